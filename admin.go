@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/admin"
+	"github.com/mazrean/gocica/internal/config"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/log"
+)
+
+// adminTokenFile is the file in the cache directory keeping the token of the admin endpoints when --admin-token is not given.
+const adminTokenFile = "admin.token"
+
+// AdminFlag is the configuration of the admin endpoints of the long-running modes
+type AdminFlag struct {
+	Addr  string `kong:"optional,help='Address on the loopback interface to serve /debug/pprof, /debug/config and /debug/metrics on, e.g. 127.0.0.1:6060.',env='GOCICA_ADMIN_ADDR'"`
+	Token string `kong:"optional,help='Bearer token of the admin endpoints. Defaults to one generated into admin.token in the cache directory.',env='GOCICA_ADMIN_TOKEN',secret"`
+}
+
+// selectedAdmin returns the admin flags of the command of ctx, or nil when it has none.
+func selectedAdmin(ctx *kong.Context) *AdminFlag {
+	switch ctx.Command() {
+	case "daemon":
+		return &CLI.Daemon.Admin
+	case "serve":
+		return &CLI.Serve.Admin
+	default:
+		return nil
+	}
+}
+
+// serve serves the admin endpoints until the returned function is called.
+// It returns a no-op when no address is given.
+func (a *AdminFlag) serve(logger log.Logger, effective *config.Effective) (func(), error) {
+	if a.Addr == "" {
+		return func() {}, nil
+	}
+
+	token := a.Token
+	if token == "" {
+		path := filepath.Join(CLI.Dir, adminTokenFile)
+		var err error
+		token, err = admin.LoadToken(path)
+		if err != nil {
+			return nil, fmt.Errorf("load admin token: %w", err)
+		}
+		logger.Infof("the token of the admin endpoints is in %s", path)
+	}
+
+	return listenAndServe(logger, "admin endpoints", a.Addr, admin.Handler(token, effective, report.Handler()))
+}
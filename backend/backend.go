@@ -0,0 +1,206 @@
+// Package backend is the API for the backends of gocica implemented outside of this repository,
+// e.g. on the object store of an organization.
+//
+// A backend registers itself in an init function of its package with RegisterRemote or RegisterLocal,
+// and is linked into gocica by a blank import in a file added to the main package:
+//
+//	import _ "example.com/gocica-objstore"
+//
+// It is then selected with --backend (remote) or --local-backend (local) by its name,
+// and configured with --backend-option key=value.
+//
+// A remote backend only stores the blob of the remote cache: gocica writes it as blocks committed in order,
+// and reads it by ranges. A storage with no blocks of its own, e.g. a plain object store, is adapted with NewPacker.
+// See the example directory for a complete backend.
+//
+// The interfaces of this package are kept compatible within a major version of gocica.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+)
+
+// LocalBackend stores the outputs of the go command on the local disk, where the go command reads them.
+type LocalBackend interface {
+	// Get returns the path of the output on the disk, or "" when it is not stored.
+	Get(ctx context.Context, outputID string) (diskPath string, err error)
+	// Put returns the path of the output and the writer of its content.
+	// The output is stored once the writer is closed.
+	Put(ctx context.Context, outputID string, size int64) (diskPath string, w io.WriteCloser, err error)
+	Close(ctx context.Context) error
+}
+
+// DownloadClient reads the blob of the remote cache committed before the run.
+type DownloadClient interface {
+	// GetURL returns the location of the blob, which the upload client of the same backend copies ranges from.
+	GetURL(ctx context.Context) string
+	// DownloadBlock writes size bytes of the blob from offset to w.
+	DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error
+	// DownloadBlockBuffer reads size bytes of the blob from offset into buf[:size].
+	DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error
+}
+
+// UploadClient writes the next blob of the remote cache.
+type UploadClient interface {
+	// UploadBlock stages the content of r as the block, and returns its size.
+	UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error)
+	// UploadBlockFromURL stages a range of the blob at url, returned by GetURL, as the block.
+	UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error
+	// Commit makes the blocks, in the order given, the blob read by the later runs.
+	// size is the sum of the sizes of the blocks.
+	Commit(ctx context.Context, blockIDs []string, size int64) error
+}
+
+// ExpiringUploadClient is implemented by the UploadClient of a backend with object lifecycle, e.g. the expiry of an object.
+// With --expiry.lifecycle, SetExpiry is called before Commit with the time every entry of the blob has expired,
+// given by the toolchain as TTLs, so that the backend can let the blob expire then.
+type ExpiringUploadClient interface {
+	SetExpiry(expiresAt time.Time)
+}
+
+// StagedBlockClient is implemented by the UploadClient of a storage listing the blocks staged and not committed yet, e.g. Azure Blob Storage.
+// The blocks are checked against it before Commit, so that a block lost or truncated by the storage does not leave a hole in the blob.
+// A wrapper of an UploadClient returns an error matching errors.ErrUnsupported when the client it wraps cannot list them.
+type StagedBlockClient interface {
+	// StagedBlocks returns the sizes of the blocks staged for the next Commit by their IDs.
+	StagedBlocks(ctx context.Context) (map[string]int64, error)
+}
+
+// Capabilities are the features of the storage of a backend, which gocica picks the algorithms of its transfers by.
+type Capabilities struct {
+	// RangedReads is whether a range of the blob is read without reading it from the start.
+	// Without it, the outputs are only restored with the whole blob, never on demand.
+	RangedReads bool
+	// ServerSideCopy is whether UploadBlockFromURL copies the range in the storage, without the bytes going through the runner.
+	// Without it, the copies of the blob read count as transfers of the runner.
+	ServerSideCopy bool
+	// ConditionalPut is whether Commit fails instead of replacing a blob committed by another run since the blob was read.
+	ConditionalPut bool
+	// Multipart is whether the blocks are staged in the storage and committed as a list, within the 50000 blocks of Azure Blob Storage.
+	// Without it, the blob is sent as a whole at Commit, with no limit on the number of blocks.
+	Multipart bool
+}
+
+// DefaultCapabilities are the capabilities of Azure Blob Storage, assumed of the clients not implementing CapableClient.
+var DefaultCapabilities = Capabilities{
+	RangedReads:    true,
+	ServerSideCopy: true,
+	Multipart:      true,
+}
+
+// CapableClient is implemented by the DownloadClient and the UploadClient of a storage whose capabilities differ from DefaultCapabilities,
+// e.g. a plain object store adapted with NewPacker.
+type CapableClient interface {
+	Capabilities() Capabilities
+}
+
+// RemoteBackend opens the clients of the remote cache for a run.
+type RemoteBackend interface {
+	// DownloadClient returns the client of the blob, or nil when nothing is committed yet.
+	DownloadClient(ctx context.Context) (DownloadClient, error)
+	// UploadClient returns the client of the next blob, or nil when the run can only read.
+	UploadClient(ctx context.Context) (UploadClient, error)
+}
+
+// Config is given to the factory of the backend selected.
+type Config struct {
+	Logger log.Logger
+	// Dir is the cache directory of gocica.
+	Dir string
+	// Options are the values of --backend-option.
+	Options map[string]string
+}
+
+// Option returns the value of the option, or def when it is not given.
+func (c Config) Option(key, def string) string {
+	if v, ok := c.Options[key]; ok {
+		return v
+	}
+
+	return def
+}
+
+// RemoteFactory creates the remote backend with the configuration.
+type RemoteFactory func(ctx context.Context, config Config) (RemoteBackend, error)
+
+// LocalFactory creates the local backend with the configuration.
+type LocalFactory func(ctx context.Context, config Config) (LocalBackend, error)
+
+// reserved are the names of the backends built into gocica.
+var reserved = []string{"auto", "github", "http", "exec", "signed", "none", "disk", "pack"}
+
+var (
+	registryLocker sync.RWMutex
+	remotes        = map[string]RemoteFactory{}
+	locals         = map[string]LocalFactory{}
+)
+
+func checkName(name string, registered bool) {
+	if name == "" || slices.Contains(reserved, name) {
+		panic(fmt.Sprintf("backend: the name %q is reserved", name))
+	}
+	if registered {
+		panic(fmt.Sprintf("backend: %q is registered twice", name))
+	}
+}
+
+// RegisterRemote makes the remote backend available by the name.
+// It panics when the name is taken, like database/sql.Register.
+func RegisterRemote(name string, factory RemoteFactory) {
+	registryLocker.Lock()
+	defer registryLocker.Unlock()
+
+	_, ok := remotes[name]
+	checkName(name, ok)
+	remotes[name] = factory
+}
+
+// RegisterLocal makes the local backend available by the name.
+// It panics when the name is taken, like database/sql.Register.
+func RegisterLocal(name string, factory LocalFactory) {
+	registryLocker.Lock()
+	defer registryLocker.Unlock()
+
+	_, ok := locals[name]
+	checkName(name, ok)
+	locals[name] = factory
+}
+
+// LookupRemote returns the factory of the remote backend registered by the name.
+func LookupRemote(name string) (RemoteFactory, bool) {
+	registryLocker.RLock()
+	defer registryLocker.RUnlock()
+
+	factory, ok := remotes[name]
+	return factory, ok
+}
+
+// LookupLocal returns the factory of the local backend registered by the name.
+func LookupLocal(name string) (LocalFactory, bool) {
+	registryLocker.RLock()
+	defer registryLocker.RUnlock()
+
+	factory, ok := locals[name]
+	return factory, ok
+}
+
+// RemoteNames returns the names of the remote backends registered, sorted.
+func RemoteNames() []string {
+	registryLocker.RLock()
+	defer registryLocker.RUnlock()
+
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
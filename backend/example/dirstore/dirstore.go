@@ -0,0 +1,127 @@
+// Package dirstore is an example of a remote backend implemented outside of gocica.
+// It keeps the remote cache in a directory, e.g. a network file system shared by the CI runners,
+// as an ObjectStore adapted with backend.NewPacker.
+//
+// It is linked into gocica by a blank import in the main package, and selected with:
+//
+//	gocica --backend dir --backend-option path=/mnt/cache --backend-option prefix=myrepo
+package dirstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/mazrean/gocica/backend"
+)
+
+func init() {
+	backend.RegisterRemote("dir", New)
+}
+
+// New creates the backend from the options path, the directory of the store, and prefix, the namespace in it.
+func New(_ context.Context, config backend.Config) (backend.RemoteBackend, error) {
+	dir := config.Option("path", "")
+	if dir == "" {
+		return nil, errors.New("dir backend: the path option is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("dir backend: create directory: %w", err)
+	}
+
+	return backend.NewPacker(Store(dir), config.Option("prefix", "gocica")), nil
+}
+
+// Store is an ObjectStore of the files in a directory.
+type Store string
+
+var _ backend.ObjectStore = Store("")
+
+func (s Store) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+
+	return filepath.Join(string(s), filepath.FromSlash(key)), nil
+}
+
+func (s Store) ReadRange(_ context.Context, key string, offset, size int64, w io.Writer) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = io.NewSectionReader(f, offset, size)
+	if size < 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seek: %w", err)
+		}
+		r = f
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("copy: %w", io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
+// Write writes the object to a temporary file and renames it, so that a reader never sees a part of it.
+func (s Store) Write(_ context.Context, key string, r io.Reader, size int64) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("write: got %d bytes, want %d", n, size)
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+func (s Store) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove: %w", err)
+	}
+
+	return nil
+}
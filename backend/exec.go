@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+)
+
+// ExecProtocolVersion is the version of the protocol of the exec plugins.
+const ExecProtocolVersion = 1
+
+// Operations of the exec protocol.
+//
+// A plugin is a program started by gocica with --remote=exec:<command>, and speaks the protocol on its stdin and stdout.
+// Its stderr is written to the log of gocica. Every request is a line of JSON (ExecRequest),
+// answered with a line of JSON (ExecResponse), one at a time in the order sent.
+// The content of a block follows its line as the raw bytes of the size given:
+// after an upload_block request, and after the response of a download without an error.
+// The plugin exits when its stdin is closed.
+//
+//   - init: the first request, with the version and the values of --backend-option.
+//     read_only answers whether the run can only read.
+//   - find_blob: the blob committed latest. found answers whether one is, and url its location,
+//     which the later requests of the run name.
+//   - download: size bytes of the blob at url from offset.
+//   - upload_block: stage the bytes as the block block_id.
+//   - upload_block_from_url: stage size bytes of the blob at url from offset as the block block_id.
+//   - commit: make the blocks of block_ids, in order, the blob found by the later runs. size is their total.
+//     expires_at, when given, is the time in Unix nanoseconds every entry of the blob has expired (see ExpiringUploadClient).
+const (
+	ExecOpInit               = "init"
+	ExecOpFindBlob           = "find_blob"
+	ExecOpDownload           = "download"
+	ExecOpUploadBlock        = "upload_block"
+	ExecOpUploadBlockFromURL = "upload_block_from_url"
+	ExecOpCommit             = "commit"
+)
+
+// ExecRequest is a request of the exec protocol.
+type ExecRequest struct {
+	Op       string            `json:"op"`
+	Version  int               `json:"version,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	BlockID  string            `json:"block_id,omitempty"`
+	BlockIDs []string          `json:"block_ids,omitempty"`
+	Offset   int64             `json:"offset,omitempty"`
+	Size     int64             `json:"size,omitempty"`
+	// ExpiresAt is in Unix nanoseconds.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ExecResponse is a response of the exec protocol. Error is set when the request failed.
+type ExecResponse struct {
+	Error    string `json:"error,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Found    bool   `json:"found,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// ServeExec serves the remote backend created by factory as an exec plugin,
+// so that a backend of this package runs in a process of its own, e.g. with other dependencies than gocica.
+// It returns when r is closed.
+//
+//	func main() {
+//		if err := backend.ServeExec(context.Background(), os.Stdin, os.Stdout, dirstore.New); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func ServeExec(ctx context.Context, r io.Reader, w io.Writer, factory RemoteFactory) error {
+	s := &execServer{
+		factory: factory,
+		r:       bufio.NewReader(r),
+		w:       bufio.NewWriter(w),
+	}
+
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read request: %w", err)
+		}
+
+		var req ExecRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			return fmt.Errorf("decode request: %w", err)
+		}
+
+		res, body, err := s.handle(ctx, &req)
+		if err != nil {
+			res, body = &ExecResponse{Error: err.Error()}, nil
+		}
+		if err := s.respond(res, body); err != nil {
+			return err
+		}
+	}
+}
+
+type execServer struct {
+	factory RemoteFactory
+	r       *bufio.Reader
+	w       *bufio.Writer
+
+	remote         RemoteBackend
+	downloadClient DownloadClient
+	uploadClient   UploadClient
+}
+
+func (s *execServer) respond(res *ExecResponse, body []byte) error {
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+	buf = append(buf, '\n')
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("write response: %w", err)
+	}
+	if _, err := s.w.Write(body); err != nil {
+		return fmt.Errorf("write body: %w", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
+
+// handle runs the request. The body of an upload is read even when it fails, so that the next request is read from its line.
+func (s *execServer) handle(ctx context.Context, req *ExecRequest) (*ExecResponse, []byte, error) {
+	var body []byte
+	if req.Op == ExecOpUploadBlock {
+		body = make([]byte, req.Size)
+		if _, err := io.ReadFull(s.r, body); err != nil {
+			return nil, nil, fmt.Errorf("read block: %w", err)
+		}
+	}
+
+	if req.Op != ExecOpInit && s.remote == nil {
+		return nil, nil, errors.New("not initialized")
+	}
+
+	switch req.Op {
+	case ExecOpInit:
+		if req.Version != ExecProtocolVersion {
+			return nil, nil, fmt.Errorf("unsupported protocol version: %d", req.Version)
+		}
+
+		remote, err := s.factory(ctx, Config{
+			Logger:  log.DefaultLogger,
+			Options: req.Options,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		uploadClient, err := remote.UploadClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.remote, s.uploadClient = remote, uploadClient
+
+		return &ExecResponse{ReadOnly: uploadClient == nil}, nil, nil
+	case ExecOpFindBlob:
+		downloadClient, err := s.remote.DownloadClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.downloadClient = downloadClient
+		if downloadClient == nil {
+			return &ExecResponse{}, nil, nil
+		}
+
+		return &ExecResponse{Found: true, URL: downloadClient.GetURL(ctx)}, nil, nil
+	case ExecOpDownload:
+		if s.downloadClient == nil || s.downloadClient.GetURL(ctx) != req.URL {
+			return nil, nil, fmt.Errorf("blob %s is not found", req.URL)
+		}
+
+		buf := make([]byte, req.Size)
+		if err := s.downloadClient.DownloadBlockBuffer(ctx, req.Offset, req.Size, buf); err != nil {
+			return nil, nil, err
+		}
+
+		return &ExecResponse{Size: req.Size}, buf, nil
+	case ExecOpUploadBlock, ExecOpUploadBlockFromURL, ExecOpCommit:
+		if s.uploadClient == nil {
+			return nil, nil, errors.New("read only")
+		}
+
+		var err error
+		switch req.Op {
+		case ExecOpUploadBlock:
+			_, err = s.uploadClient.UploadBlock(ctx, req.BlockID, nopSeekCloser{bytes.NewReader(body)})
+		case ExecOpUploadBlockFromURL:
+			err = s.uploadClient.UploadBlockFromURL(ctx, req.BlockID, req.URL, req.Offset, req.Size)
+		default:
+			if expiringClient, ok := s.uploadClient.(ExpiringUploadClient); ok && req.ExpiresAt != 0 {
+				expiringClient.SetExpiry(time.Unix(0, req.ExpiresAt))
+			}
+			err = s.uploadClient.Commit(ctx, req.BlockIDs, req.Size)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &ExecResponse{}, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown operation: %s", req.Op)
+	}
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,288 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectStore is a storage of whole objects read by ranges, e.g. the object store of an organization.
+type ObjectStore interface {
+	// ReadRange writes size bytes of the object from offset to w, or the rest of it when size is negative.
+	// It returns an error wrapping fs.ErrNotExist when the object does not exist.
+	ReadRange(ctx context.Context, key string, offset, size int64, w io.Writer) error
+	// Write stores the size bytes of r as the object, replacing the object of the key.
+	Write(ctx context.Context, key string, r io.Reader, size int64) error
+	// Delete removes the object. Removing an object that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// keptVersions is the committed versions of the blob kept in the store.
+// The older versions stay for the runs still downloading from them while others commit.
+const keptVersions = 4
+
+// Packer is the remote backend keeping the blob in an ObjectStore under a prefix.
+// The blocks of a run are staged as objects, and packed into a new version of the blob at the commit.
+// A block copied from the blob read is not staged, but read from it again at the commit.
+//
+// The objects are:
+//   - <prefix>/versions: the JSON list of the versions of the blob, latest first.
+//   - <prefix>/blobs/<version>: a version of the blob.
+//   - <prefix>/blocks/<run>/<block>: the blocks staged by a run, removed at its commit.
+//
+// When runs commit at the same time, the versions list of the last one wins, and the versions of the others are left in the store.
+// So are the blocks of the runs never committed. A lifecycle rule of the store removing the objects older than a few days cleans them up.
+type Packer struct {
+	store  ObjectStore
+	prefix string
+}
+
+var _ RemoteBackend = (*Packer)(nil)
+
+// NewPacker creates the remote backend storing the blob in store under prefix.
+func NewPacker(store ObjectStore, prefix string) *Packer {
+	return &Packer{
+		store:  store,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+type versionList struct {
+	Versions []string `json:"versions"`
+}
+
+func (p *Packer) versionsKey() string {
+	return p.prefix + "/versions"
+}
+
+func (p *Packer) versions(ctx context.Context) ([]string, error) {
+	buf := &bytes.Buffer{}
+	if err := p.store.ReadRange(ctx, p.versionsKey(), 0, -1, buf); err != nil {
+		return nil, fmt.Errorf("read versions: %w", err)
+	}
+
+	var list versionList
+	if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+		return nil, fmt.Errorf("decode versions: %w", err)
+	}
+
+	return list.Versions, nil
+}
+
+// DownloadClient returns the client of the latest version of the blob, or nil when nothing is committed yet.
+// The version is fixed for the run, so that later commits of others do not change what it reads.
+func (p *Packer) DownloadClient(ctx context.Context) (DownloadClient, error) {
+	versions, err := p.versions(ctx)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(versions) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &packedDownloadClient{
+		store: p.store,
+		key:   versions[0],
+	}, nil
+}
+
+// UploadClient returns the client staging the blocks of a run.
+func (p *Packer) UploadClient(context.Context) (UploadClient, error) {
+	return &packedUploadClient{
+		packer: p,
+		run:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		blocks: map[string]segment{},
+	}, nil
+}
+
+// packerCapabilities are the capabilities of an ObjectStore packed by the runner:
+// the copies of the blob read and the blocks staged go through the runner at the commit, which takes any number of blocks.
+var packerCapabilities = Capabilities{RangedReads: true}
+
+var (
+	_ CapableClient = (*packedDownloadClient)(nil)
+	_ CapableClient = (*packedUploadClient)(nil)
+)
+
+type packedDownloadClient struct {
+	store ObjectStore
+	key   string
+}
+
+func (c *packedDownloadClient) GetURL(context.Context) string {
+	return c.key
+}
+
+func (c *packedDownloadClient) Capabilities() Capabilities {
+	return packerCapabilities
+}
+
+func (c *packedDownloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	if err := c.store.ReadRange(ctx, c.key, offset, size, w); err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+
+	return nil
+}
+
+// sliceWriter fills a buffer, failing when more is written than it holds.
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	if len(p) > len(w.buf)-w.n {
+		return 0, io.ErrShortBuffer
+	}
+	w.n += copy(w.buf[w.n:], p)
+
+	return len(p), nil
+}
+
+func (c *packedDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	w := &sliceWriter{buf: buf[:size]}
+	if err := c.store.ReadRange(ctx, c.key, offset, size, w); err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+	if int64(w.n) != size {
+		return fmt.Errorf("read blob: %w", io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
+// segment is a range of an object making a block.
+type segment struct {
+	key    string
+	offset int64
+	size   int64
+	staged bool
+}
+
+type packedUploadClient struct {
+	packer *Packer
+	run    string
+
+	locker sync.Mutex
+	blocks map[string]segment
+}
+
+func (c *packedUploadClient) blockKey(blockID string) string {
+	// Block IDs may be base64 with slashes, so they are not used as the keys as they are.
+	return fmt.Sprintf("%s/blocks/%s/%s", c.packer.prefix, c.run, hex.EncodeToString([]byte(blockID)))
+}
+
+func (c *packedUploadClient) addBlock(blockID string, seg segment) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.blocks[blockID] = seg
+}
+
+func (c *packedUploadClient) Capabilities() Capabilities {
+	return packerCapabilities
+}
+
+func (c *packedUploadClient) UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	defer r.Close()
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("get size: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek start: %w", err)
+	}
+
+	key := c.blockKey(blockID)
+	if err := c.packer.store.Write(ctx, key, r, size); err != nil {
+		return 0, fmt.Errorf("stage block: %w", err)
+	}
+	c.addBlock(blockID, segment{key: key, size: size, staged: true})
+
+	return size, nil
+}
+
+func (c *packedUploadClient) UploadBlockFromURL(_ context.Context, blockID string, url string, offset, size int64) error {
+	if !strings.HasPrefix(url, c.packer.prefix+"/blobs/") {
+		return fmt.Errorf("%s is not a blob of %s", url, c.packer.prefix)
+	}
+	c.addBlock(blockID, segment{key: url, offset: offset, size: size})
+
+	return nil
+}
+
+// Commit writes the blocks to a new version of the blob and makes it the latest.
+// The staged blocks and the versions beyond the kept ones are removed on a best-effort basis.
+func (c *packedUploadClient) Commit(ctx context.Context, blockIDs []string, size int64) error {
+	c.locker.Lock()
+	segments := make([]segment, 0, len(blockIDs))
+	for _, blockID := range blockIDs {
+		seg, ok := c.blocks[blockID]
+		if !ok {
+			c.locker.Unlock()
+			return fmt.Errorf("block %s is not staged", blockID)
+		}
+		segments = append(segments, seg)
+	}
+	c.locker.Unlock()
+
+	store := c.packer.store
+	version := fmt.Sprintf("%s/blobs/%020d", c.packer.prefix, time.Now().UnixNano())
+	pr, pw := io.Pipe()
+	go func() {
+		for _, seg := range segments {
+			if err := store.ReadRange(ctx, seg.key, seg.offset, seg.size, pw); err != nil {
+				pw.CloseWithError(fmt.Errorf("read block %s: %w", seg.key, err))
+				return
+			}
+		}
+		pw.Close()
+	}()
+	err := store.Write(ctx, version, pr, size)
+	// Stops the reads of the blocks when the write failed before the end.
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+
+	versions, err := c.packer.versions(ctx)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	versions = append([]string{version}, versions...)
+	var stale []string
+	if len(versions) > keptVersions {
+		versions, stale = versions[:keptVersions], versions[keptVersions:]
+	}
+
+	list, err := json.Marshal(versionList{Versions: versions})
+	if err != nil {
+		return fmt.Errorf("encode versions: %w", err)
+	}
+	if err := store.Write(ctx, c.packer.versionsKey(), bytes.NewReader(list), int64(len(list))); err != nil {
+		return fmt.Errorf("write versions: %w", err)
+	}
+
+	for _, seg := range segments {
+		if seg.staged {
+			stale = append(stale, seg.key)
+		}
+	}
+	for _, key := range stale {
+		// The commit is done, and a lifecycle rule removes what is left.
+		_ = store.Delete(ctx, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore keeps the objects in memory.
+type memoryStore struct {
+	locker  sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStore) ReadRange(_ context.Context, key string, offset, size int64, w io.Writer) error {
+	s.locker.Lock()
+	object, ok := s.objects[key]
+	s.locker.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+
+	if size < 0 {
+		size = int64(len(object)) - offset
+	}
+	if offset+size > int64(len(object)) {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := w.Write(object[offset : offset+size])
+	return err
+}
+
+func (s *memoryStore) Write(_ context.Context, key string, r io.Reader, size int64) error {
+	object, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(object)) != size {
+		return fmt.Errorf("got %d bytes, want %d", len(object), size)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.objects[key] = object
+
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, key string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	delete(s.objects, key)
+
+	return nil
+}
+
+func (s *memoryStore) keys(prefix string) []string {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestPacker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := &memoryStore{objects: map[string][]byte{}}
+	packer := NewPacker(store, "repo/")
+
+	downloadClient, err := packer.DownloadClient(ctx)
+	if err != nil || downloadClient != nil {
+		t.Fatalf("expected no blob before the first commit, got %v, %v", downloadClient, err)
+	}
+
+	// The first run uploads every block.
+	uploadClient, err := packer.UploadClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create upload client: %v", err)
+	}
+	for id, content := range map[string]string{"a/1": "header", "b/2": "outputs"} {
+		size, err := uploadClient.UploadBlock(ctx, id, nopCloser{strings.NewReader(content)})
+		if err != nil {
+			t.Fatalf("failed to upload block: %v", err)
+		}
+		if size != int64(len(content)) {
+			t.Errorf("size: got %d, want %d", size, len(content))
+		}
+	}
+	if err := uploadClient.Commit(ctx, []string{"a/1", "b/2"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if keys := store.keys("repo/blocks/"); len(keys) != 0 {
+		t.Errorf("expected the staged blocks to be removed, got %v", keys)
+	}
+
+	downloadClient, err = packer.DownloadClient(ctx)
+	if err != nil || downloadClient == nil {
+		t.Fatalf("failed to create download client: %v, %v", downloadClient, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := downloadClient.DownloadBlock(ctx, 0, 13, buf); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if buf.String() != "headeroutputs" {
+		t.Errorf("blob: got %q", buf.String())
+	}
+
+	// The next run copies the outputs from the blob read, and commits until the oldest versions are removed.
+	for i := range keptVersions + 1 {
+		uploadClient, err := packer.UploadClient(ctx)
+		if err != nil {
+			t.Fatalf("failed to create upload client: %v", err)
+		}
+		header := fmt.Sprintf("head%02d", i)
+		if _, err := uploadClient.UploadBlock(ctx, "a/1", nopCloser{strings.NewReader(header)}); err != nil {
+			t.Fatalf("failed to upload block: %v", err)
+		}
+		if err := uploadClient.UploadBlockFromURL(ctx, "b/2", downloadClient.GetURL(ctx), 6, 7); err != nil {
+			t.Fatalf("failed to copy block: %v", err)
+		}
+		if err := uploadClient.UploadBlockFromURL(ctx, "c/3", "other/blobs/1", 0, 1); err == nil {
+			t.Error("expected an error copying from another prefix")
+		}
+		if err := uploadClient.Commit(ctx, []string{"a/1", "b/2"}, 13); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		downloadClient, err = packer.DownloadClient(ctx)
+		if err != nil {
+			t.Fatalf("failed to create download client: %v", err)
+		}
+		buf := make([]byte, 13)
+		if err := downloadClient.DownloadBlockBuffer(ctx, 0, 13, buf); err != nil {
+			t.Fatalf("failed to download: %v", err)
+		}
+		if want := header + "outputs"; string(buf) != want {
+			t.Errorf("blob: got %q, want %q", buf, want)
+		}
+	}
+
+	if blobs := store.keys("repo/blobs/"); len(blobs) != keptVersions {
+		t.Errorf("expected %d versions kept, got %v", keptVersions, blobs)
+	}
+}
@@ -0,0 +1,338 @@
+// Package blob reads the cache blobs of gocica, for debugging, external tooling and cache analytics.
+//
+// A blob is what a remote backend of gocica stores: a header indexing the outputs of the go command by their actions,
+// followed by the outputs, each compressed and encrypted on its own. Open reads one from a local file,
+// e.g. one written by the hub or downloaded from a bucket, or from an http(s) URL serving ranges of it,
+// and OpenClient from the DownloadClient of a backend.
+//
+// Only the header is read when a blob is opened, and each output is read by its range when it is extracted.
+//
+//	r, err := blob.Open(ctx, "https://hub.example.com/blob", nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//
+//	for _, output := range r.Outputs() {
+//		err := r.Extract(ctx, output.ID, io.Discard)
+//		...
+//	}
+package blob
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/storage"
+	"github.com/mazrean/gocica/log"
+)
+
+var (
+	// ErrNotFound is returned by Extract for an output not in the blob.
+	ErrNotFound = errors.New("output not found")
+	// ErrChecksum is returned by Extract for an output whose content is not the one of its ID.
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// Options are the options to open a blob with. The zero Options open an unencrypted blob.
+type Options struct {
+	// Logger logs the reads of the blob. nil logs nothing.
+	Logger log.Logger
+	// EncryptionKey is the AES-256 key the blob is encrypted with, as given to --encryption-key decoded from base64.
+	EncryptionKey []byte
+}
+
+// Entry is the entry of an action in the blob.
+type Entry struct {
+	// ActionID and OutputID are the IDs of the go command, encoded in base64.
+	ActionID string
+	OutputID string
+	// Size is the size of the output, uncompressed.
+	Size int64
+	// Time is when the output was put by the go command.
+	Time time.Time
+	// Kind is the kind of the output, e.g. compile, link or test, or "" when it is unknown.
+	Kind string
+	// Inline reports whether the output is kept in the header instead of among the outputs of the blob.
+	Inline bool
+}
+
+// Output is an output stored in the blob.
+type Output struct {
+	// ID is the output ID of the go command, encoded in base64.
+	ID string
+	// Offset is the offset of the output from the end of the header.
+	Offset int64
+	// StoredSize is the size of the output in the blob, compressed and encrypted.
+	StoredSize int64
+	// Compressed reports whether the output is compressed with zstd.
+	Compressed bool
+}
+
+// Reader reads a blob.
+type Reader struct {
+	downloader *core.Downloader
+	closer     io.Closer
+	// stored are the IDs of the outputs stored in the blob.
+	stored map[string]struct{}
+	// inline are the contents of the outputs kept in the header, by their IDs.
+	inline map[string][]byte
+}
+
+// Open opens the blob at location, an http(s) URL or the path of a local file.
+// opts may be nil.
+func Open(ctx context.Context, location string, opts *Options) (*Reader, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return OpenClient(ctx, storage.NewSignedDownloadClient(nil, location), opts)
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("open blob: %w", err)
+	}
+
+	r, err := OpenClient(ctx, &fileClient{f: f}, opts)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r.closer = f
+
+	return r, nil
+}
+
+// OpenClient opens the blob read by client, e.g. the one of a backend registered with the backend package.
+// opts may be nil.
+func OpenClient(ctx context.Context, client backend.DownloadClient, opts *Options) (*Reader, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	var cipher *crypt.Cipher
+	if opts.EncryptionKey != nil {
+		var err error
+		cipher, err = crypt.New(opts.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("create cipher: %w", err)
+		}
+	}
+
+	downloader, err := core.NewDownloader(ctx, logger, client, cipher, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	r := &Reader{
+		downloader: downloader,
+		stored:     map[string]struct{}{},
+		inline:     map[string][]byte{},
+	}
+	outputs, err := downloader.GetOutputs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get outputs: %w", err)
+	}
+	for _, output := range outputs {
+		r.stored[output.GetId()] = struct{}{}
+	}
+	entries, err := downloader.GetEntries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get entries: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.GetInlineOutput() != nil {
+			r.inline[entry.GetOutputId()] = entry.GetInlineOutput()
+		}
+	}
+
+	return r, nil
+}
+
+// Close closes the file of the blob opened from a local file.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+
+	if err := r.closer.Close(); err != nil {
+		return fmt.Errorf("close blob: %w", err)
+	}
+
+	return nil
+}
+
+// Entries returns the entries of the blob, by their action IDs.
+func (r *Reader) Entries() []Entry {
+	header, _ := r.downloader.GetEntries(context.Background())
+
+	entries := make([]Entry, 0, len(header))
+	for actionID, entry := range header {
+		entries = append(entries, Entry{
+			ActionID: actionID,
+			OutputID: entry.GetOutputId(),
+			Size:     entry.GetSize(),
+			Time:     time.Unix(0, entry.GetTimenano()),
+			Kind:     entry.GetKind(),
+			Inline:   entry.GetInlineOutput() != nil,
+		})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Compare(a.ActionID, b.ActionID)
+	})
+
+	return entries
+}
+
+// Outputs returns the outputs stored in the blob, in their order in it.
+// The outputs kept in the header are not among them.
+func (r *Reader) Outputs() []Output {
+	header, _ := r.downloader.GetOutputs(context.Background())
+
+	outputs := make([]Output, 0, len(header))
+	for _, output := range header {
+		outputs = append(outputs, Output{
+			ID:         output.GetId(),
+			Offset:     output.GetOffset(),
+			StoredSize: output.GetSize(),
+			Compressed: output.GetCompression() == v1.Compression_COMPRESSION_ZSTD,
+		})
+	}
+	slices.SortFunc(outputs, func(a, b Output) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	return outputs
+}
+
+// Extract writes the content of the output, decrypted and decompressed, to w.
+// It fails with ErrNotFound when the output is not in the blob, and with ErrChecksum when its content is not the one of its ID,
+// after the content is written.
+func (r *Reader) Extract(ctx context.Context, outputID string, w io.Writer) error {
+	hw := &hashWriter{w: w, h: sha256.New()}
+	if content, ok := r.inline[outputID]; ok {
+		if _, err := hw.Write(content); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+	} else {
+		if _, ok := r.stored[outputID]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, outputID)
+		}
+		if err := r.downloader.FetchOutput(ctx, outputID, hw); err != nil {
+			return fmt.Errorf("fetch output %s: %w", outputID, err)
+		}
+	}
+
+	if want, err := base64.StdEncoding.DecodeString(outputID); err == nil && !bytes.Equal(hw.h.Sum(nil), want) {
+		return fmt.Errorf("%w: %s", ErrChecksum, outputID)
+	}
+
+	return nil
+}
+
+// ExtractAll extracts every output of the blob, the ones kept in the header included,
+// to a file in dir named by the hex of its ID.
+func (r *Reader) ExtractAll(ctx context.Context, dir string) error {
+	ids := make([]string, 0, len(r.inline))
+	for id := range r.inline {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, output := range r.Outputs() {
+		ids = append(ids, output.ID)
+	}
+
+	for _, id := range ids {
+		if err := r.extractFile(ctx, id, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) extractFile(ctx context.Context, outputID, dir string) (err error) {
+	name := outputID
+	if raw, decodeErr := base64.StdEncoding.DecodeString(outputID); decodeErr == nil {
+		name = hex.EncodeToString(raw)
+	}
+
+	f, err := os.Create(filepath.Join(dir, filepath.Base(name)))
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close output file: %w", closeErr)
+		}
+	}()
+
+	return r.Extract(ctx, outputID, f)
+}
+
+// hashWriter hashes the content written through it.
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	w.h.Write(p)
+	return w.w.Write(p)
+}
+
+// fileClient reads the blob from a local file.
+type fileClient struct {
+	f *os.File
+}
+
+func (c *fileClient) GetURL(context.Context) string {
+	return c.f.Name()
+}
+
+func (c *fileClient) DownloadBlock(_ context.Context, offset int64, size int64, w io.Writer) error {
+	n, err := io.Copy(w, io.NewSectionReader(c.f, offset, size))
+	if err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("read blob: %w", io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
+func (c *fileClient) DownloadBlockBuffer(_ context.Context, offset int64, size int64, buf []byte) error {
+	if _, err := c.f.ReadAt(buf[:size], offset); err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+func (nopLogger) Warnf(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
@@ -0,0 +1,169 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/zstd"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func outputID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// testBlob returns a blob with an output stored compressed, one stored as is, and one kept in the header.
+func testBlob(t *testing.T) []byte {
+	t.Helper()
+
+	compressed, err := zstd.Compress(nil, []byte("compressed output"))
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	raw := []byte("raw output")
+
+	header, err := proto.Marshal(&v1.ActionsCache{
+		Entries: map[string]*v1.IndexEntry{
+			"action1": {OutputId: outputID("compressed output"), Size: 17, Timenano: time.Unix(1, 0).UnixNano(), Kind: "compile"},
+			"action2": {OutputId: outputID("raw output"), Size: 10, Kind: "link"},
+			"action3": {OutputId: outputID("inline"), Size: 6, InlineOutput: []byte("inline")},
+		},
+		Outputs: []*v1.ActionsOutput{
+			{Id: outputID("compressed output"), Offset: 0, Size: int64(len(compressed)), Compression: v1.Compression_COMPRESSION_ZSTD},
+			{Id: outputID("raw output"), Offset: int64(len(compressed)), Size: int64(len(raw))},
+		},
+		OutputTotalSize: int64(len(compressed) + len(raw)),
+	})
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+
+	blob := binary.BigEndian.AppendUint64(nil, uint64(len(header)))
+	blob = append(blob, header...)
+	blob = append(blob, compressed...)
+
+	return append(blob, raw...)
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	blob := testBlob(t)
+
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path, blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(blob))
+	}))
+	t.Cleanup(server.Close)
+
+	for name, location := range map[string]string{"file": path, "url": server.URL + "/blob"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := Open(t.Context(), location, nil)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer r.Close()
+
+			entries := r.Entries()
+			if len(entries) != 3 || entries[0].ActionID != "action1" || entries[0].Kind != "compile" || !entries[0].Time.Equal(time.Unix(1, 0)) || !entries[2].Inline {
+				t.Errorf("Entries() = %+v", entries)
+			}
+			outputs := r.Outputs()
+			if len(outputs) != 2 || !outputs[0].Compressed || outputs[1].Compressed || outputs[1].StoredSize != 10 {
+				t.Errorf("Outputs() = %+v", outputs)
+			}
+
+			for _, content := range []string{"compressed output", "raw output", "inline"} {
+				buf := &bytes.Buffer{}
+				if err := r.Extract(t.Context(), outputID(content), buf); err != nil {
+					t.Errorf("Extract(%q) error = %v", content, err)
+				}
+				if buf.String() != content {
+					t.Errorf("Extract(%q) = %q", content, buf.String())
+				}
+			}
+
+			if err := r.Extract(t.Context(), outputID("missing"), io.Discard); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Extract(missing) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestReader_ExtractAll(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path, testBlob(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(t.Context(), path, nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+
+	dir := t.TempDir()
+	if err := r.ExtractAll(t.Context(), dir); err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+
+	for _, content := range []string{"compressed output", "raw output", "inline"} {
+		sum := sha256.Sum256([]byte(content))
+		got, err := os.ReadFile(filepath.Join(dir, hex.EncodeToString(sum[:])))
+		if err != nil {
+			t.Errorf("read %q: %v", content, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("extracted %q, want %q", got, content)
+		}
+	}
+}
+
+func TestReader_Extract_corrupted(t *testing.T) {
+	t.Parallel()
+
+	blob := testBlob(t)
+	// Alter the last byte of the raw output.
+	blob[len(blob)-1] = 'T'
+
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path, blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(t.Context(), path, nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+
+	buf := &bytes.Buffer{}
+	if err := r.Extract(t.Context(), outputID("raw output"), buf); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Extract() error = %v, want ErrChecksum", err)
+	}
+	if !strings.HasPrefix(buf.String(), "raw outpu") {
+		t.Errorf("Extract() wrote %q", buf.String())
+	}
+}
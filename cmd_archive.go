@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mazrean/gocica/internal/archive"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// ExportCmd writes the current cache entry to a local archive
+type ExportCmd struct {
+	Output string `kong:"arg,type='path',help='Archive file to write (tar compressed with zstd).'"`
+}
+
+func (c *ExportCmd) Run(logger log.Logger) (err error) {
+	ctx := lifecycle.Context()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	downloadClientProvider, _, err := provider.GHACacheProvider(ctx, logger, CLI.Github.config())
+	if err != nil {
+		return fmt.Errorf("create cache client: %w", err)
+	}
+
+	downloadClient, err := downloadClientProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("look up cache entry: %w", err)
+	}
+	if downloadClient == nil {
+		return errors.New("no cache entry found")
+	}
+
+	var key string
+	if keyed, ok := downloadClient.(interface{ Key() string }); ok {
+		key = keyed.Key()
+	}
+
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close archive: %w", closeErr)
+		}
+	}()
+
+	metadata, err := archive.Export(ctx, logger, downloadClient, cipher, key, f)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	logger.Infof("exported %s (%d bytes) to %s", metadata.Key, metadata.Size, c.Output)
+
+	return nil
+}
+
+// ImportCmd uploads an archive written by export as a new cache entry
+type ImportCmd struct {
+	Input string `kong:"arg,type='existingfile',help='Archive file written by the export command.'"`
+}
+
+func (c *ImportCmd) Run(logger log.Logger) error {
+	ctx := lifecycle.Context()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	_, uploadClientProvider, err := provider.GHACacheProvider(ctx, logger, CLI.Github.config())
+	if err != nil {
+		return fmt.Errorf("create cache client: %w", err)
+	}
+
+	uploadClient, err := uploadClientProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("create cache entry: %w", err)
+	}
+	if uploadClient == nil {
+		return errors.New("a cache entry already exists for this key. change the sha or ref to import")
+	}
+
+	f, err := os.Open(c.Input)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer f.Close()
+
+	metadata, err := archive.Import(ctx, f, uploadClient, cipher)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	logger.Infof("imported %s (%d bytes, exported from %q at %s)", c.Input, metadata.Size, metadata.Key, metadata.ExportedAt)
+
+	return nil
+}
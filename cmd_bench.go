@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/mazrean/gocica/internal/bench"
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/internal/kessoku"
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// benchShaPrefix marks the keys committed by the bench command, so that the benchmark never overwrites the cache of a build.
+const benchShaPrefix = "bench-"
+
+// BenchCmd measures gocica with a synthetic workload against the configured backend
+type BenchCmd struct {
+	Actions      int    `kong:"default='1000',help='Number of actions of the workload.'"`
+	MinSize      int64  `kong:"default='0',help='Smallest output size in bytes.'"`
+	MaxSize      int64  `kong:"default='4194304',help='Largest output size in bytes.'"`
+	Distribution string `kong:"default='log-uniform',enum='log-uniform,uniform,fixed',help='Distribution of the output sizes. fixed makes every output --max-size bytes.'"`
+	Seed         uint64 `kong:"default='0',help='Seed of the workload. The same seed generates the same actions and outputs. 0 picks one and logs it.'"`
+	Concurrency  int    `kong:"default='0',help='Requests in flight, like the parallel actions of the go command. 0 is GOMAXPROCS.'"`
+	ReuseDir     bool   `kong:"help='Run the warm round on the local cache directory of the cold round, so that the outputs restored from the remote cache are already on the local disk.'"`
+	Output       string `kong:"optional,help='File to write the results to as JSON',type='path'"`
+}
+
+func (c *BenchCmd) Run(logger log.Logger) error {
+	ctx, cancel := context.WithCancel(lifecycle.Context())
+	defer cancel()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	signer, err := CLI.Signing.signer()
+	if err != nil {
+		return err
+	}
+
+	seed := c.Seed
+	if seed == 0 {
+		//nolint:gosec
+		seed = uint64(time.Now().UnixNano())
+	}
+	workload := &bench.Workload{
+		Actions:      c.Actions,
+		MinSize:      c.MinSize,
+		MaxSize:      c.MaxSize,
+		Distribution: bench.Distribution(c.Distribution),
+		Seed:         seed,
+	}
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	baseDir, err := os.MkdirTemp(CLI.Dir, "bench-*")
+	if err != nil {
+		return fmt.Errorf("create bench directory: %w", err)
+	}
+	defer os.RemoveAll(baseDir)
+	membuf.SetLimit(CLI.MaxMemory<<20, baseDir)
+
+	// The key is unique to the benchmark, so that the warm round restores what the cold round committed.
+	githubConfig := CLI.Github.config()
+	githubConfig.Sha = benchShaPrefix + time.Now().UTC().Format("20060102T150405Z")
+
+	logger.Infof("benchmarking: backend=%s, actions=%d, sizes=%d-%d (%s), seed=%d, concurrency=%d",
+		CLI.Backend, c.Actions, c.MinSize, c.MaxSize, c.Distribution, seed, concurrency)
+
+	rounds := []bench.Round{
+		{Name: "cold", Put: true, Concurrency: concurrency},
+		{Name: "warm", Put: false, Concurrency: concurrency},
+	}
+	results := make([]*bench.RoundResult, 0, len(rounds))
+	dir := ""
+	for _, round := range rounds {
+		if dir == "" || !c.ReuseDir {
+			dir, err = os.MkdirTemp(baseDir, round.Name+"-*")
+			if err != nil {
+				return fmt.Errorf("create %s directory: %w", round.Name, err)
+			}
+		}
+
+		result, err := bench.Run(ctx, logger, workload, round, func(w io.Writer, r io.Reader) error {
+			process, err := kessoku.InitializeProcess(
+				ctx,
+				logger,
+				local.DiskDir(dir),
+				provider.BackendKind(CLI.Backend),
+				githubConfig,
+				hubConfig(),
+				cipher,
+				signer,
+				cacheprog.CommitTimeout(CLI.CommitTimeout),
+			)
+			if err != nil {
+				return fmt.Errorf("initialize process: %w", err)
+			}
+
+			return process.Serve(w, r)
+		})
+		if err != nil {
+			return fmt.Errorf("%s round: %w", round.Name, err)
+		}
+		result.Log(logger)
+		results = append(results, result)
+	}
+
+	if c.Output != "" {
+		if err := bench.WriteFile(c.Output, results); err != nil {
+			return fmt.Errorf("write results: %w", err)
+		}
+	}
+
+	return nil
+}
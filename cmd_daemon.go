@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/internal/daemon"
+	"github.com/mazrean/gocica/internal/pkg/audit"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+const (
+	// stopPollInterval is the interval at which daemon --stop checks whether the daemon has exited.
+	stopPollInterval = 100 * time.Millisecond
+	// graceMargin is left of the grace period for the exit after the commit, e.g. the report and the flush of the log.
+	graceMargin = 5 * time.Second
+)
+
+// socketPath returns the socket of the daemon, which defaults to gocica.sock in the cache directory.
+func socketPath(socket string) string {
+	if socket != "" {
+		return socket
+	}
+
+	return filepath.Join(CLI.Dir, "gocica.sock")
+}
+
+// DaemonCmd serves the go commands of many invocations connecting through gocica client
+type DaemonCmd struct {
+	Socket      string        `kong:"optional,help='Unix socket to listen on. Defaults to gocica.sock in the cache directory.',type='path',env='GOCICA_SOCKET'"`
+	IdleTimeout time.Duration `kong:"default='0',help='Time without connected go commands after which the daemon commits the remote cache and exits. 0 runs until it is stopped.',env='GOCICA_DAEMON_IDLE_TIMEOUT'"`
+	Stop        bool          `kong:"help='Stop the daemon listening on the socket and wait for it to commit the remote cache.'"`
+	HealthAddr  string        `kong:"optional,help='Address to serve /healthz and /readyz on, for the probes of a sidecar container.',env='GOCICA_HEALTH_ADDR'"`
+	GracePeriod time.Duration `kong:"default='0',help='Termination grace period of the pod running the daemon. The commit after SIGTERM is bounded to finish within it. 0 leaves it to --commit-timeout.',env='GOCICA_GRACE_PERIOD'"`
+	Admin       AdminFlag     `kong:"embed,prefix='admin-'"`
+}
+
+func (c *DaemonCmd) Run(logger log.Logger, kctx *kong.Context) error {
+	path := socketPath(c.Socket)
+	pidPath := path + ".pid"
+
+	if c.Stop {
+		return stopDaemon(logger, pidPath)
+	}
+
+	// The backend outlives the signal, so that the commit after it is not canceled.
+	ctx, cancel := context.WithCancel(lifecycle.Context())
+	defer cancel()
+
+	membuf.SetLimit(CLI.MaxMemory<<20, CLI.Dir)
+	if CLI.Audit.Manifest != "" {
+		audit.Enable()
+	}
+
+	if c.GracePeriod > 0 {
+		// The pod is killed at the end of the grace period, which would lose the whole commit.
+		limit := max(c.GracePeriod-graceMargin, c.GracePeriod/2)
+		if CLI.CommitTimeout == 0 || CLI.CommitTimeout > limit {
+			logger.Debugf("commit timeout is bounded to %s by the grace period", limit)
+			CLI.CommitTimeout = limit
+		}
+	}
+
+	effective := watchConfig(ctx, logger, kctx)
+
+	health := &daemon.Health{}
+	if c.HealthAddr != "" {
+		stopHealth, err := listenAndServe(logger, "health probes", c.HealthAddr, health.Handler())
+		if err != nil {
+			return err
+		}
+		defer stopHealth()
+	}
+
+	stopAdmin, err := c.Admin.serve(logger, effective)
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+
+	backend, err := initializeBackend(ctx, logger)
+	if err != nil {
+		class := provider.ClassifyFailure(err)
+		return &exitCodeError{
+			code: initFailureExitCode(class),
+			err:  fmt.Errorf("failed to initialize backend (%s failure): %w", class, err),
+		}
+	}
+	cacheProg := cacheprog.NewCacheProg(logger, backend)
+
+	l, err := daemon.Listen(path)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		_ = l.Close()
+		return fmt.Errorf("write pid file: %w", err)
+	}
+	// The pid file is removed last, so that daemon --stop returns once the remote cache is committed.
+	defer os.Remove(pidPath)
+
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Infof("daemon listening on %s", path)
+	health.SetReady(true)
+	serveErr := daemon.NewServer(logger, cacheProg, c.IdleTimeout).Serve(sigCtx, l)
+	health.SetReady(false)
+
+	logger.Infof("daemon stopping. committing the remote cache")
+	closeErr := cacheProg.Close(ctx)
+
+	reportRun(logger)
+
+	if err := errors.Join(serveErr, closeErr); err != nil {
+		return fmt.Errorf("failed to run daemon: %w", err)
+	}
+
+	return nil
+}
+
+// listenAndServe serves handler on addr until the returned function is called.
+// what names the endpoints in the logs.
+func listenAndServe(logger log.Logger, what, addr string, handler http.Handler) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warnf("failed to serve %s: %v", what, err)
+		}
+	}()
+	logger.Infof("%s listening on %s", what, l.Addr())
+
+	return func() {
+		_ = server.Close()
+	}, nil
+}
+
+// stopDaemon interrupts the daemon of the pid file and waits for it to exit.
+func stopDaemon(logger log.Logger, pidPath string) error {
+	data, err := os.ReadFile(pidPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("no daemon is running")
+		}
+		return fmt.Errorf("read pid file: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("parse pid file: %w", err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("find daemon process: %w", err)
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		// The daemon is gone without removing the pid file, e.g. killed by the runner.
+		_ = os.Remove(pidPath)
+		return fmt.Errorf("interrupt daemon(pid=%d): %w", pid, err)
+	}
+	logger.Infof("waiting for the daemon(pid=%d) to commit the remote cache", pid)
+
+	ctx := context.Background()
+	if CLI.CommitTimeout > 0 {
+		var cancel context.CancelFunc
+		// The daemon may still be finishing the requests of connected go commands before its commit.
+		ctx, cancel = context.WithTimeout(ctx, CLI.CommitTimeout+time.Minute)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(pidPath); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for daemon(pid=%d): %w", pid, ctx.Err())
+		}
+	}
+}
+
+// ClientCmd is the GOCACHEPROG relaying the go command to the daemon
+type ClientCmd struct {
+	Socket string `kong:"optional,help='Unix socket of the daemon. Defaults to gocica.sock in the cache directory.',type='path',env='GOCICA_SOCKET'"`
+}
+
+func (c *ClientCmd) Run(logger log.Logger) error {
+	conn, err := daemon.Dial(context.Background(), socketPath(c.Socket))
+	if err != nil {
+		// The go command must not fail because the daemon is not running, so it is served like gocica run.
+		logger.Warnf("%v. serving the go command in this process instead", err)
+		return (&RunCmd{}).Run(logger)
+	}
+
+	err = serveRecorded(logger, func(w io.Writer, r io.Reader) error {
+		return daemon.Relay(conn, w, r)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to relay to daemon: %w", err)
+	}
+
+	return nil
+}
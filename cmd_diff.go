@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mazrean/gocica/internal/headerdiff"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// DiffCmd compares the headers of two cache entries
+type DiffCmd struct {
+	Old   string `kong:"arg,help='Key of the cache entry to compare from, e.g. the one before the change.'"`
+	New   string `kong:"arg,help='Key of the cache entry to compare to.'"`
+	Limit int    `kong:"default='20',help='Most added, removed and changed entries listed each, the largest changes in size first. Negative lists every entry. --json writes every entry.'"`
+}
+
+func (c *DiffCmd) Run(logger log.Logger) error {
+	ctx := lifecycle.Context()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	signer, err := CLI.Signing.signer()
+	if err != nil {
+		return err
+	}
+
+	oldHeader, err := fetchHeader(ctx, logger, cipher, signer, c.Old)
+	if err != nil {
+		return err
+	}
+	newHeader, err := fetchHeader(ctx, logger, cipher, signer, c.New)
+	if err != nil {
+		return err
+	}
+
+	diff := headerdiff.Compare(oldHeader, newHeader)
+	if CLI.JSON {
+		return diff.WriteJSON(os.Stdout)
+	}
+
+	return diff.Write(os.Stdout, c.Limit)
+}
+
+// fetchHeader downloads the header of the cache entry of key.
+func fetchHeader(ctx context.Context, logger log.Logger, cipher *crypt.Cipher, signer *crypt.Signer, key string) (*headerdiff.Header, error) {
+	downloadClient, err := provider.GHACacheEntry(ctx, logger, CLI.Github.config(), key)
+	if err != nil {
+		return nil, fmt.Errorf("look up cache entry: %w", err)
+	}
+
+	downloader, err := core.NewDownloader(ctx, logger, downloadClient, cipher, signer)
+	if err != nil {
+		return nil, fmt.Errorf("read header of %s: %w", key, err)
+	}
+	// A rejected header is replaced with an empty one, which would show every entry as added or removed.
+	if err := downloader.Rejected(); err != nil {
+		return nil, fmt.Errorf("read header of %s: %w", key, err)
+	}
+
+	entries, err := downloader.GetEntries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get entries of %s: %w", key, err)
+	}
+	outputs, err := downloader.GetOutputs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get outputs of %s: %w", key, err)
+	}
+
+	return &headerdiff.Header{Key: key, Entries: entries, Outputs: outputs}, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mazrean/gocica/internal/doctor"
+	"github.com/mazrean/gocica/internal/doublecache"
+	"github.com/mazrean/gocica/internal/modcache"
+	"github.com/mazrean/gocica/log"
+)
+
+// DoctorCmd diagnoses the configuration, the remote cache and the local disk
+type DoctorCmd struct {
+	Write bool `kong:"help='Also create and commit a small probe cache entry under a separate key.'"`
+}
+
+func (c *DoctorCmd) Run(logger log.Logger) error {
+	ctx := context.Background()
+	d := &doctor.Doctor{}
+
+	d.CheckDir(CLI.Dir)
+	d.CheckDoubleCache(doubleCacheDirs(ctx))
+
+	config := CLI.Github.config()
+	if d.CheckGitHubConfig(config) {
+		d.CheckToken(config.Token)
+		d.CheckGitHubRemote(ctx, logger, config, c.Write)
+	}
+
+	if err := d.Print(os.Stdout); err != nil {
+		return fmt.Errorf("print findings: %w", err)
+	}
+
+	if d.Failed() {
+		return errors.New("some checks failed")
+	}
+
+	return nil
+}
+
+// doubleCacheDirs returns the directories of the go command that gocica caches with the current configuration.
+func doubleCacheDirs(ctx context.Context) []doublecache.Dir {
+	var dirs []doublecache.Dir
+	if dir, ok := doublecache.GoCache(); ok {
+		dirs = append(dirs, dir)
+	}
+	if CLI.ModCache {
+		if dir, err := modcache.Locate(ctx); err == nil {
+			dirs = append(dirs, doublecache.ModCache(dir))
+		}
+	}
+
+	return dirs
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// MigrateCmd copies a cache entry to another key or backend
+type MigrateCmd struct {
+	FromKey string `kong:"required,name='from-key',help='Key of the GitHub Actions cache entry to copy, e.g. one written before a change of --scope or --variant-namespace.'"`
+	ToKey   string `kong:"name='to-key',help='Key of the GitHub Actions cache entry to create. Empty copies to the entry the configured backend writes, e.g. the hub of --remote or the current key on GitHub Actions.'"`
+}
+
+func (c *MigrateCmd) Run(logger log.Logger) error {
+	ctx := lifecycle.Context()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	config := CLI.Github.config()
+	from, err := provider.GHACacheEntry(ctx, logger, config, c.FromKey)
+	if err != nil {
+		return fmt.Errorf("look up cache entry: %w", err)
+	}
+
+	to, target, err := c.target(logger, config)
+	if err != nil {
+		return err
+	}
+
+	// Only the ranges of the blob are copied, by the storage when it can, so the outputs are not downloaded to the runner.
+	size, err := core.CopyBlob(ctx, logger, from, to, cipher)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	logger.Infof("migrated %s (%d bytes) to %s", c.FromKey, size, target)
+
+	return nil
+}
+
+// target returns the upload client of the entry to copy to, and its name.
+func (c *MigrateCmd) target(logger log.Logger, config *provider.GHACacheConfig) (core.UploadClient, string, error) {
+	ctx := lifecycle.Context()
+
+	if c.ToKey != "" {
+		to, err := provider.GHACacheNewEntry(ctx, logger, config, c.ToKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("create cache entry: %w", err)
+		}
+
+		return to, c.ToKey, nil
+	}
+
+	_, uploadClientProvider, err := provider.Switch(ctx, logger, provider.BackendKind(CLI.Backend), config, hubConfig())
+	if err != nil {
+		return nil, "", fmt.Errorf("create backend: %w", err)
+	}
+	to, err := uploadClientProvider(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("create upload client: %w", err)
+	}
+	if to == nil {
+		return nil, "", errors.New("the configured backend takes no upload, e.g. as the entry of the current key exists. give --to-key or another backend")
+	}
+
+	return to, "the configured backend", nil
+}
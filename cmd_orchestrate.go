@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/internal/kessoku"
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// restoreStateFile is the file in the cache directory written by gocica restore and removed by gocica save.
+// It keeps the time of the restoration, after which the entries of the local index were used or put by the go commands.
+const restoreStateFile = "restore.state"
+
+// RestoreCmd restores the remote cache to the local disk in a step before the build
+type RestoreCmd struct{}
+
+func (*RestoreCmd) Run(logger log.Logger) error {
+	ctx := lifecycle.Context()
+
+	membuf.SetLimit(CLI.MaxMemory<<20, CLI.Dir)
+	// No go command looks up the outputs yet, so the whole blob is restored.
+	core.SetSparseAfter(0)
+	setupModCache(ctx, logger)
+
+	backend, err := strictBackend(ctx, logger)
+	if err != nil {
+		return orchestrationFailure(logger, "restore", err)
+	}
+
+	restorer, ok := backend.(cacheprog.Restorer)
+	if !ok {
+		return errors.New("the backend cannot restore the remote cache ahead of the build")
+	}
+	entries, err := restorer.Restore(ctx)
+	if err != nil {
+		return orchestrationFailure(logger, "restore", err)
+	}
+
+	if err := cacheprog.WriteLocalIndex(local.DiskDir(CLI.Dir), entries); err != nil {
+		return fmt.Errorf("write local index: %w", err)
+	}
+	// The entries restored were last used before now, so the ones used from now on are told apart by gocica save.
+	state := time.Now().UTC().Format(time.RFC3339Nano)
+	if err := os.WriteFile(filepath.Join(CLI.Dir, restoreStateFile), []byte(state+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write restore state: %w", err)
+	}
+	logger.Infof("restored %d entries to %s. the go commands are served from them until gocica save", len(entries), CLI.Dir)
+
+	return nil
+}
+
+// SaveCmd commits what the go commands used and put since gocica restore to the remote cache in a step after the build
+type SaveCmd struct{}
+
+func (*SaveCmd) Run(logger log.Logger) error {
+	ctx := lifecycle.Context()
+
+	restoredAt, ok := readRestoreState(logger)
+	if !ok {
+		// The go commands were served with the remote cache, which they committed themselves.
+		logger.Warnf("nothing was restored by gocica restore in %s. there is nothing to save", CLI.Dir)
+		return nil
+	}
+
+	membuf.SetLimit(CLI.MaxMemory<<20, CLI.Dir)
+	// The outputs are on the disk already, restored by gocica restore.
+	core.SetUploadOnly()
+	setupModCache(ctx, logger)
+	setupCompressor(ctx, logger)
+
+	backend, err := strictBackend(ctx, logger)
+	if err != nil {
+		return orchestrationFailure(logger, "save", err)
+	}
+
+	saver, ok := backend.(cacheprog.Saver)
+	if !ok {
+		return errors.New("the backend cannot commit the outputs of the build after it")
+	}
+	result, err := cacheprog.Save(ctx, logger, local.DiskDir(CLI.Dir), saver, restoredAt)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	if err := backend.Close(ctx); err != nil {
+		return orchestrationFailure(logger, "save", err)
+	}
+	logger.Infof("committed %d entries used and %d put since gocica restore", result.Used, result.Put)
+
+	if err := os.Remove(filepath.Join(CLI.Dir, restoreStateFile)); err != nil {
+		return fmt.Errorf("remove restore state: %w", err)
+	}
+
+	reportRun(logger)
+
+	return nil
+}
+
+// strictBackend sets up the remote backend for restore and save, failing instead of running without it.
+func strictBackend(ctx context.Context, logger log.Logger) (cacheprog.Backend, error) {
+	cipher, err := loadCipher()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", provider.ErrInvalidConfig, err)
+	}
+
+	signer, err := CLI.Signing.signer()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", provider.ErrInvalidConfig, err)
+	}
+
+	return kessoku.InitializeBackend(
+		ctx,
+		logger,
+		local.DiskDir(CLI.Dir),
+		provider.BackendKind(CLI.Backend),
+		CLI.Github.config(),
+		hubConfig(),
+		cipher,
+		signer,
+		cacheprog.CommitTimeout(CLI.CommitTimeout),
+	)
+}
+
+// orchestrationFailure returns the error of a step failing with the remote cache in strict mode.
+// Otherwise it only warns, as a build is never failed by its cache.
+func orchestrationFailure(logger log.Logger, step string, err error) error {
+	class := provider.ClassifyFailure(err)
+	if CLI.Strict {
+		return &exitCodeError{
+			code: initFailureExitCode(class),
+			err:  fmt.Errorf("failed to %s the remote cache (%s failure): %w", step, class, err),
+		}
+	}
+
+	logger.Warnf("failed to %s the remote cache (%s failure): %v. %s", step, class, err, class.Hint())
+
+	return nil
+}
+
+// readRestoreState returns the time of gocica restore, or false when the go commands are not served from its restoration.
+func readRestoreState(logger log.Logger) (time.Time, bool) {
+	data, err := os.ReadFile(filepath.Join(CLI.Dir, restoreStateFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warnf("failed to read the restore state: %v. the remote cache is used", err)
+		}
+		return time.Time{}, false
+	}
+
+	restoredAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		logger.Warnf("failed to parse the restore state: %v. the remote cache is used", err)
+		return time.Time{}, false
+	}
+
+	return restoredAt, true
+}
+
+// restoredBackend serves the go commands from the local index written by gocica restore without the remote cache.
+// The entries they use and put are saved to the local index for gocica save.
+func restoredBackend(ctx context.Context, logger log.Logger) (cacheprog.Backend, error) {
+	backend, err := kessoku.InitializeBackend(
+		ctx,
+		logger,
+		local.DiskDir(CLI.Dir),
+		provider.BackendNone,
+		CLI.Github.config(),
+		hubConfig(),
+		nil,
+		nil,
+		cacheprog.CommitTimeout(CLI.CommitTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("initialize local backend: %w", err)
+	}
+
+	return cacheprog.NewLocalFirstBackend(logger, local.DiskDir(CLI.Dir), backend), nil
+}
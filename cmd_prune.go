@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mazrean/gocica/internal/prune"
+	"github.com/mazrean/gocica/log"
+)
+
+// PruneCmd deletes stale gocica entries in the remote cache
+type PruneCmd struct {
+	GitHub     bool           `kong:"name='github',help='Prune the GitHub Actions Cache entries of the repository.'"`
+	OlderThan  time.Duration  `kong:"default='168h',help='Only delete entries that have not been accessed for this duration. 0 deletes every matching entry.'"`
+	Pattern    string         `kong:"default='gocica-cache-*',help='Only delete entries whose key matches this glob pattern.'"`
+	DryRun     bool           `kong:"help='List the entries that would be deleted without deleting them.'"`
+	GithubREST GithubRESTFlag `kong:"embed"`
+}
+
+func (c *PruneCmd) Run(logger log.Logger) error {
+	if !c.GitHub {
+		return errors.New("nothing to prune. specify --github to prune the GitHub Actions Cache")
+	}
+
+	remote, err := c.GithubREST.remote()
+	if err != nil {
+		return err
+	}
+
+	entries, err := prune.Prune(context.Background(), remote, &prune.Filter{
+		OlderThan: c.OlderThan,
+		Pattern:   c.Pattern,
+	}, c.DryRun)
+	if printErr := printEntries(entries); printErr != nil {
+		logger.Warnf("failed to print entries: %v", printErr)
+	}
+	if err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.SizeInBytes
+	}
+	if c.DryRun {
+		logger.Infof("%d entries (%d bytes) would be deleted", len(entries), size)
+	} else {
+		logger.Infof("deleted %d entries (%d bytes)", len(entries), size)
+	}
+
+	return nil
+}
+
+func printEntries(entries []prune.Entry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tREF\tSIZE\tLAST ACCESSED")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", entry.Key, entry.Ref, entry.SizeInBytes, entry.LastAccessedAt.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
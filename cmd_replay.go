@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/record"
+	"github.com/mazrean/gocica/log"
+)
+
+// maxLoggedMismatches is the mismatches logged one by one. The rest are only in the output file.
+const maxLoggedMismatches = 20
+
+// ReplayCmd replays a recorded session against the configured backend
+type ReplayCmd struct {
+	Session string        `kong:"arg,help='Session directory recorded with --record.',type='existingdir'"`
+	Timeout time.Duration `kong:"default='10m',help='Time after which the requests not answered yet are reported as a hang.'"`
+	Output  string        `kong:"optional,help='File to write the result to as JSON',type='path'"`
+}
+
+func (c *ReplayCmd) Run(logger log.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	membuf.SetLimit(CLI.MaxMemory<<20, CLI.Dir)
+
+	logger.Infof("replaying %s with the %s backend. its puts are committed to it like a build.", c.Session, CLI.Backend)
+	result, err := record.Replay(ctx, c.Session, func(w io.Writer, r io.Reader) error {
+		// The process is set up in the replay, so that its setup counts to the timeout like in the recorded run.
+		process, err := initializeProcess(context.WithoutCancel(ctx), logger)
+		if err != nil {
+			return fmt.Errorf("initialize process: %w", err)
+		}
+
+		return process.Serve(w, r)
+	})
+	if result != nil {
+		logger.Infof("replayed %d requests: %d responses, %d mismatches, %d pending in %s",
+			result.Requests, result.Responses, len(result.Mismatches), len(result.Pending), result.Duration.Round(time.Millisecond))
+		for i, m := range result.Mismatches {
+			if i == maxLoggedMismatches {
+				logger.Warnf("... and %d more mismatches", len(result.Mismatches)-i)
+				break
+			}
+			logger.Warnf("mismatch of %s %d (action %s): recorded %s, replayed %s", m.Command, m.ID, m.ActionID, m.Recorded, m.Replayed)
+		}
+		if len(result.Pending) > 0 {
+			logger.Errorf("requests not answered: %v", result.Pending)
+		}
+
+		if c.Output != "" {
+			if writeErr := result.WriteFile(c.Output); writeErr != nil {
+				logger.Warnf("failed to write result: %v", writeErr)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to replay: %w", err)
+	}
+
+	reportRun(logger)
+
+	return nil
+}
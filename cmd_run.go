@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/internal/kessoku"
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/modcache"
+	"github.com/mazrean/gocica/internal/pkg/audit"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/pkg/syncpause"
+	"github.com/mazrean/gocica/internal/pkg/variant"
+	"github.com/mazrean/gocica/internal/record"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// pauseFile is the control file in the cache directory that pauses the sync with the remote cache in laptop mode.
+const pauseFile = "sync.pause"
+
+// pushTimeout bounds the Pushgateway request so that it never stalls the end of the job.
+const pushTimeout = 10 * time.Second
+
+// RunCmd runs gocica as the GOCACHEPROG of the go command
+type RunCmd struct{}
+
+func (*RunCmd) Run(logger log.Logger) error {
+	// Initialize process via DI (FR-002: Context parameter, FR-007: Degraded mode handling)
+	// Use a cancellable context so we can clean up background goroutines on initialization failure.
+	// The second context parameter is for GitHubActionsCache initialization (kessoku DI limitation).
+	ctx, cancel := context.WithCancel(lifecycle.Context())
+	// Defer cancel to ensure cleanup even on panic (idempotent - safe to call multiple times)
+	defer cancel()
+
+	membuf.SetLimit(CLI.MaxMemory<<20, CLI.Dir)
+
+	// Downloads start during initialization, so recording must be enabled before it.
+	if CLI.Audit.Manifest != "" {
+		audit.Enable()
+	}
+
+	recordGoCommand(logger)
+
+	process, err := initializeProcess(ctx, logger)
+	if err != nil {
+		class := provider.ClassifyFailure(err)
+		if CLI.Strict {
+			return &exitCodeError{
+				code: initFailureExitCode(class),
+				err:  fmt.Errorf("failed to initialize process (%s failure): %w", class, err),
+			}
+		}
+
+		// Degraded mode: log warning and continue with no-cache Process
+		logger.Warnf("failed to initialize process (%s failure): %v. %s no cache will be used.", class, err, class.Hint())
+		report.SetInitFailure(string(class))
+		process = protocol.NewProcess(protocol.WithLogger(logger))
+	}
+
+	runErr := runProcess(logger, process)
+
+	reportRun(logger)
+
+	if runErr != nil {
+		return fmt.Errorf("failed to run process: %w", runErr)
+	}
+
+	return nil
+}
+
+// recordGoCommand records the go command that started gocica, which tells the summary why test results are not cached.
+func recordGoCommand(logger log.Logger) {
+	args, err := report.ReadParentCommand()
+	if err != nil {
+		logger.Debugf("failed to read the go command line: %v", err)
+	}
+	if len(args) > 0 {
+		name := strings.TrimSuffix(filepath.Base(args[0]), ".exe")
+		if name != "go" && !strings.HasPrefix(name, "go1.") {
+			// gocica was not started by the go command, e.g. by a wrapper script.
+			args = nil
+		}
+	}
+
+	report.SetGoCommand(args, os.Getenv("GOFLAGS"))
+}
+
+// runProcess serves the go command on stdin and stdout, recording the session when --record is given.
+func runProcess(logger log.Logger, process *protocol.Process) error {
+	if CLI.Record == "" {
+		return process.Run()
+	}
+
+	return serveRecorded(logger, process.Serve)
+}
+
+// serveRecorded calls serve with stdout and stdin, recording what passes through them when --record is given.
+// A recording that cannot be started leaves the go command served without it.
+func serveRecorded(logger log.Logger, serve func(w io.Writer, r io.Reader) error) error {
+	if CLI.Record == "" {
+		return serve(os.Stdout, os.Stdin)
+	}
+
+	session, err := record.NewSession(CLI.Record, version)
+	if err != nil {
+		logger.Warnf("failed to start recording: %v. the session is not recorded", err)
+		return serve(os.Stdout, os.Stdin)
+	}
+	defer func() {
+		if err := session.Close(); err != nil {
+			logger.Warnf("failed to finish recording: %v", err)
+		}
+	}()
+	logger.Infof("recording the session to %s", session.Dir())
+
+	return serve(session.Output(os.Stdout), session.Input(os.Stdin))
+}
+
+// reportRun logs the end-of-run summary and writes the report, the metrics and the audit manifest configured.
+func reportRun(logger log.Logger) {
+	summary := report.Collect()
+	if CLI.Attribution != "" {
+		if err := summary.AttributeFile(CLI.Attribution); err != nil {
+			logger.Warnf("failed to attribute cache misses: %v", err)
+		}
+	}
+	summary.Log(logger)
+
+	if CLI.Report != "" {
+		if err := summary.WriteFile(CLI.Report); err != nil {
+			logger.Warnf("failed to write report: %v", err)
+		}
+	}
+
+	if CLI.Metrics.OpenMetrics != "" {
+		if err := summary.WriteOpenMetricsFile(CLI.Metrics.OpenMetrics); err != nil {
+			logger.Warnf("failed to write metrics: %v", err)
+		}
+	}
+
+	if CLI.Metrics.Pushgateway != "" {
+		pushCtx, pushCancel := context.WithTimeout(context.Background(), pushTimeout)
+		defer pushCancel()
+		if err := summary.Push(pushCtx, CLI.Metrics.Pushgateway, CLI.Metrics.Job); err != nil {
+			logger.Warnf("failed to push metrics: %v", err)
+		}
+	}
+
+	if CLI.Audit.Manifest != "" {
+		if err := writeAuditManifest(); err != nil {
+			logger.Warnf("failed to write audit manifest: %v", err)
+		}
+	}
+}
+
+func writeAuditManifest() error {
+	var signer *audit.Signer
+	if CLI.Audit.Key != "" {
+		var err error
+		signer, err = audit.LoadSigner(CLI.Audit.Key)
+		if err != nil {
+			return fmt.Errorf("load signing key: %w", err)
+		}
+	}
+
+	return audit.Collect().WriteFile(CLI.Audit.Manifest, signer)
+}
+
+// initializeProcess loads the keys of the remote cache and wires up the process.
+// An unusable encryption or signing key is returned as an error like a backend failure,
+// so that the cache is never written unencrypted or trusted unsigned by mistake.
+// Unless in strict mode, the backend is set up while the process already serves the go command,
+// and a backend failure falls back to the local cache instead of being returned.
+func initializeProcess(ctx context.Context, logger log.Logger) (*protocol.Process, error) {
+	backend, err := initializeBackend(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	// The go command started this process, so its GODEBUG is the one of the build.
+	if cacheprog.VerifyMode(os.Getenv("GODEBUG")) {
+		backend = cacheprog.NewVerifyBackend(logger, backend)
+	}
+
+	return kessoku.NewProcessWithOptions(logger, cacheprog.NewCacheProg(logger, backend)), nil
+}
+
+// initializeBackend loads the keys of the remote cache and sets up the backend as described in initializeProcess.
+// After gocica restore, the backend serves the local index alone until gocica save.
+func initializeBackend(ctx context.Context, logger log.Logger) (cacheprog.Backend, error) {
+	if restoredAt, ok := readRestoreState(logger); ok {
+		logger.Infof("serving the cache restored by gocica restore at %s. gocica save commits it", restoredAt.Format(time.RFC3339))
+		return restoredBackend(ctx, logger)
+	}
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", provider.ErrInvalidConfig, err)
+	}
+
+	signer, err := CLI.Signing.signer()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", provider.ErrInvalidConfig, err)
+	}
+
+	if CLI.Laptop {
+		syncpause.SetFile(filepath.Join(CLI.Dir, pauseFile))
+		core.SetLowPriority()
+	}
+
+	setupModCache(ctx, logger)
+	setupCompressor(ctx, logger)
+
+	go warnDoubleCache(logger)
+
+	if CLI.CrossOS {
+		checkCrossOS(logger)
+	}
+
+	if inputs := variantInputs(); len(inputs) > 0 {
+		logger.Debugf("cache namespace of the build variant: %s (%s)", variant.Key(inputs), strings.Join(inputs, " "))
+	}
+
+	backend, err := setupBackend(ctx, logger, cipher, signer)
+	if err != nil {
+		return nil, err
+	}
+	if CLI.Laptop {
+		return cacheprog.NewLocalFirstBackend(logger, local.DiskDir(CLI.Dir), backend), nil
+	}
+
+	return backend, nil
+}
+
+// checkCrossOS tells why the remote cache cannot be shared with the runners of other OSes with --cross-os.
+func checkCrossOS(logger log.Logger) {
+	if !crossOS() {
+		logger.Infof("the go command may build with cgo (CGO_ENABLED=%q), so the remote cache is not shared across OSes. set CGO_ENABLED=0 to share it",
+			os.Getenv("CGO_ENABLED"))
+		return
+	}
+
+	trimpath := slices.ContainsFunc(report.BuildFlags(os.Getenv("GOFLAGS")), func(flag string) bool {
+		return strings.TrimLeft(flag, "-") == "trimpath" || strings.TrimLeft(flag, "-") == "trimpath=true"
+	})
+	if !trimpath {
+		logger.Warnf("the remote cache is shared across OSes, but without -trimpath the action IDs include the directories of the packages, which differ between the runners. add -trimpath to GOFLAGS")
+	}
+	logger.Debugf("remote cache shared across OSes for %s", variant.Target(os.Getenv))
+}
+
+// setupModCache enables the management of the module cache with --modcache.
+func setupModCache(ctx context.Context, logger log.Logger) {
+	if !CLI.ModCache {
+		return
+	}
+
+	dir, err := modcache.Locate(ctx)
+	if err != nil {
+		logger.Warnf("failed to locate the module cache: %v. the module cache is not kept in the remote cache.", err)
+		return
+	}
+	modcache.SetDir(dir)
+}
+
+// setupCompressor picks the codec of the outputs by --compress.codec,
+// and enables the external compressor of --compress.exec once it passes its sanity check.
+func setupCompressor(ctx context.Context, logger log.Logger) {
+	codec := core.DefaultCodec
+	switch CLI.Compress.Codec {
+	case "none":
+		codec = core.Codec{}
+	case "auto":
+		probed, throughput, err := core.ProbeCodec()
+		if err != nil {
+			logger.Warnf("failed to probe the compression: %v. the outputs are uploaded uncompressed.", err)
+			codec = core.Codec{}
+			break
+		}
+		codec = probed
+		logger.Debugf("zstd level 1 compresses %.0fMiB/s on this runner", throughput/(1<<20))
+	}
+	if CLI.Compress.Level > 0 && codec != (core.Codec{}) {
+		codec.Level = CLI.Compress.Level
+	}
+	core.SetCodec(codec)
+	logger.Debugf("outputs are compressed with %s", codec)
+
+	if CLI.Compress.Exec == "" {
+		return
+	}
+
+	compressor, err := core.NewExternalCompressor(ctx, CLI.Compress.Exec, CLI.Compress.ExecMinSize<<20)
+	if err != nil {
+		logger.Warnf("failed to set up the external compressor: %v. the built-in zstd compresses the outputs.", err)
+		return
+	}
+	core.SetExternalCompressor(compressor)
+	logger.Debugf("outputs of %dMiB or more are compressed with %s", CLI.Compress.ExecMinSize, compressor)
+}
+
+// setupBackend sets up the backend in strict mode, or starts setting it up in the background otherwise.
+func setupBackend(ctx context.Context, logger log.Logger, cipher *crypt.Cipher, signer *crypt.Signer) (cacheprog.Backend, error) {
+	if CLI.Strict {
+		return kessoku.InitializeBackend(
+			ctx,
+			logger,
+			local.DiskDir(CLI.Dir),
+			provider.BackendKind(CLI.Backend),
+			CLI.Github.config(),
+			hubConfig(),
+			cipher,
+			signer,
+			cacheprog.CommitTimeout(CLI.CommitTimeout),
+		)
+	}
+
+	initWait := CLI.InitWait
+	if CLI.Laptop {
+		// The local index answers what the developer built before, so the remote cache is not waited for.
+		initWait = 0
+	}
+
+	return cacheprog.NewLazyBackend(
+		ctx,
+		logger,
+		initWait,
+		func(ctx context.Context) (cacheprog.Backend, error) {
+			backend, err := kessoku.InitializeBackend(
+				ctx,
+				logger,
+				local.DiskDir(CLI.Dir),
+				provider.BackendKind(CLI.Backend),
+				CLI.Github.config(),
+				hubConfig(),
+				cipher,
+				signer,
+				cacheprog.CommitTimeout(CLI.CommitTimeout),
+			)
+			if err != nil {
+				class := provider.ClassifyFailure(err)
+				logger.Warnf("failed to initialize the remote cache (%s failure): %v. %s only the local cache will be used.", class, err, class.Hint())
+				report.SetInitFailure(string(class))
+				return nil, err
+			}
+
+			return backend, nil
+		},
+		func(ctx context.Context) (cacheprog.Backend, error) {
+			return kessoku.InitializeBackend(
+				ctx,
+				logger,
+				local.DiskDir(CLI.Dir),
+				provider.BackendNone,
+				CLI.Github.config(),
+				hubConfig(),
+				nil,
+				nil,
+				cacheprog.CommitTimeout(CLI.CommitTimeout),
+			)
+		},
+	), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/hub"
+	"github.com/mazrean/gocica/log"
+)
+
+const (
+	// serveReadHeaderTimeout bounds the headers of a request to the hub, which keeps idle connections from piling up.
+	serveReadHeaderTimeout = 10 * time.Second
+	// serveShutdownTimeout is the time given to the requests in flight when the hub is stopped.
+	serveShutdownTimeout = 30 * time.Second
+)
+
+// ServeCmd serves the cache hub shared by the jobs of a workflow
+type ServeCmd struct {
+	HTTP  string    `kong:"name='http',default=':8080',help='Address to serve the cache hub on. Jobs use it with --remote=http://<host><address>.',env='GOCICA_SERVE_HTTP'"`
+	Admin AdminFlag `kong:"embed,prefix='admin-'"`
+}
+
+func (c *ServeCmd) Run(logger log.Logger, kctx *kong.Context) error {
+	server, err := hub.NewServer(logger, filepath.Join(CLI.Dir, "hub"))
+	if err != nil {
+		return fmt.Errorf("failed to set up hub: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	effective := watchConfig(ctx, logger, kctx)
+	stopAdmin, err := c.Admin.serve(logger, effective)
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+
+	l, err := net.Listen("tcp", c.HTTP)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", c.HTTP, err)
+	}
+
+	// The configuration is only served on the admin endpoints, behind their token.
+	httpServer := &http.Server{
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.Serve(l)
+	}()
+	logger.Infof("cache hub listening on %s", l.Addr())
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to serve hub: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Infof("cache hub stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop hub: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve hub: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mazrean/gocica/internal/prune"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// VerifyCmd downloads the current cache blob and checks its integrity
+type VerifyCmd struct {
+	Delete     bool           `kong:"help='Delete the cache entry through the GitHub REST API when it is corrupt.'"`
+	GithubREST GithubRESTFlag `kong:"embed"`
+}
+
+func (c *VerifyCmd) Run(logger log.Logger) error {
+	ctx := context.Background()
+
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	signer, err := CLI.Signing.signer()
+	if err != nil {
+		return err
+	}
+
+	downloadClientProvider, _, err := provider.GHACacheProvider(ctx, logger, CLI.Github.config())
+	if err != nil {
+		return fmt.Errorf("create cache client: %w", err)
+	}
+
+	downloadClient, err := downloadClientProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("look up cache entry: %w", err)
+	}
+	if downloadClient == nil {
+		return errors.New("no cache entry found")
+	}
+
+	var key string
+	if keyed, ok := downloadClient.(interface{ Key() string }); ok {
+		key = keyed.Key()
+	}
+
+	downloader, err := core.NewDownloader(ctx, logger, downloadClient, cipher, signer)
+	if err != nil {
+		// A header that cannot be read or parsed makes the whole entry unusable.
+		return c.corrupt(ctx, logger, key, []string{fmt.Sprintf("header: %v", err)})
+	}
+
+	outputs, err := downloader.GetOutputs(ctx)
+	if err != nil {
+		return fmt.Errorf("get outputs: %w", err)
+	}
+	entries, err := downloader.GetEntries(ctx)
+	if err != nil {
+		return fmt.Errorf("get entries: %w", err)
+	}
+	logger.Infof("verifying %s: %d entries, %d outputs", key, len(entries), len(outputs))
+
+	problems, err := downloader.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(os.Stdout, "%s: ok\n", key)
+		return nil
+	}
+
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+
+	return c.corrupt(ctx, logger, key, messages)
+}
+
+// corrupt reports the problems of the entry and deletes it when requested.
+func (c *VerifyCmd) corrupt(ctx context.Context, logger log.Logger, key string, problems []string) error {
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stdout, "%s: %s\n", key, problem)
+	}
+
+	if c.Delete {
+		if key == "" {
+			return errors.New("cache entry is corrupt, but its key is unknown and cannot be deleted")
+		}
+
+		remote, err := c.GithubREST.remote()
+		if err != nil {
+			return err
+		}
+
+		deleted, err := prune.Prune(ctx, remote, &prune.Filter{Key: key}, false)
+		if err != nil {
+			return fmt.Errorf("delete cache entry: %w", err)
+		}
+		logger.Infof("deleted %d cache entries with key %s", len(deleted), key)
+	}
+
+	return fmt.Errorf("cache entry is corrupt: %d problems", len(problems))
+}
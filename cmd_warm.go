@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+)
+
+// warmShaPrefix marks the keys committed by the warm command.
+// The ref part of the key is kept, so that runs on the same ref restore the warmed cache.
+const warmShaPrefix = "warm-"
+
+// WarmCmd pre-populates the remote cache by running a build under gocica
+type WarmCmd struct {
+	Command []string `kong:"arg,optional,help='Command to run with gocica as GOCACHEPROG. Defaults to go build std ./...'"`
+}
+
+func (c *WarmCmd) Run(logger log.Logger) error {
+	args := c.Command
+	if len(args) == 0 {
+		args = []string{"go", "build", "std", "./..."}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("get executable: %w", err)
+	}
+
+	// Each run needs a unique key because committed cache entries are immutable.
+	sha := warmShaPrefix + time.Now().UTC().Format("20060102T150405Z")
+	logger.Infof("warming cache: key sha=%s, command=%v", sha, args)
+
+	//nolint:gosec
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Pass the resolved configuration through the environment,
+	// so that flags and configuration files given to warm also apply to the child gocica.
+	cmd.Env = append(os.Environ(),
+		"GOCACHEPROG="+quoteGoCacheProg(executable),
+		"GOCICA_DIR="+CLI.Dir,
+		"GOCICA_LOG_LEVEL="+CLI.LogLevel,
+		"GOCICA_LOG_SAMPLE="+strconv.Itoa(CLI.LogSample),
+		"GOCICA_GITHUB_CACHE_URL="+CLI.Github.CacheURL,
+		"GOCICA_GITHUB_TOKEN="+CLI.Github.Token,
+		"GOCICA_GITHUB_RUNNER_OS="+CLI.Github.RunnerOS,
+		"GOCICA_GITHUB_REF="+CLI.Github.Ref,
+		"GOCICA_GITHUB_SHA="+sha,
+		"GOCICA_GITHUB_EVENT="+CLI.Github.Event,
+		"GOCICA_GITHUB_RUN_ID="+CLI.Github.RunID,
+		"GOCICA_GITHUB_RUN_ATTEMPT="+CLI.Github.RunAttempt,
+		"GOCICA_PR_ISOLATION="+strconv.FormatBool(CLI.PRIsolation),
+		"GOCICA_VARIANT_NAMESPACE="+strconv.FormatBool(CLI.VariantNS),
+		"GOCICA_SCOPE="+CLI.Scope,
+		"GOCICA_CROSS_OS="+strconv.FormatBool(CLI.CrossOS),
+		"GOCICA_COMMIT_TIMEOUT="+CLI.CommitTimeout.String(),
+		"GOCICA_CLOSE_TIMEOUT="+CLI.CloseTimeout.String(),
+		"GOCICA_MAX_MEMORY="+strconv.FormatInt(CLI.MaxMemory, 10),
+		"GOCICA_COMPRESS_CODEC="+CLI.Compress.Codec,
+		"GOCICA_COMPRESS_LEVEL="+strconv.Itoa(CLI.Compress.Level),
+		"GOCICA_COMPRESS_EXEC="+CLI.Compress.Exec,
+		"GOCICA_COMPRESS_EXEC_MIN_SIZE="+strconv.FormatInt(CLI.Compress.ExecMinSize, 10),
+		// The warm key is unique to this run, so there are no other jobs to merge with.
+		"GOCICA_COORDINATION_JOB_TOTAL=0",
+	)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run %s: %w", args[0], err)
+	}
+
+	return nil
+}
+
+// quoteGoCacheProg quotes path the way the go command splits GOCACHEPROG.
+// The go command supports quotes but no escapes, so backslashes of Windows paths are kept as is.
+func quoteGoCacheProg(path string) string {
+	if !strings.ContainsAny(path, " \t\n") {
+		return path
+	}
+	if strings.Contains(path, "'") {
+		return `"` + path + `"`
+	}
+
+	return "'" + path + "'"
+}
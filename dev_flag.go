@@ -8,9 +8,11 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"time"
 
 	"github.com/felixge/fgprof"
 	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/remote/fault"
 )
 
 type DevFlag struct {
@@ -22,6 +24,25 @@ type DevFlag struct {
 	BlockProf   string       `kong:"optional,help='Block profile output file',type='path'"`
 	FgProf      string       `kong:"optional,help='fgprof output file',type='path'"`
 	fgprofStop  func() error `kong:"-"`
+
+	FaultLatency      time.Duration `kong:"optional,help='Mean latency injected into the calls to the remote cache'"`
+	FaultErrorRate    float64       `kong:"optional,help='Rate of the calls to the remote cache failing with an injected error'"`
+	FaultTruncateRate float64       `kong:"optional,help='Rate of the transfers of the remote cache truncated in the middle'"`
+	FaultSeed         uint64        `kong:"optional,help='Seed of the injected faults. 0 picks a random one'"`
+}
+
+// InjectFaults enables the fault injection into the remote cache when any fault flag is set.
+func (d *DevFlag) InjectFaults() error {
+	if d.FaultLatency == 0 && d.FaultErrorRate == 0 && d.FaultTruncateRate == 0 {
+		return nil
+	}
+
+	return fault.Enable(fault.Config{
+		Latency:      d.FaultLatency,
+		ErrorRate:    d.FaultErrorRate,
+		TruncateRate: d.FaultTruncateRate,
+		Seed:         d.FaultSeed,
+	})
 }
 
 func (d *DevFlag) StartProfiling() error {
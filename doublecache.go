@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mazrean/gocica/internal/doublecache"
+	"github.com/mazrean/gocica/internal/modcache"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/log"
+)
+
+// warnDoubleCache warns once per job when GOCACHE, or GOMODCACHE with --modcache, was restored by another cache step,
+// e.g. actions/setup-go or actions/cache, which only adds minutes to every run next to gocica.
+func warnDoubleCache(logger log.Logger) {
+	if !doublecache.Enabled() || !doublecache.Claim() {
+		return
+	}
+
+	jobStart, err := doublecache.JobStart()
+	if err != nil {
+		logger.Debugf("failed to find the start of the job: %v. double caching is not detected.", err)
+		return
+	}
+
+	var dirs []doublecache.Dir
+	if dir, ok := doublecache.GoCache(); ok {
+		dirs = append(dirs, dir)
+	}
+	if dir := modcache.Dir(); dir != "" {
+		dirs = append(dirs, doublecache.ModCache(dir))
+	}
+
+	findings, err := doublecache.Detect(dirs, jobStart)
+	if err != nil {
+		logger.Debugf("failed to detect double caching: %v", err)
+		return
+	}
+
+	for _, finding := range findings {
+		message := finding.Message()
+		logger.Warnf("%s", message)
+		report.AddEvent("doublecache", message)
+		// An annotation shows the warning on the summary of the run. stdout carries the protocol, so it goes to stderr,
+		// which the runner also reads for workflow commands.
+		fmt.Fprintf(os.Stderr, "::warning title=gocica: %s cached twice::%s\n", finding.Name, escapeWorkflowCommand(message))
+	}
+}
+
+// escapeWorkflowCommand escapes the message of a workflow command of GitHub Actions.
+func escapeWorkflowCommand(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
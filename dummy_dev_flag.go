@@ -9,3 +9,7 @@ func (d DevFlag) StartProfiling() error {
 }
 
 func (d DevFlag) StopProfiling() {}
+
+func (d DevFlag) InjectFaults() error {
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build fips
+
+// Builds with the fips tag run in the Go FIPS 140-3 mode, in which only approved algorithms are used.
+// Build with GOFIPS140=v1.0.0 as well to link the validated snapshot of the Go Cryptographic Module.
+// ref: https://go.dev/doc/security/fips140
+
+//go:debug fips140=on
+
+package main
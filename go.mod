@@ -18,6 +18,9 @@ require (
 	github.com/felixge/fgprof v0.9.5
 	github.com/mazrean/kessoku v1.1.0
 	github.com/prometheus/procfs v0.19.2
+	go.uber.org/goleak v1.3.0
+	golang.org/x/sys v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -30,7 +33,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	golang.org/x/arch v0.14.0 // indirect
 	golang.org/x/net v0.49.0 // indirect
-	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 )
 
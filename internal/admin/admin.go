@@ -0,0 +1,110 @@
+// Package admin serves the admin endpoints of the long-running modes, gocica daemon and gocica serve:
+// the profiles of pprof, the configuration in effect and the live metrics.
+// They are served on the loopback interface and guarded by a token, as the profiles expose the memory of the process.
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Paths of the admin endpoints.
+const (
+	// PprofPath is the prefix of the profiles of net/http/pprof, e.g. /debug/pprof/profile?seconds=30.
+	PprofPath = "/debug/pprof/"
+	// ConfigPath serves the configuration in effect as JSON.
+	ConfigPath = "/debug/config"
+	// MetricsPath serves the counters of the process in the Prometheus text exposition format.
+	MetricsPath = "/debug/metrics"
+)
+
+// TokenQuery is the query parameter of the token, for clients that cannot set the Authorization header, e.g. go tool pprof.
+const TokenQuery = "token"
+
+// tokenSize is the size in bytes of a generated token.
+const tokenSize = 32
+
+// Handler returns the handler of the admin endpoints.
+// Requests without the token, given as a bearer token or in TokenQuery, are answered with 401.
+func Handler(token string, config, metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+PprofPath, pprof.Index)
+	mux.HandleFunc("GET "+PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET "+PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc("GET "+PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc("POST "+PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc("GET "+PprofPath+"trace", pprof.Trace)
+	mux.Handle("GET "+ConfigPath, config)
+	mux.Handle("GET "+MetricsPath, metrics)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func authorized(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		given = r.URL.Query().Get(TokenQuery)
+	}
+
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// LoadToken returns the token kept in the file at path, generating it on first use.
+// The file is only readable by the user, who passes it to the clients of the admin endpoints.
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read token: %w", err)
+	}
+
+	buf := make([]byte, tokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("write token: %w", err)
+	}
+
+	return token, nil
+}
+
+// CheckAddr checks that addr is on the loopback interface, as the endpoints are served over plain HTTP.
+func CheckAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("admin address %s is not on the loopback interface", addr)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	config := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("config"))
+	})
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	})
+	handler := Handler("s3cret", config, metrics)
+
+	tests := []struct {
+		name       string
+		target     string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "no token", target: ConfigPath, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", target: ConfigPath, header: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "bearer token", target: ConfigPath, header: "Bearer s3cret", wantStatus: http.StatusOK, wantBody: "config"},
+		{name: "query token", target: MetricsPath + "?" + TokenQuery + "=s3cret", wantStatus: http.StatusOK, wantBody: "metrics"},
+		{name: "pprof", target: PprofPath + "goroutine?debug=1", header: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "unknown path", target: "/debug/unknown", header: "Bearer s3cret", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body: got %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dir", "admin.token")
+
+	token, err := LoadToken(path)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if len(token) != 2*tokenSize {
+		t.Errorf("token length: got %d, want %d", len(token), 2*tokenSize)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat token: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("permission: got %o, want 600", perm)
+	}
+
+	again, err := LoadToken(path)
+	if err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	if again != token {
+		t.Errorf("token changed: got %q, want %q", again, token)
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "127.0.0.1:6060"},
+		{addr: "[::1]:6060"},
+		{addr: "localhost:6060"},
+		{addr: ":6060", wantErr: true},
+		{addr: "0.0.0.0:6060", wantErr: true},
+		{addr: "10.0.0.1:6060", wantErr: true},
+		{addr: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			t.Parallel()
+
+			if err := CheckAddr(tt.addr); (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
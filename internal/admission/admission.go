@@ -0,0 +1,76 @@
+package admission
+
+import (
+	"sync/atomic"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+var (
+	// minSize is the size from which the outputs are admitted by the sketch. 0 admits every output.
+	minSize atomic.Int64
+	// current is the sketch of the run, loaded from the header of the remote cache.
+	current atomic.Pointer[Sketch]
+	// history reports whether the sketch loaded has counted any action.
+	history atomic.Bool
+)
+
+// Set sets the size from which the outputs are only uploaded once their actions have been seen before. 0 disables the admission.
+func Set(size int64) {
+	minSize.Store(size)
+}
+
+// Enabled reports whether the admission is enabled in the run.
+func Enabled() bool {
+	return minSize.Load() > 0
+}
+
+// Load sets the sketch kept in the header of the remote cache as the one of the run.
+func Load(msg *v1.AdmissionSketch) {
+	s := Unmarshal(msg)
+	history.Store(!s.Empty())
+	current.Store(s)
+}
+
+// sketch returns the sketch of the run, creating an empty one when none has been loaded.
+func sketch() *Sketch {
+	if s := current.Load(); s != nil {
+		return s
+	}
+	current.CompareAndSwap(nil, NewSketch())
+
+	return current.Load()
+}
+
+// Admit counts the put of the action, and reports whether its output is uploaded to the remote cache.
+// An output smaller than the size set is always admitted, and a larger one only when its action has been seen before.
+// Without any history, e.g. in the first run, every output is admitted, so that a new remote cache is filled at once.
+func Admit(actionID string, size int64) bool {
+	if !Enabled() {
+		return true
+	}
+
+	seen := sketch().Add(actionID) > 0
+
+	return size < minSize.Load() || !history.Load() || seen
+}
+
+// Record counts the hit of the action.
+func Record(actionID string) {
+	if !Enabled() {
+		return
+	}
+
+	sketch().Add(actionID)
+}
+
+// Snapshot returns the sketch to keep in the header of the remote cache.
+// The sketch loaded is kept while the admission is disabled, and none is kept when it has never been enabled.
+func Snapshot() *v1.AdmissionSketch {
+	s := current.Load()
+	if s == nil || s.Empty() {
+		return nil
+	}
+
+	return s.Marshal()
+}
@@ -0,0 +1,51 @@
+package admission
+
+import "testing"
+
+// TestAdmit is not parallel, as it sets the admission of the run.
+func TestAdmit(t *testing.T) {
+	t.Cleanup(func() {
+		Set(0)
+		current.Store(nil)
+		history.Store(false)
+	})
+
+	Set(0)
+	if !Admit("disabled", 1<<30) {
+		t.Error("an output is rejected while the admission is disabled")
+	}
+
+	Set(1 << 20)
+
+	// Every output is admitted without any history.
+	Load(nil)
+	if !Admit("first", 1<<30) {
+		t.Error("an output of the first run is rejected")
+	}
+
+	history := NewSketch()
+	history.Add("seen")
+	Load(history.Marshal())
+
+	tests := []struct {
+		name     string
+		actionID string
+		size     int64
+		want     bool
+	}{
+		{name: "small", actionID: "small", size: 1<<20 - 1, want: true},
+		{name: "unseen", actionID: "unseen", size: 1 << 20, want: false},
+		{name: "seen", actionID: "seen", size: 1 << 20, want: true},
+		{name: "put again", actionID: "unseen", size: 1 << 20, want: true},
+	}
+	for _, tt := range tests {
+		if got := Admit(tt.actionID, tt.size); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	Record("hit")
+	if got := Snapshot(); Unmarshal(got).Estimate("hit") != 1 {
+		t.Error("the hit is not kept in the snapshot")
+	}
+}
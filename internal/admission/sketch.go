@@ -0,0 +1,141 @@
+// Package admission decides which large outputs are worth uploading to the remote cache.
+// Outputs written every run but never read again, e.g. the binaries of commands linked for each change,
+// take most of the upload time and the size of the remote cache without saving any build.
+// The puts and the hits of the actions are counted in a count-min sketch kept in the header,
+// and a large output is admitted only once its action has been seen before, like the doorkeeper of TinyLFU.
+package admission
+
+import (
+	"hash/fnv"
+	"sync"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+const (
+	// depth is the number of rows of the sketch.
+	depth = 4
+	// width is the number of counters of a row.
+	width = 1 << 14
+	// maxCount is the largest value of a 4 bit counter.
+	maxCount = 15
+	// resetAdditions is the number of additions after which the counters are halved, so that old history fades.
+	resetAdditions = width * 10
+)
+
+// Sketch is a count-min sketch of the actions with 4 bit counters, two in a byte.
+type Sketch struct {
+	locker    sync.Mutex
+	counters  []byte
+	additions int64
+}
+
+// NewSketch creates an empty sketch.
+func NewSketch() *Sketch {
+	return &Sketch{counters: make([]byte, depth*width/2)}
+}
+
+// Unmarshal returns the sketch kept in the header, or an empty one when the header has none of this size.
+func Unmarshal(msg *v1.AdmissionSketch) *Sketch {
+	if len(msg.GetCounters()) != depth*width/2 {
+		return NewSketch()
+	}
+
+	return &Sketch{
+		counters:  append([]byte(nil), msg.GetCounters()...),
+		additions: msg.GetAdditions(),
+	}
+}
+
+// Marshal returns the sketch to keep in the header.
+func (s *Sketch) Marshal() *v1.AdmissionSketch {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return &v1.AdmissionSketch{
+		Counters:  append([]byte(nil), s.counters...),
+		Additions: s.additions,
+	}
+}
+
+// indexes returns the index of the counter of the action in each row, by double hashing.
+func indexes(actionID string) [depth]int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(actionID))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+	var idx [depth]int
+	for i := range idx {
+		idx[i] = i*width + int((h1+uint32(i)*h2)%width)
+	}
+
+	return idx
+}
+
+func (s *Sketch) get(i int) byte {
+	return s.counters[i/2] >> (4 * (i % 2)) & 0x0f
+}
+
+func (s *Sketch) set(i int, v byte) {
+	shift := 4 * (i % 2)
+	s.counters[i/2] = s.counters[i/2]&^(0x0f<<shift) | v<<shift
+}
+
+func (s *Sketch) estimate(idx [depth]int) byte {
+	count := byte(maxCount)
+	for _, i := range idx {
+		count = min(count, s.get(i))
+	}
+
+	return count
+}
+
+// Estimate returns the number of times the action has been added, bounded by 15.
+func (s *Sketch) Estimate(actionID string) int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return int(s.estimate(indexes(actionID)))
+}
+
+// Add counts the action once, and returns the count before it.
+// Only the smallest counters are incremented, the conservative update, to keep the overestimation low.
+func (s *Sketch) Add(actionID string) int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	idx := indexes(actionID)
+	count := s.estimate(idx)
+	if count < maxCount {
+		for _, i := range idx {
+			if s.get(i) == count {
+				s.set(i, count+1)
+			}
+		}
+	}
+
+	s.additions++
+	if s.additions >= resetAdditions {
+		s.halve()
+	}
+
+	return int(count)
+}
+
+// halve halves all the counters.
+func (s *Sketch) halve() {
+	for i := range s.counters {
+		// Each nibble is shifted on its own, dropping the bit carried from the high nibble into the low one.
+		s.counters[i] = s.counters[i] >> 1 & 0x77
+	}
+	s.additions /= 2
+}
+
+// Empty reports whether nothing has been added to the sketch.
+func (s *Sketch) Empty() bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return s.additions == 0
+}
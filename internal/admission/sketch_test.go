@@ -0,0 +1,60 @@
+package admission
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	t.Parallel()
+
+	s := NewSketch()
+	for i := range 20 {
+		if got := s.Add("hot"); got != min(i, maxCount) {
+			t.Fatalf("add %d: got count %d, want %d", i, got, min(i, maxCount))
+		}
+	}
+	s.Add("warm")
+
+	tests := []struct {
+		actionID string
+		want     int
+	}{
+		{actionID: "hot", want: maxCount},
+		{actionID: "warm", want: 1},
+		{actionID: "cold", want: 0},
+	}
+	for _, tt := range tests {
+		if got := s.Estimate(tt.actionID); got != tt.want {
+			t.Errorf("estimate %s: got %d, want %d", tt.actionID, got, tt.want)
+		}
+	}
+
+	// The sketch kept in the header counts the same.
+	restored := Unmarshal(s.Marshal())
+	for _, tt := range tests {
+		if got := restored.Estimate(tt.actionID); got != tt.want {
+			t.Errorf("restored estimate %s: got %d, want %d", tt.actionID, got, tt.want)
+		}
+	}
+
+	if !Unmarshal(nil).Empty() {
+		t.Error("sketch of a header without one is not empty")
+	}
+}
+
+func TestSketch_halve(t *testing.T) {
+	t.Parallel()
+
+	s := NewSketch()
+	for range 8 {
+		s.Add("hot")
+	}
+	for i := 0; s.Estimate("hot") == 8; i++ {
+		s.Add(fmt.Sprintf("action-%d", i))
+	}
+
+	if got := s.Estimate("hot"); got != 4 {
+		t.Errorf("got %d after halving, want 4", got)
+	}
+}
@@ -0,0 +1,223 @@
+// Package archive exports a cache blob to a local archive and imports it back into a remote.
+//
+// An archive is a zstd compressed tar containing the gocica blob as is (header and outputs)
+// and a small JSON metadata file, so it can be moved between providers or seeded offline.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/json"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	metadataName = "gocica.json"
+	blobName     = "blob"
+
+	formatVersion = 1
+)
+
+// Metadata describes the blob stored in an archive.
+type Metadata struct {
+	Version    int       `json:"version"`
+	Key        string    `json:"key,omitempty"`
+	Size       int64     `json:"size"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Export downloads the whole blob of client and writes it to w as an archive.
+// An encrypted blob is exported still encrypted; cipher is only used to read its header.
+func Export(ctx context.Context, logger log.Logger, client core.DownloadClient, cipher *crypt.Cipher, key string, w io.Writer) (*Metadata, error) {
+	downloader, err := core.NewDownloader(ctx, logger, client, cipher, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	_, headerSize, outputSize, err := downloader.GetOutputBlockURL(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get blob size: %w", err)
+	}
+
+	metadata := &Metadata{
+		Version:    formatVersion,
+		Key:        key,
+		Size:       headerSize + outputSize,
+		ExportedAt: time.Now(),
+	}
+
+	zw := zstd.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	metadataBuf := &bytes.Buffer{}
+	if err := json.NewEncoder(metadataBuf).Encode(metadata); err != nil {
+		return nil, fmt.Errorf("encode metadata: %w", err)
+	}
+	if err := writeFile(tw, metadataName, int64(metadataBuf.Len()), metadata.ExportedAt, func(w io.Writer) error {
+		_, err := metadataBuf.WriteTo(w)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(tw, blobName, metadata.Size, metadata.ExportedAt, func(w io.Writer) error {
+		return client.DownloadBlock(ctx, 0, metadata.Size, w)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("close tar: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("close compressor: %w", err)
+	}
+
+	return metadata, nil
+}
+
+func writeFile(tw *tar.Writer, name string, size int64, modTime time.Time, write func(io.Writer) error) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: modTime,
+	}); err != nil {
+		return fmt.Errorf("write %s header: %w", name, err)
+	}
+
+	if err := write(tw); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// maxImportBlockSize is the size of the blocks staged by Import.
+const maxImportBlockSize = 4 * (1 << 20)
+
+// Import reads an archive from r and uploads its blob as a new cache entry with client.
+// cipher must be the one the blob was encrypted with, or nil for an unencrypted blob.
+func Import(ctx context.Context, r io.Reader, client core.UploadClient, cipher *crypt.Cipher) (*Metadata, error) {
+	zr := zstd.NewReader(r)
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	var metadata *Metadata
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("archive has no blob")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+
+		switch hdr.Name {
+		case metadataName:
+			metadata = &Metadata{}
+			if err := json.NewDecoder(tr).Decode(metadata); err != nil {
+				return nil, fmt.Errorf("decode metadata: %w", err)
+			}
+			if metadata.Version != formatVersion {
+				return nil, fmt.Errorf("unsupported archive version %d", metadata.Version)
+			}
+		case blobName:
+			if metadata == nil {
+				return nil, errors.New("archive has no metadata before the blob")
+			}
+			if hdr.Size != metadata.Size {
+				return nil, fmt.Errorf("blob size %d does not match the metadata size %d", hdr.Size, metadata.Size)
+			}
+
+			if err := uploadBlob(ctx, tr, hdr.Size, client, cipher); err != nil {
+				return nil, err
+			}
+
+			return metadata, nil
+		}
+	}
+}
+
+func uploadBlob(ctx context.Context, r io.Reader, size int64, client core.UploadClient, cipher *crypt.Cipher) error {
+	var (
+		blockIDs []string
+		buf      = make([]byte, maxImportBlockSize)
+		read     int64
+	)
+	for read < size {
+		n, err := io.ReadFull(r, buf[:min(maxImportBlockSize, size-read)])
+		if err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+
+		// Reject archives that are not gocica blobs before anything is committed.
+		if read == 0 {
+			if err := validateHeader(buf[:n], cipher); err != nil {
+				return fmt.Errorf("invalid blob: %w", err)
+			}
+		}
+		read += int64(n)
+
+		var idBuf [32]byte
+		if _, err := rand.Read(idBuf[:]); err != nil {
+			return fmt.Errorf("generate block ID: %w", err)
+		}
+		blockID := base64.StdEncoding.EncodeToString(idBuf[:])
+
+		if _, err := client.UploadBlock(ctx, blockID, myio.NopSeekCloser(bytes.NewReader(buf[:n]))); err != nil {
+			return fmt.Errorf("upload block: %w", err)
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+
+	if err := client.Commit(ctx, blockIDs, size); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+// validateHeader checks that the first block of a blob starts with a readable header.
+// Headers larger than the first block are only checked for their size.
+func validateHeader(block []byte, cipher *crypt.Cipher) error {
+	if len(block) < 8 {
+		return errors.New("blob is shorter than the header size")
+	}
+
+	//nolint:gosec
+	headerSize := int(binary.BigEndian.Uint64(block))
+	if headerSize < 0 || 8+headerSize > len(block) {
+		return nil
+	}
+
+	header := block[8 : 8+headerSize]
+	if cipher != nil {
+		var err error
+		header, err = cipher.OpenHeader(header)
+		if err != nil {
+			return fmt.Errorf("decrypt header: %w", err)
+		}
+	}
+
+	if err := proto.Unmarshal(header, &v1.ActionsCache{}); err != nil {
+		return fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	return nil
+}
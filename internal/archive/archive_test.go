@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/proto"
+)
+
+type memoryDownloadClient struct {
+	blob []byte
+}
+
+func (c *memoryDownloadClient) GetURL(context.Context) string {
+	return ""
+}
+
+func (c *memoryDownloadClient) DownloadBlock(_ context.Context, offset int64, size int64, w io.Writer) error {
+	_, err := w.Write(c.blob[offset : offset+size])
+	return err
+}
+
+func (c *memoryDownloadClient) DownloadBlockBuffer(_ context.Context, offset int64, size int64, buf []byte) error {
+	copy(buf, c.blob[offset:offset+size])
+	return nil
+}
+
+type memoryUploadClient struct {
+	blocks    map[string][]byte
+	committed []byte
+}
+
+func (c *memoryUploadClient) UploadBlock(_ context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	c.blocks[blockID] = buf
+
+	return int64(len(buf)), nil
+}
+
+func (c *memoryUploadClient) UploadBlockFromURL(context.Context, string, string, int64, int64) error {
+	return nil
+}
+
+func (c *memoryUploadClient) Commit(_ context.Context, blockIDs []string, _ int64) error {
+	for _, blockID := range blockIDs {
+		c.committed = append(c.committed, c.blocks[blockID]...)
+	}
+
+	return nil
+}
+
+func TestExportImport(t *testing.T) {
+	header, err := proto.Marshal(&v1.ActionsCache{
+		Entries:         map[string]*v1.IndexEntry{"action": {OutputId: "output", Size: 5}},
+		Outputs:         []*v1.ActionsOutput{{Id: "output", Size: 5}},
+		OutputTotalSize: 5,
+	})
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	blob := binary.BigEndian.AppendUint64(nil, uint64(len(header)))
+	blob = append(blob, header...)
+	blob = append(blob, "hello"...)
+
+	archiveBuf := &bytes.Buffer{}
+	exported, err := Export(context.Background(), log.DefaultLogger, &memoryDownloadClient{blob: blob}, nil, "key", archiveBuf)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if exported.Size != int64(len(blob)) {
+		t.Errorf("exported size: got %d, want %d", exported.Size, len(blob))
+	}
+
+	uploadClient := &memoryUploadClient{blocks: map[string][]byte{}}
+	imported, err := Import(context.Background(), archiveBuf, uploadClient, nil)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if imported.Key != "key" {
+		t.Errorf("imported key: got %q, want %q", imported.Key, "key")
+	}
+	if !bytes.Equal(uploadClient.committed, blob) {
+		t.Errorf("committed blob mismatch: got %q, want %q", uploadClient.committed, blob)
+	}
+}
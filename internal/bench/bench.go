@@ -0,0 +1,193 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+	"golang.org/x/sync/errgroup"
+)
+
+// ServeFunc runs a gocica process reading the requests from r and writing the responses to w until r is closed.
+type ServeFunc func(w io.Writer, r io.Reader) error
+
+// Round is a run of a gocica process with the workload.
+type Round struct {
+	Name string
+	// Put puts the outputs that are missed, as the go command does on a cold build.
+	Put bool
+	// Concurrency is the number of requests in flight, like the parallel actions of the go command.
+	Concurrency int
+}
+
+// RoundResult is the measurement of a round.
+type RoundResult struct {
+	Name string `json:"name"`
+	// Duration is the wall time from the first request to the response to close, which includes the commit of the remote cache.
+	Duration time.Duration `json:"duration_ns"`
+	// Close is the time the close request took.
+	Close  time.Duration `json:"close_ns"`
+	Gets   int64         `json:"gets"`
+	Hits   int64         `json:"hits"`
+	Puts   int64         `json:"puts"`
+	Errors int64         `json:"errors"`
+	// Bytes is the size of the outputs hit and put.
+	Bytes          int64                    `json:"bytes"`
+	OpsPerSecond   float64                  `json:"ops_per_second"`
+	BytesPerSecond float64                  `json:"bytes_per_second"`
+	Latencies      []metrics.LatencySummary `json:"latencies"`
+}
+
+// Run runs a round of the workload on a process served by serve.
+func Run(ctx context.Context, logger log.Logger, workload *Workload, round Round, serve ServeFunc) (*RoundResult, error) {
+	if err := workload.validate(); err != nil {
+		return nil, fmt.Errorf("invalid workload: %w", err)
+	}
+
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	serveErr := make(chan error, 1)
+	go func() {
+		err := serve(resW, reqR)
+		_ = reqR.CloseWithError(errors.New("process exited"))
+		_ = resW.Close()
+		serveErr <- err
+	}()
+
+	c := newClient(reqW, resR)
+	latencies := metrics.NewHistogram("bench_" + round.Name)
+	result := &RoundResult{Name: round.Name}
+
+	var (
+		next                           atomic.Int64
+		gets, hits, puts, errs, nbytes atomic.Int64
+	)
+	start := time.Now()
+	eg, egCtx := errgroup.WithContext(ctx)
+	for range max(round.Concurrency, 1) {
+		eg.Go(func() error {
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= workload.Actions {
+					return nil
+				}
+				action := workload.Action(i)
+
+				reqStart := time.Now()
+				res, err := c.do(egCtx, &protocol.Request{Command: protocol.CmdGet, ActionID: action.ActionID}, nil)
+				if err != nil {
+					return fmt.Errorf("get: %w", err)
+				}
+				latencies.Observe(time.Since(reqStart), "get")
+				gets.Add(1)
+
+				switch {
+				case res.Err != "":
+					errs.Add(1)
+					logger.Warnf("get %s: %s", action.ActionID, res.Err)
+					continue
+				case !res.Miss && res.OutputID == action.OutputID:
+					hits.Add(1)
+					nbytes.Add(res.Size)
+					continue
+				case !res.Miss:
+					errs.Add(1)
+					logger.Warnf("get %s: got output %s, want %s", action.ActionID, res.OutputID, action.OutputID)
+					continue
+				case !round.Put:
+					continue
+				}
+
+				reqStart = time.Now()
+				res, err = c.do(egCtx, &protocol.Request{
+					Command:  protocol.CmdPut,
+					ActionID: action.ActionID,
+					OutputID: action.OutputID,
+				}, action.Body)
+				if err != nil {
+					return fmt.Errorf("put: %w", err)
+				}
+				latencies.Observe(time.Since(reqStart), "put")
+				puts.Add(1)
+
+				if res.Err != "" {
+					errs.Add(1)
+					logger.Warnf("put %s: %s", action.ActionID, res.Err)
+					continue
+				}
+				nbytes.Add(int64(len(action.Body)))
+			}
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		_ = reqW.CloseWithError(err)
+		return nil, errors.Join(err, <-serveErr)
+	}
+
+	closeStart := time.Now()
+	res, err := c.do(ctx, &protocol.Request{Command: protocol.CmdClose}, nil)
+	if err != nil {
+		_ = reqW.CloseWithError(err)
+		return nil, errors.Join(fmt.Errorf("close: %w", err), <-serveErr)
+	}
+	result.Close = time.Since(closeStart)
+	result.Duration = time.Since(start)
+	if res.Err != "" {
+		errs.Add(1)
+		logger.Warnf("close: %s", res.Err)
+	}
+
+	_ = reqW.Close()
+	if err := <-serveErr; err != nil {
+		return nil, fmt.Errorf("serve: %w", err)
+	}
+
+	result.Gets = gets.Load()
+	result.Hits = hits.Load()
+	result.Puts = puts.Load()
+	result.Errors = errs.Load()
+	result.Bytes = nbytes.Load()
+	result.OpsPerSecond = float64(result.Gets+result.Puts) / result.Duration.Seconds()
+	result.BytesPerSecond = float64(result.Bytes) / result.Duration.Seconds()
+	result.Latencies = latencies.Summaries()
+
+	return result, nil
+}
+
+// Log logs the result in the format of the run summary.
+func (r *RoundResult) Log(logger log.Logger) {
+	logger.Infof("%s: %d gets (%d hits), %d puts, %d errors in %s (close %s)",
+		r.Name, r.Gets, r.Hits, r.Puts, r.Errors, r.Duration.Round(time.Millisecond), r.Close.Round(time.Millisecond))
+	logger.Infof("%s: %.0f ops/s, %.1fMiB/s", r.Name, r.OpsPerSecond, r.BytesPerSecond/(1<<20))
+	for _, latency := range r.Latencies {
+		logger.Infof("%s latency %s: count=%d p50=%s p95=%s p99=%s max=%s",
+			r.Name, latency.Label, latency.Count, latency.P50, latency.P95, latency.P99, latency.Max)
+	}
+}
+
+// WriteFile writes the results as JSON to path.
+func WriteFile(path string, results []*RoundResult) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create result file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close result file: %w", closeErr)
+		}
+	}()
+
+	if err := json.NewEncoder(f).Encode(results); err != nil {
+		return fmt.Errorf("encode results: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// memoryStore is a cache in memory served through the protocol, standing in for the backends.
+type memoryStore struct {
+	locker  sync.Mutex
+	outputs map[string]*protocol.Response
+}
+
+func (s *memoryStore) serve(w io.Writer, r io.Reader) error {
+	process := protocol.NewProcess(
+		protocol.WithLogger(log.DefaultLogger),
+		protocol.WithGetHandler(func(_ context.Context, req *protocol.Request, res *protocol.Response) error {
+			s.locker.Lock()
+			defer s.locker.Unlock()
+
+			stored, ok := s.outputs[req.ActionID]
+			if !ok {
+				res.Miss = true
+				return nil
+			}
+			res.OutputID = stored.OutputID
+			res.Size = stored.Size
+
+			return nil
+		}),
+		protocol.WithPutHandler(func(_ context.Context, req *protocol.Request, _ *protocol.Response) error {
+			var size int64
+			if req.Body != nil {
+				n, err := io.Copy(io.Discard, req.Body)
+				if err != nil {
+					return err
+				}
+				size = n
+			}
+
+			s.locker.Lock()
+			defer s.locker.Unlock()
+			s.outputs[req.ActionID] = &protocol.Response{OutputID: req.OutputID, Size: size}
+
+			return nil
+		}),
+	)
+
+	return process.Serve(w, r)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	workload := &Workload{
+		Actions:      50,
+		MinSize:      0,
+		MaxSize:      64 << 10,
+		Distribution: DistributionLogUniform,
+		Seed:         1,
+	}
+	store := &memoryStore{outputs: map[string]*protocol.Response{}}
+	logger := log.DefaultLogger
+
+	cold, err := Run(t.Context(), logger, workload, Round{Name: "cold", Put: true, Concurrency: 4}, store.serve)
+	if err != nil {
+		t.Fatalf("cold round: %v", err)
+	}
+	if cold.Gets != 50 || cold.Hits != 0 || cold.Puts != 50 || cold.Errors != 0 {
+		t.Errorf("cold round: gets=%d hits=%d puts=%d errors=%d", cold.Gets, cold.Hits, cold.Puts, cold.Errors)
+	}
+
+	warm, err := Run(t.Context(), logger, workload, Round{Name: "warm", Concurrency: 4}, store.serve)
+	if err != nil {
+		t.Fatalf("warm round: %v", err)
+	}
+	if warm.Gets != 50 || warm.Hits != 50 || warm.Puts != 0 || warm.Errors != 0 {
+		t.Errorf("warm round: gets=%d hits=%d puts=%d errors=%d", warm.Gets, warm.Hits, warm.Puts, warm.Errors)
+	}
+	if warm.Bytes != cold.Bytes {
+		t.Errorf("warm round hit %d bytes, want the %d bytes put", warm.Bytes, cold.Bytes)
+	}
+}
+
+func TestWorkload_Action(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		workload Workload
+	}{
+		{
+			name:     "log-uniform",
+			workload: Workload{Actions: 100, MinSize: 0, MaxSize: 1 << 20, Distribution: DistributionLogUniform, Seed: 1},
+		},
+		{
+			name:     "uniform",
+			workload: Workload{Actions: 100, MinSize: 10, MaxSize: 1000, Distribution: DistributionUniform, Seed: 2},
+		},
+		{
+			name:     "fixed",
+			workload: Workload{Actions: 100, MinSize: 0, MaxSize: 100, Distribution: DistributionFixed, Seed: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.workload.validate(); err != nil {
+				t.Fatalf("invalid workload: %v", err)
+			}
+
+			actionIDs := map[string]struct{}{}
+			for i := range tt.workload.Actions {
+				action := tt.workload.Action(i)
+				size := int64(len(action.Body))
+				if size < tt.workload.MinSize || size > tt.workload.MaxSize {
+					t.Errorf("action %d: size %d out of [%d, %d]", i, size, tt.workload.MinSize, tt.workload.MaxSize)
+				}
+				if tt.workload.Distribution == DistributionFixed && size != tt.workload.MaxSize {
+					t.Errorf("action %d: size %d, want %d", i, size, tt.workload.MaxSize)
+				}
+				actionIDs[action.ActionID] = struct{}{}
+
+				// The same seed generates the same action.
+				again := tt.workload.Action(i)
+				if again.ActionID != action.ActionID || again.OutputID != action.OutputID {
+					t.Errorf("action %d is not reproducible", i)
+				}
+			}
+			if len(actionIDs) != tt.workload.Actions {
+				t.Errorf("got %d unique action IDs, want %d", len(actionIDs), tt.workload.Actions)
+			}
+		})
+	}
+}
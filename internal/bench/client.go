@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// client talks to a gocica process like the go command does: requests may be in flight concurrently,
+// and their responses come back in any order.
+type client struct {
+	writeLocker sync.Mutex
+	w           *bufio.Writer
+
+	nextID        atomic.Int64
+	pendingLocker sync.Mutex
+	pending       map[int64]chan *protocol.Response
+	// done is closed when the responses cannot be read anymore, with the reason in err.
+	done chan struct{}
+	err  error
+}
+
+func newClient(w io.Writer, r io.Reader) *client {
+	c := &client{
+		w:       bufio.NewWriter(w),
+		pending: map[int64]chan *protocol.Response{},
+		done:    make(chan struct{}),
+	}
+
+	go c.readResponses(r)
+
+	return c
+}
+
+func (c *client) readResponses(r io.Reader) {
+	defer close(c.done)
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var res protocol.Response
+		if err := dec.Decode(&res); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			c.err = fmt.Errorf("decode response: %w", err)
+			return
+		}
+
+		// The response with ID 0 announces the known commands.
+		if res.ID == 0 {
+			continue
+		}
+
+		c.pendingLocker.Lock()
+		ch, ok := c.pending[res.ID]
+		delete(c.pending, res.ID)
+		c.pendingLocker.Unlock()
+		if ok {
+			ch <- &res
+		}
+	}
+}
+
+// do sends a request with body, if any, and waits for its response.
+func (c *client) do(ctx context.Context, req *protocol.Request, body []byte) (*protocol.Response, error) {
+	req.ID = c.nextID.Add(1)
+	req.BodySize = int64(len(body))
+
+	ch := make(chan *protocol.Response, 1)
+	c.pendingLocker.Lock()
+	c.pending[req.ID] = ch
+	c.pendingLocker.Unlock()
+
+	if err := c.send(req, body); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send writes a request and its body as a base64 encoded JSON string on the next line.
+func (c *client) send(req *protocol.Request, body []byte) error {
+	// The request must be a single line followed by the body, so it is not written by json.Encoder, which adds an empty line.
+	line, err := stdjson.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+	line = append(line, '\n')
+
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+
+	if _, err := c.w.Write(line); err != nil {
+		return fmt.Errorf("write request: %w", err)
+	}
+
+	if len(body) > 0 {
+		encoded := make([]byte, base64.StdEncoding.EncodedLen(len(body))+3)
+		encoded[0] = '"'
+		base64.StdEncoding.Encode(encoded[1:], body)
+		encoded[len(encoded)-2] = '"'
+		encoded[len(encoded)-1] = '\n'
+		if _, err := c.w.Write(encoded); err != nil {
+			return fmt.Errorf("write body: %w", err)
+		}
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("flush request: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Package bench drives a gocica process with a synthetic workload of the go command and measures it.
+package bench
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// Distribution is the distribution of the output sizes of a workload.
+type Distribution string
+
+const (
+	// DistributionLogUniform makes small outputs as common as large ones in every order of magnitude,
+	// which is close to the outputs of a Go build: many small packages and a few large ones.
+	DistributionLogUniform Distribution = "log-uniform"
+	DistributionUniform    Distribution = "uniform"
+	// DistributionFixed makes every output MaxSize bytes.
+	DistributionFixed Distribution = "fixed"
+)
+
+// Workload is a set of actions generated from a seed, so that the same workload can be replayed against other builds or backends.
+type Workload struct {
+	Actions      int
+	MinSize      int64
+	MaxSize      int64
+	Distribution Distribution
+	Seed         uint64
+}
+
+func (w *Workload) validate() error {
+	switch {
+	case w.Actions <= 0:
+		return fmt.Errorf("actions must be positive: %d", w.Actions)
+	case w.MinSize < 0:
+		return fmt.Errorf("min size must not be negative: %d", w.MinSize)
+	case w.MaxSize < w.MinSize:
+		return fmt.Errorf("max size %d is smaller than min size %d", w.MaxSize, w.MinSize)
+	}
+
+	switch w.Distribution {
+	case DistributionLogUniform, DistributionUniform, DistributionFixed:
+	default:
+		return fmt.Errorf("unknown distribution: %s", w.Distribution)
+	}
+
+	return nil
+}
+
+// Action is an action of the workload with its output.
+type Action struct {
+	ActionID string
+	OutputID string
+	Body     []byte
+}
+
+// Action returns the i-th action of the workload. The body is random, so it does not compress.
+func (w *Workload) Action(i int) *Action {
+	//nolint:gosec
+	rng := rand.New(rand.NewPCG(w.Seed, uint64(i)))
+
+	body := make([]byte, w.size(rng))
+	for j := 0; j < len(body); j += 8 {
+		var word [8]byte
+		binary.LittleEndian.PutUint64(word[:], rng.Uint64())
+		copy(body[j:], word[:])
+	}
+
+	var key [16]byte
+	binary.BigEndian.PutUint64(key[:8], w.Seed)
+	binary.BigEndian.PutUint64(key[8:], uint64(i))
+	actionID := sha256.Sum256(key[:])
+	outputID := sha256.Sum256(body)
+
+	return &Action{
+		ActionID: hex.EncodeToString(actionID[:]),
+		OutputID: hex.EncodeToString(outputID[:]),
+		Body:     body,
+	}
+}
+
+func (w *Workload) size(rng *rand.Rand) int64 {
+	if w.MaxSize == w.MinSize {
+		return w.MinSize
+	}
+
+	switch w.Distribution {
+	case DistributionUniform:
+		return w.MinSize + rng.Int64N(w.MaxSize-w.MinSize+1)
+	case DistributionLogUniform:
+		// Sizes are shifted by one so that a min size of 0 is allowed.
+		lo, hi := math.Log(float64(w.MinSize+1)), math.Log(float64(w.MaxSize+1))
+		size := int64(math.Exp(lo+rng.Float64()*(hi-lo))) - 1
+		return min(max(size, w.MinSize), w.MaxSize)
+	default:
+		return w.MaxSize
+	}
+}
@@ -2,27 +2,67 @@ package cacheprog
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mazrean/gocica/internal/admission"
+	"github.com/mazrean/gocica/internal/closer"
 	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/dedup"
 	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
 	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/pkg/syncpause"
+	"github.com/mazrean/gocica/internal/policy"
 	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
 	"github.com/mazrean/gocica/internal/remote"
 	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+	"github.com/mazrean/gocica/stream"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Backend interface {
 	Get(ctx context.Context, actionID string) (diskPath string, metaData *MetaData, err error)
-	Put(ctx context.Context, actionID, outputID string, size int64, body myio.ClonableReadSeeker) (diskPath string, err error)
+	// Put stores the output of the action. ttl is the expiration hint of the toolchain, 0 without one.
+	Put(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body stream.ClonableReadSeeker) (diskPath string, err error)
 	Close(ctx context.Context) error
 }
 
+// Reasons of a DegradedError.
+const (
+	// DegradedUploadCanceled is the reason of a put after the uploads were abandoned at the commit deadline.
+	DegradedUploadCanceled = "upload_canceled"
+	// DegradedUploadFailed is the reason of a put whose upload failed.
+	DegradedUploadFailed = "upload_failed"
+	// DegradedConflict is the reason of a put of an output uploaded with another size, whose entry is not committed.
+	DegradedConflict = "conflict"
+)
+
+// DegradedError is returned by Put with the disk path of an output written to the local cache but not to the remote one.
+// The put is still a success for the go command, which reads the output from the disk path.
+type DegradedError struct {
+	Reason string
+	Err    error
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("remote degraded (%s): %v", e.Reason, e.Err)
+}
+
+func (e *DegradedError) Unwrap() error {
+	return e.Err
+}
+
 type MetaData struct {
 	// OutputID is the unique identifier for the object.
 	OutputID string
@@ -30,41 +70,115 @@ type MetaData struct {
 	Size int64
 	// Timenano is the time the object was created in Unix nanoseconds.
 	Timenano int64
+	// ExpiresNanos is the time the object expires in Unix nanoseconds, or 0 when it never does.
+	ExpiresNanos int64
+}
+
+// expiresAt returns the time an output put now with the TTL expires, or nil when it never does.
+func expiresAt(ttl time.Duration) *timestamppb.Timestamp {
+	t := policy.CurrentExpiry().ExpiresAt(time.Now(), ttl)
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+// expired reports whether the entry has expired at now.
+func expired(indexEntry *v1.IndexEntry, now time.Time) bool {
+	return indexEntry.GetExpiresAt() != nil && !indexEntry.GetExpiresAt().AsTime().After(now)
+}
+
+// expiresNanos returns the expiry of the entry in Unix nanoseconds, or 0 when it never expires.
+func expiresNanos(indexEntry *v1.IndexEntry) int64 {
+	if indexEntry.GetExpiresAt() == nil {
+		return 0
+	}
+
+	return indexEntry.GetExpiresAt().AsTime().UnixNano()
 }
 
 var _ Backend = &ConbinedBackend{}
 
+// errSyncPaused cancels the uploads when the run ends while the sync with the remote cache is paused.
+var errSyncPaused = errors.New("the sync with the remote cache is paused")
+
 var (
-	requestGauge  = metrics.NewGauge("backend_request")
-	durationGauge = metrics.NewGauge("backend_duration")
-	cacheHitGauge = metrics.NewGauge("backend_cache_hit")
+	requestGauge      = metrics.NewGauge("backend_request")
+	durationHistogram = metrics.NewHistogram("backend_duration")
+	cacheHitGauge     = metrics.NewGauge("backend_cache_hit")
 )
 
+// pendingWait is how long a get waits for an output the restoration of the remote cache has not written yet, as a time.Duration.
+var pendingWait atomic.Int64
+
+// SetPendingWait sets how long a get waits for an output the restoration of the remote cache has not written yet,
+// before it is answered as a miss. 0 answers it at once.
+func SetPendingWait(wait time.Duration) {
+	pendingWait.Store(int64(wait))
+}
+
+// CommitTimeout bounds the time Close takes to finish the uploads and commit the remote cache.
+// Zero disables the bound.
+type CommitTimeout time.Duration
+
+// closeTimeout is the hard bound of Close, as a time.Duration. 0 leaves Close to the commit timeout.
+var closeTimeout atomic.Int64
+
+// SetCloseTimeout sets the hard bound of Close. Close returns once it is over, even with the backends not closed yet,
+// so the commit timeout should be at most it for the commit to be done by then.
+func SetCloseTimeout(timeout time.Duration) {
+	closeTimeout.Store(int64(timeout))
+}
+
+// maxCommitReserve is the largest part of the commit timeout reserved for the commit request itself.
+const maxCommitReserve = 30 * time.Second
+
 type ConbinedBackend struct {
 	logger log.Logger
 
+	commitTimeout time.Duration
+	// uploadCtx is canceled when the uploads still running at the commit deadline are abandoned, or when the process shuts down.
+	uploadCtx     context.Context
+	cancelUploads context.CancelCauseFunc
+
 	local  local.Backend
 	remote remote.Backend
 
-	objectMapLocker sync.Mutex
-	objectMap       map[string]struct{}
-
-	eg                   *errgroup.Group
-	nowTimestamp         *timestamppb.Timestamp
-	metaDataMap          map[string]*v1.IndexEntry
-	newMetaDataMapLocker sync.Mutex
-	newMetaDataMap       map[string]*v1.IndexEntry
+	// uploads dedups the uploads of the outputs in the remote cache or put in this run.
+	// It and the metadata maps are built on sync.Maps, as every get and put of a parallel build touches them.
+	uploads dedup.Uploads
+
+	eg           *errgroup.Group
+	nowTimestamp *timestamppb.Timestamp
+	metaDataMap  map[string]*v1.IndexEntry
+	// newMetaDataMap has the entries to commit, the recent remote ones and the ones put in this run, as map[string]*v1.IndexEntry.
+	newMetaDataMap sync.Map
+	// usedMetaDataMap has the remote entries hit in this run, as map[string]*v1.IndexEntry.
+	// Their LastUsedAt is updated at Close, so that a get does not write an entry other gets read.
+	usedMetaDataMap sync.Map
+	// materializeGroup dedups the writes of an inline output to the local disk.
+	materializeGroup singleflight.Group
+	// accessOrders has the rank of the first get of each action in this run, from 1, as map[string]int64.
+	// The next run restores the outputs in this order, so that the first steps of the build find theirs on disk.
+	accessOrders sync.Map
+	accesses     atomic.Int64
+	// hitOutputs are the outputs hit in this run, as map[string]struct{}.
+	// A hit answers the path of the output on the disk, so the ones hit again are only counted:
+	// the go command reads them from the page cache of the OS, which no cache of gocica in memory would save.
+	hitOutputs sync.Map
 }
 
-func NewConbinedBackend(logger log.Logger, local local.Backend, remote remote.Backend) (*ConbinedBackend, error) {
+func NewConbinedBackend(logger log.Logger, local local.Backend, remote remote.Backend, commitTimeout CommitTimeout) (*ConbinedBackend, error) {
 	conbined := &ConbinedBackend{
-		logger:       logger,
-		eg:           &errgroup.Group{},
-		objectMap:    map[string]struct{}{},
-		local:        local,
-		remote:       remote,
-		nowTimestamp: timestamppb.Now(),
+		logger:        logger,
+		commitTimeout: time.Duration(commitTimeout),
+		eg:            &errgroup.Group{},
+		local:         local,
+		remote:        remote,
+		nowTimestamp:  timestamppb.Now(),
 	}
+	conbined.uploadCtx, conbined.cancelUploads = context.WithCancelCause(lifecycle.Context())
 
 	conbined.start()
 
@@ -73,37 +187,59 @@ func NewConbinedBackend(logger log.Logger, local local.Backend, remote remote.Ba
 
 func (cb *ConbinedBackend) start() {
 	var err error
-	cb.metaDataMap, err = cb.remote.MetaData(context.Background())
+	cb.metaDataMap, err = cb.remote.MetaData(lifecycle.Context())
 	if err != nil {
 		cb.logger.Warnf("parse remote metadata: %v. ignore the all remote cache.", err)
 	}
 	if cb.metaDataMap == nil {
 		cb.metaDataMap = map[string]*v1.IndexEntry{}
 	}
+	report.RemoteEntries.Add(int64(len(cb.metaDataMap)))
 
 	for _, indexEntry := range cb.metaDataMap {
-		cb.objectMap[indexEntry.OutputId] = struct{}{}
+		cb.uploads.Known(indexEntry.OutputId)
 	}
 
-	cb.newMetaDataMap = make(map[string]*v1.IndexEntry, len(cb.metaDataMap))
-	metaLimitLastUsedAt := time.Now().Add(-time.Hour * 24 * 7)
+	// The pinned entries are kept however long they are unused, and even when they have expired.
+	now := time.Now()
+	metaLimitLastUsedAt := now.Add(-time.Hour * 24 * 7)
+	var pinned, expiredEntries int
 	for actionID, metaData := range cb.metaDataMap {
+		if expired(metaData, now) && !policy.Pinned(actionID, metaData.OutputId) {
+			expiredEntries++
+			continue
+		}
 		if metaData.LastUsedAt.AsTime().After(metaLimitLastUsedAt) {
-			cb.newMetaDataMap[actionID] = metaData
+			cb.newMetaDataMap.Store(actionID, metaData)
+		} else if policy.Pinned(actionID, metaData.OutputId) {
+			cb.newMetaDataMap.Store(actionID, metaData)
+			pinned++
 		}
 	}
+	if pinned > 0 {
+		cb.logger.Debugf("kept %d pinned entries unused for more than a week", pinned)
+	}
+	if expiredEntries > 0 {
+		cb.logger.Debugf("dropped %d expired entries", expiredEntries)
+	}
 }
 
 func (cb *ConbinedBackend) Get(ctx context.Context, actionID string) (diskPath string, metaData *MetaData, err error) {
 	requestGauge.Set(1, "get")
 	defer requestGauge.Set(0, "get")
 
-	durationGauge.Stopwatch(func() {
-		indexEntry, ok := cb.metaDataMap[actionID]
+	durationHistogram.Stopwatch(func() {
+		cb.recordAccess(actionID)
+
+		indexEntry, ok := cb.lookup(actionID)
 		if !ok {
 			cacheHitGauge.Set(0, "meta_miss")
 			return
 		}
+		if expired(indexEntry, time.Now()) && !policy.Pinned(actionID, indexEntry.OutputId) {
+			cacheHitGauge.Set(0, "expired")
+			return
+		}
 
 		diskPath, err = cb.local.Get(ctx, indexEntry.OutputId)
 		if err != nil {
@@ -111,22 +247,55 @@ func (cb *ConbinedBackend) Get(ctx context.Context, actionID string) (diskPath s
 			return
 		}
 
+		if diskPath == "" && remote.IsInline(indexEntry) {
+			diskPath, err = cb.materialize(ctx, indexEntry)
+			if err != nil {
+				err = fmt.Errorf("materialize inline output: %w", err)
+				return
+			}
+		}
+
+		onDemand, _ := cb.remote.(remote.OnDemandBackend)
+		if diskPath == "" && onDemand != nil {
+			// A failed fetch is only a miss, which the go command rebuilds.
+			diskPath, err = onDemand.Fetch(ctx, indexEntry.OutputId)
+			if err != nil {
+				cb.logger.Warnf("fetch remote cache: %v", err)
+				diskPath, err = "", nil
+			}
+		}
+
 		if diskPath == "" {
-			cacheHitGauge.Set(0, "local_miss")
-			return
+			pending, ok := cb.remote.(remote.PendingBackend)
+			if !ok || !pending.Pending(indexEntry.OutputId) {
+				cacheHitGauge.Set(0, "local_miss")
+				return
+			}
+
+			diskPath = cb.waitPending(ctx, pending, indexEntry)
+			if diskPath == "" {
+				cacheHitGauge.Set(0, "pending_miss")
+				return
+			}
 		}
 
-		cb.newMetaDataMapLocker.Lock()
-		defer cb.newMetaDataMapLocker.Unlock()
-		indexEntry.LastUsedAt = cb.nowTimestamp
-		cb.newMetaDataMap[actionID] = indexEntry
+		cb.usedMetaDataMap.Store(actionID, indexEntry)
+		admission.Record(actionID)
+		if onDemand != nil {
+			onDemand.Used(indexEntry.OutputId)
+		}
+		if _, loaded := cb.hitOutputs.LoadOrStore(indexEntry.OutputId, struct{}{}); loaded {
+			report.RepeatedHits.Add(1)
+			report.RepeatedHitBytes.Add(indexEntry.Size)
+		}
 
 		cacheHitGauge.Set(1, "hit")
 
 		metaData = &MetaData{
-			OutputID: indexEntry.OutputId,
-			Size:     indexEntry.Size,
-			Timenano: indexEntry.Timenano,
+			OutputID:     indexEntry.OutputId,
+			Size:         indexEntry.Size,
+			Timenano:     indexEntry.Timenano,
+			ExpiresNanos: expiresNanos(indexEntry),
 		}
 		err = nil
 	}, "get")
@@ -134,65 +303,155 @@ func (cb *ConbinedBackend) Get(ctx context.Context, actionID string) (diskPath s
 	return diskPath, metaData, err
 }
 
-func (cb *ConbinedBackend) Put(ctx context.Context, actionID, outputID string, size int64, body myio.ClonableReadSeeker) (diskPath string, err error) {
+// waitPending waits up to the pending wait for the output the restoration of the remote cache has not written yet,
+// and returns its path, or "" for a pending miss.
+// A pending miss is told apart from the others, as it points to the order of the restoration rather than a cold cache.
+func (cb *ConbinedBackend) waitPending(ctx context.Context, pending remote.PendingBackend, indexEntry *v1.IndexEntry) string {
+	if wait := time.Duration(pendingWait.Load()); wait > 0 {
+		diskPath, err := pending.WaitPending(ctx, indexEntry.OutputId, wait)
+		if err != nil {
+			cb.logger.Debugf("wait for the restoration of output %s: %v", indexEntry.OutputId, err)
+		}
+		if diskPath != "" {
+			report.PendingWaitHits.Add(1)
+			return diskPath
+		}
+	}
+
+	report.PendingMisses.Add(1)
+	report.PendingMissBytes.Add(indexEntry.Size)
+
+	return ""
+}
+
+// recordAccess records the rank of the first get of the action in this run.
+// A miss is recorded too, as the output put for it is looked up at the same step of the next build.
+func (cb *ConbinedBackend) recordAccess(actionID string) {
+	// Concurrent first gets may skip a rank, which keeps the order.
+	if _, ok := cb.accessOrders.Load(actionID); !ok {
+		cb.accessOrders.LoadOrStore(actionID, cb.accesses.Add(1))
+	}
+}
+
+// lookup returns the entry of the action. An entry put in this run takes precedence over the remote one,
+// so that a daemon serves the go commands with the outputs put by the ones before them.
+func (cb *ConbinedBackend) lookup(actionID string) (*v1.IndexEntry, bool) {
+	if value, ok := cb.newMetaDataMap.Load(actionID); ok {
+		//nolint:forcetypeassert
+		return value.(*v1.IndexEntry), true
+	}
+
+	indexEntry, ok := cb.metaDataMap[actionID]
+	return indexEntry, ok
+}
+
+func (cb *ConbinedBackend) Put(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body stream.ClonableReadSeeker) (diskPath string, err error) {
 	requestGauge.Set(1, "put")
 	defer requestGauge.Set(0, "put")
 
-	durationGauge.Stopwatch(func() {
+	if size <= remote.MaxInlineSize {
+		durationHistogram.Stopwatch(func() {
+			diskPath, err = cb.putInline(ctx, actionID, outputID, size, ttl, body)
+		}, "put_inline")
+
+		return diskPath, err
+	}
+
+	// The kind is recorded in the entry, for the download filters of the later runs.
+	kind, sniffErr := sniff(body, size)
+	if sniffErr != nil {
+		membuf.Release(body)
+		return "", fmt.Errorf("sniff: %w", sniffErr)
+	}
+
+	if p := policy.Current(); p != nil && !policy.Pinned(actionID, outputID) {
+		if !p.Allow(kind, size) {
+			durationHistogram.Stopwatch(func() {
+				diskPath, err = cb.putLocal(ctx, outputID, size, body)
+			}, "put_local")
+			report.PolicySkipped.Add(1)
+			report.PolicySkippedBytes.Add(size)
+
+			return diskPath, err
+		}
+	}
+
+	// An output of an action never seen before is not uploaded until the action is seen again, unless it is pinned.
+	if !admission.Admit(actionID, size) && !policy.Pinned(actionID, outputID) {
+		durationHistogram.Stopwatch(func() {
+			diskPath, err = cb.putLocal(ctx, outputID, size, body)
+		}, "put_local")
+		report.AdmissionRejected.Add(1)
+		report.AdmissionRejectedBytes.Add(size)
+
+		return diskPath, err
+	}
+
+	// The body is released once both the local copy and the remote upload are done with it.
+	var pending atomic.Int32
+	pending.Store(2)
+	done := func() {
+		if pending.Add(-1) == 0 {
+			membuf.Release(body)
+		}
+	}
+
+	durationHistogram.Stopwatch(func() {
 		indexEntry := &v1.IndexEntry{
 			OutputId:   outputID,
 			Size:       size,
 			Timenano:   time.Now().UnixNano(),
 			LastUsedAt: cb.nowTimestamp,
+			ExpiresAt:  expiresAt(ttl),
+			Kind:       string(kind),
 		}
 
-		func() {
-			cb.newMetaDataMapLocker.Lock()
-			defer cb.newMetaDataMapLocker.Unlock()
-			cb.newMetaDataMap[actionID] = indexEntry
-		}()
-
-		var ok bool
-		func() {
-			cb.objectMapLocker.Lock()
-			defer cb.objectMapLocker.Unlock()
-
-			_, ok = cb.objectMap[outputID]
-			if !ok {
-				cb.objectMap[outputID] = struct{}{}
-			}
-		}()
-		if ok {
-			diskPath, err = cb.local.Get(ctx, outputID)
-			if err != nil {
-				err = fmt.Errorf("get local cache: %w", err)
-				return
-			}
+		cb.newMetaDataMap.Store(actionID, indexEntry)
 
-			if diskPath != "" {
-				return
-			}
+		// An output already on the local disk, e.g. put for another action, is not written again.
+		diskPath, err = cb.local.Get(ctx, outputID)
+		if err != nil {
+			membuf.Release(body)
+			err = fmt.Errorf("get local cache: %w", err)
+			return
 		}
 
 		var (
 			remoteReader io.ReadSeeker
 			localReader  io.Reader
 		)
-		if size == 0 {
+		switch {
+		case size == 0:
 			remoteReader = myio.EmptyReader
 			localReader = myio.EmptyReader
-		} else {
+		case diskPath != "":
+			remoteReader = body
+		default:
 			remoteReader = body
 			localReader = body.Clone()
 		}
 
-		cb.eg.Go(func() error {
-			if err := cb.remote.Put(context.Background(), outputID, size, remoteReader); err != nil {
-				return fmt.Errorf("put remote cache: %w", err)
-			}
+		// The uploads were abandoned at the commit deadline, so the output is only written to the local disk.
+		if cause := context.Cause(cb.uploadCtx); cause != nil {
+			done()
+			defer func() {
+				if err == nil {
+					err = &DegradedError{Reason: DegradedUploadCanceled, Err: cause}
+				}
+			}()
+		} else {
+			cb.eg.Go(func() error {
+				defer done()
+				cb.upload(actionID, indexEntry, remoteReader)
+				return nil
+			})
+		}
 
-			return nil
-		})
+		defer done()
+
+		if diskPath != "" {
+			return
+		}
 
 		var w io.WriteCloser
 		diskPath, w, err = cb.local.Put(ctx, outputID, size)
@@ -211,33 +470,326 @@ func (cb *ConbinedBackend) Put(ctx context.Context, actionID, outputID string, s
 	return diskPath, err
 }
 
-func (cb *ConbinedBackend) Close(ctx context.Context) (err error) {
-	requestGauge.Set(1, "close")
-	defer requestGauge.Set(0, "close")
+// upload uploads the output of the entry, unless another put uploads it or it is in the remote cache.
+// A failed upload only leaves the entry out of the commit, as the put was answered once the output was written to the local disk.
+// It is recorded as a degraded put instead.
+func (cb *ConbinedBackend) upload(actionID string, indexEntry *v1.IndexEntry, r io.ReadSeeker) {
+	_, err := cb.uploads.Do(cb.uploadCtx, indexEntry.OutputId, indexEntry.Size, func() error {
+		return cb.remote.Put(cb.uploadCtx, indexEntry.OutputId, indexEntry.Size, r)
+	})
+	if err == nil {
+		return
+	}
 
-	durationGauge.Stopwatch(func() {
-		if waitErr := cb.eg.Wait(); waitErr != nil {
-			err = fmt.Errorf("wait for all tasks: %w", waitErr)
-			return
+	reason := DegradedUploadFailed
+	switch {
+	case errors.Is(err, dedup.ErrConflict):
+		reason = DegradedConflict
+		cb.newMetaDataMap.CompareAndDelete(actionID, indexEntry)
+	case cb.uploadCtx.Err() != nil:
+		reason = DegradedUploadCanceled
+	}
+	report.AddDegraded(string(protocol.CmdPut), reason, indexEntry.Size)
+
+	msg := fmt.Sprintf("remote degraded: command=put action=%s output=%s size=%d reason=%s: %v", actionID, indexEntry.OutputId, indexEntry.Size, reason, err)
+	if reason == DegradedUploadCanceled {
+		cb.logger.Debugf("%s", msg)
+	} else {
+		cb.logger.Warnf("%s", msg)
+	}
+}
+
+// putInline keeps a tiny output in its IndexEntry, so that it is neither uploaded nor downloaded as an output of the blob.
+// It is still written to the local disk, as the go command reads outputs from their disk paths.
+func (cb *ConbinedBackend) putInline(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body io.Reader) (string, error) {
+	defer membuf.Release(body)
+
+	var content []byte
+	if size > 0 {
+		content = make([]byte, size)
+		if _, err := io.ReadFull(body, content); err != nil {
+			return "", fmt.Errorf("read body: %w", err)
 		}
+	}
 
-		if writeErr := cb.remote.WriteMetaData(context.Background(), cb.newMetaDataMap); writeErr != nil {
-			err = fmt.Errorf("write remote metadata: %w", writeErr)
-			return
+	indexEntry := &v1.IndexEntry{
+		OutputId:     outputID,
+		Size:         size,
+		Timenano:     time.Now().UnixNano(),
+		LastUsedAt:   cb.nowTimestamp,
+		InlineOutput: content,
+		ExpiresAt:    expiresAt(ttl),
+		Kind:         string(policy.Sniff(content)),
+	}
+
+	// An output left out by the policy is only materialized on the local disk.
+	if p := policy.Current(); p == nil || policy.Pinned(actionID, outputID) || p.Allow(policy.Kind(indexEntry.Kind), size) {
+		cb.newMetaDataMap.Store(actionID, indexEntry)
+	} else {
+		report.PolicySkipped.Add(1)
+		report.PolicySkippedBytes.Add(size)
+	}
+
+	diskPath, err := cb.materialize(ctx, indexEntry)
+	if err != nil {
+		return "", fmt.Errorf("put: %w", err)
+	}
+
+	return diskPath, nil
+}
+
+// sniff returns the kind of the output from its first bytes, and rewinds the body.
+func sniff(body io.ReadSeeker, size int64) (policy.Kind, error) {
+	head := make([]byte, min(size, policy.SniffSize))
+	if _, err := io.ReadFull(body, head); err != nil {
+		return "", fmt.Errorf("read head: %w", err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("rewind: %w", err)
+	}
+
+	return policy.Sniff(head), nil
+}
+
+// putLocal writes an output left out of the remote cache by the policy to the local disk only.
+// No entry is committed for it, so the later runs miss its action.
+func (cb *ConbinedBackend) putLocal(ctx context.Context, outputID string, size int64, body io.Reader) (string, error) {
+	defer membuf.Release(body)
+
+	diskPath, err := cb.local.Get(ctx, outputID)
+	if err != nil {
+		return "", fmt.Errorf("get local cache: %w", err)
+	}
+	if diskPath != "" {
+		return diskPath, nil
+	}
+
+	diskPath, w, err := cb.local.Put(ctx, outputID, size)
+	if err != nil {
+		return "", fmt.Errorf("put: %w", err)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, body); err != nil {
+		return "", fmt.Errorf("copy: %w", err)
+	}
+
+	return diskPath, nil
+}
+
+// materialize writes an inline output to the local disk unless it is already there, and returns its disk path.
+func (cb *ConbinedBackend) materialize(ctx context.Context, indexEntry *v1.IndexEntry) (string, error) {
+	v, err, _ := cb.materializeGroup.Do(indexEntry.OutputId, func() (any, error) {
+		diskPath, err := cb.local.Get(ctx, indexEntry.OutputId)
+		if err != nil {
+			return "", fmt.Errorf("get local cache: %w", err)
+		}
+		if diskPath != "" {
+			return diskPath, nil
 		}
 
-		if closeErr := cb.remote.Close(ctx); closeErr != nil {
-			err = fmt.Errorf("close remote backend: %w", closeErr)
-			return
+		diskPath, w, err := cb.local.Put(ctx, indexEntry.OutputId, indexEntry.Size)
+		if err != nil {
+			return "", fmt.Errorf("put local cache: %w", err)
 		}
 
-		if closeErr := cb.local.Close(ctx); closeErr != nil {
-			err = fmt.Errorf("close backend: %w", closeErr)
-			return
+		if _, err := w.Write(indexEntry.InlineOutput); err != nil {
+			_ = w.Close()
+			return "", fmt.Errorf("write: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return "", fmt.Errorf("close: %w", err)
 		}
 
+		if err := local.RestoreMtime(diskPath, indexEntry.Timenano); err != nil {
+			cb.logger.Debugf("restore mtime: %v", err)
+		}
+
+		return diskPath, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	//nolint:forcetypeassert
+	return v.(string), nil
+}
+
+func (cb *ConbinedBackend) Close(ctx context.Context) (err error) {
+	requestGauge.Set(1, "close")
+	defer requestGauge.Set(0, "close")
+
+	durationHistogram.Stopwatch(func() {
+		// The protocol context is not bounded, so the closers bound the commit.
+		err = cb.closeWithin(context.WithoutCancel(ctx), time.Duration(closeTimeout.Load()))
+
 		requestGauge.Set(0, "close")
 	}, "close")
 
 	return err
 }
+
+// closeWithin runs the closers, and returns once timeout is over even when they have not finished, e.g. a backend ignoring the context.
+// The closers left run on until the process exits. 0 waits for them.
+func (cb *ConbinedBackend) closeWithin(ctx context.Context, timeout time.Duration) error {
+	registry := cb.closers(timeout)
+	if timeout <= 0 {
+		return registry.Close(ctx)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- registry.Close(ctx)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// The go command is not failed for it: what was committed by then stays committed.
+		cb.logger.Warnf("close did not finish within the close timeout (%s). leaving the rest of it", timeout)
+		report.AddEvent("close", fmt.Sprintf("close cut short at the close timeout (%s)", timeout))
+		return nil
+	}
+}
+
+// Steps of Close.
+const (
+	closeUploads = "wait for uploads"
+	closeCommit  = "write remote metadata"
+	closeRemote  = "close remote backend"
+	closeLocal   = "close backend"
+)
+
+// closers returns the steps of Close. The metadata is committed once the uploads are done or abandoned,
+// within the commit timeout counted from the start of Close, and the backends are closed after the commit even when it failed,
+// within closeTimeout when it is not 0.
+func (cb *ConbinedBackend) closers(closeTimeout time.Duration) *closer.Registry {
+	paused := syncpause.Paused()
+
+	registry := &closer.Registry{}
+	registry.Add(closer.Closer{
+		Name:    closeUploads,
+		Timeout: cb.commitTimeout,
+		Close: func(ctx context.Context) error {
+			if !paused {
+				cb.waitUploads(ctx)
+				return nil
+			}
+
+			// The uploads wait for the sync to be resumed, which would hold the go command until the commit timeout.
+			cb.logger.Warnf("the sync with the remote cache is paused. the outputs of this run are not committed.")
+			cb.cancelUploads(errSyncPaused)
+			if waitErr := cb.eg.Wait(); waitErr != nil {
+				cb.logger.Debugf("abandoned uploads: %v", waitErr)
+			}
+			return nil
+		},
+	})
+	registry.Add(closer.Closer{
+		Name:    closeCommit,
+		After:   []string{closeUploads},
+		Timeout: cb.commitTimeout,
+		Close: func(ctx context.Context) error {
+			if paused {
+				return nil
+			}
+			return cb.remote.WriteMetaData(ctx, cb.metaDataToCommit())
+		},
+	})
+	registry.Add(closer.Closer{
+		Name:    closeRemote,
+		After:   []string{closeCommit},
+		Timeout: closeTimeout,
+		Close:   cb.remote.Close,
+	})
+	registry.Add(closer.Closer{
+		Name:    closeLocal,
+		After:   []string{closeRemote},
+		Timeout: closeTimeout,
+		Close:   cb.local.Close,
+	})
+
+	return registry
+}
+
+// metaDataToCommit collects the entries to commit, marking the remote entries hit in this run as used now.
+// An entry put in this run for the same action takes precedence over the remote one hit.
+// The entries are cloned before they are changed, so that the metadata built again for a retried commit is the same.
+func (cb *ConbinedBackend) metaDataToCommit() map[string]*v1.IndexEntry {
+	metaDataMap := make(map[string]*v1.IndexEntry, len(cb.metaDataMap))
+	newEntries := map[string]*v1.IndexEntry{}
+	cb.newMetaDataMap.Range(func(key, value any) bool {
+		//nolint:forcetypeassert
+		newEntries[key.(string)] = value.(*v1.IndexEntry)
+		return true
+	})
+	for actionID, indexEntry := range newEntries {
+		//nolint:forcetypeassert
+		metaDataMap[actionID] = proto.Clone(indexEntry).(*v1.IndexEntry)
+	}
+
+	cb.usedMetaDataMap.Range(func(key, value any) bool {
+		//nolint:forcetypeassert
+		actionID, indexEntry := key.(string), value.(*v1.IndexEntry)
+		if current, ok := newEntries[actionID]; !ok || current == indexEntry {
+			//nolint:forcetypeassert
+			usedEntry := proto.Clone(indexEntry).(*v1.IndexEntry)
+			usedEntry.LastUsedAt = cb.nowTimestamp
+			metaDataMap[actionID] = usedEntry
+		}
+		return true
+	})
+
+	cb.setAccessOrders(metaDataMap)
+
+	return metaDataMap
+}
+
+// setAccessOrders sets the rank of the entries looked up in this run to the order of their first get.
+// The other entries keep their order from the runs before, after the ones looked up in this run.
+// The entries of metaDataMap are changed in place, so they must not be shared with the index.
+func (cb *ConbinedBackend) setAccessOrders(metaDataMap map[string]*v1.IndexEntry) {
+	accesses := cb.accesses.Load()
+	for actionID, indexEntry := range metaDataMap {
+		if value, ok := cb.accessOrders.Load(actionID); ok {
+			//nolint:forcetypeassert
+			indexEntry.AccessOrder = value.(int64)
+			continue
+		}
+		if indexEntry.AccessOrder > 0 {
+			indexEntry.AccessOrder += accesses
+		}
+	}
+}
+
+// waitUploads waits for the remote uploads until the commit deadline minus the time reserved for the commit request.
+// The uploads still running then are canceled, so that the finished ones can be committed in time.
+func (cb *ConbinedBackend) waitUploads(commitCtx context.Context) {
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		_ = cb.eg.Wait()
+	}()
+
+	var timeoutCh <-chan time.Time
+	if deadline, ok := commitCtx.Deadline(); ok {
+		reserve := min(cb.commitTimeout/4, maxCommitReserve)
+		timer := time.NewTimer(time.Until(deadline.Add(-reserve)))
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case <-doneCh:
+		return
+	case <-timeoutCh:
+	case <-commitCtx.Done():
+	}
+
+	cb.logger.Warnf("uploads did not finish before the commit deadline (%s). committing the finished ones.", cb.commitTimeout)
+	cb.cancelUploads(errors.New("commit deadline exceeded"))
+}
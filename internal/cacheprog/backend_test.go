@@ -0,0 +1,119 @@
+package cacheprog
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// hangingRemote commits at once, and its Close hangs ignoring the context until release is closed.
+type hangingRemote struct {
+	committed atomic.Bool
+	release   chan struct{}
+}
+
+func (r *hangingRemote) MetaData(context.Context) (map[string]*v1.IndexEntry, error) {
+	return map[string]*v1.IndexEntry{}, nil
+}
+
+func (r *hangingRemote) WriteMetaData(context.Context, map[string]*v1.IndexEntry) error {
+	r.committed.Store(true)
+	return nil
+}
+
+func (r *hangingRemote) Put(context.Context, string, int64, io.ReadSeeker) error {
+	return nil
+}
+
+func (r *hangingRemote) Close(context.Context) error {
+	<-r.release
+	return nil
+}
+
+func (r *hangingRemote) Capabilities() remote.Capabilities {
+	return remote.Capabilities{}
+}
+
+type nopLocal struct{}
+
+func (nopLocal) Get(context.Context, string) (string, error) {
+	return "", nil
+}
+
+func (nopLocal) Put(context.Context, string, int64) (string, io.WriteCloser, error) {
+	return "", nil, nil
+}
+
+func (nopLocal) Close(context.Context) error {
+	return nil
+}
+
+func TestConbinedBackend_closeWithin(t *testing.T) {
+	t.Parallel()
+
+	r := &hangingRemote{release: make(chan struct{})}
+	t.Cleanup(func() {
+		close(r.release)
+	})
+
+	cb, err := NewConbinedBackend(log.DefaultLogger, nopLocal{}, r, CommitTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("NewConbinedBackend() error = %v", err)
+	}
+
+	start := time.Now()
+	if err := cb.closeWithin(t.Context(), 50*time.Millisecond); err != nil {
+		t.Errorf("closeWithin() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("closeWithin() took %s, want about the close timeout", elapsed)
+	}
+	// The commit before the hanging close is done.
+	if !r.committed.Load() {
+		t.Error("the metadata was not committed")
+	}
+}
+
+func TestConbinedBackend_metaDataToCommit(t *testing.T) {
+	t.Parallel()
+
+	now := timestamppb.Now()
+	cb := &ConbinedBackend{nowTimestamp: now}
+	cb.accesses.Store(2)
+
+	recent := &v1.IndexEntry{OutputId: "recent", AccessOrder: 3}
+	put := &v1.IndexEntry{OutputId: "put"}
+	hit := &v1.IndexEntry{OutputId: "hit", AccessOrder: 5}
+	cb.newMetaDataMap.Store("recent", recent)
+	cb.newMetaDataMap.Store("put", put)
+	cb.usedMetaDataMap.Store("hit", hit)
+	cb.accessOrders.Store("put", int64(1))
+	cb.accessOrders.Store("hit", int64(2))
+
+	want := map[string]*v1.IndexEntry{
+		"recent": {OutputId: "recent", AccessOrder: 5},
+		"put":    {OutputId: "put", AccessOrder: 1},
+		"hit":    {OutputId: "hit", AccessOrder: 2, LastUsedAt: now},
+	}
+	// A retried commit builds the same metadata.
+	for range 2 {
+		got := cb.metaDataToCommit()
+		if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+			t.Errorf("metaDataToCommit() mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	// The entries of the index are not changed.
+	if recent.AccessOrder != 3 || put.AccessOrder != 0 || hit.AccessOrder != 5 || hit.LastUsedAt != nil {
+		t.Errorf("entries of the index changed: recent=%v put=%v hit=%v", recent, put, hit)
+	}
+}
@@ -1,20 +1,31 @@
 package cacheprog
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"sync/atomic"
+	"io"
+	"sync"
 
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/report"
 	"github.com/mazrean/gocica/log"
 	"github.com/mazrean/gocica/protocol"
+	"github.com/mazrean/gocica/stream"
 )
 
+// ErrChecksumMismatch is returned for a put whose body is not the content of its output ID.
+var ErrChecksumMismatch = errors.New("body checksum mismatch")
+
 type CacheProg struct {
-	logger    log.Logger
-	backend   Backend
-	hitCount  uint64
-	missCount uint64
-	putCount  uint64
+	logger  log.Logger
+	backend Backend
+	// missedActions holds the action IDs that missed, so that the size of
+	// the output put afterwards can be attributed to the miss.
+	missedActions sync.Map
 }
 
 func NewCacheProg(logger log.Logger, backend Backend) *CacheProg {
@@ -28,25 +39,54 @@ func (cp *CacheProg) Get(ctx context.Context, req *protocol.Request, res *protoc
 	}
 
 	if diskPath == "" || meta == nil {
-		atomic.AddUint64(&cp.missCount, 1)
+		report.Misses.Add(1)
+		report.RecordAction(req.ActionID, false)
+		cp.missedActions.Store(req.ActionID, struct{}{})
 		cp.logger.Debugf("action %s not found(diskPath: %s, meta: %v)", req.ActionID, diskPath, meta)
 		res.Miss = true
 		return nil
 	}
 
-	atomic.AddUint64(&cp.hitCount, 1)
+	report.Hits.Add(1)
+	report.RecordAction(req.ActionID, true)
 	cp.logger.Debugf("action %s found", req.ActionID)
 	res.DiskPath = diskPath
 	res.OutputID = meta.OutputID
 	res.Size = meta.Size
 	res.TimeNanos = meta.Timenano
+	res.ExpiresNanos = meta.ExpiresNanos
 
 	return nil
 }
 
 func (cp *CacheProg) Put(ctx context.Context, req *protocol.Request, res *protocol.Response) error {
-	atomic.AddUint64(&cp.putCount, 1)
-	diskPath, err := cp.backend.Put(ctx, req.ActionID, req.OutputID, req.BodySize, req.Body)
+	report.Puts.Add(1)
+	if _, ok := cp.missedActions.LoadAndDelete(req.ActionID); ok {
+		report.AddMiss(report.Miss{
+			ActionID: req.ActionID,
+			OutputID: req.OutputID,
+			Size:     req.BodySize,
+		})
+	}
+
+	// A body damaged on the way, e.g. by the pipe or a wrapper of the go command, is refused before it is stored,
+	// instead of being served to the later builds.
+	if err := checkBody(req.OutputID, req.BodySize, req.Body); err != nil {
+		membuf.Release(req.Body)
+		if errors.Is(err, ErrChecksumMismatch) {
+			report.PutChecksumMismatches.Add(1)
+			cp.logger.Warnf("put of action %s refused: %v", req.ActionID, err)
+		}
+		return fmt.Errorf("check body: %w", err)
+	}
+
+	diskPath, err := cp.backend.Put(ctx, req.ActionID, req.OutputID, req.BodySize, req.TTL, req.Body)
+	// The output on the local disk is a success for the go command, even when it is not in the remote cache.
+	var degradedErr *DegradedError
+	if errors.As(err, &degradedErr) && diskPath != "" {
+		res.Degraded = degradedErr.Reason
+		err = nil
+	}
 	if err != nil {
 		return fmt.Errorf("put action: %w", err)
 	}
@@ -57,13 +97,34 @@ func (cp *CacheProg) Put(ctx context.Context, req *protocol.Request, res *protoc
 }
 
 func (cp *CacheProg) Close(ctx context.Context) error {
-	cp.logger.Infof("cache hit count: %d", atomic.LoadUint64(&cp.hitCount))
-	cp.logger.Infof("cache miss count: %d", atomic.LoadUint64(&cp.missCount))
-	cp.logger.Infof("cache put count: %d", atomic.LoadUint64(&cp.putCount))
-
 	if err := cp.backend.Close(ctx); err != nil {
 		return fmt.Errorf("close backend: %w", err)
 	}
 
 	return nil
 }
+
+// checkBody checks that body, of size bytes, is the content of outputID, the base64 of its SHA-256 as given by the go command.
+// An output ID of another length is not a content hash, and the body is not checked against it.
+// body is read from a clone, and left at its position.
+func checkBody(outputID string, size int64, body stream.ClonableReadSeeker) error {
+	want, err := base64.StdEncoding.DecodeString(outputID)
+	if err != nil || len(want) != sha256.Size {
+		return nil
+	}
+
+	h := sha256.New()
+	var n int64
+	if body != nil {
+		n, err = io.Copy(h, body.Clone())
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+	}
+
+	if n != size || !bytes.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("%w: output %s, %d of %d bytes hash to %s", ErrChecksumMismatch, outputID, n, size, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package cacheprog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+	"github.com/mazrean/gocica/stream"
+)
+
+// putBackend answers every put with diskPath and err.
+type putBackend struct {
+	missBackend
+	diskPath string
+	err      error
+	called   bool
+}
+
+func (b *putBackend) Put(context.Context, string, string, int64, time.Duration, stream.ClonableReadSeeker) (string, error) {
+	b.called = true
+	return b.diskPath, b.err
+}
+
+func TestCacheProg_Put(t *testing.T) {
+	t.Parallel()
+
+	errUpload := errors.New("upload failed")
+
+	tests := []struct {
+		name           string
+		diskPath       string
+		err            error
+		expectDiskPath string
+		expectDegraded string
+		expectErr      bool
+	}{
+		{
+			name:           "success",
+			diskPath:       "/cache/o-1",
+			expectDiskPath: "/cache/o-1",
+		},
+		{
+			name:           "written locally only",
+			diskPath:       "/cache/o-1",
+			err:            &DegradedError{Reason: DegradedUploadCanceled, Err: errUpload},
+			expectDiskPath: "/cache/o-1",
+			expectDegraded: DegradedUploadCanceled,
+		},
+		{
+			name:      "degraded without the local output",
+			err:       &DegradedError{Reason: DegradedUploadCanceled, Err: errUpload},
+			expectErr: true,
+		},
+		{
+			name:      "failure",
+			diskPath:  "/cache/o-1",
+			err:       errUpload,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cp := NewCacheProg(log.DefaultLogger, &putBackend{diskPath: tt.diskPath, err: tt.err})
+
+			res := &protocol.Response{}
+			err := cp.Put(t.Context(), &protocol.Request{ActionID: "action", OutputID: "output"}, res)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, want error=%t", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if res.DiskPath != tt.expectDiskPath {
+				t.Errorf("got disk path %q, want %q", res.DiskPath, tt.expectDiskPath)
+			}
+			if res.Degraded != tt.expectDegraded {
+				t.Errorf("got degraded %q, want %q", res.Degraded, tt.expectDegraded)
+			}
+		})
+	}
+}
+
+func TestCacheProg_Put_checksum(t *testing.T) {
+	t.Parallel()
+
+	sum := sha256.Sum256([]byte("output"))
+	outputID := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		outputID  string
+		body      string
+		size      int64
+		expectErr error
+	}{
+		{name: "intact", outputID: outputID, body: "output", size: 6},
+		{name: "corrupted", outputID: outputID, body: "outpuT", size: 6, expectErr: ErrChecksumMismatch},
+		{name: "truncated", outputID: outputID, body: "outp", size: 6, expectErr: ErrChecksumMismatch},
+		{name: "not a content hash", outputID: "output", body: "other", size: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			backend := &putBackend{diskPath: "/cache/o-1"}
+			cp := NewCacheProg(log.DefaultLogger, backend)
+
+			body := stream.NewClonableReadSeeker([]byte(tt.body))
+			err := cp.Put(t.Context(), &protocol.Request{ActionID: "action", OutputID: tt.outputID, BodySize: tt.size, Body: body}, &protocol.Response{})
+			if !errors.Is(err, tt.expectErr) {
+				t.Fatalf("got error %v, want %v", err, tt.expectErr)
+			}
+			if (err == nil) != backend.called {
+				t.Errorf("backend called: %t, want %t", backend.called, err == nil)
+			}
+			// The body is handed to the backend from its start.
+			if pos, _ := body.Seek(0, io.SeekCurrent); pos != 0 {
+				t.Errorf("body read to %d before the put", pos)
+			}
+		})
+	}
+}
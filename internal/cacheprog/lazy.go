@@ -0,0 +1,136 @@
+package cacheprog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+)
+
+var _ Backend = &LazyBackend{}
+
+// errNoBackend is returned when neither the backend nor its fallback could be set up.
+var errNoBackend = errors.New("no backend is available")
+
+// LazyBackend serves the go command while the backend is set up in the background,
+// so that the API calls and the header download of the remote cache overlap with the start of the build.
+type LazyBackend struct {
+	logger log.Logger
+	// deadline is the time until which gets wait for the setup. Gets after it are misses until the setup is done.
+	deadline time.Time
+	ready    chan struct{}
+	// backend is set before ready is closed. It is nil when the fallback failed too.
+	backend Backend
+	// cancelSetup cancels the context of the setup of backend at Close. It is nil when the setup failed.
+	cancelSetup context.CancelCauseFunc
+}
+
+// NewLazyBackend starts setting up the backend with setup, and falls back to fallback when it fails.
+// The context given to setup is canceled when it fails, so that the workers it started halfway stop before the fallback.
+// Gets wait for the setup up to wait after the start, and are answered as misses after that.
+// Puts and Close wait for the setup, as their outputs would be lost otherwise.
+func NewLazyBackend(ctx context.Context, logger log.Logger, wait time.Duration, setup, fallback func(ctx context.Context) (Backend, error)) *LazyBackend {
+	lb := &LazyBackend{
+		logger:   logger,
+		deadline: time.Now().Add(wait),
+		ready:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(lb.ready)
+
+		start := time.Now()
+		setupCtx, cancel := context.WithCancelCause(ctx)
+		backend, err := setup(setupCtx)
+		if err == nil {
+			lb.logger.Debugf("backend set up in %s", time.Since(start).Round(time.Millisecond))
+			lb.backend = backend
+			lb.cancelSetup = cancel
+			return
+		}
+		cancel(fmt.Errorf("set up backend: %w", err))
+
+		backend, err = fallback(ctx)
+		if err != nil {
+			lb.logger.Errorf("failed to set up the fallback backend: %v. no cache will be used.", err)
+			return
+		}
+		lb.backend = backend
+	}()
+
+	return lb
+}
+
+// waitReady waits for the setup until ctx is done or, when untilDeadline, until the deadline of gets.
+func (lb *LazyBackend) waitReady(ctx context.Context, untilDeadline bool) (bool, error) {
+	select {
+	case <-lb.ready:
+		return true, nil
+	default:
+	}
+
+	var timeoutCh <-chan time.Time
+	if untilDeadline {
+		wait := time.Until(lb.deadline)
+		if wait <= 0 {
+			return false, nil
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case <-lb.ready:
+		return true, nil
+	case <-timeoutCh:
+		return false, nil
+	case <-ctx.Done():
+		return false, fmt.Errorf("wait for backend: %w", ctx.Err())
+	}
+}
+
+func (lb *LazyBackend) Get(ctx context.Context, actionID string) (string, *MetaData, error) {
+	ok, err := lb.waitReady(ctx, true)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		lb.logger.Debugf("backend is not set up yet. answering as a miss: actionID=%s", actionID)
+		return "", nil, nil
+	}
+	if lb.backend == nil {
+		return "", nil, nil
+	}
+
+	return lb.backend.Get(ctx, actionID)
+}
+
+func (lb *LazyBackend) Put(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body stream.ClonableReadSeeker) (string, error) {
+	if _, err := lb.waitReady(ctx, false); err != nil {
+		return "", err
+	}
+	if lb.backend == nil {
+		return "", errNoBackend
+	}
+
+	return lb.backend.Put(ctx, actionID, outputID, size, ttl, body)
+}
+
+func (lb *LazyBackend) Close(ctx context.Context) error {
+	if _, err := lb.waitReady(ctx, false); err != nil {
+		return err
+	}
+	if lb.backend == nil {
+		return nil
+	}
+	if lb.cancelSetup != nil {
+		defer lb.cancelSetup(errors.New("backend closed"))
+	}
+
+	return lb.backend.Close(ctx)
+}
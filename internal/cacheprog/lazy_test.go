@@ -0,0 +1,120 @@
+package cacheprog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+	"go.uber.org/goleak"
+)
+
+type stubBackend struct {
+	name string
+}
+
+func (b *stubBackend) Get(context.Context, string) (string, *MetaData, error) {
+	return b.name, &MetaData{}, nil
+}
+
+func (b *stubBackend) Put(context.Context, string, string, int64, time.Duration, stream.ClonableReadSeeker) (string, error) {
+	return b.name, nil
+}
+
+func (b *stubBackend) Close(context.Context) error {
+	return nil
+}
+
+func TestLazyBackend(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		wait      time.Duration
+		setupErr  error
+		wantEarly string
+		wantLate  string
+	}{
+		{name: "early get is a miss", wait: 0, wantEarly: "", wantLate: "remote"},
+		{name: "early get waits", wait: time.Minute, wantEarly: "remote", wantLate: "remote"},
+		{name: "fallback", wait: time.Minute, setupErr: errors.New("setup error"), wantEarly: "local", wantLate: "local"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			release := make(chan struct{})
+			lb := NewLazyBackend(t.Context(), log.DefaultLogger, tt.wait, func(context.Context) (Backend, error) {
+				<-release
+				if tt.setupErr != nil {
+					return nil, tt.setupErr
+				}
+				return &stubBackend{name: "remote"}, nil
+			}, func(context.Context) (Backend, error) {
+				return &stubBackend{name: "local"}, nil
+			})
+
+			earlyCh := make(chan string, 1)
+			go func() {
+				diskPath, _, err := lb.Get(t.Context(), "action")
+				if err != nil {
+					t.Errorf("early get: %v", err)
+				}
+				earlyCh <- diskPath
+			}()
+
+			if tt.wait == 0 {
+				// The early get must not wait for the setup.
+				if got := <-earlyCh; got != tt.wantEarly {
+					t.Errorf("early get: got %q, want %q", got, tt.wantEarly)
+				}
+				close(release)
+			} else {
+				close(release)
+				if got := <-earlyCh; got != tt.wantEarly {
+					t.Errorf("early get: got %q, want %q", got, tt.wantEarly)
+				}
+			}
+
+			diskPath, err := lb.Put(t.Context(), "action", "output", 0, 0, nil)
+			if err != nil {
+				t.Fatalf("put: %v", err)
+			}
+			if diskPath != tt.wantLate {
+				t.Errorf("put: got %q, want %q", diskPath, tt.wantLate)
+			}
+
+			if err := lb.Close(t.Context()); err != nil {
+				t.Errorf("close: %v", err)
+			}
+		})
+	}
+}
+
+func TestLazyBackend_SetupFailure(t *testing.T) {
+	// Not parallel, as goleak sees the goroutines of the other tests.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	errSetup := errors.New("setup error")
+	causeCh := make(chan error, 1)
+	lb := NewLazyBackend(t.Context(), log.DefaultLogger, time.Minute, func(ctx context.Context) (Backend, error) {
+		// A worker started halfway through the setup, e.g. the copy of the base blob.
+		go func() {
+			<-ctx.Done()
+			causeCh <- context.Cause(ctx)
+		}()
+		return nil, errSetup
+	}, func(context.Context) (Backend, error) {
+		return &stubBackend{name: "local"}, nil
+	})
+
+	if err := lb.Close(t.Context()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if cause := <-causeCh; !errors.Is(cause, errSetup) {
+		t.Errorf("cause: got %v, want %v", cause, errSetup)
+	}
+}
@@ -0,0 +1,223 @@
+package cacheprog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/osfile"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ Backend = &LocalFirstBackend{}
+
+// localIndexFile is the file in the cache directory the local index is kept in across runs.
+const localIndexFile = "local-index.pb"
+
+// LocalFirstBackend answers gets from the index of the local cache kept across runs before asking the backend,
+// so that a developer machine is served from its disk at once while the remote cache is synced in the background.
+type LocalFirstBackend struct {
+	logger log.Logger
+	dir    local.DiskDir
+	// index is the local index saved by the previous runs. It is not written after it is loaded.
+	index map[string]*v1.IndexEntry
+	// entries are the entries hit or put in this run, as map[string]*v1.IndexEntry.
+	entries sync.Map
+	backend Backend
+}
+
+// NewLocalFirstBackend loads the local index in dir and wraps backend with it.
+// A local index that cannot be read is ignored, as it is only a head start.
+func NewLocalFirstBackend(logger log.Logger, dir local.DiskDir, backend Backend) *LocalFirstBackend {
+	lb := &LocalFirstBackend{
+		logger:  logger,
+		dir:     dir,
+		index:   map[string]*v1.IndexEntry{},
+		backend: backend,
+	}
+
+	index, err := readLocalIndex(filepath.Join(string(dir), localIndexFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		logger.Warnf("ignoring the local index: %v", err)
+	default:
+		lb.index = index
+		logger.Debugf("local index loaded: %d entries", len(index))
+	}
+
+	return lb
+}
+
+// lookup returns the entry of the action whose object is on the disk.
+func (lb *LocalFirstBackend) lookup(actionID string) (*v1.IndexEntry, string, bool) {
+	var entry *v1.IndexEntry
+	if value, ok := lb.entries.Load(actionID); ok {
+		//nolint:forcetypeassert
+		entry = value.(*v1.IndexEntry)
+	} else if entry, ok = lb.index[actionID]; !ok {
+		return nil, "", false
+	}
+
+	diskPath, ok := lb.available(entry)
+	if !ok {
+		return nil, "", false
+	}
+
+	return entry, diskPath, true
+}
+
+// available returns the path of the object of the entry when it has not expired and is on the disk.
+func (lb *LocalFirstBackend) available(entry *v1.IndexEntry) (string, bool) {
+	if expired(entry, time.Now()) {
+		return "", false
+	}
+
+	// Objects are renamed into place once complete, so one of the right size is the output.
+	diskPath := local.ObjectPath(lb.dir, entry.OutputId)
+	if stat, err := os.Stat(diskPath); err != nil || stat.Size() != entry.Size {
+		return "", false
+	}
+
+	return diskPath, true
+}
+
+func (lb *LocalFirstBackend) Get(ctx context.Context, actionID string) (string, *MetaData, error) {
+	if entry, diskPath, ok := lb.lookup(actionID); ok {
+		// The entry is saved as used now, which tells gocica save the entries used since gocica restore.
+		used := proto.CloneOf(entry)
+		used.LastUsedAt = timestamppb.Now()
+		lb.entries.Store(actionID, used)
+		return diskPath, &MetaData{
+			OutputID:     entry.OutputId,
+			Size:         entry.Size,
+			Timenano:     entry.Timenano,
+			ExpiresNanos: expiresNanos(entry),
+		}, nil
+	}
+
+	diskPath, metaData, err := lb.backend.Get(ctx, actionID)
+	if err != nil || diskPath == "" || metaData == nil {
+		return diskPath, metaData, err
+	}
+	indexEntry := &v1.IndexEntry{
+		OutputId:   metaData.OutputID,
+		Size:       metaData.Size,
+		Timenano:   metaData.Timenano,
+		LastUsedAt: timestamppb.Now(),
+	}
+	if metaData.ExpiresNanos != 0 {
+		indexEntry.ExpiresAt = timestamppb.New(time.Unix(0, metaData.ExpiresNanos))
+	}
+	lb.entries.Store(actionID, indexEntry)
+
+	return diskPath, metaData, nil
+}
+
+func (lb *LocalFirstBackend) Put(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body stream.ClonableReadSeeker) (string, error) {
+	diskPath, err := lb.backend.Put(ctx, actionID, outputID, size, ttl, body)
+	// An output only written to the local disk is still in the local index.
+	var degradedErr *DegradedError
+	if err != nil && (!errors.As(err, &degradedErr) || diskPath == "") {
+		return "", err
+	}
+	now := time.Now()
+	lb.entries.Store(actionID, &v1.IndexEntry{
+		OutputId:   outputID,
+		Size:       size,
+		Timenano:   now.UnixNano(),
+		LastUsedAt: timestamppb.New(now),
+		ExpiresAt:  expiresAt(ttl),
+	})
+
+	return diskPath, err
+}
+
+// Close saves the local index before closing the backend, so that it is kept even when the commit fails.
+func (lb *LocalFirstBackend) Close(ctx context.Context) error {
+	if err := lb.save(); err != nil {
+		lb.logger.Warnf("failed to save the local index: %v", err)
+	}
+
+	return lb.backend.Close(ctx)
+}
+
+// save writes the entries of the previous runs and this run whose objects are still on the disk.
+// The index is read again first, so that the entries saved since it was loaded, e.g. by a go command run in parallel, are kept.
+func (lb *LocalFirstBackend) save() error {
+	path := filepath.Join(string(lb.dir), localIndexFile)
+	saved, err := readLocalIndex(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		lb.logger.Debugf("ignoring the saved local index: %v", err)
+	}
+
+	entries := make(map[string]*v1.IndexEntry, len(lb.index))
+	for _, index := range []map[string]*v1.IndexEntry{lb.index, saved} {
+		for actionID, entry := range index {
+			if _, ok := lb.available(entry); ok {
+				entries[actionID] = entry
+			}
+		}
+	}
+	lb.entries.Range(func(key, value any) bool {
+		//nolint:forcetypeassert
+		entries[key.(string)] = value.(*v1.IndexEntry)
+		return true
+	})
+
+	return writeLocalIndex(path, entries)
+}
+
+func readLocalIndex(path string) (map[string]*v1.IndexEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read local index: %w", err)
+	}
+
+	index := &v1.ActionsCache{}
+	if err := proto.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("unmarshal local index: %w", err)
+	}
+	if index.Entries == nil {
+		return map[string]*v1.IndexEntry{}, nil
+	}
+
+	return index.Entries, nil
+}
+
+// writeLocalIndex writes the index to a temporary file and renames it, as other gocica processes may read it.
+func writeLocalIndex(path string, entries map[string]*v1.IndexEntry) error {
+	data, err := proto.Marshal(&v1.ActionsCache{Entries: entries})
+	if err != nil {
+		return fmt.Errorf("marshal local index: %w", err)
+	}
+
+	f, err := osfile.CreateTemp(filepath.Dir(path), "tmp-index-*")
+	if err != nil {
+		return fmt.Errorf("create local index: %w", err)
+	}
+	defer osfile.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write local index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close local index: %w", err)
+	}
+
+	if err := osfile.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename local index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package cacheprog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/local"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+)
+
+// missBackend misses every get and puts the output to nowhere.
+type missBackend struct {
+	gets   int
+	closed bool
+}
+
+func (b *missBackend) Get(context.Context, string) (string, *MetaData, error) {
+	b.gets++
+	return "", nil, nil
+}
+
+func (b *missBackend) Put(context.Context, string, string, int64, time.Duration, stream.ClonableReadSeeker) (string, error) {
+	return "put", nil
+}
+
+func (b *missBackend) Close(context.Context) error {
+	b.closed = true
+	return nil
+}
+
+func TestLocalFirstBackend(t *testing.T) {
+	t.Parallel()
+
+	dir := local.DiskDir(t.TempDir())
+	writeObject := func(outputID, content string) {
+		t.Helper()
+		if err := os.WriteFile(local.ObjectPath(dir, outputID), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeObject("on-disk", "hello")
+	writeObject("truncated", "hel")
+	if err := writeLocalIndex(filepath.Join(string(dir), localIndexFile), map[string]*v1.IndexEntry{
+		"hit":       {OutputId: "on-disk", Size: 5},
+		"truncated": {OutputId: "truncated", Size: 5},
+		"gone":      {OutputId: "gone", Size: 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &missBackend{}
+	lb := NewLocalFirstBackend(log.DefaultLogger, dir, backend)
+
+	tests := []struct {
+		actionID string
+		wantHit  bool
+	}{
+		{actionID: "hit", wantHit: true},
+		{actionID: "truncated", wantHit: false},
+		{actionID: "gone", wantHit: false},
+		{actionID: "unknown", wantHit: false},
+	}
+	for _, tt := range tests {
+		diskPath, metaData, err := lb.Get(t.Context(), tt.actionID)
+		if err != nil {
+			t.Fatalf("get %s: %v", tt.actionID, err)
+		}
+		if gotHit := diskPath != "" && metaData != nil; gotHit != tt.wantHit {
+			t.Errorf("get %s: hit=%t, want %t", tt.actionID, gotHit, tt.wantHit)
+		}
+	}
+	if backend.gets != 3 {
+		t.Errorf("backend got %d gets, want the 3 misses of the local index", backend.gets)
+	}
+
+	if _, err := lb.Put(t.Context(), "put", "put-output", 3, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	writeObject("put-output", "abc")
+
+	if err := lb.Close(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if !backend.closed {
+		t.Error("backend is not closed")
+	}
+
+	// The next run is served with the entries whose objects are on the disk.
+	index, err := readLocalIndex(filepath.Join(string(dir), localIndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index["hit"] == nil || index["put"] == nil {
+		t.Errorf("saved local index: %v", index)
+	}
+}
@@ -0,0 +1,202 @@
+package cacheprog
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mazrean/gocica/internal/admission"
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/osfile"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote"
+	"github.com/mazrean/gocica/log"
+)
+
+// The steps of a workflow may move the transfers of the remote cache out of the go commands:
+// gocica restore restores the remote cache to the local disk and its index before the build,
+// the go commands are served from the local index alone (LocalFirstBackend),
+// and gocica save commits the entries they used and put after the build.
+
+var (
+	_ Restorer = &ConbinedBackend{}
+	_ Saver    = &ConbinedBackend{}
+)
+
+// Restorer is a Backend restoring the remote cache to the local disk ahead of the go commands.
+type Restorer interface {
+	// Restore waits for the outputs of the remote cache to be restored to the local disk and returns their entries.
+	// The backend is closed without a commit, and cannot be used afterwards.
+	Restore(ctx context.Context) (map[string]*v1.IndexEntry, error)
+}
+
+func (cb *ConbinedBackend) Restore(ctx context.Context) (map[string]*v1.IndexEntry, error) {
+	if waiter, ok := cb.remote.(remote.RestoreWaiter); ok {
+		if err := waiter.WaitRestored(ctx); err != nil {
+			return nil, fmt.Errorf("wait for the restoration: %w", err)
+		}
+	}
+
+	now := time.Now()
+	entries := make(map[string]*v1.IndexEntry, len(cb.metaDataMap))
+	for actionID, indexEntry := range cb.metaDataMap {
+		if expired(indexEntry, now) {
+			continue
+		}
+
+		diskPath, err := cb.local.Get(ctx, indexEntry.OutputId)
+		if err != nil {
+			return nil, fmt.Errorf("get local cache: %w", err)
+		}
+		if diskPath == "" && remote.IsInline(indexEntry) {
+			diskPath, err = cb.materialize(ctx, indexEntry)
+			if err != nil {
+				return nil, fmt.Errorf("materialize inline output: %w", err)
+			}
+		}
+		// An output the restoration failed for is a miss, which the go command rebuilds.
+		if diskPath != "" {
+			entries[actionID] = indexEntry
+		}
+	}
+
+	if err := cb.remote.Close(ctx); err != nil {
+		return nil, fmt.Errorf("close remote backend: %w", err)
+	}
+	if err := cb.local.Close(ctx); err != nil {
+		return nil, fmt.Errorf("close backend: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Saver is a Backend committing the entries of the local index used and put after gocica restore.
+type Saver interface {
+	Backend
+	// Use records a get of the action, and marks its entry in the remote cache used when it has the output.
+	// It reports whether the remote cache has the entry, which is then not put again.
+	Use(actionID, outputID string) bool
+}
+
+func (cb *ConbinedBackend) Use(actionID, outputID string) bool {
+	cb.recordAccess(actionID)
+
+	indexEntry, ok := cb.lookup(actionID)
+	if !ok || indexEntry.OutputId != outputID {
+		return false
+	}
+	cb.usedMetaDataMap.Store(actionID, indexEntry)
+	admission.Record(actionID)
+
+	return true
+}
+
+// WriteLocalIndex replaces the local index in dir with entries, which LocalFirstBackend serves the go commands from.
+func WriteLocalIndex(dir local.DiskDir, entries map[string]*v1.IndexEntry) error {
+	return writeLocalIndex(filepath.Join(string(dir), localIndexFile), entries)
+}
+
+// SaveResult counts the entries of the local index committed by Save.
+type SaveResult struct {
+	// Used is the number of entries of the remote cache used.
+	Used int
+	// Put is the number of entries put, whose outputs are uploaded.
+	Put int
+}
+
+// Save replays the entries of the local index in dir used or put since the time to backend, in the order they were last used.
+// The entries already in the remote cache are marked used, and the others are put with their outputs on the disk.
+// backend commits them when it is closed.
+func Save(ctx context.Context, logger log.Logger, dir local.DiskDir, backend Saver, since time.Time) (*SaveResult, error) {
+	index, err := readLocalIndex(filepath.Join(string(dir), localIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	type usedEntry struct {
+		actionID string
+		entry    *v1.IndexEntry
+	}
+	var used []usedEntry
+	for actionID, entry := range index {
+		if entry.LastUsedAt != nil && !entry.LastUsedAt.AsTime().Before(since) {
+			used = append(used, usedEntry{actionID: actionID, entry: entry})
+		}
+	}
+	// The access order of the entries is recorded, which the next restoration follows.
+	slices.SortFunc(used, func(x, y usedEntry) int {
+		return cmp.Or(
+			x.entry.LastUsedAt.AsTime().Compare(y.entry.LastUsedAt.AsTime()),
+			cmp.Compare(x.actionID, y.actionID),
+		)
+	})
+
+	result := &SaveResult{}
+	for _, u := range used {
+		if backend.Use(u.actionID, u.entry.OutputId) {
+			result.Used++
+			continue
+		}
+
+		ok, err := putObject(ctx, dir, backend, u.actionID, u.entry)
+		if err != nil {
+			return nil, fmt.Errorf("put %s: %w", u.actionID, err)
+		}
+		if !ok {
+			logger.Debugf("skipped %s: its output is not on the disk or has expired", u.actionID)
+			continue
+		}
+		result.Put++
+	}
+
+	return result, nil
+}
+
+// putObject puts the entry with its output on the disk, and reports false when the output is gone or has expired.
+func putObject(ctx context.Context, dir local.DiskDir, backend Backend, actionID string, entry *v1.IndexEntry) (bool, error) {
+	var ttl time.Duration
+	if entry.ExpiresAt != nil {
+		ttl = time.Until(entry.ExpiresAt.AsTime())
+		if ttl <= 0 {
+			return false, nil
+		}
+	}
+
+	f, err := osfile.Open(local.ObjectPath(dir, entry.OutputId))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open output: %w", err)
+	}
+	defer f.Close()
+
+	buf, err := membuf.New(entry.Size)
+	if err != nil {
+		return false, fmt.Errorf("allocate: %w", err)
+	}
+	if _, err := io.Copy(buf, f); err != nil {
+		buf.Release()
+		return false, fmt.Errorf("read output: %w", err)
+	}
+	if buf.Len() != entry.Size {
+		buf.Release()
+		return false, nil
+	}
+
+	// An output kept on the disk but not uploaded is still a put, as it is for the go command.
+	_, err = backend.Put(ctx, actionID, entry.OutputId, entry.Size, ttl, buf.Reader())
+	var degradedErr *DegradedError
+	if err != nil && !errors.As(err, &degradedErr) {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package cacheprog
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/local"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// replayBackend has the entries of remote and records the uses and puts.
+type replayBackend struct {
+	remote map[string]string
+	uses   []string
+	puts   map[string]string
+}
+
+func (b *replayBackend) Use(actionID, outputID string) bool {
+	b.uses = append(b.uses, actionID)
+	return b.remote[actionID] == outputID
+}
+
+func (b *replayBackend) Get(context.Context, string) (string, *MetaData, error) {
+	return "", nil, nil
+}
+
+func (b *replayBackend) Put(_ context.Context, actionID, _ string, _ int64, _ time.Duration, body stream.ClonableReadSeeker) (string, error) {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	b.puts[actionID] = string(content)
+
+	return "path", nil
+}
+
+func (b *replayBackend) Close(context.Context) error {
+	return nil
+}
+
+func TestSave(t *testing.T) {
+	t.Parallel()
+
+	dir := local.DiskDir(t.TempDir())
+	for outputID, content := range map[string]string{"restored": "old", "built": "new"} {
+		if err := os.WriteFile(local.ObjectPath(dir, outputID), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restoredAt := time.Now()
+	before := timestamppb.New(restoredAt.Add(-time.Hour))
+	if err := WriteLocalIndex(dir, map[string]*v1.IndexEntry{
+		"unused":  {OutputId: "restored", Size: 3, LastUsedAt: before},
+		"hit":     {OutputId: "restored", Size: 3, LastUsedAt: timestamppb.New(restoredAt.Add(2 * time.Second))},
+		"built":   {OutputId: "built", Size: 3, LastUsedAt: timestamppb.New(restoredAt.Add(time.Second))},
+		"gone":    {OutputId: "gone", Size: 3, LastUsedAt: timestamppb.New(restoredAt.Add(3 * time.Second))},
+		"expired": {OutputId: "built", Size: 3, LastUsedAt: timestamppb.New(restoredAt.Add(4 * time.Second)), ExpiresAt: before},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &replayBackend{remote: map[string]string{"hit": "restored"}, puts: map[string]string{}}
+	result, err := Save(t.Context(), log.DefaultLogger, dir, backend, restoredAt)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	if result.Used != 1 || result.Put != 1 {
+		t.Errorf("got %d used and %d put, want 1 and 1", result.Used, result.Put)
+	}
+	if wantUses := []string{"built", "hit", "gone", "expired"}; !slices.Equal(backend.uses, wantUses) {
+		t.Errorf("uses: got %q, want %q in the order of use", backend.uses, wantUses)
+	}
+	if backend.puts["built"] != "new" || len(backend.puts) != 1 {
+		t.Errorf("puts: got %q, want the output of built", backend.puts)
+	}
+}
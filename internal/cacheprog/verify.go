@@ -0,0 +1,127 @@
+package cacheprog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/osfile"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+)
+
+// VerifyMode reports whether godebug, the GODEBUG of the go command, enables its cache verification mode.
+// The last setting of gocacheverify wins, as in the go command.
+func VerifyMode(godebug string) bool {
+	verify := false
+	for _, setting := range strings.Split(godebug, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(setting), "="); ok && key == "gocacheverify" {
+			verify = value == "1"
+		}
+	}
+
+	return verify
+}
+
+var _ Backend = &VerifyBackend{}
+
+// VerifyBackend emulates the cache verification mode of the go command (GODEBUG=gocacheverify=1),
+// which the go command only applies to its own cache and not to GOCACHEPROG.
+// Every get is answered as a miss, so that the go command rebuilds the output,
+// and the output put is compared with the one the backend would have served, checked against its output ID,
+// to tell a damaged cache copy from a build that does not reproduce its output.
+type VerifyBackend struct {
+	logger  log.Logger
+	backend Backend
+	// cached are the outputs the backend hit for the actions, as map[string]cachedOutput.
+	cached sync.Map
+}
+
+type cachedOutput struct {
+	outputID string
+	// damage is the Divergence source of the cache copy, or "" when it is intact.
+	damage string
+}
+
+// NewVerifyBackend wraps backend in the cache verification mode.
+func NewVerifyBackend(logger log.Logger, backend Backend) *VerifyBackend {
+	report.EnableCacheVerify()
+	logger.Infof("cache verification mode (GODEBUG=gocacheverify=1): every output of the cache is rebuilt and compared with the rebuilt one")
+
+	return &VerifyBackend{
+		logger:  logger,
+		backend: backend,
+	}
+}
+
+// Get checks the output the backend hits, and answers a miss.
+func (vb *VerifyBackend) Get(ctx context.Context, actionID string) (string, *MetaData, error) {
+	diskPath, metaData, err := vb.backend.Get(ctx, actionID)
+	if err != nil || diskPath == "" || metaData == nil {
+		return "", nil, err
+	}
+
+	damage, err := checkOutput(diskPath, metaData.OutputID, metaData.Size)
+	if err != nil {
+		vb.logger.Warnf("failed to check the cached output of action %s: %v", actionID, err)
+		return "", nil, nil
+	}
+	vb.cached.Store(actionID, cachedOutput{outputID: metaData.OutputID, damage: damage})
+
+	return "", nil, nil
+}
+
+// Put stores the rebuilt output, and compares it with the output of the cache of the action.
+func (vb *VerifyBackend) Put(ctx context.Context, actionID, outputID string, size int64, ttl time.Duration, body stream.ClonableReadSeeker) (string, error) {
+	diskPath, err := vb.backend.Put(ctx, actionID, outputID, size, ttl, body)
+
+	if value, ok := vb.cached.LoadAndDelete(actionID); ok {
+		//nolint:forcetypeassert
+		cached := value.(cachedOutput)
+		switch {
+		case cached.damage != "":
+			report.AddCacheVerify(&report.Divergence{ActionID: actionID, Source: cached.damage, CachedOutputID: cached.outputID, OutputID: outputID})
+		case cached.outputID != outputID:
+			report.AddCacheVerify(&report.Divergence{ActionID: actionID, Source: report.DivergenceRebuild, CachedOutputID: cached.outputID, OutputID: outputID})
+		default:
+			report.AddCacheVerify(nil)
+		}
+	}
+
+	return diskPath, err
+}
+
+func (vb *VerifyBackend) Close(ctx context.Context) error {
+	return vb.backend.Close(ctx)
+}
+
+// checkOutput returns the Divergence source of the file of the output of size bytes, or "" when its content is the one of outputID,
+// the base64 of its SHA-256 as given by the go command.
+func checkOutput(diskPath, outputID string, size int64) (string, error) {
+	f, err := osfile.Open(diskPath)
+	if err != nil {
+		return "", fmt.Errorf("open output: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("read output: %w", err)
+	}
+
+	switch {
+	case base64.StdEncoding.EncodeToString(h.Sum(nil)) == outputID:
+		return "", nil
+	case n < size:
+		return report.DivergenceTruncated, nil
+	default:
+		return report.DivergenceCorrupted, nil
+	}
+}
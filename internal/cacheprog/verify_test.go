@@ -0,0 +1,114 @@
+package cacheprog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
+)
+
+func TestVerifyMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		godebug string
+		want    bool
+	}{
+		{godebug: "", want: false},
+		{godebug: "gocacheverify=1", want: true},
+		{godebug: "gocachehash=1, gocacheverify=1", want: true},
+		{godebug: "gocacheverify=1,gocacheverify=0", want: false},
+		{godebug: "gocacheverify=2", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.godebug, func(t *testing.T) {
+			t.Parallel()
+
+			if got := VerifyMode(tt.godebug); got != tt.want {
+				t.Errorf("VerifyMode(%q) = %t, want %t", tt.godebug, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("compiled package")
+	sum := sha256.Sum256(content)
+	outputID := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{name: "intact", content: content, want: ""},
+		{name: "truncated", content: content[:4], want: report.DivergenceTruncated},
+		{name: "corrupted", content: []byte("compiled pockage"), want: report.DivergenceCorrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "o-output")
+			if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := checkOutput(path, outputID, int64(len(content)))
+			if err != nil {
+				t.Fatalf("checkOutput() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checkOutput() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// hitBackend hits every action with its output.
+type hitBackend struct {
+	diskPath string
+	metaData *MetaData
+}
+
+func (b *hitBackend) Get(context.Context, string) (string, *MetaData, error) {
+	return b.diskPath, b.metaData, nil
+}
+
+func (b *hitBackend) Put(context.Context, string, string, int64, time.Duration, stream.ClonableReadSeeker) (string, error) {
+	return b.diskPath, nil
+}
+
+func (b *hitBackend) Close(context.Context) error {
+	return nil
+}
+
+func TestVerifyBackend_Get(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "o-output")
+	if err := os.WriteFile(path, []byte("output"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vb := NewVerifyBackend(log.DefaultLogger, &hitBackend{diskPath: path, metaData: &MetaData{OutputID: "output", Size: 6}})
+
+	// The go command rebuilds every output, so that it is compared with the one of the cache.
+	diskPath, metaData, err := vb.Get(t.Context(), "action")
+	if err != nil || diskPath != "" || metaData != nil {
+		t.Errorf("Get() = %q, %v, %v, want a miss", diskPath, metaData, err)
+	}
+	if _, ok := vb.cached.Load("action"); !ok {
+		t.Error("the output hit is not kept to compare with the one put")
+	}
+}
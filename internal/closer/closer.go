@@ -0,0 +1,111 @@
+// Package closer runs the steps of a shutdown in the order of their dependencies,
+// e.g. the uploads before the commit of the metadata, and the commit before the local backend is closed.
+package closer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Closer is a step of a shutdown.
+type Closer struct {
+	// Name names the closer in the dependencies of the others and in its error.
+	Name string
+	// After are the names of the closers run before this one. It runs after them even when they failed.
+	After []string
+	// Timeout bounds the closer from the start of the shutdown, so that the closers given the same timeout share a deadline.
+	// 0 leaves it unbounded.
+	Timeout time.Duration
+	// Close runs the step.
+	Close func(ctx context.Context) error
+}
+
+// Registry collects the closers of a shutdown.
+type Registry struct {
+	closers []Closer
+}
+
+// Add adds the closer. The closers not depending on each other run in the order they are added.
+func (r *Registry) Add(c Closer) {
+	r.closers = append(r.closers, c)
+}
+
+// Close runs every closer once the ones it depends on have run, and returns the errors of the failed ones joined.
+// Unknown or cyclic dependencies are returned before any closer runs.
+func (r *Registry) Close(ctx context.Context) error {
+	order, err := r.order()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var errs []error
+	for _, c := range order {
+		if err := run(ctx, start, c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func run(ctx context.Context, start time.Time, c Closer) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(c.Timeout))
+		defer cancel()
+	}
+
+	return c.Close(ctx)
+}
+
+// order sorts the closers topologically, keeping the order they were added in among the ones ready to run.
+func (r *Registry) order() ([]Closer, error) {
+	index := make(map[string]int, len(r.closers))
+	for i, c := range r.closers {
+		if _, ok := index[c.Name]; ok {
+			return nil, fmt.Errorf("closer %s is added twice", c.Name)
+		}
+		index[c.Name] = i
+	}
+	for _, c := range r.closers {
+		for _, name := range c.After {
+			if _, ok := index[name]; !ok {
+				return nil, fmt.Errorf("closer %s depends on unknown closer %s", c.Name, name)
+			}
+		}
+	}
+
+	done := make([]bool, len(r.closers))
+	order := make([]Closer, 0, len(r.closers))
+	for len(order) < len(r.closers) {
+		progressed := false
+		for i, c := range r.closers {
+			if done[i] || !ready(c, index, done) {
+				continue
+			}
+			done[i] = true
+			order = append(order, c)
+			progressed = true
+			// The closers added earlier are preferred, which the one just run may have made ready.
+			break
+		}
+		if !progressed {
+			return nil, errors.New("closers depend on each other in a cycle")
+		}
+	}
+
+	return order, nil
+}
+
+func ready(c Closer, index map[string]int, done []bool) bool {
+	for _, name := range c.After {
+		if !done[index[name]] {
+			return false
+		}
+	}
+
+	return true
+}
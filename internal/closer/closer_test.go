@@ -0,0 +1,133 @@
+package closer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Close(t *testing.T) {
+	t.Parallel()
+
+	errCommit := errors.New("commit failed")
+
+	type closer struct {
+		name  string
+		after []string
+		err   error
+	}
+	tests := []struct {
+		name      string
+		closers   []closer
+		wantOrder []string
+		wantErrs  []error
+		wantErr   bool
+	}{
+		{
+			name: "dependencies first",
+			closers: []closer{
+				{name: "local", after: []string{"commit"}},
+				{name: "commit", after: []string{"uploads"}},
+				{name: "uploads"},
+				{name: "remote", after: []string{"commit"}},
+			},
+			wantOrder: []string{"uploads", "commit", "local", "remote"},
+		},
+		{
+			name: "failures are joined and do not stop the others",
+			closers: []closer{
+				{name: "uploads"},
+				{name: "commit", after: []string{"uploads"}, err: errCommit},
+				{name: "local", after: []string{"commit"}, err: context.Canceled},
+			},
+			wantOrder: []string{"uploads", "commit", "local"},
+			wantErrs:  []error{errCommit, context.Canceled},
+			wantErr:   true,
+		},
+		{
+			name:    "unknown dependency",
+			closers: []closer{{name: "commit", after: []string{"uploads"}}},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			closers: []closer{
+				{name: "a", after: []string{"b"}},
+				{name: "b", after: []string{"a"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				registry Registry
+				order    []string
+			)
+			for _, c := range tt.closers {
+				registry.Add(Closer{
+					Name:  c.name,
+					After: c.after,
+					Close: func(context.Context) error {
+						order = append(order, c.name)
+						return c.err
+					},
+				})
+			}
+
+			err := registry.Close(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Close() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, wantErr := range tt.wantErrs {
+				if !errors.Is(err, wantErr) {
+					t.Errorf("Close() error = %v, want %v in it", err, wantErr)
+				}
+			}
+			if !slices.Equal(order, tt.wantOrder) {
+				t.Errorf("order: got %q, want %q", order, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestRegistry_CloseTimeout(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registry  Registry
+		deadlines []time.Time
+	)
+	for _, name := range []string{"uploads", "commit"} {
+		registry.Add(Closer{
+			Name:    name,
+			Timeout: time.Minute,
+			Close: func(ctx context.Context) error {
+				deadline, _ := ctx.Deadline()
+				deadlines = append(deadlines, deadline)
+				return nil
+			},
+		})
+	}
+	registry.Add(Closer{
+		Name: "local",
+		Close: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); ok {
+				t.Error("local: got a deadline, want none")
+			}
+			return nil
+		},
+	})
+
+	if err := registry.Close(t.Context()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(deadlines) != 2 || !deadlines[0].Equal(deadlines[1]) {
+		t.Errorf("closers with the same timeout do not share a deadline: %v", deadlines)
+	}
+}
@@ -0,0 +1,146 @@
+// Package config loads gocica.yaml configuration files as kong resolvers.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the configuration file looked up by Paths.
+const FileName = "gocica.yaml"
+
+// Paths returns the configuration files to load, from the lowest to the highest priority:
+// the user config directory (e.g. $XDG_CONFIG_HOME/gocica/gocica.yaml) and the repository root.
+// Files that do not exist are skipped by kong.
+func Paths() []string {
+	var paths []string
+
+	if configDir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(configDir, "gocica", FileName))
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if root, ok := repositoryRoot(wd); ok {
+			paths = append(paths, filepath.Join(root, FileName))
+		}
+	}
+
+	return paths
+}
+
+// repositoryRoot walks up from dir to the nearest directory containing .git.
+func repositoryRoot(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// fileSources are the files the flags were resolved from by their names, for Source.
+var fileSources sync.Map
+
+// resolver resolves flags from a YAML document.
+// Keys are flag names, either flat ("github.cache-url") or nested ("github: {cache-url: ...}"),
+// and "_" may be used in place of "-".
+type resolver struct {
+	values map[string]any
+	// path is the file of the document, or FileName when it is not read from a file.
+	path string
+}
+
+// Loader is a kong.ConfigurationLoader for YAML files.
+// JSON is a subset of YAML, so JSON files are accepted as well.
+func Loader(r io.Reader) (kong.Resolver, error) {
+	values := map[string]any{}
+	if err := yaml.NewDecoder(r).Decode(&values); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode yaml: %w", err)
+	}
+
+	path := FileName
+	if f, ok := r.(interface{ Name() string }); ok {
+		path = f.Name()
+	}
+
+	return &resolver{values: normalize(values), path: path}, nil
+}
+
+// normalize flattens nested maps into dotted keys with "-" as the word separator.
+func normalize(values map[string]any) map[string]any {
+	flat := make(map[string]any, len(values))
+	var walk func(prefix string, values map[string]any)
+	walk = func(prefix string, values map[string]any) {
+		for key, value := range values {
+			key = prefix + strings.ReplaceAll(key, "_", "-")
+			if nested, ok := value.(map[string]any); ok {
+				walk(key+".", nested)
+				continue
+			}
+			flat[key] = value
+		}
+	}
+	walk("", values)
+
+	return flat
+}
+
+func (r *resolver) Validate(app *kong.Application) error {
+	names := map[string]struct{}{}
+	var walk func(node *kong.Node)
+	walk = func(node *kong.Node) {
+		for _, flag := range node.Flags {
+			names[flag.Name] = struct{}{}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(app.Node)
+
+	var unknown []string
+	for key := range r.values {
+		if _, ok := names[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("unknown configuration keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+func (r *resolver) Resolve(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+	// kong applies environment variables before resolvers.
+	// Skip flags set by the environment so that it takes precedence over the file.
+	for _, env := range flag.Envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, nil
+		}
+	}
+
+	value, ok := r.values[flag.Name]
+	if !ok {
+		return nil, nil
+	}
+	// kong picks the value of the last resolver, which is the last one recorded.
+	fileSources.Store(flag.Name, r.path)
+
+	return value, nil
+}
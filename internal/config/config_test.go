@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+type testCLI struct {
+	Dir      string `kong:"env='GOCICA_TEST_DIR'"`
+	LogLevel string `kong:"default='info'"`
+	Github   struct {
+		CacheURL string
+	} `kong:"embed,prefix='github.'"`
+}
+
+func TestLoader(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		args    []string
+		env     map[string]string
+		want    testCLI
+		wantErr bool
+	}{
+		{
+			name: "nested and snake case keys",
+			yaml: "dir: /file\nlog_level: debug\ngithub:\n  cache-url: https://example.com\n",
+			want: func() testCLI {
+				cli := testCLI{Dir: "/file", LogLevel: "debug"}
+				cli.Github.CacheURL = "https://example.com"
+				return cli
+			}(),
+		},
+		{
+			name: "flat keys",
+			yaml: "github.cache-url: https://example.com\n",
+			want: func() testCLI {
+				cli := testCLI{LogLevel: "info"}
+				cli.Github.CacheURL = "https://example.com"
+				return cli
+			}(),
+		},
+		{
+			name: "env overrides file",
+			yaml: "dir: /file\n",
+			env:  map[string]string{"GOCICA_TEST_DIR": "/env"},
+			want: testCLI{Dir: "/env", LogLevel: "info"},
+		},
+		{
+			name: "flag overrides env and file",
+			yaml: "dir: /file\n",
+			args: []string{"--dir", "/flag"},
+			env:  map[string]string{"GOCICA_TEST_DIR": "/env"},
+			want: testCLI{Dir: "/flag", LogLevel: "info"},
+		},
+		{
+			name: "empty file",
+			yaml: "",
+			want: testCLI{LogLevel: "info"},
+		},
+		{
+			name:    "unknown key",
+			yaml:    "dri: /file\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+
+			var cli testCLI
+			parser, err := kong.New(&cli, kong.Configuration(Loader, path))
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			_, err = parser.Parse(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cli != tt.want {
+				t.Errorf("got %+v, want %+v", cli, tt.want)
+			}
+		})
+	}
+}
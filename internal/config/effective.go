@@ -0,0 +1,182 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/pkg/json"
+)
+
+// SecretTag is the kong tag of the flags whose values are redacted from the effective configuration, e.g. tokens and keys.
+const SecretTag = "secret"
+
+// redacted replaces the values of secret flags served by Effective.
+const redacted = "<redacted>"
+
+// Values returns the values of the flags of ctx by their names.
+func Values(ctx *kong.Context) map[string]string {
+	values := map[string]string{}
+	for _, flag := range ctx.Flags() {
+		if !flag.Target.IsValid() {
+			continue
+		}
+		values[flag.Name] = fmt.Sprint(flag.Target.Interface())
+	}
+
+	return values
+}
+
+// Changed returns the names of the flags whose values differ between prev and next, sorted.
+func Changed(prev, next map[string]string) []string {
+	var names []string
+	for name, value := range next {
+		if prevValue, ok := prev[name]; !ok || prevValue != value {
+			names = append(names, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Effective is the configuration in effect in a long-running process, served as JSON by its admin endpoint.
+// The changes of the configuration files applied without a restart are set on it,
+// and the others are listed as pending until the process is restarted.
+type Effective struct {
+	locker  sync.Mutex
+	values  map[string]string
+	secrets map[string]bool
+	pending []string
+}
+
+// NewEffective creates the effective configuration of the flags of ctx.
+func NewEffective(ctx *kong.Context) *Effective {
+	secrets := map[string]bool{}
+	for _, flag := range ctx.Flags() {
+		if flag.Tag.Has(SecretTag) {
+			secrets[flag.Name] = true
+		}
+	}
+
+	return &Effective{
+		values:  Values(ctx),
+		secrets: secrets,
+	}
+}
+
+// Set records the value of the flag of the name applied to the running process.
+func (e *Effective) Set(name, value string) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+
+	e.values[name] = value
+	e.pending = slices.DeleteFunc(e.pending, func(n string) bool { return n == name })
+}
+
+// SetPending records that the flag of the name was changed, and takes effect when the process is restarted.
+func (e *Effective) SetPending(name string) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+
+	if !slices.Contains(e.pending, name) {
+		e.pending = append(e.pending, name)
+		slices.Sort(e.pending)
+	}
+}
+
+// EffectiveResponse is the body served by Effective.
+type EffectiveResponse struct {
+	// Values are the values of the flags in effect, with the secrets redacted.
+	Values map[string]string `json:"values"`
+	// PendingRestart are the flags changed in the configuration files, which take effect on restart.
+	PendingRestart []string `json:"pending_restart,omitempty"`
+}
+
+// Snapshot returns the configuration in effect, with the secrets redacted.
+func (e *Effective) Snapshot() *EffectiveResponse {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+
+	values := maps.Clone(e.values)
+	for name := range e.secrets {
+		if values[name] != "" {
+			values[name] = redacted
+		}
+	}
+
+	return &EffectiveResponse{
+		Values:         values,
+		PendingRestart: slices.Clone(e.pending),
+	}
+}
+
+func (e *Effective) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(e.Snapshot())
+}
+
+// Watcher watches the configuration files for changes.
+// The files are polled, as the configuration rarely changes.
+type Watcher struct {
+	paths []string
+	stats map[string]fileStat
+}
+
+// NewWatcher creates a watcher of the files at paths, which finds the changes made after it is created.
+func NewWatcher(paths []string) *Watcher {
+	return &Watcher{
+		paths: paths,
+		stats: statFiles(paths),
+	}
+}
+
+// Run calls onChange every time one of the files is written, created or removed, checking them every interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		stats := statFiles(w.paths)
+		if !maps.Equal(w.stats, stats) {
+			w.stats = stats
+			onChange()
+		}
+	}
+}
+
+// fileStat is what Watcher compares of a file. A file that does not exist has the zero value.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths []string) map[string]fileStat {
+	stats := make(map[string]fileStat, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			stats[path] = fileStat{}
+			continue
+		}
+		stats[path] = fileStat{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	return stats
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/pkg/json"
+)
+
+func TestChanged(t *testing.T) {
+	t.Parallel()
+
+	prev := map[string]string{"dir": "/a", "log-level": "info", "removed": "x"}
+	next := map[string]string{"dir": "/a", "log-level": "debug", "added": "y"}
+
+	want := []string{"added", "log-level", "removed"}
+	if got := Changed(prev, next); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEffective(t *testing.T) {
+	t.Parallel()
+
+	var cli struct {
+		LogLevel string `kong:"default='info'"`
+		Token    string `kong:"secret"`
+		Empty    string `kong:"secret"`
+	}
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatalf("create parser: %v", err)
+	}
+	ctx, err := parser.Parse([]string{"--token", "t0ken"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	effective := NewEffective(ctx)
+	effective.SetPending("dir")
+	effective.SetPending("log-level")
+	effective.Set("log-level", "debug")
+
+	rec := httptest.NewRecorder()
+	effective.ServeHTTP(rec, httptest.NewRequest("GET", "/config", nil))
+
+	var got EffectiveResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for name, want := range map[string]string{"log-level": "debug", "token": redacted, "empty": ""} {
+		if got.Values[name] != want {
+			t.Errorf("%s: got %q, want %q", name, got.Values[name], want)
+		}
+	}
+	if want := []string{"dir"}; !slices.Equal(got.PendingRestart, want) {
+		t.Errorf("pending restart: got %v, want %v", got.PendingRestart, want)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), FileName)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	changes := make(chan struct{}, 1)
+	go NewWatcher([]string{path}).Run(ctx, time.Millisecond, func() {
+		changes <- struct{}{}
+	})
+
+	for _, data := range []string{"log-level: debug\n", "log-level: warn, transfer.concurrency: 4\n"} {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the change was not watched")
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove config: %v", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the removal was not watched")
+	}
+}
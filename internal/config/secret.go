@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+)
+
+// SecretFileSuffix is appended to an environment variable of a flag to give the path of a file holding its value,
+// as Kubernetes and Docker mount secrets as files rather than setting them in the environment.
+const SecretFileSuffix = "_FILE"
+
+// LoadSecretFiles sets the environment variables of the flags of app that are not set
+// from the files named by the variables with SecretFileSuffix, e.g. GOCICA_GITHUB_TOKEN from the file at GOCICA_GITHUB_TOKEN_FILE.
+// The trailing newline of the file is trimmed. It must be called before the arguments are parsed.
+func LoadSecretFiles(app *kong.Application) error {
+	return loadSecretFiles(app.Node)
+}
+
+func loadSecretFiles(node *kong.Node) error {
+	for _, flag := range node.Flags {
+		for _, env := range flag.Envs {
+			if err := loadSecretFile(env); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, child := range node.Children {
+		if err := loadSecretFiles(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadSecretFile(env string) error {
+	if _, ok := os.LookupEnv(env); ok {
+		return nil
+	}
+
+	path, ok := os.LookupEnv(env + SecretFileSuffix)
+	if !ok || path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s%s: %w", env, SecretFileSuffix, err)
+	}
+
+	if err := os.Setenv(env, strings.TrimRight(string(data), "\r\n")); err != nil {
+		return fmt.Errorf("set %s: %w", env, err)
+	}
+
+	return nil
+}
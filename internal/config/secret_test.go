@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestLoadSecretFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "from file",
+			env:    map[string]string{"GOCICA_TEST_DIR_FILE": "secret"},
+			secret: "/from/file\n",
+			want:   "/from/file",
+		},
+		{
+			name:   "variable takes precedence",
+			env:    map[string]string{"GOCICA_TEST_DIR": "/from/env", "GOCICA_TEST_DIR_FILE": "secret"},
+			secret: "/from/file\n",
+			want:   "/from/env",
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"GOCICA_TEST_DIR_FILE": "missing"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "secret"), []byte(tt.secret), 0o600); err != nil {
+				t.Fatalf("failed to write secret: %v", err)
+			}
+
+			// t.Setenv restores the variable set by LoadSecretFiles too.
+			t.Setenv("GOCICA_TEST_DIR", "")
+			os.Unsetenv("GOCICA_TEST_DIR")
+			for k, v := range tt.env {
+				if k == "GOCICA_TEST_DIR_FILE" {
+					v = filepath.Join(dir, v)
+				}
+				t.Setenv(k, v)
+			}
+
+			var cli testCLI
+			parser, err := kong.New(&cli)
+			if err != nil {
+				t.Fatalf("failed to create parser: %v", err)
+			}
+
+			err = LoadSecretFiles(parser.Model)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := parser.Parse(nil); err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if cli.Dir != tt.want {
+				t.Errorf("dir: got %q, want %q", cli.Dir, tt.want)
+			}
+		})
+	}
+}
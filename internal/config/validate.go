@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+)
+
+// Validation gathers the problems of the configuration, so that they are reported all at once
+// instead of one a run, each with where its bad value was given.
+type Validation struct {
+	ctx      *kong.Context
+	problems []string
+}
+
+// NewValidation creates a validation of the configuration parsed into ctx.
+func NewValidation(ctx *kong.Context) *Validation {
+	return &Validation{ctx: ctx}
+}
+
+// Check records err as a problem of the flags when it is not nil.
+func (v *Validation) Check(err error, flags ...string) {
+	if err != nil {
+		v.Add(err.Error(), flags...)
+	}
+}
+
+// Add records the problem of the flags.
+// The flags given a value are listed with its source, the others are left out.
+func (v *Validation) Add(problem string, flags ...string) {
+	var sources []string
+	for _, name := range flags {
+		if source := Source(v.ctx, name); source != "" {
+			sources = append(sources, "--"+name+" "+source)
+		}
+	}
+	if len(sources) > 0 {
+		problem += " (" + strings.Join(sources, ", ") + ")"
+	}
+
+	v.problems = append(v.problems, problem)
+}
+
+// Err returns the problems recorded as a *ValidationError, or nil when there is none.
+func (v *Validation) Err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: slices.Clone(v.problems)}
+}
+
+// ValidationError is the problems of the configuration found by a Validation.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid configuration: " + e.Problems[0]
+	}
+
+	return fmt.Sprintf("%d configuration problems:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Source describes where the flag of the name got its value:
+// "on the command line", "from <environment variable>" or "from <configuration file>".
+// It returns "" when the flag has its default value or is unknown.
+// Secret files are reported as the environment variables they set.
+func Source(ctx *kong.Context, name string) string {
+	var flag *kong.Flag
+	for _, f := range ctx.Flags() {
+		if f.Name == name {
+			flag = f
+			break
+		}
+	}
+	if flag == nil {
+		return ""
+	}
+
+	for _, path := range ctx.Path {
+		if path.Flag == flag && !path.Resolved {
+			return "on the command line"
+		}
+	}
+
+	for _, env := range flag.Envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return "from " + env
+		}
+	}
+
+	if path, ok := fileSources.Load(name); ok {
+		return fmt.Sprintf("from %s", path)
+	}
+
+	return ""
+}
+
+// CheckURL checks that raw is empty or an absolute URL of one of the schemes.
+func CheckURL(raw string, schemes ...string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// The error of url.Parse has the URL, which may hold a token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("malformed URL: %w", err)
+	}
+	if !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("malformed URL: scheme %q is not one of %s", u.Scheme, strings.Join(schemes, ", "))
+	}
+	if u.Host == "" {
+		return errors.New("malformed URL: no host")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		args []string
+		env  map[string]string
+		flag string
+		want string
+	}{
+		{name: "default", flag: "dir", want: ""},
+		{name: "command line", args: []string{"--dir", "/flag"}, env: map[string]string{"GOCICA_TEST_DIR": "/env"}, flag: "dir", want: "on the command line"},
+		{name: "environment variable", yaml: "dir: /file\n", env: map[string]string{"GOCICA_TEST_DIR": "/env"}, flag: "dir", want: "from GOCICA_TEST_DIR"},
+		{name: "file", yaml: "github:\n  cache-url: https://example.com\n", flag: "github.cache-url", want: "from "},
+		{name: "unknown flag", flag: "unknown", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Not parallel, as the files the flags were resolved from are global.
+			fileSources.Clear()
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			ctx := parseTestCLI(t, tt.yaml, tt.args)
+
+			// The file is reported by its path, which is in a temporary directory.
+			got, _, _ := strings.Cut(Source(ctx, tt.flag), string(filepath.Separator))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidation(t *testing.T) {
+	fileSources.Clear()
+	t.Setenv("GOCICA_TEST_DIR", "/env")
+	ctx := parseTestCLI(t, "", []string{"--log-level", "debug"})
+
+	v := NewValidation(ctx)
+	if err := v.Err(); err != nil {
+		t.Fatalf("no problem: got %v", err)
+	}
+
+	v.Check(nil, "dir")
+	v.Check(os.ErrNotExist, "dir", "github.cache-url")
+	v.Add("bad level", "log-level")
+
+	err := v.Err()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %T, want *ValidationError", err)
+	}
+	want := []string{
+		"file does not exist (--dir from GOCICA_TEST_DIR)",
+		"bad level (--log-level on the command line)",
+	}
+	if strings.Join(validationErr.Problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems: got %q, want %q", validationErr.Problems, want)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "2 configuration problems:\n  - ") {
+		t.Errorf("message: got %q", msg)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "empty", url: ""},
+		{name: "valid", url: "https://example.com/path"},
+		{name: "other scheme", url: "ftp://example.com", wantErr: true},
+		{name: "no host", url: "http://", wantErr: true},
+		{name: "no scheme", url: "example.com", wantErr: true},
+		{name: "malformed", url: "http://token@[::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := CheckURL(tt.url, "http", "https")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "token") {
+				t.Errorf("the error has the URL: %v", err)
+			}
+		})
+	}
+}
+
+func parseTestCLI(t *testing.T, yaml string, args []string) *kong.Context {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var cli testCLI
+	parser, err := kong.New(&cli, kong.Configuration(Loader, path))
+	if err != nil {
+		t.Fatalf("create parser: %v", err)
+	}
+	ctx, err := parser.Parse(args)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	return ctx
+}
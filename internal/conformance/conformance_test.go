@@ -0,0 +1,301 @@
+//go:build conformance
+
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/hub"
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/record"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// module is the small module built and tested by the go commands: a package with a test and a command importing it.
+var module = map[string]string{
+	"go.mod": "module example.com/conformance\n\ngo 1.24\n",
+	"greet/greet.go": `package greet
+
+// Hello returns the greeting of name.
+func Hello(name string) string {
+	return "hello, " + name
+}
+`,
+	"greet/greet_test.go": `package greet
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	if got := Hello("gopher"); got != "hello, gopher" {
+		t.Errorf("got %q", got)
+	}
+}
+`,
+	"cmd/hello/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/conformance/greet"
+)
+
+func main() {
+	fmt.Println(greet.Hello("gopher"))
+}
+`,
+}
+
+func TestConformance(t *testing.T) {
+	gocica := buildGocica(t)
+
+	goCommands := strings.Fields(os.Getenv("GOCICA_CONFORMANCE_GO"))
+	if len(goCommands) == 0 {
+		goCommands = []string{"go"}
+	}
+
+	for _, goCommand := range goCommands {
+		t.Run(goCommand, func(t *testing.T) {
+			version := goVersion(t, goCommand)
+			if !supportsCacheProg(version) {
+				t.Skipf("%s has no GOCACHEPROG", version)
+			}
+
+			moduleDir := t.TempDir()
+			for name, content := range module {
+				path := filepath.Join(moduleDir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			server, err := hub.NewServer(log.DefaultLogger, t.TempDir())
+			if err != nil {
+				t.Fatalf("failed to create hub: %v", err)
+			}
+			remote := httptest.NewServer(server.Handler())
+			defer remote.Close()
+
+			recordDir := t.TempDir()
+			started := time.Now()
+
+			// Every go command commits its outputs to the hub. The second round starts from empty local caches,
+			// so that it is served from the outputs downloaded from the hub.
+			for round := range 2 {
+				env := append(os.Environ(),
+					"GOCACHE="+t.TempDir(),
+					"GOCACHEPROG="+strings.Join([]string{gocica, "--strict", "--remote=" + remote.URL, "--dir=" + t.TempDir(), "--record=" + recordDir}, " "),
+					"GOFLAGS=",
+					"GOTOOLCHAIN=local",
+				)
+				runGo(t, moduleDir, env, goCommand, "build", "-o", filepath.Join(t.TempDir(), "hello"), "./cmd/hello")
+				out := runGo(t, moduleDir, env, goCommand, "test", "./...")
+				if round == 1 && !strings.Contains(out, "(cached)") {
+					t.Errorf("test result is not cached in the second round:\n%s", out)
+				}
+			}
+
+			sessions, err := os.ReadDir(recordDir)
+			if err != nil {
+				t.Fatalf("failed to read record directory: %v", err)
+			}
+			if len(sessions) == 0 {
+				t.Fatal("no session recorded")
+			}
+
+			var hits, puts int
+			for _, session := range sessions {
+				h, p := checkSession(t, filepath.Join(recordDir, session.Name()), started)
+				hits += h
+				puts += p
+			}
+			if hits == 0 || puts == 0 {
+				t.Errorf("expected both hits and puts, got %d hits and %d puts", hits, puts)
+			}
+		})
+	}
+}
+
+// buildGocica builds the gocica command of this tree.
+func buildGocica(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "gocica")
+	cmd := exec.Command("go", "build", "-o", path, "github.com/mazrean/gocica")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build gocica: %v\n%s", err, out)
+	}
+
+	return path
+}
+
+// goVersion returns the version of the go command, e.g. go1.24.5.
+func goVersion(t *testing.T, goCommand string) string {
+	t.Helper()
+
+	out, err := exec.Command(goCommand, "env", "GOVERSION").Output()
+	if err != nil {
+		t.Skipf("%s is not available: %v", goCommand, err)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// supportsCacheProg reports whether the go command of the version supports GOCACHEPROG without GOEXPERIMENT, from Go 1.24.
+// Development versions are assumed to support it.
+func supportsCacheProg(version string) bool {
+	minor, ok := strings.CutPrefix(version, "go1.")
+	if !ok {
+		return !strings.HasPrefix(version, "go")
+	}
+	minor, _, _ = strings.Cut(minor, ".")
+	minor, _, _ = strings.Cut(minor, "rc")
+	n, err := strconv.Atoi(minor)
+
+	return err == nil && n >= 24
+}
+
+func runGo(t *testing.T, dir string, env []string, goCommand string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command(goCommand, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s failed: %v\n%s", goCommand, strings.Join(args, " "), err, out)
+	}
+
+	return string(out)
+}
+
+// checkSession checks the responses of a recorded session against the requests, and returns the numbers of hits and puts.
+// The output ID given by the go command is the SHA-256 of the content, so the file at every disk path answered must hash to it.
+func checkSession(t *testing.T, dir string, started time.Time) (int, int) {
+	t.Helper()
+
+	requests := readRequests(t, filepath.Join(dir, record.RequestsFile))
+	responses := readResponses(t, filepath.Join(dir, record.ResponsesFile))
+
+	var hits, puts int
+	for _, req := range requests {
+		res, ok := responses[req.ID]
+		if !ok {
+			t.Errorf("%s: request %d is not answered", dir, req.ID)
+			continue
+		}
+		if res.Err != "" {
+			t.Errorf("%s: request %d failed: %s", dir, req.ID, res.Err)
+			continue
+		}
+
+		switch req.Command {
+		case protocol.CmdGet:
+			if res.Miss {
+				continue
+			}
+			hits++
+
+			if res.TimeNanos < started.UnixNano() || res.TimeNanos > time.Now().UnixNano() {
+				t.Errorf("get %s: time %v is not in the test", req.ActionID, time.Unix(0, res.TimeNanos))
+			}
+			checkOutput(t, "get "+req.ActionID, res.DiskPath, res.OutputID, res.Size)
+		case protocol.CmdPut:
+			puts++
+			checkOutput(t, "put "+req.ActionID, res.DiskPath, req.OutputID, req.BodySize)
+		}
+	}
+
+	return hits, puts
+}
+
+// checkOutput checks that the file at diskPath is the output of the ID and the size.
+func checkOutput(t *testing.T, name, diskPath, outputID string, size int64) {
+	t.Helper()
+
+	if !filepath.IsAbs(diskPath) {
+		t.Errorf("%s: disk path %q is not absolute", name, diskPath)
+		return
+	}
+
+	content, err := os.ReadFile(diskPath)
+	if err != nil {
+		t.Errorf("%s: failed to read output: %v", name, err)
+		return
+	}
+	if int64(len(content)) != size {
+		t.Errorf("%s: output has %d bytes, want %d", name, len(content), size)
+	}
+
+	sum := sha256.Sum256(content)
+	if got := base64.StdEncoding.EncodeToString(sum[:]); got != outputID {
+		t.Errorf("%s: output hashes to %s, want %s", name, got, outputID)
+	}
+}
+
+// readRequests reads the recorded requests. The body of a put follows it as a JSON string, which is skipped.
+func readRequests(t *testing.T, path string) []*protocol.Request {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open requests: %v", err)
+	}
+	defer f.Close()
+
+	var requests []*protocol.Request
+	decoder := json.NewDecoder(f)
+	for {
+		req := &protocol.Request{}
+		if err := decoder.Decode(req); errors.Is(err, io.EOF) {
+			return requests
+		} else if err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+
+		if req.Command == protocol.CmdPut && req.BodySize > 0 {
+			var body string
+			if err := decoder.Decode(&body); err != nil {
+				t.Fatalf("failed to decode body of request %d: %v", req.ID, err)
+			}
+		}
+	}
+}
+
+// readResponses reads the recorded responses by their IDs.
+func readResponses(t *testing.T, path string) map[int64]*protocol.Response {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open responses: %v", err)
+	}
+	defer f.Close()
+
+	responses := map[int64]*protocol.Response{}
+	decoder := json.NewDecoder(f)
+	for {
+		res := &protocol.Response{}
+		if err := decoder.Decode(res); errors.Is(err, io.EOF) {
+			return responses
+		} else if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		responses[res.ID] = res
+	}
+}
@@ -0,0 +1,9 @@
+// Package conformance runs real go commands against gocica as GOCACHEPROG, and checks what gocica answered them:
+// the disk paths, the sizes and the times of the outputs, which have gone wrong in ways only the real toolchain shows.
+// The tests are slow, so they only build with the conformance tag:
+//
+//	go test -tags conformance ./internal/conformance
+//
+// The go commands run are the ones listed in GOCICA_CONFORMANCE_GO, separated by spaces, e.g. go1.24.5 go1.25.0
+// installed by golang.org/dl, or go in PATH when it is empty. Versions before Go 1.24 have no GOCACHEPROG and are skipped.
+package conformance
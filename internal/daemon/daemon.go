@@ -0,0 +1,173 @@
+// Package daemon serves the go commands of many invocations from one long-running gocica process over a unix socket,
+// so that they share the index in memory and the remote session instead of setting them up on every invocation.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// Server serves the connections of gocica clients with one CacheProg.
+type Server struct {
+	logger    log.Logger
+	cacheProg *cacheprog.CacheProg
+	// idleTimeout is the time without connections after which the server stops. 0 never stops.
+	idleTimeout time.Duration
+
+	activeLocker sync.Mutex
+	active       int
+}
+
+func NewServer(logger log.Logger, cacheProg *cacheprog.CacheProg, idleTimeout time.Duration) *Server {
+	return &Server{
+		logger:      logger,
+		cacheProg:   cacheProg,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Listen listens on the unix socket at path. A socket left by a daemon that is gone is removed,
+// and an error is returned when another daemon is listening on it.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("another daemon is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	return l, nil
+}
+
+// Serve serves the connections on l until ctx is done or the server is idle for the idle timeout,
+// and then waits for the connections being served. It does not close the CacheProg,
+// as the outputs of all the connections are committed together by the caller.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var idleTimer *time.Timer
+	if s.idleTimeout > 0 {
+		idleTimer = time.AfterFunc(s.idleTimeout, func() {
+			s.logger.Infof("no go command connected for %s. stopping the daemon", s.idleTimeout)
+			cancel()
+		})
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		s.connected(idleTimer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.disconnected(idleTimer)
+
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) connected(idleTimer *time.Timer) {
+	s.activeLocker.Lock()
+	defer s.activeLocker.Unlock()
+
+	s.active++
+	if idleTimer != nil {
+		idleTimer.Stop()
+	}
+}
+
+func (s *Server) disconnected(idleTimer *time.Timer) {
+	s.activeLocker.Lock()
+	defer s.activeLocker.Unlock()
+
+	s.active--
+	if s.active == 0 && idleTimer != nil {
+		idleTimer.Reset(s.idleTimeout)
+	}
+}
+
+// serveConn serves a go command. Its close request does not close the CacheProg, which outlives the go command.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.logger.Debugf("go command connected")
+	process := protocol.NewProcess(
+		protocol.WithLogger(s.logger),
+		protocol.WithGetHandler(s.cacheProg.Get),
+		protocol.WithPutHandler(s.cacheProg.Put),
+	)
+	if err := process.Serve(conn, conn); err != nil {
+		s.logger.Warnf("serve go command: %v", err)
+	}
+	s.logger.Debugf("go command disconnected")
+}
+
+// Dial connects to the daemon listening on the unix socket at path.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("connect to daemon: %w", err)
+	}
+
+	return conn, nil
+}
+
+// Relay relays the protocol between the go command reading w and writing r and the daemon on conn,
+// until the daemon is done with the requests of the go command. It closes conn.
+func Relay(conn net.Conn, w io.Writer, r io.Reader) error {
+	defer conn.Close()
+
+	go func() {
+		_, _ = io.Copy(conn, r)
+		// The daemon finishes the requests in flight and closes the connection once it sees the end of the requests.
+		if uc, ok := conn.(*net.UnixConn); ok {
+			_ = uc.CloseWrite()
+		}
+	}()
+
+	if _, err := io.Copy(w, conn); err != nil {
+		return fmt.Errorf("relay responses: %w", err)
+	}
+
+	return nil
+}
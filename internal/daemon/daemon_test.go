@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+	"github.com/mazrean/gocica/stream"
+)
+
+// mapBackend keeps the outputs put in memory.
+type mapBackend struct {
+	locker  sync.Mutex
+	outputs map[string]string
+	closed  bool
+}
+
+func (b *mapBackend) Get(_ context.Context, actionID string) (string, *cacheprog.MetaData, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	outputID, ok := b.outputs[actionID]
+	if !ok {
+		return "", nil, nil
+	}
+
+	return "/cache/" + outputID, &cacheprog.MetaData{OutputID: outputID}, nil
+}
+
+func (b *mapBackend) Put(_ context.Context, actionID, outputID string, _ int64, _ time.Duration, _ stream.ClonableReadSeeker) (string, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	b.outputs[actionID] = outputID
+
+	return "/cache/" + outputID, nil
+}
+
+func (b *mapBackend) Close(context.Context) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	b.closed = true
+
+	return nil
+}
+
+// runGoCommand sends the requests through a client connection like a go command, and returns the responses by ID.
+func runGoCommand(t *testing.T, path string, requests ...protocol.Request) map[int64]protocol.Response {
+	t.Helper()
+
+	var stdin strings.Builder
+	for _, req := range requests {
+		line, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+		stdin.Write(line)
+		stdin.WriteByte('\n')
+	}
+
+	conn, err := Dial(t.Context(), path)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	var stdout bytes.Buffer
+	if err := Relay(conn, &stdout, strings.NewReader(stdin.String())); err != nil {
+		t.Fatalf("failed to relay: %v", err)
+	}
+
+	responses := map[int64]protocol.Response{}
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var res protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatalf("failed to decode response %q: %v", scanner.Text(), err)
+		}
+		responses[res.ID] = res
+	}
+
+	return responses
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	backend := &mapBackend{outputs: map[string]string{}}
+	server := NewServer(log.DefaultLogger, cacheprog.NewCacheProg(log.DefaultLogger, backend), 0)
+
+	path := filepath.Join(t.TempDir(), "gocica.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("expected an error listening on the socket of a running daemon")
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ctx, l)
+	}()
+
+	first := runGoCommand(t, path,
+		protocol.Request{ID: 1, Command: protocol.CmdPut, ActionID: "action", OutputID: "output"},
+		protocol.Request{ID: 2, Command: protocol.CmdClose},
+	)
+	if first[1].Err != "" {
+		t.Errorf("first go command: put failed: %s", first[1].Err)
+	}
+
+	// The second go command is served with what the first one put.
+	second := runGoCommand(t, path,
+		protocol.Request{ID: 1, Command: protocol.CmdGet, ActionID: "action"},
+		protocol.Request{ID: 2, Command: protocol.CmdGet, ActionID: "other"},
+		protocol.Request{ID: 3, Command: protocol.CmdClose},
+	)
+	if second[1].Miss || second[1].OutputID != "output" {
+		t.Errorf("second go command: expected a hit of output, got %+v", second[1])
+	}
+	if !second[2].Miss {
+		t.Errorf("second go command: expected a miss, got %+v", second[2])
+	}
+
+	// The close requests of the go commands do not close the backend shared by them.
+	backend.locker.Lock()
+	closed := backend.closed
+	backend.locker.Unlock()
+	if closed {
+		t.Error("backend is closed by a go command")
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+func TestServer_idleTimeout(t *testing.T) {
+	t.Parallel()
+
+	backend := &mapBackend{outputs: map[string]string{}}
+	server := NewServer(log.DefaultLogger, cacheprog.NewCacheProg(log.DefaultLogger, backend), 100*time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "gocica.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(t.Context(), l)
+	}()
+
+	runGoCommand(t, path, protocol.Request{ID: 1, Command: protocol.CmdClose})
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop after the idle timeout")
+	}
+}
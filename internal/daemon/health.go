@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Health serves the probes of a daemon run as the sidecar of in-cluster CI, e.g. Tekton or Argo Workflows.
+// /healthz answers while the process is up, including the final commit after SIGTERM,
+// so that the liveness probe does not kill the commit.
+// /readyz answers once the daemon accepts go commands, and fails again when it stops accepting them.
+type Health struct {
+	ready atomic.Bool
+}
+
+// SetReady sets whether the daemon accepts go commands.
+func (h *Health) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+
+	return mux
+}
@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	h := &Health{}
+	handler := h.Handler()
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz: got %d, want %d", code, http.StatusOK)
+	}
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before ready: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	h.SetReady(true)
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Errorf("readyz when ready: got %d, want %d", code, http.StatusOK)
+	}
+
+	// A stopping daemon is still alive while it commits, but takes no more go commands.
+	h.SetReady(false)
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz when stopping: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz when stopping: got %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,135 @@
+// Package doctor diagnoses the gocica configuration, the remote cache and the local disk.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/diskspace"
+)
+
+type Status uint8
+
+const (
+	OK Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Warn:
+		return "WARN"
+	case Fail:
+		return "FAIL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Finding is the result of a single check.
+type Finding struct {
+	Check   string
+	Status  Status
+	Message string
+}
+
+// Doctor runs the checks and collects their findings.
+type Doctor struct {
+	findings []Finding
+}
+
+func (d *Doctor) add(check string, status Status, format string, args ...any) {
+	d.findings = append(d.findings, Finding{
+		Check:   check,
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Findings returns the findings collected so far.
+func (d *Doctor) Findings() []Finding {
+	return d.findings
+}
+
+// Failed reports whether any check failed.
+func (d *Doctor) Failed() bool {
+	for _, finding := range d.findings {
+		if finding.Status == Fail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes the findings in a human-readable form.
+func (d *Doctor) Print(w io.Writer) error {
+	for _, finding := range d.findings {
+		if _, err := fmt.Fprintf(w, "[%-4s] %s: %s\n", finding.Status, finding.Check, finding.Message); err != nil {
+			return fmt.Errorf("write finding: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// minFreeSpace is the free space below which the local disk check warns.
+const minFreeSpace = 5 << 30
+
+// CheckDir checks that the cache directory is writable and has enough free space.
+func (d *Doctor) CheckDir(dir string) {
+	const check = "local disk"
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		d.add(check, Fail, "cannot create cache directory %s: %v", dir, err)
+		return
+	}
+
+	f, err := os.CreateTemp(dir, "doctor-*")
+	if err != nil {
+		d.add(check, Fail, "cache directory %s is not writable: %v", dir, err)
+		return
+	}
+	name := f.Name()
+	_ = f.Close()
+	_ = os.Remove(name)
+
+	free, err := diskspace.Free(dir)
+	switch {
+	case err != nil:
+		d.add(check, Warn, "cannot get free space of %s: %v", dir, err)
+	case free < minFreeSpace:
+		d.add(check, Warn, "only %s free in %s. large builds may fill the disk", formatBytes(free), filepath.Clean(dir))
+	default:
+		d.add(check, OK, "%s is writable, %s free", filepath.Clean(dir), formatBytes(free))
+	}
+}
+
+// measure runs f and returns the elapsed time.
+func measure(ctx context.Context, f func(ctx context.Context) error) (time.Duration, error) {
+	start := time.Now()
+	err := f(ctx)
+	return time.Since(start), err
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,33 @@
+package doctor
+
+import (
+	"github.com/mazrean/gocica/internal/doublecache"
+)
+
+// CheckDoubleCache checks that dirs were not restored by another cache step besides gocica on a GitHub-hosted runner.
+func (d *Doctor) CheckDoubleCache(dirs []doublecache.Dir) {
+	const check = "double caching"
+
+	if !doublecache.Enabled() {
+		return
+	}
+
+	jobStart, err := doublecache.JobStart()
+	if err != nil {
+		d.add(check, Warn, "cannot find the start of the job: %v", err)
+		return
+	}
+
+	findings, err := doublecache.Detect(dirs, jobStart)
+	if err != nil {
+		d.add(check, Warn, "cannot detect double caching: %v", err)
+		return
+	}
+	if len(findings) == 0 {
+		d.add(check, OK, "no directory of the go command was restored by another cache step")
+		return
+	}
+	for _, finding := range findings {
+		d.add(check, Warn, "%s", finding.Message())
+	}
+}
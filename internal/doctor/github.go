@@ -0,0 +1,202 @@
+package doctor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/log"
+)
+
+// CheckGitHubConfig checks that the GitHub Actions Cache settings are complete.
+// It reports whether the remote checks can be run.
+func (d *Doctor) CheckGitHubConfig(config *provider.GHACacheConfig) bool {
+	const check = "github config"
+
+	ok := true
+	if config.Token == "" {
+		d.add(check, Fail, "token is not set. set ACTIONS_RUNTIME_TOKEN or GOCICA_GITHUB_TOKEN")
+		ok = false
+	}
+	if config.Service == provider.CacheServiceV1 {
+		d.add(check, Fail, "the runner only offers the legacy cache service (v1), e.g. on GitHub Enterprise Server. set GOCICA_GITHUB_CACHE_URL to a cache service v2")
+		ok = false
+	} else if config.CacheURL == "" {
+		d.add(check, Fail, "cache URL is not set. set ACTIONS_RESULTS_URL or GOCICA_GITHUB_CACHE_URL")
+		ok = false
+	} else if u, err := url.Parse(config.CacheURL); err != nil || u.Scheme == "" || u.Host == "" {
+		d.add(check, Fail, "cache URL %q is not a valid absolute URL", config.CacheURL)
+		ok = false
+	}
+	for _, field := range []struct {
+		name, value, env string
+	}{
+		{"runner OS", config.RunnerOS, "RUNNER_OS"},
+		{"ref", config.Ref, "GITHUB_REF"},
+		{"sha", config.Sha, "GITHUB_SHA"},
+	} {
+		if field.value == "" {
+			d.add(check, Warn, "%s is not set (%s). caches of different runs may share a key", field.name, field.env)
+		}
+	}
+
+	if ok {
+		d.add(check, OK, "cache URL and token are set")
+	}
+
+	return ok
+}
+
+// CheckToken inspects the claims of the runtime token without verifying its signature.
+func (d *Doctor) CheckToken(token string) {
+	const check = "github token"
+
+	runtimeToken, err := provider.ParseRuntimeToken(token)
+	switch {
+	case errors.Is(err, provider.ErrNotJWT):
+		d.add(check, Warn, "token is not a JWT. scopes cannot be checked")
+		return
+	case err != nil:
+		d.add(check, Fail, "%v", err)
+		return
+	}
+
+	if !runtimeToken.Expiry.IsZero() && runtimeToken.Expiry.Before(time.Now()) {
+		d.add(check, Fail, "token expired at %s", runtimeToken.Expiry.Format(time.RFC3339))
+		return
+	}
+
+	if runtimeToken.Scopes == nil {
+		d.add(check, Warn, "token has no cache scopes (ac claim). is it an ACTIONS_RUNTIME_TOKEN?")
+		return
+	}
+
+	writable := runtimeToken.WritableScopes()
+	if len(writable) == 0 {
+		d.add(check, Warn, "token can only read caches. uploads will fail (read-only scopes: %d)", len(runtimeToken.Scopes))
+		return
+	}
+
+	d.add(check, OK, "token can write caches for %s", strings.Join(writable, ", "))
+}
+
+// headerProbeLimit caps the bytes downloaded to measure the bandwidth.
+const headerProbeLimit = 4 << 20
+
+// CheckGitHubRemote measures a read round trip against the GitHub Actions Cache,
+// and a write round trip to a separate key when write is true.
+func (d *Doctor) CheckGitHubRemote(ctx context.Context, logger log.Logger, config *provider.GHACacheConfig, write bool) {
+	const check = "github remote"
+
+	downloadClientProvider, _, err := provider.GHACacheProvider(ctx, logger, config)
+	if err != nil {
+		d.add(check, Fail, "create cache client: %v", err)
+		return
+	}
+
+	var downloadClient core.DownloadClient
+	latency, err := measure(ctx, func(ctx context.Context) error {
+		var err error
+		downloadClient, err = downloadClientProvider(ctx)
+		return err
+	})
+	switch {
+	case err != nil:
+		d.add(check, Fail, "look up cache entry: %v", err)
+		return
+	case downloadClient == nil:
+		d.add(check, Warn, "no cache entry found for this key (lookup took %s). the first run will be cold", latency.Round(time.Millisecond))
+	default:
+		d.add(check, OK, "cache entry found (lookup took %s)", latency.Round(time.Millisecond))
+		d.checkDownload(ctx, downloadClient)
+	}
+
+	if write {
+		d.checkUpload(ctx, logger, config)
+	}
+}
+
+func (d *Doctor) checkDownload(ctx context.Context, client core.DownloadClient) {
+	const check = "github download"
+
+	sizeBuf := make([]byte, 8)
+	latency, err := measure(ctx, func(ctx context.Context) error {
+		return client.DownloadBlockBuffer(ctx, 0, int64(len(sizeBuf)), sizeBuf)
+	})
+	if err != nil {
+		d.add(check, Fail, "download header size: %v", err)
+		return
+	}
+	d.add(check, OK, "first byte latency %s", latency.Round(time.Millisecond))
+
+	//nolint:gosec
+	size := min(int64(binary.BigEndian.Uint64(sizeBuf)), headerProbeLimit)
+	if size == 0 {
+		d.add(check, Warn, "cache header is empty")
+		return
+	}
+
+	elapsed, err := measure(ctx, func(ctx context.Context) error {
+		return client.DownloadBlock(ctx, 8, size, io.Discard)
+	})
+	if err != nil {
+		d.add(check, Fail, "download header: %v", err)
+		return
+	}
+
+	//nolint:gosec
+	d.add(check, OK, "downloaded %s in %s (%s/s)", formatBytes(uint64(size)), elapsed.Round(time.Millisecond),
+		formatBytes(uint64(float64(size)/elapsed.Seconds())))
+}
+
+// doctorRunnerOSPrefix keeps the probe entries out of the restore keys of real runs.
+const doctorRunnerOSPrefix = "doctor-"
+
+func (d *Doctor) checkUpload(ctx context.Context, logger log.Logger, config *provider.GHACacheConfig) {
+	const check = "github upload"
+
+	probeConfig := *config
+	probeConfig.RunnerOS = doctorRunnerOSPrefix + config.RunnerOS
+	probeConfig.Sha = fmt.Sprintf("%s-%d", config.Sha, time.Now().UnixNano())
+
+	_, uploadClientProvider, err := provider.GHACacheProvider(ctx, logger, &probeConfig)
+	if err != nil {
+		d.add(check, Fail, "create cache client: %v", err)
+		return
+	}
+
+	latency, err := measure(ctx, func(ctx context.Context) error {
+		uploadClient, err := uploadClientProvider(ctx)
+		if err != nil {
+			return fmt.Errorf("create cache entry: %w", err)
+		}
+		if uploadClient == nil {
+			return errors.New("probe cache entry already exists")
+		}
+
+		downloader, err := core.NewDownloader(ctx, logger, nil, nil, nil)
+		if err != nil {
+			return fmt.Errorf("create empty downloader: %w", err)
+		}
+
+		uploader := core.NewUploader(ctx, logger, uploadClient, downloader, nil, nil)
+		if err := uploader.Commit(ctx, nil); err != nil {
+			return fmt.Errorf("commit probe entry: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		d.add(check, Fail, "%v", err)
+		return
+	}
+
+	d.add(check, OK, "created and committed a probe entry in %s", latency.Round(time.Millisecond))
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package doublecache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobStart returns when the job started, which is the boot of the machine on a GitHub-hosted runner.
+func JobStart() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("open /proc/stat: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "btime ")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse boot time: %w", err)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("read /proc/stat: %w", err)
+	}
+
+	return time.Time{}, errors.New("no boot time in /proc/stat")
+}
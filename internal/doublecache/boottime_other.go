@@ -0,0 +1,13 @@
+//go:build !linux
+
+package doublecache
+
+import (
+	"errors"
+	"time"
+)
+
+// JobStart returns when the job started, which is the boot of the machine on a GitHub-hosted runner.
+func JobStart() (time.Time, error) {
+	return time.Time{}, errors.New("not supported on this platform")
+}
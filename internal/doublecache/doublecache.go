@@ -0,0 +1,174 @@
+// Package doublecache detects a CI job that caches the directories of the go command on its own as well as through gocica,
+// e.g. GOCACHE with actions/cache or with the cache of actions/setup-go, which is on by default.
+// The outputs are then restored and saved twice, and the other cache adds its download and upload to every run
+// without saving any build.
+//
+// The detection is a heuristic for GitHub-hosted runners: every job runs on a fresh machine,
+// so many files in GOCACHE older than the boot of the machine were restored by another cache step.
+package doublecache
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// maxScan bounds the files looked at in a directory, which keeps the detection fast on a large cache.
+	maxScan = 2000
+	// minFiles is the number of files from before the job that tells a restored cache from a few files of the runner image.
+	minFiles = 100
+	// markerFile is the file in RUNNER_TEMP telling the later go commands of the job that the detection ran.
+	markerFile = "gocica-doublecache"
+)
+
+// Dir is a directory of the go command that gocica caches.
+type Dir struct {
+	// Name is the variable of the go command selecting the directory, e.g. GOCACHE.
+	Name string
+	// Path is the directory.
+	Path string
+	// Guidance tells how to stop caching the directory twice.
+	Guidance string
+}
+
+// Finding is a directory that was restored by another cache step.
+type Finding struct {
+	Dir
+	// Files is the number of files from before the job, counting up to the bound of the scan.
+	Files int
+	// Bytes is the size of these files.
+	Bytes int64
+}
+
+// Message returns the warning shown for the finding.
+func (f *Finding) Message() string {
+	more := ""
+	if f.Files >= maxScan {
+		more = "+"
+	}
+
+	return fmt.Sprintf(
+		"%s (%s) holds %d%s files (%.1f MiB) from before this job, restored by another cache step. "+
+			"gocica already caches them, so the other cache only adds its restore and save to every run. %s",
+		f.Name, f.Path, f.Files, more, float64(f.Bytes)/(1<<20), f.Guidance,
+	)
+}
+
+// Enabled reports whether the detection applies, which is on GitHub-hosted runners only,
+// as a self-hosted runner keeps its directories between the jobs.
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true" && os.Getenv("RUNNER_ENVIRONMENT") == "github-hosted"
+}
+
+// Claim reports whether the detection is still to run in this job, claiming it for the calling process.
+// The go commands of a job each start gocica, and only the first one warns.
+func Claim() bool {
+	runnerTemp := os.Getenv("RUNNER_TEMP")
+	if runnerTemp == "" {
+		return false
+	}
+
+	f, err := os.OpenFile(filepath.Join(runnerTemp, markerFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+
+	return true
+}
+
+// GoCache returns GOCACHE of the go command, or false when the cache is off or cannot be located.
+func GoCache() (Dir, bool) {
+	path := os.Getenv("GOCACHE")
+	if path == "off" {
+		return Dir{}, false
+	}
+	if path == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return Dir{}, false
+		}
+		path = filepath.Join(userCache, "go-build")
+	}
+
+	return Dir{
+		Name: "GOCACHE",
+		Path: path,
+		Guidance: "Set cache: false on actions/setup-go, or remove the actions/cache step of GOCACHE. " +
+			"Caching GOMODCACHE there is fine, or keep it in gocica with --modcache.",
+	}, true
+}
+
+// ModCache returns GOMODCACHE at path, kept in the remote cache by --modcache.
+func ModCache(path string) Dir {
+	return Dir{
+		Name:     "GOMODCACHE",
+		Path:     path,
+		Guidance: "Set cache: false on actions/setup-go, or remove the actions/cache step of GOMODCACHE, as --modcache keeps it in gocica.",
+	}
+}
+
+// Detect returns the directories of dirs holding at least minFiles files modified before jobStart.
+func Detect(dirs []Dir, jobStart time.Time) ([]*Finding, error) {
+	var findings []*Finding
+	for _, dir := range dirs {
+		files, size, err := scan(dir.Path, jobStart)
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", dir.Name, err)
+		}
+		if files >= minFiles {
+			findings = append(findings, &Finding{Dir: dir, Files: files, Bytes: size})
+		}
+	}
+
+	return findings, nil
+}
+
+// errEnough stops the walk at the bound of the scan.
+var errEnough = errors.New("enough files")
+
+// scan counts the files in root, up to maxScan, modified before jobStart and sums their size.
+// A missing root holds no files.
+func scan(root string, jobStart time.Time) (int, int64, error) {
+	var (
+		scanned, files int
+		size           int64
+	)
+	err := filepath.WalkDir(root, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// The file was removed during the walk.
+			return nil
+		}
+		if info.ModTime().Before(jobStart) {
+			files++
+			size += info.Size()
+		}
+
+		scanned++
+		if scanned >= maxScan {
+			return errEnough
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnough) {
+		return 0, 0, err
+	}
+
+	return files, size, nil
+}
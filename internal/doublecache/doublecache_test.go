@@ -0,0 +1,87 @@
+package doublecache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	jobStart := time.Now().Add(-time.Hour)
+	restored := jobStart.Add(-24 * time.Hour)
+
+	writeFiles := func(t *testing.T, n int, modTime time.Time) string {
+		t.Helper()
+
+		root := t.TempDir()
+		for i := range n {
+			dir := filepath.Join(root, strconv.Itoa(i%16))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("create directory: %v", err)
+			}
+			path := filepath.Join(dir, strconv.Itoa(i)+"-a")
+			if err := os.WriteFile(path, []byte("output"), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("set modification time: %v", err)
+			}
+		}
+
+		return root
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantFiles int
+	}{
+		{name: "restored cache", path: writeFiles(t, minFiles, restored), wantFiles: minFiles},
+		{name: "few files from the image", path: writeFiles(t, minFiles-1, restored)},
+		{name: "files of this job", path: writeFiles(t, minFiles, time.Now())},
+		{name: "bounded scan", path: writeFiles(t, maxScan+10, restored), wantFiles: maxScan},
+		{name: "missing directory", path: filepath.Join(t.TempDir(), "missing")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			findings, err := Detect([]Dir{{Name: "GOCACHE", Path: tt.path}}, jobStart)
+			if err != nil {
+				t.Fatalf("detect: %v", err)
+			}
+
+			if tt.wantFiles == 0 {
+				if len(findings) != 0 {
+					t.Errorf("got %d findings, want none", len(findings))
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("got %d findings, want 1", len(findings))
+			}
+			if findings[0].Files != tt.wantFiles {
+				t.Errorf("files: got %d, want %d", findings[0].Files, tt.wantFiles)
+			}
+			if want := int64(tt.wantFiles * len("output")); findings[0].Bytes != want {
+				t.Errorf("bytes: got %d, want %d", findings[0].Bytes, want)
+			}
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	t.Setenv("RUNNER_TEMP", t.TempDir())
+
+	if !Claim() {
+		t.Error("first claim: got false, want true")
+	}
+	if Claim() {
+		t.Error("second claim: got true, want false")
+	}
+}
@@ -0,0 +1,285 @@
+// Package headerdiff compares the headers of two cache entries,
+// to tell why the size of the cache jumped or its hit rate dropped after a change.
+package headerdiff
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/mazrean/gocica/internal/policy"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+// Header is what is compared of the header of a cache entry.
+type Header struct {
+	Key     string
+	Entries map[string]*v1.IndexEntry
+	Outputs []*v1.ActionsOutput
+}
+
+// Entry is an entry of an action added, removed or changed.
+// The fields of the old header are empty for an added entry, and the ones of the new header for a removed one.
+type Entry struct {
+	ActionID    string `json:"action_id"`
+	Kind        string `json:"kind"`
+	OldOutputID string `json:"old_output_id,omitempty"`
+	NewOutputID string `json:"new_output_id,omitempty"`
+	OldSize     int64  `json:"old_size"`
+	NewSize     int64  `json:"new_size"`
+}
+
+// SizeDelta is the change of the size of the output of the entry.
+func (e *Entry) SizeDelta() int64 {
+	return e.NewSize - e.OldSize
+}
+
+// Kind is the change of the entries of a kind of output.
+type Kind struct {
+	Kind      string `json:"kind"`
+	Added     int    `json:"added"`
+	Removed   int    `json:"removed"`
+	Changed   int    `json:"changed"`
+	SizeDelta int64  `json:"size_delta"`
+}
+
+// Diff is the difference of the new header from the old one.
+type Diff struct {
+	OldKey string `json:"old_key"`
+	NewKey string `json:"new_key"`
+	// OldEntries and NewEntries are the numbers of entries.
+	OldEntries int `json:"old_entries"`
+	NewEntries int `json:"new_entries"`
+	// OldSize and NewSize are the sizes of the distinct outputs of the entries.
+	OldSize int64 `json:"old_size"`
+	NewSize int64 `json:"new_size"`
+	// OldStoredSize and NewStoredSize are the sizes of the outputs in the blobs, after compression.
+	OldStoredSize int64 `json:"old_stored_size"`
+	NewStoredSize int64 `json:"new_stored_size"`
+	// Added, Removed and Changed are the entries by the absolute change of their size, the largest first.
+	Added   []Entry `json:"added"`
+	Removed []Entry `json:"removed"`
+	Changed []Entry `json:"changed"`
+	// Kinds are the changes by the kind of output, of the kinds with any.
+	Kinds []Kind `json:"kinds"`
+}
+
+// Compare returns the difference of newHeader from oldHeader.
+// An entry is changed when its output ID or size differs.
+func Compare(oldHeader, newHeader *Header) *Diff {
+	diff := &Diff{
+		OldKey:        oldHeader.Key,
+		NewKey:        newHeader.Key,
+		OldEntries:    len(oldHeader.Entries),
+		NewEntries:    len(newHeader.Entries),
+		OldSize:       outputSize(oldHeader.Entries),
+		NewSize:       outputSize(newHeader.Entries),
+		OldStoredSize: storedSize(oldHeader.Outputs),
+		NewStoredSize: storedSize(newHeader.Outputs),
+		Added:         []Entry{},
+		Removed:       []Entry{},
+		Changed:       []Entry{},
+	}
+
+	kinds := map[string]*Kind{}
+	kindOf := func(kind string) *Kind {
+		if kind == "" {
+			kind = string(policy.KindOther)
+		}
+		k, ok := kinds[kind]
+		if !ok {
+			k = &Kind{Kind: kind}
+			kinds[kind] = k
+		}
+
+		return k
+	}
+
+	for actionID, newEntry := range newHeader.Entries {
+		oldEntry, ok := oldHeader.Entries[actionID]
+		switch {
+		case !ok:
+			entry := Entry{ActionID: actionID, Kind: newEntry.GetKind(), NewOutputID: newEntry.GetOutputId(), NewSize: newEntry.GetSize()}
+			diff.Added = append(diff.Added, entry)
+			k := kindOf(entry.Kind)
+			k.Added++
+			k.SizeDelta += entry.SizeDelta()
+		case oldEntry.GetOutputId() != newEntry.GetOutputId() || oldEntry.GetSize() != newEntry.GetSize():
+			entry := Entry{
+				ActionID:    actionID,
+				Kind:        newEntry.GetKind(),
+				OldOutputID: oldEntry.GetOutputId(),
+				NewOutputID: newEntry.GetOutputId(),
+				OldSize:     oldEntry.GetSize(),
+				NewSize:     newEntry.GetSize(),
+			}
+			diff.Changed = append(diff.Changed, entry)
+			k := kindOf(entry.Kind)
+			k.Changed++
+			k.SizeDelta += entry.SizeDelta()
+		}
+	}
+	for actionID, oldEntry := range oldHeader.Entries {
+		if _, ok := newHeader.Entries[actionID]; ok {
+			continue
+		}
+		entry := Entry{ActionID: actionID, Kind: oldEntry.GetKind(), OldOutputID: oldEntry.GetOutputId(), OldSize: oldEntry.GetSize()}
+		diff.Removed = append(diff.Removed, entry)
+		k := kindOf(entry.Kind)
+		k.Removed++
+		k.SizeDelta += entry.SizeDelta()
+	}
+
+	for _, entries := range [][]Entry{diff.Added, diff.Removed, diff.Changed} {
+		slices.SortFunc(entries, func(a, b Entry) int {
+			return cmp.Or(cmp.Compare(abs(b.SizeDelta()), abs(a.SizeDelta())), cmp.Compare(a.ActionID, b.ActionID))
+		})
+	}
+
+	diff.Kinds = make([]Kind, 0, len(kinds))
+	for _, k := range kinds {
+		diff.Kinds = append(diff.Kinds, *k)
+	}
+	slices.SortFunc(diff.Kinds, func(a, b Kind) int {
+		return cmp.Compare(a.Kind, b.Kind)
+	})
+
+	return diff
+}
+
+// outputSize returns the size of the distinct outputs of the entries.
+func outputSize(entries map[string]*v1.IndexEntry) int64 {
+	sizes := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		sizes[entry.GetOutputId()] = entry.GetSize()
+	}
+
+	var size int64
+	for _, s := range sizes {
+		size += s
+	}
+
+	return size
+}
+
+func storedSize(outputs []*v1.ActionsOutput) int64 {
+	var size int64
+	for _, output := range outputs {
+		size += output.GetSize()
+	}
+
+	return size
+}
+
+// WriteJSON writes the diff as JSON.
+func (d *Diff) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(d); err != nil {
+		return fmt.Errorf("encode diff: %w", err)
+	}
+
+	return nil
+}
+
+// Write writes the diff for humans, with at most limit entries of each of the added, removed and changed ones.
+// A negative limit writes every entry.
+func (d *Diff) Write(w io.Writer, limit int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "--- %s\n+++ %s\n", d.OldKey, d.NewKey)
+	fmt.Fprintf(tw, "entries\t%d -> %d\t(%+d)\n", d.OldEntries, d.NewEntries, d.NewEntries-d.OldEntries)
+	fmt.Fprintf(tw, "outputs\t%s -> %s\t(%s)\n", formatBytes(d.OldSize), formatBytes(d.NewSize), formatDelta(d.NewSize-d.OldSize))
+	fmt.Fprintf(tw, "stored\t%s -> %s\t(%s)\n", formatBytes(d.OldStoredSize), formatBytes(d.NewStoredSize), formatDelta(d.NewStoredSize-d.OldStoredSize))
+
+	if len(d.Kinds) > 0 {
+		fmt.Fprintln(tw, "\nKIND\tADDED\tREMOVED\tCHANGED\tSIZE")
+		for _, k := range d.Kinds {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", k.Kind, k.Added, k.Removed, k.Changed, formatDelta(k.SizeDelta))
+		}
+	}
+
+	for _, section := range []struct {
+		name    string
+		mark    string
+		entries []Entry
+	}{
+		{name: "added", mark: "+", entries: d.Added},
+		{name: "removed", mark: "-", entries: d.Removed},
+		{name: "changed", mark: "~", entries: d.Changed},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+
+		var delta int64
+		for _, entry := range section.entries {
+			delta += entry.SizeDelta()
+		}
+		fmt.Fprintf(tw, "\n%s: %d entries (%s)\n", section.name, len(section.entries), formatDelta(delta))
+
+		shown := section.entries
+		if limit >= 0 && len(shown) > limit {
+			shown = shown[:limit]
+		}
+		for _, entry := range shown {
+			fmt.Fprintf(tw, "%s %s\t%s\t%s -> %s\t(%s)\n",
+				section.mark, shortID(entry.ActionID), entry.Kind, formatBytes(entry.OldSize), formatBytes(entry.NewSize), formatDelta(entry.SizeDelta()))
+		}
+		if rest := len(section.entries) - len(shown); rest > 0 {
+			fmt.Fprintf(tw, "  ... and %d more\n", rest)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write diff: %w", err)
+	}
+
+	return nil
+}
+
+// shortID returns the first 12 hex digits of the action ID, the base64 given by the go command, as gocachehash abbreviates it.
+func shortID(actionID string) string {
+	id := actionID
+	if raw, err := base64.StdEncoding.DecodeString(actionID); err == nil {
+		id = hex.EncodeToString(raw)
+	}
+
+	return id[:min(len(id), 12)]
+}
+
+func formatDelta(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+
+	return "+" + formatBytes(n)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,98 @@
+package headerdiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	oldHeader := &Header{
+		Key: "old",
+		Entries: map[string]*v1.IndexEntry{
+			"kept":    {OutputId: "o1", Size: 100, Kind: "compile"},
+			"rebuilt": {OutputId: "o2", Size: 200, Kind: "compile"},
+			"gone":    {OutputId: "o3", Size: 300, Kind: "test"},
+			"same":    {OutputId: "o1", Size: 100, Kind: "compile"},
+		},
+		Outputs: []*v1.ActionsOutput{{Id: "o1", Size: 50}, {Id: "o2", Size: 80}, {Id: "o3", Size: 90}},
+	}
+	newHeader := &Header{
+		Key: "new",
+		Entries: map[string]*v1.IndexEntry{
+			"kept":    {OutputId: "o1", Size: 100, Kind: "compile"},
+			"same":    {OutputId: "o1", Size: 100, Kind: "compile"},
+			"rebuilt": {OutputId: "o4", Size: 250, Kind: "compile"},
+			"small":   {OutputId: "o5", Size: 10, Kind: "link"},
+			"large":   {OutputId: "o6", Size: 5000, Kind: "link"},
+		},
+		Outputs: []*v1.ActionsOutput{{Id: "o1", Size: 50}, {Id: "o4", Size: 100}, {Id: "o5", Size: 10}, {Id: "o6", Size: 2000}},
+	}
+
+	diff := Compare(oldHeader, newHeader)
+
+	if diff.OldEntries != 4 || diff.NewEntries != 5 {
+		t.Errorf("entries: got %d -> %d, want 4 -> 5", diff.OldEntries, diff.NewEntries)
+	}
+	// The output shared by two entries is counted once.
+	if diff.OldSize != 600 || diff.NewSize != 5360 {
+		t.Errorf("size: got %d -> %d, want 600 -> 5360", diff.OldSize, diff.NewSize)
+	}
+	if diff.OldStoredSize != 220 || diff.NewStoredSize != 2160 {
+		t.Errorf("stored size: got %d -> %d, want 220 -> 2160", diff.OldStoredSize, diff.NewStoredSize)
+	}
+
+	if len(diff.Added) != 2 || diff.Added[0].ActionID != "large" || diff.Added[1].ActionID != "small" {
+		t.Errorf("added: got %+v, want large then small", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].ActionID != "gone" || diff.Removed[0].SizeDelta() != -300 {
+		t.Errorf("removed: got %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ActionID != "rebuilt" || diff.Changed[0].OldOutputID != "o2" || diff.Changed[0].NewOutputID != "o4" {
+		t.Errorf("changed: got %+v", diff.Changed)
+	}
+
+	wantKinds := []Kind{
+		{Kind: "compile", Changed: 1, SizeDelta: 50},
+		{Kind: "link", Added: 2, SizeDelta: 5010},
+		{Kind: "test", Removed: 1, SizeDelta: -300},
+	}
+	if len(diff.Kinds) != len(wantKinds) {
+		t.Fatalf("kinds: got %+v, want %+v", diff.Kinds, wantKinds)
+	}
+	for i, k := range diff.Kinds {
+		if k != wantKinds[i] {
+			t.Errorf("kinds[%d]: got %+v, want %+v", i, k, wantKinds[i])
+		}
+	}
+}
+
+func TestDiff_Write(t *testing.T) {
+	t.Parallel()
+
+	oldHeader := &Header{Key: "old", Entries: map[string]*v1.IndexEntry{}}
+	newHeader := &Header{Key: "new", Entries: map[string]*v1.IndexEntry{
+		// The action IDs are the base64 given by the go command.
+		"3q2+7w==": {OutputId: "o1", Size: 2048, Kind: "compile"},
+		"AAAAAA==": {OutputId: "o2", Size: 10, Kind: "compile"},
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := Compare(oldHeader, newHeader).Write(buf, 1); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"--- old", "+++ new", "added: 2 entries (+2.0KiB)", "+ deadbeef", "... and 1 more"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "removed:") {
+		t.Errorf("output lists the removed entries of none:\n%s", out)
+	}
+}
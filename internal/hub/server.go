@@ -0,0 +1,404 @@
+// Package hub provides the cache hub served by gocica serve --http.
+// A hub keeps a blob in the same layout as the GitHub Actions cache entry on its disk,
+// so that the jobs of a workflow share their outputs through a service container without the round trips to the cache service.
+package hub
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/log"
+)
+
+// keptVersions is the committed versions of the blob kept on the disk.
+// The older versions stay for the clients still downloading or copying from them while others commit.
+const keptVersions = 4
+
+// keptCommits is the last commits of the sessions remembered, so that a client retrying a commit whose response was lost
+// is answered with its version instead of the blocks it staged being gone.
+const keptCommits = 256
+
+// Paths of the hub API. The blob is addressed by its version, as a committed version never changes.
+const (
+	// BlobPath redirects to the latest version of the blob, or answers 404 when nothing is committed yet.
+	BlobPath = "/blob"
+	// VersionsPath is the prefix of the versions of the blob, which are served with ranges.
+	VersionsPath = "/blobs/"
+	// BlocksPath stages a block with the id query from the request body,
+	// or from the range given by the version, offset and size queries of a committed version.
+	BlocksPath = "/blocks"
+	// CommitPath commits the blocks of a CommitRequest as the next version of the blob.
+	CommitPath = "/commit"
+)
+
+// SessionQuery is the query of BlocksPath and CommitPath naming the upload session of a client.
+// The blocks are staged and committed in the namespace of their session, as the clients give the same IDs,
+// e.g. the output IDs, to the blocks of the same new outputs. Requests without it share one namespace.
+// A commit of the same blocks retried in a session is answered without committing them again.
+const SessionQuery = "session"
+
+// CommitRequest is the body of a commit.
+type CommitRequest struct {
+	Blocks []string `json:"blocks"`
+}
+
+// Server serves the blob of the hub from dir.
+type Server struct {
+	logger    log.Logger
+	blobDir   string
+	blocksDir string
+
+	// locker guards the versions of the blob and the commits of the sessions.
+	locker   sync.Mutex
+	versions []uint64
+	// commits are the last commits of the sessions, the oldest first.
+	commits []sessionCommit
+}
+
+// sessionCommit is a commit of the blocks of a session.
+type sessionCommit struct {
+	session string
+	blocks  []string
+	version uint64
+}
+
+// NewServer creates a server storing the blob in dir.
+// The versions committed by a previous server are served again, and the blocks it left uncommitted are removed.
+func NewServer(logger log.Logger, dir string) (*Server, error) {
+	s := &Server{
+		logger:    logger,
+		blobDir:   filepath.Join(dir, "blobs"),
+		blocksDir: filepath.Join(dir, "blocks"),
+	}
+
+	if err := os.RemoveAll(s.blocksDir); err != nil {
+		return nil, fmt.Errorf("remove blocks: %w", err)
+	}
+	for _, d := range []string{s.blobDir, s.blocksDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("create directory: %w", err)
+		}
+	}
+
+	files, err := os.ReadDir(s.blobDir)
+	if err != nil {
+		return nil, fmt.Errorf("read blob directory: %w", err)
+	}
+	for _, file := range files {
+		version, err := strconv.ParseUint(file.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.versions = append(s.versions, version)
+	}
+	slices.Sort(s.versions)
+
+	return s, nil
+}
+
+// Handler returns the handler of the hub API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+BlobPath, s.handleBlob)
+	mux.HandleFunc("GET "+VersionsPath+"{version}", s.handleVersion)
+	mux.HandleFunc("PUT "+BlocksPath, s.handleBlock)
+	mux.HandleFunc("POST "+CommitPath, s.handleCommit)
+
+	return mux
+}
+
+func (s *Server) latest() (uint64, bool) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if len(s.versions) == 0 {
+		return 0, false
+	}
+
+	return s.versions[len(s.versions)-1], true
+}
+
+func (s *Server) versionPath(version uint64) string {
+	return filepath.Join(s.blobDir, strconv.FormatUint(version, 10))
+}
+
+// sessionDir returns the directory of the blocks staged in the session.
+func (s *Server) sessionDir(session string) string {
+	return filepath.Join(s.blocksDir, "s-"+base64.RawURLEncoding.EncodeToString([]byte(session)))
+}
+
+func (s *Server) blockPath(session, blockID string) string {
+	return filepath.Join(s.sessionDir(session), base64.RawURLEncoding.EncodeToString([]byte(blockID)))
+}
+
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request) {
+	version, ok := s.latest()
+	if !ok {
+		http.Error(w, "no blob is committed yet", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, VersionsPath+strconv.FormatUint(version, 10), http.StatusTemporaryRedirect)
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseUint(r.PathValue("version"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	// A version removed after the open is still read to the end, as the file stays until it is closed.
+	f, err := os.Open(s.versionPath(version))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "version is not kept anymore", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Warnf("open version %d: %v", version, err)
+		http.Error(w, "failed to open version", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		s.logger.Warnf("stat version %d: %v", version, err)
+		http.Error(w, "failed to stat version", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// The version numbers start over when the directory of the hub is lost, so the ETag also has the time of the version.
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, version, stat.ModTime().UnixNano()))
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	blockID := query.Get("id")
+	if blockID == "" {
+		http.Error(w, "block id is required", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		src io.Reader = r.Body
+		err error
+	)
+	if query.Has("version") {
+		var closeFunc func() error
+		src, closeFunc, err = s.openRange(query.Get("version"), query.Get("offset"), query.Get("size"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer closeFunc()
+	}
+
+	if err := s.stage(query.Get(SessionQuery), blockID, src); err != nil {
+		s.logger.Warnf("stage block: %v", err)
+		http.Error(w, "failed to stage block", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// openRange opens the range of a committed version of the blob to copy it to a block.
+func (s *Server) openRange(strVersion, strOffset, strSize string) (io.Reader, func() error, error) {
+	version, err := strconv.ParseUint(strVersion, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid version: %w", err)
+	}
+	offset, err := strconv.ParseInt(strOffset, 10, 64)
+	if err != nil || offset < 0 {
+		return nil, nil, fmt.Errorf("invalid offset: %q", strOffset)
+	}
+	size, err := strconv.ParseInt(strSize, 10, 64)
+	if err != nil || size < 0 {
+		return nil, nil, fmt.Errorf("invalid size: %q", strSize)
+	}
+
+	f, err := os.Open(s.versionPath(version))
+	if err != nil {
+		return nil, nil, fmt.Errorf("open version %d: %w", version, err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("stat version %d: %w", version, err)
+	}
+	if offset+size > stat.Size() {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("range %d+%d is out of the %d bytes of version %d", offset, size, stat.Size(), version)
+	}
+
+	return io.NewSectionReader(f, offset, size), f.Close, nil
+}
+
+// stage writes a block of the session through a temporary file, so that a failed upload never leaves a partial block to commit.
+func (s *Server) stage(session, blockID string, r io.Reader) (err error) {
+	dir := s.sessionDir(session)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create session directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("write block: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close block: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.blockPath(session, blockID)); err != nil {
+		return fmt.Errorf("rename block: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) handleCommit(w http.ResponseWriter, r *http.Request) {
+	var req CommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	session := r.URL.Query().Get(SessionQuery)
+	if version, ok := s.committed(session, req.Blocks); ok {
+		s.logger.Infof("commit of version %d retried", version)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for _, blockID := range req.Blocks {
+		if _, err := os.Stat(s.blockPath(session, blockID)); err != nil {
+			http.Error(w, fmt.Sprintf("block %s is not staged", blockID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	version, err := s.commit(session, req.Blocks)
+	if err != nil {
+		s.logger.Warnf("commit: %v", err)
+		http.Error(w, "failed to commit", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Infof("committed version %d of %d blocks", version, len(req.Blocks))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commit joins the blocks of the session into the next version of the blob.
+// The last commit wins, like the latest cache entry restored by a key prefix.
+func (s *Server) commit(session string, blockIDs []string) (version uint64, err error) {
+	f, err := os.CreateTemp(s.blobDir, "tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	for _, blockID := range blockIDs {
+		if err := appendBlock(f, s.blockPath(session, blockID)); err != nil {
+			return 0, fmt.Errorf("append block %s: %w", blockID, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("close blob: %w", err)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	version = 1
+	if len(s.versions) > 0 {
+		version = s.versions[len(s.versions)-1] + 1
+	}
+	if err := os.Rename(f.Name(), s.versionPath(version)); err != nil {
+		return 0, fmt.Errorf("rename blob: %w", err)
+	}
+	s.versions = append(s.versions, version)
+	if session != "" {
+		s.commits = append(s.commits, sessionCommit{session: session, blocks: blockIDs, version: version})
+		if len(s.commits) > keptCommits {
+			s.commits = slices.Delete(s.commits, 0, len(s.commits)-keptCommits)
+		}
+	}
+
+	for len(s.versions) > keptVersions {
+		if err := os.Remove(s.versionPath(s.versions[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.Warnf("remove version %d: %v", s.versions[0], err)
+		}
+		s.versions = s.versions[1:]
+	}
+
+	// The blocks of other sessions, even with the same IDs, stay for their own commits.
+	for _, blockID := range blockIDs {
+		_ = os.Remove(s.blockPath(session, blockID))
+	}
+	if session != "" {
+		// A session is of one client, which does not stage while it commits.
+		_ = os.Remove(s.sessionDir(session))
+	}
+
+	return version, nil
+}
+
+// committed returns the version of the commit of the same blocks by the session, when it is one of the last commits.
+// The requests without a session are of different clients, so they are never taken as a retry.
+func (s *Server) committed(session string, blockIDs []string) (uint64, bool) {
+	if session == "" {
+		return 0, false
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	for _, c := range slices.Backward(s.commits) {
+		if c.session == session {
+			return c.version, slices.Equal(c.blocks, blockIDs)
+		}
+	}
+
+	return 0, false
+}
+
+func appendBlock(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mazrean/gocica/log"
+)
+
+func TestServer_commit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	server, err := NewServer(log.DefaultLogger, dir)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	for i := range keptVersions + 2 {
+		if err := server.stage("", "block", strings.NewReader(strings.Repeat("x", i))); err != nil {
+			t.Fatalf("failed to stage block: %v", err)
+		}
+		if _, err := server.commit("", []string{"block"}); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+	if err := server.stage("", "left", strings.NewReader("uncommitted")); err != nil {
+		t.Fatalf("failed to stage block: %v", err)
+	}
+
+	if _, err := os.Stat(server.versionPath(2)); !os.IsNotExist(err) {
+		t.Errorf("expected version 2 to be removed, got %v", err)
+	}
+
+	// A restarted server serves the kept versions and drops the blocks left uncommitted.
+	restarted, err := NewServer(log.DefaultLogger, dir)
+	if err != nil {
+		t.Fatalf("failed to restart server: %v", err)
+	}
+	if got := len(restarted.versions); got != keptVersions {
+		t.Errorf("versions: got %d, want %d", got, keptVersions)
+	}
+	if latest, _ := restarted.latest(); latest != keptVersions+2 {
+		t.Errorf("latest: got %d, want %d", latest, keptVersions+2)
+	}
+	if _, err := os.Stat(restarted.blockPath("", "left")); !os.IsNotExist(err) {
+		t.Errorf("expected the uncommitted block to be removed, got %v", err)
+	}
+}
+
+func TestServer_sessions(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(log.DefaultLogger, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	// Two jobs stage the same new output, with the output ID as its block ID, and commit at once.
+	sessions := []string{"job-a", "job-b"}
+	for _, session := range sessions {
+		for blockID, content := range map[string]string{"header": "header of " + session, "output": "output"} {
+			query := url.Values{"id": {blockID}, SessionQuery: {session}}
+			req, err := http.NewRequest(http.MethodPut, ts.URL+BlocksPath+"?"+query.Encode(), strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatalf("failed to stage block: %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("stage block %s of %s: got status %d", blockID, session, res.StatusCode)
+			}
+		}
+	}
+
+	statuses := make([]int, len(sessions))
+	wg := sync.WaitGroup{}
+	for i, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"blocks":["header","output"]}`
+			res, err := ts.Client().Post(ts.URL+CommitPath+"?"+SessionQuery+"="+session, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("failed to commit %s: %v", session, err)
+				return
+			}
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusNoContent {
+			t.Errorf("commit of %s: got status %d, want %d", sessions[i], status, http.StatusNoContent)
+		}
+	}
+	if latest, _ := server.latest(); latest != 2 {
+		t.Errorf("latest: got %d, want 2", latest)
+	}
+	for version := uint64(1); version <= 2; version++ {
+		content, err := os.ReadFile(server.versionPath(version))
+		if err != nil {
+			t.Fatalf("failed to read version %d: %v", version, err)
+		}
+		if !strings.HasPrefix(string(content), "header of job-") || !strings.HasSuffix(string(content), "output") {
+			t.Errorf("version %d: got %q", version, content)
+		}
+	}
+}
+
+func TestServer_commitRetried(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(log.DefaultLogger, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	query := url.Values{"id": {"header"}, SessionQuery: {"job"}}
+	req, err := http.NewRequest(http.MethodPut, ts.URL+BlocksPath+"?"+query.Encode(), strings.NewReader("header"))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to stage block: %v", err)
+	}
+	res.Body.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "commit", body: `{"blocks":["header"]}`, wantStatus: http.StatusNoContent},
+		// The response of the first commit was lost, and the client commits again.
+		{name: "retried commit", body: `{"blocks":["header"]}`, wantStatus: http.StatusNoContent},
+		{name: "commit of other blocks", body: `{"blocks":["header","output"]}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		res, err := ts.Client().Post(ts.URL+CommitPath+"?"+SessionQuery+"=job", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("%s: failed to commit: %v", tt.name, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.wantStatus)
+		}
+	}
+
+	if latest, _ := server.latest(); latest != 1 {
+		t.Errorf("latest: got %d, want 1", latest)
+	}
+}
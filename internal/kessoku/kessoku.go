@@ -5,6 +5,7 @@ import (
 	"github.com/mazrean/gocica/internal/local"
 	"github.com/mazrean/gocica/internal/remote"
 	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/fault"
 	"github.com/mazrean/gocica/internal/remote/provider"
 	"github.com/mazrean/gocica/log"
 	"github.com/mazrean/gocica/protocol"
@@ -26,12 +27,13 @@ func NewProcessWithOptions(logger log.Logger, cacheProg *cacheprog.CacheProg) *p
 
 // InitializeProcess is the main DI injector function.
 // It creates a fully configured Process with all dependencies wired up.
-// Unsatisfied dependencies (logger, dir, token, cacheURL, runnerOS, ref, sha) become function parameters.
+// Unsatisfied dependencies (logger, dir, backend kind, GitHub config, hub config, cipher, signer, commit timeout) become function parameters.
 var _ = kessoku.Inject[*protocol.Process](
 	"InitializeProcess",
-	kessoku.Async(kessoku.Bind[local.Backend](kessoku.Provide(local.NewDisk))),
+	kessoku.Async(kessoku.Provide(local.New)),
 
-	kessoku.Bind[remote.Backend](kessoku.Provide(core.NewBackend)),
+	kessoku.Provide(core.NewBackend),
+	kessoku.Bind[remote.Backend](kessoku.Provide(fault.WrapBackend)),
 	kessoku.Async(kessoku.Provide(core.NewUploader)),
 	kessoku.Async(kessoku.Bind[core.BaseBlobProvider](kessoku.Provide(core.NewDownloader))),
 	kessoku.Async(kessoku.Provide(provider.DownloadClientProviderExecutor)),
@@ -44,3 +46,21 @@ var _ = kessoku.Inject[*protocol.Process](
 
 	kessoku.Provide(NewProcessWithOptions),
 )
+
+// InitializeBackend creates the backend of the cache without the process,
+// so that the process can serve the go command while the remote cache is set up.
+// It takes the same parameters as InitializeProcess.
+var _ = kessoku.Inject[cacheprog.Backend](
+	"InitializeBackend",
+	kessoku.Async(kessoku.Provide(local.New)),
+
+	kessoku.Provide(core.NewBackend),
+	kessoku.Bind[remote.Backend](kessoku.Provide(fault.WrapBackend)),
+	kessoku.Async(kessoku.Provide(core.NewUploader)),
+	kessoku.Async(kessoku.Bind[core.BaseBlobProvider](kessoku.Provide(core.NewDownloader))),
+	kessoku.Async(kessoku.Provide(provider.DownloadClientProviderExecutor)),
+	kessoku.Async(kessoku.Provide(provider.UploadClientProviderExecutor)),
+	kessoku.Provide(provider.Switch),
+
+	kessoku.Async(kessoku.Bind[cacheprog.Backend](kessoku.Provide(cacheprog.NewConbinedBackend))),
+)
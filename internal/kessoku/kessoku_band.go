@@ -6,8 +6,10 @@ import (
 	"context"
 	"github.com/mazrean/gocica/internal/cacheprog"
 	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
 	"github.com/mazrean/gocica/internal/remote"
 	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/fault"
 	"github.com/mazrean/gocica/internal/remote/provider"
 	"github.com/mazrean/gocica/log"
 	"github.com/mazrean/gocica/protocol"
@@ -15,9 +17,9 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.DiskDir, ghacacheConfig *provider.GHACacheConfig) (*protocol.Process, error) {
+func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.DiskDir, backendKind provider.BackendKind, ghacacheConfig *provider.GHACacheConfig, hubConfig *provider.HubConfig, cipher *crypt.Cipher, signer *crypt.Signer, commitTimeout cacheprog.CommitTimeout) (*protocol.Process, error) {
 	var (
-		disk                     *local.Disk
+		disk                     local.Backend
 		diskCh                   = make(chan struct{})
 		downloadClientProvider   provider.DownloadClientProvider
 		downloadClientProviderCh = make(chan struct{})
@@ -29,6 +31,7 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 		downloaderCh             = make(chan struct{})
 		uploader                 *core.Uploader
 		backend                  *core.Backend
+		remoteBackend            remote.Backend
 		backendCh                = make(chan struct{})
 		conbinedBackend          *cacheprog.ConbinedBackend
 		cacheProg                *cacheprog.CacheProg
@@ -47,7 +50,7 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 			return err
 		}
 		var err0 error
-		downloader, err0 = kessoku.Async(kessoku.Bind[core.BaseBlobProvider](kessoku.Provide(core.NewDownloader))).Fn()(ctx, logger, downloadClient)
+		downloader, err0 = kessoku.Async(kessoku.Bind[core.BaseBlobProvider](kessoku.Provide(core.NewDownloader))).Fn()(ctx, logger, downloadClient, cipher, signer)
 		if err0 != nil {
 			return err0
 		}
@@ -62,7 +65,7 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 				return ctx.Err()
 			}
 		}
-		uploader = kessoku.Async(kessoku.Provide(core.NewUploader)).Fn()(ctx, logger, uploadClient, downloader)
+		uploader = kessoku.Async(kessoku.Provide(core.NewUploader)).Fn()(ctx, logger, uploadClient, downloader, cipher, signer)
 		for _, ch := range []<-chan struct{}{diskCh, downloaderCh} {
 			select {
 			case <-ch:
@@ -71,10 +74,11 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 			}
 		}
 		var err1 error
-		backend, err1 = kessoku.Bind[remote.Backend](kessoku.Provide(core.NewBackend)).Fn()(logger, disk, uploader, downloader)
+		backend, err1 = kessoku.Provide(core.NewBackend).Fn()(logger, disk, uploader, downloader)
 		if err1 != nil {
 			return err1
 		}
+		remoteBackend = kessoku.Bind[remote.Backend](kessoku.Provide(fault.WrapBackend)).Fn()(backend)
 		close(backendCh)
 		return nil
 	})
@@ -87,7 +91,7 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 			}
 		}
 		var err2 error
-		conbinedBackend, err2 = kessoku.Async(kessoku.Bind[cacheprog.Backend](kessoku.Provide(cacheprog.NewConbinedBackend))).Fn()(logger, disk, backend)
+		conbinedBackend, err2 = kessoku.Async(kessoku.Bind[cacheprog.Backend](kessoku.Provide(cacheprog.NewConbinedBackend))).Fn()(logger, disk, remoteBackend, commitTimeout)
 		if err2 != nil {
 			return err2
 		}
@@ -96,14 +100,14 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 		return nil
 	})
 	var err3 error
-	disk, err3 = kessoku.Async(kessoku.Bind[local.Backend](kessoku.Provide(local.NewDisk))).Fn()(logger, diskDir)
+	disk, err3 = kessoku.Async(kessoku.Provide(local.New)).Fn()(ctx, logger, diskDir)
 	if err3 != nil {
 		var zero *protocol.Process
 		return zero, err3
 	}
 	close(diskCh)
 	var err4 error
-	downloadClientProvider, uploadClientProvider, err4 = kessoku.Provide(provider.Switch).Fn()(ctx, logger, ghacacheConfig)
+	downloadClientProvider, uploadClientProvider, err4 = kessoku.Provide(provider.Switch).Fn()(ctx, logger, backendKind, ghacacheConfig, hubConfig)
 	if err4 != nil {
 		var zero *protocol.Process
 		return zero, err4
@@ -121,3 +125,108 @@ func InitializeProcess(ctx context.Context, logger log.Logger, diskDir local.Dis
 	}
 	return process, nil
 }
+
+func InitializeBackend(ctx context.Context, logger log.Logger, diskDir local.DiskDir, backendKind provider.BackendKind, ghacacheConfig *provider.GHACacheConfig, hubConfig *provider.HubConfig, cipher *crypt.Cipher, signer *crypt.Signer, commitTimeout cacheprog.CommitTimeout) (cacheprog.Backend, error) {
+	var (
+		disk                     local.Backend
+		diskCh                   = make(chan struct{})
+		downloadClientProvider   provider.DownloadClientProvider
+		downloadClientProviderCh = make(chan struct{})
+		uploadClientProvider     provider.UploadClientProvider
+		uploadClient             core.UploadClient
+		uploadClientCh           = make(chan struct{})
+		downloadClient           core.DownloadClient
+		downloader               *core.Downloader
+		downloaderCh             = make(chan struct{})
+		uploader                 *core.Uploader
+		backend                  *core.Backend
+		remoteBackend            remote.Backend
+		backendCh                = make(chan struct{})
+		conbinedBackend          *cacheprog.ConbinedBackend
+	)
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		select {
+		case <-downloadClientProviderCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		var err error
+		downloadClient, err = kessoku.Async(kessoku.Provide(provider.DownloadClientProviderExecutor)).Fn()(ctx, downloadClientProvider)
+		if err != nil {
+			return err
+		}
+		var err0 error
+		downloader, err0 = kessoku.Async(kessoku.Bind[core.BaseBlobProvider](kessoku.Provide(core.NewDownloader))).Fn()(ctx, logger, downloadClient, cipher, signer)
+		if err0 != nil {
+			return err0
+		}
+		close(downloaderCh)
+		return nil
+	})
+	eg.Go(func() error {
+		for _, ch := range []<-chan struct{}{uploadClientCh, downloaderCh} {
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		uploader = kessoku.Async(kessoku.Provide(core.NewUploader)).Fn()(ctx, logger, uploadClient, downloader, cipher, signer)
+		for _, ch := range []<-chan struct{}{diskCh, downloaderCh} {
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var err1 error
+		backend, err1 = kessoku.Provide(core.NewBackend).Fn()(logger, disk, uploader, downloader)
+		if err1 != nil {
+			return err1
+		}
+		remoteBackend = kessoku.Bind[remote.Backend](kessoku.Provide(fault.WrapBackend)).Fn()(backend)
+		close(backendCh)
+		return nil
+	})
+	eg.Go(func() error {
+		for _, ch := range []<-chan struct{}{diskCh, backendCh} {
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var err2 error
+		conbinedBackend, err2 = kessoku.Async(kessoku.Bind[cacheprog.Backend](kessoku.Provide(cacheprog.NewConbinedBackend))).Fn()(logger, disk, remoteBackend, commitTimeout)
+		if err2 != nil {
+			return err2
+		}
+		return nil
+	})
+	var err3 error
+	disk, err3 = kessoku.Async(kessoku.Provide(local.New)).Fn()(ctx, logger, diskDir)
+	if err3 != nil {
+		var zero cacheprog.Backend
+		return zero, err3
+	}
+	close(diskCh)
+	var err4 error
+	downloadClientProvider, uploadClientProvider, err4 = kessoku.Provide(provider.Switch).Fn()(ctx, logger, backendKind, ghacacheConfig, hubConfig)
+	if err4 != nil {
+		var zero cacheprog.Backend
+		return zero, err4
+	}
+	close(downloadClientProviderCh)
+	var err5 error
+	uploadClient, err5 = kessoku.Async(kessoku.Provide(provider.UploadClientProviderExecutor)).Fn()(ctx, uploadClientProvider)
+	if err5 != nil {
+		var zero cacheprog.Backend
+		return zero, err5
+	}
+	close(uploadClientCh)
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return conbinedBackend, nil
+}
@@ -9,7 +9,9 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/mazrean/gocica/internal/pkg/osfile"
 	"github.com/mazrean/gocica/log"
 )
 
@@ -17,33 +19,88 @@ type DiskDir string
 
 var _ Backend = &Disk{}
 
+// tempDir is the directory in the cache directory holding the run directories of the objects being written.
+const tempDir = "tmp"
+
+// staleTempAge is the age after which a run directory, or a temporary object written by an older gocica into the cache directory itself,
+// is taken as left by a run that did not exit cleanly. The run directory of a live run changes with every object it writes.
+const staleTempAge = time.Hour
+
 type Disk struct {
 	logger   log.Logger
 	rootPath string
+	// runTempPath is the directory of the objects being written by this run, removed at Close,
+	// so that the objects of a download racing with the shutdown are not left in the cache directory.
+	runTempPath string
 
 	objectMapLocker sync.RWMutex
 	objectMap       map[string]*objectLocker
 }
 
 func NewDisk(logger log.Logger, dir DiskDir) (*Disk, error) {
-	strDir := string(dir)
+	// The objects are given to the go command by their paths, which the os package on Windows
+	// lifts over MAX_PATH only when they are absolute.
+	strDir, err := filepath.Abs(string(dir))
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path of root directory: %w", err)
+	}
 
-	err := os.MkdirAll(strDir, 0755)
+	err = os.MkdirAll(strDir, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("create root directory: %w", err)
 	}
 
+	cleanStaleTemp(logger, strDir)
+
+	err = os.MkdirAll(filepath.Join(strDir, tempDir), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create temporary directory: %w", err)
+	}
+	runTempPath, err := os.MkdirTemp(filepath.Join(strDir, tempDir), "run-*")
+	if err != nil {
+		return nil, fmt.Errorf("create run directory: %w", err)
+	}
+
 	logger.Infof("disk backend initialized.")
 
 	disk := &Disk{
-		logger:    logger,
-		rootPath:  strDir,
-		objectMap: map[string]*objectLocker{},
+		logger:      logger,
+		rootPath:    strDir,
+		runTempPath: runTempPath,
+		objectMap:   map[string]*objectLocker{},
 	}
 
 	return disk, nil
 }
 
+// cleanStaleTemp removes the run directories, and the temporary objects of older gocica in the cache directory itself, not changed for staleTempAge.
+// Other runs sharing the cache directory keep writing to theirs.
+func cleanStaleTemp(logger log.Logger, dir string) {
+	var paths []string
+	if matches, err := filepath.Glob(filepath.Join(dir, "tmp-o-*")); err == nil {
+		paths = append(paths, matches...)
+	}
+	if matches, err := filepath.Glob(filepath.Join(dir, tempDir, "run-*")); err == nil {
+		paths = append(paths, matches...)
+	}
+
+	removed := 0
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		if err := osfile.RemoveAll(path); err != nil {
+			logger.Warnf("failed to remove stale temporary file %s: %v", path, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		logger.Infof("removed %d temporary files left by runs that did not exit cleanly", removed)
+	}
+}
+
 type objectLocker struct {
 	l  sync.RWMutex
 	ok bool
@@ -78,8 +135,9 @@ var ErrSizeMismatch = errors.New("size mismatch")
 func (d *Disk) Put(_ context.Context, outputID string, _ int64) (string, io.WriteCloser, error) {
 	outputFilePath := d.objectFilePath(outputID)
 
-	var f *os.File
-	f, err := os.Create(outputFilePath)
+	// The object is written to a temporary file in the run directory and renamed at Close, so that a go command or another gocica
+	// reading the object from a previous run never sees it truncated while it is written again.
+	f, err := d.createTemp("o-*")
 	if err != nil {
 		return "", nil, fmt.Errorf("create output file: %w", err)
 	}
@@ -101,11 +159,13 @@ func (d *Disk) Put(_ context.Context, outputID string, _ int64) (string, io.Writ
 	d.logger.Debugf("write lock acquired outputID=%s", outputID)
 	wrapped := &WriteCloserWithUnlock{
 		WriteCloser: f,
-		unlock: sync.OnceFunc(func() {
-			d.logger.Debugf("lock released outputID=%s", outputID)
-			l.ok = true
+		path:        outputFilePath,
+		tmpPath:     f.Name(),
+		unlock: func(ok bool) {
+			d.logger.Debugf("lock released outputID=%s, ok=%t", outputID, ok)
+			l.ok = ok
 			l.l.Unlock()
-		}),
+		},
 	}
 
 	return outputFilePath, wrapped, nil
@@ -113,19 +173,81 @@ func (d *Disk) Put(_ context.Context, outputID string, _ int64) (string, io.Writ
 
 type WriteCloserWithUnlock struct {
 	io.WriteCloser
-	unlock func()
+	path       string
+	tmpPath    string
+	unlockOnce sync.Once
+	unlock     func(ok bool)
 }
 
-func (w *WriteCloserWithUnlock) Close() error {
-	defer w.unlock()
-	return w.WriteCloser.Close()
+// Close closes the object and makes it available at its path.
+func (w *WriteCloserWithUnlock) Close() (err error) {
+	defer w.unlockOnce.Do(func() { w.unlock(err == nil) })
+
+	if err := w.WriteCloser.Close(); err != nil {
+		// The object was closed before, by Close or Abort, or is incomplete.
+		if !errors.Is(err, os.ErrClosed) {
+			_ = osfile.Remove(w.tmpPath)
+		}
+		return err
+	}
+
+	if err := osfile.Rename(w.tmpPath, w.path); err != nil {
+		_ = osfile.Remove(w.tmpPath)
+		return fmt.Errorf("rename output file: %w", err)
+	}
+
+	return nil
+}
+
+// File returns the output file, so that its writes can be batched with those of other outputs.
+func (w *WriteCloserWithUnlock) File() *os.File {
+	f, _ := w.WriteCloser.(*os.File)
+	return f
+}
+
+// Abort discards the object written so far, so that it is a miss instead of a corrupted hit.
+// Close after Abort does not make the object available.
+func (w *WriteCloserWithUnlock) Abort() error {
+	defer w.unlockOnce.Do(func() { w.unlock(false) })
+
+	// The object is discarded, so an error closing it does not matter.
+	_ = w.WriteCloser.Close()
+	if err := osfile.Remove(w.tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove output file: %w", err)
+	}
+
+	return nil
+}
+
+// createTemp creates a temporary file in the run directory.
+func (d *Disk) createTemp(pattern string) (*os.File, error) {
+	f, err := osfile.CreateTemp(d.runTempPath, pattern)
+	if errors.Is(err, os.ErrNotExist) {
+		// Another run took the run directory as stale while this one was idle.
+		if err := os.MkdirAll(d.runTempPath, 0755); err != nil {
+			return nil, fmt.Errorf("create run directory: %w", err)
+		}
+		f, err = osfile.CreateTemp(d.runTempPath, pattern)
+	}
+
+	return f, err
 }
 
 func (d *Disk) objectFilePath(id string) string {
-	return filepath.Join(d.rootPath, fmt.Sprintf("o-%s", encodeID(id)))
+	return ObjectPath(DiskDir(d.rootPath), id)
+}
+
+// ObjectPath returns the path of the object of the output in the cache directory.
+func ObjectPath(dir DiskDir, outputID string) string {
+	return filepath.Join(string(dir), fmt.Sprintf("o-%s", encodeID(outputID)))
 }
 
+// Close removes the objects still being written, which are misses for the later runs.
 func (d *Disk) Close(context.Context) error {
+	if err := osfile.RemoveAll(d.runTempPath); err != nil {
+		return fmt.Errorf("remove run directory: %w", err)
+	}
+
 	return nil
 }
 
@@ -2,10 +2,12 @@ package local
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/mazrean/gocica/log"
@@ -257,6 +259,101 @@ func TestDisk_Put(t *testing.T) {
 	}
 }
 
+func TestDisk_Abort(t *testing.T) {
+	t.Parallel()
+
+	const outputID = "mFrrgfLpmiSLw6bjO9ZS7F1d7I5fb2DQO3Br5W5e3U0="
+
+	disk, err := NewDisk(log.DefaultLogger, DiskDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, w, err := disk.Put(context.Background(), outputID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+
+	//nolint:forcetypeassert
+	if err := w.(*WriteCloserWithUnlock).Abort(); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	// Closing after the abort must not make the object available.
+	_ = w.Close()
+
+	gotPath, err := disk.Get(context.Background(), outputID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "" {
+		t.Errorf("aborted object is a hit: %s", gotPath)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("aborted object file remains: %v", err)
+	}
+}
+
+func TestDisk_temp(t *testing.T) {
+	t.Parallel()
+
+	const outputID = "mFrrgfLpmiSLw6bjO9ZS7F1d7I5fb2DQO3Br5W5e3U0="
+
+	dir := t.TempDir()
+	stale := time.Now().Add(-2 * staleTempAge)
+	paths := map[string]bool{
+		// path: whether it is kept
+		filepath.Join(dir, tempDir, "run-stale"): false,
+		filepath.Join(dir, tempDir, "run-live"):  true,
+		filepath.Join(dir, "tmp-o-stale"):        false,
+		filepath.Join(dir, "o-object"):           true,
+	}
+	for path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(path) != "run-live" {
+			if err := os.Chtimes(path, stale, stale); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	disk, err := NewDisk(log.DefaultLogger, DiskDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, kept := range paths {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Errorf("%s: kept %t, want %t", filepath.Base(path), err == nil, kept)
+		}
+	}
+
+	// An object still being written at Close is removed with the run directory.
+	_, w, err := disk.Put(t.Context(), outputID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Close(t.Context()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(disk.runTempPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("run directory remains: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("object written after close is made available")
+	}
+}
+
 func TestEncodeID(t *testing.T) {
 	t.Parallel()
 
@@ -2,7 +2,12 @@ package local
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sync"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/log"
 )
 
 type Backend interface {
@@ -10,3 +15,57 @@ type Backend interface {
 	Put(ctx context.Context, outputID string, size int64) (diskPath string, w io.WriteCloser, err error)
 	Close(ctx context.Context) error
 }
+
+// DiskBackend is the name of the local backend built into gocica.
+const DiskBackend = "disk"
+
+var (
+	selectedLocker  sync.Mutex
+	selectedName    = DiskBackend
+	selectedOptions map[string]string
+)
+
+// SetBackend selects the local backend by the name, either DiskBackend, PackBackend or one registered with backend.RegisterLocal.
+func SetBackend(name string, options map[string]string) error {
+	if name != DiskBackend && name != PackBackend {
+		if _, ok := backend.LookupLocal(name); !ok {
+			return fmt.Errorf("unknown local backend: %s", name)
+		}
+	}
+
+	selectedLocker.Lock()
+	defer selectedLocker.Unlock()
+	selectedName, selectedOptions = name, options
+
+	return nil
+}
+
+// New creates the local backend selected with SetBackend, the disk by default.
+func New(ctx context.Context, logger log.Logger, dir DiskDir) (Backend, error) {
+	selectedLocker.Lock()
+	name, options := selectedName, selectedOptions
+	selectedLocker.Unlock()
+
+	switch name {
+	case DiskBackend:
+		return NewDisk(logger, dir)
+	case PackBackend:
+		return NewPack(logger, dir)
+	}
+
+	factory, ok := backend.LookupLocal(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown local backend: %s", name)
+	}
+	b, err := factory(ctx, backend.Config{
+		Logger:  logger,
+		Dir:     string(dir),
+		Options: options,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s local backend: %w", name, err)
+	}
+	logger.Infof("%s local backend initialized.", name)
+
+	return b, nil
+}
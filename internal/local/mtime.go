@@ -0,0 +1,36 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var restoreMtime atomic.Bool
+
+// SetRestoreMtime sets whether the objects restored from the remote cache get the time of their entries as their mtime,
+// instead of the time they were downloaded.
+func SetRestoreMtime(enabled bool) {
+	restoreMtime.Store(enabled)
+}
+
+// RestoreMtimeEnabled reports whether the mtime of the restored objects is set.
+func RestoreMtimeEnabled() bool {
+	return restoreMtime.Load()
+}
+
+// RestoreMtime sets the mtime of the object at diskPath to timenano, the time of its entry in Unix nanoseconds,
+// when it is enabled with SetRestoreMtime.
+func RestoreMtime(diskPath string, timenano int64) error {
+	if !restoreMtime.Load() || diskPath == "" || timenano <= 0 {
+		return nil
+	}
+
+	t := time.Unix(0, timenano)
+	if err := os.Chtimes(diskPath, t, t); err != nil {
+		return fmt.Errorf("set mtime: %w", err)
+	}
+
+	return nil
+}
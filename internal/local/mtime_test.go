@@ -0,0 +1,44 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreMtime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "object")
+	if err := os.WriteFile(path, []byte("object"), 0o644); err != nil {
+		t.Fatalf("failed to write object: %v", err)
+	}
+	put := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    func(mtime time.Time) bool
+	}{
+		{name: "disabled", enabled: false, want: func(mtime time.Time) bool { return !mtime.Equal(put) }},
+		{name: "enabled", enabled: true, want: func(mtime time.Time) bool { return mtime.Equal(put) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetRestoreMtime(tt.enabled)
+			defer SetRestoreMtime(false)
+
+			if err := RestoreMtime(path, put.UnixNano()); err != nil {
+				t.Fatalf("failed to restore mtime: %v", err)
+			}
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat object: %v", err)
+			}
+			if !tt.want(stat.ModTime()) {
+				t.Errorf("unexpected mtime: %v", stat.ModTime())
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mazrean/gocica/internal/pkg/osfile"
+	"github.com/mazrean/gocica/log"
+)
+
+// PackBackend is the name of the local backend keeping the restored objects in pack files.
+const PackBackend = "pack"
+
+const (
+	// maxPackedObjectSize is the largest object appended to a pack file. Larger objects are written to files of their own,
+	// as the overhead of a file only matters for the small ones.
+	maxPackedObjectSize = 1 << 20
+	// maxPackSize is the size from which the next objects are appended to a new pack file.
+	maxPackSize = 256 << 20
+)
+
+// PackedBackend is a Backend keeping the objects restored from the remote cache in pack files,
+// which are written to a file of their own only when the go command looks them up.
+type PackedBackend interface {
+	Backend
+	// PutPacked returns the writer of the object appended to a pack file once it is closed.
+	// The file of the object gets timenano as its mtime when it is written, as RestoreMtime does.
+	PutPacked(ctx context.Context, outputID string, timenano int64) (io.WriteCloser, error)
+}
+
+var _ PackedBackend = &Pack{}
+
+// Pack is the disk backend appending the objects restored from the remote cache to pack files,
+// like the outputs in the remote blob, instead of creating a file for each of them.
+// Runners with a slow filesystem, e.g. NTFS or overlayfs, spend most of a restore creating files never read.
+// The objects put by the go command are still files, as it reads them right after the put.
+type Pack struct {
+	*Disk
+
+	packLocker sync.Mutex
+	pack       *os.File
+	packSize   int64
+	packs      []*os.File
+
+	indexLocker sync.RWMutex
+	index       map[string]*packedObject
+}
+
+// packedObject is an object in a pack file. l is held for writing until the object is appended.
+type packedObject struct {
+	l        sync.RWMutex
+	ok       bool
+	pack     *os.File
+	offset   int64
+	size     int64
+	timenano int64
+	// spilled is whether the object was too large to be packed, and was written to its file by the Disk.
+	spilled bool
+
+	// extractLocker serializes the writes of the object to its file.
+	extractLocker sync.Mutex
+	diskPath      string
+}
+
+// NewPack creates the pack backend in dir.
+func NewPack(logger log.Logger, dir DiskDir) (*Pack, error) {
+	disk, err := NewDisk(logger, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pack{
+		Disk:  disk,
+		index: map[string]*packedObject{},
+	}, nil
+}
+
+// Get returns the path of the object, writing it to its file on the first lookup when it is in a pack file.
+func (p *Pack) Get(ctx context.Context, outputID string) (string, error) {
+	diskPath, err := p.Disk.Get(ctx, outputID)
+	if err != nil || diskPath != "" {
+		return diskPath, err
+	}
+
+	p.indexLocker.RLock()
+	object, ok := p.index[outputID]
+	p.indexLocker.RUnlock()
+	if !ok {
+		return "", nil
+	}
+
+	object.l.RLock()
+	defer object.l.RUnlock()
+	switch {
+	case !object.ok:
+		return "", nil
+	case object.spilled:
+		return p.Disk.Get(ctx, outputID)
+	}
+
+	return p.extract(ctx, outputID, object)
+}
+
+// extract writes the object in the pack file to its file, once.
+func (p *Pack) extract(ctx context.Context, outputID string, object *packedObject) (string, error) {
+	object.extractLocker.Lock()
+	defer object.extractLocker.Unlock()
+
+	if object.diskPath != "" {
+		return object.diskPath, nil
+	}
+
+	diskPath, w, err := p.Disk.Put(ctx, outputID, object.size)
+	if err != nil {
+		return "", fmt.Errorf("put object: %w", err)
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(object.pack, object.offset, object.size)); err != nil {
+		if aw, ok := w.(*WriteCloserWithUnlock); ok {
+			_ = aw.Abort()
+		}
+		return "", fmt.Errorf("extract object: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("close object: %w", err)
+	}
+
+	if err := RestoreMtime(diskPath, object.timenano); err != nil {
+		p.logger.Debugf("restore mtime: %v", err)
+	}
+	object.diskPath = diskPath
+
+	return diskPath, nil
+}
+
+func (p *Pack) PutPacked(_ context.Context, outputID string, timenano int64) (io.WriteCloser, error) {
+	object := &packedObject{timenano: timenano}
+	object.l.Lock()
+
+	p.indexLocker.Lock()
+	p.index[outputID] = object
+	p.indexLocker.Unlock()
+
+	return &packWriter{pack: p, outputID: outputID, object: object}, nil
+}
+
+// appendObject appends the content to the current pack file, and returns the file and the offset of it.
+func (p *Pack) appendObject(content []byte) (*os.File, int64, error) {
+	p.packLocker.Lock()
+	defer p.packLocker.Unlock()
+
+	if p.pack == nil || p.packSize >= maxPackSize {
+		pack, err := p.createTemp("pack-*")
+		if err != nil {
+			return nil, 0, fmt.Errorf("create pack file: %w", err)
+		}
+		p.pack, p.packSize = pack, 0
+		p.packs = append(p.packs, pack)
+	}
+
+	offset := p.packSize
+	if _, err := p.pack.WriteAt(content, offset); err != nil {
+		return nil, 0, fmt.Errorf("write pack file: %w", err)
+	}
+	p.packSize += int64(len(content))
+
+	return p.pack, offset, nil
+}
+
+// Close removes the pack files. The objects written to their files are kept like the ones of the Disk.
+func (p *Pack) Close(ctx context.Context) error {
+	p.packLocker.Lock()
+	defer p.packLocker.Unlock()
+
+	var errs []error
+	for _, pack := range p.packs {
+		if err := pack.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := osfile.Remove(pack.Name()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.packs, p.pack = nil, nil
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("remove pack files: %w", err)
+	}
+
+	return p.Disk.Close(ctx)
+}
+
+// packWriter buffers an object until it is closed and appended to a pack file,
+// or writes it to its file through the Disk once it is larger than maxPackedObjectSize.
+type packWriter struct {
+	pack     *Pack
+	outputID string
+	object   *packedObject
+
+	buf      bytes.Buffer
+	spilled  io.WriteCloser
+	once     sync.Once
+	released bool
+}
+
+func (w *packWriter) Write(b []byte) (int, error) {
+	if w.spilled != nil {
+		return w.spilled.Write(b)
+	}
+
+	if w.buf.Len()+len(b) <= maxPackedObjectSize {
+		return w.buf.Write(b)
+	}
+
+	_, spilled, err := w.pack.Disk.Put(context.Background(), w.outputID, 0)
+	if err != nil {
+		return 0, fmt.Errorf("put object: %w", err)
+	}
+	w.spilled = spilled
+	if _, err := spilled.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf = bytes.Buffer{}
+
+	return spilled.Write(b)
+}
+
+// Close appends the object to a pack file, and makes it available.
+func (w *packWriter) Close() (err error) {
+	// The object was closed or aborted before.
+	if w.released {
+		return nil
+	}
+	defer w.release(func() { w.object.ok = err == nil })
+
+	if w.spilled != nil {
+		w.object.spilled = true
+		return w.spilled.Close()
+	}
+
+	w.object.pack, w.object.offset, err = w.pack.appendObject(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.object.size = int64(w.buf.Len())
+
+	return nil
+}
+
+// Abort discards the object, so that it is a miss instead of a corrupted hit.
+func (w *packWriter) Abort() error {
+	defer w.release(func() { w.object.ok = false })
+
+	if aw, ok := w.spilled.(*WriteCloserWithUnlock); ok {
+		return aw.Abort()
+	}
+
+	return nil
+}
+
+// release sets the state of the object and unlocks it, only on the first Close or Abort.
+func (w *packWriter) release(set func()) {
+	w.once.Do(func() {
+		set()
+		w.released = true
+		w.object.l.Unlock()
+	})
+}
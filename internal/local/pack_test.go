@@ -0,0 +1,89 @@
+package local
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mazrean/gocica/log"
+)
+
+func TestPack_PutPacked(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		content    []byte
+		abort      bool
+		wantPacked bool
+		wantMiss   bool
+	}{
+		{name: "small", content: []byte("output"), wantPacked: true},
+		{name: "empty", content: []byte{}, wantPacked: true},
+		{name: "large", content: bytes.Repeat([]byte("a"), maxPackedObjectSize+1)},
+		{name: "aborted", content: []byte("output"), abort: true, wantMiss: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			pack, err := NewPack(log.DefaultLogger, DiskDir(dir))
+			if err != nil {
+				t.Fatalf("new pack: %v", err)
+			}
+
+			w, err := pack.PutPacked(t.Context(), "output", 0)
+			if err != nil {
+				t.Fatalf("put packed: %v", err)
+			}
+			if _, err := w.Write(tt.content); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if tt.abort {
+				if err := w.(*packWriter).Abort(); err != nil {
+					t.Fatalf("abort: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			// The packed object has no file until it is looked up.
+			if _, err := os.Stat(ObjectPath(DiskDir(dir), "output")); tt.wantPacked && err == nil {
+				t.Error("packed object has a file before it is looked up")
+			}
+
+			diskPath, err := pack.Get(t.Context(), "output")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			if tt.wantMiss {
+				if diskPath != "" {
+					t.Errorf("got %s, want a miss", diskPath)
+				}
+				return
+			}
+			got, err := os.ReadFile(diskPath)
+			if err != nil {
+				t.Fatalf("read object: %v", err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Errorf("content: got %d bytes, want %d", len(got), len(tt.content))
+			}
+
+			if err := pack.Close(t.Context()); err != nil {
+				t.Fatalf("close pack: %v", err)
+			}
+			packs, err := filepath.Glob(filepath.Join(dir, tempDir, "*", "pack-*"))
+			if err != nil || len(packs) != 0 {
+				t.Errorf("pack files left: %v, %v", packs, err)
+			}
+			if _, err := os.Stat(diskPath); err != nil {
+				t.Errorf("object looked up is removed with the packs: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package modcache keeps the module download cache of the go command in the remote cache,
+// so that a CI job restores its modules from the same blob as the build outputs instead of caching GOMODCACHE on its own.
+// Only cache/download is kept: the go command extracts a module from its zip there without the network,
+// and the extracted trees are several times larger.
+// A file is kept as an output of the blob named by the SHA-256 of its content,
+// so a snapshot only uploads the files added since the blob it is based on.
+package modcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// DownloadDir is the directory of GOMODCACHE kept in the remote cache.
+const DownloadDir = "cache/download"
+
+// concurrency is the number of files hashed, uploaded or restored at the same time.
+const concurrency = 16
+
+var dir atomic.Pointer[string]
+
+// SetDir enables the management of the module cache in d.
+func SetDir(d string) {
+	dir.Store(&d)
+}
+
+// Dir returns the module cache being managed, or "" when the management is disabled.
+func Dir() string {
+	if d := dir.Load(); d != nil {
+		return *d
+	}
+
+	return ""
+}
+
+// Locate returns the GOMODCACHE of the go command.
+func Locate(ctx context.Context) (string, error) {
+	if d := os.Getenv("GOMODCACHE"); d != "" {
+		return d, nil
+	}
+
+	out, err := exec.CommandContext(ctx, "go", "env", "GOMODCACHE").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOMODCACHE: %w", err)
+	}
+
+	d := strings.TrimSpace(string(out))
+	if d == "" {
+		return "", errors.New("go env GOMODCACHE is empty")
+	}
+
+	return d, nil
+}
+
+// OutputID returns the output ID of a file with the SHA-256 hash of its content, in the encoding of the output IDs of the go command.
+func OutputID(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// skip reports whether a file of the download cache is only used while the go command downloads a module.
+func skip(name string) bool {
+	return strings.HasSuffix(name, ".lock") ||
+		strings.HasSuffix(name, ".partial") ||
+		strings.Contains(name, ".tmp")
+}
+
+// mutable reports whether a file of the download cache is rewritten by the go command, e.g. the version lists.
+// Other files are written once for a module version, so their content is not hashed again when their size is unchanged.
+func mutable(name string) bool {
+	return name == "list" || name == "latest"
+}
+
+// PutFunc uploads the content of a file of the module cache as the output.
+type PutFunc func(ctx context.Context, outputID string, size int64, r io.ReadSeeker) error
+
+// Snapshot returns the files of the download cache in dir, uploading with put the ones whose content is not in base.
+// A file whose upload fails is left out, so that the snapshot only lists the files in the blob.
+func Snapshot(ctx context.Context, logger log.Logger, dir string, base *v1.ModCache, put PutFunc) (*v1.ModCache, error) {
+	baseFiles := base.GetFiles()
+	baseOutputs := make(map[string]struct{}, len(baseFiles))
+	for _, file := range baseFiles {
+		baseOutputs[file.OutputId] = struct{}{}
+	}
+
+	var (
+		locker   sync.Mutex
+		files    = make(map[string]*v1.ModFile, len(baseFiles))
+		uploaded int
+		failed   int
+	)
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	root := filepath.Join(dir, filepath.FromSlash(DownloadDir))
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || skip(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return fmt.Errorf("relative path: %w", err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", rel, err)
+		}
+
+		if file, ok := baseFiles[rel]; ok && file.Size == info.Size() && !mutable(d.Name()) {
+			locker.Lock()
+			files[rel] = file
+			locker.Unlock()
+			return nil
+		}
+
+		eg.Go(func() error {
+			file, isNew, err := snapshotFile(egCtx, p, baseOutputs, put)
+			if err != nil {
+				if egCtx.Err() != nil {
+					return egCtx.Err()
+				}
+				logger.Warnf("failed to snapshot %s of the module cache: %v", rel, err)
+
+				locker.Lock()
+				defer locker.Unlock()
+				failed++
+				return nil
+			}
+
+			locker.Lock()
+			defer locker.Unlock()
+			files[rel] = file
+			if isNew {
+				uploaded++
+			}
+			return nil
+		})
+
+		return nil
+	})
+	if waitErr := eg.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("walk module cache: %w", err)
+	}
+
+	logger.Infof("module cache snapshot: files=%d, uploaded=%d, failed=%d", len(files), uploaded, failed)
+
+	return &v1.ModCache{Files: files}, nil
+}
+
+// snapshotFile hashes a file, and uploads it with put unless its content is in base.
+func snapshotFile(ctx context.Context, p string, base map[string]struct{}, put PutFunc) (*v1.ModFile, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, false, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, false, fmt.Errorf("hash: %w", err)
+	}
+
+	file := &v1.ModFile{
+		OutputId: OutputID(h.Sum(nil)),
+		Size:     size,
+	}
+	if _, ok := base[file.OutputId]; ok {
+		return file, false, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, fmt.Errorf("seek: %w", err)
+	}
+	if err := put(ctx, file.OutputId, size, f); err != nil {
+		return nil, false, fmt.Errorf("upload: %w", err)
+	}
+
+	return file, true, nil
+}
+
+// FetchFunc writes the content of the output to w.
+type FetchFunc func(ctx context.Context, outputID string, w io.Writer) error
+
+// Restore writes the files of modCache missing in dir with the content fetched by fetch, and returns the number of files written.
+// A file is renamed into place once it is complete, so that a go command running at the same time never reads a part of it.
+// The .ziphash files are written after the zips they describe, as the go command does.
+func Restore(ctx context.Context, logger log.Logger, dir string, modCache *v1.ModCache, fetch FetchFunc) (int, error) {
+	var missing []string
+	for rel := range modCache.GetFiles() {
+		// The paths come from the remote cache, so the ones escaping the download cache are rejected.
+		if !strings.HasPrefix(path.Clean(rel), DownloadDir+"/") || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			logger.Warnf("ignoring %s of the module cache: outside of %s", rel, DownloadDir)
+			continue
+		}
+
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel))); err == nil {
+			continue
+		}
+		missing = append(missing, rel)
+	}
+	slices.SortFunc(missing, func(x, y string) int {
+		xHash, yHash := strings.HasSuffix(x, ".ziphash"), strings.HasSuffix(y, ".ziphash")
+		switch {
+		case xHash == yHash:
+			return strings.Compare(x, y)
+		case xHash:
+			return 1
+		default:
+			return -1
+		}
+	})
+
+	var restored atomic.Int64
+	restoreFiles := func(rels []string) error {
+		eg, ctx := errgroup.WithContext(ctx)
+		eg.SetLimit(concurrency)
+		for _, rel := range rels {
+			eg.Go(func() error {
+				if err := restoreFile(ctx, filepath.Join(dir, filepath.FromSlash(rel)), modCache.Files[rel], fetch); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					// The go command downloads the module of a file not restored.
+					logger.Warnf("failed to restore %s of the module cache: %v", rel, err)
+					return nil
+				}
+
+				restored.Add(1)
+				return nil
+			})
+		}
+		return eg.Wait()
+	}
+
+	hashes := slices.IndexFunc(missing, func(rel string) bool { return strings.HasSuffix(rel, ".ziphash") })
+	if hashes < 0 {
+		hashes = len(missing)
+	}
+	err := restoreFiles(missing[:hashes])
+	if err == nil {
+		err = restoreFiles(missing[hashes:])
+	}
+	if err != nil {
+		return int(restored.Load()), fmt.Errorf("restore module cache: %w", err)
+	}
+
+	return int(restored.Load()), nil
+}
+
+func restoreFile(ctx context.Context, p string, file *v1.ModFile, fetch FetchFunc) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	err = fetch(ctx, file.OutputId, io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+
+	if got := OutputID(h.Sum(nil)); got != file.OutputId {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, file.OutputId)
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package modcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for rel, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+func outputID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return OutputID(sum[:])
+}
+
+// store keeps the outputs put in memory.
+type store struct {
+	locker  sync.Mutex
+	outputs map[string][]byte
+}
+
+func (s *store) put(_ context.Context, outputID string, _ int64, r io.ReadSeeker) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.outputs[outputID] = content
+
+	return nil
+}
+
+func (s *store) fetch(_ context.Context, outputID string, w io.Writer) error {
+	s.locker.Lock()
+	content, ok := s.outputs[outputID]
+	s.locker.Unlock()
+	if !ok {
+		return fmt.Errorf("output %s not found", outputID)
+	}
+
+	_, err := w.Write(content)
+	return err
+}
+
+func (s *store) ids() []string {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	ids := make([]string, 0, len(s.outputs))
+	for id := range s.outputs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cache/download/example.com/a/@v/v1.0.0.zip":      "zip a",
+		"cache/download/example.com/a/@v/v1.0.0.mod":      "module a",
+		"cache/download/example.com/a/@v/list":            "v1.0.0\n",
+		"cache/download/example.com/a/@v/v1.0.0.lock":     "",
+		"cache/download/example.com/a/@v/v1.1.0.zip.tmp1": "partial",
+		"example.com/a@v1.0.0/a.go":                       "package a",
+	})
+
+	ctx := context.Background()
+	s := &store{outputs: map[string][]byte{}}
+	first, err := Snapshot(ctx, log.DefaultLogger, dir, nil, s.put)
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+
+	var paths []string
+	for rel := range first.Files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	want := []string{
+		"cache/download/example.com/a/@v/list",
+		"cache/download/example.com/a/@v/v1.0.0.mod",
+		"cache/download/example.com/a/@v/v1.0.0.zip",
+	}
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Errorf("files: got %v, want %v", paths, want)
+	}
+	if got := first.Files["cache/download/example.com/a/@v/v1.0.0.zip"]; got.OutputId != outputID("zip a") || got.Size != 5 {
+		t.Errorf("zip: got %+v", got)
+	}
+
+	// The next snapshot only uploads what was added or rewritten.
+	writeFiles(t, dir, map[string]string{
+		"cache/download/example.com/a/@v/v1.1.0.zip": "zip a 1.1",
+		"cache/download/example.com/a/@v/list":       "v1.0.0\nv1.1.0\n",
+	})
+	next := &store{outputs: map[string][]byte{}}
+	second, err := Snapshot(ctx, log.DefaultLogger, dir, first, next.put)
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if len(second.Files) != 4 {
+		t.Errorf("expected 4 files, got %d", len(second.Files))
+	}
+	wantIDs := []string{outputID("v1.0.0\nv1.1.0\n"), outputID("zip a 1.1")}
+	sort.Strings(wantIDs)
+	if fmt.Sprint(next.ids()) != fmt.Sprint(wantIDs) {
+		t.Errorf("uploaded: got %v, want %v", next.ids(), wantIDs)
+	}
+}
+
+func TestSnapshot_noModCache(t *testing.T) {
+	t.Parallel()
+
+	s := &store{outputs: map[string][]byte{}}
+	modCache, err := Snapshot(context.Background(), log.DefaultLogger, t.TempDir(), nil, s.put)
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if len(modCache.Files) != 0 {
+		t.Errorf("expected no files, got %v", modCache.Files)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+
+	s := &store{outputs: map[string][]byte{
+		outputID("zip"):     []byte("zip"),
+		outputID("hash"):    []byte("hash"),
+		outputID("local"):   []byte("local"),
+		outputID("escaped"): []byte("escaped"),
+	}}
+	modCache := &v1.ModCache{Files: map[string]*v1.ModFile{
+		"cache/download/example.com/a/@v/v1.0.0.zip":     {OutputId: outputID("zip"), Size: 3},
+		"cache/download/example.com/a/@v/v1.0.0.ziphash": {OutputId: outputID("hash"), Size: 4},
+		"cache/download/example.com/a/@v/v1.0.0.mod":     {OutputId: outputID("remote"), Size: 6},
+		"cache/download/../../escaped":                   {OutputId: outputID("escaped"), Size: 7},
+		"cache/download/example.com/a/@v/broken":         {OutputId: outputID("zip"), Size: 3},
+	}}
+	// A file modified after the upload is not restored with the wrong content.
+	s.outputs[outputID("broken")] = []byte("other")
+	modCache.Files["cache/download/example.com/a/@v/broken"].OutputId = outputID("broken")
+
+	dir := filepath.Join(t.TempDir(), "mod")
+	writeFiles(t, dir, map[string]string{
+		"cache/download/example.com/a/@v/v1.0.0.mod": "local",
+	})
+
+	restored, err := Restore(context.Background(), log.DefaultLogger, dir, modCache, s.fetch)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("expected 2 files restored, got %d", restored)
+	}
+
+	for rel, want := range map[string]string{
+		"cache/download/example.com/a/@v/v1.0.0.zip":     "zip",
+		"cache/download/example.com/a/@v/v1.0.0.ziphash": "hash",
+		"cache/download/example.com/a/@v/v1.0.0.mod":     "local",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", rel, err)
+			continue
+		}
+		if !bytes.Equal(got, []byte(want)) {
+			t.Errorf("%s: got %q, want %q", rel, got, want)
+		}
+	}
+
+	for _, p := range []string{
+		filepath.Join(filepath.Dir(dir), "escaped"),
+		filepath.Join(dir, "cache", "download", "example.com", "a", "@v", "broken"),
+	} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be restored, got %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package audit records the outputs transferred to and from the remote cache
+// and writes them as an optionally signed manifest for supply-chain auditing.
+package audit
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+)
+
+// Direction is the direction of a transfer seen from gocica.
+type Direction string
+
+const (
+	Upload   Direction = "upload"
+	Download Direction = "download"
+)
+
+// Entry is a single output transferred to or from the remote.
+type Entry struct {
+	Direction Direction `json:"direction"`
+	OutputID  string    `json:"output_id"`
+	// Size is the size of the output content, before compression.
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded SHA-256 of the output content, before compression.
+	SHA256 string    `json:"sha256"`
+	Time   time.Time `json:"time"`
+}
+
+// Manifest is the audit log of a single gocica run.
+type Manifest struct {
+	Version   int       `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   []Entry   `json:"entries"`
+}
+
+const manifestVersion = 1
+
+var (
+	enabled   atomic.Bool
+	startedAt time.Time
+
+	entriesLocker sync.Mutex
+	entries       []Entry
+)
+
+// Enable starts recording transfers. Recording is off by default to avoid hashing the outputs twice.
+func Enable() {
+	startedAt = time.Now()
+	enabled.Store(true)
+}
+
+// Enabled reports whether transfers are recorded.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Record adds a transfer to the audit log.
+func Record(entry Entry) {
+	if !Enabled() {
+		return
+	}
+
+	entriesLocker.Lock()
+	defer entriesLocker.Unlock()
+	entries = append(entries, entry)
+}
+
+// RecordReader hashes the content of r and records it as an upload.
+// r is rewound to its current position so that it can be uploaded afterwards.
+func RecordReader(outputID string, r io.ReadSeeker) error {
+	if !Enabled() {
+		return nil
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("get output position: %w", err)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return fmt.Errorf("hash output: %w", err)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind output: %w", err)
+	}
+
+	Record(Entry{
+		Direction: Upload,
+		OutputID:  outputID,
+		Size:      size,
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+		Time:      time.Now(),
+	})
+
+	return nil
+}
+
+// recordingWriter hashes the content written through it and records it as a download on Close.
+type recordingWriter struct {
+	io.WriteCloser
+	outputID string
+	h        hash.Hash
+	size     int64
+	once     sync.Once
+}
+
+// NewRecordingWriter wraps w so that the output is recorded as a download when w is closed.
+// It returns w as is when recording is disabled.
+func NewRecordingWriter(w io.WriteCloser, outputID string) io.WriteCloser {
+	if !Enabled() {
+		return w
+	}
+
+	return &recordingWriter{
+		WriteCloser: w,
+		outputID:    outputID,
+		h:           sha256.New(),
+	}
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.h.Write(p[:n])
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the underlying writer. Close may be called more than once, but the output is recorded only once.
+func (w *recordingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.once.Do(func() {
+		if err != nil {
+			return
+		}
+		Record(Entry{
+			Direction: Download,
+			OutputID:  w.outputID,
+			Size:      w.size,
+			SHA256:    hex.EncodeToString(w.h.Sum(nil)),
+			Time:      time.Now(),
+		})
+	})
+
+	return err
+}
+
+// Collect returns the manifest of the transfers recorded so far, ordered by time.
+func Collect() *Manifest {
+	entriesLocker.Lock()
+	collected := slices.Clone(entries)
+	entriesLocker.Unlock()
+
+	slices.SortStableFunc(collected, func(x, y Entry) int {
+		return cmp.Or(x.Time.Compare(y.Time), cmp.Compare(x.Direction, y.Direction), cmp.Compare(x.OutputID, y.OutputID))
+	})
+	if collected == nil {
+		collected = []Entry{}
+	}
+
+	return &Manifest{
+		Version:   manifestVersion,
+		StartedAt: startedAt,
+		CreatedAt: time.Now(),
+		Entries:   collected,
+	}
+}
+
+func (m *Manifest) marshal() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(m); err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes the manifest to path.
+// When signer is not nil, the manifest is wrapped in a signed DSSE envelope.
+func (m *Manifest) WriteFile(path string, signer *Signer) error {
+	payload, err := m.marshal()
+	if err != nil {
+		return err
+	}
+
+	if signer != nil {
+		payload, err = signer.envelope(payload)
+		if err != nil {
+			return fmt.Errorf("sign manifest: %w", err)
+		}
+	}
+
+	//nolint:gosec
+	if err := os.WriteFile(path, payload, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	return nil
+}
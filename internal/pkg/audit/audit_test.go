@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+	closed int
+}
+
+func (w *nopWriteCloser) Close() error {
+	w.closed++
+	return nil
+}
+
+func resetEntries(t *testing.T) {
+	t.Helper()
+
+	Enable()
+	entries = nil
+	t.Cleanup(func() {
+		enabled.Store(false)
+		entries = nil
+	})
+}
+
+func TestRecord(t *testing.T) {
+	resetEntries(t)
+
+	content := []byte("output content")
+	sum := sha256.Sum256(content)
+	wantHash := hex.EncodeToString(sum[:])
+
+	r := bytes.NewReader(content)
+	if err := RecordReader("upload-id", r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rest, _ := io.ReadAll(r); !bytes.Equal(rest, content) {
+		t.Errorf("reader was not rewound: got %q", rest)
+	}
+
+	w := &nopWriteCloser{Writer: io.Discard}
+	rw := NewRecordingWriter(w, "download-id")
+	if _, err := rw.Write(content); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Close is called twice by the downloader, but the output must be recorded once.
+	for range 2 {
+		if err := rw.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	manifest := Collect()
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %+v", len(manifest.Entries), manifest.Entries)
+	}
+	directions := map[Direction]string{}
+	for _, entry := range manifest.Entries {
+		if entry.SHA256 != wantHash || entry.Size != int64(len(content)) {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		directions[entry.Direction] = entry.OutputID
+	}
+	if directions[Upload] != "upload-id" || directions[Download] != "download-id" {
+		t.Errorf("unexpected entries: %+v", manifest.Entries)
+	}
+}
+
+func TestManifest_WriteFile(t *testing.T) {
+	resetEntries(t)
+	Record(Entry{Direction: Upload, OutputID: "id", Size: 1, SHA256: "00"})
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := Collect().WriteFile(path, NewSigner(privateKey)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(buf, &envelope); err != nil {
+		t.Fatalf("unmarshal envelope: %v", err)
+	}
+
+	payload, err := envelope.Verify(publicKey)
+	if err != nil {
+		t.Fatalf("verify envelope: %v", err)
+	}
+	if !strings.Contains(string(payload), `"output_id":"id"`) {
+		t.Errorf("unexpected payload: %s", payload)
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if _, err := envelope.Verify(otherKey); err == nil {
+		t.Error("expected verification with another key to fail")
+	}
+}
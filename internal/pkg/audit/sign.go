@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+)
+
+// PayloadType is the DSSE payload type of the manifest.
+const PayloadType = "application/vnd.gocica.audit.v1+json"
+
+// Signer signs manifests with an Ed25519 key.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// LoadSigner reads a PEM encoded PKCS #8 Ed25519 private key,
+// as generated by `openssl genpkey -algorithm ed25519`.
+func LoadSigner(path string) (*Signer, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, errors.New("no PEM block found in key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T: only ed25519 is supported", key)
+	}
+
+	return NewSigner(edKey), nil
+}
+
+// NewSigner creates a Signer from an Ed25519 private key.
+// The key ID is the hex encoded SHA-256 of the public key.
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	//nolint:forcetypeassert
+	keyID := sha256.Sum256(key.Public().(ed25519.PublicKey))
+
+	return &Signer{
+		key:   key,
+		keyID: hex.EncodeToString(keyID[:]),
+	}
+}
+
+// Envelope is a DSSE envelope.
+// ref: https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// pae is the DSSE pre-authentication encoding of the payload.
+func pae(payloadType string, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	buf.Write(payload)
+
+	return buf.Bytes()
+}
+
+func (s *Signer) envelope(payload []byte) ([]byte, error) {
+	envelope := &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []Signature{{
+			KeyID: s.keyID,
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, pae(PayloadType, payload))),
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(envelope); err != nil {
+		return nil, fmt.Errorf("encode envelope: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Verify checks that the envelope is signed by the public key and returns the payload.
+func (e *Envelope) Verify(publicKey ed25519.PublicKey) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+
+	message := pae(e.PayloadType, payload)
+	for _, signature := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(publicKey, message, sig) {
+			return payload, nil
+		}
+	}
+
+	return nil, errors.New("no valid signature")
+}
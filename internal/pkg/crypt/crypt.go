@@ -0,0 +1,236 @@
+// Package crypt encrypts cache blobs with AES-256-GCM before they leave the runner
+// and signs their headers with Ed25519.
+//
+// Outputs are split into segments sealed one by one, so that they can be decrypted
+// while downloading without holding a whole output in memory.
+// Every segment is sealed with a random nonce generated by the GCM implementation,
+// which is the FIPS 140-3 approved way to use AES-GCM, and authenticates the segment counter
+// and a flag marking the last segment, which prevents segments from being reordered, dropped or truncated.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize is the size of an AES-256 key.
+	KeySize = 32
+
+	segmentSize = 64 << 10
+	// segmentOverhead is the size of the nonce and the tag of a sealed segment.
+	segmentOverhead = 12 + 16
+)
+
+// Cipher encrypts and decrypts outputs and headers.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// ParseKey decodes a base64 encoded 32 byte key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// keyFilePrefix marks a key reference read from a file, e.g. one written by a KMS or secret manager agent.
+const keyFilePrefix = "file:"
+
+// LoadKey resolves a key reference: a base64 encoded key, or file:<path> to read the base64 encoded key from a file.
+func LoadKey(ref string) ([]byte, error) {
+	path, ok := strings.CutPrefix(ref, keyFilePrefix)
+	if !ok {
+		return ParseKey(ref)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	return ParseKey(strings.TrimSpace(string(buf)))
+}
+
+// New creates a Cipher from a 32 byte key.
+func New(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create block cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCMWithRandomNonce(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// segmentAAD binds a segment to its output, its position and whether it is the last one.
+func segmentAAD(aad []byte, counter uint32, last bool) []byte {
+	segmentAAD := make([]byte, 0, len(aad)+5)
+	segmentAAD = append(segmentAAD, aad...)
+	segmentAAD = binary.BigEndian.AppendUint32(segmentAAD, counter)
+	if last {
+		return append(segmentAAD, 1)
+	}
+
+	return append(segmentAAD, 0)
+}
+
+// Encrypt encrypts an output. aad, e.g. the output ID, must be given again to decrypt it.
+func (c *Cipher) Encrypt(plaintext, aad []byte) []byte {
+	segments := max(1, (len(plaintext)+segmentSize-1)/segmentSize)
+	dst := make([]byte, 0, len(plaintext)+segments*segmentOverhead)
+	for i := range segments {
+		segment := plaintext[i*segmentSize : min((i+1)*segmentSize, len(plaintext))]
+		//nolint:gosec
+		dst = c.aead.Seal(dst, nil, segment, segmentAAD(aad, uint32(i), i == segments-1))
+	}
+
+	return dst
+}
+
+// EncryptTo encrypts the output read from r to w like Encrypt, one segment at a time,
+// so that only two segments of it are held in memory.
+func (c *Cipher) EncryptTo(w io.Writer, r io.Reader, aad []byte) error {
+	cur, next := make([]byte, segmentSize), make([]byte, segmentSize)
+	n, err := readSegment(r, cur)
+	if err != nil {
+		return fmt.Errorf("read segment 0: %w", err)
+	}
+
+	sealed := make([]byte, 0, segmentSize+segmentOverhead)
+	for counter := uint32(0); ; counter++ {
+		// A full segment is the last one only when nothing follows it.
+		last, nextN := n < segmentSize, 0
+		if !last {
+			nextN, err = readSegment(r, next)
+			if err != nil {
+				return fmt.Errorf("read segment %d: %w", counter+1, err)
+			}
+			last = nextN == 0
+		}
+
+		//nolint:gosec
+		sealed = c.aead.Seal(sealed[:0], nil, cur[:n], segmentAAD(aad, counter, last))
+		if _, err := w.Write(sealed); err != nil {
+			return fmt.Errorf("write segment %d: %w", counter, err)
+		}
+		if last {
+			return nil
+		}
+
+		cur, next, n = next, cur, nextN
+	}
+}
+
+// readSegment fills buf from r, and returns the bytes read, fewer than len(buf) only at the end of r.
+func readSegment(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, nil
+	}
+
+	return n, err
+}
+
+// Overhead returns the size added by Encrypt to a plaintext of the given size.
+func Overhead(size int64) int64 {
+	segments := max(1, (size+segmentSize-1)/segmentSize)
+	return segments * segmentOverhead
+}
+
+// decryptWriter decrypts the segments written to it and writes the plaintext to w.
+type decryptWriter struct {
+	c       *Cipher
+	w       io.WriteCloser
+	aad     []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewDecryptWriter returns a writer decrypting an output encrypted by Encrypt with the same aad.
+// Close must be called to decrypt the last segment; it fails when the output was truncated.
+func (c *Cipher) NewDecryptWriter(w io.WriteCloser, aad []byte) io.WriteCloser {
+	return &decryptWriter{
+		c:   c,
+		w:   w,
+		aad: aad,
+		buf: make([]byte, 0, segmentSize+segmentOverhead),
+	}
+}
+
+func (d *decryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full segment is decrypted only once more data follows, because the last one is sealed differently.
+		if len(d.buf) == segmentSize+segmentOverhead {
+			if err := d.open(false); err != nil {
+				return 0, err
+			}
+		}
+
+		take := min(segmentSize+segmentOverhead-len(d.buf), len(p))
+		d.buf = append(d.buf, p[:take]...)
+		p = p[take:]
+	}
+
+	return n, nil
+}
+
+func (d *decryptWriter) open(last bool) error {
+	plaintext, err := d.c.aead.Open(nil, nil, d.buf, segmentAAD(d.aad, d.counter, last))
+	if err != nil {
+		return fmt.Errorf("decrypt segment %d: %w", d.counter, err)
+	}
+	d.counter++
+	d.buf = d.buf[:0]
+
+	if _, err := d.w.Write(plaintext); err != nil {
+		return fmt.Errorf("write plaintext: %w", err)
+	}
+
+	return nil
+}
+
+func (d *decryptWriter) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	return errors.Join(d.open(true), d.w.Close())
+}
+
+var headerAAD = []byte("gocica-header")
+
+// SealHeader encrypts a serialized header.
+func (c *Cipher) SealHeader(header []byte) []byte {
+	return c.aead.Seal(nil, nil, header, headerAAD)
+}
+
+// OpenHeader decrypts a header encrypted by SealHeader.
+func (c *Cipher) OpenHeader(sealed []byte) ([]byte, error) {
+	header, err := c.aead.Open(nil, nil, sealed, headerAAD)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt header (wrong key or unencrypted cache?): %w", err)
+	}
+
+	return header, nil
+}
@@ -0,0 +1,210 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	c, err := New(key)
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+
+	return c
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCipher(t)
+
+	tests := []struct {
+		name      string
+		size      int
+		writeSize int
+	}{
+		{name: "empty", size: 0, writeSize: 1},
+		{name: "small", size: 100, writeSize: 7},
+		{name: "exactly one segment", size: segmentSize, writeSize: 4096},
+		{name: "several segments", size: 3*segmentSize + 123, writeSize: 10000},
+		{name: "single write", size: 2*segmentSize + 1, writeSize: 1 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			plaintext := make([]byte, tt.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatalf("generate plaintext: %v", err)
+			}
+
+			ciphertext := c.Encrypt(plaintext, []byte("output"))
+			if got, want := int64(len(ciphertext)), int64(tt.size)+Overhead(int64(tt.size)); got != want {
+				t.Errorf("ciphertext size: got %d, want %d", got, want)
+			}
+
+			out := &bufferCloser{}
+			w := c.NewDecryptWriter(out, []byte("output"))
+			for i := 0; i < len(ciphertext); i += tt.writeSize {
+				if _, err := w.Write(ciphertext[i:min(i+tt.writeSize, len(ciphertext))]); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			if !out.closed {
+				t.Error("underlying writer not closed")
+			}
+			if !bytes.Equal(out.Bytes(), plaintext) {
+				t.Error("decrypted content mismatch")
+			}
+		})
+	}
+}
+
+func TestEncryptTo(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCipher(t)
+
+	for _, size := range []int{0, 100, segmentSize, 2 * segmentSize, 3*segmentSize + 123} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			t.Parallel()
+
+			plaintext := make([]byte, size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatalf("generate plaintext: %v", err)
+			}
+
+			// The reader returns short reads, as a decompressor or a spilled buffer may.
+			ciphertext := &bytes.Buffer{}
+			if err := c.EncryptTo(ciphertext, iotest.HalfReader(bytes.NewReader(plaintext)), []byte("output")); err != nil {
+				t.Fatalf("EncryptTo() error = %v", err)
+			}
+			if got, want := int64(ciphertext.Len()), int64(size)+Overhead(int64(size)); got != want {
+				t.Errorf("ciphertext size: got %d, want %d", got, want)
+			}
+
+			out := &bufferCloser{}
+			w := c.NewDecryptWriter(out, []byte("output"))
+			if _, err := w.Write(ciphertext.Bytes()); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), plaintext) {
+				t.Error("decrypted content mismatch")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCipher(t)
+
+	plaintext := make([]byte, 2*segmentSize+10)
+	ciphertext := c.Encrypt(plaintext, []byte("output"))
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		aad        string
+		cipher     *Cipher
+	}{
+		{name: "other output ID", ciphertext: ciphertext, aad: "other"},
+		{name: "truncated at a segment boundary", ciphertext: ciphertext[:2*(segmentSize+segmentOverhead)], aad: "output"},
+		{name: "truncated in the nonce", ciphertext: ciphertext[:3], aad: "output"},
+		{name: "empty", ciphertext: nil, aad: "output"},
+		{name: "other key", ciphertext: ciphertext, aad: "output", cipher: newTestCipher(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			decrypter := c
+			if tt.cipher != nil {
+				decrypter = tt.cipher
+			}
+
+			w := decrypter.NewDecryptWriter(&bufferCloser{}, []byte(tt.aad))
+			_, writeErr := w.Write(tt.ciphertext)
+			closeErr := w.Close()
+			if writeErr == nil && closeErr == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCipher(t)
+
+	sealed := c.SealHeader([]byte("header"))
+
+	header, err := c.OpenHeader(sealed)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if string(header) != "header" {
+		t.Errorf("header: got %q, want %q", header, "header")
+	}
+
+	if _, err := newTestCipher(t).OpenHeader(sealed); err == nil {
+		t.Error("expected an error with another key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "valid", key: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+		{name: "too short", key: "AAAA", wantErr: true},
+		{name: "not base64", key: "not a key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
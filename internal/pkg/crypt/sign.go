@@ -0,0 +1,115 @@
+package crypt
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signer signs cache headers with an Ed25519 key and verifies them.
+// A Signer created from a public key only verifies, which is what untrusted pipelines should be given.
+type Signer struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewSigner creates a Signer. privateKey may be nil to only verify.
+// When publicKey is nil, the public key of privateKey is used.
+func NewSigner(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) (*Signer, error) {
+	if publicKey == nil {
+		if privateKey == nil {
+			return nil, errors.New("no key given")
+		}
+
+		//nolint:forcetypeassert
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+
+	return &Signer{
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}, nil
+}
+
+// LoadSigner reads a PEM encoded PKCS #8 Ed25519 private key and a PEM encoded PKIX Ed25519 public key,
+// as generated by `openssl genpkey -algorithm ed25519` and `openssl pkey -pubout`.
+// Either path may be empty.
+func LoadSigner(privateKeyPath, publicKeyPath string) (*Signer, error) {
+	var (
+		privateKey ed25519.PrivateKey
+		publicKey  ed25519.PublicKey
+	)
+	if privateKeyPath != "" {
+		der, err := readPEM(privateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read private key: %w", err)
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+
+		var ok bool
+		privateKey, ok = key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T: only ed25519 is supported", key)
+		}
+	}
+
+	if publicKeyPath != "" {
+		der, err := readPEM(publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+
+		var ok bool
+		publicKey, ok = key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type %T: only ed25519 is supported", key)
+		}
+	}
+
+	return NewSigner(privateKey, publicKey)
+}
+
+func readPEM(path string) ([]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	return block.Bytes, nil
+}
+
+// CanSign reports whether the Signer has a private key.
+func (s *Signer) CanSign() bool {
+	return s.privateKey != nil
+}
+
+// Sign signs message. It returns nil when the Signer has no private key.
+func (s *Signer) Sign(message []byte) []byte {
+	if s.privateKey == nil {
+		return nil
+	}
+
+	return ed25519.Sign(s.privateKey, message)
+}
+
+// Verify reports whether sig is a valid signature of message.
+func (s *Signer) Verify(message, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(s.publicKey, message, sig)
+}
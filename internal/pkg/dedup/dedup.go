@@ -0,0 +1,81 @@
+// Package dedup uploads each output once, however many puts of however many actions share it.
+// The go command puts the same output for actions with identical results, often at the same time in a parallel build.
+//
+// The uploads are keyed by the output ID, which is the SHA-256 of the content of the output: the go command computes it so,
+// and the puts whose body does not hash to it are refused before they reach the uploads. Keying them by a content hash
+// computed again would coalesce no other uploads, as two outputs with the same content have the same output ID.
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrConflict is returned for a put of an output with another size than the put uploading it.
+// The output IDs are hashes of the contents, so it is a corrupted put, which is not uploaded.
+var ErrConflict = errors.New("output put with another size")
+
+// unknownSize is the size of the outputs known to be uploaded without their size, e.g. the ones of the remote cache.
+const unknownSize = -1
+
+// Uploads runs the upload of each output once. The zero value is ready to use.
+type Uploads struct {
+	// calls are the uploads done or running by the output IDs, as map[string]*call.
+	calls sync.Map
+}
+
+// call is an upload of an output shared by its puts. err is set before done is closed.
+type call struct {
+	size int64
+	done chan struct{}
+	err  error
+}
+
+// closedCh is the done channel of the calls known to be uploaded.
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Known records that the output is already uploaded, e.g. as it is in the remote cache, so that its puts are not uploaded again.
+func (u *Uploads) Known(outputID string) {
+	u.calls.LoadOrStore(outputID, &call{size: unknownSize, done: closedCh})
+}
+
+// Do runs upload unless the output was uploaded or is being uploaded by another put.
+// A put of an output being uploaded waits for the upload, and retries it when it failed,
+// so the output is uploaded unless every put of it fails. A failed upload is forgotten, so that a later put retries it.
+// It reports whether upload was run by this put.
+func (u *Uploads) Do(ctx context.Context, outputID string, size int64, upload func() error) (bool, error) {
+	for {
+		c := &call{size: size, done: make(chan struct{})}
+		value, loaded := u.calls.LoadOrStore(outputID, c)
+		if !loaded {
+			c.err = upload()
+			if c.err != nil {
+				u.calls.CompareAndDelete(outputID, c)
+			}
+			close(c.done)
+
+			return true, c.err
+		}
+
+		//nolint:forcetypeassert
+		running := value.(*call)
+		if running.size != unknownSize && running.size != size {
+			return false, fmt.Errorf("%w: %d, uploading %d", ErrConflict, size, running.size)
+		}
+
+		select {
+		case <-running.done:
+		case <-ctx.Done():
+			return false, context.Cause(ctx)
+		}
+		if running.err == nil {
+			return false, nil
+		}
+	}
+}
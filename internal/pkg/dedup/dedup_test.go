@@ -0,0 +1,197 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploads_concurrentIdentical(t *testing.T) {
+	t.Parallel()
+
+	var (
+		u       Uploads
+		runs    atomic.Int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		errs    = make(chan error, 10)
+	)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := u.Do(context.Background(), "output", 10, func() error {
+				runs.Add(1)
+				<-release
+				return nil
+			})
+			errs <- err
+		}()
+	}
+	// The puts are waiting for the first upload.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("uploaded %d times, want 1", n)
+	}
+
+	uploaded, err := u.Do(context.Background(), "output", 10, func() error {
+		t.Error("uploaded after the upload finished")
+		return nil
+	})
+	if uploaded || err != nil {
+		t.Errorf("got uploaded=%t, err=%v, want false, nil", uploaded, err)
+	}
+}
+
+func TestUploads_Do(t *testing.T) {
+	t.Parallel()
+
+	errUpload := errors.New("upload failed")
+
+	tests := []struct {
+		name      string
+		setup     func(*Uploads)
+		size      int64
+		uploadErr error
+		expectRun bool
+		expectErr error
+	}{
+		{
+			name:      "first put",
+			size:      10,
+			expectRun: true,
+		},
+		{
+			name:  "known output",
+			setup: func(u *Uploads) { u.Known("output") },
+			size:  10,
+		},
+		{
+			name: "uploaded output",
+			setup: func(u *Uploads) {
+				_, _ = u.Do(context.Background(), "output", 10, func() error { return nil })
+			},
+			size: 10,
+		},
+		{
+			name: "conflicting size",
+			setup: func(u *Uploads) {
+				_, _ = u.Do(context.Background(), "output", 10, func() error { return nil })
+			},
+			size:      20,
+			expectErr: ErrConflict,
+		},
+		{
+			name: "retry after a failed upload",
+			setup: func(u *Uploads) {
+				_, _ = u.Do(context.Background(), "output", 10, func() error { return errUpload })
+			},
+			size:      10,
+			expectRun: true,
+		},
+		{
+			name:      "failed upload",
+			size:      10,
+			uploadErr: errUpload,
+			expectRun: true,
+			expectErr: errUpload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var u Uploads
+			if tt.setup != nil {
+				tt.setup(&u)
+			}
+
+			run := false
+			uploaded, err := u.Do(context.Background(), "output", tt.size, func() error {
+				run = true
+				return tt.uploadErr
+			})
+			if !errors.Is(err, tt.expectErr) {
+				t.Errorf("got error %v, want %v", err, tt.expectErr)
+			}
+			if run != tt.expectRun || uploaded != tt.expectRun {
+				t.Errorf("got run=%t, uploaded=%t, want %t", run, uploaded, tt.expectRun)
+			}
+		})
+	}
+}
+
+func TestUploads_waitingPutRetries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		u       Uploads
+		started = make(chan struct{})
+		release = make(chan struct{})
+		runs    atomic.Int32
+	)
+	go func() {
+		_, _ = u.Do(context.Background(), "output", 10, func() error {
+			runs.Add(1)
+			close(started)
+			<-release
+			return errors.New("upload failed")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := u.Do(context.Background(), "output", 10, func() error {
+			runs.Add(1)
+			return nil
+		})
+		done <- err
+	}()
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("uploaded %d times, want 2", n)
+	}
+}
+
+func TestUploads_waitCanceled(t *testing.T) {
+	t.Parallel()
+
+	var (
+		u       Uploads
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	defer close(release)
+	go func() {
+		_, _ = u.Do(context.Background(), "output", 10, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := u.Do(ctx, "output", 10, func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
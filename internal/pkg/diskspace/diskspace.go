@@ -0,0 +1,2 @@
+// Package diskspace reports the free space of the volume of a directory.
+package diskspace
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package diskspace
+
+import "errors"
+
+// Free returns the space available to the user on the volume of dir.
+func Free(string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
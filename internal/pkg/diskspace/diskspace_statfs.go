@@ -0,0 +1,20 @@
+//go:build linux || darwin
+
+package diskspace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Free returns the space available to the user on the volume of dir.
+func Free(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("statfs: %w", err)
+	}
+
+	//nolint:unconvert,gosec
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package diskspace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// Free returns the space available to the user on the volume of dir.
+func Free(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, fmt.Errorf("convert path: %w", err)
+	}
+
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return 0, fmt.Errorf("get disk free space: %w", err)
+	}
+
+	return free, nil
+}
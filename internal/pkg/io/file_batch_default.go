@@ -0,0 +1,12 @@
+//go:build !linux || !iouring
+
+package io
+
+// FileBatchEnabled reports whether the writes of downloaded files are batched.
+// Without io_uring, batching only adds a copy, so the files are written as they are received.
+const FileBatchEnabled = false
+
+// Flush writes the buffered writes to their files one by one.
+func (b *FileBatch) Flush() error {
+	return b.flushSequential()
+}
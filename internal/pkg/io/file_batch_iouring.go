@@ -0,0 +1,38 @@
+//go:build linux && iouring
+
+package io
+
+import (
+	"fmt"
+
+	"github.com/mazrean/gocica/internal/pkg/uring"
+)
+
+// FileBatchEnabled reports whether the writes of downloaded files are batched.
+const FileBatchEnabled = true
+
+// Flush writes the buffered writes to their files with a single io_uring submission.
+// It falls back to writing them one by one when io_uring is not available.
+func (b *FileBatch) Flush() error {
+	ring, err := uring.Default()
+	if err != nil {
+		return b.flushSequential()
+	}
+
+	writes := make([]uring.Write, 0, len(b.files))
+	for _, f := range b.files {
+		if len(f.buf) == 0 {
+			continue
+		}
+		writes = append(writes, uring.Write{FD: int(f.file.Fd()), Buf: f.buf})
+	}
+	if err := ring.WriteAll(writes); err != nil {
+		return fmt.Errorf("write files: %w", err)
+	}
+
+	for _, f := range b.files {
+		f.buf = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mazrean/gocica/stream"
+)
+
+func TestFileBatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		contents [][]byte
+	}{
+		{
+			name:     "single file",
+			contents: [][]byte{[]byte("hello")},
+		},
+		{
+			name:     "multiple files",
+			contents: [][]byte{[]byte("hello"), []byte("world"), bytes.Repeat([]byte("a"), 1<<20)},
+		},
+		{
+			name:     "empty file",
+			contents: [][]byte{[]byte("hello"), {}, []byte("world")},
+		},
+		{
+			name:     "no file",
+			contents: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			batch := NewFileBatch()
+			files := make([]*os.File, 0, len(tt.contents))
+			for i, content := range tt.contents {
+				f, err := os.Create(filepath.Join(dir, fmt.Sprint(i)))
+				if err != nil {
+					t.Fatalf("failed to create file: %v", err)
+				}
+				files = append(files, f)
+
+				w := batch.Add(f)
+				// The content is written in pieces, as a chunk download does.
+				for len(content) > 0 {
+					n := min(len(content), 1000)
+					if _, err := w.Write(content[:n]); err != nil {
+						t.Fatalf("failed to write: %v", err)
+					}
+					content = content[n:]
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("failed to close: %v", err)
+				}
+			}
+
+			if err := batch.Flush(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+
+			for i, f := range files {
+				if err := f.Close(); err != nil {
+					t.Fatalf("failed to close file: %v", err)
+				}
+
+				got, err := os.ReadFile(f.Name())
+				if err != nil {
+					t.Fatalf("failed to read file: %v", err)
+				}
+				if !bytes.Equal(got, tt.contents[i]) {
+					t.Errorf("file %d: got %d bytes, want %d bytes", i, len(got), len(tt.contents[i]))
+				}
+			}
+		})
+	}
+}
+
+// benchmarkChunkFiles is the number of files in a chunk of the benchmarks, as in a chunk of small outputs.
+const benchmarkChunkFiles = 64
+
+func benchmarkFiles(b *testing.B, fileSize int, write func(files []*os.File, chunk []byte) error) {
+	dir := b.TempDir()
+	chunk := bytes.Repeat([]byte("a"), fileSize*benchmarkChunkFiles)
+
+	b.SetBytes(int64(fileSize * benchmarkChunkFiles))
+	b.ResetTimer()
+	for i := range b.N {
+		b.StopTimer()
+		files := make([]*os.File, 0, benchmarkChunkFiles)
+		for j := range benchmarkChunkFiles {
+			f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d-%d", i, j)))
+			if err != nil {
+				b.Fatalf("failed to create file: %v", err)
+			}
+			files = append(files, f)
+		}
+		b.StartTimer()
+
+		if err := write(files, chunk); err != nil {
+			b.Fatalf("failed to write: %v", err)
+		}
+
+		b.StopTimer()
+		for _, f := range files {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkChunkWrite compares writing the files of a chunk as it streams through JoinedWriter
+// with buffering them in a FileBatch and flushing it, which is io_uring with the iouring build tag.
+func BenchmarkChunkWrite(b *testing.B) {
+	for _, fileSize := range []int{1 << 10, 16 << 10, 64 << 10} {
+		// The chunk arrives in reads of this size from the network.
+		const readSize = 32 << 10
+
+		b.Run(fmt.Sprintf("JoinedWriter/%dKiB", fileSize>>10), func(b *testing.B) {
+			benchmarkFiles(b, fileSize, func(files []*os.File, chunk []byte) error {
+				writers := make([]stream.WriterWithSize, 0, len(files))
+				for _, f := range files {
+					writers = append(writers, stream.WriterWithSize{Writer: nopCloser{f}, Size: int64(fileSize)})
+				}
+				jw := stream.NewJoinedWriter(writers...)
+				return writeChunk(jw, chunk, readSize)
+			})
+		})
+
+		b.Run(fmt.Sprintf("FileBatch/%dKiB", fileSize>>10), func(b *testing.B) {
+			benchmarkFiles(b, fileSize, func(files []*os.File, chunk []byte) error {
+				batch := NewFileBatch()
+				writers := make([]stream.WriterWithSize, 0, len(files))
+				for _, f := range files {
+					writers = append(writers, stream.WriterWithSize{Writer: batch.Add(f), Size: int64(fileSize)})
+				}
+				jw := stream.NewJoinedWriter(writers...)
+				if err := writeChunk(jw, chunk, readSize); err != nil {
+					return err
+				}
+				return batch.Flush()
+			})
+		})
+	}
+}
+
+// writeChunk writes chunk to w in pieces of readSize.
+func writeChunk(w *stream.JoinedWriter, chunk []byte, readSize int) error {
+	for len(chunk) > 0 {
+		n := min(len(chunk), readSize)
+		if _, err := w.Write(chunk[:n]); err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+	}
+
+	return nil
+}
+
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+// Package lifecycle stops the goroutines of the process working in the background,
+// e.g. the restoration of the remote cache, the copy of the base blob and the uploads of the puts.
+// They derive their contexts from Context instead of context.Background(), so that Shutdown stops them
+// at the exit of the process, also when the initialization failed halfway and nothing closes what it started.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrShutdown is the cause of the cancellation by Shutdown when it is given none.
+var ErrShutdown = errors.New("process is shutting down")
+
+// Lifecycle cancels the workers started with it together.
+type Lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	workers sync.WaitGroup
+	running atomic.Int64
+}
+
+// New creates a Lifecycle whose context is canceled by Shutdown only.
+func New() *Lifecycle {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &Lifecycle{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Context returns the context of the workers, canceled by Shutdown with its cause.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Go runs f in a goroutine with the context of the workers. Shutdown waits for it to return.
+func (l *Lifecycle) Go(f func(ctx context.Context)) {
+	l.workers.Add(1)
+	l.running.Add(1)
+	go func() {
+		defer l.workers.Done()
+		defer l.running.Add(-1)
+
+		f(l.ctx)
+	}()
+}
+
+// Shutdown cancels the context of the workers with cause, or ErrShutdown when it is nil,
+// and waits for the goroutines started by Go to return until ctx is done.
+func (l *Lifecycle) Shutdown(ctx context.Context, cause error) error {
+	if cause == nil {
+		cause = ErrShutdown
+	}
+	l.cancel(cause)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.workers.Wait()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d workers still running: %w", l.running.Load(), context.Cause(ctx))
+	}
+}
+
+// process is the lifecycle of the process, which the package functions use.
+var process atomic.Pointer[Lifecycle]
+
+func init() {
+	process.Store(New())
+}
+
+// Context returns the context of the workers of the process, canceled by Shutdown.
+func Context() context.Context {
+	return process.Load().Context()
+}
+
+// Go runs f in a goroutine with the context of the workers of the process. Shutdown waits for it to return.
+func Go(f func(ctx context.Context)) {
+	process.Load().Go(f)
+}
+
+// Shutdown cancels the workers of the process and waits for them as Lifecycle.Shutdown does.
+func Shutdown(ctx context.Context, cause error) error {
+	return process.Load().Shutdown(ctx, cause)
+}
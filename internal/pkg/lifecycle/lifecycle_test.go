@@ -0,0 +1,70 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestShutdown(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	errCause := errors.New("initialization failed")
+
+	tests := []struct {
+		name      string
+		cause     error
+		stuck     bool
+		wantCause error
+		wantErr   bool
+	}{
+		{name: "cause", cause: errCause, wantCause: errCause},
+		{name: "no cause", wantCause: ErrShutdown},
+		{name: "stuck worker", cause: errCause, stuck: true, wantCause: errCause, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New()
+
+			causes := make(chan error, 2)
+			for range 2 {
+				l.Go(func(ctx context.Context) {
+					<-ctx.Done()
+					causes <- context.Cause(ctx)
+				})
+			}
+			release := make(chan struct{})
+			if tt.stuck {
+				l.Go(func(context.Context) {
+					<-release
+				})
+			}
+
+			ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+			defer cancel()
+			err := l.Shutdown(ctx, tt.cause)
+			close(release)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Shutdown() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for range 2 {
+				if cause := <-causes; !errors.Is(cause, tt.wantCause) {
+					t.Errorf("cause: got %v, want %v", cause, tt.wantCause)
+				}
+			}
+			if !errors.Is(context.Cause(l.Context()), tt.wantCause) {
+				t.Errorf("context cause: got %v, want %v", context.Cause(l.Context()), tt.wantCause)
+			}
+
+			// The stuck worker returns once it is released.
+			if err := l.Shutdown(t.Context(), nil); err != nil {
+				t.Errorf("second Shutdown() error = %v", err)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@
 package log
 
 import (
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 type Level uint8
@@ -19,22 +21,51 @@ const (
 
 // NewLogger creates a new logger instance
 func NewLogger(level Level) *Logger {
-	return &Logger{
-		level:  level,
-		logger: log.New(os.Stderr, "GoCICa: ", log.LstdFlags|log.Lmicroseconds),
+	return NewLoggerWithWriter(level, os.Stderr)
+}
+
+// NewLoggerWithWriter creates a new logger instance writing to w
+func NewLoggerWithWriter(level Level, w io.Writer) *Logger {
+	l := &Logger{
+		logger: log.New(w, "GoCICa: ", log.LstdFlags|log.Lmicroseconds),
 	}
+	l.SetLevel(level)
+
+	return l
 }
 
 // Logger wraps the standard logger with additional log level functionality
 type Logger struct {
-	level Level
+	// level is a Level, changed by SetLevel while the logger is in use
+	level atomic.Uint32
 	// logger is the underlying standard logger instance
 	logger *log.Logger
+	// sampler limits the debug lines of each log site, or is nil when they are not limited. Changed by SetSample.
+	sampler atomic.Pointer[sampler]
+}
+
+// SetLevel changes the level of the logger, e.g. on a reload of the configuration
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(uint32(level))
+}
+
+// SetSample limits the debug lines of each log site to perSecond lines a second, the others being dropped and counted.
+// 0 logs every line.
+func (l *Logger) SetSample(perSecond int) {
+	if perSecond <= 0 {
+		l.sampler.Store(nil)
+		return
+	}
+	l.sampler.Store(newSampler(perSecond))
+}
+
+func (l *Logger) enabled(level Level) bool {
+	return Level(l.level.Load()) >= level
 }
 
 // Errorf logs a message at ERROR level using printf style formatting
 func (l *Logger) Errorf(format string, args ...any) {
-	if l.level < Error {
+	if !l.enabled(Error) {
 		return
 	}
 	l.logger.Printf("[ERROR] "+format, args...)
@@ -42,7 +73,7 @@ func (l *Logger) Errorf(format string, args ...any) {
 
 // Warnf logs a message at WARN level using printf style formatting
 func (l *Logger) Warnf(format string, args ...any) {
-	if l.level < Warn {
+	if !l.enabled(Warn) {
 		return
 	}
 	l.logger.Printf("[WARN] "+format, args...)
@@ -50,7 +81,7 @@ func (l *Logger) Warnf(format string, args ...any) {
 
 // Infof logs a message at INFO level using printf style formatting
 func (l *Logger) Infof(format string, args ...any) {
-	if l.level < Info {
+	if !l.enabled(Info) {
 		return
 	}
 	l.logger.Printf("[INFO] "+format, args...)
@@ -58,8 +89,18 @@ func (l *Logger) Infof(format string, args ...any) {
 
 // Debugf logs a message at DEBUG level using printf style formatting
 func (l *Logger) Debugf(format string, args ...any) {
-	if l.level < Debug {
+	if !l.enabled(Debug) {
 		return
 	}
+	if s := l.sampler.Load(); s != nil {
+		ok, dropped := s.admit(format)
+		if !ok {
+			return
+		}
+		if dropped > 0 {
+			l.logger.Printf("[DEBUG] "+format+" (%d similar lines dropped)", append(args[:len(args):len(args)], dropped)...)
+			return
+		}
+	}
 	l.logger.Printf("[DEBUG] "+format, args...)
 }
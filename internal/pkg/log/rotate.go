@@ -0,0 +1,92 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxLogBackups is the number of rotated log files kept next to the current one.
+const maxLogBackups = 3
+
+// RotatingFile is a log file that is rotated when it grows beyond maxSize.
+// Rotated files are renamed to path.1, path.2, ... and the oldest one is removed.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+
+	locker sync.Mutex
+	f      *os.File
+	size   int64
+}
+
+// OpenRotatingFile opens path for appending. maxSize <= 0 disables the rotation.
+func OpenRotatingFile(path string, maxSize int64) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	//nolint:gosec
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+
+	r.f = f
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		// Missing backups are expected until the file has been rotated maxLogBackups times.
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("rename log file: %w", err)
+	}
+
+	return r.open()
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	return r.f.Close()
+}
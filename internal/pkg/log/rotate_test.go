@@ -0,0 +1,55 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gocica.log")
+
+	r, err := OpenRotatingFile(path, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n", "eeeeee\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		path:        "eeeeee\n",
+		path + ".1": "dddddd\n",
+		path + ".2": "cccccc\n",
+		path + ".3": "bbbbbb\n",
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("expected only %d backups, stat .4: %v", maxLogBackups, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "gocica.log") {
+			t.Errorf("unexpected file: %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler limits the debug lines of each log site, told by its format, to a number per second.
+// A site logging more, e.g. on every lock acquisition of a big build, has its other lines of the second dropped,
+// and the next line logged from it counts them, so that the contention is still told.
+type sampler struct {
+	// perSecond is the most lines of a site logged in a second.
+	perSecond int
+	now       func() time.Time
+	// sites are the windows of the sites, as map[string]*site.
+	sites sync.Map
+}
+
+type site struct {
+	locker sync.Mutex
+	// second is the Unix second of the window, and logged the lines logged in it.
+	second  int64
+	logged  int
+	dropped int
+}
+
+func newSampler(perSecond int) *sampler {
+	return &sampler{
+		perSecond: perSecond,
+		now:       time.Now,
+	}
+}
+
+// admit reports whether a line of the site of format is logged,
+// and the number of lines of the site dropped since the last one logged.
+func (s *sampler) admit(format string) (bool, int) {
+	value, ok := s.sites.Load(format)
+	if !ok {
+		value, _ = s.sites.LoadOrStore(format, &site{})
+	}
+	//nolint:forcetypeassert
+	st := value.(*site)
+
+	second := s.now().Unix()
+
+	st.locker.Lock()
+	defer st.locker.Unlock()
+
+	if st.second != second {
+		st.second = second
+		st.logged = 0
+	}
+	if st.logged >= s.perSecond {
+		st.dropped++
+		return false, 0
+	}
+	st.logged++
+
+	dropped := st.dropped
+	st.dropped = 0
+
+	return true, dropped
+}
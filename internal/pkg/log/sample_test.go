@@ -0,0 +1,58 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger_SetSample(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLoggerWithWriter(Debug, buf)
+	l.SetSample(2)
+
+	now := time.Unix(100, 0)
+	l.sampler.Load().now = func() time.Time { return now }
+
+	for i := range 5 {
+		l.Debugf("lock acquired outputID=%d", i)
+	}
+	l.Debugf("another site")
+	// Warnings are never dropped.
+	for range 3 {
+		l.Warnf("warning")
+	}
+
+	now = now.Add(time.Second)
+	l.Debugf("lock acquired outputID=%d", 5)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var got []string
+	for _, line := range lines {
+		_, msg, _ := strings.Cut(line, "] ")
+		got = append(got, msg)
+	}
+	want := []string{
+		"lock acquired outputID=0",
+		"lock acquired outputID=1",
+		"another site",
+		"warning",
+		"warning",
+		"warning",
+		"lock acquired outputID=5 (3 similar lines dropped)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines:\ngot  %q\nwant %q", got, want)
+	}
+
+	// 0 logs every line.
+	buf.Reset()
+	l.SetSample(0)
+	for i := range 5 {
+		l.Debugf("lock acquired outputID=%d", i)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 5 {
+		t.Errorf("unsampled lines: got %d, want 5", n)
+	}
+}
@@ -0,0 +1,250 @@
+// Package membuf provides the byte buffers of put bodies and compression within a memory budget.
+// Buffers beyond the budget are spilled to temporary files, so that large builds do not grow the RSS without bound.
+package membuf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/stream"
+)
+
+// maxPooledSize is the largest buffer put back to the pool.
+// Larger buffers are rare, and keeping them would pin their memory for the rest of the run.
+const maxPooledSize = 16 << 20
+
+var (
+	limit    atomic.Int64
+	spillDir atomic.Pointer[string]
+
+	inUse  atomic.Int64
+	peak   atomic.Int64
+	spills atomic.Int64
+
+	pool = sync.Pool{
+		New: func() any {
+			return &[]byte{}
+		},
+	}
+
+	inUseGauge = metrics.NewGauge("membuf_in_use")
+)
+
+// SetLimit sets the memory budget in bytes and the directory buffers beyond it are spilled to.
+// A limit of 0 disables the budget.
+func SetLimit(bytes int64, dir string) {
+	limit.Store(bytes)
+	spillDir.Store(&dir)
+}
+
+// InUse returns the bytes of the buffers held in memory.
+func InUse() int64 {
+	return inUse.Load()
+}
+
+// Peak returns the largest InUse seen in the run.
+func Peak() int64 {
+	return peak.Load()
+}
+
+// Spills returns the number of buffers spilled to temporary files.
+func Spills() int64 {
+	return spills.Load()
+}
+
+func reserve(size int64) bool {
+	for {
+		used := inUse.Load()
+		if l := limit.Load(); l > 0 && used+size > l && used > 0 {
+			return false
+		}
+		if inUse.CompareAndSwap(used, used+size) {
+			break
+		}
+	}
+
+	used := inUse.Load()
+	for {
+		p := peak.Load()
+		if used <= p || peak.CompareAndSwap(p, used) {
+			break
+		}
+	}
+	inUseGauge.Set(float64(used), "")
+
+	return true
+}
+
+func unreserve(size int64) {
+	inUseGauge.Set(float64(inUse.Add(-size)), "")
+}
+
+// Buffer is a byte buffer in memory, or in a temporary file when the memory budget is exceeded.
+type Buffer struct {
+	// mem is nil when the buffer is spilled to file.
+	mem  *[]byte
+	file *os.File
+	size int64
+	res  *resources
+}
+
+// resources are what a Buffer gives back when it is released.
+// They are kept apart from the Buffer and its readers, so that a cleanup can release them once those are unreachable.
+type resources struct {
+	once     sync.Once
+	mem      *[]byte
+	reserved int64
+	file     *os.File
+}
+
+func (r *resources) release() {
+	r.once.Do(func() {
+		if r.mem != nil {
+			unreserve(r.reserved)
+			if cap(*r.mem) <= maxPooledSize {
+				*r.mem = (*r.mem)[:0]
+				pool.Put(r.mem)
+			}
+		}
+		if r.file != nil {
+			_ = r.file.Close()
+			_ = os.Remove(r.file.Name())
+		}
+	})
+}
+
+// New returns a buffer for about size bytes.
+// It is kept in memory while the budget allows, and spilled to a temporary file otherwise.
+// A single buffer larger than the whole budget is still kept in memory when nothing else is,
+// as spilling it would only make the build slower.
+func New(size int64) (*Buffer, error) {
+	if reserve(size) {
+		//nolint:forcetypeassert
+		mem := pool.Get().(*[]byte)
+		*mem = slices.Grow((*mem)[:0], int(size))
+
+		return &Buffer{
+			mem: mem,
+			res: &resources{mem: mem, reserved: size},
+		}, nil
+	}
+
+	dir := ""
+	if d := spillDir.Load(); d != nil {
+		dir = *d
+	}
+	file, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("create spill file: %w", err)
+	}
+	spills.Add(1)
+
+	return &Buffer{
+		file: file,
+		res:  &resources{file: file},
+	}, nil
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	if b.mem != nil {
+		*b.mem = append(*b.mem, p...)
+		n = len(p)
+	} else {
+		n, err = b.file.Write(p)
+	}
+	b.size += int64(n)
+
+	return n, err
+}
+
+// InMemory reports whether the buffer is kept in memory.
+func (b *Buffer) InMemory() bool {
+	return b.mem != nil
+}
+
+// Extend grows an in-memory buffer by n bytes and returns them to be filled in place,
+// which saves the copy of Write when the bytes are produced by a decoder.
+// It panics when the buffer is spilled.
+func (b *Buffer) Extend(n int) []byte {
+	if b.mem == nil {
+		panic("membuf: Extend of a spilled buffer")
+	}
+
+	start := len(*b.mem)
+	*b.mem = slices.Grow(*b.mem, n)[:start+n]
+	b.size += int64(n)
+
+	return (*b.mem)[start:]
+}
+
+// Len returns the number of bytes written.
+func (b *Buffer) Len() int64 {
+	return b.size
+}
+
+// Reader returns a reader of the bytes written, which must not be written to anymore.
+// The buffer is released when Release is called or, failing that, once the reader and its clones are unreachable.
+func (b *Buffer) Reader() stream.ClonableReadSeeker {
+	var at io.ReaderAt
+	if b.mem != nil {
+		at = bytes.NewReader(*b.mem)
+	} else {
+		at = b.file
+	}
+
+	o := &owner{at: at, size: b.size, res: b.res}
+	runtime.AddCleanup(o, (*resources).release, b.res)
+
+	return o.newReader()
+}
+
+// Release gives the buffer back. Readers of it must not be used after that.
+func (b *Buffer) Release() {
+	b.res.release()
+}
+
+// owner is shared by a reader and its clones.
+type owner struct {
+	at   io.ReaderAt
+	size int64
+	res  *resources
+}
+
+func (o *owner) newReader() *reader {
+	return &reader{
+		SectionReader: io.NewSectionReader(o.at, 0, o.size),
+		owner:         o,
+	}
+}
+
+type reader struct {
+	*io.SectionReader
+	owner *owner
+}
+
+func (r *reader) Clone() stream.ClonableReadSeeker {
+	return r.owner.newReader()
+}
+
+// Release gives the buffer of the reader back. The reader and its clones must not be used after that.
+func (r *reader) Release() {
+	r.owner.res.release()
+}
+
+// Release gives the buffer of r back when r is a reader of a Buffer. r and its clones must not be used after that.
+func Release(r io.Reader) {
+	if releaser, ok := r.(interface{ Release() }); ok {
+		releaser.Release()
+	}
+}
@@ -0,0 +1,74 @@
+package membuf
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	SetLimit(10, dir)
+	t.Cleanup(func() { SetLimit(0, "") })
+
+	tests := []struct {
+		name      string
+		held      []int64
+		size      int64
+		wantSpill bool
+	}{
+		{name: "within the budget", size: 10},
+		{name: "over the budget", held: []int64{6}, size: 5, wantSpill: true},
+		{name: "larger than the budget alone", size: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, size := range tt.held {
+				held, err := New(size)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer held.Release()
+			}
+
+			spillsBefore := Spills()
+			buf, err := New(tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := []byte("0123456789abcdefghij"[:tt.size])
+			if _, err := buf.Write(data); err != nil {
+				t.Fatal(err)
+			}
+
+			if spilled := Spills() > spillsBefore; spilled != tt.wantSpill {
+				t.Errorf("spilled: got %v, want %v", spilled, tt.wantSpill)
+			}
+
+			r := buf.Reader()
+			for _, r := range []io.Reader{r, r.Clone()} {
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(data) {
+					t.Errorf("content: got %q, want %q", got, data)
+				}
+			}
+
+			Release(r)
+			// Released twice by the cleanup must not be counted twice.
+			buf.Release()
+
+			if spillFiles, err := os.ReadDir(dir); err != nil || len(spillFiles) != 0 {
+				t.Errorf("spill files remain: %v, %v", spillFiles, err)
+			}
+		})
+	}
+
+	if InUse() != 0 {
+		t.Errorf("in use after release: %d", InUse())
+	}
+}
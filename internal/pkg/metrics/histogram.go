@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Histogram keeps every latency sample per label so that tail percentiles can be reported.
+// Unlike Gauge, it is always enabled because its percentiles are part of the run summary.
+type Histogram struct {
+	name          string
+	samplesLocker sync.Mutex
+	samples       map[string][]time.Duration
+}
+
+var (
+	histogramsLocker = &sync.RWMutex{}
+	histograms       = []*Histogram{}
+)
+
+func NewHistogram(name string) *Histogram {
+	histogram := &Histogram{
+		name:    name,
+		samples: map[string][]time.Duration{},
+	}
+
+	histogramsLocker.Lock()
+	defer histogramsLocker.Unlock()
+
+	histograms = append(histograms, histogram)
+
+	return histogram
+}
+
+func (h *Histogram) Observe(d time.Duration, label string) {
+	h.samplesLocker.Lock()
+	defer h.samplesLocker.Unlock()
+
+	h.samples[label] = append(h.samples[label], d)
+}
+
+func (h *Histogram) Stopwatch(f func(), label string) {
+	start := time.Now()
+	f()
+	h.Observe(time.Since(start), label)
+}
+
+// LatencySummary is the percentile digest of a single histogram label.
+type LatencySummary struct {
+	Name  string        `json:"name"`
+	Label string        `json:"label"`
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// Summaries returns the percentile digests of the labels of the histogram that have samples.
+func (h *Histogram) Summaries() []LatencySummary {
+	h.samplesLocker.Lock()
+	defer h.samplesLocker.Unlock()
+
+	labels := make([]string, 0, len(h.samples))
+	for label := range h.samples {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+
+	summaries := make([]LatencySummary, 0, len(labels))
+	for _, label := range labels {
+		sorted := slices.Clone(h.samples[label])
+		if len(sorted) == 0 {
+			continue
+		}
+		slices.Sort(sorted)
+
+		summaries = append(summaries, LatencySummary{
+			Name:  h.name,
+			Label: label,
+			Count: len(sorted),
+			P50:   percentile(sorted, 0.50),
+			P95:   percentile(sorted, 0.95),
+			P99:   percentile(sorted, 0.99),
+			Max:   sorted[len(sorted)-1],
+		})
+	}
+
+	return summaries
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// LatencySummaries returns the percentile digests of all histograms that have samples.
+func LatencySummaries() []LatencySummary {
+	histogramsLocker.RLock()
+	defer histogramsLocker.RUnlock()
+
+	var summaries []LatencySummary
+	for _, histogram := range histograms {
+		summaries = append(summaries, histogram.Summaries()...)
+	}
+
+	return summaries
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistogram_summaries(t *testing.T) {
+	t.Parallel()
+
+	h := &Histogram{name: "test", samples: map[string][]time.Duration{}}
+	for i := 100; i >= 1; i-- {
+		h.Observe(time.Duration(i)*time.Millisecond, "get")
+	}
+	h.Observe(time.Second, "put")
+
+	want := []LatencySummary{
+		{Name: "test", Label: "get", Count: 100, P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond},
+		{Name: "test", Label: "put", Count: 1, P50: time.Second, P95: time.Second, P99: time.Second, Max: time.Second},
+	}
+	if diff := cmp.Diff(want, h.Summaries()); diff != "" {
+		t.Errorf("summaries mismatch (-want +got):\n%s", diff)
+	}
+}
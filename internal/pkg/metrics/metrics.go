@@ -36,6 +36,37 @@ func WriteMetrics(w io.Writer) error {
 
 	csvWriter.Write([]string{"name", "value", "time", "label"})
 
+	if err := writeGauges(csvWriter); err != nil {
+		return err
+	}
+
+	endTime := strconv.FormatInt(time.Since(startTime).Nanoseconds(), 10)
+	for _, summary := range LatencySummaries() {
+		for _, p := range []struct {
+			suffix string
+			value  time.Duration
+		}{
+			{"_p50", summary.P50},
+			{"_p95", summary.P95},
+			{"_p99", summary.P99},
+			{"_max", summary.Max},
+		} {
+			err := csvWriter.Write([]string{
+				summary.Name + p.suffix,
+				strconv.FormatInt(p.value.Nanoseconds(), 10),
+				endTime,
+				summary.Label,
+			})
+			if err != nil {
+				return fmt.Errorf("write histogram record: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeGauges(csvWriter *csv.Writer) error {
 	gaugesLocker.RLock()
 	defer gaugesLocker.RUnlock()
 
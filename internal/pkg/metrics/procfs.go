@@ -1,10 +1,12 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
 	"github.com/prometheus/procfs"
 )
 
@@ -24,8 +26,15 @@ func InitProcStat() error {
 	}
 
 	ticker := time.NewTicker(100 * time.Millisecond)
-	go func() {
-		for range ticker.C {
+	lifecycle.Go(func(ctx context.Context) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
 			err := getCPUAllStat(fs)
 			if err != nil {
 				log.Printf("failed to get stat: %v", err)
@@ -41,7 +50,7 @@ func InitProcStat() error {
 				log.Printf("failed to get stat: %v", err)
 			}
 		}
-	}()
+	})
 
 	return nil
 }
@@ -0,0 +1,6 @@
+// Package osfile creates, opens, renames and removes the files of the cache directory,
+// which other gocica processes and the go command read and replace at the same time.
+// On Windows, the files are opened sharing their deletion, paths over MAX_PATH get the long path prefix,
+// and the renames and removals failing on a file open in another process are retried,
+// as the POSIX semantics the other platforms give are not there.
+package osfile
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package osfile
+
+import "os"
+
+// CreateTemp creates a new temporary file in dir, like os.CreateTemp.
+func CreateTemp(dir, pattern string) (*os.File, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+// Open opens the file for reading, like os.Open.
+func Open(name string) (*os.File, error) {
+	return os.Open(name)
+}
+
+// Rename renames oldpath to newpath, replacing it, like os.Rename.
+func Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove removes the file or empty directory, like os.Remove.
+func Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll removes path and everything it contains, like os.RemoveAll.
+func RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
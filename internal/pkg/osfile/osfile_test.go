@@ -0,0 +1,71 @@
+package osfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "o-object")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatalf("write object: %v", err)
+	}
+
+	// The object being read, e.g. by another gocica, is replaced, and the reader keeps the content it opened.
+	reader, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer reader.Close()
+
+	f, err := CreateTemp(dir, "o-*")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatalf("write temporary object: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close temporary object: %v", err)
+	}
+
+	if err := Rename(f.Name(), path); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read object: %v", err)
+	}
+	if string(got) != "new" {
+		t.Errorf("object: got %q, want %q", got, "new")
+	}
+	buf := make([]byte, 3)
+	if _, err := reader.Read(buf); err != nil || string(buf) != "old" {
+		t.Errorf("reader: got %q, %v, want %q", buf, err, "old")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+
+	// A temporary object still being written is removed, e.g. by a run taking the run directory as stale.
+	f, err := CreateTemp(dir, "o-*")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer f.Close()
+
+	if err := Remove(f.Name()); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := Open(f.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open() after Remove() error = %v, want os.ErrNotExist", err)
+	}
+	if err := Remove(f.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second Remove() error = %v, want os.ErrNotExist", err)
+	}
+}
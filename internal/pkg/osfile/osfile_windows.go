@@ -0,0 +1,141 @@
+//go:build windows
+
+package osfile
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// maxShortPath is the length from which a path needs the long path prefix.
+	// It is the limit of directories, 12 characters under MAX_PATH for the 8.3 name of a file in them, as in the os package.
+	maxShortPath = 248
+	// shareAll lets other processes read, write, and delete or rename the file while it is open.
+	// Without FILE_SHARE_DELETE, an object being written or read here cannot be replaced or removed by another gocica.
+	shareAll = windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE
+	// retryAttempts is the attempts of a rename or removal failing on a file open in another process.
+	retryAttempts = 8
+	// retryBackoff is the wait before the second attempt, doubled for each later one, about 1.3s in all.
+	retryBackoff = 10 * time.Millisecond
+	// createTempAttempts is the names tried by CreateTemp before it gives up, as os.CreateTemp does.
+	createTempAttempts = 10000
+)
+
+// CreateTemp creates a new temporary file in dir, like os.CreateTemp, opened sharing its deletion.
+// The last "*" of pattern is replaced by a random string.
+func CreateTemp(dir, pattern string) (*os.File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	for range createTempAttempts {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := open(name, windows.GENERIC_READ|windows.GENERIC_WRITE, windows.CREATE_NEW)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+
+		return f, err
+	}
+
+	return nil, &os.PathError{Op: "createtemp", Path: filepath.Join(dir, pattern), Err: os.ErrExist}
+}
+
+// Open opens the file for reading, like os.Open, sharing its deletion,
+// so that the object read can be replaced by another gocica meanwhile.
+func Open(name string) (*os.File, error) {
+	return open(name, windows.GENERIC_READ, windows.OPEN_EXISTING)
+}
+
+// open opens the file of name with the access and the creation disposition, sharing everything.
+func open(name string, access, disposition uint32) (*os.File, error) {
+	path, err := windows.UTF16PtrFromString(longPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	h, err := windows.CreateFile(path, access, shareAll, nil, disposition, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return os.NewFile(uintptr(h), name), nil
+}
+
+// Rename renames oldpath to newpath, replacing it, like os.Rename.
+// A rename failing because newpath is open in a process not sharing its deletion, e.g. the go command reading the object, is retried.
+func Rename(oldpath, newpath string) error {
+	return retry(func() error {
+		return os.Rename(longPath(oldpath), longPath(newpath))
+	})
+}
+
+// Remove removes the file or empty directory, like os.Remove, retrying while it is open in another process.
+func Remove(name string) error {
+	return retry(func() error {
+		return os.Remove(longPath(name))
+	})
+}
+
+// RemoveAll removes path and everything it contains, like os.RemoveAll, retrying while a file of it is open in another process.
+func RemoveAll(path string) error {
+	return retry(func() error {
+		return os.RemoveAll(longPath(path))
+	})
+}
+
+// retry runs op until it succeeds, fails otherwise than on a file open in another process, or runs out of attempts.
+func retry(op func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == retryAttempts || !isSharingViolation(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isSharingViolation reports whether err is of a file open or locked in another process.
+// A file open without FILE_SHARE_DELETE, or deleted but still open, fails a rename over it or its removal with ERROR_ACCESS_DENIED,
+// so it is retried as well; a real lack of permission gives up after the attempts.
+func isSharingViolation(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) ||
+		errors.Is(err, windows.ERROR_LOCK_VIOLATION) ||
+		errors.Is(err, windows.ERROR_ACCESS_DENIED)
+}
+
+// longPath returns path with the long path prefix when it is too long for the Windows API without it.
+// The os package adds the prefix to absolute paths only, so relative ones are made absolute first.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+	if filepath.IsAbs(path) && len(path) < maxShortPath {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil || len(abs) < maxShortPath {
+		return path
+	}
+	if share, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + share
+	}
+
+	return `\\?\` + abs
+}
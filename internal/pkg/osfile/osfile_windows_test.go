@@ -0,0 +1,32 @@
+//go:build windows
+
+package osfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := `C:\cache\` + strings.Repeat("a", maxShortPath)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "short", path: `C:\cache\o-abc`, want: `C:\cache\o-abc`},
+		{name: "long", path: long, want: `\\?\` + long},
+		{name: "long with slashes", path: strings.ReplaceAll(long, `\`, "/"), want: `\\?\` + long},
+		{name: "long UNC", path: `\\server\share\` + strings.Repeat("a", maxShortPath), want: `\\?\UNC\server\share\` + strings.Repeat("a", maxShortPath)},
+		{name: "prefixed", path: `\\?\` + long, want: `\\?\` + long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := longPath(tt.path); got != tt.want {
+				t.Errorf("longPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// APICallStat is the number of calls made to a single remote operation.
+type APICallStat struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Count     int64  `json:"count"`
+}
+
+type apiCallKey struct {
+	service   string
+	operation string
+}
+
+var (
+	apiCalls     sync.Map // apiCallKey -> *atomic.Int64
+	retriedCalls sync.Map // apiCallKey -> *atomic.Int64
+)
+
+// AddAPICall counts a call to a remote service operation.
+func AddAPICall(service, operation string) {
+	addCall(&apiCalls, service, operation)
+}
+
+// AddRetriedCall counts a call to a remote service operation made again after a transient failure or a rate limit.
+// The retry is also counted by AddAPICall.
+func AddRetriedCall(service, operation string) {
+	addCall(&retriedCalls, service, operation)
+}
+
+func addCall(calls *sync.Map, service, operation string) {
+	v, ok := calls.Load(apiCallKey{service, operation})
+	if !ok {
+		v, _ = calls.LoadOrStore(apiCallKey{service, operation}, &atomic.Int64{})
+	}
+
+	//nolint:forcetypeassert
+	v.(*atomic.Int64).Add(1)
+}
+
+func collectAPICalls() []APICallStat {
+	return collectCalls(&apiCalls)
+}
+
+func collectRetriedCalls() []APICallStat {
+	return collectCalls(&retriedCalls)
+}
+
+func collectCalls(calls *sync.Map) []APICallStat {
+	stats := []APICallStat{}
+	calls.Range(func(k, v any) bool {
+		//nolint:forcetypeassert
+		key, count := k.(apiCallKey), v.(*atomic.Int64)
+		stats = append(stats, APICallStat{
+			Service:   key.service,
+			Operation: key.operation,
+			Count:     count.Load(),
+		})
+		return true
+	})
+	slices.SortFunc(stats, func(x, y APICallStat) int {
+		return cmp.Or(cmp.Compare(x.Service, y.Service), cmp.Compare(x.Operation, y.Operation))
+	})
+
+	return stats
+}
+
+// apiBudget is a known per-run limit of a remote service.
+type apiBudget struct {
+	service     string
+	operations  []string
+	limit       int64
+	description string
+}
+
+// budgetWarnRatio is the ratio of a budget at which the summary starts warning.
+const budgetWarnRatio = 0.8
+
+var apiBudgets = []apiBudget{
+	{
+		service:     "azure",
+		operations:  []string{"stage_block", "stage_block_from_url"},
+		limit:       50000,
+		description: "Azure block blobs can commit at most 50,000 blocks",
+	},
+}
+
+// budgetWarnings returns a message for each budget that the calls are approaching.
+func budgetWarnings(stats []APICallStat) []string {
+	var warnings []string
+	for _, budget := range apiBudgets {
+		var used int64
+		for _, stat := range stats {
+			if stat.Service == budget.service && slices.Contains(budget.operations, stat.Operation) {
+				used += stat.Count
+			}
+		}
+
+		if float64(used) >= float64(budget.limit)*budgetWarnRatio {
+			warnings = append(warnings, fmt.Sprintf("%s: %d/%d calls used (%s)", budget.service, used, budget.limit, budget.description))
+		}
+	}
+
+	return warnings
+}
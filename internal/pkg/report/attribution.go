@@ -0,0 +1,117 @@
+package report
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+var (
+	actionsLocker sync.Mutex
+	// actions maps the requested action IDs to whether the last get hit.
+	actions = map[string]bool{}
+)
+
+// RecordAction records the result of a get so that it can be attributed to a package later.
+func RecordAction(actionID string, hit bool) {
+	actionsLocker.Lock()
+	defer actionsLocker.Unlock()
+
+	actions[actionID] = hit
+}
+
+// PackageStat is the cache effectiveness of a single package.
+type PackageStat struct {
+	// Package is the action name reported by the go command, e.g. "build example.com/foo".
+	Package string `json:"package"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+}
+
+const maxPackageStats = 20
+
+// gocachehashPattern matches the final action ID line printed by the go command with GODEBUG=gocachehash=1.
+var gocachehashPattern = regexp.MustCompile(`^HASH\[([^\]]+)\]: ([0-9a-f]{64})$`)
+
+// AttributeFile is Attribute reading the GODEBUG=gocachehash=1 output from path.
+func (s *Summary) AttributeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open attribution log: %w", err)
+	}
+	defer f.Close()
+
+	return s.Attribute(f)
+}
+
+// Attribute correlates the recorded gets with package names found in the
+// GODEBUG=gocachehash=1 output of the go command, and fills Packages with
+// the packages that miss most often.
+func (s *Summary) Attribute(r io.Reader) error {
+	names := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		match := gocachehashPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		id, err := hex.DecodeString(match[2])
+		if err != nil {
+			continue
+		}
+		// cmd/go sends action IDs as base64 encoded bytes in the GOCACHEPROG protocol.
+		names[base64.StdEncoding.EncodeToString(id)] = match[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read attribution log: %w", err)
+	}
+
+	statMap := map[string]*PackageStat{}
+	func() {
+		actionsLocker.Lock()
+		defer actionsLocker.Unlock()
+
+		for actionID, hit := range actions {
+			name, ok := names[actionID]
+			if !ok {
+				name = "unknown"
+			}
+
+			stat, ok := statMap[name]
+			if !ok {
+				stat = &PackageStat{Package: name}
+				statMap[name] = stat
+			}
+			if hit {
+				stat.Hits++
+			} else {
+				stat.Misses++
+			}
+		}
+	}()
+
+	stats := make([]PackageStat, 0, len(statMap))
+	for _, stat := range statMap {
+		if stat.Misses > 0 {
+			stats = append(stats, *stat)
+		}
+	}
+	slices.SortFunc(stats, func(x, y PackageStat) int {
+		return cmp.Or(cmp.Compare(y.Misses, x.Misses), cmp.Compare(x.Package, y.Package))
+	})
+	if len(stats) > maxPackageStats {
+		stats = stats[:maxPackageStats]
+	}
+	s.Packages = stats
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package report
+
+import "sync"
+
+// Sources of a Divergence.
+const (
+	// DivergenceTruncated is an output of the cache shorter than its size, e.g. of a download cut short.
+	DivergenceTruncated = "truncated"
+	// DivergenceCorrupted is an output of the cache of its size whose content is not the one of its output ID,
+	// e.g. of a bad decompression or a transfer altering it.
+	DivergenceCorrupted = "corrupted"
+	// DivergenceRebuild is an output of the cache intact but differing from the one rebuilt,
+	// i.e. the build does not reproduce its output, and the cache is not at fault.
+	DivergenceRebuild = "rebuild"
+)
+
+// maxDivergences is the most divergences kept in the summary.
+const maxDivergences = 100
+
+// Divergence is an output of the cache that differs from the output rebuilt by the go command in the cache verification mode.
+type Divergence struct {
+	ActionID       string `json:"action_id"`
+	Source         string `json:"source"`
+	CachedOutputID string `json:"cached_output_id"`
+	OutputID       string `json:"output_id"`
+}
+
+// CacheVerify is the result of the cache verification mode of the go command (GODEBUG=gocacheverify=1),
+// in which the outputs of the cache are rebuilt and compared with the ones rebuilt.
+type CacheVerify struct {
+	// Checked is the number of outputs of the cache rebuilt, and Matched the ones rebuilt identical.
+	Checked int64 `json:"checked"`
+	Matched int64 `json:"matched"`
+	// Divergences are the first maxDivergences outputs differing.
+	Divergences []Divergence `json:"divergences"`
+}
+
+var cacheVerify struct {
+	sync.Mutex
+	result *CacheVerify
+}
+
+// EnableCacheVerify adds the result of the cache verification mode to the summary.
+func EnableCacheVerify() {
+	cacheVerify.Lock()
+	defer cacheVerify.Unlock()
+
+	if cacheVerify.result == nil {
+		cacheVerify.result = &CacheVerify{Divergences: []Divergence{}}
+	}
+}
+
+// AddCacheVerify records an output of the cache rebuilt, identical when divergence is nil.
+func AddCacheVerify(divergence *Divergence) {
+	cacheVerify.Lock()
+	defer cacheVerify.Unlock()
+
+	result := cacheVerify.result
+	if result == nil {
+		return
+	}
+
+	result.Checked++
+	if divergence == nil {
+		result.Matched++
+		return
+	}
+	if len(result.Divergences) < maxDivergences {
+		result.Divergences = append(result.Divergences, *divergence)
+	}
+}
+
+func collectCacheVerify() *CacheVerify {
+	cacheVerify.Lock()
+	defer cacheVerify.Unlock()
+
+	if cacheVerify.result == nil {
+		return nil
+	}
+
+	result := *cacheVerify.result
+	result.Divergences = append([]Divergence{}, cacheVerify.result.Divergences...)
+
+	return &result
+}
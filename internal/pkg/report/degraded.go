@@ -0,0 +1,63 @@
+package report
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// DegradedStat is the number of requests of an operation that succeeded only in part for a reason,
+// e.g. puts written to the local cache whose upload failed, and the size of their outputs.
+type DegradedStat struct {
+	Operation string `json:"operation"`
+	Reason    string `json:"reason"`
+	Count     int64  `json:"count"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type degradedKey struct {
+	operation string
+	reason    string
+}
+
+type degradedCounter struct {
+	count atomic.Int64
+	bytes atomic.Int64
+}
+
+var degraded sync.Map // degradedKey -> *degradedCounter
+
+// AddDegraded counts a request of the operation whose remote part failed for the reason while its local part succeeded,
+// so that the go command was answered with a success.
+func AddDegraded(operation, reason string, size int64) {
+	v, ok := degraded.Load(degradedKey{operation, reason})
+	if !ok {
+		v, _ = degraded.LoadOrStore(degradedKey{operation, reason}, &degradedCounter{})
+	}
+
+	//nolint:forcetypeassert
+	counter := v.(*degradedCounter)
+	counter.count.Add(1)
+	counter.bytes.Add(size)
+}
+
+func collectDegraded() []DegradedStat {
+	var stats []DegradedStat
+	degraded.Range(func(k, v any) bool {
+		//nolint:forcetypeassert
+		key, counter := k.(degradedKey), v.(*degradedCounter)
+		stats = append(stats, DegradedStat{
+			Operation: key.operation,
+			Reason:    key.reason,
+			Count:     counter.count.Load(),
+			Bytes:     counter.bytes.Load(),
+		})
+		return true
+	})
+	slices.SortFunc(stats, func(x, y DegradedStat) int {
+		return cmp.Or(cmp.Compare(x.Operation, y.Operation), cmp.Compare(x.Reason, y.Reason))
+	})
+
+	return stats
+}
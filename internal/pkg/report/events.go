@@ -0,0 +1,62 @@
+package report
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event is a notable incident of the run, such as the remote refusing uploads.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Message string    `json:"message"`
+}
+
+var (
+	eventsLocker sync.Mutex
+	events       []Event
+)
+
+// AddEvent records an incident to show in the summary.
+func AddEvent(service, message string) {
+	eventsLocker.Lock()
+	defer eventsLocker.Unlock()
+
+	events = append(events, Event{
+		Time:    time.Now(),
+		Service: service,
+		Message: message,
+	})
+}
+
+var initFailure struct {
+	sync.Mutex
+	class string
+}
+
+// SetInitFailure records the class of the failure that left the run without the remote cache.
+func SetInitFailure(class string) {
+	initFailure.Lock()
+	defer initFailure.Unlock()
+
+	initFailure.class = class
+}
+
+func collectInitFailure() string {
+	initFailure.Lock()
+	defer initFailure.Unlock()
+
+	return initFailure.class
+}
+
+func collectEvents() []Event {
+	eventsLocker.Lock()
+	defer eventsLocker.Unlock()
+
+	if events == nil {
+		return []Event{}
+	}
+
+	return slices.Clone(events)
+}
@@ -0,0 +1,184 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const metricPrefix = "gocica_"
+
+// expositionWriter writes the summary in the Prometheus text exposition format,
+// or in the OpenMetrics format when openMetrics is true.
+type expositionWriter struct {
+	w           io.Writer
+	openMetrics bool
+	err         error
+}
+
+func (e *expositionWriter) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+func (e *expositionWriter) family(name, typ, help string) {
+	familyName := metricPrefix + name
+	if e.openMetrics && typ == "counter" {
+		// OpenMetrics names counter families without the _total suffix.
+		familyName = strings.TrimSuffix(familyName, "_total")
+	}
+	e.printf("# HELP %s %s\n", familyName, help)
+	e.printf("# TYPE %s %s\n", familyName, typ)
+}
+
+func (e *expositionWriter) sample(name string, value float64, labels ...string) {
+	e.printf("%s%s", metricPrefix, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+		}
+		e.printf("{%s}", strings.Join(pairs, ","))
+	}
+	e.printf(" %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (s *Summary) writeExposition(w io.Writer, openMetrics bool) error {
+	e := &expositionWriter{w: w, openMetrics: openMetrics}
+
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"cache_hits_total", "Number of gets served from the cache.", s.Hits},
+		{"cache_misses_total", "Number of gets that missed.", s.Misses},
+		{"cache_puts_total", "Number of puts.", s.Puts},
+		{"uploaded_bytes_total", "Bytes sent to the remote after compression.", s.UploadedBytes},
+		{"uploaded_raw_bytes_total", "Bytes handed to the uploader before compression.", s.UploadedRawBytes},
+		{"downloaded_bytes_total", "Bytes read from the remote.", s.DownloadedBytes},
+		{"handler_panics_total", "Requests whose handler panicked.", s.Panics},
+		{"buffer_spills_total", "Buffers spilled to temporary files because of the memory budget.", s.BufferSpills},
+	} {
+		e.family(counter.name, "counter", counter.help)
+		e.sample(counter.name, float64(counter.value))
+	}
+
+	e.family("remote_io_seconds_total", "counter", "Cumulative time spent in remote storage calls.")
+	e.sample("remote_io_seconds_total", s.RemoteIOSeconds)
+
+	e.family("buffer_peak_bytes", "gauge", "Largest memory held by the buffers of put bodies and compression.")
+	e.sample("buffer_peak_bytes", float64(s.BufferPeakBytes))
+
+	e.family("init_failures_total", "counter", "Failures to initialize the remote backend by class.")
+	if s.InitFailure != "" {
+		e.sample("init_failures_total", 1, "class", s.InitFailure)
+	}
+
+	e.family("api_calls_total", "counter", "Number of calls to remote service operations.")
+	for _, call := range s.APICalls {
+		e.sample("api_calls_total", float64(call.Count), "service", call.Service, "operation", call.Operation)
+	}
+
+	e.family("retried_calls_total", "counter", "Number of calls to remote service operations made again after a transient failure or a rate limit.")
+	for _, call := range s.RetriedCalls {
+		e.sample("retried_calls_total", float64(call.Count), "service", call.Service, "operation", call.Operation)
+	}
+
+	e.family("remote_degraded_total", "counter", "Requests answered with a success although their remote part failed, by operation and reason.")
+	for _, stat := range s.RemoteDegraded {
+		e.sample("remote_degraded_total", float64(stat.Count), "operation", stat.Operation, "reason", stat.Reason)
+	}
+
+	e.family("latency_seconds", "summary", "Latency of backend and remote operations.")
+	for _, latency := range s.Latencies {
+		for _, q := range []struct {
+			quantile string
+			seconds  float64
+		}{
+			{"0.5", latency.P50.Seconds()},
+			{"0.95", latency.P95.Seconds()},
+			{"0.99", latency.P99.Seconds()},
+		} {
+			e.sample("latency_seconds", q.seconds, "name", latency.Name, "label", latency.Label, "quantile", q.quantile)
+		}
+		e.sample("latency_seconds_count", float64(latency.Count), "name", latency.Name, "label", latency.Label)
+	}
+
+	if openMetrics {
+		e.printf("# EOF\n")
+	}
+
+	return e.err
+}
+
+// WriteOpenMetricsFile writes the summary to path in the OpenMetrics text format,
+// so that it can be uploaded as a CI artifact.
+func (s *Summary) WriteOpenMetricsFile(path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create metrics file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close metrics file: %w", closeErr)
+		}
+	}()
+
+	if err := s.writeExposition(f, true); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+
+	return nil
+}
+
+// Handler serves the counters collected so far in the Prometheus text exposition format,
+// for the live metrics of a long-running process.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		// An error is of the connection, which leaves nothing to answer.
+		_ = Collect().writeExposition(w, false)
+	})
+}
+
+// Push sends the summary to a Prometheus Pushgateway, replacing the metrics of the job.
+func (s *Summary) Push(ctx context.Context, gatewayURL, job string) error {
+	u, err := url.Parse(gatewayURL)
+	if err != nil {
+		return fmt.Errorf("parse pushgateway url: %w", err)
+	}
+	u = u.JoinPath("metrics", "job", job)
+
+	buf := &bytes.Buffer{}
+	if err := s.writeExposition(buf, false); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), buf)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, body)
+	}
+
+	return nil
+}
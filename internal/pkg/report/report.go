@@ -0,0 +1,383 @@
+// Package report collects per-run statistics and renders the end-of-run summary.
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/log"
+)
+
+// Counter is an always-on monotonic counter.
+type Counter struct {
+	v atomic.Int64
+}
+
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Load() int64 {
+	return c.v.Load()
+}
+
+// Stopwatch runs f and adds the elapsed nanoseconds to the counter.
+func (c *Counter) Stopwatch(f func()) {
+	start := time.Now()
+	f()
+	c.Add(time.Since(start).Nanoseconds())
+}
+
+var (
+	Hits   = &Counter{}
+	Misses = &Counter{}
+	Puts   = &Counter{}
+
+	// UploadedBytes is the number of bytes sent to the remote after compression.
+	UploadedBytes = &Counter{}
+	// UploadedRawBytes is the number of bytes handed to the uploader before compression.
+	UploadedRawBytes = &Counter{}
+	// DownloadedBytes is the number of bytes read from the remote.
+	DownloadedBytes = &Counter{}
+	// RemoteIONanos is the cumulative time spent in remote storage calls.
+	// Calls running in parallel are summed, so it can exceed the wall time.
+	RemoteIONanos = &Counter{}
+	// Panics is the number of requests whose handler panicked and was recovered.
+	Panics = &Counter{}
+	// PolicySkipped is the number of outputs only kept in the local cache by the policy.
+	PolicySkipped = &Counter{}
+	// PolicySkippedBytes is the size of the outputs only kept in the local cache by the policy.
+	PolicySkippedBytes = &Counter{}
+	// AdmissionRejected is the number of outputs only kept in the local cache by the admission.
+	AdmissionRejected = &Counter{}
+	// AdmissionRejectedBytes is the size of the outputs only kept in the local cache by the admission.
+	AdmissionRejectedBytes = &Counter{}
+	// BlockLimitSkipped is the number of outputs only kept in the local cache as the blob had no block left for them.
+	BlockLimitSkipped = &Counter{}
+	// BlockLimitSkippedBytes is the size of the outputs only kept in the local cache by the block limit.
+	BlockLimitSkippedBytes = &Counter{}
+	// DownloadFiltered is the number of outputs of the remote cache not restored by the download filter.
+	DownloadFiltered = &Counter{}
+	// DownloadFilteredBytes is the size of the outputs not restored by the download filter.
+	DownloadFilteredBytes = &Counter{}
+	// RepeatedHits is the number of hits of an output already hit in the run, answered with the same file.
+	RepeatedHits = &Counter{}
+	// RepeatedHitBytes is the size of the outputs of the repeated hits.
+	RepeatedHitBytes = &Counter{}
+	// PendingMisses is the number of misses of outputs of the remote cache the restoration in the background had not written yet.
+	PendingMisses = &Counter{}
+	// PendingMissBytes is the size of the outputs of the pending misses.
+	PendingMissBytes = &Counter{}
+	// PendingWaitHits is the number of hits of outputs a get waited for the restoration to write.
+	PendingWaitHits = &Counter{}
+	// PutChecksumMismatches is the number of puts refused as their body was not the content of their output ID.
+	PutChecksumMismatches = &Counter{}
+	// RestoreChecksumMismatches is the number of outputs of a signed remote cache dropped as their content was not the one of their output ID.
+	RestoreChecksumMismatches = &Counter{}
+)
+
+const maxLargestMisses = 10
+
+// Miss is a cache miss that was later filled by a put.
+type Miss struct {
+	ActionID string `json:"action_id"`
+	OutputID string `json:"output_id"`
+	Size     int64  `json:"size"`
+}
+
+var (
+	largestMissesLocker sync.Mutex
+	largestMisses       []Miss
+)
+
+// AddMiss records a filled miss, keeping only the largest ones.
+func AddMiss(miss Miss) {
+	largestMissesLocker.Lock()
+	defer largestMissesLocker.Unlock()
+
+	if len(largestMisses) >= maxLargestMisses && largestMisses[len(largestMisses)-1].Size >= miss.Size {
+		return
+	}
+
+	i, _ := slices.BinarySearchFunc(largestMisses, miss.Size, func(m Miss, size int64) int {
+		switch {
+		case m.Size > size:
+			return -1
+		case m.Size < size:
+			return 1
+		default:
+			return 0
+		}
+	})
+	largestMisses = slices.Insert(largestMisses, i, miss)
+	if len(largestMisses) > maxLargestMisses {
+		largestMisses = largestMisses[:maxLargestMisses]
+	}
+}
+
+// Summary is the machine-readable end-of-run report.
+type Summary struct {
+	Hits             int64   `json:"hits"`
+	Misses           int64   `json:"misses"`
+	Puts             int64   `json:"puts"`
+	HitRate          float64 `json:"hit_rate"`
+	UploadedBytes    int64   `json:"uploaded_bytes"`
+	UploadedRawBytes int64   `json:"uploaded_raw_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	DownloadedBytes  int64   `json:"downloaded_bytes"`
+	RemoteIOSeconds  float64 `json:"remote_io_seconds"`
+	LargestMisses    []Miss  `json:"largest_misses"`
+	Panics           int64   `json:"panics"`
+	// BufferPeakBytes is the largest memory held by the buffers of put bodies and compression.
+	BufferPeakBytes int64 `json:"buffer_peak_bytes"`
+	// BufferSpills is the number of buffers spilled to temporary files because of --max-memory.
+	BufferSpills int64 `json:"buffer_spills"`
+	// InitFailure is the class of the failure that left the run without the remote cache, if any.
+	InitFailure string `json:"init_failure,omitempty"`
+	// RemoteEntries is the number of entries in the remote cache when it was set up.
+	RemoteEntries int64 `json:"remote_entries"`
+	// PolicySkipped is the number of outputs left out of the remote cache by the policy, and PolicySkippedBytes their size.
+	PolicySkipped      int64 `json:"policy_skipped"`
+	PolicySkippedBytes int64 `json:"policy_skipped_bytes"`
+	// AdmissionRejected is the number of outputs left out of the remote cache by the admission, and AdmissionRejectedBytes their size.
+	AdmissionRejected      int64 `json:"admission_rejected"`
+	AdmissionRejectedBytes int64 `json:"admission_rejected_bytes"`
+	// BlockLimitSkipped is the number of outputs left out of the remote cache by the block limit of the blob, and BlockLimitSkippedBytes their size.
+	BlockLimitSkipped      int64 `json:"block_limit_skipped"`
+	BlockLimitSkippedBytes int64 `json:"block_limit_skipped_bytes"`
+	// DownloadFiltered is the number of outputs not restored by the download filter, and DownloadFilteredBytes their size.
+	DownloadFiltered      int64 `json:"download_filtered"`
+	DownloadFilteredBytes int64 `json:"download_filtered_bytes"`
+	// RepeatedHits is the number of hits of an output already hit in the run, and RepeatedHitBytes their size.
+	// The go command reads the file of the output again, which the page cache of the OS usually holds.
+	RepeatedHits     int64 `json:"repeated_hits"`
+	RepeatedHitBytes int64 `json:"repeated_hit_bytes"`
+	// PendingMisses are the misses of outputs of the remote cache the restoration in the background had not reached yet,
+	// which point to its order rather than a cold cache, and PendingMissBytes their size. They are counted in Misses too.
+	PendingMisses    int64 `json:"pending_misses"`
+	PendingMissBytes int64 `json:"pending_miss_bytes"`
+	// PendingWaitHits are the hits of outputs a get waited for the restoration to write, with --download.pending-wait.
+	PendingWaitHits int64 `json:"pending_wait_hits"`
+	// PutChecksumMismatches are the puts refused as their body did not hash to their output ID,
+	// pointing to a damage between the go command and gocica.
+	PutChecksumMismatches int64 `json:"put_checksum_mismatches"`
+	// RestoreChecksumMismatches are the outputs of a signed remote cache dropped as their content did not hash to their output ID,
+	// pointing to bytes of the blob swapped under its signed header.
+	RestoreChecksumMismatches int64 `json:"restore_checksum_mismatches"`
+	// RemoteDegraded are the requests answered with a success although their remote part failed, e.g. a failed upload.
+	RemoteDegraded []DegradedStat `json:"remote_degraded,omitempty"`
+	// TestCaching is only filled when the go command defeats its caching.
+	TestCaching *TestCaching `json:"test_caching,omitempty"`
+	// CacheVerify is only filled in the cache verification mode of the go command.
+	CacheVerify *CacheVerify `json:"cache_verify,omitempty"`
+
+	Latencies []metrics.LatencySummary `json:"latencies"`
+	APICalls  []APICallStat            `json:"api_calls"`
+	// RetriedCalls are the calls of APICalls made again after a transient failure or a rate limit.
+	RetriedCalls []APICallStat `json:"retried_calls,omitempty"`
+	Events       []Event       `json:"events"`
+	// Packages is only filled when an attribution log is given.
+	Packages []PackageStat `json:"packages,omitempty"`
+}
+
+// Collect builds a Summary from the current counter values.
+func Collect() *Summary {
+	s := &Summary{
+		Hits:                      Hits.Load(),
+		Misses:                    Misses.Load(),
+		Puts:                      Puts.Load(),
+		UploadedBytes:             UploadedBytes.Load(),
+		UploadedRawBytes:          UploadedRawBytes.Load(),
+		DownloadedBytes:           DownloadedBytes.Load(),
+		RemoteIOSeconds:           time.Duration(RemoteIONanos.Load()).Seconds(),
+		Panics:                    Panics.Load(),
+		BufferPeakBytes:           membuf.Peak(),
+		BufferSpills:              membuf.Spills(),
+		InitFailure:               collectInitFailure(),
+		RemoteEntries:             RemoteEntries.Load(),
+		PolicySkipped:             PolicySkipped.Load(),
+		PolicySkippedBytes:        PolicySkippedBytes.Load(),
+		AdmissionRejected:         AdmissionRejected.Load(),
+		AdmissionRejectedBytes:    AdmissionRejectedBytes.Load(),
+		BlockLimitSkipped:         BlockLimitSkipped.Load(),
+		BlockLimitSkippedBytes:    BlockLimitSkippedBytes.Load(),
+		DownloadFiltered:          DownloadFiltered.Load(),
+		DownloadFilteredBytes:     DownloadFilteredBytes.Load(),
+		RepeatedHits:              RepeatedHits.Load(),
+		RepeatedHitBytes:          RepeatedHitBytes.Load(),
+		PendingMisses:             PendingMisses.Load(),
+		PendingMissBytes:          PendingMissBytes.Load(),
+		PendingWaitHits:           PendingWaitHits.Load(),
+		PutChecksumMismatches:     PutChecksumMismatches.Load(),
+		RestoreChecksumMismatches: RestoreChecksumMismatches.Load(),
+		RemoteDegraded:            collectDegraded(),
+		CacheVerify:               collectCacheVerify(),
+		Latencies:                 metrics.LatencySummaries(),
+		APICalls:                  collectAPICalls(),
+		RetriedCalls:              collectRetriedCalls(),
+		Events:                    collectEvents(),
+	}
+	if s.Latencies == nil {
+		s.Latencies = []metrics.LatencySummary{}
+	}
+
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	if s.UploadedBytes > 0 {
+		s.CompressionRatio = float64(s.UploadedRawBytes) / float64(s.UploadedBytes)
+	}
+	if s.CacheVerify == nil {
+		s.TestCaching = collectTestCaching(s.Hits, s.Misses, s.RemoteEntries)
+	} else {
+		// Every get misses on purpose in the cache verification mode.
+		s.TestCaching = collectTestCaching(0, 0, s.RemoteEntries)
+	}
+
+	largestMissesLocker.Lock()
+	defer largestMissesLocker.Unlock()
+	s.LargestMisses = slices.Clone(largestMisses)
+	if s.LargestMisses == nil {
+		s.LargestMisses = []Miss{}
+	}
+
+	return s
+}
+
+// Log prints a concise human-readable summary.
+func (s *Summary) Log(logger log.Logger) {
+	logger.Infof("cache hit: %d, miss: %d, put: %d (hit rate %.1f%%)", s.Hits, s.Misses, s.Puts, s.HitRate*100)
+	logger.Infof("uploaded: %s (raw %s, compression ratio %.2f), downloaded: %s, remote I/O: %.2fs",
+		formatBytes(s.UploadedBytes), formatBytes(s.UploadedRawBytes), s.CompressionRatio,
+		formatBytes(s.DownloadedBytes), s.RemoteIOSeconds)
+	logger.Infof("buffer peak: %s, spilled to disk: %d", formatBytes(s.BufferPeakBytes), s.BufferSpills)
+	if s.PolicySkipped > 0 {
+		logger.Infof("left out of the remote cache by the policy: %d outputs, %s", s.PolicySkipped, formatBytes(s.PolicySkippedBytes))
+	}
+	if s.AdmissionRejected > 0 {
+		logger.Infof("left out of the remote cache by the admission: %d outputs, %s", s.AdmissionRejected, formatBytes(s.AdmissionRejectedBytes))
+	}
+	if s.BlockLimitSkipped > 0 {
+		logger.Infof("left out of the remote cache by the block limit of the blob: %d outputs, %s", s.BlockLimitSkipped, formatBytes(s.BlockLimitSkippedBytes))
+	}
+	if s.DownloadFiltered > 0 {
+		logger.Infof("not restored by the download filter: %d outputs, %s", s.DownloadFiltered, formatBytes(s.DownloadFilteredBytes))
+	}
+	if s.RepeatedHits > 0 {
+		logger.Infof("hits of an output already hit in this run: %d, %s", s.RepeatedHits, formatBytes(s.RepeatedHitBytes))
+	}
+	if s.PendingMisses > 0 {
+		logger.Infof("misses of outputs the restoration of the remote cache had not reached yet: %d, %s. "+
+			"the build asked for them before the download in the background: --download.pending-wait waits for them", s.PendingMisses, formatBytes(s.PendingMissBytes))
+	}
+	if s.PendingWaitHits > 0 {
+		logger.Infof("hits after waiting for the restoration of the remote cache: %d", s.PendingWaitHits)
+	}
+	if s.PutChecksumMismatches > 0 {
+		logger.Warnf("puts refused as their body did not hash to their output ID: %d. "+
+			"the bodies were damaged between the go command and gocica, e.g. by a wrapper of GOCACHEPROG", s.PutChecksumMismatches)
+	}
+	if s.RestoreChecksumMismatches > 0 {
+		logger.Warnf("outputs of the signed remote cache dropped as their content did not hash to their output ID: %d. "+
+			"the blob was written to under its signed header", s.RestoreChecksumMismatches)
+	}
+	for _, stat := range s.RemoteDegraded {
+		logger.Warnf("remote degraded: %d %s requests (%s) succeeded only locally: %s", stat.Count, stat.Operation, formatBytes(stat.Bytes), stat.Reason)
+	}
+	for _, latency := range s.Latencies {
+		logger.Infof("latency %s(%s): count=%d p50=%s p95=%s p99=%s max=%s",
+			latency.Name, latency.Label, latency.Count, latency.P50, latency.P95, latency.P99, latency.Max)
+	}
+	for _, call := range s.APICalls {
+		logger.Infof("api calls %s.%s: %d", call.Service, call.Operation, call.Count)
+	}
+	for _, call := range s.RetriedCalls {
+		logger.Infof("api calls retried %s.%s: %d", call.Service, call.Operation, call.Count)
+	}
+	for _, warning := range budgetWarnings(s.APICalls) {
+		logger.Warnf("approaching remote API limit: %s", warning)
+	}
+	if s.InitFailure != "" {
+		logger.Warnf("the remote cache was not used because of a %s failure", s.InitFailure)
+	}
+	if t := s.TestCaching; t != nil {
+		for _, reason := range t.Uncacheable {
+			logger.Warnf("test results are not cached: %s. see go help test for the cacheable flags.", reason)
+		}
+		if t.Churn {
+			logger.Warnf("most gets missed although the remote cache had %d entries. the action IDs differ from the runs that wrote it: "+
+				"compare the build flags (%s), GOFLAGS, Go version and environment of the jobs sharing the cache.",
+				s.RemoteEntries, formatBuildFlags(t.BuildFlags))
+		}
+	}
+	if v := s.CacheVerify; v != nil {
+		logger.Infof("cache verify: %d outputs of the cache rebuilt, %d identical", v.Checked, v.Matched)
+		for _, d := range v.Divergences {
+			switch d.Source {
+			case DivergenceRebuild:
+				logger.Warnf("cache verify: action %s rebuilt output %s instead of %s. the cached output is intact, so the build does not reproduce it", d.ActionID, d.OutputID, d.CachedOutputID)
+			default:
+				logger.Warnf("cache verify: action %s has a %s output %s in the cache, rebuilt as %s. the cache copy is damaged", d.ActionID, d.Source, d.CachedOutputID, d.OutputID)
+			}
+		}
+	}
+	if s.Panics > 0 {
+		logger.Warnf("%d requests failed with a panic. please report it with the log.", s.Panics)
+	}
+	for _, event := range s.Events {
+		logger.Warnf("%s: %s", event.Service, event.Message)
+	}
+	for i, miss := range s.LargestMisses {
+		logger.Infof("largest miss #%d: action=%s output=%s size=%s", i+1, miss.ActionID, miss.OutputID, formatBytes(miss.Size))
+	}
+	for _, stat := range s.Packages {
+		logger.Infof("package %s: miss %d, hit %d", stat.Package, stat.Misses, stat.Hits)
+	}
+}
+
+// Write encodes the summary as JSON.
+func (s *Summary) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return fmt.Errorf("encode summary: %w", err)
+	}
+
+	return nil
+}
+
+// WriteFile writes the summary as JSON to path.
+func (s *Summary) WriteFile(path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create report file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close report file: %w", closeErr)
+		}
+	}()
+
+	return s.Write(f)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,182 @@
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddMiss(t *testing.T) {
+	largestMisses = nil
+	t.Cleanup(func() {
+		largestMisses = nil
+	})
+
+	for i := range int64(15) {
+		// Insert in a shuffled order to exercise the sorted insertion.
+		size := (i * 7) % 15
+		AddMiss(Miss{ActionID: "a", OutputID: "o", Size: size})
+	}
+
+	got := make([]int64, 0, len(largestMisses))
+	for _, miss := range Collect().LargestMisses {
+		got = append(got, miss.Size)
+	}
+
+	want := []int64{14, 13, 12, 11, 10, 9, 8, 7, 6, 5}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("largest misses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSummary_Write(t *testing.T) {
+	s := &Summary{
+		Hits:             3,
+		Misses:           1,
+		UploadedBytes:    10,
+		UploadedRawBytes: 20,
+		LargestMisses:    []Miss{},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Write(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+
+	for _, key := range []string{"hits", "misses", "uploaded_bytes", "compression_ratio", "largest_misses"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing key %q in report: %s", key, buf.String())
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0B"},
+		{n: 1023, want: "1023B"},
+		{n: 1024, want: "1.0KiB"},
+		{n: 5 * 1024 * 1024, want: "5.0MiB"},
+		{n: 3 * 1024 * 1024 * 1024, want: "3.0GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSummary_Attribute(t *testing.T) {
+	actions = map[string]bool{}
+	t.Cleanup(func() {
+		actions = map[string]bool{}
+	})
+
+	fooID := strings.Repeat("ab", 32)
+	barID := strings.Repeat("cd", 32)
+	RecordAction(hexToActionID(t, fooID), false)
+	RecordAction(hexToActionID(t, barID), true)
+	RecordAction(hexToActionID(t, strings.Repeat("ef", 32)), false)
+
+	log := strings.Join([]string{
+		`HASH[build example.com/foo]: "go1.24"`,
+		`HASH[build example.com/foo]: ` + fooID,
+		`HASH[build example.com/bar]: ` + barID,
+	}, "\n")
+
+	s := &Summary{}
+	if err := s.Attribute(strings.NewReader(log)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []PackageStat{
+		{Package: "build example.com/foo", Misses: 1},
+		{Package: "unknown", Misses: 1},
+	}
+	if diff := cmp.Diff(want, s.Packages); diff != "" {
+		t.Errorf("packages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func hexToActionID(t *testing.T, s string) string {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestSummary_writeExposition(t *testing.T) {
+	s := &Summary{
+		Hits:     3,
+		APICalls: []APICallStat{{Service: "azure", Operation: "stage_block", Count: 2}},
+	}
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "prometheus",
+			openMetrics: false,
+			contains: []string{
+				"# TYPE gocica_cache_hits_total counter\n",
+				"gocica_cache_hits_total 3\n",
+				`gocica_api_calls_total{service="azure",operation="stage_block"} 2` + "\n",
+			},
+			notContains: []string{"# EOF"},
+		},
+		{
+			name:        "openmetrics",
+			openMetrics: true,
+			contains: []string{
+				"# TYPE gocica_cache_hits counter\n",
+				"gocica_cache_hits_total 3\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := s.writeExposition(buf, tt.openMetrics); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := buf.String()
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tt.notContains {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, got)
+				}
+			}
+			if tt.openMetrics && !strings.HasSuffix(got, "# EOF\n") {
+				t.Errorf("openmetrics output must end with # EOF:\n%s", got)
+			}
+		})
+	}
+}
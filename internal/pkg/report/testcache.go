@@ -0,0 +1,205 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RemoteEntries is the number of entries in the remote cache when it was set up.
+var RemoteEntries = &Counter{}
+
+// TestCaching explains why the go command gets few hits, most often because its test results are never cached.
+type TestCaching struct {
+	// Command is the go command line gocica was started by, when it could be read.
+	Command []string `json:"command,omitempty"`
+	// BuildFlags are the flags of the command and GOFLAGS that change every action ID, e.g. -race.
+	// Jobs sharing the cache hit each other only with the same ones.
+	BuildFlags []string `json:"build_flags,omitempty"`
+	// Uncacheable are the reasons the go command does not cache the test results of the run.
+	Uncacheable []string `json:"uncacheable,omitempty"`
+	// Churn is set when most gets missed although the remote cache had entries,
+	// i.e. the action IDs differ from the runs that wrote it.
+	Churn bool `json:"churn"`
+}
+
+var (
+	goCommandLocker sync.Mutex
+	goCommand       []string
+	goFlags         string
+)
+
+// SetGoCommand records the command line of the go command and its GOFLAGS for the summary.
+func SetGoCommand(args []string, goflags string) {
+	goCommandLocker.Lock()
+	defer goCommandLocker.Unlock()
+
+	goCommand = args
+	goFlags = goflags
+}
+
+// BuildFlags returns the flags changing every action ID of the recorded go command and of goflags, e.g. -race.
+// goflags is given as the go command is not recorded by every mode, e.g. the daemon serving many.
+func BuildFlags(goflags string) []string {
+	goCommandLocker.Lock()
+	args := goCommand
+	goCommandLocker.Unlock()
+
+	buildFlags, _ := inspectGoCommand(args, goflags)
+	return buildFlags
+}
+
+// ReadParentCommand returns the command line of the parent process, which is the go command for GOCACHEPROG.
+// It is only available on systems with procfs.
+func ReadParentCommand() ([]string, error) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", os.Getppid()))
+	if err != nil {
+		return nil, fmt.Errorf("read command line: %w", err)
+	}
+
+	return strings.Split(string(bytes.TrimSuffix(cmdline, []byte{0})), "\x00"), nil
+}
+
+const (
+	// minChurnGets is the number of gets below which the hit rate says nothing about churn.
+	minChurnGets = 100
+	// maxChurnHitRate is the hit rate below which the gets are churning.
+	maxChurnHitRate = 0.1
+)
+
+// collectTestCaching inspects the go command and the hit rate. It returns nil when there is nothing to tell.
+func collectTestCaching(hits, misses, remoteEntries int64) *TestCaching {
+	goCommandLocker.Lock()
+	args, goflags := goCommand, goFlags
+	goCommandLocker.Unlock()
+
+	t := &TestCaching{Command: args}
+	t.BuildFlags, t.Uncacheable = inspectGoCommand(args, goflags)
+
+	gets := hits + misses
+	t.Churn = gets >= minChurnGets && remoteEntries >= gets/2 && float64(hits) < float64(gets)*maxChurnHitRate
+
+	if len(t.Uncacheable) == 0 && !t.Churn {
+		return nil
+	}
+
+	return t
+}
+
+func formatBuildFlags(flags []string) string {
+	if len(flags) == 0 {
+		return "none in this run"
+	}
+
+	return strings.Join(flags, " ")
+}
+
+// cacheableTestFlags are the test flags with which the go command still caches the test results.
+// ref: go help test
+var cacheableTestFlags = []string{
+	"benchtime", "coverprofile", "cpu", "failfast", "fullpath", "list", "outputdir", "parallel", "run", "short", "skip", "timeout", "v",
+}
+
+// testFlags are the flags of go test passed to the test binary.
+var testFlags = append([]string{
+	"bench", "benchmem", "blockprofile", "blockprofilerate", "count", "cpuprofile", "fuzz", "fuzzminimizetime", "fuzztime",
+	"memprofile", "memprofilerate", "mutexprofile", "mutexprofilefraction", "shuffle", "trace",
+}, cacheableTestFlags...)
+
+// actionIDFlags are the build flags changing the action ID of every package.
+var actionIDFlags = []string{"race", "msan", "asan", "cover", "covermode", "coverpkg", "tags", "gcflags", "asmflags", "ldflags", "trimpath", "pgo", "buildmode"}
+
+// boolFlags are the flags of go test and go build not taking a separate value.
+var boolFlags = []string{
+	"a", "asan", "benchmem", "c", "cover", "failfast", "fullpath", "i", "json", "linkshared", "modcacherw", "msan", "n",
+	"race", "short", "trimpath", "v", "work", "x",
+}
+
+// flagName returns the name of the flag in arg, without the test. prefix, or false when arg is not a flag.
+func flagName(arg string) (string, bool) {
+	if len(arg) < 2 || arg[0] != '-' {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	name, _, _ = strings.Cut(name, "=")
+
+	return strings.TrimPrefix(name, "test."), true
+}
+
+// inspectGoCommand returns the flags changing every action ID, and the reasons the test results are not cached
+// following the rules of the go command: packages are given, and every test flag is a cacheable one.
+func inspectGoCommand(args []string, goflags string) (buildFlags []string, uncacheable []string) {
+	var goflagsUncacheable []string
+	for _, arg := range strings.Fields(goflags) {
+		name, ok := flagName(arg)
+		if !ok {
+			continue
+		}
+		if slices.Contains(actionIDFlags, name) {
+			buildFlags = append(buildFlags, arg)
+		}
+		if slices.Contains(testFlags, name) && !slices.Contains(cacheableTestFlags, name) {
+			goflagsUncacheable = append(goflagsUncacheable, fmt.Sprintf("%s in GOFLAGS", arg))
+		}
+	}
+
+	// Skip the global flags before the subcommand, e.g. go -C dir test.
+	subcommand := -1
+	for i := 1; i < len(args); i++ {
+		if _, ok := flagName(args[i]); !ok {
+			subcommand = i
+			break
+		}
+		if args[i] == "-C" {
+			i++
+		}
+	}
+	if subcommand < 0 {
+		// The command is unknown, so the test flags of GOFLAGS are reported as they are usually set for go test.
+		return buildFlags, goflagsUncacheable
+	}
+	if args[subcommand] == "test" {
+		uncacheable = goflagsUncacheable
+	}
+
+	var packages int
+	for i := subcommand + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-args" || arg == "--args" {
+			if i+1 < len(args) {
+				uncacheable = append(uncacheable, "arguments after -args are passed to the test binary")
+			}
+			break
+		}
+
+		name, ok := flagName(arg)
+		if !ok {
+			packages++
+			continue
+		}
+		if !strings.Contains(arg, "=") && !slices.Contains(boolFlags, name) && i+1 < len(args) {
+			i++
+			arg += " " + args[i]
+		}
+
+		if slices.Contains(actionIDFlags, name) {
+			buildFlags = append(buildFlags, arg)
+		}
+		if slices.Contains(testFlags, name) && !slices.Contains(cacheableTestFlags, name) {
+			uncacheable = append(uncacheable, fmt.Sprintf("%s on the command line", arg))
+		}
+	}
+
+	if args[subcommand] != "test" {
+		return buildFlags, nil
+	}
+	if packages == 0 {
+		uncacheable = append(uncacheable, "no packages are given, and go test in the local directory mode does not cache")
+	}
+
+	return buildFlags, uncacheable
+}
@@ -0,0 +1,105 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestInspectGoCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		args            []string
+		goflags         string
+		wantBuildFlags  []string
+		wantUncacheable []string
+	}{
+		{
+			name: "cacheable test",
+			args: []string{"go", "test", "-v", "-run", "TestFoo", "-timeout=5m", "./..."},
+		},
+		{
+			name:            "count on the command line",
+			args:            []string{"go", "test", "-count=1", "./..."},
+			wantUncacheable: []string{"-count=1 on the command line"},
+		},
+		{
+			name:            "count in GOFLAGS",
+			args:            []string{"/usr/local/go/bin/go", "test", "-race", "./..."},
+			goflags:         "-mod=mod -count=1",
+			wantBuildFlags:  []string{"-race"},
+			wantUncacheable: []string{"-count=1 in GOFLAGS"},
+		},
+		{
+			name:    "count in GOFLAGS of a build",
+			args:    []string{"go", "build", "./..."},
+			goflags: "-count=1",
+		},
+		{
+			name:            "count in GOFLAGS of an unknown command",
+			goflags:         "-count=1",
+			wantUncacheable: []string{"-count=1 in GOFLAGS"},
+		},
+		{
+			name:            "local directory mode",
+			args:            []string{"go", "-C", "sub", "test", "-v"},
+			wantUncacheable: []string{"no packages are given, and go test in the local directory mode does not cache"},
+		},
+		{
+			name:            "separate flag values",
+			args:            []string{"go", "test", "-bench", ".", "-tags", "integration", "./pkg"},
+			wantBuildFlags:  []string{"-tags integration"},
+			wantUncacheable: []string{"-bench . on the command line"},
+		},
+		{
+			name:            "test binary arguments",
+			args:            []string{"go", "test", "./pkg", "-args", "-update"},
+			wantUncacheable: []string{"arguments after -args are passed to the test binary"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			buildFlags, uncacheable := inspectGoCommand(tt.args, tt.goflags)
+			if diff := cmp.Diff(tt.wantBuildFlags, buildFlags, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("build flags mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantUncacheable, uncacheable, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("uncacheable mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCollectTestCaching(t *testing.T) {
+	SetGoCommand([]string{"go", "build", "./..."}, "")
+	t.Cleanup(func() {
+		SetGoCommand(nil, "")
+	})
+
+	tests := []struct {
+		name          string
+		hits, misses  int64
+		remoteEntries int64
+		wantChurn     bool
+	}{
+		{name: "most gets hit", hits: 900, misses: 100, remoteEntries: 1000},
+		{name: "most gets miss", hits: 10, misses: 990, remoteEntries: 1000, wantChurn: true},
+		{name: "empty remote cache", hits: 0, misses: 1000, remoteEntries: 0},
+		{name: "few gets", hits: 0, misses: 10, remoteEntries: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collectTestCaching(tt.hits, tt.misses, tt.remoteEntries)
+			if (got != nil && got.Churn) != tt.wantChurn {
+				t.Errorf("churn: got %+v, want %t", got, tt.wantChurn)
+			}
+		})
+	}
+}
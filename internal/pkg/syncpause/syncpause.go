@@ -0,0 +1,64 @@
+// Package syncpause pauses the sync with the remote cache while a control file exists,
+// so that a developer can hold the remote traffic, e.g. on a metered network, without restarting gocica.
+package syncpause
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// checkInterval is how long the presence of the control file is cached, as every transfer asks for it.
+const checkInterval = time.Second
+
+var (
+	file      atomic.Pointer[string]
+	checkedAt atomic.Int64
+	paused    atomic.Bool
+)
+
+// SetFile sets the control file. An empty path never pauses.
+func SetFile(path string) {
+	file.Store(&path)
+	checkedAt.Store(0)
+}
+
+// Paused reports whether the control file exists.
+func Paused() bool {
+	p := file.Load()
+	if p == nil || *p == "" {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	if last := checkedAt.Load(); now-last < int64(checkInterval) {
+		return paused.Load()
+	}
+
+	_, err := os.Stat(*p)
+	paused.Store(err == nil)
+	checkedAt.Store(now)
+
+	return err == nil
+}
+
+// Wait waits while the sync is paused.
+func Wait(ctx context.Context) error {
+	if !Paused() {
+		return nil
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for Paused() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for the sync to be resumed: %w", ctx.Err())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package syncpause
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.pause")
+	SetFile(path)
+	t.Cleanup(func() { SetFile("") })
+
+	if Paused() {
+		t.Fatal("paused without the control file")
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The cached state is dropped, as the file would otherwise be checked a second later.
+	SetFile(path)
+	if !Paused() {
+		t.Fatal("not paused with the control file")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx); err == nil {
+		t.Error("wait returned while paused")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Wait(t.Context())
+	}()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("wait: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("wait did not return after the sync was resumed")
+	}
+}
@@ -0,0 +1,3 @@
+// Package uring is a minimal io_uring client to write many files with a single system call.
+// It is built on Linux with the iouring build tag only.
+package uring
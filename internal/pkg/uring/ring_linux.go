@@ -0,0 +1,224 @@
+//go:build linux && iouring
+
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	offSQRing = 0
+	offCQRing = 0x8000000
+	offSQEs   = 0x10000000
+
+	featSingleMmap = 1 << 0
+	enterGetEvents = 1 << 0
+
+	opWrite = 23
+)
+
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type params struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqringOffsets
+	cqOff                                                                  cqringOffsets
+}
+
+type sqe struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	_        [3]uint64
+}
+
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// Ring is an io_uring instance. It is safe for concurrent use, one batch at a time.
+type Ring struct {
+	locker sync.Mutex
+	fd     int
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	sqes                   []sqe
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []cqe
+}
+
+// Write is a write of Buf to the file descriptor FD at Offset.
+type Write struct {
+	FD     int
+	Buf    []byte
+	Offset int64
+}
+
+var (
+	defaultOnce sync.Once
+	defaultRing *Ring
+	defaultErr  error
+)
+
+// Default returns the ring shared by the process, set up on the first call.
+// It returns an error when io_uring is not available, e.g. blocked by seccomp.
+func Default() (*Ring, error) {
+	defaultOnce.Do(func() {
+		defaultRing, defaultErr = New(256)
+	})
+
+	return defaultRing, defaultErr
+}
+
+// New sets up a ring with entries submission queue entries.
+func New(entries uint32) (*Ring, error) {
+	var p params
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &Ring{fd: int(fd)}
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(cqe{})))
+	if p.features&featSingleMmap != 0 {
+		sqSize = max(sqSize, cqSize)
+		cqSize = sqSize
+	}
+
+	sq, err := unix.Mmap(r.fd, offSQRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		_ = unix.Close(r.fd)
+		return nil, fmt.Errorf("mmap submission queue: %w", err)
+	}
+	cq := sq
+	if p.features&featSingleMmap == 0 {
+		cq, err = unix.Mmap(r.fd, offCQRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		if err != nil {
+			_ = unix.Close(r.fd)
+			return nil, fmt.Errorf("mmap completion queue: %w", err)
+		}
+	}
+	sqes, err := unix.Mmap(r.fd, offSQEs, int(p.sqEntries)*int(unsafe.Sizeof(sqe{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		_ = unix.Close(r.fd)
+		return nil, fmt.Errorf("mmap submission queue entries: %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&sq[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&sq[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&sq[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&sq[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*sqe)(unsafe.Pointer(&sqes[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&cq[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&cq[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&cq[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&cq[p.cqOff.cqes])), p.cqEntries)
+
+	return r, nil
+}
+
+// WriteAll writes every write in as few submissions as the ring size allows, and waits for them.
+// Short writes are resubmitted for the rest of their buffers.
+func (r *Ring) WriteAll(writes []Write) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	pending := make([]Write, len(writes))
+	copy(pending, writes)
+
+	var errs []error
+	for len(pending) > 0 {
+		n := min(len(pending), len(r.sqes))
+		batch := pending[:n]
+
+		tail := atomic.LoadUint32(r.sqTail)
+		mask := atomic.LoadUint32(r.sqMask)
+		for i, w := range batch {
+			idx := (tail + uint32(i)) & mask
+			r.sqes[idx] = sqe{
+				opcode:   opWrite,
+				fd:       int32(w.FD),
+				off:      uint64(w.Offset),
+				addr:     uint64(uintptr(unsafe.Pointer(unsafe.SliceData(w.Buf)))),
+				len:      uint32(len(w.Buf)),
+				userData: uint64(i),
+			}
+			r.sqArray[idx] = idx
+		}
+		atomic.StoreUint32(r.sqTail, tail+uint32(n))
+
+		if err := r.enter(uint32(n), uint32(n)); err != nil {
+			return fmt.Errorf("io_uring_enter: %w", err)
+		}
+
+		var retries []Write
+		for range n {
+			head := atomic.LoadUint32(r.cqHead)
+			c := r.cqes[head&atomic.LoadUint32(r.cqMask)]
+			atomic.StoreUint32(r.cqHead, head+1)
+
+			w := batch[c.userData]
+			switch {
+			case c.res < 0:
+				errs = append(errs, fmt.Errorf("write fd %d: %w", w.FD, syscall.Errno(-c.res)))
+			case c.res == 0 && len(w.Buf) > 0:
+				errs = append(errs, fmt.Errorf("write fd %d: %w", w.FD, errors.New("no progress")))
+			case int(c.res) < len(w.Buf):
+				retries = append(retries, Write{FD: w.FD, Buf: w.Buf[c.res:], Offset: w.Offset + int64(c.res)})
+			}
+		}
+		runtime.KeepAlive(batch)
+
+		pending = append(retries, pending[n:]...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// enter submits toSubmit entries and waits for minComplete completions, retrying on EINTR.
+func (r *Ring) enter(toSubmit, minComplete uint32) error {
+	for {
+		submitted, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), enterGetEvents, 0, 0)
+		switch errno {
+		case 0:
+		case unix.EINTR:
+			continue
+		default:
+			return errno
+		}
+
+		// The entries submitted so far are waited for, and the rest submitted again.
+		toSubmit -= uint32(submitted)
+		if toSubmit == 0 {
+			return nil
+		}
+		minComplete = toSubmit
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux && iouring
+
+package uring
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRing_WriteAll(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		entries uint32
+		files   int
+		size    int
+	}{
+		{name: "within ring", entries: 8, files: 4, size: 100},
+		{name: "beyond ring", entries: 4, files: 10, size: 100},
+		{name: "large writes", entries: 4, files: 3, size: 4 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ring, err := New(tt.entries)
+			if err != nil {
+				t.Skipf("io_uring is not available: %v", err)
+			}
+
+			dir := t.TempDir()
+			writes := make([]Write, 0, tt.files)
+			files := make([]*os.File, 0, tt.files)
+			for i := range tt.files {
+				f, err := os.Create(filepath.Join(dir, fmt.Sprint(i)))
+				if err != nil {
+					t.Fatalf("failed to create file: %v", err)
+				}
+				defer f.Close()
+				files = append(files, f)
+				writes = append(writes, Write{FD: int(f.Fd()), Buf: bytes.Repeat([]byte{byte(i)}, tt.size)})
+			}
+
+			if err := ring.WriteAll(writes); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+
+			for i, f := range files {
+				got, err := os.ReadFile(f.Name())
+				if err != nil {
+					t.Fatalf("failed to read file: %v", err)
+				}
+				if !bytes.Equal(got, writes[i].Buf) {
+					t.Errorf("file %d: got %d bytes, want %d bytes", i, len(got), len(writes[i].Buf))
+				}
+			}
+		})
+	}
+}
+
+func TestRing_WriteAllError(t *testing.T) {
+	t.Parallel()
+
+	ring, err := New(4)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+
+	if err := ring.WriteAll([]Write{{FD: -1, Buf: []byte("a")}}); err == nil {
+		t.Error("expected an error for a bad file descriptor")
+	}
+}
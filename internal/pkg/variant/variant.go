@@ -0,0 +1,99 @@
+// Package variant derives the namespace of the build variant of the go command, e.g. with -race or CGO_ENABLED=0.
+// The action IDs of the go command already differ between the variants, but a wrapper that rewrites the flags or the environment
+// can break that. Keeping every variant in its own namespace of the remote cache stops the outputs of one from being served to another.
+package variant
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Env are the environment variables of the go command selecting the variant of the outputs it builds.
+var Env = []string{"GOOS", "GOARCH", "CGO_ENABLED", "GOEXPERIMENT", "GOAMD64", "GOARM", "GOARM64", "GO386", "GOFIPS140"}
+
+// keySize is the size in bytes of the hash in a key, which keeps the cache keys short.
+const keySize = 4
+
+// Inputs returns what selects the variant: the variables of Env set in getenv, as NAME=value,
+// and buildFlags, the flags of the go command changing every action ID, e.g. -race or -tags foo, as name=value.
+// They are sorted, so that the order the flags are given in does not matter.
+func Inputs(getenv func(string) string, buildFlags []string) []string {
+	var inputs []string
+	for _, name := range Env {
+		if value := getenv(name); value != "" {
+			inputs = append(inputs, name+"="+value)
+		}
+	}
+
+	for _, flag := range buildFlags {
+		flag = strings.TrimLeft(flag, "-")
+		// The value follows the first "=" or, for a flag given as two arguments, the first space.
+		name, value := flag, "true"
+		if i := strings.IndexAny(flag, "= "); i >= 0 {
+			name, value = flag[:i], flag[i+1:]
+		}
+		if name == "tags" {
+			// The build tags are a set, given comma separated or, in older go commands, space separated.
+			tags := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+			slices.Sort(tags)
+			value = strings.Join(slices.Compact(tags), ",")
+		}
+		inputs = append(inputs, name+"="+value)
+	}
+	slices.Sort(inputs)
+
+	return slices.Compact(inputs)
+}
+
+// Key returns the namespace of the variant selected by inputs, or "" when nothing is set,
+// which is the namespace shared by the builds of the default variant.
+func Key(inputs []string) string {
+	if len(inputs) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(inputs, "\n")))
+	return hex.EncodeToString(sum[:keySize])
+}
+
+// WithTarget returns getenv with GOOS and GOARCH defaulting to the platform gocica runs on, the one of the go command starting it,
+// so that a native build names its target like a cross build for the same platform.
+func WithTarget(getenv func(string) string) func(string) string {
+	return func(name string) string {
+		value := getenv(name)
+		if value != "" {
+			return value
+		}
+
+		switch name {
+		case "GOOS":
+			return runtime.GOOS
+		case "GOARCH":
+			return runtime.GOARCH
+		default:
+			return ""
+		}
+	}
+}
+
+// Target returns the platform the go command builds for, as GOOS_GOARCH.
+func Target(getenv func(string) string) string {
+	getenv = WithTarget(getenv)
+	return getenv("GOOS") + "_" + getenv("GOARCH")
+}
+
+// Cgo reports whether the go command may build with cgo, whose outputs depend on the C toolchain of the runner.
+// Without CGO_ENABLED, the go command enables cgo for native builds only.
+func Cgo(getenv func(string) string) bool {
+	switch getenv("CGO_ENABLED") {
+	case "0":
+		return false
+	case "1":
+		return true
+	default:
+		return Target(getenv) == runtime.GOOS+"_"+runtime.GOARCH
+	}
+}
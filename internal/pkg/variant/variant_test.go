@@ -0,0 +1,101 @@
+package variant
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestInputs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		buildFlags []string
+		want       []string
+	}{
+		{name: "default variant"},
+		{
+			name: "environment",
+			env:  map[string]string{"CGO_ENABLED": "0", "GOARCH": "arm64", "HOME": "/root"},
+			want: []string{"CGO_ENABLED=0", "GOARCH=arm64"},
+		},
+		{
+			name:       "flags",
+			buildFlags: []string{"-race", "--tags=b,a", "-gcflags all=-N"},
+			want:       []string{"gcflags=all=-N", "race=true", "tags=a,b"},
+		},
+		{
+			name:       "same flags in other forms",
+			buildFlags: []string{"-tags a b", "-race=true", "-tags=b,a"},
+			want:       []string{"race=true", "tags=a,b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Inputs(func(name string) string { return tt.env[name] }, tt.buildFlags)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	if key := Key(nil); key != "" {
+		t.Errorf("default variant: got %q, want \"\"", key)
+	}
+
+	race := Key([]string{"race=true"})
+	if len(race) != 2*keySize {
+		t.Errorf("key length: got %d, want %d", len(race), 2*keySize)
+	}
+	if race == Key([]string{"CGO_ENABLED=0"}) {
+		t.Errorf("variants share the key %q", race)
+	}
+	if race != Key([]string{"race=true"}) {
+		t.Error("the key of a variant changed")
+	}
+}
+
+func TestCgo(t *testing.T) {
+	t.Parallel()
+
+	// A platform other than the one of the test, which the go command builds for without cgo.
+	otherOS := "linux"
+	if runtime.GOOS == "linux" {
+		otherOS = "darwin"
+	}
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantTarget string
+		want       bool
+	}{
+		{name: "native", wantTarget: runtime.GOOS + "_" + runtime.GOARCH, want: true},
+		{name: "native without cgo", env: map[string]string{"CGO_ENABLED": "0"}, wantTarget: runtime.GOOS + "_" + runtime.GOARCH, want: false},
+		{name: "cross", env: map[string]string{"GOOS": otherOS}, wantTarget: otherOS + "_" + runtime.GOARCH, want: false},
+		{name: "cross with cgo", env: map[string]string{"GOOS": otherOS, "GOARCH": "arm64", "CGO_ENABLED": "1"}, wantTarget: otherOS + "_arm64", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			getenv := func(name string) string { return tt.env[name] }
+			if got := Target(getenv); got != tt.wantTarget {
+				t.Errorf("Target() = %q, want %q", got, tt.wantTarget)
+			}
+			if got := Cgo(getenv); got != tt.want {
+				t.Errorf("Cgo() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
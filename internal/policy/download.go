@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// DownloadFilter is the rules of the outputs of the remote cache restored to the local cache with the whole blob,
+// e.g. to leave enormous test binaries rarely hit in the remote cache on a runner with little bandwidth.
+// The patterns are globs of path.Match, matched against the metadata recorded in the entries of an output:
+// its kind (compile, link, test or other) and its action IDs and output ID in hex, as GODEBUG=gocachehash=1 prints them.
+// The go cache protocol does not tell the packages of the actions, so they cannot be matched.
+type DownloadFilter struct {
+	// MaxSize is the size above which an output is not restored. 0 is unlimited.
+	MaxSize int64
+	// Include are the patterns of the outputs restored, when any is given.
+	Include []string
+	// Exclude are the patterns of the outputs not restored.
+	Exclude []string
+}
+
+// NewDownloadFilter creates the download filter, checking the patterns.
+func NewDownloadFilter(maxSize int64, include, exclude []string) (*DownloadFilter, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("negative max object size: %d", maxSize)
+	}
+
+	parse := func(patterns []string) ([]string, error) {
+		parsed := make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			parsed = append(parsed, pattern)
+		}
+		return parsed, nil
+	}
+
+	f := &DownloadFilter{MaxSize: maxSize}
+	var err error
+	if f.Include, err = parse(include); err != nil {
+		return nil, err
+	}
+	if f.Exclude, err = parse(exclude); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Empty reports whether the filter restores every output.
+func (f *DownloadFilter) Empty() bool {
+	return f == nil || (f.MaxSize == 0 && len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// DownloadMetaData is the metadata recorded for an entry of an output, which the patterns are matched against.
+type DownloadMetaData struct {
+	ActionID string
+	OutputID string
+	Size     int64
+	Kind     Kind
+}
+
+// Allow reports whether the output of the entry is restored with the whole blob.
+func (f *DownloadFilter) Allow(metaData DownloadMetaData) bool {
+	if f.Empty() {
+		return true
+	}
+
+	if f.MaxSize > 0 && metaData.Size > f.MaxSize {
+		return false
+	}
+
+	values := []string{string(metaData.Kind), hexID(metaData.ActionID), hexID(metaData.OutputID)}
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			for _, value := range values {
+				if ok, _ := path.Match(pattern, value); ok && value != "" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if match(f.Exclude) {
+		return false
+	}
+	if len(f.Include) > 0 && !match(f.Include) {
+		return false
+	}
+
+	return true
+}
+
+// hexID returns the ID of the protocol, in base64, in hex. An ID that is not base64 is returned as it is.
+func hexID(id string) string {
+	raw, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return id
+	}
+
+	return hex.EncodeToString(raw)
+}
+
+var currentDownload atomic.Pointer[DownloadFilter]
+
+// SetDownload sets the download filter of the run.
+func SetDownload(f *DownloadFilter) {
+	currentDownload.Store(f)
+}
+
+// CurrentDownload returns the download filter of the run, or nil when every output is restored.
+func CurrentDownload() *DownloadFilter {
+	if f := currentDownload.Load(); !f.Empty() {
+		return f
+	}
+
+	return nil
+}
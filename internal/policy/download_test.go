@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFilter_Allow(t *testing.T) {
+	t.Parallel()
+
+	actionID := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("\xab", idSize)))
+	outputID := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("\x01", idSize)))
+
+	tests := []struct {
+		name     string
+		maxSize  int64
+		include  []string
+		exclude  []string
+		kind     Kind
+		size     int64
+		expected bool
+	}{
+		{name: "empty", kind: KindLink, size: 1 << 30, expected: true},
+		{name: "below max size", maxSize: 100, kind: KindLink, size: 100, expected: true},
+		{name: "above max size", maxSize: 100, kind: KindCompile, size: 101, expected: false},
+		{name: "excluded kind", exclude: []string{"link"}, kind: KindLink, size: 1, expected: false},
+		{name: "not excluded kind", exclude: []string{"link"}, kind: KindCompile, size: 1, expected: true},
+		{name: "included kind", include: []string{"comp*"}, kind: KindCompile, size: 1, expected: true},
+		{name: "not included kind", include: []string{"compile"}, kind: KindTest, size: 1, expected: false},
+		{name: "included action ID", include: []string{"abab*"}, kind: KindTest, size: 1, expected: true},
+		{name: "excluded output ID", exclude: []string{"0101*"}, kind: KindCompile, size: 1, expected: false},
+		{name: "exclude over include", include: []string{"*"}, exclude: []string{"test"}, kind: KindTest, size: 1, expected: false},
+		{name: "unknown kind", include: []string{"compile"}, size: 1, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := NewDownloadFilter(tt.maxSize, tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("failed to create download filter: %v", err)
+			}
+			actual := f.Allow(DownloadMetaData{ActionID: actionID, OutputID: outputID, Size: tt.size, Kind: tt.kind})
+			if actual != tt.expected {
+				t.Errorf("got %t, want %t", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewDownloadFilter_invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewDownloadFilter(0, []string{"[a-"}, nil); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+	if _, err := NewDownloadFilter(-1, nil, nil); err == nil {
+		t.Error("expected an error for a negative size")
+	}
+}
@@ -0,0 +1,44 @@
+package policy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Expiry is the rules of the expiration of the entries, given as TTLs by the toolchain on put.
+// An expired entry is a miss, and is dropped from the remote cache at the next run.
+type Expiry struct {
+	// Default is the TTL of the outputs put without one. 0 keeps them until unused for long.
+	Default time.Duration
+	// Max bounds the TTLs. 0 is unbounded.
+	Max time.Duration
+	// Lifecycle passes the time the whole blob expires to backends with object lifecycle, e.g. the expiry of an object.
+	Lifecycle bool
+}
+
+// ExpiresAt returns the time an output put at now with the TTL expires, or the zero time when it never does.
+func (e *Expiry) ExpiresAt(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 && e != nil {
+		ttl = e.Default
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	if e != nil && e.Max > 0 {
+		ttl = min(ttl, e.Max)
+	}
+
+	return now.Add(ttl)
+}
+
+var currentExpiry atomic.Pointer[Expiry]
+
+// SetExpiry sets the expiry rules of the run.
+func SetExpiry(e *Expiry) {
+	currentExpiry.Store(e)
+}
+
+// CurrentExpiry returns the expiry rules of the run, or nil when only the TTLs given are applied.
+func CurrentExpiry() *Expiry {
+	return currentExpiry.Load()
+}
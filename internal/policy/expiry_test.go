@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiry_ExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		expiry *Expiry
+		ttl    time.Duration
+		want   time.Time
+	}{
+		{name: "no rules", ttl: time.Hour, want: now.Add(time.Hour)},
+		{name: "no ttl", expiry: &Expiry{}, want: time.Time{}},
+		{name: "default", expiry: &Expiry{Default: time.Minute}, want: now.Add(time.Minute)},
+		{name: "ttl over default", expiry: &Expiry{Default: time.Minute}, ttl: time.Hour, want: now.Add(time.Hour)},
+		{name: "bounded", expiry: &Expiry{Max: time.Minute}, ttl: time.Hour, want: now.Add(time.Minute)},
+		{name: "negative ttl", expiry: &Expiry{}, ttl: -time.Hour, want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.expiry.ExpiresAt(now, tt.ttl); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// idSize is the size of the action IDs and the output IDs of the go command, SHA-256 hashes.
+const idSize = 32
+
+// Pins are the actions and the outputs kept in the remote cache whatever the rules are:
+// their entries stay beyond the TTL of unused entries, and their outputs are never left out by the policy.
+type Pins struct {
+	ids map[string]struct{}
+}
+
+// normalizeID returns the ID in the base64 of the protocol, given in it or in the hex of GODEBUG=gocachehash=1.
+func normalizeID(id string) (string, error) {
+	var (
+		raw []byte
+		err error
+	)
+	if len(id) == hex.EncodedLen(idSize) {
+		raw, err = hex.DecodeString(id)
+	} else {
+		raw, err = base64.StdEncoding.DecodeString(id)
+	}
+	if err != nil || len(raw) != idSize {
+		return "", fmt.Errorf("invalid ID: %s. give an action ID or an output ID in base64 or hex", id)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// NewPins creates the pins of the IDs, each either an action ID or an output ID.
+func NewPins(ids []string) (*Pins, error) {
+	p := &Pins{ids: make(map[string]struct{}, len(ids))}
+	for _, id := range ids {
+		normalized, err := normalizeID(strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+		p.ids[normalized] = struct{}{}
+	}
+
+	return p, nil
+}
+
+// ReadPinFile returns the IDs listed in the file, one a line. Empty lines and the lines starting with # are skipped.
+func ReadPinFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open pin file: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read pin file: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Len returns the number of IDs pinned.
+func (p *Pins) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	return len(p.ids)
+}
+
+// Pinned reports whether the action or its output is pinned.
+func (p *Pins) Pinned(actionID, outputID string) bool {
+	if p.Len() == 0 {
+		return false
+	}
+
+	_, ok := p.ids[actionID]
+	if !ok {
+		_, ok = p.ids[outputID]
+	}
+
+	return ok
+}
+
+var currentPins atomic.Pointer[Pins]
+
+// SetPins sets the pins of the run.
+func SetPins(p *Pins) {
+	currentPins.Store(p)
+}
+
+// Pinned reports whether the action or its output is pinned in the run.
+func Pinned(actionID, outputID string) bool {
+	return currentPins.Load().Pinned(actionID, outputID)
+}
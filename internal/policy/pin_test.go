@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPins(t *testing.T) {
+	t.Parallel()
+
+	action := sha256.Sum256([]byte("action"))
+	output := sha256.Sum256([]byte("output"))
+	other := sha256.Sum256([]byte("other"))
+	b64 := base64.StdEncoding.EncodeToString
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pins.txt")
+	// The action ID is given in the hex of GODEBUG=gocachehash=1, and the output ID in base64.
+	content := "# runtime\n" + hex.EncodeToString(action[:]) + "\n\n" + b64(output[:]) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write pin file: %v", err)
+	}
+
+	ids, err := ReadPinFile(path)
+	if err != nil {
+		t.Fatalf("failed to read pin file: %v", err)
+	}
+	pins, err := NewPins(ids)
+	if err != nil {
+		t.Fatalf("failed to create pins: %v", err)
+	}
+	if pins.Len() != 2 {
+		t.Errorf("expected 2 pins, got %d", pins.Len())
+	}
+
+	tests := []struct {
+		name     string
+		actionID string
+		outputID string
+		want     bool
+	}{
+		{name: "pinned action", actionID: b64(action[:]), outputID: b64(other[:]), want: true},
+		{name: "pinned output", actionID: b64(other[:]), outputID: b64(output[:]), want: true},
+		{name: "not pinned", actionID: b64(other[:]), outputID: b64(other[:]), want: false},
+	}
+	for _, tt := range tests {
+		if got := pins.Pinned(tt.actionID, tt.outputID); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	var empty *Pins
+	if empty.Pinned(b64(action[:]), b64(output[:])) {
+		t.Error("expected nothing pinned without pins")
+	}
+}
+
+func TestNewPins_invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, id := range []string{"runtime", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewPins([]string{id}); err == nil {
+			t.Errorf("expected an error for %q", id)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package policy decides which outputs put by the go command are kept in the remote cache.
+// An output left out by the policy is still written to the local cache, so that the go command reads it in this run,
+// but it is neither uploaded nor listed in the blob, e.g. to keep enormous test binaries out of it.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Kind is the kind of an output, told from its first bytes.
+type Kind string
+
+const (
+	// KindCompile is a package archive or an object file written by the compiler.
+	KindCompile Kind = "compile"
+	// KindLink is an executable written by the linker, e.g. a test binary.
+	KindLink Kind = "link"
+	// KindTest is the output or the log of a test run cached by go test.
+	KindTest  Kind = "test"
+	KindOther Kind = "other"
+)
+
+// Kinds are the kinds of outputs.
+var Kinds = []Kind{KindCompile, KindLink, KindTest, KindOther}
+
+// SniffSize is the number of the first bytes of an output Sniff looks at.
+const SniffSize = 16
+
+var (
+	compileMagics = [][]byte{
+		[]byte("!<arch>\n"),
+		[]byte("go object "),
+	}
+	linkMagics = [][]byte{
+		[]byte("\x7fELF"),
+		// Mach-O, 32 and 64 bit, both byte orders.
+		{0xfe, 0xed, 0xfa, 0xce}, {0xce, 0xfa, 0xed, 0xfe},
+		{0xfe, 0xed, 0xfa, 0xcf}, {0xcf, 0xfa, 0xed, 0xfe},
+		// PE
+		[]byte("MZ"),
+		// wasm
+		[]byte("\x00asm"),
+	}
+	// testMagics are the header of the test log and the usual starts of the output of go test.
+	testMagics = [][]byte{
+		[]byte("# test log\n"),
+		[]byte("=== RUN"),
+		[]byte("--- "),
+		[]byte("PASS\n"),
+		[]byte("ok  \t"),
+		[]byte("testing: "),
+	}
+)
+
+// Sniff returns the kind of the output starting with head.
+func Sniff(head []byte) Kind {
+	hasPrefix := func(magic []byte) bool { return bytes.HasPrefix(head, magic) }
+
+	switch {
+	case slices.ContainsFunc(compileMagics, hasPrefix):
+		return KindCompile
+	case slices.ContainsFunc(linkMagics, hasPrefix):
+		return KindLink
+	case slices.ContainsFunc(testMagics, hasPrefix):
+		return KindTest
+	default:
+		return KindOther
+	}
+}
+
+// Policy is the rules of the outputs kept in the remote cache.
+type Policy struct {
+	// MaxSize is the size above which an output is left out. 0 is unlimited.
+	MaxSize int64
+	// Skip are the kinds left out.
+	Skip []Kind
+	// Only are the kinds kept, when any is given.
+	Only []Kind
+}
+
+// New creates the policy from the kinds given by name.
+func New(maxSize int64, skip, only []string) (*Policy, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("negative max size: %d", maxSize)
+	}
+
+	parse := func(names []string) ([]Kind, error) {
+		kinds := make([]Kind, 0, len(names))
+		for _, name := range names {
+			kind := Kind(strings.TrimSpace(name))
+			if !slices.Contains(Kinds, kind) {
+				return nil, fmt.Errorf("unknown kind of output: %s. available: %v", name, Kinds)
+			}
+			kinds = append(kinds, kind)
+		}
+		return kinds, nil
+	}
+
+	p := &Policy{MaxSize: maxSize}
+	var err error
+	if p.Skip, err = parse(skip); err != nil {
+		return nil, err
+	}
+	if p.Only, err = parse(only); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Empty reports whether the policy keeps every output.
+func (p *Policy) Empty() bool {
+	return p == nil || (p.MaxSize == 0 && len(p.Skip) == 0 && len(p.Only) == 0)
+}
+
+// Allow reports whether an output of the kind and the size is kept in the remote cache.
+func (p *Policy) Allow(kind Kind, size int64) bool {
+	if p.Empty() {
+		return true
+	}
+
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return false
+	}
+	if slices.Contains(p.Skip, kind) {
+		return false
+	}
+	if len(p.Only) > 0 && !slices.Contains(p.Only, kind) {
+		return false
+	}
+
+	return true
+}
+
+var current atomic.Pointer[Policy]
+
+// Set sets the policy of the run.
+func Set(p *Policy) {
+	current.Store(p)
+}
+
+// Current returns the policy of the run, or nil when every output is kept.
+func Current() *Policy {
+	if p := current.Load(); !p.Empty() {
+		return p
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package policy
+
+import "testing"
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		head string
+		want Kind
+	}{
+		{name: "package archive", head: "!<arch>\n__.PKGDEF", want: KindCompile},
+		{name: "object file", head: "go object linux amd64", want: KindCompile},
+		{name: "elf", head: "\x7fELF\x02\x01\x01", want: KindLink},
+		{name: "mach-o", head: "\xcf\xfa\xed\xfe\x07", want: KindLink},
+		{name: "test log", head: "# test log\ngetenv", want: KindTest},
+		{name: "test output", head: "=== RUN   TestFoo", want: KindTest},
+		{name: "vet config", head: "{\"ID\":\"x\"}", want: KindOther},
+		{name: "empty", head: "", want: KindOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Sniff([]byte(tt.head)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Allow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		skip    []string
+		only    []string
+		kind    Kind
+		size    int64
+		want    bool
+	}{
+		{name: "empty", kind: KindLink, size: 1 << 30, want: true},
+		{name: "below max size", maxSize: 100, kind: KindCompile, size: 100, want: true},
+		{name: "above max size", maxSize: 100, kind: KindCompile, size: 101, want: false},
+		{name: "skipped", skip: []string{"link", "test"}, kind: KindTest, size: 1, want: false},
+		{name: "not skipped", skip: []string{"link"}, kind: KindCompile, size: 1, want: true},
+		{name: "only", only: []string{"compile"}, kind: KindCompile, size: 1, want: true},
+		{name: "not only", only: []string{"compile"}, kind: KindOther, size: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := New(tt.maxSize, tt.skip, tt.only)
+			if err != nil {
+				t.Fatalf("failed to create policy: %v", err)
+			}
+			if got := p.Allow(tt.kind, tt.size); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(0, []string{"binary"}, nil); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+	if _, err := New(-1, nil, nil); err == nil {
+		t.Error("expected an error for a negative size")
+	}
+}
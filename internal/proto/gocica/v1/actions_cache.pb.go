@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.10
+// 	protoc-gen-go v1.36.11
 // 	protoc        (unknown)
 // source: gocica/v1/actions_cache.proto
 
@@ -135,18 +135,118 @@ func (x *ActionsOutput) GetId() string {
 	return ""
 }
 
+type ModFile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OutputId      string                 `protobuf:"bytes,1,opt,name=output_id,json=outputId,proto3" json:"output_id,omitempty"`
+	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ModFile) Reset() {
+	*x = ModFile{}
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModFile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModFile) ProtoMessage() {}
+
+func (x *ModFile) ProtoReflect() protoreflect.Message {
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModFile.ProtoReflect.Descriptor instead.
+func (*ModFile) Descriptor() ([]byte, []int) {
+	return file_gocica_v1_actions_cache_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ModFile) GetOutputId() string {
+	if x != nil {
+		return x.OutputId
+	}
+	return ""
+}
+
+func (x *ModFile) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+type ModCache struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Files         map[string]*ModFile    `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ModCache) Reset() {
+	*x = ModCache{}
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModCache) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModCache) ProtoMessage() {}
+
+func (x *ModCache) ProtoReflect() protoreflect.Message {
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModCache.ProtoReflect.Descriptor instead.
+func (*ModCache) Descriptor() ([]byte, []int) {
+	return file_gocica_v1_actions_cache_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ModCache) GetFiles() map[string]*ModFile {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
 type ActionsCache struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
 	Entries         map[string]*IndexEntry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	Outputs         []*ActionsOutput       `protobuf:"bytes,2,rep,name=outputs,proto3" json:"outputs,omitempty"`
 	OutputTotalSize int64                  `protobuf:"varint,3,opt,name=output_total_size,json=outputTotalSize,proto3" json:"output_total_size,omitempty"`
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	Signature       []byte                 `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	ModCache        *ModCache              `protobuf:"bytes,5,opt,name=mod_cache,json=modCache,proto3" json:"mod_cache,omitempty"`
+	// admission is the access history of the actions deciding which large outputs are uploaded.
+	Admission     *AdmissionSketch `protobuf:"bytes,6,opt,name=admission,proto3" json:"admission,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ActionsCache) Reset() {
 	*x = ActionsCache{}
-	mi := &file_gocica_v1_actions_cache_proto_msgTypes[1]
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -158,7 +258,7 @@ func (x *ActionsCache) String() string {
 func (*ActionsCache) ProtoMessage() {}
 
 func (x *ActionsCache) ProtoReflect() protoreflect.Message {
-	mi := &file_gocica_v1_actions_cache_proto_msgTypes[1]
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -171,7 +271,7 @@ func (x *ActionsCache) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ActionsCache.ProtoReflect.Descriptor instead.
 func (*ActionsCache) Descriptor() ([]byte, []int) {
-	return file_gocica_v1_actions_cache_proto_rawDescGZIP(), []int{1}
+	return file_gocica_v1_actions_cache_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *ActionsCache) GetEntries() map[string]*IndexEntry {
@@ -195,6 +295,81 @@ func (x *ActionsCache) GetOutputTotalSize() int64 {
 	return 0
 }
 
+func (x *ActionsCache) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *ActionsCache) GetModCache() *ModCache {
+	if x != nil {
+		return x.ModCache
+	}
+	return nil
+}
+
+func (x *ActionsCache) GetAdmission() *AdmissionSketch {
+	if x != nil {
+		return x.Admission
+	}
+	return nil
+}
+
+// AdmissionSketch is a count-min sketch of the puts and the hits of the actions, with 4 bit counters.
+type AdmissionSketch struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Counters []byte                 `protobuf:"bytes,1,opt,name=counters,proto3" json:"counters,omitempty"`
+	// additions is the number of additions since the counters were last halved.
+	Additions     int64 `protobuf:"varint,2,opt,name=additions,proto3" json:"additions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdmissionSketch) Reset() {
+	*x = AdmissionSketch{}
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdmissionSketch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdmissionSketch) ProtoMessage() {}
+
+func (x *AdmissionSketch) ProtoReflect() protoreflect.Message {
+	mi := &file_gocica_v1_actions_cache_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdmissionSketch.ProtoReflect.Descriptor instead.
+func (*AdmissionSketch) Descriptor() ([]byte, []int) {
+	return file_gocica_v1_actions_cache_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AdmissionSketch) GetCounters() []byte {
+	if x != nil {
+		return x.Counters
+	}
+	return nil
+}
+
+func (x *AdmissionSketch) GetAdditions() int64 {
+	if x != nil {
+		return x.Additions
+	}
+	return 0
+}
+
 var File_gocica_v1_actions_cache_proto protoreflect.FileDescriptor
 
 const file_gocica_v1_actions_cache_proto_rawDesc = "" +
@@ -204,14 +379,29 @@ const file_gocica_v1_actions_cache_proto_rawDesc = "" +
 	"\x06offset\x18\x01 \x01(\x03R\x06offset\x12\x12\n" +
 	"\x04size\x18\x02 \x01(\x03R\x04size\x128\n" +
 	"\vcompression\x18\x03 \x01(\x0e2\x16.gocica.v1.CompressionR\vcompression\x12\x0e\n" +
-	"\x02id\x18\x04 \x01(\tR\x02id\"\x81\x02\n" +
+	"\x02id\x18\x04 \x01(\tR\x02id\":\n" +
+	"\aModFile\x12\x1b\n" +
+	"\toutput_id\x18\x01 \x01(\tR\boutputId\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\"\x8e\x01\n" +
+	"\bModCache\x124\n" +
+	"\x05files\x18\x01 \x03(\v2\x1e.gocica.v1.ModCache.FilesEntryR\x05files\x1aL\n" +
+	"\n" +
+	"FilesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12(\n" +
+	"\x05value\x18\x02 \x01(\v2\x12.gocica.v1.ModFileR\x05value:\x028\x01\"\x8b\x03\n" +
 	"\fActionsCache\x12>\n" +
 	"\aentries\x18\x01 \x03(\v2$.gocica.v1.ActionsCache.EntriesEntryR\aentries\x122\n" +
 	"\aoutputs\x18\x02 \x03(\v2\x18.gocica.v1.ActionsOutputR\aoutputs\x12*\n" +
-	"\x11output_total_size\x18\x03 \x01(\x03R\x0foutputTotalSize\x1aQ\n" +
+	"\x11output_total_size\x18\x03 \x01(\x03R\x0foutputTotalSize\x12\x1c\n" +
+	"\tsignature\x18\x04 \x01(\fR\tsignature\x120\n" +
+	"\tmod_cache\x18\x05 \x01(\v2\x13.gocica.v1.ModCacheR\bmodCache\x128\n" +
+	"\tadmission\x18\x06 \x01(\v2\x1a.gocica.v1.AdmissionSketchR\tadmission\x1aQ\n" +
 	"\fEntriesEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12+\n" +
-	"\x05value\x18\x02 \x01(\v2\x15.gocica.v1.IndexEntryR\x05value:\x028\x01*@\n" +
+	"\x05value\x18\x02 \x01(\v2\x15.gocica.v1.IndexEntryR\x05value:\x028\x01\"K\n" +
+	"\x0fAdmissionSketch\x12\x1a\n" +
+	"\bcounters\x18\x01 \x01(\fR\bcounters\x12\x1c\n" +
+	"\tadditions\x18\x02 \x01(\x03R\tadditions*@\n" +
 	"\vCompression\x12\x1b\n" +
 	"\x17COMPRESSION_UNSPECIFIED\x10\x00\x12\x14\n" +
 	"\x10COMPRESSION_ZSTD\x10\x01B+Z)github.com/mazrean/gocica/proto/gocica/v1b\x06proto3"
@@ -229,24 +419,32 @@ func file_gocica_v1_actions_cache_proto_rawDescGZIP() []byte {
 }
 
 var file_gocica_v1_actions_cache_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_gocica_v1_actions_cache_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_gocica_v1_actions_cache_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_gocica_v1_actions_cache_proto_goTypes = []any{
-	(Compression)(0),      // 0: gocica.v1.Compression
-	(*ActionsOutput)(nil), // 1: gocica.v1.ActionsOutput
-	(*ActionsCache)(nil),  // 2: gocica.v1.ActionsCache
-	nil,                   // 3: gocica.v1.ActionsCache.EntriesEntry
-	(*IndexEntry)(nil),    // 4: gocica.v1.IndexEntry
+	(Compression)(0),        // 0: gocica.v1.Compression
+	(*ActionsOutput)(nil),   // 1: gocica.v1.ActionsOutput
+	(*ModFile)(nil),         // 2: gocica.v1.ModFile
+	(*ModCache)(nil),        // 3: gocica.v1.ModCache
+	(*ActionsCache)(nil),    // 4: gocica.v1.ActionsCache
+	(*AdmissionSketch)(nil), // 5: gocica.v1.AdmissionSketch
+	nil,                     // 6: gocica.v1.ModCache.FilesEntry
+	nil,                     // 7: gocica.v1.ActionsCache.EntriesEntry
+	(*IndexEntry)(nil),      // 8: gocica.v1.IndexEntry
 }
 var file_gocica_v1_actions_cache_proto_depIdxs = []int32{
 	0, // 0: gocica.v1.ActionsOutput.compression:type_name -> gocica.v1.Compression
-	3, // 1: gocica.v1.ActionsCache.entries:type_name -> gocica.v1.ActionsCache.EntriesEntry
-	1, // 2: gocica.v1.ActionsCache.outputs:type_name -> gocica.v1.ActionsOutput
-	4, // 3: gocica.v1.ActionsCache.EntriesEntry.value:type_name -> gocica.v1.IndexEntry
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	6, // 1: gocica.v1.ModCache.files:type_name -> gocica.v1.ModCache.FilesEntry
+	7, // 2: gocica.v1.ActionsCache.entries:type_name -> gocica.v1.ActionsCache.EntriesEntry
+	1, // 3: gocica.v1.ActionsCache.outputs:type_name -> gocica.v1.ActionsOutput
+	3, // 4: gocica.v1.ActionsCache.mod_cache:type_name -> gocica.v1.ModCache
+	5, // 5: gocica.v1.ActionsCache.admission:type_name -> gocica.v1.AdmissionSketch
+	2, // 6: gocica.v1.ModCache.FilesEntry.value:type_name -> gocica.v1.ModFile
+	8, // 7: gocica.v1.ActionsCache.EntriesEntry.value:type_name -> gocica.v1.IndexEntry
+	8, // [8:8] is the sub-list for method output_type
+	8, // [8:8] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_gocica_v1_actions_cache_proto_init() }
@@ -261,7 +459,7 @@ func file_gocica_v1_actions_cache_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gocica_v1_actions_cache_proto_rawDesc), len(file_gocica_v1_actions_cache_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   3,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -24,11 +24,19 @@ const (
 
 // IndexEntry is a single entry in the index.
 type IndexEntry struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	OutputId      string                 `protobuf:"bytes,1,opt,name=output_id,json=outputId,proto3" json:"output_id,omitempty"`
-	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
-	Timenano      int64                  `protobuf:"varint,3,opt,name=timenano,proto3" json:"timenano,omitempty"`
-	LastUsedAt    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=last_used_at,json=lastUsedAt,proto3" json:"last_used_at,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	OutputId   string                 `protobuf:"bytes,1,opt,name=output_id,json=outputId,proto3" json:"output_id,omitempty"`
+	Size       int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Timenano   int64                  `protobuf:"varint,3,opt,name=timenano,proto3" json:"timenano,omitempty"`
+	LastUsedAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=last_used_at,json=lastUsedAt,proto3" json:"last_used_at,omitempty"`
+	// inline_output is the content of a tiny output, which is kept in the header instead of the outputs.
+	InlineOutput []byte `protobuf:"bytes,5,opt,name=inline_output,json=inlineOutput,proto3" json:"inline_output,omitempty"`
+	// expires_at is when the output expires, given by the toolchain as a TTL on put. Unset never expires.
+	ExpiresAt *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// access_order is the rank of the entry in the order the actions were looked up in the last runs, from 1. 0 is unknown.
+	AccessOrder int64 `protobuf:"varint,8,opt,name=access_order,json=accessOrder,proto3" json:"access_order,omitempty"`
+	// kind is the kind of the output told from its first bytes, e.g. compile or link. Empty is unknown.
+	Kind          string `protobuf:"bytes,9,opt,name=kind,proto3" json:"kind,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -91,6 +99,34 @@ func (x *IndexEntry) GetLastUsedAt() *timestamppb.Timestamp {
 	return nil
 }
 
+func (x *IndexEntry) GetInlineOutput() []byte {
+	if x != nil {
+		return x.InlineOutput
+	}
+	return nil
+}
+
+func (x *IndexEntry) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *IndexEntry) GetAccessOrder() int64 {
+	if x != nil {
+		return x.AccessOrder
+	}
+	return 0
+}
+
+func (x *IndexEntry) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
 // IndexEntryMap is a map of IndexEntry.
 type IndexEntryMap struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -140,14 +176,19 @@ var File_gocica_v1_index_entry_proto protoreflect.FileDescriptor
 
 const file_gocica_v1_index_entry_proto_rawDesc = "" +
 	"\n" +
-	"\x1bgocica/v1/index_entry.proto\x12\tgocica.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x97\x01\n" +
+	"\x1bgocica/v1/index_entry.proto\x12\tgocica.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xba\x02\n" +
 	"\n" +
 	"IndexEntry\x12\x1b\n" +
 	"\toutput_id\x18\x01 \x01(\tR\boutputId\x12\x12\n" +
 	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x1a\n" +
 	"\btimenano\x18\x03 \x01(\x03R\btimenano\x12<\n" +
 	"\flast_used_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
-	"lastUsedAt\"\xa3\x01\n" +
+	"lastUsedAt\x12#\n" +
+	"\rinline_output\x18\x05 \x01(\fR\finlineOutput\x129\n" +
+	"\n" +
+	"expires_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12!\n" +
+	"\faccess_order\x18\b \x01(\x03R\vaccessOrder\x12\x12\n" +
+	"\x04kind\x18\t \x01(\tR\x04kindJ\x04\b\a\x10\bR\x04hits\"\xa3\x01\n" +
 	"\rIndexEntryMap\x12?\n" +
 	"\aentries\x18\x01 \x03(\v2%.gocica.v1.IndexEntryMap.EntriesEntryR\aentries\x1aQ\n" +
 	"\fEntriesEntry\x12\x10\n" +
@@ -175,13 +216,14 @@ var file_gocica_v1_index_entry_proto_goTypes = []any{
 }
 var file_gocica_v1_index_entry_proto_depIdxs = []int32{
 	3, // 0: gocica.v1.IndexEntry.last_used_at:type_name -> google.protobuf.Timestamp
-	2, // 1: gocica.v1.IndexEntryMap.entries:type_name -> gocica.v1.IndexEntryMap.EntriesEntry
-	0, // 2: gocica.v1.IndexEntryMap.EntriesEntry.value:type_name -> gocica.v1.IndexEntry
-	3, // [3:3] is the sub-list for method output_type
-	3, // [3:3] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	3, // 1: gocica.v1.IndexEntry.expires_at:type_name -> google.protobuf.Timestamp
+	2, // 2: gocica.v1.IndexEntryMap.entries:type_name -> gocica.v1.IndexEntryMap.EntriesEntry
+	0, // 3: gocica.v1.IndexEntryMap.EntriesEntry.value:type_name -> gocica.v1.IndexEntry
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_gocica_v1_index_entry_proto_init() }
@@ -0,0 +1,117 @@
+package prune
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/remote/provider"
+)
+
+const githubPageSize = 100
+
+// GitHub manages the gocica entries of a repository through the GitHub REST API.
+// Unlike the cache service used by the run command, it needs a token with the actions:write permission,
+// such as GITHUB_TOKEN, instead of ACTIONS_RUNTIME_TOKEN.
+type GitHub struct {
+	httpClient *http.Client
+	apiURL     *url.URL
+	repository string
+	token      string
+}
+
+// NewGitHub creates a GitHub remote for repository ("owner/name").
+func NewGitHub(apiURL, repository, token string) (*GitHub, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse api url: %w", err)
+	}
+
+	if owner, name, ok := strings.Cut(repository, "/"); !ok || owner == "" || name == "" {
+		return nil, fmt.Errorf("invalid repository %q: expected owner/name", repository)
+	}
+
+	return &GitHub{
+		httpClient: http.DefaultClient,
+		apiURL:     u,
+		repository: repository,
+		token:      token,
+	}, nil
+}
+
+func (g *GitHub) do(ctx context.Context, method string, u *url.URL, operation string, respBody any) error {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	report.AddAPICall("github_rest", operation)
+	res, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, body)
+	}
+
+	if respBody == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(respBody); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// List returns every gocica entry of the repository.
+func (g *GitHub) List(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+	for page := 1; ; page++ {
+		u := g.apiURL.JoinPath("repos", g.repository, "actions", "caches")
+		u.RawQuery = url.Values{
+			// key is a prefix match.
+			"key":      {provider.ActionsCacheKeyPrefix},
+			"per_page": {strconv.Itoa(githubPageSize)},
+			"page":     {strconv.Itoa(page)},
+		}.Encode()
+
+		var res struct {
+			TotalCount   int     `json:"total_count"`
+			ActionsCache []Entry `json:"actions_caches"`
+		}
+		if err := g.do(ctx, http.MethodGet, u, "list_caches", &res); err != nil {
+			return nil, fmt.Errorf("list caches: %w", err)
+		}
+
+		entries = append(entries, res.ActionsCache...)
+		if len(res.ActionsCache) < githubPageSize || len(entries) >= res.TotalCount {
+			return entries, nil
+		}
+	}
+}
+
+// Delete deletes a cache entry by its ID.
+func (g *GitHub) Delete(ctx context.Context, entry Entry) error {
+	u := g.apiURL.JoinPath("repos", g.repository, "actions", "caches", strconv.FormatInt(entry.ID, 10))
+	if err := g.do(ctx, http.MethodDelete, u, "delete_cache", nil); err != nil {
+		return fmt.Errorf("delete cache: %w", err)
+	}
+
+	return nil
+}
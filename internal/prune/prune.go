@@ -0,0 +1,89 @@
+// Package prune lists and deletes stale gocica entries in the remote cache.
+package prune
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Entry is a cache entry stored in the remote.
+type Entry struct {
+	ID             int64     `json:"id"`
+	Key            string    `json:"key"`
+	Ref            string    `json:"ref"`
+	SizeInBytes    int64     `json:"size_in_bytes"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+}
+
+// Remote lists and deletes cache entries.
+type Remote interface {
+	List(ctx context.Context) ([]Entry, error)
+	Delete(ctx context.Context, entry Entry) error
+}
+
+// Filter selects the entries to delete.
+type Filter struct {
+	// OlderThan selects entries that have not been accessed for this duration. Zero selects every entry.
+	OlderThan time.Duration
+	// Pattern is a path.Match pattern the key must match. Empty matches every key.
+	Pattern string
+	// Key is the exact key to match. Empty matches every key.
+	Key string
+}
+
+// Select returns the entries matching the filter.
+func (f *Filter) Select(entries []Entry, now time.Time) ([]Entry, error) {
+	selected := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if f.OlderThan > 0 && now.Sub(entry.LastAccessedAt) < f.OlderThan {
+			continue
+		}
+
+		if f.Key != "" && entry.Key != f.Key {
+			continue
+		}
+
+		if f.Pattern != "" {
+			ok, err := path.Match(f.Pattern, entry.Key)
+			if err != nil {
+				return nil, fmt.Errorf("match pattern: %w", err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		selected = append(selected, entry)
+	}
+
+	return selected, nil
+}
+
+// Prune deletes the entries of remote selected by filter and returns them.
+// When dryRun is true, the entries are only returned.
+func Prune(ctx context.Context, remote Remote, filter *Filter, dryRun bool) ([]Entry, error) {
+	entries, err := remote.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list entries: %w", err)
+	}
+
+	selected, err := filter.Select(entries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return selected, nil
+	}
+
+	for i, entry := range selected {
+		if err := remote.Delete(ctx, entry); err != nil {
+			return selected[:i], fmt.Errorf("delete %s: %w", entry.Key, err)
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,123 @@
+package prune
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilter_Select(t *testing.T) {
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Key: "gocica-cache-Linux-main-a", LastAccessedAt: now.Add(-48 * time.Hour)},
+		{Key: "gocica-cache-Linux-main-b", LastAccessedAt: now.Add(-time.Hour)},
+		{Key: "gocica-cache-macOS-main-c", LastAccessedAt: now.Add(-48 * time.Hour)},
+	}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "no filter",
+			filter: Filter{},
+			want:   []string{"gocica-cache-Linux-main-a", "gocica-cache-Linux-main-b", "gocica-cache-macOS-main-c"},
+		},
+		{
+			name:   "older than",
+			filter: Filter{OlderThan: 24 * time.Hour},
+			want:   []string{"gocica-cache-Linux-main-a", "gocica-cache-macOS-main-c"},
+		},
+		{
+			name:   "older than and pattern",
+			filter: Filter{OlderThan: 24 * time.Hour, Pattern: "gocica-cache-Linux-*"},
+			want:   []string{"gocica-cache-Linux-main-a"},
+		},
+		{
+			name:    "invalid pattern",
+			filter:  Filter{Pattern: "["},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.filter.Select(entries, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := make([]string, 0, len(selected))
+			for _, entry := range selected {
+				got = append(got, entry.Key)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("selected entries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	const total = githubPageSize + 1
+
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/owner/repo/actions/caches", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if key := r.URL.Query().Get("key"); key != "gocica-cache-" {
+			t.Errorf("unexpected key prefix: %s", key)
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start, end := (page-1)*githubPageSize, min(page*githubPageSize, total)
+		fmt.Fprintf(w, `{"total_count":%d,"actions_caches":[`, total)
+		for i := start; i < end; i++ {
+			if i != start {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"id":%d,"key":"gocica-cache-%d"}`, i, i)
+		}
+		fmt.Fprint(w, "]}")
+	})
+	mux.HandleFunc("DELETE /repos/owner/repo/actions/caches/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	remote, err := NewGitHub(server.URL, "owner/repo", "token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := Prune(context.Background(), remote, &Filter{Pattern: "gocica-cache-10?"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Key != "gocica-cache-100" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if diff := cmp.Diff([]string{"100"}, deleted); diff != "" {
+		t.Errorf("deleted entries mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,170 @@
+// Package record records the protocol sessions between the go command and gocica, and replays them against a gocica process,
+// so that hangs and wrong hits reported by users can be reproduced offline.
+// A session is a directory holding the requests as the go command sent them, put bodies included,
+// and the responses as gocica answered them.
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/json"
+)
+
+// Files of a session directory.
+const (
+	RequestsFile  = "requests"
+	ResponsesFile = "responses"
+	MetaFile      = "meta.json"
+)
+
+// Meta describes a recorded session.
+type Meta struct {
+	Version  string    `json:"version"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Err is the first error of writing the recording, after which the rest of the session is missing.
+	Err string `json:"error,omitempty"`
+}
+
+// Session records a protocol session to a directory of its own.
+// Failures to write the recording are kept in the meta data and never fail the go command.
+type Session struct {
+	dir       string
+	version   string
+	started   time.Time
+	requests  *os.File
+	responses *os.File
+
+	errLocker sync.Mutex
+	err       error
+}
+
+// NewSession creates the directory of a new session in dir, named by the start time and the process ID.
+func NewSession(dir string, version string) (*Session, error) {
+	started := time.Now()
+	sessionDir := filepath.Join(dir, started.UTC().Format("20060102T150405.000000000Z")+"-"+strconv.Itoa(os.Getpid()))
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create session directory: %w", err)
+	}
+
+	requests, err := os.Create(filepath.Join(sessionDir, RequestsFile))
+	if err != nil {
+		return nil, fmt.Errorf("create requests file: %w", err)
+	}
+
+	responses, err := os.Create(filepath.Join(sessionDir, ResponsesFile))
+	if err != nil {
+		_ = requests.Close()
+		return nil, fmt.Errorf("create responses file: %w", err)
+	}
+
+	return &Session{
+		dir:       sessionDir,
+		version:   version,
+		started:   started,
+		requests:  requests,
+		responses: responses,
+	}, nil
+}
+
+// Dir returns the directory of the session.
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+func (s *Session) setErr(err error) {
+	s.errLocker.Lock()
+	defer s.errLocker.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Input returns a reader of r recording what is read from it as the requests.
+func (s *Session) Input(r io.Reader) io.Reader {
+	return &teeReader{r: r, file: s.requests, session: s}
+}
+
+// Output returns a writer to w recording what is written to it as the responses.
+func (s *Session) Output(w io.Writer) io.Writer {
+	return &teeWriter{w: w, file: s.responses, session: s}
+}
+
+// Close finishes the recording and writes the meta data of the session.
+func (s *Session) Close() error {
+	if err := errors.Join(s.requests.Close(), s.responses.Close()); err != nil {
+		s.setErr(err)
+	}
+
+	meta := Meta{
+		Version:  s.version,
+		Started:  s.started,
+		Finished: time.Now(),
+	}
+	s.errLocker.Lock()
+	if s.err != nil {
+		meta.Err = s.err.Error()
+	}
+	s.errLocker.Unlock()
+
+	f, err := os.Create(filepath.Join(s.dir, MetaFile))
+	if err != nil {
+		return fmt.Errorf("create meta file: %w", err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(&meta); err != nil {
+		return fmt.Errorf("write meta file: %w", err)
+	}
+
+	return nil
+}
+
+// teeReader records the bytes read from r until writing them fails.
+type teeReader struct {
+	r       io.Reader
+	file    *os.File
+	session *Session
+	failed  bool
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && !t.failed {
+		if _, werr := t.file.Write(p[:n]); werr != nil {
+			t.failed = true
+			t.session.setErr(fmt.Errorf("record requests: %w", werr))
+		}
+	}
+
+	return n, err
+}
+
+// teeWriter records the bytes written to w until writing them fails.
+// Only one goroutine writes the responses, so it needs no lock.
+type teeWriter struct {
+	w       io.Writer
+	file    *os.File
+	session *Session
+	failed  bool
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if n > 0 && !t.failed {
+		if _, werr := t.file.Write(p[:n]); werr != nil {
+			t.failed = true
+			t.session.setErr(fmt.Errorf("record responses: %w", werr))
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,205 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// newMapProcess returns a process keeping the outputs put in memory. A process with forget never hits.
+func newMapProcess(forget bool) *protocol.Process {
+	var (
+		locker  sync.Mutex
+		outputs = map[string]string{}
+	)
+
+	return protocol.NewProcess(
+		protocol.WithLogger(log.DefaultLogger),
+		protocol.WithGetHandler(func(_ context.Context, req *protocol.Request, res *protocol.Response) error {
+			locker.Lock()
+			defer locker.Unlock()
+
+			outputID, ok := outputs[req.ActionID]
+			if !ok || forget {
+				res.Miss = true
+				return nil
+			}
+			res.OutputID = outputID
+			res.Size = 4
+			res.DiskPath = "/somewhere/" + outputID
+
+			return nil
+		}),
+		protocol.WithPutHandler(func(_ context.Context, req *protocol.Request, res *protocol.Response) error {
+			locker.Lock()
+			defer locker.Unlock()
+
+			outputs[req.ActionID] = req.OutputID
+			res.DiskPath = "/somewhere/" + req.OutputID
+
+			return nil
+		}),
+	)
+}
+
+// session is a build getting an action, putting it, and getting it again.
+func session() string {
+	body := base64.StdEncoding.EncodeToString([]byte("body"))
+	lines := []string{
+		`{"ID":1,"Command":"get","ActionID":"a"}`,
+		`{"ID":2,"Command":"put","ActionID":"a","OutputID":"o","BodySize":4}`,
+		`"` + body + `"`,
+		`{"ID":3,"Command":"get","ActionID":"a"}`,
+		`{"ID":4,"Command":"close"}`,
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// record records the session served by a process keeping the outputs, one request at a time like a go command waiting for each.
+func record(t *testing.T, dir string) string {
+	t.Helper()
+
+	s, err := NewSession(dir, "test")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	reqR, reqW := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- newMapProcess(false).Serve(s.Output(&out), s.Input(reqR))
+	}()
+	for line := range strings.Lines(session()) {
+		if _, err := io.WriteString(reqW, line); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+		// The put has to be done before the get after it, as the go command only gets after its put is answered.
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = reqW.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+
+	return s.Dir()
+}
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	dir := record(t, t.TempDir())
+
+	requests, err := os.ReadFile(filepath.Join(dir, RequestsFile))
+	if err != nil {
+		t.Fatalf("failed to read requests: %v", err)
+	}
+	if string(requests) != session() {
+		t.Errorf("requests: got %q, want %q", requests, session())
+	}
+
+	responses, err := readResponses(filepath.Join(dir, ResponsesFile))
+	if err != nil {
+		t.Fatalf("failed to read responses: %v", err)
+	}
+	if len(responses) != 4 {
+		t.Fatalf("expected 4 responses, got %d", len(responses))
+	}
+	if !responses[1].Miss || responses[3].OutputID != "o" {
+		t.Errorf("unexpected responses: first %+v, last get %+v", responses[1], responses[3])
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, MetaFile)); err != nil {
+		t.Errorf("expected meta file: %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	dir := record(t, t.TempDir())
+
+	tests := []struct {
+		name           string
+		serve          ServeFunc
+		timeout        time.Duration
+		wantErr        bool
+		wantMismatches []int64
+		wantPending    []int64
+	}{
+		{
+			name: "same backend",
+			serve: func(w io.Writer, r io.Reader) error {
+				return newMapProcess(false).Serve(w, r)
+			},
+			timeout: 10 * time.Second,
+		},
+		{
+			name: "backend never hitting",
+			serve: func(w io.Writer, r io.Reader) error {
+				return newMapProcess(true).Serve(w, r)
+			},
+			timeout:        10 * time.Second,
+			wantMismatches: []int64{3},
+		},
+		{
+			name: "hang",
+			serve: func(io.Writer, io.Reader) error {
+				select {}
+			},
+			timeout:     100 * time.Millisecond,
+			wantErr:     true,
+			wantPending: []int64{1, 2, 3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			result, err := Replay(ctx, dir, tt.serve)
+			if tt.wantErr {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("expected deadline exceeded, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result.Requests != 4 {
+				t.Errorf("requests: got %d, want 4", result.Requests)
+			}
+
+			var mismatches []int64
+			for _, m := range result.Mismatches {
+				mismatches = append(mismatches, m.ID)
+			}
+			if fmt.Sprint(mismatches) != fmt.Sprint(tt.wantMismatches) {
+				t.Errorf("mismatches: got %v, want %v", result.Mismatches, tt.wantMismatches)
+			}
+			if fmt.Sprint(result.Pending) != fmt.Sprint(tt.wantPending) {
+				t.Errorf("pending: got %v, want %v", result.Pending, tt.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	myjson "github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// ServeFunc runs a gocica process reading the requests from r and writing the responses to w until r is closed.
+type ServeFunc func(w io.Writer, r io.Reader) error
+
+// Mismatch is a request answered differently by the replay than in the recording.
+type Mismatch struct {
+	ID       int64        `json:"id"`
+	Command  protocol.Cmd `json:"command"`
+	ActionID string       `json:"action_id,omitempty"`
+	Recorded string       `json:"recorded"`
+	Replayed string       `json:"replayed"`
+}
+
+// Result is the outcome of a replay.
+type Result struct {
+	Requests   int        `json:"requests"`
+	Responses  int        `json:"responses"`
+	Mismatches []Mismatch `json:"mismatches"`
+	// Pending are the IDs of the requests not answered when the replay ended, which is how a hang reproduces.
+	Pending  []int64       `json:"pending"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// WriteFile writes the result to path as JSON.
+func (r *Result) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create result file: %w", err)
+	}
+	defer f.Close()
+
+	if err := myjson.NewEncoder(f).Encode(r); err != nil {
+		return fmt.Errorf("write result file: %w", err)
+	}
+
+	return nil
+}
+
+// Replay sends the requests of the session in dir to a process served by serve, and compares the responses with the recorded ones.
+// A request is sent once the earlier requests of its action are answered, and close once every earlier request is,
+// as the go command only puts what it got a miss for. The other requests are sent at once.
+// When ctx is done before the process answers every request, the result lists the pending requests,
+// and the process is left running, as a hung process cannot be stopped from outside.
+func Replay(ctx context.Context, dir string, serve ServeFunc) (*Result, error) {
+	requests, err := readRequests(filepath.Join(dir, RequestsFile))
+	if err != nil {
+		return nil, fmt.Errorf("read requests: %w", err)
+	}
+
+	recorded, err := readResponses(filepath.Join(dir, ResponsesFile))
+	if err != nil {
+		return nil, fmt.Errorf("read responses: %w", err)
+	}
+
+	var (
+		locker   sync.Mutex
+		replayed = make(map[int64]*protocol.Response, len(requests))
+		answered = make(map[int64]chan struct{}, len(requests))
+	)
+	for _, req := range requests {
+		answered[req.ID] = make(chan struct{})
+	}
+
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	serveErr := make(chan error, 1)
+	go func() {
+		err := serve(resW, reqR)
+		_ = reqR.CloseWithError(errors.New("process exited"))
+		_ = resW.Close()
+		serveErr <- err
+	}()
+
+	decodeErr := make(chan error, 1)
+	go func() {
+		decodeErr <- decodeResponses(resR, func(res *protocol.Response) {
+			locker.Lock()
+			defer locker.Unlock()
+
+			if _, ok := replayed[res.ID]; ok {
+				return
+			}
+			replayed[res.ID] = res
+			if ch, ok := answered[res.ID]; ok {
+				close(ch)
+			}
+		})
+	}()
+
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+	go func() {
+		err := sendRequests(sendCtx, reqW, requests, answered)
+		_ = reqW.CloseWithError(err)
+	}()
+
+	start := time.Now()
+	var errs []error
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			errs = append(errs, fmt.Errorf("serve: %w", err))
+		}
+		if err := <-decodeErr; err != nil {
+			errs = append(errs, fmt.Errorf("decode responses: %w", err))
+		}
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("replay did not finish: %w", ctx.Err()))
+		_ = resR.CloseWithError(ctx.Err())
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+
+	result := &Result{
+		Requests:  len(requests),
+		Responses: len(replayed),
+		Duration:  time.Since(start),
+	}
+	for _, req := range requests {
+		res, ok := replayed[req.ID]
+		if !ok {
+			result.Pending = append(result.Pending, req.ID)
+			continue
+		}
+
+		recordedRes, ok := recorded[req.ID]
+		if !ok {
+			// The go command was gone before gocica answered, e.g. on the interrupt of the build.
+			continue
+		}
+		if want, got := describe(recordedRes), describe(res); want != got {
+			result.Mismatches = append(result.Mismatches, Mismatch{
+				ID:       req.ID,
+				Command:  req.Command,
+				ActionID: req.ActionID,
+				Recorded: want,
+				Replayed: got,
+			})
+		}
+	}
+	slices.Sort(result.Pending)
+
+	return result, errors.Join(errs...)
+}
+
+// sendRequests writes the requests to w in the order described in Replay.
+func sendRequests(ctx context.Context, w io.Writer, requests []*recordedRequest, answered map[int64]chan struct{}) error {
+	wait := func(ids ...int64) error {
+		for _, id := range ids {
+			select {
+			case <-answered[id]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	lastOfAction := map[string]int64{}
+	sent := make([]int64, 0, len(requests))
+	for _, req := range requests {
+		var err error
+		switch {
+		case req.Command == protocol.CmdClose:
+			err = wait(sent...)
+		case req.ActionID != "":
+			if id, ok := lastOfAction[req.ActionID]; ok {
+				err = wait(id)
+			}
+			lastOfAction[req.ActionID] = req.ID
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(req.raw); err != nil {
+			return fmt.Errorf("write request %d: %w", req.ID, err)
+		}
+		sent = append(sent, req.ID)
+	}
+
+	return nil
+}
+
+// describe returns what matters of a response to the go command.
+// The disk path is left out, as it depends on the cache directory.
+func describe(res *protocol.Response) string {
+	switch {
+	case res.Err != "":
+		return "error"
+	case res.Miss:
+		return "miss"
+	case res.OutputID != "":
+		return fmt.Sprintf("hit %s (%d bytes)", res.OutputID, res.Size)
+	default:
+		return "ok"
+	}
+}
+
+// recordedRequest is a request of a recorded stream with its bytes, including the put body after it.
+type recordedRequest struct {
+	*protocol.Request
+	raw []byte
+}
+
+// readRequests reads the requests of a recorded stream.
+func readRequests(path string) ([]*recordedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	var requests []*recordedRequest
+	decoder := json.NewDecoder(f)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return requests, nil
+			}
+			// The recording of a killed go command may end in the middle of a request.
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return requests, nil
+			}
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+
+		// A JSON string is the body of the put before it.
+		if bytes.HasPrefix(raw, []byte(`"`)) {
+			if len(requests) == 0 {
+				return nil, errors.New("body without a request")
+			}
+			last := requests[len(requests)-1]
+			last.raw = append(append(last.raw, raw...), '\n')
+			continue
+		}
+
+		var req protocol.Request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, fmt.Errorf("decode request: %w", err)
+		}
+		requests = append(requests, &recordedRequest{
+			Request: &req,
+			raw:     append(slices.Clip(raw), '\n'),
+		})
+	}
+}
+
+// readResponses reads the responses of a recorded stream by the IDs of their requests.
+func readResponses(path string) (map[int64]*protocol.Response, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	responses := map[int64]*protocol.Response{}
+	err = decodeResponses(f, func(res *protocol.Response) {
+		responses[res.ID] = res
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+// decodeResponses calls f with every response of r until it ends.
+// The ID 0 response listing the known commands is skipped.
+func decodeResponses(r io.Reader, f func(*protocol.Response)) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var res protocol.Response
+		if err := decoder.Decode(&res); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("decode: %w", err)
+		}
+
+		if res.ID == 0 {
+			continue
+		}
+		f(&res)
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import "sync/atomic"
+
+// appendList is a list appended to by many goroutines without a lock.
+// Appends are a compare-and-swap on the head, so the puts of a parallel build do not queue on a mutex.
+type appendList[T any] struct {
+	head atomic.Pointer[appendNode[T]]
+}
+
+type appendNode[T any] struct {
+	value T
+	next  *appendNode[T]
+	// index is the position of the node in the list, which sizes the slice of items.
+	index int
+}
+
+func (l *appendList[T]) append(value T) {
+	node := &appendNode[T]{value: value}
+	for {
+		head := l.head.Load()
+		node.next = head
+		if head != nil {
+			node.index = head.index + 1
+		}
+		if l.head.CompareAndSwap(head, node) {
+			return
+		}
+	}
+}
+
+// items returns the values appended so far in the order they were appended.
+func (l *appendList[T]) items() []T {
+	head := l.head.Load()
+	if head == nil {
+		return nil
+	}
+
+	items := make([]T, head.index+1)
+	for node := head; node != nil; node = node.next {
+		items[node.index] = node.value
+	}
+
+	return items
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestAppendList(t *testing.T) {
+	t.Parallel()
+
+	var l appendList[int]
+	if items := l.items(); len(items) != 0 {
+		t.Fatalf("items of an empty list: got %v", items)
+	}
+
+	const (
+		goroutines = 8
+		perRoutine = 1000
+	)
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perRoutine {
+				l.append(g*perRoutine + i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	items := l.items()
+	if len(items) != goroutines*perRoutine {
+		t.Fatalf("items: got %d, want %d", len(items), goroutines*perRoutine)
+	}
+
+	// The values of a goroutine keep the order they were appended in.
+	last := make([]int, goroutines)
+	for g := range last {
+		last[g] = -1
+	}
+	for _, item := range items {
+		g := item / perRoutine
+		if item <= last[g] {
+			t.Fatalf("item %d after %d", item, last[g])
+		}
+		last[g] = item
+	}
+
+	slices.Sort(items)
+	for i, item := range items {
+		if item != i {
+			t.Fatalf("items[%d]: got %d", i, item)
+		}
+	}
+}
@@ -5,23 +5,55 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mazrean/gocica/internal/admission"
 	"github.com/mazrean/gocica/internal/local"
 	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
 	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
 	"github.com/mazrean/gocica/internal/remote"
 	"github.com/mazrean/gocica/log"
 )
 
-var _ remote.Backend = &Backend{}
+var (
+	_ remote.OnDemandBackend = &Backend{}
+	_ remote.RestoreWaiter   = &Backend{}
+	_ remote.PendingBackend  = &Backend{}
+)
 
 // Backend implements remote.Backend.
 // It uses Uploader/Downloader for data transfer.
 type Backend struct {
 	logger             log.Logger
+	localBackend       local.Backend
 	uploader           *Uploader
 	downloader         *Downloader
 	downloadCancelFunc context.CancelCauseFunc
+	// timenanos are the times the objects get as their mtime by their output IDs, nil when it is not enabled.
+	timenanos map[string]int64
+	// restoring is whether the whole blob is being restored, and sparse whether it was stopped for few of its outputs being used.
+	restoring atomic.Bool
+	sparse    atomic.Bool
+	// used are the outputs of the blob used by hits, and usedOutputs their number.
+	used        sync.Map
+	usedOutputs atomic.Int64
+	// fetches are the fetchCalls of the outputs being fetched by their IDs.
+	fetches sync.Map
+	// restored is closed once the restoration of the whole blob ends.
+	restored chan struct{}
+}
+
+// uploadOnly is whether the backends created afterwards leave the blob and the module cache unrestored.
+var uploadOnly atomic.Bool
+
+// SetUploadOnly stops the backends created afterwards from restoring the blob and the module cache,
+// for a process committing what an earlier one restored, e.g. gocica save.
+func SetUploadOnly() {
+	uploadOnly.Store(true)
 }
 
 // NewBackend creates a new RemoteBackend with the given uploader and downloader.
@@ -32,30 +64,59 @@ func NewBackend(
 	downloader *Downloader,
 ) (*Backend, error) {
 	c := &Backend{
-		logger:     logger,
-		uploader:   uploader,
-		downloader: downloader,
+		logger:       logger,
+		localBackend: localBackend,
+		uploader:     uploader,
+		downloader:   downloader,
+		restored:     make(chan struct{}),
+	}
+	// The objects get the time of their entries as their mtime, when it is enabled.
+	if local.RestoreMtimeEnabled() {
+		c.timenanos = downloader.outputTimenanos()
+	}
+
+	// The module cache is restored before the go command is served, as it downloads the modules missing on its own.
+	if !uploadOnly.Load() {
+		c.restoreModCache(lifecycle.Context())
 	}
 
-	if !c.downloader.IsEmpty() {
-		ctx := context.Background()
-		ctx, c.downloadCancelFunc = context.WithCancelCause(ctx)
+	admission.Load(c.downloader.Admission())
+
+	capabilities := c.Capabilities()
+	logger.Debugf("storage capabilities: ranged reads=%t, server-side copy=%t, conditional put=%t, multipart=%t",
+		capabilities.RangedReads, capabilities.ServerSideCopy, capabilities.ConditionalPut, capabilities.Multipart)
+
+	if c.downloader.IsEmpty() || uploadOnly.Load() {
+		close(c.restored)
+	} else {
+		// The restoration stops with the backend closed or the process shutting down, whichever is first.
+		ctx, cancel := context.WithCancelCause(lifecycle.Context())
+		c.downloadCancelFunc = cancel
 
 		// Download all output blocks in the background.
-		go func() {
+		c.restoring.Store(true)
+		lifecycle.Go(func(context.Context) {
+			defer close(c.restored)
+			defer c.restoring.Store(false)
 			defer func() {
 				if r := recover(); r != nil {
 					logger.Errorf("panic in downloading output blocks: %v", r)
 				}
 			}()
 
-			if err := c.downloader.DownloadAllOutputBlocks(ctx, func(ctx context.Context, objectID string) (io.WriteCloser, error) {
-				_, w, err := localBackend.Put(ctx, objectID, 0)
-				return w, err
-			}); err != nil {
+			err := c.downloader.DownloadAllOutputBlocks(ctx, c.restoredObjectWriter)
+			switch {
+			case err == nil:
+			case errors.Is(context.Cause(ctx), errSparse):
+				logger.Debugf("stopped downloading all output blocks: %v", err)
+			default:
 				logger.Errorf("download all output blocks: %v", err)
 			}
-		}()
+		})
+
+		if after := time.Duration(sparseAfter.Load()); after > 0 {
+			time.AfterFunc(after, c.checkSparse)
+		}
 	}
 
 	logger.Infof("GitHub Actions cache backend initialized.")
@@ -63,6 +124,121 @@ func NewBackend(
 	return c, nil
 }
 
+// objectWriter returns the path of the object in the local cache and the writer of it.
+func (c *Backend) objectWriter(ctx context.Context, objectID string) (string, io.WriteCloser, error) {
+	diskPath, w, err := c.localBackend.Put(ctx, objectID, 0)
+	if err != nil || c.timenanos == nil {
+		return diskPath, w, err
+	}
+
+	return diskPath, &mtimeWriter{WriteCloser: w, logger: c.logger, diskPath: diskPath, timenano: c.timenanos[objectID]}, nil
+}
+
+// restoredObjectWriter returns the writer of an object restored with the whole blob,
+// which a local backend keeping pack files packs until it is looked up.
+func (c *Backend) restoredObjectWriter(ctx context.Context, objectID string) (io.WriteCloser, error) {
+	if packed, ok := c.localBackend.(local.PackedBackend); ok {
+		return packed.PutPacked(ctx, objectID, c.timenanos[objectID])
+	}
+
+	_, w, err := c.objectWriter(ctx, objectID)
+	return w, err
+}
+
+// mtimeWriter sets the mtime of the object once it is written.
+// It passes File and Abort through, so that the downloader still batches and aborts the writes of the object.
+type mtimeWriter struct {
+	io.WriteCloser
+	logger   log.Logger
+	diskPath string
+	timenano int64
+}
+
+func (w *mtimeWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+
+	// A wrong mtime only affects the tools looking at it, so the object is kept.
+	if err := local.RestoreMtime(w.diskPath, w.timenano); err != nil {
+		w.logger.Debugf("restore mtime: %v", err)
+	}
+
+	return nil
+}
+
+func (w *mtimeWriter) File() *os.File {
+	if fw, ok := w.WriteCloser.(myio.FileWriter); ok {
+		return fw.File()
+	}
+
+	return nil
+}
+
+func (w *mtimeWriter) Abort() error {
+	if aw, ok := w.WriteCloser.(abortWriter); ok {
+		return aw.Abort()
+	}
+
+	return nil
+}
+
+// WaitRestored waits for the restoration of the whole blob in the background to end.
+// The outputs it failed to restore are left out of the local cache.
+func (c *Backend) WaitRestored(ctx context.Context) error {
+	select {
+	case <-c.restored:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// pendingPollInterval is the interval at which a get waiting for a pending output looks it up in the local cache.
+const pendingPollInterval = 5 * time.Millisecond
+
+// Pending reports whether the output is still to be written to the local cache by the restoration of the whole blob:
+// the restoration is running, and the output is in the blob and not left out by the download filter.
+func (c *Backend) Pending(outputID string) bool {
+	if !c.restoring.Load() || c.sparse.Load() {
+		return false
+	}
+	if _, ok := c.downloader.output(outputID); !ok {
+		return false
+	}
+	_, filtered := c.downloader.filteredOutputs()[outputID]
+
+	return !filtered
+}
+
+// WaitPending waits up to wait for the restoration of the whole blob to write the output to the local cache,
+// and returns its path, or "" when it is not written by then or the restoration ends without it.
+// An output the restoration has started writing is waited for by the local backend, as with any get of it.
+func (c *Backend) WaitPending(ctx context.Context, outputID string, wait time.Duration) (string, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(pendingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", context.Cause(ctx)
+		case <-timer.C:
+			return "", nil
+		case <-c.restored:
+			// The output may have been written right before the restoration ended.
+			return c.localBackend.Get(ctx, outputID)
+		case <-ticker.C:
+		}
+
+		diskPath, err := c.localBackend.Get(ctx, outputID)
+		if err != nil || diskPath != "" {
+			return diskPath, err
+		}
+	}
+}
+
 func (c *Backend) MetaData(ctx context.Context) (map[string]*v1.IndexEntry, error) {
 	entries, err := c.downloader.GetEntries(ctx)
 	if err != nil {
@@ -73,6 +249,9 @@ func (c *Backend) MetaData(ctx context.Context) (map[string]*v1.IndexEntry, erro
 }
 
 func (c *Backend) WriteMetaData(ctx context.Context, metaDataMap map[string]*v1.IndexEntry) error {
+	c.snapshotModCache(ctx)
+	c.uploader.SetAdmission(admission.Snapshot())
+
 	if err := c.uploader.Commit(ctx, metaDataMap); err != nil {
 		return fmt.Errorf("commit: %w", err)
 	}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/local"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"go.uber.org/goleak"
+)
+
+// stalledClient never answers a download until its context is done.
+type stalledClient struct {
+	startOnce sync.Once
+	started   chan struct{}
+}
+
+func (c *stalledClient) GetURL(context.Context) string {
+	return ""
+}
+
+func (c *stalledClient) DownloadBlock(ctx context.Context, _ int64, _ int64, _ io.Writer) error {
+	c.startOnce.Do(func() { close(c.started) })
+	<-ctx.Done()
+	return context.Cause(ctx)
+}
+
+func (c *stalledClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, _ []byte) error {
+	return c.DownloadBlock(ctx, offset, size, nil)
+}
+
+func TestBackend_Close(t *testing.T) {
+	// Not parallel, as goleak sees the goroutines of the other tests.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	client := &stalledClient{started: make(chan struct{})}
+	downloader := &Downloader{
+		logger: log.DefaultLogger,
+		client: client,
+		header: &v1.ActionsCache{
+			Outputs:         []*v1.ActionsOutput{{Id: "output", Size: 10}},
+			OutputTotalSize: 10,
+		},
+	}
+
+	disk, err := local.NewDisk(log.DefaultLogger, local.DiskDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDisk() error = %v", err)
+	}
+
+	backend, err := NewBackend(log.DefaultLogger, disk, &Uploader{}, downloader)
+	if err != nil {
+		t.Fatalf("NewBackend() error = %v", err)
+	}
+	<-client.started
+
+	// The restoration of the whole blob stalls, and only stops with the backend closed.
+	if err := backend.Close(t.Context()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := backend.WaitRestored(t.Context()); err != nil {
+		t.Errorf("WaitRestored() error = %v", err)
+	}
+}
+
+// gatedClient serves the ranges of a blob once it is released.
+type gatedClient struct {
+	blobClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *gatedClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	close(c.started)
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-c.release:
+	}
+
+	return c.blobClient.DownloadBlock(ctx, offset, size, w)
+}
+
+func TestBackend_WaitPending(t *testing.T) {
+	t.Parallel()
+
+	client := &gatedClient{
+		blobClient: blobClient{blob: []byte("0123456789")},
+		started:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	downloader := &Downloader{
+		logger: log.DefaultLogger,
+		client: client,
+		header: &v1.ActionsCache{
+			Outputs:         []*v1.ActionsOutput{{Id: "output", Size: 10}},
+			OutputTotalSize: 10,
+		},
+	}
+
+	disk, err := local.NewDisk(log.DefaultLogger, local.DiskDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDisk() error = %v", err)
+	}
+
+	backend, err := NewBackend(log.DefaultLogger, disk, &Uploader{}, downloader)
+	if err != nil {
+		t.Fatalf("NewBackend() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = backend.Close(context.Background())
+	})
+	<-client.started
+
+	if !backend.Pending("output") {
+		t.Error("Pending(output) = false, want true before the restoration reaches it")
+	}
+	if backend.Pending("other") {
+		t.Error("Pending(other) = true, want false for an output not in the blob")
+	}
+
+	diskPath, err := backend.WaitPending(t.Context(), "other", 10*time.Millisecond)
+	if err != nil || diskPath != "" {
+		t.Errorf("WaitPending(other) = %q, %v, want a miss once the wait is over", diskPath, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(client.release)
+	}()
+
+	diskPath, err = backend.WaitPending(t.Context(), "output", 10*time.Second)
+	if err != nil || diskPath == "" {
+		t.Errorf("WaitPending(output) = %q, %v, want the path of the output restored", diskPath, err)
+	}
+
+	if err := backend.WaitRestored(t.Context()); err != nil {
+		t.Fatalf("WaitRestored() error = %v", err)
+	}
+	if backend.Pending("output") {
+		t.Error("Pending(output) = true, want false once the restoration ends")
+	}
+}
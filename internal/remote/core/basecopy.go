@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCopyWorkers bounds the copies of the base blob running at once.
+	// The copies are done by the storage, so they take no bandwidth of the runner, but too many of them are throttled.
+	maxCopyWorkers = 16
+	// copyAttempts is the attempts of copying a range before the base is given up.
+	copyAttempts = 3
+	// copyBackoff is the wait before the second attempt, doubled for each later one.
+	copyBackoff = 500 * time.Millisecond
+)
+
+// ETagDownloadClient is a DownloadClient of a storage giving the version of the blob, e.g. its ETag.
+// The version is compared before and after the base blob is copied, so that ranges of different versions are never committed together.
+type ETagDownloadClient interface {
+	ETag(ctx context.Context) (string, error)
+}
+
+// ETag returns the version of the blob, or "" when the storage gives none.
+func (d *Downloader) ETag(ctx context.Context) (string, error) {
+	etagClient, ok := d.client.(ETagDownloadClient)
+	if !ok {
+		return "", nil
+	}
+
+	return etagClient.ETag(ctx)
+}
+
+// baseRange is a range of the base blob staged as a block.
+type baseRange struct {
+	blockID string
+	offset  int64
+	size    int64
+}
+
+// planCopy splits the size bytes of the base blob from offset into ranges of chunkSize, with the IDs of their blocks.
+func (u *Uploader) planCopy(offset, size, chunkSize int64) ([]baseRange, error) {
+	ranges := make([]baseRange, 0, (size+chunkSize-1)/chunkSize)
+	for i := int64(0); i < size; i += chunkSize {
+		blockID, err := u.generateBlockID()
+		if err != nil {
+			return nil, fmt.Errorf("generate block ID: %w", err)
+		}
+		ranges = append(ranges, baseRange{
+			blockID: blockID,
+			offset:  offset + i,
+			size:    min(chunkSize, size-i),
+		})
+	}
+
+	return ranges, nil
+}
+
+// copyBase stages the size bytes of the base blob at url from offset as blocks, and returns their IDs in order.
+// The ranges are copied by a bounded pool of workers, each range retried on failure,
+// and the ranges staged are verified to cover the outputs of the base exactly, from the same version of it.
+func (u *Uploader) copyBase(ctx context.Context, baseBlobProvider BaseBlobProvider, url string, offset, size int64) ([]string, error) {
+	etag, err := baseETag(ctx, baseBlobProvider)
+	if err != nil {
+		return nil, fmt.Errorf("get version of base: %w", err)
+	}
+
+	ranges, err := u.planCopy(offset, size, copyBlockSize(size))
+	if err != nil {
+		return nil, err
+	}
+	// A base taking every block leaves none to the outputs, so it is given up before anything is copied.
+	if u.newBlockBudget(int64(len(ranges))) == 0 {
+		return nil, fmt.Errorf("base blob needs %d blocks, over the %d blocks of a blob. raise --transfer.block-size", len(ranges), maxBlocks)
+	}
+	u.baseBlocks.Store(int64(len(ranges)))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Without a copy in the storage, the ranges are downloaded by the runner, sharing the transfers with the outputs.
+	throughRunner := !ClientCapabilities(u.client).ServerSideCopy
+
+	staged := make([]int64, len(ranges))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for range min(maxCopyWorkers, len(ranges)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := u.copyRangeWithRetry(ctx, url, ranges[i], throughRunner); err != nil {
+					cancel(err)
+					continue
+				}
+				staged[i] = ranges[i].size
+			}
+		}()
+	}
+	for i := range ranges {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := verifyCopy(ranges, staged, offset, size); err != nil {
+		return nil, err
+	}
+
+	if etag != "" {
+		after, err := baseETag(ctx, baseBlobProvider)
+		if err != nil {
+			return nil, fmt.Errorf("get version of base: %w", err)
+		}
+		if after != etag {
+			return nil, fmt.Errorf("base blob changed during the copy: version %s, now %s", etag, after)
+		}
+	}
+
+	u.base.Store(&copiedBase{url: url, ranges: ranges, throughRunner: throughRunner})
+
+	blockIDs := make([]string, len(ranges))
+	for i, r := range ranges {
+		blockIDs[i] = r.blockID
+	}
+
+	return blockIDs, nil
+}
+
+// baseETag returns the version of the base blob, or "" when its storage gives none.
+func baseETag(ctx context.Context, baseBlobProvider BaseBlobProvider) (string, error) {
+	etagProvider, ok := baseBlobProvider.(ETagDownloadClient)
+	if !ok {
+		return "", nil
+	}
+
+	return etagProvider.ETag(ctx)
+}
+
+// copyRangeWithRetry stages the range, retrying the failures with an exponential backoff.
+// A range copied through the runner takes a transfer for each attempt.
+func (u *Uploader) copyRangeWithRetry(ctx context.Context, url string, r baseRange, throughRunner bool) error {
+	backoff := copyBackoff
+	for attempt := 1; ; attempt++ {
+		if throughRunner {
+			if err := acquireTransfer(ctx); err != nil {
+				return fmt.Errorf("acquire transfer: %w", err)
+			}
+		}
+		err := u.client.UploadBlockFromURL(ctx, r.blockID, url, r.offset, r.size)
+		if throughRunner {
+			transfers.release()
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt == copyAttempts || ctx.Err() != nil {
+			return fmt.Errorf("upload block from URL at %d: %w", r.offset, err)
+		}
+
+		u.logger.Debugf("upload block from URL at %d (attempt %d/%d): %v. retrying in %s", r.offset, attempt, copyAttempts, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("upload block from URL at %d: %w", r.offset, errors.Join(err, context.Cause(ctx)))
+		}
+		backoff *= 2
+	}
+}
+
+// verifyCopy checks that the ranges staged follow each other from offset to offset+size, each with its whole length.
+func verifyCopy(ranges []baseRange, staged []int64, offset, size int64) error {
+	next := offset
+	for i, r := range ranges {
+		if r.offset != next {
+			return fmt.Errorf("range %d starts at %d, want %d", i, r.offset, next)
+		}
+		if staged[i] != r.size {
+			return fmt.Errorf("range %d at %d staged %d bytes, want %d", i, r.offset, staged[i], r.size)
+		}
+		next += r.size
+	}
+	if next != offset+size {
+		return fmt.Errorf("ranges end at %d, want %d", next, offset+size)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+// copyClient records the ranges copied by their block IDs, failing the first attempt of the ranges in failOnce.
+type copyClient struct {
+	mockUploadClient
+	locker   sync.Mutex
+	copied   map[string][2]int64
+	failOnce map[int64]bool
+}
+
+func (c *copyClient) UploadBlockFromURL(_ context.Context, blockID, _ string, offset, size int64) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.failOnce[offset] {
+		c.failOnce[offset] = false
+		return errors.New("transient")
+	}
+	c.copied[blockID] = [2]int64{offset, size}
+
+	return nil
+}
+
+// etagProvider is a base blob whose version changes after etagChanges calls of ETag.
+type etagProvider struct {
+	mockBaseBlobProvider
+	calls       int
+	etagChanges int
+}
+
+func (p *etagProvider) ETag(context.Context) (string, error) {
+	p.calls++
+	if p.etagChanges > 0 && p.calls > p.etagChanges {
+		return "v2", nil
+	}
+
+	return "v1", nil
+}
+
+func (p *etagProvider) GetOutputs(context.Context) ([]*v1.ActionsOutput, error) {
+	return nil, nil
+}
+
+func TestUploader_copyBase(t *testing.T) {
+	t.Parallel()
+
+	const size = 10*maxUploadChunkSize + 3
+
+	tests := []struct {
+		name        string
+		failOnce    map[int64]bool
+		etagChanges int
+		wantErr     bool
+	}{
+		{name: "copied"},
+		{name: "retried", failOnce: map[int64]bool{8 + 2*maxUploadChunkSize: true}},
+		{name: "base changed", etagChanges: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &copyClient{copied: map[string][2]int64{}, failOnce: tt.failOnce}
+			uploader := &Uploader{logger: log.DefaultLogger, client: client}
+			provider := &etagProvider{etagChanges: tt.etagChanges}
+
+			blockIDs, err := uploader.copyBase(t.Context(), provider, "https://example.com/base", 8, size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The blocks are in the order of the blob, and cover it exactly.
+			next := int64(8)
+			for _, blockID := range blockIDs {
+				r, ok := client.copied[blockID]
+				if !ok {
+					t.Fatalf("block %s is not copied", blockID)
+				}
+				if r[0] != next {
+					t.Errorf("block at %d, want %d", r[0], next)
+				}
+				next += r[1]
+			}
+			if next != 8+size {
+				t.Errorf("blocks end at %d, want %d", next, 8+size)
+			}
+			if len(client.copied) != len(blockIDs) || slices.Contains(blockIDs, "") {
+				t.Errorf("copied %d blocks for %d block IDs", len(client.copied), len(blockIDs))
+			}
+		})
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	t.Parallel()
+
+	ranges := []baseRange{{offset: 0, size: 4}, {offset: 4, size: 2}}
+
+	tests := []struct {
+		name    string
+		ranges  []baseRange
+		staged  []int64
+		size    int64
+		wantErr bool
+	}{
+		{name: "complete", ranges: ranges, staged: []int64{4, 2}, size: 6},
+		{name: "range not staged", ranges: ranges, staged: []int64{4, 0}, size: 6, wantErr: true},
+		{name: "short", ranges: ranges, staged: []int64{4, 2}, size: 7, wantErr: true},
+		{name: "gap", ranges: []baseRange{{offset: 0, size: 4}, {offset: 5, size: 1}}, staged: []int64{4, 1}, size: 6, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := verifyCopy(tt.ranges, tt.staged, 0, tt.size); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
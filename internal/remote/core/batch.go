@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+// maxBatchedOutputSize is the largest output packed into a shared block instead of a block of its own.
+// A StageBlock round trip costs about as much as sending this many bytes.
+const maxBatchedOutputSize = 256 << 10
+
+// outputBatch is a block shared by small outputs, which saves a StageBlock round trip and a block list entry per output.
+// The outputs are placed one after another, so the header records them like the outputs of their own blocks.
+type outputBatch struct {
+	blockID string
+	buf     *membuf.Buffer
+	// outputs have offsets relative to the start of the block.
+	outputs []*v1.ActionsOutput
+}
+
+// uploadedBatch is a batch staged in the blob.
+type uploadedBatch struct {
+	blockID string
+	size    int64
+	outputs []*v1.ActionsOutput
+}
+
+// addToBatch appends an output to the current batch, and uploads the batch once it reaches batchSize.
+func (u *Uploader) addToBatch(ctx context.Context, outputID string, r io.Reader, compression v1.Compression) error {
+	full, err := func() (*outputBatch, error) {
+		u.batchLocker.Lock()
+		defer u.batchLocker.Unlock()
+
+		if u.batch == nil {
+			blockID, err := u.generateBlockID()
+			if err != nil {
+				return nil, fmt.Errorf("generate block ID: %w", err)
+			}
+
+			buf, err := membuf.New(batchSize() + maxBatchedOutputSize)
+			if err != nil {
+				return nil, fmt.Errorf("allocate batch buffer: %w", err)
+			}
+
+			u.batch = &outputBatch{blockID: blockID, buf: buf}
+		}
+		batch := u.batch
+
+		offset := batch.buf.Len()
+		n, err := io.Copy(batch.buf, r)
+		if err != nil {
+			// The bytes copied so far are left in the block unreferenced, as the outputs after them are placed by the buffer length.
+			return nil, fmt.Errorf("copy output: %w", err)
+		}
+		batch.outputs = append(batch.outputs, &v1.ActionsOutput{
+			Id:          outputID,
+			Offset:      offset,
+			Size:        n,
+			Compression: compression,
+		})
+
+		if batch.buf.Len() < batchSize() {
+			return nil, nil
+		}
+		u.batch = nil
+
+		return batch, nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	if full != nil {
+		return u.uploadBatch(ctx, full)
+	}
+
+	return nil
+}
+
+// flushBatch uploads the batch not full yet.
+func (u *Uploader) flushBatch(ctx context.Context) error {
+	var batch *outputBatch
+	func() {
+		u.batchLocker.Lock()
+		defer u.batchLocker.Unlock()
+		batch, u.batch = u.batch, nil
+	}()
+	if batch == nil {
+		return nil
+	}
+
+	return u.uploadBatch(ctx, batch)
+}
+
+// uploadBatch stages a batch. The outputs of a batch failed to upload are left out of the blob, like a failed output of its own block.
+func (u *Uploader) uploadBatch(ctx context.Context, batch *outputBatch) error {
+	defer batch.buf.Release()
+
+	if len(batch.outputs) == 0 {
+		return nil
+	}
+
+	if !u.reserveBlock() {
+		u.skipOverBlockLimit(len(batch.outputs), batch.buf.Len())
+		return nil
+	}
+
+	// A batch holds the smallest outputs, so it goes before every output of its own block.
+	if err := uploadQueue.acquireUpload(ctx, 0); err != nil {
+		u.blocks.Add(-1)
+		return fmt.Errorf("acquire transfer: %w", err)
+	}
+	var (
+		size int64
+		err  error
+	)
+	report.RemoteIONanos.Stopwatch(func() {
+		size, err = u.client.UploadBlock(ctx, batch.blockID, myio.NopSeekCloser(batch.buf.Reader()))
+	})
+	transfers.release()
+	if err != nil {
+		u.blocks.Add(-1)
+		return fmt.Errorf("upload batch of %d outputs: %w", len(batch.outputs), err)
+	}
+	report.UploadedBytes.Add(size)
+
+	u.batches.append(&uploadedBatch{
+		blockID: batch.blockID,
+		size:    batch.buf.Len(),
+		outputs: batch.outputs,
+	})
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/mazrean/gocica/internal/pkg/report"
+)
+
+const (
+	// maxBlocks is the most blocks a blob can be committed with, the limit of Azure Blob Storage.
+	maxBlocks = 50000
+	// maxCopyBlocks is the part of maxBlocks for the blocks copied from the base blob.
+	// The rest is left to the outputs and the batches of the run.
+	maxCopyBlocks = 40000
+	// maxCopyBlockSize is the largest block copied from a URL, the limit of Put Block From URL.
+	maxCopyBlockSize = 4000 << 20
+	// blockSizeAlign is the unit the block sizes picked are rounded up to.
+	blockSizeAlign = 1 << 20
+)
+
+// blockSize is the size of the blocks copied and batched given by the user, 0 to pick it.
+var blockSize atomic.Int64
+
+// SetBlockSize sets the size of the blocks copied from the base blob and of the batches of small outputs.
+// 0 picks the size by the size of the blob. It must be called before any upload.
+func SetBlockSize(size int64) {
+	blockSize.Store(size)
+}
+
+// copyBlockSize returns the size of the blocks copying total bytes of a blob.
+// Multi-GB blobs get blocks larger than maxUploadChunkSize, so that the copies stay within maxCopyBlocks and take fewer round trips.
+func copyBlockSize(total int64) int64 {
+	if size := blockSize.Load(); size > 0 {
+		return min(size, maxCopyBlockSize)
+	}
+
+	size := int64(maxUploadChunkSize)
+	if need := (total + maxCopyBlocks - 1) / maxCopyBlocks; need > size {
+		size = (need + blockSizeAlign - 1) / blockSizeAlign * blockSizeAlign
+	}
+
+	return min(size, maxCopyBlockSize)
+}
+
+// batchSize returns the size from which a batch of small outputs is uploaded.
+func batchSize() int64 {
+	if size := blockSize.Load(); size > 0 {
+		return size
+	}
+
+	return maxUploadChunkSize
+}
+
+// newBlockBudget returns the blocks left for the outputs and the batches of the run after the header and baseBlocks blocks of the base.
+// A storage sending the blob as a whole has no limit on them.
+func (u *Uploader) newBlockBudget(baseBlocks int64) int64 {
+	if !ClientCapabilities(u.client).Multipart {
+		return math.MaxInt64
+	}
+
+	return max(maxBlocks-1-baseBlocks, 0)
+}
+
+// reserveBlock reserves a block for an output or a batch, and reports false when the blob has no block left for it.
+// The base blob is counted by the blocks planned for its copy, so the outputs uploaded before it is planned may still exceed the budget,
+// which Commit leaves out of the blob.
+func (u *Uploader) reserveBlock() bool {
+	if u.blocks.Add(1) > u.newBlockBudget(u.baseBlocks.Load()) {
+		u.blocks.Add(-1)
+		return false
+	}
+
+	return true
+}
+
+// skipOverBlockLimit leaves the outputs out of the remote cache, as the blob has no block left for them.
+// They stay in the local cache, and their entries are dropped at the commit like the ones of a failed upload.
+func (u *Uploader) skipOverBlockLimit(outputs int, size int64) {
+	u.blockLimitOnce.Do(func() {
+		u.logger.Warnf("the blob reached the %d blocks of Azure Blob Storage. the rest of the outputs are only kept in the local cache. raise --transfer.block-size to pack more outputs in a block.", maxBlocks)
+	})
+	report.BlockLimitSkipped.Add(int64(outputs))
+	report.BlockLimitSkippedBytes.Add(size)
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+// TestCopyBlockSize is not parallel, as it sets the block size of the process.
+func TestCopyBlockSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockSize int64
+		total     int64
+		want      int64
+	}{
+		{name: "small blob", total: 100 << 20, want: maxUploadChunkSize},
+		{name: "at the block limit", total: maxCopyBlocks * maxUploadChunkSize, want: maxUploadChunkSize},
+		{name: "multi-GB blob", total: maxCopyBlocks*maxUploadChunkSize + 1, want: maxUploadChunkSize + blockSizeAlign},
+		{name: "huge blob", total: 1 << 50, want: maxCopyBlockSize},
+		{name: "given", blockSize: 16 << 20, total: 100 << 20, want: 16 << 20},
+		{name: "given beyond the limit", blockSize: 8000 << 20, total: 100 << 20, want: maxCopyBlockSize},
+	}
+
+	t.Cleanup(func() {
+		SetBlockSize(0)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetBlockSize(tt.blockSize)
+
+			got := copyBlockSize(tt.total)
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+			if tt.blockSize == 0 && got < maxCopyBlockSize && (tt.total+got-1)/got > maxCopyBlocks {
+				t.Errorf("%d blocks of %d bytes, over %d", (tt.total+got-1)/got, got, maxCopyBlocks)
+			}
+		})
+	}
+}
+
+func TestUploader_reserveBlock(t *testing.T) {
+	t.Parallel()
+
+	uploader := &Uploader{logger: log.DefaultLogger}
+	uploader.baseBlocks.Store(maxBlocks - 3)
+
+	// The header and the base leave two blocks.
+	for i := range 2 {
+		if !uploader.reserveBlock() {
+			t.Fatalf("block %d is not reserved", i)
+		}
+	}
+	if uploader.reserveBlock() {
+		t.Error("reserved a block over the limit")
+	}
+	if got := uploader.blocks.Load(); got != 2 {
+		t.Errorf("blocks: got %d, want 2", got)
+	}
+}
+
+func TestUploader_constructOutputs_blockLimit(t *testing.T) {
+	t.Parallel()
+
+	uploader := &Uploader{logger: log.DefaultLogger}
+	uploader.outputs.append(&v1.ActionsOutput{Id: "a", Size: 10})
+	uploader.outputs.append(&v1.ActionsOutput{Id: "empty"})
+	uploader.outputs.append(&v1.ActionsOutput{Id: "b", Size: 20})
+	uploader.batches.append(&uploadedBatch{blockID: "batch", size: 5, outputs: []*v1.ActionsOutput{{Id: "c", Size: 5}}})
+
+	blockIDs, outputs, offset := uploader.constructOutputs(0, nil, 1, nil)
+
+	if len(blockIDs) != 1 || blockIDs[0] != "a" {
+		t.Errorf("block IDs: got %v, want [a]", blockIDs)
+	}
+	// An empty output takes no block, so it is kept.
+	var ids []string
+	for _, output := range outputs {
+		ids = append(ids, output.Id)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "empty" {
+		t.Errorf("outputs: got %v, want [a empty]", ids)
+	}
+	if offset != 10 {
+		t.Errorf("offset: got %d, want 10", offset)
+	}
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/remote"
+)
+
+// CapableClient is a DownloadClient or an UploadClient reporting the capabilities of its storage.
+// The clients of the backends of plugins implement it with backend.CapableClient.
+type CapableClient = backend.CapableClient
+
+// ClientCapabilities returns the capabilities of the storage of the client,
+// or backend.DefaultCapabilities, the ones of Azure Blob Storage, when it reports none or is nil.
+func ClientCapabilities(client any) remote.Capabilities {
+	if capableClient, ok := client.(CapableClient); ok {
+		return capableClient.Capabilities()
+	}
+
+	return backend.DefaultCapabilities
+}
+
+// Capabilities returns the capabilities of the storage, the ranged reads of the blob read and the rest of the blob written.
+func (c *Backend) Capabilities() remote.Capabilities {
+	capabilities := ClientCapabilities(c.uploader.client)
+	capabilities.RangedReads = ClientCapabilities(c.downloader.client).RangedReads
+
+	return capabilities
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/remote"
+)
+
+// wholeObjectClient is an UploadClient of a storage taking the blob as a whole, like an object store behind a pre-signed URL.
+type wholeObjectClient struct {
+	mockUploadClient
+}
+
+func (*wholeObjectClient) Capabilities() remote.Capabilities {
+	return remote.Capabilities{RangedReads: true}
+}
+
+func TestClientCapabilities(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		client any
+		want   remote.Capabilities
+	}{
+		{name: "nil", want: backend.DefaultCapabilities},
+		{name: "not reported", client: &mockUploadClient{}, want: backend.DefaultCapabilities},
+		{name: "reported", client: &wholeObjectClient{}, want: remote.Capabilities{RangedReads: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := ClientCapabilities(tt.client); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploader_newBlockBudget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		client UploadClient
+		want   int64
+	}{
+		{name: "multipart", client: &mockUploadClient{}, want: maxBlocks - 1 - 100},
+		{name: "whole object", client: &wholeObjectClient{}, want: math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			uploader := &Uploader{client: tt.client}
+			if got := uploader.newBlockBudget(100); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
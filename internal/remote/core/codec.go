@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/zstd"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+const (
+	// probeSampleSize is the size of the sample the CPU compresses at start to pick the codec.
+	probeSampleSize = 4 << 20
+	// minCompressThroughput is the throughput of zstd level 1 below which the outputs are uploaded uncompressed,
+	// as compressing them would take longer than the uploads it saves on a runner.
+	minCompressThroughput = 64 << 20
+	// highCompressThroughput is the throughput of zstd level 1 from which level 3 keeps up with the uploads, for smaller blobs.
+	highCompressThroughput = 512 << 20
+)
+
+// Codec is the compression of the outputs uploaded by the runner.
+// The compression of every output is recorded in the header, and zstd frames are decompressed whatever their level,
+// so the runners of a fleet picking different codecs read the outputs of each other.
+// The zero Codec uploads the outputs uncompressed.
+type Codec struct {
+	Compression v1.Compression
+	// Level is the zstd level.
+	Level int
+}
+
+func (c Codec) String() string {
+	if c.Compression != v1.Compression_COMPRESSION_ZSTD {
+		return "none"
+	}
+
+	return fmt.Sprintf("zstd level %d", c.Level)
+}
+
+// DefaultCodec is the codec of the outputs when none is set.
+var DefaultCodec = Codec{Compression: v1.Compression_COMPRESSION_ZSTD, Level: 1}
+
+var codec atomic.Pointer[Codec]
+
+// SetCodec sets the codec of the outputs uploaded after it.
+func SetCodec(c Codec) {
+	codec.Store(&c)
+}
+
+func currentCodec() Codec {
+	if c := codec.Load(); c != nil {
+		return *c
+	}
+
+	return DefaultCodec
+}
+
+// ProbeCodec compresses a sample with zstd level 1, and picks the codec by the throughput of the CPU in bytes per second.
+// It fails when the sample is not decompressed back to itself.
+func ProbeCodec() (Codec, float64, error) {
+	sample := probeSample()
+
+	start := time.Now()
+	compressed, err := zstd.CompressLevel(nil, sample, 1)
+	elapsed := time.Since(start)
+	if err != nil {
+		return Codec{}, 0, fmt.Errorf("compress sample: %w", err)
+	}
+
+	decompressed, err := zstd.Decompress(nil, compressed)
+	if err != nil {
+		return Codec{}, 0, fmt.Errorf("decompress sample: %w", err)
+	}
+	if !bytes.Equal(decompressed, sample) {
+		return Codec{}, 0, errors.New("the sample is not decompressed back to itself")
+	}
+
+	throughput := float64(len(sample)) / max(elapsed.Seconds(), 1e-9)
+
+	return pickCodec(throughput), throughput, nil
+}
+
+// pickCodec picks the codec for a CPU compressing bytesPerSec with zstd level 1.
+func pickCodec(bytesPerSec float64) Codec {
+	switch {
+	case bytesPerSec < minCompressThroughput:
+		return Codec{}
+	case bytesPerSec >= highCompressThroughput:
+		return Codec{Compression: v1.Compression_COMPRESSION_ZSTD, Level: 3}
+	default:
+		return DefaultCodec
+	}
+}
+
+// probeSample returns a sample compressing about as well as the outputs of the go command, random bytes and repeated runs in halves.
+func probeSample() []byte {
+	const run = 256
+
+	r := rand.New(rand.NewPCG(1, 2))
+	sample := make([]byte, probeSampleSize)
+	for offset := 0; offset < len(sample); offset += 2 * run {
+		chunk := sample[offset:min(offset+run, len(sample))]
+		for i := range chunk {
+			chunk[i] = byte(r.Uint32())
+		}
+		copy(sample[min(offset+run, len(sample)):min(offset+2*run, len(sample))], chunk)
+	}
+
+	return sample
+}
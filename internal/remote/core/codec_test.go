@@ -0,0 +1,46 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+func TestPickCodec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		bytesPerSec float64
+		want        Codec
+	}{
+		{name: "slow CPU", bytesPerSec: 10 << 20, want: Codec{}},
+		{name: "average CPU", bytesPerSec: 200 << 20, want: DefaultCodec},
+		{name: "fast CPU", bytesPerSec: 1 << 30, want: Codec{Compression: v1.Compression_COMPRESSION_ZSTD, Level: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := pickCodec(tt.bytesPerSec); got != tt.want {
+				t.Errorf("pickCodec(%v) = %v, want %v", tt.bytesPerSec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeCodec(t *testing.T) {
+	t.Parallel()
+
+	codec, throughput, err := ProbeCodec()
+	if err != nil {
+		t.Fatalf("ProbeCodec() error = %v", err)
+	}
+	if throughput <= 0 {
+		t.Errorf("throughput: got %v, want a positive one", throughput)
+	}
+	if codec != pickCodec(throughput) {
+		t.Errorf("codec: got %v, want %v for %v bytes/s", codec, pickCodec(throughput), throughput)
+	}
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+
+	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+)
+
+// zstdMagic starts every zstd frame, which the downloads decompress the outputs from.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// maxCompressorStderr is the part of the stderr of a failing compressor kept in its error.
+const maxCompressorStderr = 512
+
+// ExternalCompressor compresses the large outputs with a command instead of the built-in zstd,
+// e.g. a multithreaded zstd or a compressor offloading to hardware.
+// The command reads an output on stdin and writes a zstd frame of it to stdout.
+type ExternalCompressor struct {
+	args    []string
+	minSize int64
+	// failed is whether the command failed, after which the built-in zstd compresses every output.
+	failed atomic.Bool
+}
+
+// NewExternalCompressor creates the compressor running command, split on spaces without a shell, for the outputs of at least minSize bytes.
+// It compresses a sample with the command, and fails when the result is not decompressed back to the sample.
+func NewExternalCompressor(ctx context.Context, command string, minSize int64) (*ExternalCompressor, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("find command: %w", err)
+	}
+
+	c := &ExternalCompressor{args: args, minSize: minSize}
+
+	sample := bytes.Repeat([]byte("gocica checks the external compressor with this sample. "), 1024)
+	var compressed bytes.Buffer
+	if err := c.compress(ctx, &compressed, bytes.NewReader(sample)); err != nil {
+		return nil, fmt.Errorf("compress sample: %w", err)
+	}
+	decompressed, err := zstd.Decompress(nil, compressed.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("decompress sample: %w", err)
+	}
+	if !bytes.Equal(decompressed, sample) {
+		return nil, errors.New("the sample is not decompressed back to itself")
+	}
+
+	return c, nil
+}
+
+func (c *ExternalCompressor) String() string {
+	return strings.Join(c.args, " ")
+}
+
+// compress writes the zstd frame of r written by the command to w.
+func (c *ExternalCompressor) compress(ctx context.Context, w io.Writer, r io.Reader) error {
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = r
+	out := &frameWriter{w: w}
+	cmd.Stdout = out
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	var err error
+	compressGauge.Stopwatch(func() {
+		err = cmd.Run()
+	}, "compress_exec")
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return fmt.Errorf("run %s: %w", c, err)
+		}
+		if len(message) > maxCompressorStderr {
+			message = message[:maxCompressorStderr]
+		}
+		return fmt.Errorf("run %s: %w: %s", c, err, message)
+	}
+	if !bytes.Equal(out.head, zstdMagic) {
+		return fmt.Errorf("%s wrote no zstd frame", c)
+	}
+
+	return nil
+}
+
+// frameWriter keeps the first bytes written, which start a zstd frame.
+type frameWriter struct {
+	w    io.Writer
+	head []byte
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	if n := len(zstdMagic) - len(f.head); n > 0 {
+		f.head = append(f.head, p[:min(n, len(p))]...)
+	}
+
+	return f.w.Write(p)
+}
+
+// externalCompressor is the compressor of the large outputs, nil for the built-in zstd.
+var externalCompressor atomic.Pointer[ExternalCompressor]
+
+// SetExternalCompressor makes c compress the outputs of at least its minimum size uploaded afterwards. nil compresses them with the built-in zstd.
+func SetExternalCompressor(c *ExternalCompressor) {
+	externalCompressor.Store(c)
+}
+
+// compressOutput compresses the output of size bytes in r into a new buffer, with the external compressor when it is large.
+// When the external compressor fails, the output and the ones after it are compressed with the built-in zstd.
+func (u *Uploader) compressOutput(ctx context.Context, r io.ReadSeeker, size int64) (*membuf.Buffer, error) {
+	if c := externalCompressor.Load(); c != nil && size >= c.minSize && !c.failed.Load() {
+		buf, err := membuf.New(size)
+		if err != nil {
+			return nil, fmt.Errorf("allocate compression buffer: %w", err)
+		}
+		err = c.compress(ctx, buf, r)
+		if err == nil {
+			return buf, nil
+		}
+		buf.Release()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		if c.failed.CompareAndSwap(false, true) {
+			u.logger.Warnf("external compressor failed: %v. the built-in zstd compresses the outputs from now on", err)
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("rewind output: %w", err)
+		}
+	}
+
+	// The compressed output is at most about as large as the output.
+	buf, err := membuf.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("allocate compression buffer: %w", err)
+	}
+	if err := compress(buf, r); err != nil {
+		buf.Release()
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/log"
+)
+
+func TestNewExternalCompressor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		command string
+		needs   string
+		wantErr bool
+	}{
+		{name: "zstd", command: "zstd -q -c -3", needs: "zstd"},
+		{name: "not a zstd frame", command: "cat", needs: "cat", wantErr: true},
+		{name: "failing command", command: "false", needs: "false", wantErr: true},
+		{name: "unknown command", command: "gocica-no-such-compressor", wantErr: true},
+		{name: "empty command", command: "  ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.needs != "" {
+				if _, err := exec.LookPath(tt.needs); err != nil {
+					t.Skipf("%s not found: %v", tt.needs, err)
+				}
+			}
+
+			_, err := NewExternalCompressor(t.Context(), tt.command, 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewExternalCompressor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploader_compressOutputFallback(t *testing.T) {
+	// Not parallel, as the external compressor is global.
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skipf("false not found: %v", err)
+	}
+
+	compressor := &ExternalCompressor{args: []string{"false"}}
+	SetExternalCompressor(compressor)
+	t.Cleanup(func() { SetExternalCompressor(nil) })
+
+	output := bytes.Repeat([]byte("output "), 1<<12)
+	u := &Uploader{logger: log.DefaultLogger}
+	buf, err := u.compressOutput(t.Context(), bytes.NewReader(output), int64(len(output)))
+	if err != nil {
+		t.Fatalf("compressOutput() error = %v", err)
+	}
+	defer buf.Release()
+
+	compressed, err := io.ReadAll(buf.Reader())
+	if err != nil {
+		t.Fatalf("read compressed output: %v", err)
+	}
+	decompressed, err := zstd.Decompress(nil, compressed)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, output) {
+		t.Error("the output compressed by the built-in zstd is not decompressed back to itself")
+	}
+	if !compressor.failed.Load() {
+		t.Error("the failing external compressor is still used")
+	}
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/syncpause"
+	"github.com/mazrean/gocica/log"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// minTransfers and maxTransfers bound the parallel transfers of blocks.
+	minTransfers = 4
+	maxTransfers = 128
+	// transfersPerCPU is the transfers per CPU before the bandwidth is known.
+	// Transfers mostly wait for the network, so a few of them per CPU keep the CPU busy on decompression and writes.
+	transfersPerCPU = 4
+	// maxTransfersPerCPU caps the tuned transfers per CPU, as every transfer costs the CPU to decompress and write it.
+	maxTransfersPerCPU = 8
+	// targetBandwidth is the aggregate bandwidth the tuned transfers aim for.
+	// It is above what the NIC of a hosted runner gives, so it is the CPUs or maxTransfers that bound a fast machine.
+	targetBandwidth = 1 << 30
+	// minProbeSize is the smallest chunk whose download is taken as the bandwidth probe.
+	// Smaller chunks mostly measure the latency of the request.
+	minProbeSize = 1 << 20
+	// lowPriorityTransfers is the transfers of a sync in the background, which must leave the network to the developer.
+	lowPriorityTransfers = 2
+)
+
+var (
+	// transfers limits the parallel downloads of chunks and uploads of outputs of the process.
+	// It starts from the CPUs and is tuned once by the bandwidth of the first chunk downloaded.
+	transfers = newLimiter(cpuTransfers(runtime.GOMAXPROCS(0)))
+	// compressions limits the parallel compressions of outputs, which are bound by the CPUs.
+	compressions = semaphore.NewWeighted(int64(runtime.GOMAXPROCS(0)))
+
+	probeOnce   sync.Once
+	lowPriority atomic.Bool
+)
+
+// SetLowPriority limits the transfers and compressions to a few, and keeps them from being tuned up,
+// so that the sync with the remote cache stays in the background of a developer machine.
+// It must be called before any transfer.
+func SetLowPriority() {
+	lowPriority.Store(true)
+	transfers.setLimit(lowPriorityTransfers)
+	// The weight held here is never released, which leaves a single compression at a time.
+	if procs := int64(runtime.GOMAXPROCS(0)); procs > 1 {
+		compressions.TryAcquire(procs - 1)
+	}
+}
+
+// SetTransfers fixes the parallel transfers of blocks to n, instead of tuning them by the bandwidth.
+// It may be called while blocks are transferred, e.g. on a reload of the configuration; a lower limit takes effect as the transfers in flight finish.
+func SetTransfers(n int) {
+	transfers.setLimit(int64(n))
+	// The probe is spent, so that the first chunk downloaded does not tune the limit given.
+	probeOnce.Do(func() {})
+}
+
+// acquireTransfer waits for the sync with the remote cache to be resumed and for a transfer slot.
+func acquireTransfer(ctx context.Context) error {
+	if err := syncpause.Wait(ctx); err != nil {
+		return err
+	}
+
+	return transfers.acquire(ctx)
+}
+
+// cpuTransfers returns the transfers for procs CPUs while the bandwidth is not known.
+func cpuTransfers(procs int) int64 {
+	return min(max(int64(procs)*transfersPerCPU, minTransfers), maxTransfers)
+}
+
+// tunedTransfers returns the transfers to reach targetBandwidth with streams of bytesPerSec each,
+// within what procs CPUs can process.
+func tunedTransfers(procs int, bytesPerSec float64) int64 {
+	upper := min(max(int64(procs)*maxTransfersPerCPU, minTransfers), maxTransfers)
+	if bytesPerSec <= 0 {
+		return upper
+	}
+
+	return min(max(int64(math.Ceil(targetBandwidth/bytesPerSec)), minTransfers), upper)
+}
+
+// probeBandwidth tunes transfers by the bandwidth of a chunk download of size bytes which took elapsed.
+// Only the first chunk large enough to measure is taken.
+func probeBandwidth(logger log.Logger, size int64, elapsed time.Duration) {
+	if size < minProbeSize || elapsed <= 0 || lowPriority.Load() {
+		return
+	}
+
+	probeOnce.Do(func() {
+		bytesPerSec := float64(size) / elapsed.Seconds()
+		procs := runtime.GOMAXPROCS(0)
+		limit := tunedTransfers(procs, bytesPerSec)
+		transfers.setLimit(limit)
+
+		logger.Infof("tuned parallel transfers to %d: %.1fMiB/s per transfer, GOMAXPROCS=%d", limit, bytesPerSec/(1<<20), procs)
+	})
+}
+
+// limiter is a semaphore whose limit can be changed while it is in use.
+// The weight above the limit is held by the limiter itself, so that changing the limit is releasing or acquiring it.
+type limiter struct {
+	sem    *semaphore.Weighted
+	locker sync.Mutex
+	// held is the weight held by the limiter. It is maxTransfers minus the limit once pending is done.
+	held    int64
+	pending *pendingAcquire
+}
+
+// pendingAcquire is the weight of a lowered limit waiting for the transfers in flight to finish.
+type pendingAcquire struct {
+	weight int64
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func newLimiter(limit int64) *limiter {
+	l := &limiter{
+		sem: semaphore.NewWeighted(maxTransfers),
+	}
+	l.setLimit(limit)
+
+	return l
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	return l.sem.Acquire(ctx, 1)
+}
+
+func (l *limiter) release() {
+	l.sem.Release(1)
+}
+
+// setLimit changes the limit. A lower limit takes effect as the transfers in flight finish.
+func (l *limiter) setLimit(limit int64) {
+	limit = min(max(limit, 1), maxTransfers)
+
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	if p := l.pending; p != nil {
+		// An acquire is canceled as a whole or not at all, so held is exact once it is done.
+		p.cancel()
+		<-p.done
+		if p.err == nil {
+			l.held += p.weight
+		}
+		l.pending = nil
+	}
+
+	target := maxTransfers - limit
+	switch {
+	case target < l.held:
+		l.sem.Release(l.held - target)
+		l.held = target
+	case target > l.held:
+		weight := target - l.held
+		if l.sem.TryAcquire(weight) {
+			l.held = target
+			break
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		p := &pendingAcquire{weight: weight, cancel: cancel, done: make(chan struct{})}
+		go func() {
+			defer close(p.done)
+			p.err = l.sem.Acquire(ctx, weight)
+		}()
+		l.pending = p
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCPUTransfers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		procs int
+		want  int64
+	}{
+		{procs: 1, want: minTransfers},
+		{procs: 2, want: 8},
+		{procs: 16, want: 64},
+		{procs: 64, want: maxTransfers},
+	}
+
+	for _, tt := range tests {
+		if got := cpuTransfers(tt.procs); got != tt.want {
+			t.Errorf("cpuTransfers(%d) = %d, want %d", tt.procs, got, tt.want)
+		}
+	}
+}
+
+func TestTunedTransfers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		procs       int
+		bytesPerSec float64
+		want        int64
+	}{
+		{name: "slow streams on a small runner", procs: 2, bytesPerSec: 10 << 20, want: 16},
+		{name: "slow streams on a large machine", procs: 64, bytesPerSec: 10 << 20, want: 103},
+		{name: "fast streams", procs: 64, bytesPerSec: 500 << 20, want: minTransfers},
+		{name: "moderate streams", procs: 64, bytesPerSec: 64 << 20, want: 16},
+		{name: "unknown bandwidth", procs: 2, bytesPerSec: 0, want: 16},
+		{name: "many CPUs", procs: 256, bytesPerSec: 1 << 20, want: maxTransfers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tunedTransfers(tt.procs, tt.bytesPerSec); got != tt.want {
+				t.Errorf("tunedTransfers(%d, %f) = %d, want %d", tt.procs, tt.bytesPerSec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l := newLimiter(2)
+
+	for range 2 {
+		if err := l.acquire(ctx); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	}
+	if l.sem.TryAcquire(1) {
+		t.Fatal("acquired beyond the limit")
+	}
+
+	// Lowering the limit with transfers in flight waits for them to finish.
+	l.setLimit(1)
+	l.release()
+	l.release()
+	<-l.pending.done
+
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(shortCtx); err != nil {
+		t.Fatalf("acquire after lowering: %v", err)
+	}
+	if l.sem.TryAcquire(1) {
+		t.Fatal("acquired beyond the lowered limit")
+	}
+
+	// Raising the limit frees the weight at once.
+	l.setLimit(3)
+	for range 2 {
+		if !l.sem.TryAcquire(1) {
+			t.Fatal("failed to acquire within the raised limit")
+		}
+	}
+	if l.sem.TryAcquire(1) {
+		t.Fatal("acquired beyond the raised limit")
+	}
+}
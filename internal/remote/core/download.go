@@ -1,47 +1,80 @@
 package core
 
 import (
+	"cmp"
 	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/pkg/audit"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
 	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/policy"
 	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
 	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
-	"google.golang.org/protobuf/proto"
 )
 
 type Downloader struct {
 	logger log.Logger
 	// warning: client can be nil, which means no download is needed.
-	client     DownloadClient
+	client DownloadClient
+	// cipher decrypts outputs and the header after download. nil disables decryption.
+	cipher *crypt.Cipher
+	// signer verifies the header after download. nil accepts unsigned headers.
+	signer     *crypt.Signer
 	headerSize int64
 	header     *v1.ActionsCache
+	// rejected is the reason the header was not trusted, or nil.
+	rejected error
+	// outputs are the outputs of the header by their IDs, built on the first lookup by output.
+	outputsOnce sync.Once
+	outputs     map[string]*v1.ActionsOutput
+	// filtered are the outputs left out of the restoration by the download filter, built on the first use.
+	filteredOnce sync.Once
+	filtered     map[string]struct{}
+	// restored is the number of outputs restored by DownloadAllOutputBlocks so far.
+	restored atomic.Int64
+	// fetches are the outputs waiting to be read together by fetchCoalesced, and fetching whether a fetch is gathering them.
+	fetchLocker sync.Mutex
+	fetches     []*fetchRequest
+	fetching    bool
 }
 
-// DownloadClient defines the interface for downloading blocks from remote storage.
-type DownloadClient interface {
-	GetURL(ctx context.Context) string
-	DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error
-	DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error
-}
+// DownloadClient reads blocks from remote storage.
+// It is the client of the public backend package, so that the storages built in and the ones registered cannot drift apart.
+type DownloadClient = backend.DownloadClient
 
 // NewDownloader creates a new Downloader with the given client.
 // It reads the header from the remote storage immediately.
+// When cipher is not nil, the blob is expected to be encrypted with it.
+// When signer is not nil, a blob whose header is unsigned or signed by another key is treated as empty,
+// so that every output is a miss and nothing of it is carried over to the next upload.
 func NewDownloader(
 	ctx context.Context,
 	logger log.Logger,
 	client DownloadClient,
+	cipher *crypt.Cipher,
+	signer *crypt.Signer,
 ) (*Downloader, error) {
 	downloader := &Downloader{
 		logger: logger,
 		client: client,
+		cipher: cipher,
+		signer: signer,
 	}
 
 	var err error
@@ -50,9 +83,33 @@ func NewDownloader(
 		return nil, fmt.Errorf("read header: %w", err)
 	}
 
+	if downloader.rejected != nil {
+		logger.Warnf("ignoring the remote cache: %v", downloader.rejected)
+		downloader.header = &v1.ActionsCache{
+			Entries: map[string]*v1.IndexEntry{},
+		}
+	}
+
 	return downloader, nil
 }
 
+// outputTimenanos returns the earliest time of the entries of each output in Unix nanoseconds, by the output IDs.
+func (d *Downloader) outputTimenanos() map[string]int64 {
+	timenanos := make(map[string]int64, len(d.header.Outputs))
+	for _, entry := range d.header.Entries {
+		if t, ok := timenanos[entry.OutputId]; !ok || entry.Timenano < t {
+			timenanos[entry.OutputId] = entry.Timenano
+		}
+	}
+
+	return timenanos
+}
+
+// Rejected returns the reason the header was not trusted, or nil when it was accepted.
+func (d *Downloader) Rejected() error {
+	return d.rejected
+}
+
 func (d *Downloader) readHeader(ctx context.Context) (header *v1.ActionsCache, headerSize int64, err error) {
 	if d.client == nil {
 		return &v1.ActionsCache{
@@ -62,26 +119,79 @@ func (d *Downloader) readHeader(ctx context.Context) (header *v1.ActionsCache, h
 		}, 0, nil
 	}
 
+	// A header kept by an earlier run for the same version of the blob is read instead of downloaded.
+	etag := d.blobETag(ctx)
+	if protoBuf, ok := loadHeader(etag); ok {
+		header, err = d.decodeHeader(protoBuf)
+		if err == nil {
+			d.logger.Debugf("remote header is unchanged (version %s). read it from the local cache.", etag)
+			return header, 8 + int64(len(protoBuf)), nil
+		}
+		d.logger.Debugf("decode kept header: %v. downloading it.", err)
+	}
+
+	protoBuf, err := d.downloadHeader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if etag != "" {
+		if err := storeHeader(etag, protoBuf); err != nil {
+			d.logger.Debugf("keep header: %v", err)
+		}
+	}
+
+	header, err = d.decodeHeader(protoBuf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return header, 8 + int64(len(protoBuf)), nil
+}
+
+// downloadHeader downloads the header of the blob, as encoded in it.
+func (d *Downloader) downloadHeader(ctx context.Context) ([]byte, error) {
+	var err error
 	sizeBuf := make([]byte, 8)
-	err = d.client.DownloadBlockBuffer(ctx, 0, 8, sizeBuf)
+	report.RemoteIONanos.Stopwatch(func() {
+		err = d.client.DownloadBlockBuffer(ctx, 0, 8, sizeBuf)
+	})
 	if err != nil {
-		return nil, 0, fmt.Errorf("download size buffer: %w", err)
+		return nil, fmt.Errorf("download size buffer: %w", err)
 	}
 	//nolint:gosec
 	protobufSize := int64(binary.BigEndian.Uint64(sizeBuf))
 
 	protoBuf := make([]byte, protobufSize)
-	err = d.client.DownloadBlockBuffer(ctx, 8, protobufSize, protoBuf)
+	report.RemoteIONanos.Stopwatch(func() {
+		err = d.client.DownloadBlockBuffer(ctx, 8, protobufSize, protoBuf)
+	})
 	if err != nil {
-		return nil, 0, fmt.Errorf("download header buffer: %w", err)
+		return nil, fmt.Errorf("download header buffer: %w", err)
 	}
+	report.DownloadedBytes.Add(8 + protobufSize)
+
+	return protoBuf, nil
+}
 
-	header = &v1.ActionsCache{}
-	if err = proto.Unmarshal(protoBuf, header); err != nil {
-		return nil, 0, fmt.Errorf("unmarshal header: %w", err)
+// decodeHeader decrypts, decodes and verifies the header of the blob, setting d.rejected when it is not trusted.
+func (d *Downloader) decodeHeader(protoBuf []byte) (header *v1.ActionsCache, err error) {
+	if d.cipher != nil {
+		protoBuf, err = d.cipher.OpenHeader(protoBuf)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt header: %w", err)
+		}
 	}
 
-	return header, 8 + int64(len(protoBuf)), nil
+	header, err = UnmarshalHeader(protoBuf)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	if d.signer != nil {
+		d.rejected = verifySignature(protoBuf, header.GetSignature(), d.signer)
+	}
+
+	return header, nil
 }
 
 func (d *Downloader) GetEntries(context.Context) (metadata map[string]*v1.IndexEntry, err error) {
@@ -92,6 +202,11 @@ func (d *Downloader) GetOutputs(context.Context) (outputs []*v1.ActionsOutput, e
 	return d.header.Outputs, nil
 }
 
+// Admission returns the sketch of the admission kept in the blob, or nil when it has none.
+func (d *Downloader) Admission() *v1.AdmissionSketch {
+	return d.header.GetAdmission()
+}
+
 func (d *Downloader) IsEmpty() bool {
 	return d.header.OutputTotalSize == 0
 }
@@ -110,6 +225,76 @@ func (d *Downloader) GetOutputBlockURL(ctx context.Context) (url string, offset,
 
 const maxChunkSize = 4 * (1 << 20)
 
+const (
+	// chunkDeadline bounds the download of a chunk, which is at most a few MiB.
+	chunkDeadline = 2 * time.Minute
+	// chunkStallTimeout is the time a chunk download may go without receiving any data,
+	// e.g. on a stuck TCP connection.
+	chunkStallTimeout = 30 * time.Second
+)
+
+var (
+	errChunkDeadline = errors.New("chunk download exceeded the deadline")
+	errChunkStalled  = errors.New("chunk download stalled")
+)
+
+// downloadChunk downloads a chunk to w, watching that it makes progress and finishes in time.
+func (d *Downloader) downloadChunk(ctx context.Context, offset, size int64, w io.Writer) error {
+	ctx, cancelDeadline := context.WithTimeoutCause(ctx, chunkDeadline, errChunkDeadline)
+	defer cancelDeadline()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// The timer is reset on every write, so it only fires when nothing is received for chunkStallTimeout.
+	stallTimer := time.AfterFunc(chunkStallTimeout, func() {
+		cancel(errChunkStalled)
+	})
+	defer stallTimer.Stop()
+
+	err := d.client.DownloadBlock(ctx, offset, size, &progressWriter{
+		Writer: w,
+		onProgress: func() {
+			stallTimer.Reset(chunkStallTimeout)
+		},
+	})
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil {
+			return errors.Join(cause, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// progressWriter calls onProgress on every write.
+type progressWriter struct {
+	io.Writer
+	onProgress func()
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.onProgress()
+	return w.Writer.Write(p)
+}
+
+// abortWriter is implemented by the object writers that can discard what was written,
+// so that an object of a failed download is a miss instead of a corrupted hit.
+type abortWriter interface {
+	Abort() error
+}
+
+func (d *Downloader) abortObjects(writers []io.WriteCloser) {
+	for _, w := range writers {
+		if aw, ok := w.(abortWriter); ok {
+			if err := aw.Abort(); err != nil {
+				d.logger.Debugf("abort object writer: %v", err)
+			}
+		}
+	}
+}
+
 // openFileLimit is the maximum number of files that can be opened at the same time.
 // ref: https://github.com/golang/go/issues/46279
 const openFileLimit = 100000
@@ -124,34 +309,65 @@ func (d *Downloader) DownloadAllOutputBlocks(ctx context.Context, objectWriterFu
 		return int(x.Offset - y.Offset)
 	})
 
-	eg := errgroup.Group{}
+	skip := d.modOnlyOutputs()
+	if filtered := d.filteredOutputs(); len(filtered) > 0 {
+		skip = maps.Clone(skip)
+		if skip == nil {
+			skip = make(map[string]struct{}, len(filtered))
+		}
+		for _, output := range outputs {
+			if _, ok := filtered[output.Id]; ok {
+				skip[output.Id] = struct{}{}
+				report.DownloadFiltered.Add(1)
+				report.DownloadFilteredBytes.Add(output.Size)
+			}
+		}
+	}
+
+	chunks := planChunks(outputs, d.headerSize, skip)
+	orderChunks(chunks, d.outputAccessOrders())
 
+	eg := errgroup.Group{}
 	s := semaphore.NewWeighted(openFileLimit)
-	offset := d.headerSize
-	for i := 0; i < len(outputs); {
-		d.logger.Debugf("creating chunk: %d", i)
-		chunkOffset := offset
-		chunkSize := int64(0)
-		chunkWriters := []myio.WriterWithSize{}
+	for n, chunk := range chunks {
+		d.logger.Debugf("creating chunk: %d", n)
+		chunkWriters := []stream.WriterWithSize{}
 		chunkCloseFuncs := []func() error{}
-		for ; i < len(outputs) && chunkSize < maxChunkSize; i++ {
-			output := outputs[i]
-			offset += output.Size
-			chunkSize += output.Size
-
+		chunkObjectWriters := []io.WriteCloser{}
+		// batch buffers the writes of the output files of the chunk to write them at once, when it is enabled by the build.
+		var batch *myio.FileBatch
+		if myio.FileBatchEnabled {
+			batch = myio.NewFileBatch()
+		}
+		// batchedWriters are the object writers closed after the batch is flushed.
+		batchedWriters := []io.WriteCloser{}
+		for i, output := range chunk.outputs {
 			d.logger.Debugf("acquiring semaphore(%d): outputID=%s", i, output.Id)
 
 			err := s.Acquire(ctx, 1)
 			if err != nil {
+				d.abortObjects(chunkObjectWriters)
 				return fmt.Errorf("acquire semaphore: %w", err)
 			}
 
 			d.logger.Debugf("creating object writer(%d): outputID=%s", i, output.Id)
 
-			w, err := objectWriterFunc(ctx, outputs[i].Id)
+			w, err := objectWriterFunc(ctx, output.Id)
 			if err != nil {
+				d.abortObjects(chunkObjectWriters)
 				return fmt.Errorf("get object writer: %w", err)
 			}
+			if d.signer != nil {
+				// The content of the outputs is checked against their IDs as it is written,
+				// so the files are written as they are received instead of batched.
+				w = newVerifiedObjectWriter(d.logger, w, output.Id)
+			}
+			chunkObjectWriters = append(chunkObjectWriters, w)
+			if fw, ok := w.(myio.FileWriter); ok && batch != nil && fw.File() != nil {
+				batchedWriters = append(batchedWriters, w)
+				w = batch.Add(fw.File())
+			}
+			w = audit.NewRecordingWriter(w, output.Id)
 			chunkCloseFuncs = append(chunkCloseFuncs, w.Close)
 
 			switch output.Compression {
@@ -165,34 +381,72 @@ func (d *Downloader) DownloadAllOutputBlocks(ctx context.Context, objectWriterFu
 				d.logger.Debugf("creating raw writer(%d): outputID=%s", i, output.Id)
 			}
 
-			chunkWriters = append(chunkWriters, myio.WriterWithSize{
+			if d.cipher != nil && output.Size > 0 {
+				w = d.cipher.NewDecryptWriter(w, []byte(output.Id))
+				chunkCloseFuncs = append(chunkCloseFuncs, w.Close)
+			}
+
+			chunkWriters = append(chunkWriters, stream.WriterWithSize{
 				Writer: w,
-				Size:   outputs[i].Size,
+				Size:   output.Size,
 			})
 		}
 
 		slices.Reverse(chunkCloseFuncs)
-		j := i
+
+		if err := acquireTransfer(ctx); err != nil {
+			d.abortObjects(chunkObjectWriters)
+			return fmt.Errorf("acquire transfer: %w", err)
+		}
+
 		eg.Go(func() error {
 			defer s.Release(int64(len(chunkWriters)))
-			defer func() {
-				// io.WriteCloser is expected to be already Closed in JoindWriter.
-				// However, in order to avoid deadlock in the event that an error occurs during the process and Close is not performed, Close is performed by defer without fail.
+			defer transfers.release()
+			// io.WriteCloser is expected to be already Closed in JoindWriter.
+			// However, in order to avoid deadlock in the event that an error occurs during the process and Close is not performed, Close is performed by defer without fail.
+			closeChunk := sync.OnceFunc(func() {
 				for _, closeFunc := range chunkCloseFuncs {
 					if err := closeFunc(); err != nil {
 						d.logger.Debugf("close object writer: %v", err)
 					}
 				}
-			}()
+			})
+			defer closeChunk()
 
-			jw := myio.NewJoinedWriter(chunkWriters...)
+			jw := stream.NewJoinedWriter(chunkWriters...)
 
-			d.logger.Debugf("downloading chunk: %d/%d", j, len(outputs))
-			if err := d.client.DownloadBlock(ctx, chunkOffset, chunkSize, jw); err != nil {
+			d.logger.Debugf("downloading chunk: %d/%d", n, len(chunks))
+			var err error
+			start := time.Now()
+			report.RemoteIONanos.Stopwatch(func() {
+				err = d.downloadChunk(ctx, chunk.offset, chunk.size, jw)
+			})
+			if err != nil {
+				// Only this chunk is given up. Its outputs become misses, which the go command rebuilds.
+				d.abortObjects(chunkObjectWriters)
+				if errors.Is(err, errChunkStalled) || errors.Is(err, errChunkDeadline) {
+					report.AddEvent("download", fmt.Sprintf("%v: %d outputs are treated as misses", err, len(chunkObjectWriters)))
+				}
 				return fmt.Errorf("download block: %w", err)
 			}
+			if batch != nil {
+				// Closing the writers writes what they hold back, e.g. the last segment to decrypt, so they are closed before the flush.
+				closeChunk()
+				if err := batch.Flush(); err != nil {
+					d.abortObjects(chunkObjectWriters)
+					return fmt.Errorf("flush chunk files: %w", err)
+				}
+				for _, w := range batchedWriters {
+					if err := w.Close(); err != nil {
+						d.logger.Debugf("close object writer: %v", err)
+					}
+				}
+			}
+			report.DownloadedBytes.Add(chunk.size)
+			probeBandwidth(d.logger, chunk.size, time.Since(start))
+			d.restored.Add(int64(len(chunkObjectWriters)))
 
-			d.logger.Debugf("downloaded chunk: %d/%d", j, len(outputs))
+			d.logger.Debugf("downloaded chunk: %d/%d", n, len(chunks))
 
 			return nil
 		})
@@ -206,3 +460,112 @@ func (d *Downloader) DownloadAllOutputBlocks(ctx context.Context, objectWriterFu
 
 	return nil
 }
+
+// outputChunk is a run of adjacent outputs downloaded in one request.
+type outputChunk struct {
+	// offset is the offset of the chunk in the blob.
+	offset  int64
+	size    int64
+	outputs []*v1.ActionsOutput
+	// priority is the earliest access order of the outputs, set by orderChunks.
+	priority int64
+}
+
+// planChunks splits the outputs sorted by offset into chunks of about maxChunkSize.
+// The outputs in skip are not restored, and split the chunks.
+func planChunks(outputs []*v1.ActionsOutput, headerSize int64, skip map[string]struct{}) []outputChunk {
+	var chunks []outputChunk
+	offset := headerSize
+	for i := 0; i < len(outputs); {
+		// The outputs only of the module cache are restored to the module cache instead of the local disk,
+		// and the ones left out by the download filter are only fetched on demand.
+		if _, ok := skip[outputs[i].Id]; ok {
+			offset += outputs[i].Size
+			i++
+			continue
+		}
+
+		chunk := outputChunk{offset: offset}
+		for ; i < len(outputs) && chunk.size < maxChunkSize; i++ {
+			if _, ok := skip[outputs[i].Id]; ok {
+				break
+			}
+			offset += outputs[i].Size
+			chunk.size += outputs[i].Size
+			chunk.outputs = append(chunk.outputs, outputs[i])
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// filteredOutputs returns the outputs left out of the restoration by the download filter,
+// the ones no entry of which the filter allows.
+func (d *Downloader) filteredOutputs() map[string]struct{} {
+	d.filteredOnce.Do(func() {
+		filter := policy.CurrentDownload()
+		if filter == nil {
+			return
+		}
+
+		allowed := map[string]bool{}
+		for actionID, entry := range d.header.Entries {
+			if allowed[entry.OutputId] {
+				continue
+			}
+			allowed[entry.OutputId] = filter.Allow(policy.DownloadMetaData{
+				ActionID: actionID,
+				OutputID: entry.OutputId,
+				Size:     entry.Size,
+				Kind:     policy.Kind(entry.Kind),
+			})
+		}
+
+		d.filtered = map[string]struct{}{}
+		for outputID, ok := range allowed {
+			if !ok {
+				d.filtered[outputID] = struct{}{}
+			}
+		}
+	})
+
+	return d.filtered
+}
+
+// outputAccessOrders returns the earliest access order of the entries of each output, by the output IDs.
+// The outputs of entries never looked up are not in it.
+func (d *Downloader) outputAccessOrders() map[string]int64 {
+	orders := map[string]int64{}
+	for _, entry := range d.header.Entries {
+		if entry.AccessOrder <= 0 {
+			continue
+		}
+		if order, ok := orders[entry.OutputId]; !ok || entry.AccessOrder < order {
+			orders[entry.OutputId] = entry.AccessOrder
+		}
+	}
+
+	return orders
+}
+
+// orderChunks sorts the chunks by the earliest access order of their outputs in the last runs,
+// so that the outputs the first steps of the build look up are restored first.
+// The chunks without an output looked up keep their order in the blob after the others.
+func orderChunks(chunks []outputChunk, orders map[string]int64) {
+	if len(orders) == 0 {
+		return
+	}
+
+	for i := range chunks {
+		chunks[i].priority = math.MaxInt64
+		for _, output := range chunks[i].outputs {
+			if order, ok := orders[output.Id]; ok {
+				chunks[i].priority = min(chunks[i].priority, order)
+			}
+		}
+	}
+	slices.SortStableFunc(chunks, func(x, y outputChunk) int {
+		return cmp.Compare(x.priority, y.priority)
+	})
+}
@@ -205,7 +205,7 @@ func TestNewDownloader(t *testing.T) {
 
 			_ = tt.setupMock(client, header)
 
-			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client)
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, nil, nil)
 			if tt.expectError {
 				if err == nil {
 					t.Error("expected error, got nil")
@@ -304,7 +304,7 @@ func TestDownloader_GetEntries(t *testing.T) {
 			client.expectDownloadBlockBuffer(0, 8, sizeBuf, nil)
 			client.expectDownloadBlockBuffer(8, int64(len(headerBytes)), headerBytes, nil)
 
-			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client)
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, nil, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -388,7 +388,7 @@ func TestDownloader_GetOutputBlockURL(t *testing.T) {
 				tt.setupMock(client)
 			}
 
-			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client)
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, nil, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -419,7 +419,8 @@ func TestDownloader_GetOutputBlockURL(t *testing.T) {
 
 type mockWriteCloser struct {
 	bytes.Buffer
-	closed bool
+	closed  bool
+	aborted bool
 }
 
 func (m *mockWriteCloser) Close() error {
@@ -427,6 +428,11 @@ func (m *mockWriteCloser) Close() error {
 	return nil
 }
 
+func (m *mockWriteCloser) Abort() error {
+	m.aborted = true
+	return nil
+}
+
 func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 	t.Parallel()
 
@@ -437,12 +443,13 @@ func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 	}
 
 	tests := []struct {
-		name        string
-		header      *v1.ActionsCache
-		setupMock   func(*mockDownloadClient, int64) error
-		writerError bool
-		expectData  map[string][]byte
-		expectError bool
+		name          string
+		header        *v1.ActionsCache
+		setupMock     func(*mockDownloadClient, int64) error
+		writerError   bool
+		expectData    map[string][]byte
+		expectError   bool
+		expectAborted []string
 	}{
 		{
 			name: "success with single output",
@@ -571,7 +578,35 @@ func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 				client.expectDownloadBlock(headerSize, int64(10), nil, errors.New("download error"))
 				return nil
 			},
-			expectError: true,
+			expectError:   true,
+			expectAborted: []string{"test"},
+		},
+		{
+			name: "outputs only of the module cache are skipped",
+			header: &v1.ActionsCache{
+				Entries: map[string]*v1.IndexEntry{
+					"action": {OutputId: "shared", Size: 4},
+				},
+				Outputs: []*v1.ActionsOutput{
+					{Id: "build", Offset: 0, Size: 5},
+					{Id: "mod", Offset: 5, Size: 3},
+					{Id: "shared", Offset: 8, Size: 4},
+				},
+				OutputTotalSize: 12,
+				ModCache: &v1.ModCache{Files: map[string]*v1.ModFile{
+					"cache/download/example.com/a/@v/v1.0.0.zip": {OutputId: "mod", Size: 3},
+					"cache/download/example.com/a/@v/v1.0.0.mod": {OutputId: "shared", Size: 4},
+				}},
+			},
+			setupMock: func(client *mockDownloadClient, headerSize int64) error {
+				client.expectDownloadBlock(headerSize, 5, []byte("build"), nil)
+				client.expectDownloadBlock(headerSize+8, 4, []byte("same"), nil)
+				return nil
+			},
+			expectData: map[string][]byte{
+				"build":  []byte("build"),
+				"shared": []byte("same"),
+			},
 		},
 		{
 			name: "empty outputs",
@@ -608,7 +643,7 @@ func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 				}
 			}
 
-			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client)
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, nil, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -627,6 +662,12 @@ func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 				if err == nil {
 					t.Error("expected error, got nil")
 				}
+				// The outputs of a failed chunk must be misses, not truncated hits.
+				for _, id := range tt.expectAborted {
+					if w, ok := writers[id]; !ok || !w.aborted {
+						t.Errorf("writer for %s not aborted", id)
+					}
+				}
 				return
 			}
 			if err != nil {
@@ -661,3 +702,55 @@ func TestDownloader_DownloadAllOutputBlocks(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderChunks(t *testing.T) {
+	t.Parallel()
+
+	outputs := []*v1.ActionsOutput{
+		{Id: "a", Offset: 0, Size: maxChunkSize},
+		{Id: "b", Offset: maxChunkSize, Size: maxChunkSize},
+		{Id: "c", Offset: 2 * maxChunkSize, Size: maxChunkSize},
+		{Id: "d", Offset: 3 * maxChunkSize, Size: maxChunkSize},
+	}
+
+	tests := []struct {
+		name   string
+		orders map[string]int64
+		expect []string
+	}{
+		{
+			name:   "no access order keeps the blob order",
+			expect: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:   "looked up outputs first",
+			orders: map[string]int64{"c": 1, "a": 2},
+			expect: []string{"c", "a", "b", "d"},
+		},
+		{
+			name:   "every output looked up",
+			orders: map[string]int64{"a": 4, "b": 3, "c": 2, "d": 1},
+			expect: []string{"d", "c", "b", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			chunks := planChunks(outputs, 8, nil)
+			orderChunks(chunks, tt.orders)
+
+			var actual []string
+			for _, chunk := range chunks {
+				if chunk.offset != 8+chunk.outputs[0].Offset {
+					t.Errorf("chunk of %s at %d, want %d", chunk.outputs[0].Id, chunk.offset, 8+chunk.outputs[0].Offset)
+				}
+				actual = append(actual, chunk.outputs[0].Id)
+			}
+			if diff := cmp.Diff(tt.expect, actual); diff != "" {
+				t.Errorf("chunk order mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// entriesFieldNumber and outputsFieldNumber are the field numbers of ActionsCache.entries and ActionsCache.outputs.
+	entriesFieldNumber protowire.Number = 1
+	outputsFieldNumber protowire.Number = 2
+	// mapKeyFieldNumber and mapValueFieldNumber are the field numbers of the key and the value of a map entry.
+	mapKeyFieldNumber   protowire.Number = 1
+	mapValueFieldNumber protowire.Number = 2
+	// minHeaderShardSize is the number of entries and outputs below which a shard is not worth a goroutine.
+	minHeaderShardSize = 4096
+)
+
+// UnmarshalHeader decodes the header of a blob, like proto.Unmarshal into an ActionsCache.
+// Headers of large repositories have hundreds of thousands of entries, so the entries and the outputs are decoded in parallel,
+// into slabs instead of one allocation a message.
+// The output IDs, repeated by the entries and the outputs, are interned to keep one copy of each.
+func UnmarshalHeader(buf []byte) (*v1.ActionsCache, error) {
+	var entryFields, outputFields [][]byte
+	// rest are the other fields, kept in their order so that proto.Unmarshal merges them as usual.
+	var rest []byte
+	for b := buf; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("consume tag: %w", protowire.ParseError(n))
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return nil, fmt.Errorf("consume field %d: %w", num, protowire.ParseError(m))
+		}
+
+		switch {
+		case num == entriesFieldNumber && typ == protowire.BytesType:
+			value, _ := protowire.ConsumeBytes(b[n:])
+			entryFields = append(entryFields, value)
+		case num == outputsFieldNumber && typ == protowire.BytesType:
+			value, _ := protowire.ConsumeBytes(b[n:])
+			outputFields = append(outputFields, value)
+		default:
+			rest = append(rest, b[:n+m]...)
+		}
+		b = b[n+m:]
+	}
+
+	header := &v1.ActionsCache{}
+	if err := proto.Unmarshal(rest, header); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(entryFields))
+	entries := make([]v1.IndexEntry, len(entryFields))
+	outputs := make([]v1.ActionsOutput, len(outputFields))
+
+	shardSize := max(minHeaderShardSize, max(len(entryFields), len(outputFields))/runtime.GOMAXPROCS(0)+1)
+	// The outputs are decoded first, so that the entries can share the IDs of them.
+	if err := decodeShards(len(outputFields), shardSize, func(i int) error {
+		if err := proto.Unmarshal(outputFields[i], &outputs[i]); err != nil {
+			return fmt.Errorf("unmarshal output: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	outputIDs := make(map[string]string, len(outputs))
+	for i := range outputs {
+		outputIDs[outputs[i].Id] = outputs[i].Id
+	}
+
+	if err := decodeShards(len(entryFields), shardSize, func(i int) error {
+		if err := unmarshalEntry(entryFields[i], &keys[i], &entries[i]); err != nil {
+			return fmt.Errorf("unmarshal entry: %w", err)
+		}
+		// Entries of inline outputs have no output in the blob, and keep their own IDs.
+		if id, ok := outputIDs[entries[i].OutputId]; ok {
+			entries[i].OutputId = id
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		header.Entries = make(map[string]*v1.IndexEntry, len(entries))
+		// Later entries win over earlier ones with the same key, as in proto.Unmarshal.
+		for i := range entries {
+			header.Entries[keys[i]] = &entries[i]
+		}
+	}
+	if len(outputs) > 0 {
+		header.Outputs = make([]*v1.ActionsOutput, len(outputs))
+		for i := range outputs {
+			header.Outputs[i] = &outputs[i]
+		}
+	}
+
+	return header, nil
+}
+
+// unmarshalEntry decodes an entry of the map of entries.
+func unmarshalEntry(b []byte, key *string, entry *v1.IndexEntry) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == mapKeyFieldNumber && typ == protowire.BytesType:
+			value, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			*key = string(value)
+			b = b[m:]
+		case num == mapValueFieldNumber && typ == protowire.BytesType:
+			value, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(value, entry); err != nil {
+				return err
+			}
+			b = b[m:]
+		default:
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			b = b[m:]
+		}
+	}
+
+	return nil
+}
+
+// decodeShards calls decode for 0 to n-1, in shards of shardSize decoded in parallel.
+func decodeShards(n, shardSize int, decode func(i int) error) error {
+	eg := errgroup.Group{}
+	for start := 0; start < n; start += shardSize {
+		end := min(start+shardSize, n)
+		eg.Go(func() error {
+			for i := start; i < end; i++ {
+				if err := decode(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
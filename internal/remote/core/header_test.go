@@ -0,0 +1,136 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testHeader returns a header with n entries and outputs.
+func testHeader(n int) *v1.ActionsCache {
+	header := &v1.ActionsCache{
+		Entries:   make(map[string]*v1.IndexEntry, n),
+		Outputs:   make([]*v1.ActionsOutput, 0, n),
+		Signature: []byte("signature"),
+		ModCache: &v1.ModCache{Files: map[string]*v1.ModFile{
+			"cache/download/example.com/m/@v/v1.0.0.zip": {OutputId: "mod", Size: 10},
+		}},
+	}
+	for i := range n {
+		outputID := fmt.Sprintf("output-%08d", i)
+		header.Entries[fmt.Sprintf("action-%08d", i)] = &v1.IndexEntry{
+			OutputId:   outputID,
+			Size:       int64(i),
+			Timenano:   int64(i) * 1000,
+			LastUsedAt: timestamppb.New(timestamppb.Now().AsTime()),
+		}
+		header.Outputs = append(header.Outputs, &v1.ActionsOutput{
+			Offset:      int64(i) * 100,
+			Size:        100,
+			Compression: v1.Compression_COMPRESSION_ZSTD,
+			Id:          outputID,
+		})
+		header.OutputTotalSize += 100
+	}
+
+	return header
+}
+
+func TestUnmarshalHeader(t *testing.T) {
+	t.Parallel()
+
+	duplicated, err := proto.Marshal(testHeader(3))
+	if err != nil {
+		t.Fatalf("failed to marshal header: %v", err)
+	}
+	// A repeated key of the map and a repeated scalar field are overridden by the later ones.
+	later, err := proto.Marshal(&v1.ActionsCache{
+		Entries:         map[string]*v1.IndexEntry{"action-00000001": {OutputId: "output-00000002", Size: 2}},
+		OutputTotalSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal header: %v", err)
+	}
+	duplicated = append(duplicated, later...)
+
+	tests := []struct {
+		name    string
+		entries int
+		raw     []byte
+	}{
+		{name: "empty", entries: 0},
+		{name: "small", entries: 10},
+		{name: "sharded", entries: 3*minHeaderShardSize + 1},
+		{name: "duplicated", raw: duplicated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw := tt.raw
+			if raw == nil {
+				var err error
+				raw, err = proto.Marshal(testHeader(tt.entries))
+				if err != nil {
+					t.Fatalf("failed to marshal header: %v", err)
+				}
+			}
+
+			want := &v1.ActionsCache{}
+			if err := proto.Unmarshal(raw, want); err != nil {
+				t.Fatalf("failed to unmarshal header: %v", err)
+			}
+			got, err := UnmarshalHeader(raw)
+			if err != nil {
+				t.Fatalf("failed to unmarshal header: %v", err)
+			}
+			if !proto.Equal(got, want) {
+				t.Error("header differs from proto.Unmarshal")
+			}
+		})
+	}
+}
+
+func TestUnmarshalHeader_invalid(t *testing.T) {
+	t.Parallel()
+
+	raw, err := proto.Marshal(testHeader(10))
+	if err != nil {
+		t.Fatalf("failed to marshal header: %v", err)
+	}
+
+	if _, err := UnmarshalHeader(raw[:len(raw)-1]); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
+
+// BenchmarkUnmarshalHeader compares UnmarshalHeader with proto.Unmarshal on the header of a large repository:
+//
+//	go test -bench UnmarshalHeader -benchmem ./internal/remote/core
+func BenchmarkUnmarshalHeader(b *testing.B) {
+	raw, err := proto.Marshal(testHeader(200_000))
+	if err != nil {
+		b.Fatalf("failed to marshal header: %v", err)
+	}
+
+	b.Run("proto", func(b *testing.B) {
+		b.SetBytes(int64(len(raw)))
+		for b.Loop() {
+			if err := proto.Unmarshal(raw, &v1.ActionsCache{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.SetBytes(int64(len(raw)))
+		for b.Loop() {
+			if _, err := UnmarshalHeader(raw); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/osfile"
+)
+
+// maxCachedHeaders is the number of headers kept in the header cache, the ones read last.
+// Shards of a matrix and restore keys make a run read a few blobs.
+const maxCachedHeaders = 4
+
+var headerCacheDir atomic.Pointer[string]
+
+// SetHeaderCacheDir sets the directory keeping the headers of the blobs read, by their versions.
+// A blob whose version is unchanged since an earlier run is started from the kept header instead of downloading it again.
+// "" disables it.
+func SetHeaderCacheDir(dir string) {
+	headerCacheDir.Store(&dir)
+}
+
+// currentHeaderCacheDir returns the directory of the header cache, or "" when it is disabled.
+func currentHeaderCacheDir() string {
+	if dir := headerCacheDir.Load(); dir != nil {
+		return *dir
+	}
+
+	return ""
+}
+
+// headerCachePath returns the path of the kept header of the blob of the version, or "" when the header cache is disabled.
+func headerCachePath(etag string) string {
+	dir := currentHeaderCacheDir()
+	if dir == "" || etag == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(etag))
+	return filepath.Join(dir, hex.EncodeToString(sum[:]))
+}
+
+// blobETag returns the version of the blob when the storage gives one and the header cache is enabled, or else "".
+func (d *Downloader) blobETag(ctx context.Context) string {
+	if currentHeaderCacheDir() == "" {
+		return ""
+	}
+
+	etag, err := d.ETag(ctx)
+	if err != nil {
+		d.logger.Debugf("get version of the blob: %v", err)
+		return ""
+	}
+
+	return etag
+}
+
+// loadHeader returns the header kept for the version of the blob, still encrypted as in the blob.
+// It reports false when none is kept.
+func loadHeader(etag string) ([]byte, bool) {
+	path := headerCachePath(etag)
+	if path == "" {
+		return nil, false
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	// The mtime orders the headers by their last read, for pruneHeaders.
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return buf, true
+}
+
+// storeHeader keeps the header of the version of the blob, and removes the headers read least recently past maxCachedHeaders.
+func storeHeader(etag string, buf []byte) error {
+	path := headerCachePath(etag)
+	if path == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	// The header is renamed into place once written, so that a header cut by a crash is never read.
+	f, err := osfile.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	_, err = f.Write(buf)
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = osfile.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = osfile.Remove(f.Name())
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	return pruneHeaders(dir)
+}
+
+// pruneHeaders removes the headers of dir read least recently past maxCachedHeaders.
+func pruneHeaders(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+
+	type header struct {
+		name    string
+		modTime time.Time
+	}
+	headers := make([]header, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.Type().IsRegular() {
+			continue
+		}
+		headers = append(headers, header{entry.Name(), info.ModTime()})
+	}
+	if len(headers) <= maxCachedHeaders {
+		return nil
+	}
+
+	slices.SortFunc(headers, func(x, y header) int {
+		return cmp.Compare(y.modTime.UnixNano(), x.modTime.UnixNano())
+	})
+	for _, h := range headers[maxCachedHeaders:] {
+		if err := osfile.Remove(filepath.Join(dir, h.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove header: %w", err)
+		}
+	}
+
+	return nil
+}
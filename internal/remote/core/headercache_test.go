@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// etagBlobClient is a blobClient of a storage giving the version of the blob, counting the buffers downloaded.
+type etagBlobClient struct {
+	blobClient
+	etag    string
+	buffers int
+}
+
+func (c *etagBlobClient) ETag(context.Context) (string, error) {
+	return c.etag, nil
+}
+
+func (c *etagBlobClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	c.buffers++
+	return c.blobClient.DownloadBlockBuffer(ctx, offset, size, buf)
+}
+
+func testBlob(t *testing.T, header *v1.ActionsCache) []byte {
+	t.Helper()
+
+	protoBuf, err := proto.Marshal(header)
+	if err != nil {
+		t.Fatalf("failed to marshal header: %v", err)
+	}
+
+	return append(binary.BigEndian.AppendUint64(nil, uint64(len(protoBuf))), protoBuf...)
+}
+
+func TestDownloader_headerCache(t *testing.T) {
+	// Not parallel, as the header cache directory is global.
+	SetHeaderCacheDir(t.TempDir())
+	defer SetHeaderCacheDir("")
+
+	client := &etagBlobClient{blobClient: blobClient{blob: testBlob(t, testHeader(3))}, etag: `"1"`}
+
+	tests := []struct {
+		name        string
+		setup       func()
+		wantBuffers int
+		wantEntries int
+	}{
+		{name: "first run", wantBuffers: 2, wantEntries: 3},
+		{name: "unchanged blob", wantBuffers: 0, wantEntries: 3},
+		{
+			name: "new version",
+			setup: func() {
+				client.blob = testBlob(t, testHeader(5))
+				client.etag = `"2"`
+			},
+			wantBuffers: 2,
+			wantEntries: 5,
+		},
+		{
+			name: "corrupted kept header",
+			setup: func() {
+				if err := os.WriteFile(headerCachePath(client.etag), []byte{0xff}, 0o644); err != nil {
+					t.Fatalf("failed to write header: %v", err)
+				}
+			},
+			wantBuffers: 2,
+			wantEntries: 5,
+		},
+		{
+			name:        "storage without versions",
+			setup:       func() { client.etag = "" },
+			wantBuffers: 2,
+			wantEntries: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			client.buffers = 0
+
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to create downloader: %v", err)
+			}
+			if client.buffers != tt.wantBuffers {
+				t.Errorf("downloaded buffers: got %d, want %d", client.buffers, tt.wantBuffers)
+			}
+			if got := len(downloader.header.Entries); got != tt.wantEntries {
+				t.Errorf("entries: got %d, want %d", got, tt.wantEntries)
+			}
+			if want := int64(len(client.blob)); downloader.headerSize != want {
+				t.Errorf("header size: got %d, want %d", downloader.headerSize, want)
+			}
+		})
+	}
+}
+
+func TestStoreHeader_prune(t *testing.T) {
+	// Not parallel, as the header cache directory is global.
+	dir := t.TempDir()
+	SetHeaderCacheDir(dir)
+	defer SetHeaderCacheDir("")
+
+	for i := range maxCachedHeaders + 2 {
+		if err := storeHeader(strconv.Itoa(i), []byte("header")); err != nil {
+			t.Fatalf("failed to store header: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if len(entries) != maxCachedHeaders {
+		t.Errorf("kept headers: got %d, want %d", len(entries), maxCachedHeaders)
+	}
+}
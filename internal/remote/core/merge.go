@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// ShardedUploadClient is an UploadClient whose blob is one shard of the cache entry shared by several jobs,
+// e.g. the jobs of a matrix building the same commit.
+type ShardedUploadClient interface {
+	UploadClient
+	// Merger is called after the shard is committed.
+	// It returns the client of the merged entry and the clients of every shard
+	// when this job is elected to merge them, and a nil client otherwise.
+	Merger(ctx context.Context) (UploadClient, []DownloadClient, error)
+}
+
+// mergeShards merges the shards into one blob when this job is elected to do it.
+// The outputs are copied on the storage side, so nothing is downloaded except the headers.
+func (u *Uploader) mergeShards(ctx context.Context, client ShardedUploadClient) error {
+	mergeClient, shardClients, err := client.Merger(ctx)
+	if err != nil {
+		return fmt.Errorf("elect merger: %w", err)
+	}
+	if mergeClient == nil {
+		u.logger.Infof("another job merges the shards of the cache entry.")
+		return nil
+	}
+
+	var (
+		entries    = map[string]*v1.IndexEntry{}
+		modFiles   = map[string]*v1.ModFile{}
+		outputs    []*v1.ActionsOutput
+		outputSize int64
+		seen       = map[string]struct{}{}
+		blockIDs   []string
+	)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i, shardClient := range shardClients {
+		shard, err := NewDownloader(ctx, u.logger, shardClient, u.cipher, u.signer)
+		if err != nil {
+			return fmt.Errorf("read shard %d: %w", i, err)
+		}
+		if shard.Rejected() != nil {
+			continue
+		}
+
+		shardEntries, err := shard.GetEntries(ctx)
+		if err != nil {
+			return fmt.Errorf("get entries of shard %d: %w", i, err)
+		}
+		for actionID, entry := range shardEntries {
+			if _, ok := entries[actionID]; !ok {
+				entries[actionID] = entry
+			}
+		}
+		// The history of the admission is taken from the first shard having one.
+		if u.admission == nil {
+			u.admission = shard.Admission()
+		}
+		for path, file := range shard.ModCache().GetFiles() {
+			if _, ok := modFiles[path]; !ok {
+				modFiles[path] = file
+			}
+		}
+
+		if shard.IsEmpty() {
+			continue
+		}
+
+		shardOutputs, err := shard.GetOutputs(ctx)
+		if err != nil {
+			return fmt.Errorf("get outputs of shard %d: %w", i, err)
+		}
+
+		url, offset, _, err := shard.GetOutputBlockURL(ctx)
+		if err != nil {
+			return fmt.Errorf("get output block URL of shard %d: %w", i, err)
+		}
+
+		ranges, newOutputs, size := planShardCopy(shardOutputs, seen, outputSize)
+		outputs = append(outputs, newOutputs...)
+		outputSize += size
+
+		var copySize int64
+		for _, r := range ranges {
+			copySize += r.size
+		}
+		// The shards share the block limit of the merged blob, so each is sized as if all of them were as large.
+		chunkSize := copyBlockSize(copySize * int64(len(shardClients)))
+		for _, r := range ranges {
+			for chunkOffset := r.offset; chunkOffset < r.offset+r.size; chunkOffset += chunkSize {
+				blockID, err := u.generateBlockID()
+				if err != nil {
+					return fmt.Errorf("generate block ID: %w", err)
+				}
+				blockIDs = append(blockIDs, blockID)
+
+				copyChunkSize := min(chunkSize, r.offset+r.size-chunkOffset)
+				eg.Go(func() error {
+					if err := mergeClient.UploadBlockFromURL(egCtx, blockID, url, offset+chunkOffset, copyChunkSize); err != nil {
+						return fmt.Errorf("upload block from URL: %w", err)
+					}
+
+					return nil
+				})
+			}
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		return fmt.Errorf("copy outputs: %w", err)
+	}
+
+	entries = u.filterEntries(entries, outputs)
+	modCache := u.filterModCache(&v1.ModCache{Files: modFiles}, outputs)
+
+	headerBuf, err := u.createHeader(entries, modCache, outputs, outputSize)
+	if err != nil {
+		return fmt.Errorf("create header: %w", err)
+	}
+
+	headerBlockID, err := u.generateBlockID()
+	if err != nil {
+		return fmt.Errorf("generate header block ID: %w", err)
+	}
+
+	report.RemoteIONanos.Stopwatch(func() {
+		_, err = mergeClient.UploadBlock(ctx, headerBlockID, myio.NopSeekCloser(bytes.NewReader(headerBuf)))
+	})
+	if err != nil {
+		return fmt.Errorf("upload header: %w", err)
+	}
+	report.UploadedBytes.Add(int64(len(headerBuf)))
+	report.UploadedRawBytes.Add(int64(len(headerBuf)))
+
+	report.RemoteIONanos.Stopwatch(func() {
+		err = mergeClient.Commit(ctx, append([]string{headerBlockID}, blockIDs...), int64(len(headerBuf))+outputSize)
+	})
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	u.logger.Infof("merged %d shards into the cache entry: entries=%d, outputs=%d", len(shardClients), len(entries), len(outputs))
+
+	return nil
+}
+
+// copyRange is a range of the output region of a shard to copy.
+type copyRange struct {
+	offset int64
+	size   int64
+}
+
+// planShardCopy picks the outputs of a shard not seen in the previous shards,
+// and returns the ranges of the shard to copy and the picked outputs placed from mergedOffset.
+// Adjacent outputs are copied as one range, so the shards sharing the base blob are mostly copied in a few ranges.
+func planShardCopy(shardOutputs []*v1.ActionsOutput, seen map[string]struct{}, mergedOffset int64) ([]copyRange, []*v1.ActionsOutput, int64) {
+	shardOutputs = slices.Clone(shardOutputs)
+	slices.SortFunc(shardOutputs, func(x, y *v1.ActionsOutput) int {
+		return int(x.Offset - y.Offset)
+	})
+
+	var (
+		ranges     []copyRange
+		newOutputs []*v1.ActionsOutput
+		size       int64
+		current    *copyRange
+	)
+	for _, output := range shardOutputs {
+		if _, ok := seen[output.Id]; ok {
+			continue
+		}
+		seen[output.Id] = struct{}{}
+
+		if current == nil || current.offset+current.size != output.Offset {
+			ranges = append(ranges, copyRange{offset: output.Offset})
+			current = &ranges[len(ranges)-1]
+		}
+		current.size += output.Size
+
+		newOutputs = append(newOutputs, &v1.ActionsOutput{
+			Id:          output.Id,
+			Offset:      mergedOffset + size,
+			Size:        output.Size,
+			Compression: output.Compression,
+		})
+		size += output.Size
+	}
+
+	return slices.DeleteFunc(ranges, func(r copyRange) bool { return r.size == 0 }), newOutputs, size
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"slices"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+func TestPlanShardCopy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		outputs      []*v1.ActionsOutput
+		seen         []string
+		mergedOffset int64
+		wantRanges   []copyRange
+		wantOffsets  map[string]int64
+		wantSize     int64
+	}{
+		{
+			name: "first shard",
+			outputs: []*v1.ActionsOutput{
+				{Id: "b", Offset: 10, Size: 5},
+				{Id: "a", Offset: 0, Size: 10},
+			},
+			wantRanges:  []copyRange{{offset: 0, size: 15}},
+			wantOffsets: map[string]int64{"a": 0, "b": 10},
+			wantSize:    15,
+		},
+		{
+			name: "shared base",
+			outputs: []*v1.ActionsOutput{
+				{Id: "a", Offset: 0, Size: 10},
+				{Id: "b", Offset: 10, Size: 5},
+				{Id: "c", Offset: 15, Size: 3},
+				{Id: "d", Offset: 18, Size: 2},
+			},
+			seen:         []string{"a", "b"},
+			mergedOffset: 15,
+			wantRanges:   []copyRange{{offset: 15, size: 5}},
+			wantOffsets:  map[string]int64{"c": 15, "d": 18},
+			wantSize:     5,
+		},
+		{
+			name: "gap",
+			outputs: []*v1.ActionsOutput{
+				{Id: "a", Offset: 0, Size: 10},
+				{Id: "b", Offset: 10, Size: 5},
+				{Id: "c", Offset: 15, Size: 3},
+			},
+			seen:         []string{"b"},
+			mergedOffset: 100,
+			wantRanges:   []copyRange{{offset: 0, size: 10}, {offset: 15, size: 3}},
+			wantOffsets:  map[string]int64{"a": 100, "c": 110},
+			wantSize:     13,
+		},
+		{
+			name: "empty output",
+			outputs: []*v1.ActionsOutput{
+				{Id: "a", Offset: 0, Size: 0},
+			},
+			mergedOffset: 7,
+			wantOffsets:  map[string]int64{"a": 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			seen := map[string]struct{}{}
+			for _, id := range tt.seen {
+				seen[id] = struct{}{}
+			}
+
+			ranges, outputs, size := planShardCopy(tt.outputs, seen, tt.mergedOffset)
+			if !slices.Equal(ranges, tt.wantRanges) {
+				t.Errorf("ranges: got %v, want %v", ranges, tt.wantRanges)
+			}
+			if size != tt.wantSize {
+				t.Errorf("size: got %d, want %d", size, tt.wantSize)
+			}
+
+			if len(outputs) != len(tt.wantOffsets) {
+				t.Fatalf("outputs: got %d, want %d", len(outputs), len(tt.wantOffsets))
+			}
+			for _, output := range outputs {
+				if want, ok := tt.wantOffsets[output.Id]; !ok || output.Offset != want {
+					t.Errorf("offset of %s: got %d, want %d", output.Id, output.Offset, want)
+				}
+				if _, ok := seen[output.Id]; !ok {
+					t.Errorf("%s is not marked as seen", output.Id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/log"
+)
+
+// CopyBlob copies the blob read by from to to as is, and commits it there. It returns the size of the blob.
+// The blob is staged like a base blob: in ranges copied by the storage with UploadBlockFromURL when it can,
+// and through the runner otherwise, checked to be of the same version before and after the copy.
+// The header is only read for the size of the blob, so cipher is the key the blob is encrypted with, or nil.
+func CopyBlob(ctx context.Context, logger log.Logger, from DownloadClient, to UploadClient, cipher *crypt.Cipher) (int64, error) {
+	downloader, err := NewDownloader(ctx, logger, from, cipher, nil)
+	if err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+
+	outputSize := downloader.header.GetOutputTotalSize()
+	for _, output := range downloader.header.GetOutputs() {
+		outputSize = max(outputSize, output.GetOffset()+output.GetSize())
+	}
+	size := downloader.headerSize + outputSize
+
+	u := &Uploader{logger: logger, client: to}
+	blockIDs, err := u.copyBase(ctx, downloader, from.GetURL(ctx), 0, size)
+	if err != nil {
+		return 0, fmt.Errorf("copy blob: %w", err)
+	}
+
+	if err := to.Commit(ctx, blockIDs, size); err != nil {
+		return 0, fmt.Errorf("commit blob: %w", err)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+// urlCopyClient stages the ranges of src copied from the URL, and commits the blocks as its blob.
+type urlCopyClient struct {
+	src       []byte
+	locker    sync.Mutex
+	blocks    map[string][]byte
+	committed []byte
+}
+
+func (c *urlCopyClient) UploadBlock(context.Context, string, io.ReadSeekCloser) (int64, error) {
+	return 0, errors.New("unexpected upload of a block")
+}
+
+func (c *urlCopyClient) UploadBlockFromURL(_ context.Context, blockID, _ string, offset, size int64) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.blocks[blockID] = c.src[offset : offset+size]
+
+	return nil
+}
+
+func (c *urlCopyClient) Commit(_ context.Context, blockIDs []string, size int64) error {
+	for _, blockID := range blockIDs {
+		c.committed = append(c.committed, c.blocks[blockID]...)
+	}
+	if int64(len(c.committed)) != size {
+		return errors.New("size mismatch")
+	}
+
+	return nil
+}
+
+func TestCopyBlob(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("output")
+	blob := buildBlob(t,
+		map[string]*v1.IndexEntry{"action": {OutputId: outputIDOf(content), Size: int64(len(content))}},
+		[]*v1.ActionsOutput{{Id: outputIDOf(content), Size: int64(len(content))}},
+		[][]byte{content},
+	)
+
+	to := &urlCopyClient{src: blob, blocks: map[string][]byte{}}
+	size, err := CopyBlob(t.Context(), log.DefaultLogger, &blobClient{blob: blob}, to, nil)
+	if err != nil {
+		t.Fatalf("CopyBlob() error = %v", err)
+	}
+
+	if size != int64(len(blob)) {
+		t.Errorf("CopyBlob() = %d, want %d", size, len(blob))
+	}
+	if !bytes.Equal(to.committed, blob) {
+		t.Errorf("committed blob differs from the source: got %d bytes, want %d", len(to.committed), len(blob))
+	}
+}
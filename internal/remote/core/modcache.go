@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/internal/modcache"
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+)
+
+// ModCache returns the module cache kept in the blob, or nil when it has none.
+func (d *Downloader) ModCache() *v1.ModCache {
+	return d.header.GetModCache()
+}
+
+// modOnlyOutputs returns the outputs of the module cache not used by any entry.
+// They are restored to the module cache instead of the local disk.
+func (d *Downloader) modOnlyOutputs() map[string]struct{} {
+	files := d.header.GetModCache().GetFiles()
+	if len(files) == 0 {
+		return nil
+	}
+
+	outputs := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		outputs[file.OutputId] = struct{}{}
+	}
+	for _, entry := range d.header.Entries {
+		delete(outputs, entry.OutputId)
+	}
+
+	return outputs
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// FetchOutput downloads the output on its own and writes its content to w.
+func (d *Downloader) FetchOutput(ctx context.Context, outputID string, w io.Writer) error {
+	if d.client == nil {
+		return errors.New("no download client")
+	}
+
+	output, ok := d.output(outputID)
+	if !ok {
+		return fmt.Errorf("output %s is not in the blob", outputID)
+	}
+
+	var hasher *outputHasher
+	if d.signer != nil {
+		hasher = newOutputHasher(output.Id)
+		w = io.MultiWriter(w, hasher)
+	}
+	wc := d.decodeWriter(output, w)
+
+	if output.Size > 0 {
+		if err := acquireTransfer(ctx); err != nil {
+			return fmt.Errorf("acquire transfer: %w", err)
+		}
+		start := time.Now()
+		var err error
+		report.RemoteIONanos.Stopwatch(func() {
+			err = d.downloadChunk(ctx, d.headerSize+output.Offset, output.Size, wc)
+		})
+		transfers.release()
+		if err != nil {
+			return fmt.Errorf("download block: %w", err)
+		}
+		report.DownloadedBytes.Add(output.Size)
+		probeBandwidth(d.logger, output.Size, time.Since(start))
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	if hasher != nil {
+		if err := hasher.check(); err != nil {
+			report.RestoreChecksumMismatches.Add(1)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// output returns the output of the blob by its ID.
+func (d *Downloader) output(outputID string) (*v1.ActionsOutput, bool) {
+	d.outputsOnce.Do(func() {
+		d.outputs = make(map[string]*v1.ActionsOutput, len(d.header.Outputs))
+		for _, output := range d.header.Outputs {
+			d.outputs[output.Id] = output
+		}
+	})
+	output, ok := d.outputs[outputID]
+
+	return output, ok
+}
+
+// decodeWriter returns the writer decrypting and decompressing the bytes of the output in the blob to w.
+// Closing it flushes the content to w, but does not close w.
+func (d *Downloader) decodeWriter(output *v1.ActionsOutput, w io.Writer) io.WriteCloser {
+	var wc io.WriteCloser = nopWriteCloser{Writer: w}
+	if output.Compression == v1.Compression_COMPRESSION_ZSTD {
+		wc = zstd.NewDecompressWriter(wc)
+	}
+	if d.cipher != nil && output.Size > 0 {
+		wc = d.cipher.NewDecryptWriter(wc, []byte(output.Id))
+	}
+
+	return wc
+}
+
+// restoreModCache restores the module cache of the blob to the module cache managed.
+func (c *Backend) restoreModCache(ctx context.Context) {
+	dir := modcache.Dir()
+	if dir == "" || len(c.downloader.ModCache().GetFiles()) == 0 {
+		return
+	}
+
+	start := time.Now()
+	restored, err := modcache.Restore(ctx, c.logger, dir, c.downloader.ModCache(), c.downloader.FetchOutput)
+	if err != nil {
+		c.logger.Warnf("failed to restore the module cache: %v", err)
+	}
+	c.logger.Infof("restored %d files of the module cache to %s in %s", restored, dir, time.Since(start))
+}
+
+// snapshotModCache sets the module cache to commit.
+// The files of the module cache managed are uploaded unless they are in the blob,
+// and the module cache of the blob is carried over when it is not managed.
+func (c *Backend) snapshotModCache(ctx context.Context) {
+	if c.uploader.client == nil {
+		return
+	}
+
+	modCache := c.downloader.ModCache()
+	if dir := modcache.Dir(); dir != "" {
+		snapshot, err := modcache.Snapshot(ctx, c.logger, dir, modCache, func(ctx context.Context, outputID string, size int64, r io.ReadSeeker) error {
+			return c.uploader.UploadOutput(ctx, outputID, size, myio.NopSeekCloser(r))
+		})
+		if err != nil {
+			c.logger.Warnf("failed to snapshot the module cache: %v. the module cache of the base is committed instead.", err)
+		} else {
+			modCache = snapshot
+		}
+	}
+
+	c.uploader.SetModCache(modCache)
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// uploadQueue hands the transfers to the uploads of the outputs, the smallest first.
+var uploadQueue = newUploadScheduler(acquireTransfer)
+
+// uploadScheduler orders the uploads waiting for a transfer by their size.
+// The small outputs, which the builds reuse the most, are staged before the giant ones when the transfers are contended,
+// so that as many outputs as possible are in the blob when the commit deadline abandons the uploads left.
+// The header is staged by the commit after every upload, so it is always the last.
+// Only the upload at the head of the queue waits on the transfers, and it gives its place up to a smaller upload coming.
+type uploadScheduler struct {
+	acquire func(ctx context.Context) error
+	locker  sync.Mutex
+	// waiting are the uploads waiting for a transfer, sorted by size and then by arrival.
+	waiting []*uploadWaiter
+	seq     uint64
+	// changed is closed and replaced whenever the head of the queue changes.
+	changed chan struct{}
+}
+
+type uploadWaiter struct {
+	size int64
+	seq  uint64
+}
+
+func newUploadScheduler(acquire func(ctx context.Context) error) *uploadScheduler {
+	return &uploadScheduler{
+		acquire: acquire,
+		changed: make(chan struct{}),
+	}
+}
+
+// errPreempted cancels the wait of the head of the queue for a transfer, when a smaller upload comes before it.
+var errPreempted = errors.New("preempted by a smaller upload")
+
+// acquireUpload waits for a transfer for the upload of size bytes, after the smaller uploads waiting.
+// The transfer is released with transfers.release.
+func (s *uploadScheduler) acquireUpload(ctx context.Context, size int64) error {
+	w := s.enqueue(size)
+	defer s.remove(w)
+
+	for {
+		head, changed := s.head(w)
+		if !head {
+			select {
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			case <-changed:
+			}
+			continue
+		}
+
+		acquireCtx, cancel := context.WithCancelCause(ctx)
+		go func() {
+			select {
+			case <-changed:
+				cancel(errPreempted)
+			case <-acquireCtx.Done():
+			}
+		}()
+		err := s.acquire(acquireCtx)
+		preempted := errors.Is(context.Cause(acquireCtx), errPreempted)
+		cancel(nil)
+		if err == nil || !preempted || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (s *uploadScheduler) enqueue(size int64) *uploadWaiter {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.seq++
+	w := &uploadWaiter{size: size, seq: s.seq}
+	i, _ := slices.BinarySearchFunc(s.waiting, w, compareWaiters)
+	s.waiting = slices.Insert(s.waiting, i, w)
+	if i == 0 {
+		s.notify()
+	}
+
+	return w
+}
+
+func (s *uploadScheduler) remove(w *uploadWaiter) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	i, ok := slices.BinarySearchFunc(s.waiting, w, compareWaiters)
+	if !ok {
+		return
+	}
+	s.waiting = slices.Delete(s.waiting, i, i+1)
+	if i == 0 {
+		s.notify()
+	}
+}
+
+// head reports whether w is at the head of the queue, and returns the channel closed when the head changes.
+func (s *uploadScheduler) head(w *uploadWaiter) (bool, <-chan struct{}) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return s.waiting[0] == w, s.changed
+}
+
+// notify wakes the waiting uploads up. s.locker must be held.
+func (s *uploadScheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func compareWaiters(a, b *uploadWaiter) int {
+	return cmp.Or(cmp.Compare(a.size, b.size), cmp.Compare(a.seq, b.seq))
+}
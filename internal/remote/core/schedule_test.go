@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestUploadScheduler(t *testing.T) {
+	t.Parallel()
+
+	sem := semaphore.NewWeighted(1)
+	scheduler := newUploadScheduler(func(ctx context.Context) error {
+		return sem.Acquire(ctx, 1)
+	})
+
+	// The only transfer is taken, so the uploads queue up.
+	if err := sem.Acquire(t.Context(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		locker sync.Mutex
+		order  []int64
+		wg     sync.WaitGroup
+	)
+	sizes := []int64{100 << 20, 10, 1 << 20, 0}
+	for _, size := range sizes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := scheduler.acquireUpload(t.Context(), size); err != nil {
+				t.Errorf("acquireUpload(%d) error = %v", size, err)
+				return
+			}
+			locker.Lock()
+			order = append(order, size)
+			locker.Unlock()
+			sem.Release(1)
+		}()
+	}
+
+	waitQueued(t, scheduler, len(sizes))
+	sem.Release(1)
+	wg.Wait()
+
+	if diff := cmp.Diff([]int64{0, 10, 1 << 20, 100 << 20}, order); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadScheduler_canceled(t *testing.T) {
+	t.Parallel()
+
+	sem := semaphore.NewWeighted(1)
+	scheduler := newUploadScheduler(func(ctx context.Context) error {
+		return sem.Acquire(ctx, 1)
+	})
+	if err := sem.Acquire(t.Context(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 2)
+	for _, size := range []int64{1, 2} {
+		go func() {
+			done <- scheduler.acquireUpload(ctx, size)
+		}()
+	}
+	waitQueued(t, scheduler, 2)
+	cancel()
+
+	for range 2 {
+		if err := <-done; err == nil {
+			t.Error("acquireUpload() error = nil, want the cancellation")
+		}
+	}
+	if n := queued(scheduler); n != 0 {
+		t.Errorf("%d uploads left in the queue", n)
+	}
+}
+
+func queued(s *uploadScheduler) int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return len(s.waiting)
+}
+
+func waitQueued(t *testing.T, s *uploadScheduler, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for queued(s) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d uploads queued, want %d", queued(s), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// signatureFieldNumber is the field number of ActionsCache.signature.
+const signatureFieldNumber protowire.Number = 4
+
+// appendSignature signs the serialized header and appends the signature field to it.
+// Fields may appear in any order in protobuf, so the result is still a valid ActionsCache,
+// and the signed bytes are recovered by cutting the field off again.
+func appendSignature(header []byte, signer *crypt.Signer) []byte {
+	return appendSignatureField(header, signer.Sign(header))
+}
+
+func appendSignatureField(header, sig []byte) []byte {
+	header = protowire.AppendTag(header, signatureFieldNumber, protowire.BytesType)
+	return protowire.AppendBytes(header, sig)
+}
+
+var (
+	errUnsigned         = errors.New("header is not signed")
+	errInvalidSignature = errors.New("header signature does not match the key")
+)
+
+// verifySignature checks the signature of a serialized header, given the signature it was parsed into.
+func verifySignature(header, sig []byte, signer *crypt.Signer) error {
+	if len(sig) == 0 {
+		return errUnsigned
+	}
+
+	message, ok := bytes.CutSuffix(header, appendSignatureField(nil, sig))
+	if !ok || !signer.Verify(message, sig) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// errOutputDigest is the error of an output of a signed blob whose content does not hash to its output ID.
+var errOutputDigest = errors.New("output content does not hash to its output ID")
+
+// outputHasher hashes the content of an output as it is restored, to check it against its output ID.
+// The signature covers the header only, so the outputs of a signed blob are trusted through their IDs,
+// which are the SHA-256 of their content, and bytes swapped under a signed header are caught here.
+type outputHasher struct {
+	outputID string
+	h        hash.Hash
+}
+
+func newOutputHasher(outputID string) *outputHasher {
+	return &outputHasher{outputID: outputID, h: sha256.New()}
+}
+
+func (o *outputHasher) Write(p []byte) (int, error) {
+	return o.h.Write(p)
+}
+
+// check returns errOutputDigest when the content written does not hash to the output ID.
+// Output IDs that are not the base64 of a SHA-256 are not checked.
+func (o *outputHasher) check() error {
+	want, err := base64.StdEncoding.DecodeString(o.outputID)
+	if err != nil || len(want) != sha256.Size {
+		return nil
+	}
+	if !bytes.Equal(o.h.Sum(nil), want) {
+		return fmt.Errorf("%w: %s", errOutputDigest, o.outputID)
+	}
+
+	return nil
+}
+
+// verifiedObjectWriter is an object writer of an output of a signed blob checked at Close.
+// An object whose content does not hash to its output ID is aborted instead of made available, so it is a miss.
+type verifiedObjectWriter struct {
+	io.WriteCloser
+	logger log.Logger
+	hasher *outputHasher
+
+	once    sync.Once
+	aborted bool
+	err     error
+}
+
+func newVerifiedObjectWriter(logger log.Logger, w io.WriteCloser, outputID string) *verifiedObjectWriter {
+	return &verifiedObjectWriter{WriteCloser: w, logger: logger, hasher: newOutputHasher(outputID)}
+}
+
+func (w *verifiedObjectWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	_, _ = w.hasher.Write(p[:n])
+
+	return n, err
+}
+
+// Close makes the object available when its content is the one of its output ID, and drops it otherwise.
+// An object aborted before, e.g. of a chunk that failed to download, is not checked.
+func (w *verifiedObjectWriter) Close() error {
+	w.once.Do(func() {
+		if w.aborted {
+			return
+		}
+
+		err := w.hasher.check()
+		if err == nil {
+			w.err = w.WriteCloser.Close()
+			return
+		}
+
+		report.RestoreChecksumMismatches.Add(1)
+		w.logger.Warnf("drop the restored output: %v", err)
+		if aw, ok := w.WriteCloser.(abortWriter); ok {
+			// Only the object is dropped. The other outputs of its chunk are restored.
+			w.err = aw.Abort()
+			return
+		}
+		w.err = errors.Join(err, w.WriteCloser.Close())
+	})
+
+	return w.err
+}
+
+// Abort discards the object without checking it.
+func (w *verifiedObjectWriter) Abort() error {
+	var err error
+	w.once.Do(func() {
+		w.aborted = true
+		if aw, ok := w.WriteCloser.(abortWriter); ok {
+			err = aw.Abort()
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+func newTestSigner(t *testing.T, canSign bool, publicKey ed25519.PublicKey) *crypt.Signer {
+	t.Helper()
+
+	var privateKey ed25519.PrivateKey
+	if canSign {
+		var err error
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+	}
+
+	signer, err := crypt.NewSigner(privateKey, publicKey)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+
+	return signer
+}
+
+func TestSignedHeader(t *testing.T) {
+	t.Parallel()
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	//nolint:forcetypeassert
+	otherPublicKey := otherKey.Public().(ed25519.PublicKey)
+
+	key := make([]byte, crypt.KeySize)
+	cipher, err := crypt.New(key)
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+
+	signer := newTestSigner(t, true, nil)
+
+	tests := []struct {
+		name         string
+		uploadSigner *crypt.Signer
+		verifier     *crypt.Signer
+		cipher       *crypt.Cipher
+		wantRejected error
+	}{
+		{name: "no verification", uploadSigner: nil, verifier: nil},
+		{name: "signed", uploadSigner: signer, verifier: signer},
+		{name: "signed and encrypted", uploadSigner: signer, verifier: signer, cipher: cipher},
+		{name: "unsigned", uploadSigner: nil, verifier: signer, wantRejected: errUnsigned},
+		{name: "other key", uploadSigner: signer, verifier: newTestSigner(t, false, otherPublicKey), wantRejected: errInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			uploader := &Uploader{logger: log.DefaultLogger, cipher: tt.cipher, signer: tt.uploadSigner}
+			headerBuf, err := uploader.createHeader(
+				map[string]*v1.IndexEntry{"action": {OutputId: "output", Size: 5}},
+				nil,
+				[]*v1.ActionsOutput{{Id: "output", Size: 5}},
+				5,
+			)
+			if err != nil {
+				t.Fatalf("create header: %v", err)
+			}
+
+			client := &mockDownloadClient{}
+			client.expectDownloadBlockBuffer(0, 8, headerBuf[:8], nil)
+			client.expectDownloadBlockBuffer(8, int64(len(headerBuf)-8), headerBuf[8:], nil)
+
+			downloader, err := NewDownloader(t.Context(), log.DefaultLogger, client, tt.cipher, tt.verifier)
+			if err != nil {
+				t.Fatalf("new downloader: %v", err)
+			}
+
+			if !errors.Is(downloader.Rejected(), tt.wantRejected) {
+				t.Errorf("rejected: got %v, want %v", downloader.Rejected(), tt.wantRejected)
+			}
+
+			wantEntries := 1
+			if tt.wantRejected != nil {
+				wantEntries = 0
+			}
+			if len(downloader.header.Entries) != wantEntries {
+				t.Errorf("entries: got %d, want %d", len(downloader.header.Entries), wantEntries)
+			}
+			if downloader.IsEmpty() != (tt.wantRejected != nil) {
+				t.Errorf("IsEmpty: got %v", downloader.IsEmpty())
+			}
+		})
+	}
+}
+
+func TestSignedOutputs(t *testing.T) {
+	t.Parallel()
+
+	signer := newTestSigner(t, true, nil)
+
+	good, poisoned, foreign := []byte("good output"), []byte("real output"), []byte("evil output")
+	outputs := []*v1.ActionsOutput{
+		{Id: outputIDOf(good), Offset: 0, Size: int64(len(good))},
+		{Id: outputIDOf(poisoned), Offset: int64(len(good)), Size: int64(len(poisoned))},
+	}
+	uploader := &Uploader{logger: log.DefaultLogger, signer: signer}
+	headerBuf, err := uploader.createHeader(
+		map[string]*v1.IndexEntry{
+			"good":     {OutputId: outputs[0].Id, Size: outputs[0].Size},
+			"poisoned": {OutputId: outputs[1].Id, Size: outputs[1].Size},
+		},
+		nil,
+		outputs,
+		int64(len(good)+len(poisoned)),
+	)
+	if err != nil {
+		t.Fatalf("create header: %v", err)
+	}
+
+	// The header keeps its signature, while the bytes of an output are swapped for others of the same size.
+	blob := slices.Concat(headerBuf, good, foreign)
+
+	downloader, err := NewDownloader(t.Context(), log.DefaultLogger, &blobClient{blob: blob}, nil, signer)
+	if err != nil {
+		t.Fatalf("new downloader: %v", err)
+	}
+	if err := downloader.Rejected(); err != nil {
+		t.Fatalf("rejected: %v", err)
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		locker := sync.Mutex{}
+		writers := map[string]*mockWriteCloser{}
+		err := downloader.DownloadAllOutputBlocks(t.Context(), func(_ context.Context, objectID string) (io.WriteCloser, error) {
+			locker.Lock()
+			defer locker.Unlock()
+			w := &mockWriteCloser{}
+			writers[objectID] = w
+			return w, nil
+		})
+		if err != nil {
+			t.Fatalf("DownloadAllOutputBlocks() error = %v", err)
+		}
+
+		if w := writers[outputs[0].Id]; !w.closed || w.aborted || !bytes.Equal(w.Bytes(), good) {
+			t.Errorf("good output: closed %t, aborted %t, content %q", w.closed, w.aborted, w.Bytes())
+		}
+		if w := writers[outputs[1].Id]; w.closed || !w.aborted {
+			t.Errorf("poisoned output: closed %t, aborted %t, want it dropped", w.closed, w.aborted)
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		if err := downloader.FetchOutput(t.Context(), outputs[0].Id, io.Discard); err != nil {
+			t.Errorf("FetchOutput(good) error = %v", err)
+		}
+		if err := downloader.FetchOutput(t.Context(), outputs[1].Id, io.Discard); !errors.Is(err, errOutputDigest) {
+			t.Errorf("FetchOutput(poisoned) error = %v, want errOutputDigest", err)
+		}
+	})
+}
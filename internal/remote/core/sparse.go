@@ -0,0 +1,271 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/report"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/stream"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// sparseUsedRatio is the part of the outputs restored that the go command must have used by --transfer.sparse-after
+	// for the rest of the blob to be restored as a whole.
+	sparseUsedRatio = 0.1
+	// maxCoalesceGap is the largest gap between two outputs read in one range, the gap being discarded.
+	// A ranged read costs about as much as sending this many bytes.
+	maxCoalesceGap = 256 << 10
+	// coalesceWindow is the time a fetch waits for the fetches of other outputs, to read them in the same ranges.
+	// The go command looks up the actions of a package in a burst, so their outputs are mostly fetched together.
+	coalesceWindow = 5 * time.Millisecond
+)
+
+// errSparse cancels the restoration of the whole blob once few of its outputs are used.
+var errSparse = errors.New("few outputs of the blob are used")
+
+// sparseAfter is the time after which the restoration of the whole blob is stopped when few of its outputs are used, 0 never.
+var sparseAfter atomic.Int64
+
+// SetSparseAfter sets the time after which the restoration of the whole blob is stopped when few of its outputs are used,
+// and the outputs looked up afterwards are read on demand. 0 always restores the whole blob.
+// It must be called before the backend is created.
+func SetSparseAfter(d time.Duration) {
+	sparseAfter.Store(int64(d))
+}
+
+// Used records that the output was used by a hit, to measure the part of the blob used.
+func (c *Backend) Used(outputID string) {
+	if _, ok := c.downloader.output(outputID); !ok {
+		return
+	}
+	if _, loaded := c.used.LoadOrStore(outputID, struct{}{}); !loaded {
+		c.usedOutputs.Add(1)
+	}
+}
+
+// checkSparse stops the restoration of the whole blob when few of the outputs restored so far are used,
+// so that only the outputs looked up afterwards are downloaded.
+func (c *Backend) checkSparse() {
+	// Without ranged reads, the outputs cannot be read on their own.
+	if !c.restoring.Load() || !ClientCapabilities(c.downloader.client).RangedReads {
+		return
+	}
+
+	restored, used := c.downloader.restored.Load(), c.usedOutputs.Load()
+	if restored == 0 || float64(used) >= sparseUsedRatio*float64(restored) {
+		return
+	}
+
+	c.sparse.Store(true)
+	c.logger.Infof("only %d of the %d outputs restored were used. the rest of the blob is downloaded on demand.", used, restored)
+	report.AddEvent("download", fmt.Sprintf("switched to on-demand downloads: %d of %d outputs restored were used", used, restored))
+	c.downloadCancelFunc(errSparse)
+}
+
+// fetchCall is a fetch of an output shared by the lookups of it.
+type fetchCall struct {
+	done     chan struct{}
+	diskPath string
+	err      error
+}
+
+// Fetch downloads the output to the local cache and returns its path,
+// once the restoration of the whole blob was stopped for few of its outputs being used,
+// or when the output was left out of the restoration by the download filter.
+// It returns "" when the blob is restored as a whole, or does not have the output.
+func (c *Backend) Fetch(ctx context.Context, outputID string) (string, error) {
+	if !c.sparse.Load() && !c.fetchFiltered(outputID) {
+		return "", nil
+	}
+	output, ok := c.downloader.output(outputID)
+	if !ok {
+		return "", nil
+	}
+
+	call := &fetchCall{done: make(chan struct{})}
+	if value, loaded := c.fetches.LoadOrStore(outputID, call); loaded {
+		//nolint:forcetypeassert
+		call = value.(*fetchCall)
+		<-call.done
+		return call.diskPath, call.err
+	}
+	defer c.fetches.Delete(outputID)
+	defer close(call.done)
+
+	call.diskPath, call.err = c.fetch(ctx, output)
+
+	return call.diskPath, call.err
+}
+
+// fetchFiltered reports whether the output left out by the download filter is fetched on its lookup,
+// which needs ranged reads.
+func (c *Backend) fetchFiltered(outputID string) bool {
+	if _, ok := c.downloader.filteredOutputs()[outputID]; !ok {
+		return false
+	}
+
+	return ClientCapabilities(c.downloader.client).RangedReads
+}
+
+func (c *Backend) fetch(ctx context.Context, output *v1.ActionsOutput) (string, error) {
+	diskPath, w, err := c.objectWriter(ctx, output.Id)
+	if err != nil {
+		return "", fmt.Errorf("get object writer: %w", err)
+	}
+
+	var verified io.Writer = w
+	var hasher *outputHasher
+	if c.downloader.signer != nil {
+		hasher = newOutputHasher(output.Id)
+		verified = io.MultiWriter(w, hasher)
+	}
+
+	dw := c.downloader.decodeWriter(output, verified)
+	err = c.downloader.fetchCoalesced(ctx, output, dw)
+	if err == nil {
+		err = dw.Close()
+	}
+	if err == nil && hasher != nil {
+		if err = hasher.check(); err != nil {
+			report.RestoreChecksumMismatches.Add(1)
+		}
+	}
+	if err != nil {
+		c.downloader.abortObjects([]io.WriteCloser{w})
+		return "", fmt.Errorf("fetch output %s: %w", output.Id, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("close object writer: %w", err)
+	}
+
+	return diskPath, nil
+}
+
+// fetchRequest is an output waiting to be read by fetchCoalesced.
+type fetchRequest struct {
+	output *v1.ActionsOutput
+	w      io.Writer
+	done   chan error
+}
+
+// fetchCoalesced reads the bytes of the output to w, together with the outputs fetched at about the same time.
+// The first fetch gathers the others for coalesceWindow, and reads them in ranges merging the outputs close in the blob.
+func (d *Downloader) fetchCoalesced(ctx context.Context, output *v1.ActionsOutput, w io.Writer) error {
+	if output.Size == 0 {
+		return nil
+	}
+
+	req := &fetchRequest{output: output, w: w, done: make(chan error, 1)}
+
+	d.fetchLocker.Lock()
+	d.fetches = append(d.fetches, req)
+	gather := !d.fetching
+	d.fetching = true
+	d.fetchLocker.Unlock()
+
+	if gather {
+		time.Sleep(coalesceWindow)
+
+		d.fetchLocker.Lock()
+		reqs := d.fetches
+		d.fetches, d.fetching = nil, false
+		d.fetchLocker.Unlock()
+
+		// The ranges are read for the other fetches too, so they are not given up with the context of this one.
+		d.readRanges(context.WithoutCancel(ctx), coalesceFetches(reqs))
+	}
+
+	// The writer is only released once its range is read, even when ctx is done.
+	return <-req.done
+}
+
+// fetchRange is a range of the blob read at once for the outputs in it.
+type fetchRange struct {
+	offset   int64
+	size     int64
+	requests []*fetchRequest
+}
+
+// coalesceFetches merges the outputs apart by at most maxCoalesceGap into ranges of up to maxChunkSize.
+// An output larger than maxChunkSize is read in a range of its own.
+func coalesceFetches(reqs []*fetchRequest) []fetchRange {
+	reqs = slices.Clone(reqs)
+	slices.SortFunc(reqs, func(x, y *fetchRequest) int {
+		return int(x.output.Offset - y.output.Offset)
+	})
+
+	var ranges []fetchRange
+	for _, req := range reqs {
+		if len(ranges) > 0 {
+			last := &ranges[len(ranges)-1]
+			gap := req.output.Offset - (last.offset + last.size)
+			end := req.output.Offset + req.output.Size
+			if gap >= 0 && gap <= maxCoalesceGap && end-last.offset <= maxChunkSize {
+				last.size = end - last.offset
+				last.requests = append(last.requests, req)
+				continue
+			}
+		}
+
+		ranges = append(ranges, fetchRange{
+			offset:   req.output.Offset,
+			size:     req.output.Size,
+			requests: []*fetchRequest{req},
+		})
+	}
+
+	return ranges
+}
+
+// readRanges reads the ranges in parallel, and sends the result of each output to its request.
+func (d *Downloader) readRanges(ctx context.Context, ranges []fetchRange) {
+	eg := errgroup.Group{}
+	for _, r := range ranges {
+		eg.Go(func() error {
+			err := d.readRange(ctx, r)
+			for _, req := range r.requests {
+				req.done <- err
+			}
+
+			return nil
+		})
+	}
+	_ = eg.Wait()
+}
+
+func (d *Downloader) readRange(ctx context.Context, r fetchRange) error {
+	writers := make([]stream.WriterWithSize, 0, 2*len(r.requests))
+	offset := r.offset
+	for _, req := range r.requests {
+		if gap := req.output.Offset - offset; gap > 0 {
+			writers = append(writers, stream.WriterWithSize{Writer: nopWriteCloser{Writer: io.Discard}, Size: gap})
+		}
+		writers = append(writers, stream.WriterWithSize{Writer: nopWriteCloser{Writer: req.w}, Size: req.output.Size})
+		offset = req.output.Offset + req.output.Size
+	}
+
+	if err := acquireTransfer(ctx); err != nil {
+		return fmt.Errorf("acquire transfer: %w", err)
+	}
+	defer transfers.release()
+
+	start := time.Now()
+	var err error
+	report.RemoteIONanos.Stopwatch(func() {
+		err = d.downloadChunk(ctx, d.headerSize+r.offset, r.size, stream.NewJoinedWriter(writers...))
+	})
+	if err != nil {
+		return fmt.Errorf("download range: %w", err)
+	}
+	report.DownloadedBytes.Add(r.size)
+	probeBandwidth(d.logger, r.size, time.Since(start))
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+// blobClient serves the ranges of a blob in memory, recording them.
+type blobClient struct {
+	blob   []byte
+	locker sync.Mutex
+	reads  [][2]int64
+}
+
+func (c *blobClient) GetURL(context.Context) string {
+	return ""
+}
+
+func (c *blobClient) DownloadBlock(_ context.Context, offset int64, size int64, w io.Writer) error {
+	c.locker.Lock()
+	c.reads = append(c.reads, [2]int64{offset, size})
+	c.locker.Unlock()
+
+	_, err := w.Write(c.blob[offset : offset+size])
+	return err
+}
+
+func (c *blobClient) DownloadBlockBuffer(_ context.Context, offset int64, size int64, buf []byte) error {
+	copy(buf, c.blob[offset:offset+size])
+	return nil
+}
+
+func TestCoalesceFetches(t *testing.T) {
+	t.Parallel()
+
+	request := func(offset, size int64) *fetchRequest {
+		return &fetchRequest{output: &v1.ActionsOutput{Offset: offset, Size: size}}
+	}
+
+	tests := []struct {
+		name     string
+		requests []*fetchRequest
+		want     [][2]int64
+	}{
+		{name: "adjacent", requests: []*fetchRequest{request(10, 5), request(0, 10)}, want: [][2]int64{{0, 15}}},
+		{name: "small gap", requests: []*fetchRequest{request(0, 10), request(10+maxCoalesceGap, 5)}, want: [][2]int64{{0, 15 + maxCoalesceGap}}},
+		{name: "large gap", requests: []*fetchRequest{request(0, 10), request(11+maxCoalesceGap, 5)}, want: [][2]int64{{0, 10}, {11 + maxCoalesceGap, 5}}},
+		{name: "over a chunk", requests: []*fetchRequest{request(0, maxChunkSize-5), request(maxChunkSize-5, 10)}, want: [][2]int64{{0, maxChunkSize - 5}, {maxChunkSize - 5, 10}}},
+		{name: "large output", requests: []*fetchRequest{request(0, 2*maxChunkSize)}, want: [][2]int64{{0, 2 * maxChunkSize}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got [][2]int64
+			for _, r := range coalesceFetches(tt.requests) {
+				got = append(got, [2]int64{r.offset, r.size})
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ranges mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDownloader_readRanges(t *testing.T) {
+	t.Parallel()
+
+	blob := bytes.Repeat([]byte("0123456789"), 100)
+	client := &blobClient{blob: append([]byte("header"), blob...)}
+	downloader := &Downloader{logger: log.DefaultLogger, client: client, headerSize: 6}
+
+	outputs := []*v1.ActionsOutput{
+		{Id: "a", Offset: 0, Size: 10},
+		{Id: "b", Offset: 20, Size: 5},
+		{Id: "c", Offset: 990, Size: 10},
+	}
+	bufs := make([]bytes.Buffer, len(outputs))
+	requests := make([]*fetchRequest, 0, len(outputs))
+	for i, output := range outputs {
+		requests = append(requests, &fetchRequest{output: output, w: &bufs[i], done: make(chan error, 1)})
+	}
+
+	downloader.readRanges(t.Context(), coalesceFetches(requests))
+
+	for i, output := range outputs {
+		if err := <-requests[i].done; err != nil {
+			t.Errorf("output %s: %v", output.Id, err)
+		}
+		if want := blob[output.Offset : output.Offset+output.Size]; !bytes.Equal(bufs[i].Bytes(), want) {
+			t.Errorf("output %s: got %q, want %q", output.Id, bufs[i].Bytes(), want)
+		}
+	}
+	// The outputs are close, so they are read in one range with the gaps discarded.
+	if diff := cmp.Diff([][2]int64{{6, 1000}}, client.reads); diff != "" {
+		t.Errorf("reads mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBackend_checkSparse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		restoring  bool
+		restored   int64
+		used       int64
+		wantSparse bool
+	}{
+		{name: "sparse", restoring: true, restored: 100, used: 9, wantSparse: true},
+		{name: "dense", restoring: true, restored: 100, used: 10},
+		{name: "nothing restored", restoring: true},
+		{name: "restored", restored: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancelCause(t.Context())
+			c := &Backend{logger: log.DefaultLogger, downloader: &Downloader{}, downloadCancelFunc: cancel}
+			c.restoring.Store(tt.restoring)
+			c.downloader.restored.Store(tt.restored)
+			c.usedOutputs.Store(tt.used)
+
+			c.checkSparse()
+
+			if got := c.sparse.Load(); got != tt.wantSparse {
+				t.Errorf("sparse: got %t, want %t", got, tt.wantSparse)
+			}
+			if got := context.Cause(ctx) == errSparse; got != tt.wantSparse {
+				t.Errorf("cancelled: got %t, want %t", got, tt.wantSparse)
+			}
+		})
+	}
+}
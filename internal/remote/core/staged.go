@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mazrean/gocica/backend"
+)
+
+// StagedBlockClient is an UploadClient listing the blocks staged before the commit.
+// The clients of the backends of plugins implement it with backend.StagedBlockClient.
+type StagedBlockClient = backend.StagedBlockClient
+
+// stagedBlockChecks is how many times the staged blocks are listed before a commit, staging the missing ones again in between.
+const stagedBlockChecks = 3
+
+// StagedBlocks returns the sizes of the blocks staged by the client by their IDs,
+// or an error matching errors.ErrUnsupported when it cannot list them.
+func StagedBlocks(ctx context.Context, client UploadClient) (map[string]int64, error) {
+	stagedClient, ok := client.(StagedBlockClient)
+	if !ok {
+		return nil, fmt.Errorf("list staged blocks: %w", errors.ErrUnsupported)
+	}
+
+	return stagedClient.StagedBlocks(ctx)
+}
+
+// copiedBase is the base blob copied into the staged blocks, kept to stage the blocks again when they are lost.
+type copiedBase struct {
+	url           string
+	ranges        []baseRange
+	throughRunner bool
+}
+
+// stagedSizes returns the sizes of the blocks staged for the new outputs and the batches of small outputs by their IDs.
+func (u *Uploader) stagedSizes() map[string]int64 {
+	outputs := u.outputs.items()
+	batches := u.batches.items()
+
+	sizes := make(map[string]int64, len(outputs)+len(batches))
+	for _, output := range outputs {
+		if output.Size != 0 {
+			sizes[output.Id] = output.Size
+		}
+	}
+	for _, batch := range batches {
+		sizes[batch.blockID] = batch.size
+	}
+
+	return sizes
+}
+
+// missingBlocks returns the blocks of blockIDs not staged with the sizes they were staged with, in their order.
+func missingBlocks(staged map[string]int64, blockIDs []string, sizes map[string]int64) []string {
+	var missing []string
+	for _, blockID := range blockIDs {
+		size, ok := staged[blockID]
+		if !ok || size != sizes[blockID] {
+			missing = append(missing, blockID)
+		}
+	}
+
+	return missing
+}
+
+// restageBlocks stages the missing blocks of the base blob again,
+// and adds the missing blocks of the new outputs to lost, whose outputs are then left out of the commit as they are no longer kept.
+// The header is staged again with the next commit attempt.
+func (u *Uploader) restageBlocks(ctx context.Context, missing []string, headerBlockID string, lost map[string]struct{}) error {
+	base := u.base.Load()
+	baseRanges := map[string]baseRange{}
+	if base != nil {
+		for _, r := range base.ranges {
+			baseRanges[r.blockID] = r
+		}
+	}
+
+	for _, blockID := range missing {
+		if blockID == headerBlockID {
+			continue
+		}
+		if r, ok := baseRanges[blockID]; ok {
+			if err := u.copyRangeWithRetry(ctx, base.url, r, base.throughRunner); err != nil {
+				return fmt.Errorf("stage base block again: %w", err)
+			}
+			continue
+		}
+
+		lost[blockID] = struct{}{}
+	}
+
+	return nil
+}
+
+// checkStaged lists the staged blocks, and returns the blocks of blockIDs missing or of another size than sizes.
+// It returns no blocks when the client cannot list them.
+func (u *Uploader) checkStaged(ctx context.Context, blockIDs []string, sizes map[string]int64) ([]string, error) {
+	staged, err := StagedBlocks(ctx, u.client)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return missingBlocks(staged, blockIDs, sizes), nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+)
+
+// stagingClient keeps the sizes of the blocks staged like Azure Blob Storage, losing the copies of the blocks in lose.
+type stagingClient struct {
+	locker    sync.Mutex
+	staged    map[string]int64
+	lose      map[string]int
+	committed []string
+}
+
+func (c *stagingClient) UploadBlock(_ context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.staged[blockID] = size
+
+	return size, nil
+}
+
+func (c *stagingClient) UploadBlockFromURL(_ context.Context, blockID string, _ string, _, size int64) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.lose[blockID] > 0 {
+		c.lose[blockID]--
+		return nil
+	}
+	c.staged[blockID] = size
+
+	return nil
+}
+
+func (c *stagingClient) Commit(_ context.Context, blockIDs []string, _ int64) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.committed = blockIDs
+
+	return nil
+}
+
+func (c *stagingClient) StagedBlocks(context.Context) (map[string]int64, error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	return maps.Clone(c.staged), nil
+}
+
+func TestUploader_CommitStagedBlocks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// staged are the blocks staged before the commit besides the header.
+		staged        map[string]int64
+		lose          map[string]int
+		wantCommitted []string
+		wantErr       bool
+	}{
+		{
+			name:          "all staged",
+			staged:        map[string]int64{"base-block": 100, "output": 10},
+			wantCommitted: []string{"base-block", "output"},
+		},
+		{
+			name:          "truncated output left out",
+			staged:        map[string]int64{"base-block": 100, "output": 9},
+			wantCommitted: []string{"base-block"},
+		},
+		{
+			name:          "lost base staged again",
+			staged:        map[string]int64{"output": 10},
+			wantCommitted: []string{"base-block", "output"},
+		},
+		{
+			name:    "base never staged",
+			staged:  map[string]int64{"output": 10},
+			lose:    map[string]int{"base-block": stagedBlockChecks},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &stagingClient{staged: tt.staged, lose: tt.lose}
+			baseOutputs := []*v1.ActionsOutput{{Id: "base", Size: 100}}
+			uploader := &Uploader{
+				logger: log.DefaultLogger,
+				client: client,
+				waitBaseFunc: func() ([]string, int64, []*v1.ActionsOutput, error) {
+					return []string{"base-block"}, 100, baseOutputs, nil
+				},
+			}
+			uploader.base.Store(&copiedBase{url: "base-url", ranges: []baseRange{{blockID: "base-block", size: 100}}})
+			uploader.outputs.append(&v1.ActionsOutput{Id: "output", Size: 10})
+
+			err := uploader.Commit(t.Context(), map[string]*v1.IndexEntry{
+				"base-action":   {OutputId: "base"},
+				"output-action": {OutputId: "output"},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if client.committed != nil {
+					t.Errorf("committed %v, want no commit", client.committed)
+				}
+				return
+			}
+
+			// The header is the first block.
+			if len(client.committed) == 0 || !slices.Equal(client.committed[1:], tt.wantCommitted) {
+				t.Errorf("committed blocks: got %v, want the header and %v", client.committed, tt.wantCommitted)
+			}
+		})
+	}
+}
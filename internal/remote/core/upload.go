@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
@@ -9,12 +10,24 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/DataDog/zstd"
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/pkg/audit"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/internal/pkg/dedup"
 	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
 	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/policy"
 	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote"
 	"github.com/mazrean/gocica/log"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
@@ -25,18 +38,43 @@ var compressGauge = metrics.NewGauge("blob_compress_latency")
 type Uploader struct {
 	logger log.Logger
 	// warning: client can be nil, which means no upload is needed.
-	client        UploadClient
-	outputsLocker sync.RWMutex
-	outputs       []*v1.ActionsOutput
-	waitBaseFunc  waitBaseFunc
+	client UploadClient
+	// cipher encrypts outputs and the header before upload. nil disables encryption.
+	cipher *crypt.Cipher
+	// signer signs the header before upload. nil or a Signer without a private key leaves it unsigned.
+	signer *crypt.Signer
+	// uploads dedups the uploads of the outputs put more than once or in the base blob.
+	uploads dedup.Uploads
+	// outputs are the outputs uploaded as blocks of their own.
+	outputs appendList[*v1.ActionsOutput]
+	// batches are the staged blocks of small outputs, committed after the outputs of their own blocks.
+	batches      appendList[*uploadedBatch]
+	waitBaseFunc waitBaseFunc
+	// batch is the block of small outputs being filled.
+	batchLocker sync.Mutex
+	batch       *outputBatch
+	// headerBlockID is kept across retried commits, so that a retry replaces the staged header instead of adding another block.
+	headerBlockIDLocker sync.Mutex
+	headerBlockID       string
+	// modCache is the module cache committed with the entries. nil commits none.
+	modCache *v1.ModCache
+	// admission is the sketch of the admission committed with the entries. nil commits none.
+	admission *v1.AdmissionSketch
+	// blocks counts the blocks staged for the outputs and the batches, and baseBlocks the ones planned for the base blob,
+	// so that outputs past maxBlocks are not uploaded only to fail the commit.
+	blocks         atomic.Int64
+	baseBlocks     atomic.Int64
+	blockLimitOnce sync.Once
+	// base is the base blob copied, whose blocks are staged again when they are found missing before the commit.
+	base atomic.Pointer[copiedBase]
 }
 
-// UploadClient defines the interface for uploading blocks to remote storage.
-type UploadClient interface {
-	UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error)
-	UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error
-	Commit(ctx context.Context, blockIDs []string, size int64) error
-}
+// UploadClient writes blocks to remote storage. Like DownloadClient, it is the client of the public backend package.
+type UploadClient = backend.UploadClient
+
+// ExpiringUploadClient is an UploadClient of a backend with object lifecycle.
+// SetExpiry is called before Commit with the time every entry of the blob has expired, with --expiry.lifecycle.
+type ExpiringUploadClient = backend.ExpiringUploadClient
 
 type BaseBlobProvider interface {
 	IsEmpty() bool
@@ -47,10 +85,14 @@ type BaseBlobProvider interface {
 type waitBaseFunc func() (baseBlockIDs []string, baseOutputSize int64, baseOutputs []*v1.ActionsOutput, err error)
 
 // NewUploader creates a new Uploader with the given client and base blob provider.
-func NewUploader(ctx context.Context, logger log.Logger, client UploadClient, baseBlobProvider BaseBlobProvider) *Uploader {
+// When cipher is not nil, outputs and the header are encrypted with it.
+// When signer can sign, the header is signed with it.
+func NewUploader(ctx context.Context, logger log.Logger, client UploadClient, baseBlobProvider BaseBlobProvider, cipher *crypt.Cipher, signer *crypt.Signer) *Uploader {
 	uploader := &Uploader{
 		logger: logger,
 		client: client,
+		cipher: cipher,
+		signer: signer,
 	}
 
 	uploader.waitBaseFunc = uploader.setupBase(baseBlobProvider)
@@ -75,7 +117,8 @@ func (u *Uploader) setupBase(baseBlobProvider BaseBlobProvider) waitBaseFunc {
 		}
 	}
 
-	eg, ctx := errgroup.WithContext(context.Background())
+	// The base is copied until the commit waits for it, so only the shutdown of the process stops the copy.
+	eg, ctx := errgroup.WithContext(lifecycle.Context())
 
 	var (
 		baseBlockIDs   []string
@@ -88,24 +131,20 @@ func (u *Uploader) setupBase(baseBlobProvider BaseBlobProvider) waitBaseFunc {
 		}
 		baseOutputSize = size
 
-		var uploadSize int64
-		for i := int64(0); i < size; i += uploadSize {
-			baseBlockID, err := u.generateBlockID()
-			if err != nil {
-				return fmt.Errorf("generate block ID: %w", err)
-			}
-			baseBlockIDs = append(baseBlockIDs, baseBlockID)
-
-			chunkUploadSize := min(maxUploadChunkSize, size-i)
-			uploadSize = chunkUploadSize
-			eg.Go(func() error {
-				err = u.client.UploadBlockFromURL(ctx, baseBlockID, url, offset+i, chunkUploadSize)
-				if err != nil {
-					return fmt.Errorf("upload block from URL: %w", err)
-				}
+		baseBlockIDs, err = u.copyBase(ctx, baseBlobProvider, url, offset, size)
+		if err != nil {
+			// The base is committed without its blocks, so they are given back to the outputs.
+			u.baseBlocks.Store(0)
+			return fmt.Errorf("copy base: %w", err)
+		}
 
-				return nil
-			})
+		// The outputs of the copied base are committed with it, so their puts are not uploaded again.
+		outputs, err := baseBlobProvider.GetOutputs(ctx)
+		if err != nil {
+			return fmt.Errorf("get base outputs: %w", err)
+		}
+		for _, output := range outputs {
+			u.uploads.Known(output.Id)
 		}
 
 		return nil
@@ -132,52 +171,96 @@ func (u *Uploader) setupBase(baseBlobProvider BaseBlobProvider) waitBaseFunc {
 	}
 }
 
+// UploadOutput uploads the output once however many times it is put, and not at all once it is in the copied base blob.
 func (u *Uploader) UploadOutput(ctx context.Context, outputID string, size int64, r io.ReadSeekCloser) error {
 	if u.client == nil {
 		return nil
 	}
 
+	_, err := u.uploads.Do(ctx, outputID, size, func() error {
+		return u.uploadOutput(ctx, outputID, size, r)
+	})
+
+	return err
+}
+
+func (u *Uploader) uploadOutput(ctx context.Context, outputID string, size int64, r io.ReadSeekCloser) error {
+	if err := audit.RecordReader(outputID, r); err != nil {
+		return fmt.Errorf("record output: %w", err)
+	}
+
 	var (
 		reader      io.ReadSeeker
 		compression v1.Compression
 	)
-	if size > 100*(2^10) {
-		buf := bytes.NewBuffer(nil)
-		zw := zstd.NewWriterLevel(buf, 1)
-
-		var err error
-		compressGauge.Stopwatch(func() {
-			_, err = io.Copy(zw, r)
-		}, "compress_data")
-		if err != nil {
-			return fmt.Errorf("compress data: %w", err)
+	if size > 100*(2^10) && currentCodec().Compression == v1.Compression_COMPRESSION_ZSTD {
+		if err := compressions.Acquire(ctx, 1); err != nil {
+			return fmt.Errorf("acquire compression: %w", err)
 		}
-
-		if err := zw.Close(); err != nil {
-			return fmt.Errorf("close compressor: %w", err)
+		buf, err := u.compressOutput(ctx, r, size)
+		compressions.Release(1)
+		if err != nil {
+			return err
 		}
+		defer buf.Release()
 
-		reader = bytes.NewReader(buf.Bytes())
+		reader = buf.Reader()
 		compression = v1.Compression_COMPRESSION_ZSTD
 	} else {
 		reader = r
 		compression = v1.Compression_COMPRESSION_UNSPECIFIED
 	}
 
+	if u.cipher != nil && size != 0 {
+		// The output is encrypted segment by segment into a buffer within the memory budget, as it is compressed.
+		buf, err := membuf.New(size + crypt.Overhead(size))
+		if err != nil {
+			return fmt.Errorf("allocate encryption buffer: %w", err)
+		}
+		defer buf.Release()
+
+		if err := u.cipher.EncryptTo(buf, reader, []byte(outputID)); err != nil {
+			return fmt.Errorf("encrypt data: %w", err)
+		}
+		reader = buf.Reader()
+	}
+
+	if size > 0 && size <= maxBatchedOutputSize {
+		if err := u.addToBatch(ctx, outputID, reader, compression); err != nil {
+			return fmt.Errorf("add to batch: %w", err)
+		}
+		report.UploadedRawBytes.Add(size)
+
+		return nil
+	}
+
 	var uploadSize int64
 	if size == 0 {
 		uploadSize = 0
 	} else {
+		if !u.reserveBlock() {
+			u.skipOverBlockLimit(1, size)
+			return nil
+		}
+
+		if err := uploadQueue.acquireUpload(ctx, size); err != nil {
+			u.blocks.Add(-1)
+			return fmt.Errorf("acquire transfer: %w", err)
+		}
 		var err error
-		uploadSize, err = u.client.UploadBlock(ctx, outputID, myio.NopSeekCloser(reader))
+		report.RemoteIONanos.Stopwatch(func() {
+			uploadSize, err = u.client.UploadBlock(ctx, outputID, myio.NopSeekCloser(reader))
+		})
+		transfers.release()
 		if err != nil {
+			u.blocks.Add(-1)
 			return fmt.Errorf("upload block: %w", err)
 		}
 	}
+	report.UploadedRawBytes.Add(size)
+	report.UploadedBytes.Add(uploadSize)
 
-	u.outputsLocker.Lock()
-	defer u.outputsLocker.Unlock()
-	u.outputs = append(u.outputs, &v1.ActionsOutput{
+	u.outputs.append(&v1.ActionsOutput{
 		Id:          outputID,
 		Size:        uploadSize,
 		Compression: compression,
@@ -186,13 +269,31 @@ func (u *Uploader) UploadOutput(ctx context.Context, outputID string, size int64
 	return nil
 }
 
-func (u *Uploader) constructOutputs(baseOutputSize int64, baseOutputs []*v1.ActionsOutput) ([]string, []*v1.ActionsOutput, int64) {
-	var newOutputs []*v1.ActionsOutput
-	func() {
-		u.outputsLocker.RLock()
-		defer u.outputsLocker.RUnlock()
-		newOutputs = u.outputs
-	}()
+func compress(w io.Writer, r io.Reader) error {
+	zw := zstd.NewWriterLevel(w, currentCodec().Level)
+
+	var err error
+	compressGauge.Stopwatch(func() {
+		_, err = io.Copy(zw, r)
+	}, "compress_data")
+	if err != nil {
+		return fmt.Errorf("compress data: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close compressor: %w", err)
+	}
+
+	return nil
+}
+
+// constructOutputs places the new outputs after the base ones,
+// and returns the IDs of the blocks to commit after the base blocks, the outputs, and their total size.
+// The blocks past maxNewBlocks are left out with their outputs, so that the commit stays within the block limit of the blob,
+// and so are the blocks in lost, which were found missing in the storage.
+func (u *Uploader) constructOutputs(baseOutputSize int64, baseOutputs []*v1.ActionsOutput, maxNewBlocks int64, lost map[string]struct{}) ([]string, []*v1.ActionsOutput, int64) {
+	newOutputs := u.outputs.items()
+	batches := u.batches.items()
 
 	outputMap := make(map[string]struct{}, len(newOutputs)+len(baseOutputs))
 	for _, output := range baseOutputs {
@@ -200,29 +301,79 @@ func (u *Uploader) constructOutputs(baseOutputSize int64, baseOutputs []*v1.Acti
 	}
 	outputs := baseOutputs
 	offset := baseOutputSize
-	newOutputIDs := make([]string, 0, len(newOutputs))
+	newBlockIDs := make([]string, 0, len(newOutputs)+len(batches))
+	dropped := 0
 	for _, output := range newOutputs {
 		if _, ok := outputMap[output.Id]; ok {
 			continue
 		}
+		if _, ok := lost[output.Id]; ok && output.Size != 0 {
+			continue
+		}
+		if output.Size != 0 && int64(len(newBlockIDs)) >= maxNewBlocks {
+			dropped++
+			continue
+		}
 
 		outputMap[output.Id] = struct{}{}
 		output.Offset = offset
 		offset += output.Size
 		outputs = append(outputs, output)
 		if output.Size != 0 {
-			newOutputIDs = append(newOutputIDs, output.Id)
+			newBlockIDs = append(newBlockIDs, output.Id)
+		}
+	}
+
+	// The bytes of a batch are committed as a whole, so an output already in the base only goes unreferenced.
+	for _, batch := range batches {
+		if _, ok := lost[batch.blockID]; ok {
+			continue
+		}
+		if int64(len(newBlockIDs)) >= maxNewBlocks {
+			dropped += len(batch.outputs)
+			continue
+		}
+
+		for _, output := range batch.outputs {
+			if _, ok := outputMap[output.Id]; ok {
+				continue
+			}
+
+			outputMap[output.Id] = struct{}{}
+			outputs = append(outputs, &v1.ActionsOutput{
+				Id:          output.Id,
+				Offset:      offset + output.Offset,
+				Size:        output.Size,
+				Compression: output.Compression,
+			})
 		}
+		offset += batch.size
+		newBlockIDs = append(newBlockIDs, batch.blockID)
+	}
+	if dropped > 0 {
+		u.logger.Warnf("%d outputs are not committed because the blob reached the %d blocks of Azure Blob Storage", dropped, maxBlocks)
 	}
 
-	return newOutputIDs, outputs, offset
+	return newBlockIDs, outputs, offset
+}
+
+// SetModCache sets the module cache committed with the entries by Commit.
+func (u *Uploader) SetModCache(modCache *v1.ModCache) {
+	u.modCache = modCache
 }
 
-func (u *Uploader) createHeader(entries map[string]*v1.IndexEntry, outputs []*v1.ActionsOutput, outputSize int64) ([]byte, error) {
+// SetAdmission sets the sketch of the admission committed with the entries by Commit.
+func (u *Uploader) SetAdmission(admission *v1.AdmissionSketch) {
+	u.admission = admission
+}
+
+func (u *Uploader) createHeader(entries map[string]*v1.IndexEntry, modCache *v1.ModCache, outputs []*v1.ActionsOutput, outputSize int64) ([]byte, error) {
 	actionsCache := &v1.ActionsCache{
 		Entries:         entries,
 		Outputs:         outputs,
 		OutputTotalSize: outputSize,
+		ModCache:        modCache,
+		Admission:       u.admission,
 	}
 
 	protobufBuf, err := proto.Marshal(actionsCache)
@@ -230,6 +381,14 @@ func (u *Uploader) createHeader(entries map[string]*v1.IndexEntry, outputs []*v1
 		return nil, fmt.Errorf("marshal actions cache: %w", err)
 	}
 
+	if u.signer != nil && u.signer.CanSign() {
+		protobufBuf = appendSignature(protobufBuf, u.signer)
+	}
+
+	if u.cipher != nil {
+		protobufBuf = u.cipher.SealHeader(protobufBuf)
+	}
+
 	buf := make([]byte, 8, 8+len(protobufBuf))
 	binary.BigEndian.PutUint64(buf, uint64(len(protobufBuf)))
 	buf = append(buf, protobufBuf...)
@@ -237,12 +396,146 @@ func (u *Uploader) createHeader(entries map[string]*v1.IndexEntry, outputs []*v1
 	return buf, nil
 }
 
+func (u *Uploader) getHeaderBlockID() (string, error) {
+	u.headerBlockIDLocker.Lock()
+	defer u.headerBlockIDLocker.Unlock()
+
+	if u.headerBlockID == "" {
+		headerBlockID, err := u.generateBlockID()
+		if err != nil {
+			return "", err
+		}
+		u.headerBlockID = headerBlockID
+	}
+
+	return u.headerBlockID, nil
+}
+
+// waitBase waits for the base blob to be copied, giving up when ctx is done.
+func (u *Uploader) waitBase(ctx context.Context) ([]string, int64, []*v1.ActionsOutput, error) {
+	type result struct {
+		baseBlockIDs   []string
+		baseOutputSize int64
+		baseOutputs    []*v1.ActionsOutput
+		err            error
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		var r result
+		r.baseBlockIDs, r.baseOutputSize, r.baseOutputs, r.err = u.waitBaseFunc()
+		resultCh <- r
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.baseBlockIDs, r.baseOutputSize, r.baseOutputs, r.err
+	case <-ctx.Done():
+		return nil, 0, nil, fmt.Errorf("wait for base: %w", context.Cause(ctx))
+	}
+}
+
+// blobExpiresAt returns the time every entry of the blob has expired,
+// or false when an entry or the module cache never expires.
+func blobExpiresAt(entries map[string]*v1.IndexEntry, modCache *v1.ModCache) (time.Time, bool) {
+	if len(entries) == 0 || len(modCache.GetFiles()) > 0 {
+		return time.Time{}, false
+	}
+
+	var expiresAt time.Time
+	for _, entry := range entries {
+		if entry.GetExpiresAt() == nil {
+			return time.Time{}, false
+		}
+		if t := entry.GetExpiresAt().AsTime(); t.After(expiresAt) {
+			expiresAt = t
+		}
+	}
+
+	return expiresAt, true
+}
+
+// filterEntries drops the entries whose output is not in the blob nor inline,
+// e.g. because its upload failed or was abandoned at the commit deadline.
+func (u *Uploader) filterEntries(entries map[string]*v1.IndexEntry, outputs []*v1.ActionsOutput) map[string]*v1.IndexEntry {
+	outputMap := make(map[string]struct{}, len(outputs))
+	for _, output := range outputs {
+		outputMap[output.Id] = struct{}{}
+	}
+
+	filtered := make(map[string]*v1.IndexEntry, len(entries))
+	var omitted []string
+	for actionID, entry := range entries {
+		if _, ok := outputMap[entry.OutputId]; ok || remote.IsInline(entry) {
+			filtered[actionID] = entry
+			continue
+		}
+		omitted = append(omitted, actionID)
+	}
+	u.logOmitted(entries, omitted)
+
+	return filtered
+}
+
+// maxOmittedLogged is the number of the largest entries left out of the commit that are logged one by one.
+const maxOmittedLogged = 10
+
+// logOmitted logs the entries left out of the commit as their outputs were not uploaded, e.g. at the commit deadline,
+// the largest of them one by one, so that the outputs to leave out of the remote cache or to upload earlier can be told.
+func (u *Uploader) logOmitted(entries map[string]*v1.IndexEntry, omitted []string) {
+	if len(omitted) == 0 {
+		return
+	}
+
+	var size int64
+	for _, actionID := range omitted {
+		size += entries[actionID].GetSize()
+	}
+	u.logger.Infof("%d entries (%d bytes) are not committed because their outputs were not uploaded", len(omitted), size)
+
+	slices.SortFunc(omitted, func(a, b string) int {
+		return cmp.Or(cmp.Compare(entries[b].GetSize(), entries[a].GetSize()), cmp.Compare(a, b))
+	})
+	for i, actionID := range omitted {
+		entry := entries[actionID]
+		if i < maxOmittedLogged {
+			u.logger.Infof("not committed: action %s, output %s, %d bytes, kind %s", actionID, entry.GetOutputId(), entry.GetSize(), entry.GetKind())
+		} else {
+			u.logger.Debugf("not committed: action %s, output %s, %d bytes, kind %s", actionID, entry.GetOutputId(), entry.GetSize(), entry.GetKind())
+		}
+	}
+}
+
+// filterModCache drops the files of the module cache whose output is not in the blob, like filterEntries.
+func (u *Uploader) filterModCache(modCache *v1.ModCache, outputs []*v1.ActionsOutput) *v1.ModCache {
+	if len(modCache.GetFiles()) == 0 {
+		return nil
+	}
+
+	outputMap := make(map[string]struct{}, len(outputs))
+	for _, output := range outputs {
+		outputMap[output.Id] = struct{}{}
+	}
+
+	files := make(map[string]*v1.ModFile, len(modCache.Files))
+	for path, file := range modCache.Files {
+		if _, ok := outputMap[file.OutputId]; ok {
+			files[path] = file
+		}
+	}
+	if dropped := len(modCache.Files) - len(files); dropped > 0 {
+		u.logger.Infof("%d files of the module cache are not committed because they were not uploaded", dropped)
+	}
+
+	return &v1.ModCache{Files: files}
+}
+
 func (u *Uploader) Commit(ctx context.Context, entries map[string]*v1.IndexEntry) error {
 	if u.client == nil {
 		return nil
 	}
 
-	baseBlockIDs, baseOutputSize, baseOutputs, err := u.waitBaseFunc()
+	baseBlockIDs, baseOutputSize, baseOutputs, err := u.waitBase(ctx)
 	if err != nil {
 		u.logger.Warnf("failed to upload base: %v", err)
 		baseBlockIDs = nil
@@ -250,31 +543,91 @@ func (u *Uploader) Commit(ctx context.Context, entries map[string]*v1.IndexEntry
 		baseOutputs = []*v1.ActionsOutput{}
 	}
 
-	newOutputIDs, outputs, outputSize := u.constructOutputs(baseOutputSize, baseOutputs)
-
-	headerBuf, err := u.createHeader(entries, outputs, outputSize)
-	if err != nil {
-		return fmt.Errorf("create header: %w", err)
+	if err := u.flushBatch(ctx); err != nil {
+		u.logger.Warnf("failed to upload the last batch of small outputs: %v", err)
 	}
 
-	headerBlockID, err := u.generateBlockID()
+	headerBlockID, err := u.getHeaderBlockID()
 	if err != nil {
 		return fmt.Errorf("generate header block ID: %w", err)
 	}
 
-	_, err = u.client.UploadBlock(ctx, headerBlockID, myio.NopSeekCloser(bytes.NewReader(headerBuf)))
-	if err != nil {
-		return fmt.Errorf("upload header: %w", err)
+	sizes := u.stagedSizes()
+	if base := u.base.Load(); base != nil {
+		for _, r := range base.ranges {
+			sizes[r.blockID] = r.size
+		}
+	}
+
+	var (
+		blockIDs   []string
+		commitSize int64
+		lost       = map[string]struct{}{}
+	)
+	for check := 1; ; check++ {
+		newBlockIDs, outputs, outputSize := u.constructOutputs(baseOutputSize, baseOutputs, u.newBlockBudget(int64(len(baseBlockIDs))), lost)
+
+		committedEntries := u.filterEntries(entries, outputs)
+		modCache := u.filterModCache(u.modCache, outputs)
+
+		headerBuf, err := u.createHeader(committedEntries, modCache, outputs, outputSize)
+		if err != nil {
+			return fmt.Errorf("create header: %w", err)
+		}
+
+		report.RemoteIONanos.Stopwatch(func() {
+			_, err = u.client.UploadBlock(ctx, headerBlockID, myio.NopSeekCloser(bytes.NewReader(headerBuf)))
+		})
+		if err != nil {
+			return fmt.Errorf("upload header: %w", err)
+		}
+		report.UploadedBytes.Add(int64(len(headerBuf)))
+		report.UploadedRawBytes.Add(int64(len(headerBuf)))
+		sizes[headerBlockID] = int64(len(headerBuf))
+
+		if expiringClient, ok := u.client.(ExpiringUploadClient); ok && policy.CurrentExpiry() != nil && policy.CurrentExpiry().Lifecycle {
+			if expiresAt, ok := blobExpiresAt(committedEntries, modCache); ok {
+				expiringClient.SetExpiry(expiresAt)
+			}
+		}
+
+		blockIDs = make([]string, 0, len(newBlockIDs)+len(baseBlockIDs)+1)
+		blockIDs = append(blockIDs, headerBlockID)
+		blockIDs = append(blockIDs, baseBlockIDs...)
+		blockIDs = append(blockIDs, newBlockIDs...)
+		commitSize = int64(len(headerBuf)) + outputSize
+
+		// A block lost or truncated by the storage would leave a hole in the blob, shifting every output after it.
+		missing, err := u.checkStaged(ctx, blockIDs, sizes)
+		if err != nil {
+			return fmt.Errorf("check staged blocks: %w", err)
+		}
+		if len(missing) == 0 {
+			break
+		}
+		report.AddEvent("upload", fmt.Sprintf("%d staged blocks missing before the commit", len(missing)))
+		if check == stagedBlockChecks {
+			return fmt.Errorf("%d blocks are still not staged after %d checks, e.g. %s. the blob is not committed", len(missing), check, missing[0])
+		}
+		u.logger.Warnf("%d of the %d blocks to commit are missing or truncated in the storage. staging them again", len(missing), len(blockIDs))
+		if err := u.restageBlocks(ctx, missing, headerBlockID, lost); err != nil {
+			return fmt.Errorf("stage missing blocks: %w", err)
+		}
 	}
 
-	blockIDs := make([]string, 0, len(newOutputIDs)+2)
-	blockIDs = append(blockIDs, headerBlockID)
-	blockIDs = append(blockIDs, baseBlockIDs...)
-	blockIDs = append(blockIDs, newOutputIDs...)
-	err = u.client.Commit(ctx, blockIDs, int64(len(headerBuf))+outputSize)
+	report.RemoteIONanos.Stopwatch(func() {
+		err = u.client.Commit(ctx, blockIDs, commitSize)
+	})
 	if err != nil {
 		return fmt.Errorf("commit: %w", errors.Join(err, context.Cause(ctx)))
 	}
 
+	if shardedClient, ok := u.client.(ShardedUploadClient); ok {
+		// The shard is already committed, so it is restorable even when the merge fails.
+		if err := u.mergeShards(ctx, shardedClient); err != nil {
+			u.logger.Warnf("failed to merge the shards of the cache entry: %v", err)
+		}
+	}
+
 	return nil
 }
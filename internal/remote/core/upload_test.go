@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"slices"
 	"testing"
 	"time"
@@ -295,7 +297,7 @@ func TestNewUploader(t *testing.T) {
 
 			var baseProvider BaseBlobProvider = provider
 
-			uploader := NewUploader(t.Context(), log.DefaultLogger, client, baseProvider)
+			uploader := NewUploader(t.Context(), log.DefaultLogger, client, baseProvider, nil, nil)
 			if uploader == nil {
 				t.Fatal("uploader is nil")
 			}
@@ -342,9 +344,9 @@ func TestUploader_UploadOutput(t *testing.T) {
 		{
 			name:     "success",
 			outputID: "test-output",
-			size:     100,
+			size:     maxBatchedOutputSize + 1,
 			setupMock: func(client *mockUploadClient) (io.ReadSeekCloser, error) {
-				data := make([]byte, 100)
+				data := make([]byte, maxBatchedOutputSize+1)
 				compressedData, err := zstd.Compress(nil, data)
 				if err != nil {
 					return nil, err
@@ -356,9 +358,9 @@ func TestUploader_UploadOutput(t *testing.T) {
 		{
 			name:     "size mismatch",
 			outputID: "test-output",
-			size:     100,
+			size:     maxBatchedOutputSize + 1,
 			setupMock: func(client *mockUploadClient) (io.ReadSeekCloser, error) {
-				data := make([]byte, 50)
+				data := make([]byte, maxBatchedOutputSize/2)
 				compressedData, err := zstd.Compress(nil, data)
 				if err != nil {
 					return nil, err
@@ -370,9 +372,9 @@ func TestUploader_UploadOutput(t *testing.T) {
 		{
 			name:     "upload error",
 			outputID: "test-output",
-			size:     100,
+			size:     maxBatchedOutputSize + 1,
 			setupMock: func(client *mockUploadClient) (io.ReadSeekCloser, error) {
-				data := make([]byte, 100)
+				data := make([]byte, maxBatchedOutputSize+1)
 				compressedData, err := zstd.Compress(nil, data)
 				if err != nil {
 					return nil, err
@@ -382,6 +384,15 @@ func TestUploader_UploadOutput(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name:     "small output is batched",
+			outputID: "test-output",
+			size:     100,
+			setupMock: func(*mockUploadClient) (io.ReadSeekCloser, error) {
+				// No UploadBlock is expected until the batch is full.
+				return myio.NopSeekCloser(bytes.NewReader(make([]byte, 100))), nil
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -390,7 +401,7 @@ func TestUploader_UploadOutput(t *testing.T) {
 			t.Parallel()
 
 			client := &mockUploadClient{}
-			uploader := NewUploader(t.Context(), log.DefaultLogger, client, &mockBaseBlobProvider{})
+			uploader := NewUploader(t.Context(), log.DefaultLogger, client, &mockBaseBlobProvider{}, nil, nil)
 
 			reader, err := tt.setupMock(client)
 			if err != nil {
@@ -443,7 +454,7 @@ func TestUploader_Commit(t *testing.T) {
 				client.expectUploadBlockFromURL(0, 100, nil)
 				client.expectAnyUploadBlock(50, nil)
 				client.expectCommit(nil)
-				return NewUploader(ctx, log.DefaultLogger, client, provider)
+				return NewUploader(ctx, log.DefaultLogger, client, provider, nil, nil)
 			},
 		},
 		{
@@ -463,20 +474,16 @@ func TestUploader_Commit(t *testing.T) {
 				client.expectAnyUploadBlock(50, nil)
 				client.expectCommit(nil)
 
-				uploader := NewUploader(ctx, log.DefaultLogger, client, provider)
-				uploader.outputs = []*v1.ActionsOutput{
-					{
-						Id:          "new-output",
-						Offset:      100,
-						Size:        150,
-						Compression: v1.Compression_COMPRESSION_ZSTD,
-					},
-				}
+				uploader := NewUploader(ctx, log.DefaultLogger, client, provider, nil, nil)
+				uploader.outputs.append(&v1.ActionsOutput{
+					Id:          "new-output",
+					Offset:      100,
+					Size:        150,
+					Compression: v1.Compression_COMPRESSION_ZSTD,
+				})
 				return uploader
 			},
 			validateState: func(t *testing.T, u *Uploader) {
-				u.outputsLocker.RLock()
-				defer u.outputsLocker.RUnlock()
 				if diff := cmp.Diff([]*v1.ActionsOutput{
 					{
 						Id:          "new-output",
@@ -484,7 +491,7 @@ func TestUploader_Commit(t *testing.T) {
 						Size:        150,
 						Compression: v1.Compression_COMPRESSION_ZSTD,
 					},
-				}, u.outputs, cmpopts.IgnoreUnexported(v1.ActionsOutput{})); diff != "" {
+				}, u.outputs.items(), cmpopts.IgnoreUnexported(v1.ActionsOutput{})); diff != "" {
 					t.Errorf("outputs mismatch (-want +got):\n%s", diff)
 				}
 			},
@@ -505,7 +512,7 @@ func TestUploader_Commit(t *testing.T) {
 				client.expectUploadBlockFromURL(0, 100, nil)
 				client.expectAnyUploadBlock(50, nil)
 				client.expectCommit(errors.New("commit error"))
-				return NewUploader(ctx, log.DefaultLogger, client, provider)
+				return NewUploader(ctx, log.DefaultLogger, client, provider, nil, nil)
 			},
 			expectError: true,
 		},
@@ -615,7 +622,7 @@ func TestUploader_createHeader(t *testing.T) {
 
 			uploader := &Uploader{}
 
-			header, err := uploader.createHeader(tt.entries, tt.outputs, tt.outputSize)
+			header, err := uploader.createHeader(tt.entries, nil, tt.outputs, tt.outputSize)
 			if tt.expectError {
 				if err == nil {
 					t.Error("expected error, got nil")
@@ -849,11 +856,12 @@ func TestUploader_constructOutputs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			uploader := &Uploader{
-				outputs: tt.outputs,
+			uploader := &Uploader{}
+			for _, output := range tt.outputs {
+				uploader.outputs.append(output)
 			}
 
-			gotOutputIDs, gotOutputs, gotOffset := uploader.constructOutputs(tt.baseOutputSize, tt.baseOutputs)
+			gotOutputIDs, gotOutputs, gotOffset := uploader.constructOutputs(tt.baseOutputSize, tt.baseOutputs, maxBlocks, nil)
 
 			if diff := cmp.Diff(tt.wantOutputIDs, gotOutputIDs); diff != "" {
 				t.Errorf("output IDs mismatch (-want +got):\n%s", diff)
@@ -869,3 +877,143 @@ func TestUploader_constructOutputs(t *testing.T) {
 		})
 	}
 }
+
+func TestUploader_filterEntries(t *testing.T) {
+	t.Parallel()
+
+	uploader := &Uploader{logger: log.DefaultLogger}
+	entries := map[string]*v1.IndexEntry{
+		"uploaded":   {OutputId: "output", Size: 10},
+		"base":       {OutputId: "base", Size: 10},
+		"abandoned":  {OutputId: "missing", Size: 10},
+		"same-other": {OutputId: "output", Size: 10},
+		"inline":     {OutputId: "inline", Size: 3, InlineOutput: []byte("abc")},
+		"empty":      {OutputId: "empty"},
+	}
+	outputs := []*v1.ActionsOutput{{Id: "base"}, {Id: "output"}}
+
+	got := uploader.filterEntries(entries, outputs)
+
+	want := []string{"base", "empty", "inline", "same-other", "uploaded"}
+	gotKeys := slices.Sorted(maps.Keys(got))
+	if !slices.Equal(gotKeys, want) {
+		t.Errorf("entries: got %v, want %v", gotKeys, want)
+	}
+}
+
+func TestBlobExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	early := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		entries  map[string]*v1.IndexEntry
+		modCache *v1.ModCache
+		want     time.Time
+		wantOK   bool
+	}{
+		{name: "empty"},
+		{
+			name: "latest expiry",
+			entries: map[string]*v1.IndexEntry{
+				"a": {ExpiresAt: timestamppb.New(early)},
+				"b": {ExpiresAt: timestamppb.New(late)},
+			},
+			want:   late,
+			wantOK: true,
+		},
+		{
+			name: "entry without expiry",
+			entries: map[string]*v1.IndexEntry{
+				"a": {ExpiresAt: timestamppb.New(early)},
+				"b": {},
+			},
+		},
+		{
+			name:     "module cache",
+			entries:  map[string]*v1.IndexEntry{"a": {ExpiresAt: timestamppb.New(early)}},
+			modCache: &v1.ModCache{Files: map[string]*v1.ModFile{"cache/download/m.zip": {OutputId: "m"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := blobExpiresAt(tt.entries, tt.modCache)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("got (%v, %t), want (%v, %t)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUploader_batch(t *testing.T) {
+	t.Parallel()
+
+	client := &mockUploadClient{}
+	client.expectAnyUploadBlock(maxUploadChunkSize, nil)
+	uploader := NewUploader(t.Context(), log.DefaultLogger, client, &mockBaseBlobProvider{}, nil, nil)
+
+	const outputSize = maxBatchedOutputSize
+	outputsPerBatch := maxUploadChunkSize / outputSize
+	for i := range outputsPerBatch + 1 {
+		err := uploader.addToBatch(t.Context(), fmt.Sprintf("output-%d", i), bytes.NewReader(make([]byte, outputSize)), v1.Compression_COMPRESSION_ZSTD)
+		if err != nil {
+			t.Fatalf("add output %d: %v", i, err)
+		}
+	}
+
+	if n := len(uploader.batches.items()); n != 1 {
+		t.Fatalf("batches: got %d, want 1", n)
+	}
+	if err := uploader.flushBatch(t.Context()); err != nil {
+		t.Fatalf("flush batch: %v", err)
+	}
+	batches := uploader.batches.items()
+	if len(batches) != 2 {
+		t.Fatalf("batches: got %d, want 2", len(batches))
+	}
+
+	blockIDs, outputs, offset := uploader.constructOutputs(100, []*v1.ActionsOutput{{Id: "base", Size: 100}}, maxBlocks, nil)
+
+	wantBlockIDs := []string{batches[0].blockID, batches[1].blockID}
+	if diff := cmp.Diff(wantBlockIDs, blockIDs); diff != "" {
+		t.Errorf("block IDs mismatch (-want +got):\n%s", diff)
+	}
+
+	wantOutputs := []*v1.ActionsOutput{{Id: "base", Size: 100}}
+	for i := range outputsPerBatch + 1 {
+		wantOutputs = append(wantOutputs, &v1.ActionsOutput{
+			Id:          fmt.Sprintf("output-%d", i),
+			Offset:      100 + int64(i)*outputSize,
+			Size:        outputSize,
+			Compression: v1.Compression_COMPRESSION_ZSTD,
+		})
+	}
+	if diff := cmp.Diff(wantOutputs, outputs, protocmp.Transform()); diff != "" {
+		t.Errorf("outputs mismatch (-want +got):\n%s", diff)
+	}
+
+	if want := 100 + int64(outputsPerBatch+1)*outputSize; offset != want {
+		t.Errorf("offset: got %d, want %d", offset, want)
+	}
+}
+
+// BenchmarkUploader_UploadOutputParallel measures the bookkeeping of outputs uploaded by parallel puts,
+// which used to queue on a mutex of the Uploader.
+func BenchmarkUploader_UploadOutputParallel(b *testing.B) {
+	uploader := NewUploader(b.Context(), log.DefaultLogger, &mockUploadClient{}, &mockBaseBlobProvider{}, nil, nil)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			// An empty output is not sent, so only the bookkeeping is measured.
+			if err := uploader.UploadOutput(b.Context(), "output", 0, myio.NopSeekCloser(bytes.NewReader(nil))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/zstd"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote"
+	"golang.org/x/sync/errgroup"
+)
+
+// Problem is an inconsistency found by Verify.
+// OutputID or ActionID is empty when the problem is not about a single output or entry.
+type Problem struct {
+	ActionID string
+	OutputID string
+	Message  string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.ActionID != "":
+		return fmt.Sprintf("entry %s: %s", p.ActionID, p.Message)
+	case p.OutputID != "":
+		return fmt.Sprintf("output %s: %s", p.OutputID, p.Message)
+	default:
+		return p.Message
+	}
+}
+
+// verifyConcurrency is the number of outputs downloaded at the same time by Verify.
+const verifyConcurrency = 16
+
+// Verify checks the header of the blob, then downloads every output on its own
+// and compares the SHA-256 of its content with the output ID.
+// Outputs are downloaded one by one, so that a corrupt output does not hide the others.
+func (d *Downloader) Verify(ctx context.Context) ([]Problem, error) {
+	if d.client == nil {
+		return nil, nil
+	}
+
+	if d.rejected != nil {
+		return []Problem{{Message: d.rejected.Error()}}, nil
+	}
+
+	problems := d.verifyHeader()
+
+	var problemsLocker sync.Mutex
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(verifyConcurrency)
+	for _, output := range d.header.Outputs {
+		eg.Go(func() error {
+			if message, ok := d.verifyOutput(ctx, output); !ok {
+				problemsLocker.Lock()
+				defer problemsLocker.Unlock()
+				problems = append(problems, Problem{OutputID: output.Id, Message: message})
+			}
+
+			return ctx.Err()
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, fmt.Errorf("verify outputs: %w", err)
+	}
+
+	slices.SortFunc(problems, func(x, y Problem) int {
+		return strings.Compare(x.String(), y.String())
+	})
+
+	return problems, nil
+}
+
+func (d *Downloader) verifyHeader() []Problem {
+	var problems []Problem
+
+	outputs := slices.Clone(d.header.Outputs)
+	slices.SortFunc(outputs, func(x, y *v1.ActionsOutput) int {
+		return int(x.Offset - y.Offset)
+	})
+
+	outputMap := make(map[string]*v1.ActionsOutput, len(outputs))
+	var offset int64
+	for _, output := range outputs {
+		if _, ok := outputMap[output.Id]; ok {
+			problems = append(problems, Problem{OutputID: output.Id, Message: "duplicated in the header"})
+		}
+		outputMap[output.Id] = output
+
+		if output.Offset != offset {
+			problems = append(problems, Problem{OutputID: output.Id, Message: fmt.Sprintf("offset %d, expected %d", output.Offset, offset)})
+		}
+		offset = output.Offset + output.Size
+	}
+	if offset != d.header.OutputTotalSize {
+		problems = append(problems, Problem{Message: fmt.Sprintf("outputs end at %d, but the total size is %d", offset, d.header.OutputTotalSize)})
+	}
+
+	for actionID, entry := range d.header.Entries {
+		if remote.IsInline(entry) {
+			if message, ok := verifyInline(entry); !ok {
+				problems = append(problems, Problem{ActionID: actionID, Message: message})
+			}
+			continue
+		}
+
+		if _, ok := outputMap[entry.OutputId]; !ok {
+			problems = append(problems, Problem{ActionID: actionID, Message: fmt.Sprintf("output %s is not in the blob", entry.OutputId)})
+		}
+	}
+
+	for path, file := range d.header.GetModCache().GetFiles() {
+		if _, ok := outputMap[file.OutputId]; !ok {
+			problems = append(problems, Problem{Message: fmt.Sprintf("module cache file %s: output %s is not in the blob", path, file.OutputId)})
+		}
+	}
+
+	return problems
+}
+
+// hashWriter hashes and counts the content of an output.
+type hashWriter struct {
+	h    hash.Hash
+	size int64
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	return w.h.Write(p)
+}
+
+func (w *hashWriter) Close() error {
+	return nil
+}
+
+// verifyInline reports whether the inline output of entry matches its output ID.
+func verifyInline(entry *v1.IndexEntry) (string, bool) {
+	want, err := base64.StdEncoding.DecodeString(entry.OutputId)
+	if err != nil || len(want) != sha256.Size {
+		return "output ID is not a base64 encoded SHA-256", false
+	}
+
+	if got := sha256.Sum256(entry.InlineOutput); !bytes.Equal(got[:], want) {
+		return fmt.Sprintf("checksum mismatch: inline output hashes to %s (%d bytes)", base64.StdEncoding.EncodeToString(got[:]), len(entry.InlineOutput)), false
+	}
+
+	return "", true
+}
+
+// verifyOutput downloads an output and reports whether its content matches the output ID.
+func (d *Downloader) verifyOutput(ctx context.Context, output *v1.ActionsOutput) (string, bool) {
+	want, err := base64.StdEncoding.DecodeString(output.Id)
+	if err != nil || len(want) != sha256.Size {
+		return "output ID is not a base64 encoded SHA-256", false
+	}
+
+	hw := &hashWriter{h: sha256.New()}
+	var w io.WriteCloser = hw
+	if output.Compression == v1.Compression_COMPRESSION_ZSTD {
+		w = zstd.NewDecompressWriter(hw)
+	}
+	if d.cipher != nil && output.Size > 0 {
+		w = d.cipher.NewDecryptWriter(w, []byte(output.Id))
+	}
+
+	if output.Size > 0 {
+		if err := d.client.DownloadBlock(ctx, d.headerSize+output.Offset, output.Size, w); err != nil {
+			return fmt.Sprintf("unreadable: %v", err), false
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Sprintf("decode: %v", err), false
+	}
+
+	if got := hw.h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Sprintf("checksum mismatch: content hashes to %s (%d bytes)", base64.StdEncoding.EncodeToString(got), hw.size), false
+	}
+
+	return "", true
+}
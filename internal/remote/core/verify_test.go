@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// blobDownloadClient serves ranges of an in-memory blob.
+type blobDownloadClient struct {
+	blob []byte
+}
+
+func (c *blobDownloadClient) GetURL(context.Context) string {
+	return ""
+}
+
+func (c *blobDownloadClient) DownloadBlock(_ context.Context, offset int64, size int64, w io.Writer) error {
+	if offset+size > int64(len(c.blob)) {
+		return fmt.Errorf("out of range: offset=%d, size=%d", offset, size)
+	}
+	_, err := w.Write(c.blob[offset : offset+size])
+	return err
+}
+
+func (c *blobDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	return c.DownloadBlock(ctx, offset, size, bytes.NewBuffer(buf[:0]))
+}
+
+func outputIDOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func buildBlob(t *testing.T, entries map[string]*v1.IndexEntry, outputs []*v1.ActionsOutput, contents [][]byte) []byte {
+	t.Helper()
+
+	var body []byte
+	for _, content := range contents {
+		body = append(body, content...)
+	}
+
+	header, err := proto.Marshal(&v1.ActionsCache{
+		Entries:         entries,
+		Outputs:         outputs,
+		OutputTotalSize: int64(len(body)),
+	})
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+
+	blob := binary.BigEndian.AppendUint64(nil, uint64(len(header)))
+	blob = append(blob, header...)
+
+	return append(blob, body...)
+}
+
+func TestDownloader_Verify(t *testing.T) {
+	good := []byte("good output")
+	compressed, err := zstd.Compress(nil, []byte("compressed output"))
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	corrupt := []byte("corrupt output")
+
+	goodID := outputIDOf(good)
+	compressedID := outputIDOf([]byte("compressed output"))
+	corruptID := outputIDOf([]byte("original output"))
+
+	tests := []struct {
+		name     string
+		entries  map[string]*v1.IndexEntry
+		outputs  []*v1.ActionsOutput
+		contents [][]byte
+		want     []string
+	}{
+		{
+			name: "valid",
+			entries: map[string]*v1.IndexEntry{
+				"action":        {OutputId: goodID, Size: int64(len(good))},
+				"inline action": {OutputId: outputIDOf([]byte("inline")), Size: 6, InlineOutput: []byte("inline")},
+				"empty action":  {OutputId: outputIDOf(nil)},
+			},
+			outputs: []*v1.ActionsOutput{
+				{Id: goodID, Offset: 0, Size: int64(len(good))},
+				{Id: compressedID, Offset: int64(len(good)), Size: int64(len(compressed)), Compression: v1.Compression_COMPRESSION_ZSTD},
+			},
+			contents: [][]byte{good, compressed},
+			want:     []string{},
+		},
+		{
+			name: "checksum mismatch and missing output",
+			entries: map[string]*v1.IndexEntry{
+				"action":        {OutputId: "missing", Size: 7},
+				"inline action": {OutputId: goodID, Size: 6, InlineOutput: []byte("inline")},
+			},
+			outputs: []*v1.ActionsOutput{
+				{Id: corruptID, Offset: 0, Size: int64(len(corrupt))},
+			},
+			contents: [][]byte{corrupt},
+			want: []string{
+				"entry action: output missing is not in the blob",
+				fmt.Sprintf("entry inline action: checksum mismatch: inline output hashes to %s (6 bytes)", outputIDOf([]byte("inline"))),
+				fmt.Sprintf("output %s: checksum mismatch: content hashes to %s (%d bytes)", corruptID, outputIDOf(corrupt), len(corrupt)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &blobDownloadClient{blob: buildBlob(t, tt.entries, tt.outputs, tt.contents)}
+
+			downloader, err := NewDownloader(context.Background(), log.DefaultLogger, client, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			problems, err := downloader.Verify(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := make([]string, 0, len(problems))
+			for _, problem := range problems {
+				got = append(got, problem.String())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("problems mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package fault injects latencies, errors and truncated transfers into the remote cache,
+// so that the degraded mode, the retries and the stall detection can be exercised in CI without a flaky remote.
+// It is enabled by the dev flags of a binary built with -tags dev, and the wrappers are no-ops otherwise.
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is wrapped by the errors injected.
+var ErrInjected = errors.New("injected fault")
+
+// Config is the faults to inject into every call to the remote cache.
+type Config struct {
+	// Latency is the mean delay added to a call. The delays are uniform between 0 and twice it.
+	Latency time.Duration
+	// ErrorRate is the probability of a call failing with ErrInjected before it reaches the remote.
+	ErrorRate float64
+	// TruncateRate is the probability of the data of a transfer being cut in the middle with io.ErrUnexpectedEOF.
+	TruncateRate float64
+	// Seed makes the faults of a run reproducible. 0 picks a random seed.
+	Seed uint64
+}
+
+func (c *Config) validate() error {
+	if c.Latency < 0 {
+		return fmt.Errorf("latency must not be negative: %s", c.Latency)
+	}
+	for name, rate := range map[string]float64{"error rate": c.ErrorRate, "truncate rate": c.TruncateRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1: %g", name, rate)
+		}
+	}
+
+	return nil
+}
+
+var current atomic.Pointer[injector]
+
+// Enable injects the faults of config into the clients and backends wrapped after it.
+func Enable(config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	current.Store(&injector{
+		config: config,
+		//nolint:gosec
+		rand: rand.New(rand.NewPCG(seed, seed)),
+	})
+
+	return nil
+}
+
+// Enabled reports whether faults are injected.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+type injector struct {
+	config Config
+
+	locker sync.Mutex
+	rand   *rand.Rand
+}
+
+func (i *injector) float64() float64 {
+	i.locker.Lock()
+	defer i.locker.Unlock()
+
+	return i.rand.Float64()
+}
+
+// call delays a call and fails it at the error rate.
+func (i *injector) call(ctx context.Context, operation string) error {
+	if i.config.Latency > 0 {
+		timer := time.NewTimer(time.Duration(i.float64() * float64(2*i.config.Latency)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if i.config.ErrorRate > 0 && i.float64() < i.config.ErrorRate {
+		return fmt.Errorf("%w: %s", ErrInjected, operation)
+	}
+
+	return nil
+}
+
+// truncateAt returns the bytes of size after which a transfer is cut, or -1 when it is not.
+func (i *injector) truncateAt(size int64) int64 {
+	if i.config.TruncateRate <= 0 || size <= 0 || i.float64() >= i.config.TruncateRate {
+		return -1
+	}
+
+	return size / 2
+}
+
+// truncatedReadSeeker ends its reads at limit with io.ErrUnexpectedEOF.
+type truncatedReadSeeker struct {
+	io.ReadSeeker
+	limit int64
+	read  int64
+}
+
+func (t *truncatedReadSeeker) Read(p []byte) (int, error) {
+	if t.read >= t.limit {
+		return 0, fmt.Errorf("%w: %w", ErrInjected, io.ErrUnexpectedEOF)
+	}
+
+	if int64(len(p)) > t.limit-t.read {
+		p = p[:t.limit-t.read]
+	}
+	n, err := t.ReadSeeker.Read(p)
+	t.read += int64(n)
+
+	return n, err
+}
+
+func (t *truncatedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := t.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		t.read = pos
+	}
+
+	return pos, err
+}
+
+// truncateReader cuts r at the truncate rate.
+func (i *injector) truncateReader(r io.ReadSeeker, size int64) io.ReadSeeker {
+	limit := i.truncateAt(size)
+	if limit < 0 {
+		return r
+	}
+
+	return &truncatedReadSeeker{ReadSeeker: r, limit: limit}
+}
@@ -0,0 +1,123 @@
+package fault
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "zero", config: Config{}},
+		{name: "every fault", config: Config{Latency: time.Millisecond, ErrorRate: 0.5, TruncateRate: 1}},
+		{name: "negative latency", config: Config{Latency: -time.Millisecond}, wantErr: true},
+		{name: "error rate above 1", config: Config{ErrorRate: 1.5}, wantErr: true},
+		{name: "negative truncate rate", config: Config{TruncateRate: -0.1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newTestInjector(config Config) *injector {
+	return &injector{config: config, rand: rand.New(rand.NewPCG(1, 1))}
+}
+
+func TestInjector_call(t *testing.T) {
+	t.Parallel()
+
+	if err := newTestInjector(Config{ErrorRate: 1}).call(context.Background(), "op"); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected ErrInjected, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newTestInjector(Config{Latency: time.Hour}).call(ctx, "op"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the latency to end with the context, got %v", err)
+	}
+
+	if err := newTestInjector(Config{}).call(context.Background(), "op"); err != nil {
+		t.Errorf("expected no fault, got %v", err)
+	}
+}
+
+// memoryDownloadClient downloads from data.
+type memoryDownloadClient struct {
+	data string
+}
+
+func (c *memoryDownloadClient) GetURL(context.Context) string {
+	return "memory"
+}
+
+func (c *memoryDownloadClient) DownloadBlock(_ context.Context, offset int64, size int64, w io.Writer) error {
+	_, err := io.WriteString(w, c.data[offset:offset+size])
+	return err
+}
+
+func (c *memoryDownloadClient) DownloadBlockBuffer(_ context.Context, offset int64, size int64, buf []byte) error {
+	copy(buf, c.data[offset:offset+size])
+	return nil
+}
+
+func TestDownloadClient_truncate(t *testing.T) {
+	t.Parallel()
+
+	client := &downloadClient{
+		DownloadClient: &memoryDownloadClient{data: "0123456789"},
+		injector:       newTestInjector(Config{TruncateRate: 1}),
+	}
+
+	var buf bytes.Buffer
+	err := client.DownloadBlock(context.Background(), 2, 8, &buf)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+	if buf.String() != "2345" {
+		t.Errorf("downloaded: got %q, want %q", buf.String(), "2345")
+	}
+}
+
+func TestTruncatedReadSeeker(t *testing.T) {
+	t.Parallel()
+
+	r := &truncatedReadSeeker{ReadSeeker: strings.NewReader("0123456789"), limit: 5}
+
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrInjected) {
+		t.Errorf("expected an injected unexpected EOF, got %v", err)
+	}
+	if string(got) != "01234" {
+		t.Errorf("read: got %q, want %q", got, "01234")
+	}
+
+	// A retry seeking back to the start is cut at the same place.
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF after seeking, got %v", err)
+	}
+	if buf.String() != "01234" {
+		t.Errorf("read after seeking: got %q, want %q", buf.String(), "01234")
+	}
+}
@@ -0,0 +1,213 @@
+package fault
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
+	"github.com/mazrean/gocica/internal/remote"
+	"github.com/mazrean/gocica/internal/remote/core"
+)
+
+// WrapBackend returns b injecting the faults into its calls, or b itself when faults are not enabled.
+// The faults of the clients under b are injected by WrapDownloadClient and WrapUploadClient.
+func WrapBackend(b *core.Backend) remote.Backend {
+	i := current.Load()
+	if i == nil {
+		return b
+	}
+
+	return &backend{OnDemandBackend: b, injector: i}
+}
+
+type backend struct {
+	remote.OnDemandBackend
+	injector *injector
+}
+
+func (b *backend) MetaData(ctx context.Context) (map[string]*v1.IndexEntry, error) {
+	if err := b.injector.call(ctx, "metadata"); err != nil {
+		return nil, err
+	}
+
+	return b.OnDemandBackend.MetaData(ctx)
+}
+
+func (b *backend) WriteMetaData(ctx context.Context, metaDataMap map[string]*v1.IndexEntry) error {
+	if err := b.injector.call(ctx, "write metadata"); err != nil {
+		return err
+	}
+
+	return b.OnDemandBackend.WriteMetaData(ctx, metaDataMap)
+}
+
+func (b *backend) Put(ctx context.Context, objectID string, size int64, r io.ReadSeeker) error {
+	if err := b.injector.call(ctx, "put "+objectID); err != nil {
+		return err
+	}
+
+	return b.OnDemandBackend.Put(ctx, objectID, size, b.injector.truncateReader(r, size))
+}
+
+func (b *backend) Fetch(ctx context.Context, outputID string) (string, error) {
+	if err := b.injector.call(ctx, "fetch "+outputID); err != nil {
+		return "", err
+	}
+
+	return b.OnDemandBackend.Fetch(ctx, outputID)
+}
+
+// WrapDownloadClient returns c injecting the faults into its calls, or c itself when faults are not enabled or c is nil.
+func WrapDownloadClient(c core.DownloadClient) core.DownloadClient {
+	i := current.Load()
+	if i == nil || c == nil {
+		return c
+	}
+
+	return &downloadClient{DownloadClient: c, injector: i}
+}
+
+type downloadClient struct {
+	core.DownloadClient
+	injector *injector
+}
+
+func (c *downloadClient) Capabilities() remote.Capabilities {
+	return core.ClientCapabilities(c.DownloadClient)
+}
+
+func (c *downloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	if err := c.injector.call(ctx, "download block"); err != nil {
+		return err
+	}
+
+	limit := c.injector.truncateAt(size)
+	if limit < 0 {
+		return c.DownloadClient.DownloadBlock(ctx, offset, size, w)
+	}
+
+	if err := c.DownloadClient.DownloadBlock(ctx, offset, limit, w); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: download block: %w", ErrInjected, io.ErrUnexpectedEOF)
+}
+
+func (c *downloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	if err := c.injector.call(ctx, "download block buffer"); err != nil {
+		return err
+	}
+
+	limit := c.injector.truncateAt(size)
+	if limit < 0 {
+		return c.DownloadClient.DownloadBlockBuffer(ctx, offset, size, buf)
+	}
+
+	if err := c.DownloadClient.DownloadBlockBuffer(ctx, offset, limit, buf[:limit]); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: download block buffer: %w", ErrInjected, io.ErrUnexpectedEOF)
+}
+
+// WrapUploadClient returns c injecting the faults into its calls, or c itself when faults are not enabled or c is nil.
+// A core.ShardedUploadClient stays one, and the clients of its merge are wrapped too.
+func WrapUploadClient(c core.UploadClient) core.UploadClient {
+	i := current.Load()
+	if i == nil || c == nil {
+		return c
+	}
+
+	wrapped := &uploadClient{UploadClient: c, injector: i}
+	if sharded, ok := c.(core.ShardedUploadClient); ok {
+		return &shardedUploadClient{uploadClient: wrapped, sharded: sharded}
+	}
+
+	return wrapped
+}
+
+type uploadClient struct {
+	core.UploadClient
+	injector *injector
+}
+
+func (c *uploadClient) Capabilities() remote.Capabilities {
+	return core.ClientCapabilities(c.UploadClient)
+}
+
+func (c *uploadClient) UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	if err := c.injector.call(ctx, "upload block "+blockID); err != nil {
+		return 0, err
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("seek to end: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek to start: %w", err)
+	}
+
+	limit := c.injector.truncateAt(size)
+	if limit < 0 {
+		return c.UploadClient.UploadBlock(ctx, blockID, r)
+	}
+
+	return c.UploadClient.UploadBlock(ctx, blockID, readSeekCloser{
+		ReadSeeker: &truncatedReadSeeker{ReadSeeker: r, limit: limit},
+		Closer:     r,
+	})
+}
+
+func (c *uploadClient) UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error {
+	if err := c.injector.call(ctx, "upload block from url "+blockID); err != nil {
+		return err
+	}
+
+	return c.UploadClient.UploadBlockFromURL(ctx, blockID, url, offset, size)
+}
+
+func (c *uploadClient) StagedBlocks(ctx context.Context) (map[string]int64, error) {
+	if err := c.injector.call(ctx, "staged blocks"); err != nil {
+		return nil, err
+	}
+
+	return core.StagedBlocks(ctx, c.UploadClient)
+}
+
+func (c *uploadClient) Commit(ctx context.Context, blockIDs []string, size int64) error {
+	if err := c.injector.call(ctx, "commit"); err != nil {
+		return err
+	}
+
+	return c.UploadClient.Commit(ctx, blockIDs, size)
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+type shardedUploadClient struct {
+	*uploadClient
+	sharded core.ShardedUploadClient
+}
+
+func (c *shardedUploadClient) Merger(ctx context.Context) (core.UploadClient, []core.DownloadClient, error) {
+	if err := c.injector.call(ctx, "merger"); err != nil {
+		return nil, nil, err
+	}
+
+	mergeClient, shardClients, err := c.sharded.Merger(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedShardClients := make([]core.DownloadClient, 0, len(shardClients))
+	for _, shardClient := range shardClients {
+		wrappedShardClients = append(wrappedShardClients, WrapDownloadClient(shardClient))
+	}
+
+	return WrapUploadClient(mergeClient), wrappedShardClients, nil
+}
@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/storage"
+	"github.com/mazrean/gocica/log"
+)
+
+// ExecScheme is the prefix of --remote selecting a plugin process, e.g. exec:/usr/local/bin/gocica-objstore.
+const ExecScheme = "exec:"
+
+// execCommand returns the command of the plugin given by --remote.
+func execCommand(config *HubConfig) ([]string, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: missing settings: remote exec:<command> (GOCICA_REMOTE)", ErrInvalidConfig)
+	}
+
+	command, ok := strings.CutPrefix(config.URL, ExecScheme)
+	if !ok {
+		return nil, fmt.Errorf("%w: remote must be exec:<command>: %s", ErrInvalidConfig, config.URL)
+	}
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: empty plugin command", ErrInvalidConfig)
+	}
+	if config.ReadURL != "" {
+		return nil, fmt.Errorf("%w: a read replica is only supported by the http backend", ErrInvalidConfig)
+	}
+
+	return args, nil
+}
+
+// ExecProvider returns the providers of the clients of the plugin.
+// The plugin is started once by the first of them, with the options of the registered backends.
+func ExecProvider(logger log.Logger, command []string) (DownloadClientProvider, UploadClientProvider) {
+	start := sync.OnceValues(func() (*storage.ExecPlugin, error) {
+		backendConfigLocker.Lock()
+		options := backendConfig.Options
+		backendConfigLocker.Unlock()
+
+		plugin, err := storage.StartExecPlugin(logger, command, options)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return plugin, nil
+	})
+
+	downloadClientProvider := func(ctx context.Context) (core.DownloadClient, error) {
+		plugin, err := start()
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := plugin.FindBlob(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if client == nil {
+			logger.Infof("cache not found by the plugin. building without cache.")
+			return nil, nil
+		}
+
+		return client, nil
+	}
+	uploadClientProvider := func(context.Context) (core.UploadClient, error) {
+		plugin, err := start()
+		if err != nil {
+			return nil, err
+		}
+		if plugin.ReadOnly() {
+			logger.Warnf("the plugin can only read caches. uploads are disabled for this run.")
+			return nil, nil
+		}
+
+		return storage.NewExecUploadClient(plugin), nil
+	}
+
+	return downloadClientProvider, uploadClientProvider
+}
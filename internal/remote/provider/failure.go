@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// FailureClass is the class of a failure to initialize the remote backend.
+type FailureClass string
+
+const (
+	// FailureConfig is a missing or invalid setting, which never recovers by retrying.
+	FailureConfig FailureClass = "config"
+	// FailureAuth is a token or a signed URL rejected by the remote.
+	FailureAuth FailureClass = "auth"
+	// FailureQuota is the rate limit or the storage quota of the remote.
+	FailureQuota FailureClass = "quota"
+	// FailureNetwork is a remote that cannot be reached in time.
+	FailureNetwork FailureClass = "network"
+	FailureUnknown FailureClass = "unknown"
+)
+
+var (
+	// ErrInvalidConfig is wrapped by the errors of missing or invalid settings.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrUnauthorized is wrapped by the errors of tokens the remote does not accept.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// ClassifyFailure returns the class of an error returned while initializing the remote backend.
+func ClassifyFailure(err error) FailureClass {
+	var (
+		responseErr *azcore.ResponseError
+		netErr      net.Error
+	)
+	isResponseErr := errors.As(err, &responseErr)
+
+	switch {
+	case errors.Is(err, ErrInvalidConfig):
+		return FailureConfig
+	case errors.Is(err, ErrUnauthorized),
+		isResponseErr && (responseErr.StatusCode == http.StatusUnauthorized || responseErr.StatusCode == http.StatusForbidden):
+		return FailureAuth
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrRateLimited),
+		isResponseErr && responseErr.StatusCode == http.StatusTooManyRequests:
+		return FailureQuota
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return FailureNetwork
+	default:
+		return FailureUnknown
+	}
+}
+
+// Hint returns what to do about a failure of the class.
+func (c FailureClass) Hint() string {
+	switch c {
+	case FailureConfig:
+		return "fix the settings. gocica doctor lists what is missing."
+	case FailureAuth:
+		return "the remote rejected the credentials. ACTIONS_RUNTIME_TOKEN is only valid in the job that issued it."
+	case FailureQuota:
+		return "the remote is rate limited or out of storage. gocica prune deletes stale entries."
+	case FailureNetwork:
+		return "the remote is unreachable. it is usually a transient outage."
+	default:
+		return "please report it with the log."
+	}
+}
@@ -0,0 +1,41 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want FailureClass
+	}{
+		{name: "missing settings", err: validateGHACacheConfig(&GHACacheConfig{}), want: FailureConfig},
+		{name: "rejected token", err: fmt.Errorf("github backend: %w: %w", ErrUnauthorized, errors.New("token expired")), want: FailureAuth},
+		{name: "expired signed URL", err: fmt.Errorf("read header: %w", &azcore.ResponseError{StatusCode: http.StatusForbidden}), want: FailureAuth},
+		{name: "quota", err: fmt.Errorf("create cache entry: %w", ErrQuotaExceeded), want: FailureQuota},
+		{name: "rate limit", err: fmt.Errorf("create cache entry: %w", ErrRateLimited), want: FailureQuota},
+		{name: "connection refused", err: fmt.Errorf("do request: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), want: FailureNetwork},
+		{name: "timeout", err: fmt.Errorf("download: %w", context.DeadlineExceeded), want: FailureNetwork},
+		{name: "unknown", err: errors.New("unexpected status code: 500"), want: FailureUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := ClassifyFailure(tt.err); got != tt.want {
+				t.Errorf("ClassifyFailure(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
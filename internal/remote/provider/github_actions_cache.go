@@ -9,10 +9,14 @@ import (
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mazrean/gocica/internal/pkg/json"
 	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
 	"github.com/mazrean/gocica/internal/remote/core"
 	"github.com/mazrean/gocica/internal/remote/storage"
 	"github.com/mazrean/gocica/log"
@@ -22,9 +26,30 @@ import (
 type GHACacheConfig struct {
 	Token    string
 	CacheURL string
+	// Service is the version of the cache service at CacheURL. "" is the v2 service.
+	Service  CacheServiceVersion
 	RunnerOS string
 	Ref      string
 	Sha      string
+	// PRIsolation writes the cache of this run to the pull request namespace,
+	// which builds outside of pull requests never restore from.
+	PRIsolation bool
+	// RunID and RunAttempt identify the workflow run.
+	// They make a run-unique key when another job already created the entry of this run's key.
+	RunID      string
+	RunAttempt string
+	// JobIndex and JobTotal coordinate the jobs of a matrix sharing the key.
+	// When JobTotal is more than 1, each job uploads its own shard and the last one to finish merges them into the entry of the key.
+	JobIndex int
+	JobTotal int
+	// Retry is how the calls to the cache service are retried.
+	Retry GHARetryPolicy
+	// Variant is the namespace of the build variant, e.g. from -race and CGO_ENABLED.
+	// The keys of a variant never restore from the others. "" is the namespace of the default variant.
+	Variant string
+	// Scope is the name of the cache of this process, e.g. build, test or lint for the steps of a job invoking the go command differently.
+	// The keys of a scope never restore from the others, while the scopes share the local store. "" is the default scope.
+	Scope string
 }
 
 func GHACacheProvider(
@@ -32,25 +57,63 @@ func GHACacheProvider(
 	logger log.Logger,
 	config *GHACacheConfig,
 ) (DownloadClientProvider, UploadClientProvider, error) {
+	if err := checkCacheService(config); err != nil {
+		return nil, nil, err
+	}
+
 	cacheClient, err := newGitHubCacheClient(
 		ctx,
 		logger,
 		config.Token,
 		config.CacheURL,
-		config.RunnerOS,
+		variantRunnerOS(scopeRunnerOS(config.RunnerOS, config.Scope), config.Variant),
 		config.Ref,
 		config.Sha,
+		config.PRIsolation,
+		config.RunID,
+		config.RunAttempt,
+		config.Retry,
 	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("create github cache client: %w", err)
 	}
 
+	if config.JobTotal > 1 && (config.JobIndex < 0 || config.JobIndex >= config.JobTotal) {
+		return nil, nil, fmt.Errorf("%w: job index %d is out of the %d jobs", ErrInvalidConfig, config.JobIndex, config.JobTotal)
+	}
+
 	uploadClientProvider := func(ctx context.Context) (core.UploadClient, error) {
-		uploadURL, err := cacheClient.createCacheEntry(ctx)
+		key, _ := cacheClient.blobKey()
+		if config.JobTotal > 1 {
+			return shardUploadClient(ctx, cacheClient, key, config.JobIndex, config.JobTotal)
+		}
+
+		uploadURL, err := cacheClient.createCacheEntry(ctx, key)
+		if errors.Is(err, ErrAlreadyExists) {
+			// Another job of the same commit, e.g. of a matrix, won the race for the key.
+			// The outputs of this job are still worth keeping, and restore keys also match the run-unique key.
+			runKey, ok := cacheClient.runKey(key)
+			if !ok {
+				logger.Infof("cache entry already exists. skipping upload.")
+
+				return nil, nil
+			}
+
+			logger.Infof("cache entry already exists. uploading to %s instead.", runKey)
+			key = runKey
+			uploadURL, err = cacheClient.createCacheEntry(ctx, key)
+		}
 		switch {
 		case errors.Is(err, ErrAlreadyExists):
 			logger.Infof("cache entry already exists. skipping upload.")
 
+			return nil, nil
+		case errors.Is(err, ErrQuotaExceeded):
+			// Restoring still works, so only the upload is given up instead of the whole remote cache.
+			// There is no secondary backend to fall back to.
+			logger.Warnf("GitHub Actions Cache refused a new entry: %v. skipping upload.", err)
+			report.AddEvent("github", "upload skipped: "+err.Error())
+
 			return nil, nil
 		case err != nil:
 			return nil, fmt.Errorf("create cache entry: %w", err)
@@ -61,14 +124,11 @@ func GHACacheProvider(
 			return nil, fmt.Errorf("create azure upload client: %w", err)
 		}
 
-		return &ghaCacheUploadClientWrapper{
-			UploadClient: storageUploadClient,
-			client:       cacheClient,
-		}, nil
+		return newGHACacheUploadClientWrapper(storageUploadClient, cacheClient, key), nil
 	}
 
 	downloadClientProvider := func(ctx context.Context) (core.DownloadClient, error) {
-		downloadURL, err := cacheClient.getDownloadURL(ctx)
+		downloadURL, matchedKey, err := cacheClient.getDownloadURL(ctx)
 		if err != nil {
 			logger.Debugf("get download url: %v", err)
 			logger.Infof("cache not found. building without cache.")
@@ -81,37 +141,279 @@ func GHACacheProvider(
 			return nil, fmt.Errorf("create azure download client: %w", err)
 		}
 
-		return storageDownloadClient, nil
+		return &GHACacheDownloadClient{
+			DownloadClient: storageDownloadClient,
+			key:            matchedKey,
+		}, nil
 	}
 
 	return downloadClientProvider, uploadClientProvider, nil
 }
 
+// GHACacheEntry returns a download client of the cache entry of exactly key, without the restore keys of the configuration.
+// It fails with ErrCacheNotFound when only an entry of another key starting with key is found.
+func GHACacheEntry(ctx context.Context, logger log.Logger, config *GHACacheConfig, key string) (*GHACacheDownloadClient, error) {
+	cacheClient, err := newGitHubEntryClient(ctx, logger, config)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadURL, matchedKey, err := cacheClient.getDownloadURLFor(ctx, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get cache entry %s: %w", key, err)
+	}
+	if matchedKey != key {
+		return nil, fmt.Errorf("%w: %s, found %s", ErrCacheNotFound, key, matchedKey)
+	}
+
+	storageDownloadClient, err := storage.NewAzureDownloadClient(downloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("create azure download client: %w", err)
+	}
+
+	return &GHACacheDownloadClient{
+		DownloadClient: storageDownloadClient,
+		key:            matchedKey,
+	}, nil
+}
+
+// GHACacheNewEntry creates the cache entry of exactly key, and returns the upload client of its blob.
+// It fails with ErrAlreadyExists when the entry of key exists, as the entries are immutable.
+func GHACacheNewEntry(ctx context.Context, logger log.Logger, config *GHACacheConfig, key string) (core.UploadClient, error) {
+	cacheClient, err := newGitHubEntryClient(ctx, logger, config)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadURL, err := cacheClient.createCacheEntry(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("create cache entry %s: %w", key, err)
+	}
+
+	storageUploadClient, err := storage.NewAzureUploadClient(uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("create azure upload client: %w", err)
+	}
+
+	return newGHACacheUploadClientWrapper(storageUploadClient, cacheClient, key), nil
+}
+
+// newGitHubEntryClient creates the client of the cache service for the entries of explicit keys,
+// whose keys are not derived from the runner OS, the scope and the variant of the configuration.
+func newGitHubEntryClient(ctx context.Context, logger log.Logger, config *GHACacheConfig) (*ghaCacheClient, error) {
+	if err := validateGHACacheConfig(config); err != nil {
+		return nil, err
+	}
+
+	cacheClient, err := newGitHubCacheClient(
+		ctx,
+		logger,
+		config.Token,
+		config.CacheURL,
+		config.RunnerOS,
+		config.Ref,
+		config.Sha,
+		config.PRIsolation,
+		config.RunID,
+		config.RunAttempt,
+		config.Retry,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create github cache client: %w", err)
+	}
+
+	return cacheClient, nil
+}
+
+var _ core.DownloadClient = (*GHACacheDownloadClient)(nil)
+
+// GHACacheDownloadClient is a download client of the cache entry matched by the key or the restore keys.
+type GHACacheDownloadClient struct {
+	core.DownloadClient
+	key string
+}
+
+// Key returns the key of the matched cache entry.
+func (c *GHACacheDownloadClient) Key() string {
+	return c.key
+}
+
+// ETag returns the version of the blob of the entry, or "" when its client gives none.
+func (c *GHACacheDownloadClient) ETag(ctx context.Context) (string, error) {
+	etagClient, ok := c.DownloadClient.(core.ETagDownloadClient)
+	if !ok {
+		return "", nil
+	}
+
+	return etagClient.ETag(ctx)
+}
+
 var _ core.UploadClient = (*ghaCacheUploadClientWrapper)(nil)
 
 type ghaCacheUploadClientWrapper struct {
 	core.UploadClient
 	client *ghaCacheClient
+	key    string
+
+	// committedSize is the size of the blob whose block list is committed, or -1 before it.
+	// A retried Commit only finalizes the entry of the committed blob,
+	// as the blob can no longer be replaced once the entry is finalized and the sizes must match.
+	committedLocker sync.Mutex
+	committedSize   int64
+	finalized       bool
+}
+
+func newGHACacheUploadClientWrapper(uploadClient core.UploadClient, client *ghaCacheClient, key string) *ghaCacheUploadClientWrapper {
+	return &ghaCacheUploadClientWrapper{
+		UploadClient:  uploadClient,
+		client:        client,
+		key:           key,
+		committedSize: -1,
+	}
 }
 
+// StagedBlocks returns the blocks staged by the client of the storage of the entry.
+func (w *ghaCacheUploadClientWrapper) StagedBlocks(ctx context.Context) (map[string]int64, error) {
+	return core.StagedBlocks(ctx, w.UploadClient)
+}
+
+// Commit commits the block list and finalizes the cache entry.
+// It is safe to call again after an error: the steps already done are skipped.
 func (w *ghaCacheUploadClientWrapper) Commit(ctx context.Context, blockIDs []string, size int64) error {
-	if err := w.UploadClient.Commit(ctx, blockIDs, size); err != nil {
-		return fmt.Errorf("commit upload client: %w", err)
+	w.committedLocker.Lock()
+	defer w.committedLocker.Unlock()
+
+	if w.finalized {
+		return nil
 	}
 
-	if err := w.client.commitCacheEntry(ctx, size); err != nil {
+	if w.committedSize < 0 {
+		if err := w.UploadClient.Commit(ctx, blockIDs, size); err != nil {
+			return fmt.Errorf("commit upload client: %w", err)
+		}
+		w.committedSize = size
+	}
+
+	if err := w.finalize(ctx); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			report.AddEvent("github", "commit refused: "+err.Error())
+		}
 		return fmt.Errorf("commit cache entry: %w", err)
 	}
+	w.finalized = true
 
 	return nil
 }
 
+// finalize finalizes the cache entry. doRequest retries the transient failures,
+// so that an error at the very end of the build does not lose the whole cache.
+func (w *ghaCacheUploadClientWrapper) finalize(ctx context.Context) error {
+	err := w.client.commitCacheEntry(ctx, w.key, w.committedSize)
+	if errors.Is(err, ErrAlreadyExists) {
+		// An earlier attempt finalized the entry, but its response was lost.
+		w.client.logger.Debugf("cache entry is already finalized: %v", err)
+		return nil
+	}
+
+	return err
+}
+
+// shardUploadClient returns the upload client of the shard of this job.
+func shardUploadClient(ctx context.Context, cacheClient *ghaCacheClient, key string, jobIndex, jobTotal int) (core.UploadClient, error) {
+	shardKeys := cacheClient.shardKeys(key, jobTotal)
+
+	uploadURL, err := cacheClient.createCacheEntry(ctx, shardKeys[jobIndex])
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		cacheClient.logger.Infof("shard of the cache entry already exists. skipping upload.")
+
+		return nil, nil
+	case errors.Is(err, ErrQuotaExceeded):
+		cacheClient.logger.Warnf("GitHub Actions Cache refused a new entry: %v. skipping upload.", err)
+		report.AddEvent("github", "upload skipped: "+err.Error())
+
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("create shard cache entry: %w", err)
+	}
+
+	storageUploadClient, err := storage.NewAzureUploadClient(uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("create azure upload client: %w", err)
+	}
+
+	return &ghaCacheShardUploadClient{
+		ghaCacheUploadClientWrapper: newGHACacheUploadClientWrapper(storageUploadClient, cacheClient, shardKeys[jobIndex]),
+		mergeKey:                    key,
+		shardKeys:                   shardKeys,
+	}, nil
+}
+
+var _ core.ShardedUploadClient = (*ghaCacheShardUploadClient)(nil)
+
+// ghaCacheShardUploadClient uploads the shard of a job of a matrix.
+// Creating the entry of the merged key is the leader election: only one of the jobs seeing every shard wins it.
+type ghaCacheShardUploadClient struct {
+	*ghaCacheUploadClientWrapper
+	mergeKey  string
+	shardKeys []string
+}
+
+func (w *ghaCacheShardUploadClient) Merger(ctx context.Context) (core.UploadClient, []core.DownloadClient, error) {
+	shardClients := make([]core.DownloadClient, 0, len(w.shardKeys))
+	for _, shardKey := range w.shardKeys {
+		downloadURL, matchedKey, err := w.client.getDownloadURLFor(ctx, shardKey, []string{})
+		if err != nil || matchedKey != shardKey {
+			// The jobs still running see this shard when they finish, and the last of them merges.
+			w.client.logger.Debugf("shard %s is not committed yet: %v", shardKey, err)
+
+			return nil, nil, nil
+		}
+
+		storageDownloadClient, err := storage.NewAzureDownloadClient(downloadURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create azure download client: %w", err)
+		}
+		shardClients = append(shardClients, storageDownloadClient)
+	}
+
+	uploadURL, err := w.client.createCacheEntry(ctx, w.mergeKey)
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		return nil, nil, nil
+	case err != nil:
+		return nil, nil, fmt.Errorf("create cache entry: %w", err)
+	}
+
+	storageUploadClient, err := storage.NewAzureUploadClient(uploadURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create azure upload client: %w", err)
+	}
+
+	return newGHACacheUploadClientWrapper(storageUploadClient, w.client, w.mergeKey), shardClients, nil
+}
+
 const (
 	actionsCacheBasePath  = "/twirp/github.actions.results.api.v1.CacheService/"
 	actionsCachePrefix    = "gocica-cache"
 	actionsCacheSeparator = "-"
+	// actionsCachePRNamespace separates the caches of isolated pull requests.
+	actionsCachePRNamespace = "pr"
+	// actionsCacheRunNamespace marks the run-unique keys used when the key of the run is already taken.
+	actionsCacheRunNamespace = "run"
+	// actionsCacheJobNamespace marks the keys of the shards of the jobs of a matrix.
+	actionsCacheJobNamespace = "job"
+	// actionsCacheVariantSeparator joins the build variant to the runner OS in the keys.
+	actionsCacheVariantSeparator = "."
+	// actionsCacheScopeSeparator joins the cache scope to the runner OS in the keys.
+	actionsCacheScopeSeparator = "@"
+	// maxScopeLength is the longest name of a cache scope.
+	maxScopeLength = 32
 )
 
+// ActionsCacheKeyPrefix is the prefix of every cache key created by gocica.
+const ActionsCacheKeyPrefix = actionsCachePrefix + actionsCacheSeparator
+
 // actionsCacheVersion is sha256 of the context.
 // upstream uses paths in actionsCacheVersion, we don't seem to have anything that is unique like this.
 // so we use the sha256 of "gocica-cache-1.0" as a actionsCacheVersion.
@@ -120,9 +422,15 @@ var actionsCacheVersion = "5eb02eebd0c9b2a428c370e552c7c895ea26154c726235db0a053
 var (
 	ErrCacheNotFound = errors.New("cache not found")
 	ErrAlreadyExists = errors.New("cache already exists")
+	// ErrQuotaExceeded is returned when the cache service rejects a request because of the storage quota.
+	ErrQuotaExceeded = errors.New("cache quota exceeded")
+	// ErrRateLimited is returned when the cache service rejects a request because of its rate limit.
+	// Unlike the quota, it passes, so the calls are retried after the wait asked.
+	ErrRateLimited = errors.New("cache rate limit exceeded")
+	errServerError = errors.New("cache service error")
 )
 
-var githubAPILatencyGauge = metrics.NewGauge("github_cache_api_latency")
+var githubAPILatencyHistogram = metrics.NewHistogram("github_cache_api_latency")
 
 // ghaCacheClient handles GitHub Actions Cache API calls.
 // This is a standalone client that doesn't depend on GitHubActionsCache.
@@ -133,6 +441,11 @@ type ghaCacheClient struct {
 	runnerOS   string
 	ref        string
 	sha        string
+	isolated   bool
+	runID      string
+	runAttempt string
+	// retryPolicy is how the calls are retried, with the defaults for its zero fields.
+	retryPolicy GHARetryPolicy
 }
 
 // newGitHubCacheClient creates a new GitHub Cache API client.
@@ -143,6 +456,9 @@ func newGitHubCacheClient(
 	strBaseURL string,
 	runnerOS string,
 	ref, sha string,
+	isolated bool,
+	runID, runAttempt string,
+	retryPolicy GHARetryPolicy,
 ) (*ghaCacheClient, error) {
 	baseURL, err := url.Parse(strBaseURL)
 	if err != nil {
@@ -155,20 +471,105 @@ func newGitHubCacheClient(
 	}))
 
 	return &ghaCacheClient{
-		logger:     logger,
-		httpClient: httpClient,
-		baseURL:    baseURL,
-		runnerOS:   runnerOS,
-		ref:        ref,
-		sha:        sha,
+		logger:      logger,
+		httpClient:  httpClient,
+		baseURL:     baseURL,
+		runnerOS:    runnerOS,
+		ref:         ref,
+		sha:         sha,
+		isolated:    isolated,
+		runID:       runID,
+		runAttempt:  runAttempt,
+		retryPolicy: retryPolicy,
 	}, nil
 }
 
+// variantRunnerOS returns the runner OS segment of the keys of the build variant.
+// The variant is kept in the segment, so that the restore keys of a variant, which end with the separator after it, never match the keys of another.
+func variantRunnerOS(runnerOS, variant string) string {
+	if variant == "" {
+		return runnerOS
+	}
+
+	return runnerOS + actionsCacheVariantSeparator + variant
+}
+
+// scopeRunnerOS returns the runner OS segment of the keys of the cache scope.
+// Like the variant, the scope is kept in the segment, so that the restore keys of a scope never match the keys of another.
+func scopeRunnerOS(runnerOS, scope string) string {
+	if scope == "" {
+		return runnerOS
+	}
+
+	return runnerOS + actionsCacheScopeSeparator + scope
+}
+
+// ValidateScope checks that scope is a valid name of a cache scope: letters, digits and underscores only,
+// so that it cannot run into the separators of the keys.
+func ValidateScope(scope string) error {
+	if len(scope) > maxScopeLength {
+		return fmt.Errorf("%w: scope %q is longer than %d characters", ErrInvalidConfig, scope, maxScopeLength)
+	}
+	for _, r := range scope {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			return fmt.Errorf("%w: scope %q has %q, only letters, digits and underscores are allowed", ErrInvalidConfig, scope, r)
+		}
+	}
+
+	return nil
+}
+
 // blobKey returns the cache key and restore keys for this configuration.
+// Isolated runs use the pull request namespace for their key and fall back to the caches outside of it,
+// while the other runs never restore from the pull request namespace.
 func (c *ghaCacheClient) blobKey() (string, []string) {
-	baseKey := actionsCachePrefix + actionsCacheSeparator + c.runnerOS
+	if !c.isolated {
+		return blobKeyIn(actionsCachePrefix, c.runnerOS, c.ref, c.sha)
+	}
+
+	key, restoreKeys := blobKeyIn(actionsCachePrefix+actionsCacheSeparator+actionsCachePRNamespace, c.runnerOS, c.ref, c.sha)
+	// The prefix shared by every pull request is left out, so that pull requests with different refs do not restore from each other.
+	restoreKeys = restoreKeys[:1]
+
+	_, trunkRestoreKeys := blobKeyIn(actionsCachePrefix, c.runnerOS, c.ref, c.sha)
+
+	return key, append(restoreKeys, trunkRestoreKeys...)
+}
+
+// runKey returns the key unique to this run attempt, derived from key.
+// It reports false when the run is unknown.
+func (c *ghaCacheClient) runKey(key string) (string, bool) {
+	if c.runID == "" {
+		return "", false
+	}
+
+	runAttempt := c.runAttempt
+	if runAttempt == "" {
+		runAttempt = "1"
+	}
+
+	return key + actionsCacheSeparator + actionsCacheRunNamespace + actionsCacheSeparator + c.runID + actionsCacheSeparator + runAttempt, true
+}
+
+// shardKeys returns the keys of the shards of the jobs sharing key.
+// They are unique to the run attempt when it is known, so that a rerun does not collide with the shards of the previous attempt.
+func (c *ghaCacheClient) shardKeys(key string, jobTotal int) []string {
+	if runKey, ok := c.runKey(key); ok {
+		key = runKey
+	}
+
+	shardKeys := make([]string, 0, jobTotal)
+	for i := range jobTotal {
+		shardKeys = append(shardKeys, key+actionsCacheSeparator+actionsCacheJobNamespace+actionsCacheSeparator+strconv.Itoa(i))
+	}
+
+	return shardKeys
+}
+
+func blobKeyIn(prefix, runnerOS, ref, sha string) (string, []string) {
+	baseKey := prefix + actionsCacheSeparator + runnerOS
 	restoreKeys := make([]string, 0, 2)
-	for _, k := range []string{c.ref, c.sha} {
+	for _, k := range []string{ref, sha} {
 		baseKey += actionsCacheSeparator
 		restoreKeys = append(restoreKeys, baseKey)
 		baseKey += k
@@ -178,6 +579,7 @@ func (c *ghaCacheClient) blobKey() (string, []string) {
 	return baseKey, restoreKeys
 }
 
+// doRequest calls the endpoint, retrying transient failures and rate limits with the retry policy.
 func (c *ghaCacheClient) doRequest(ctx context.Context, endpoint string, reqBody any, respBody any) error {
 	buf := &bytes.Buffer{}
 	err := json.NewEncoder(buf).Encode(reqBody)
@@ -187,14 +589,21 @@ func (c *ghaCacheClient) doRequest(ctx context.Context, endpoint string, reqBody
 
 	c.logger.Debugf("do request: endpoint=%s, body=%s", endpoint, buf.String())
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL.JoinPath(endpoint).String(), buf)
+	return c.retry(ctx, endpoint, func() error {
+		return c.doRequestOnce(ctx, endpoint, buf.Bytes(), respBody)
+	})
+}
+
+func (c *ghaCacheClient) doRequestOnce(ctx context.Context, endpoint string, reqBody []byte, respBody any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL.JoinPath(endpoint).String(), bytes.NewReader(reqBody))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	var res *http.Response
-	githubAPILatencyGauge.Stopwatch(func() {
+	report.AddAPICall("github", endpoint)
+	githubAPILatencyHistogram.Stopwatch(func() {
 		res, err = c.httpClient.Do(req)
 	}, endpoint)
 	if err != nil {
@@ -209,12 +618,28 @@ func (c *ghaCacheClient) doRequest(ctx context.Context, endpoint string, reqBody
 			return fmt.Errorf("copy response body: %w", err)
 		}
 
+		wait, rateLimited := rateLimitWait(res.Header, time.Now())
 		switch res.StatusCode {
 		case http.StatusNotFound:
 			return fmt.Errorf("%w: %s", ErrCacheNotFound, sb.String())
 		case http.StatusConflict:
 			return fmt.Errorf("%w: %s", ErrAlreadyExists, sb.String())
+		case http.StatusTooManyRequests:
+			return &rateLimitError{err: fmt.Errorf("%w (status %d): %s", ErrRateLimited, res.StatusCode, sb.String()), wait: wait}
+		case http.StatusForbidden:
+			// A secondary rate limit is a forbidden response telling when to retry.
+			if rateLimited {
+				return &rateLimitError{err: fmt.Errorf("%w (status %d): %s", ErrRateLimited, res.StatusCode, sb.String()), wait: wait}
+			}
+			return fmt.Errorf("%w (status %d): %s", ErrUnauthorized, res.StatusCode, sb.String())
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%w (status %d): %s", ErrUnauthorized, res.StatusCode, sb.String())
+		case http.StatusRequestEntityTooLarge:
+			return fmt.Errorf("%w (status %d): %s", ErrQuotaExceeded, res.StatusCode, sb.String())
 		default:
+			if res.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("%w: status code: %d, body: %s", errServerError, res.StatusCode, sb.String())
+			}
 			return fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, sb.String())
 		}
 	}
@@ -227,8 +652,14 @@ func (c *ghaCacheClient) doRequest(ctx context.Context, endpoint string, reqBody
 }
 
 // GetDownloadURL fetches the signed download URL from GitHub Actions Cache API.
-func (c *ghaCacheClient) getDownloadURL(ctx context.Context) (string, error) {
+func (c *ghaCacheClient) getDownloadURL(ctx context.Context) (string, string, error) {
 	key, restoreKeys := c.blobKey()
+
+	return c.getDownloadURLFor(ctx, key, restoreKeys)
+}
+
+// getDownloadURLFor fetches the signed download URL of the entry matched by key or restoreKeys.
+func (c *ghaCacheClient) getDownloadURLFor(ctx context.Context, key string, restoreKeys []string) (string, string, error) {
 	c.logger.Debugf("get download url: key=%s, restoreKeys=%v", key, restoreKeys)
 
 	var res struct {
@@ -242,21 +673,20 @@ func (c *ghaCacheClient) getDownloadURL(ctx context.Context) (string, error) {
 		Version     string   `json:"version"`
 	}{key, restoreKeys, actionsCacheVersion}, &res)
 	if err != nil {
-		return "", fmt.Errorf("get cache entry download url: %w", err)
+		return "", "", fmt.Errorf("get cache entry download url: %w", err)
 	}
 
 	if !res.OK {
-		return "", errors.New("failed to get download url")
+		return "", "", errors.New("failed to get download url")
 	}
 
-	c.logger.Debugf("signed download url: %s", res.SignedDownloadURL)
+	c.logger.Debugf("signed download url: %s, matched key: %s", res.SignedDownloadURL, res.MatchedKey)
 
-	return res.SignedDownloadURL, nil
+	return res.SignedDownloadURL, res.MatchedKey, nil
 }
 
-// createCacheEntry creates a new cache entry and returns the signed upload URL.
-func (c *ghaCacheClient) createCacheEntry(ctx context.Context) (string, error) {
-	key, _ := c.blobKey()
+// createCacheEntry creates a new cache entry for key and returns the signed upload URL.
+func (c *ghaCacheClient) createCacheEntry(ctx context.Context, key string) (string, error) {
 	c.logger.Debugf("create cache entry: key=%s", key)
 
 	var res struct {
@@ -280,9 +710,8 @@ func (c *ghaCacheClient) createCacheEntry(ctx context.Context) (string, error) {
 	return res.SignedUploadURL, nil
 }
 
-// CommitCacheEntry finalizes the cache entry upload.
-func (c *ghaCacheClient) commitCacheEntry(ctx context.Context, size int64) error {
-	key, _ := c.blobKey()
+// CommitCacheEntry finalizes the upload of the cache entry for key.
+func (c *ghaCacheClient) commitCacheEntry(ctx context.Context, key string, size int64) error {
 	c.logger.Debugf("commit cache entry: key=%s, size=%d", key, size)
 
 	var res struct {
@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/log"
+)
+
+func TestGHACacheClient_doRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr error
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound, wantErr: ErrCacheNotFound},
+		{name: "conflict", status: http.StatusConflict, wantErr: ErrAlreadyExists},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: ErrRateLimited},
+		{name: "quota exceeded", status: http.StatusRequestEntityTooLarge, wantErr: ErrQuotaExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			baseURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("parse url: %v", err)
+			}
+
+			client := &ghaCacheClient{
+				logger:     log.DefaultLogger,
+				httpClient: server.Client(),
+				baseURL:    baseURL,
+				// The rate limited calls are retried.
+				retryPolicy: GHARetryPolicy{BaseBackoff: time.Millisecond},
+			}
+
+			err = client.doRequest(t.Context(), "CreateCacheEntry", struct{}{}, &struct{}{})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error: got %v, want %v", err, tt.wantErr)
+			}
+			// Only the quota gives up the uploads of the run.
+			if tt.wantErr != ErrQuotaExceeded && errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("error: got %v, which is not the quota", err)
+			}
+		})
+	}
+}
+
+type mockUploadClient struct {
+	core.UploadClient
+	commits int
+}
+
+func (m *mockUploadClient) Commit(context.Context, []string, int64) error {
+	m.commits++
+	return nil
+}
+
+func TestGHACacheUploadClientWrapper_Commit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "ok", statuses: []int{http.StatusOK}, wantRequests: 1},
+		{name: "transient error", statuses: []int{http.StatusBadGateway, http.StatusOK}, wantRequests: 2},
+		{name: "finalized by a retried request", statuses: []int{http.StatusBadGateway, http.StatusConflict}, wantRequests: 2},
+		{name: "attempts run out", statuses: []int{http.StatusBadGateway}, wantErr: true, wantRequests: 4},
+		{name: "finalized by a lost request", statuses: []int{http.StatusConflict}, wantRequests: 1},
+		{name: "not retried", statuses: []int{http.StatusBadRequest}, wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				i := int(requests.Add(1)) - 1
+				w.WriteHeader(tt.statuses[min(i, len(tt.statuses)-1)])
+				_, _ = w.Write([]byte(`{"ok":true}`))
+			}))
+			defer server.Close()
+
+			baseURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("parse url: %v", err)
+			}
+
+			uploadClient := &mockUploadClient{}
+			wrapper := newGHACacheUploadClientWrapper(uploadClient, &ghaCacheClient{
+				logger:      log.DefaultLogger,
+				httpClient:  server.Client(),
+				baseURL:     baseURL,
+				retryPolicy: GHARetryPolicy{BaseBackoff: time.Millisecond},
+			}, "key")
+
+			err = wrapper.Commit(t.Context(), []string{"block"}, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := int(requests.Load()); got != tt.wantRequests {
+				t.Errorf("finalize requests: got %d, want %d", got, tt.wantRequests)
+			}
+
+			// A retried commit does not commit the block list again.
+			_ = wrapper.Commit(t.Context(), []string{"block"}, 10)
+			if uploadClient.commits != 1 {
+				t.Errorf("block list commits: got %d, want 1", uploadClient.commits)
+			}
+		})
+	}
+}
+
+func TestGHACacheEntry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		response  string
+		wantErr   bool
+		wantErrIs error
+		wantKey   string
+	}{
+		{name: "exact", response: `{"ok":true,"signed_download_url":"https://example.com/blob","matched_key":"gocica-cache-Linux-main-abc"}`, wantKey: "gocica-cache-Linux-main-abc"},
+		{name: "another key", response: `{"ok":true,"signed_download_url":"https://example.com/blob","matched_key":"gocica-cache-Linux-main-abcd"}`, wantErr: true, wantErrIs: ErrCacheNotFound},
+		{name: "miss", response: `{"ok":false}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var restoreKeys atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					RestoreKeys []string `json:"restore_keys"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&req)
+				restoreKeys.Add(int64(len(req.RestoreKeys)))
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			client, err := GHACacheEntry(t.Context(), log.DefaultLogger, &GHACacheConfig{Token: "token", CacheURL: server.URL}, "gocica-cache-Linux-main-abc")
+			if restoreKeys.Load() != 0 {
+				t.Errorf("restore keys: got %d, want none", restoreKeys.Load())
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Errorf("error: got %v, want %v", err, tt.wantErrIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client.Key() != tt.wantKey {
+				t.Errorf("key: got %q, want %q", client.Key(), tt.wantKey)
+			}
+		})
+	}
+}
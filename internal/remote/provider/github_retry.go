@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/report"
+)
+
+// GHARetryPolicy is how the calls to the GitHub Actions Cache API are retried
+// after a transient failure or a rate limit.
+// The zero value of a field is its default.
+type GHARetryPolicy struct {
+	// Attempts is the number of attempts of a call, including the first one. 1 disables the retries.
+	Attempts int
+	// BaseBackoff is the wait before the first retry, doubled for each next one and jittered.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the jittered backoff.
+	MaxBackoff time.Duration
+	// MaxWait is the longest wait asked by the service with Retry-After or a rate limit reset that is waited for.
+	// A call asked to wait longer fails at once, as the build would rather go on without the cache.
+	MaxWait time.Duration
+}
+
+const (
+	defaultGHARetryAttempts    = 4
+	defaultGHARetryBaseBackoff = time.Second
+	defaultGHARetryMaxBackoff  = 30 * time.Second
+	defaultGHARetryMaxWait     = time.Minute
+)
+
+// withDefaults returns the policy with its zero fields set to their defaults.
+func (p GHARetryPolicy) withDefaults() GHARetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = defaultGHARetryAttempts
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = defaultGHARetryBaseBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultGHARetryMaxBackoff
+	}
+	if p.MaxWait <= 0 {
+		p.MaxWait = defaultGHARetryMaxWait
+	}
+
+	return p
+}
+
+// backoff returns the jittered wait before the retry following the attempt, counted from 1.
+func (p GHARetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.MaxBackoff
+	if shift := attempt - 1; shift < 32 && p.BaseBackoff<<shift < p.MaxBackoff {
+		backoff = p.BaseBackoff << shift
+	}
+
+	// The jitter keeps the jobs of a matrix rate limited at the same time from retrying together.
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// rateLimitError is a rate limited response of the service, e.g. a secondary rate limit, asking to retry after wait.
+// It wraps the error of the status.
+type rateLimitError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", e.err, e.wait)
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return e.err
+}
+
+// rateLimitWait returns the wait asked by the headers of a rate limited response,
+// from Retry-After in seconds or as an HTTP date, or from x-ratelimit-reset when no request remains.
+// It reports false when the response does not ask to wait.
+func rateLimitWait(header http.Header, now time.Time) (time.Duration, bool) {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+
+	if header.Get("X-Ratelimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-Ratelimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0), true
+		}
+	}
+
+	return 0, false
+}
+
+// isTransient reports whether a request failed in a way that may succeed when retried.
+func isTransient(err error) bool {
+	var (
+		netErr       net.Error
+		rateLimitErr *rateLimitError
+	)
+	return errors.Is(err, errServerError) || errors.As(err, &rateLimitErr) || errors.As(err, &netErr)
+}
+
+// retry calls do until it succeeds, fails for good or the attempts of the policy run out.
+// It waits for the time asked by a rate limited response, or for the jittered backoff after the other transient failures.
+func (c *ghaCacheClient) retry(ctx context.Context, endpoint string, do func() error) error {
+	policy := c.retryPolicy.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := do()
+		if err == nil || attempt >= policy.Attempts || !isTransient(err) {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+		if rateLimitErr := (*rateLimitError)(nil); errors.As(err, &rateLimitErr) {
+			if rateLimitErr.wait > policy.MaxWait {
+				return err
+			}
+			wait = max(rateLimitErr.wait, wait)
+		}
+
+		c.logger.Debugf("%s (attempt %d/%d): %v. retrying in %s", endpoint, attempt, policy.Attempts, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return errors.Join(err, context.Cause(ctx))
+		}
+		report.AddRetriedCall("github", endpoint)
+	}
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mazrean/gocica/log"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{name: "no header", header: http.Header{}},
+		{name: "retry after seconds", header: http.Header{"Retry-After": {"5"}}, wantWait: 5 * time.Second, wantOK: true},
+		{name: "retry after date", header: http.Header{"Retry-After": {now.Add(10 * time.Second).Format(http.TimeFormat)}}, wantWait: 10 * time.Second, wantOK: true},
+		{name: "retry after past date", header: http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, wantOK: true},
+		{name: "invalid retry after", header: http.Header{"Retry-After": {"soon"}}},
+		{
+			name:     "rate limit reset",
+			header:   http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)}},
+			wantWait: 30 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:   "requests remaining",
+			header: http.Header{"X-Ratelimit-Remaining": {"10"}, "X-Ratelimit-Reset": {strconv.FormatInt(now.Unix(), 10)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wait, ok := rateLimitWait(tt.header, now)
+			if wait != tt.wantWait || ok != tt.wantOK {
+				t.Errorf("got %s, %t, want %s, %t", wait, ok, tt.wantWait, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGHARetryPolicy_backoff(t *testing.T) {
+	t.Parallel()
+
+	policy := GHARetryPolicy{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}.withDefaults()
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 100: 5 * time.Second} {
+		if got := policy.backoff(attempt); got < want/2 || got > want {
+			t.Errorf("attempt %d: got %s, want in [%s, %s]", attempt, got, want/2, want)
+		}
+	}
+}
+
+func TestGHACacheClient_doRequestRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		status       int
+		header       http.Header
+		wantErr      error
+		wantRequests int
+	}{
+		{name: "server error", status: http.StatusBadGateway, wantRequests: 2},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantRequests: 2},
+		{name: "retry after", status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"0"}}, wantRequests: 2},
+		{name: "secondary rate limit", status: http.StatusForbidden, header: http.Header{"Retry-After": {"0"}}, wantRequests: 2},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: ErrUnauthorized, wantRequests: 1},
+		{name: "quota exceeded", status: http.StatusRequestEntityTooLarge, wantErr: ErrQuotaExceeded, wantRequests: 1},
+		{name: "retry after too long", status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"3600"}}, wantErr: ErrRateLimited, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if requests.Add(1) > 1 {
+					_, _ = w.Write([]byte(`{}`))
+					return
+				}
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			baseURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("parse url: %v", err)
+			}
+
+			client := &ghaCacheClient{
+				logger:      log.DefaultLogger,
+				httpClient:  server.Client(),
+				baseURL:     baseURL,
+				retryPolicy: GHARetryPolicy{BaseBackoff: time.Millisecond},
+			}
+
+			err = client.doRequest(t.Context(), "CreateCacheEntry", struct{}{}, &struct{}{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error: got %v, want %v", err, tt.wantErr)
+			}
+			if got := int(requests.Load()); got != tt.wantRequests {
+				t.Errorf("requests: got %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	myhttp "github.com/mazrean/gocica/internal/pkg/http"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/storage"
+	"github.com/mazrean/gocica/log"
+)
+
+// HubConfig is the configuration of the cache hub served by gocica serve --http.
+type HubConfig struct {
+	// URL is the base URL of the hub, e.g. http://hub:8080, or exec:<command> of a plugin.
+	URL string
+	// ReadURL is the base URL of a replica of the hub read from instead of URL, e.g. one in the region of the runner.
+	// The commits are written to URL, and the blob is read from URL when the replica has none. Empty reads from URL.
+	ReadURL string
+}
+
+// validateHubConfig checks that the URLs of the hub are absolute http(s) URLs.
+func validateHubConfig(config *HubConfig) error {
+	if config == nil || config.URL == "" {
+		return fmt.Errorf("%w: missing settings: remote URL (GOCICA_REMOTE)", ErrInvalidConfig)
+	}
+
+	if err := validateHubURL("remote URL", config.URL); err != nil {
+		return err
+	}
+	if config.ReadURL != "" {
+		if err := validateHubURL("remote read URL", config.ReadURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateHubURL(name, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: parse %s: %w", ErrInvalidConfig, name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %s must be an http(s) URL: %s", ErrInvalidConfig, name, rawURL)
+	}
+
+	return nil
+}
+
+// HubProvider returns the providers of the clients of the hub.
+// The download client reads the version of the blob latest at the time, which later commits of other jobs leave intact.
+// With a read replica, the version is read from the replica, and from the primary when the replica has none or fails.
+func HubProvider(logger log.Logger, config *HubConfig) (DownloadClientProvider, UploadClientProvider) {
+	httpClient := myhttp.NewClient()
+
+	downloadClientProvider := func(ctx context.Context) (core.DownloadClient, error) {
+		if config.ReadURL != "" {
+			blobURL, err := storage.FindHubBlob(ctx, httpClient, config.ReadURL)
+			switch {
+			case err == nil:
+				return storage.NewHubDownloadClient(httpClient, blobURL), nil
+			case errors.Is(err, storage.ErrHubBlobNotFound):
+				logger.Debugf("cache not found in the read replica. reading the primary.")
+			default:
+				logger.Warnf("find blob of the read replica: %v. reading the primary.", err)
+			}
+		}
+
+		blobURL, err := storage.FindHubBlob(ctx, httpClient, config.URL)
+		if errors.Is(err, storage.ErrHubBlobNotFound) {
+			logger.Infof("cache not found in the hub. building without cache.")
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("find blob of the hub: %w", err)
+		}
+
+		return storage.NewHubDownloadClient(httpClient, blobURL), nil
+	}
+	uploadClientProvider := func(context.Context) (core.UploadClient, error) {
+		return storage.NewHubUploadClient(httpClient, config.URL, config.ReadURL), nil
+	}
+
+	return downloadClientProvider, uploadClientProvider
+}
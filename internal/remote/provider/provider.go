@@ -2,8 +2,16 @@ package provider
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/mazrean/gocica/backend"
 	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/fault"
 	"github.com/mazrean/gocica/log"
 )
 
@@ -19,15 +27,254 @@ func UploadClientProviderExecutor(ctx context.Context, f UploadClientProvider) (
 	return f(ctx)
 }
 
+// BackendKind selects the remote backend.
+type BackendKind string
+
+const (
+	// BackendAuto detects the backend from the environment.
+	BackendAuto   BackendKind = "auto"
+	BackendGitHub BackendKind = "github"
+	// BackendHTTP is the cache hub served by gocica serve --http.
+	BackendHTTP BackendKind = "http"
+	// BackendExec is a plugin process given by --remote=exec:<command>.
+	BackendExec BackendKind = "exec"
+	// BackendSigned is the blob at pre-signed URLs given by --signed.download-url and --signed.upload-url.
+	BackendSigned BackendKind = "signed"
+	// BackendNone disables the remote cache and only uses the local disk.
+	BackendNone BackendKind = "none"
+)
+
+// SupportedBackendKinds are the backends built into this binary.
+var SupportedBackendKinds = []BackendKind{BackendGitHub, BackendHTTP, BackendExec, BackendSigned, BackendNone}
+
+// BackendKinds returns the backends built into this binary and the ones registered with backend.RegisterRemote.
+func BackendKinds() []BackendKind {
+	kinds := slices.Clone(SupportedBackendKinds)
+	for _, name := range backend.RemoteNames() {
+		kinds = append(kinds, BackendKind(name))
+	}
+
+	return kinds
+}
+
+var (
+	backendConfigLocker sync.Mutex
+	backendConfig       backend.Config
+)
+
+// SetBackendConfig sets the cache directory and the options given to the factory of a registered backend.
+func SetBackendConfig(dir string, options map[string]string) {
+	backendConfigLocker.Lock()
+	defer backendConfigLocker.Unlock()
+
+	backendConfig = backend.Config{
+		Dir:     dir,
+		Options: options,
+	}
+}
+
+// registeredProviders returns the client providers of the backend registered by the name, or false when it is not registered.
+func registeredProviders(ctx context.Context, logger log.Logger, name string) (DownloadClientProvider, UploadClientProvider, bool, error) {
+	factory, ok := backend.LookupRemote(name)
+	if !ok {
+		return nil, nil, false, nil
+	}
+
+	backendConfigLocker.Lock()
+	config := backendConfig
+	backendConfigLocker.Unlock()
+	config.Logger = logger
+
+	remoteBackend, err := factory(ctx, config)
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("%s backend: %w", name, err)
+	}
+
+	downloadClientProvider := func(ctx context.Context) (core.DownloadClient, error) {
+		client, err := remoteBackend.DownloadClient(ctx)
+		if err != nil || client == nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	uploadClientProvider := func(ctx context.Context) (core.UploadClient, error) {
+		client, err := remoteBackend.UploadClient(ctx)
+		if err != nil || client == nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	return downloadClientProvider, uploadClientProvider, true, nil
+}
+
+// DetectBackendKind picks the backend from the CI environment and the given settings.
+// A hub, a plugin or pre-signed URLs given explicitly are preferred to the cache service of the CI.
+func DetectBackendKind(ghaCacheConfig *GHACacheConfig, hubConfig *HubConfig) BackendKind {
+	if hubConfig != nil && strings.HasPrefix(hubConfig.URL, ExecScheme) {
+		return BackendExec
+	}
+	if hubConfig != nil && hubConfig.URL != "" {
+		return BackendHTTP
+	}
+	if signed := currentSignedURLConfig(); signed.DownloadURL != "" || signed.UploadURL != "" {
+		return BackendSigned
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" ||
+		(ghaCacheConfig != nil && ghaCacheConfig.Token != "" && ghaCacheConfig.CacheURL != "") {
+		return BackendGitHub
+	}
+
+	return BackendNone
+}
+
+// IsPullRequestEvent reports whether a GITHUB_EVENT_NAME is triggered by a pull request,
+// whose code may come from a fork.
+func IsPullRequestEvent(event string) bool {
+	return strings.HasPrefix(event, "pull_request")
+}
+
+// validateGHACacheConfig returns an error naming every missing setting required by the GitHub backend.
+func validateGHACacheConfig(config *GHACacheConfig) error {
+	if config == nil {
+		return fmt.Errorf("%w: github backend is not configured", ErrInvalidConfig)
+	}
+
+	var missing []string
+	if config.Token == "" {
+		missing = append(missing, "token (ACTIONS_RUNTIME_TOKEN or GOCICA_GITHUB_TOKEN)")
+	}
+	if config.CacheURL == "" {
+		missing = append(missing, "cache URL (ACTIONS_RESULTS_URL or GOCICA_GITHUB_CACHE_URL)")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing settings: %s", ErrInvalidConfig, strings.Join(missing, ", "))
+	}
+
+	return checkCacheService(config)
+}
+
+// noneProviders return no clients, which makes the downloader and the uploader no-ops.
+func noneProviders() (DownloadClientProvider, UploadClientProvider) {
+	downloadClientProvider := func(context.Context) (core.DownloadClient, error) {
+		return nil, nil
+	}
+	uploadClientProvider := func(context.Context) (core.UploadClient, error) {
+		return nil, nil
+	}
+
+	return downloadClientProvider, uploadClientProvider
+}
+
+// Switch returns the client providers of the backend.
+// The clients inject faults when they are enabled by the dev flags.
 func Switch(
 	ctx context.Context,
 	logger log.Logger,
+	backendKind BackendKind,
+	ghaCacheConfig *GHACacheConfig,
+	hubConfig *HubConfig,
+) (DownloadClientProvider, UploadClientProvider, error) {
+	downloadClientProvider, uploadClientProvider, err := switchBackend(ctx, logger, backendKind, ghaCacheConfig, hubConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !fault.Enabled() {
+		return downloadClientProvider, uploadClientProvider, nil
+	}
+	logger.Warnf("faults are injected into the remote cache.")
+
+	return func(ctx context.Context) (core.DownloadClient, error) {
+			client, err := downloadClientProvider(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return fault.WrapDownloadClient(client), nil
+		}, func(ctx context.Context) (core.UploadClient, error) {
+			client, err := uploadClientProvider(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return fault.WrapUploadClient(client), nil
+		}, nil
+}
+
+func switchBackend(
+	ctx context.Context,
+	logger log.Logger,
+	backendKind BackendKind,
 	ghaCacheConfig *GHACacheConfig,
+	hubConfig *HubConfig,
 ) (DownloadClientProvider, UploadClientProvider, error) {
-	switch {
-	case ghaCacheConfig != nil:
-		return GHACacheProvider(ctx, logger, ghaCacheConfig)
+	if backendKind == BackendAuto || backendKind == "" {
+		backendKind = DetectBackendKind(ghaCacheConfig, hubConfig)
+		logger.Debugf("detected backend: %s", backendKind)
+	}
+
+	switch backendKind {
+	case BackendGitHub:
+		if err := validateGHACacheConfig(ghaCacheConfig); err != nil {
+			return nil, nil, fmt.Errorf("github backend: %w", err)
+		}
+
+		canWrite, err := validateRuntimeToken(ghaCacheConfig.Token, time.Now())
+		if err != nil {
+			return nil, nil, fmt.Errorf("github backend: %w: %w", ErrUnauthorized, err)
+		}
+
+		downloadClientProvider, uploadClientProvider, err := GHACacheProvider(ctx, logger, ghaCacheConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !canWrite {
+			logger.Warnf("the token can only read caches. uploads are disabled for this run.")
+			_, uploadClientProvider = noneProviders()
+		}
+
+		return downloadClientProvider, uploadClientProvider, nil
+	case BackendHTTP:
+		if err := validateHubConfig(hubConfig); err != nil {
+			return nil, nil, fmt.Errorf("http backend: %w", err)
+		}
+
+		downloadClientProvider, uploadClientProvider := HubProvider(logger, hubConfig)
+		return downloadClientProvider, uploadClientProvider, nil
+	case BackendExec:
+		command, err := execCommand(hubConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("exec backend: %w", err)
+		}
+
+		downloadClientProvider, uploadClientProvider := ExecProvider(logger, command)
+		return downloadClientProvider, uploadClientProvider, nil
+	case BackendSigned:
+		config := currentSignedURLConfig()
+		if err := validateSignedURLConfig(config); err != nil {
+			return nil, nil, fmt.Errorf("signed backend: %w", err)
+		}
+
+		backendConfigLocker.Lock()
+		spoolDir := backendConfig.Dir
+		backendConfigLocker.Unlock()
+
+		downloadClientProvider, uploadClientProvider := SignedURLProvider(logger, config, spoolDir)
+		return downloadClientProvider, uploadClientProvider, nil
+	case BackendNone:
+		logger.Infof("no remote backend is configured. only the local cache will be used.")
+
+		downloadClientProvider, uploadClientProvider := noneProviders()
+		return downloadClientProvider, uploadClientProvider, nil
 	default:
-		return nil, nil, nil
+		downloadClientProvider, uploadClientProvider, ok, err := registeredProviders(ctx, logger, string(backendKind))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unknown backend: %s", ErrInvalidConfig, backendKind)
+		}
+
+		return downloadClientProvider, uploadClientProvider, nil
 	}
 }
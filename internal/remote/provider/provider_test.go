@@ -0,0 +1,367 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/log"
+)
+
+func init() {
+	backend.RegisterRemote("registered", func(_ context.Context, config backend.Config) (backend.RemoteBackend, error) {
+		if config.Option("fail", "") != "" {
+			return nil, errors.New("failed")
+		}
+		return registeredBackend{}, nil
+	})
+}
+
+// registeredBackend has no blob and only reads, like the none backend.
+type registeredBackend struct{}
+
+func (registeredBackend) DownloadClient(context.Context) (backend.DownloadClient, error) {
+	return nil, nil
+}
+
+func (registeredBackend) UploadClient(context.Context) (backend.UploadClient, error) {
+	return nil, nil
+}
+
+func TestSwitch(t *testing.T) {
+	tests := []struct {
+		name          string
+		backendKind   BackendKind
+		githubActions string
+		config        *GHACacheConfig
+		hubConfig     *HubConfig
+		signed        SignedURLConfig
+		options       map[string]string
+		wantErr       bool
+		wantNone      bool
+	}{
+		{
+			name:        "none",
+			backendKind: BackendNone,
+			config:      &GHACacheConfig{},
+			wantNone:    true,
+		},
+		{
+			name:        "auto outside of CI",
+			backendKind: BackendAuto,
+			config:      &GHACacheConfig{},
+			wantNone:    true,
+		},
+		{
+			name:          "auto on GitHub Actions without settings",
+			backendKind:   BackendAuto,
+			githubActions: "true",
+			config:        &GHACacheConfig{},
+			wantErr:       true,
+		},
+		{
+			name:        "github without settings",
+			backendKind: BackendGitHub,
+			config:      &GHACacheConfig{Token: "token"},
+			wantErr:     true,
+		},
+		{
+			name:        "github",
+			backendKind: BackendGitHub,
+			config:      &GHACacheConfig{Token: "token", CacheURL: "https://example.com"},
+		},
+		{
+			name:        "http without URL",
+			backendKind: BackendHTTP,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{},
+			wantErr:     true,
+		},
+		{
+			name:        "http with invalid URL",
+			backendKind: BackendHTTP,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "hub:8080"},
+			wantErr:     true,
+		},
+		{
+			name:        "http with invalid read URL",
+			backendKind: BackendHTTP,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "http://hub:8080", ReadURL: "replica:8080"},
+			wantErr:     true,
+		},
+		{
+			name:        "http with read replica",
+			backendKind: BackendHTTP,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "http://hub:8080", ReadURL: "http://replica:8080"},
+		},
+		{
+			name:        "http",
+			backendKind: BackendHTTP,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "http://hub:8080"},
+		},
+		{
+			name:          "auto prefers the hub",
+			backendKind:   BackendAuto,
+			githubActions: "true",
+			config:        &GHACacheConfig{},
+			hubConfig:     &HubConfig{URL: "http://hub:8080"},
+		},
+		{
+			name:        "exec without command",
+			backendKind: BackendExec,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "exec: "},
+			wantErr:     true,
+		},
+		{
+			name:        "exec with read replica",
+			backendKind: BackendExec,
+			config:      &GHACacheConfig{},
+			hubConfig:   &HubConfig{URL: "exec:gocica-plugin", ReadURL: "http://replica:8080"},
+			wantErr:     true,
+		},
+		{
+			name:          "auto detects the plugin",
+			backendKind:   BackendAuto,
+			githubActions: "true",
+			config:        &GHACacheConfig{},
+			hubConfig:     &HubConfig{URL: "exec:gocica-plugin --flag"},
+		},
+		{
+			name:        "signed without URLs",
+			backendKind: BackendSigned,
+			config:      &GHACacheConfig{},
+			wantErr:     true,
+		},
+		{
+			name:        "signed with invalid URL",
+			backendKind: BackendSigned,
+			config:      &GHACacheConfig{},
+			signed:      SignedURLConfig{UploadURL: "bucket/key"},
+			wantErr:     true,
+		},
+		{
+			name:          "auto detects signed URLs",
+			backendKind:   BackendAuto,
+			githubActions: "true",
+			config:        &GHACacheConfig{},
+			signed:        SignedURLConfig{DownloadURL: "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=x"},
+		},
+		{
+			name:        "unknown",
+			backendKind: "unknown",
+			config:      &GHACacheConfig{},
+			wantErr:     true,
+		},
+		{
+			name:        "registered",
+			backendKind: "registered",
+			config:      &GHACacheConfig{},
+			wantNone:    true,
+		},
+		{
+			name:        "registered with invalid options",
+			backendKind: "registered",
+			config:      &GHACacheConfig{},
+			options:     map[string]string{"fail": "true"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_ACTIONS", tt.githubActions)
+			SetBackendConfig(t.TempDir(), tt.options)
+			SetSignedURLConfig(tt.signed)
+
+			downloadClientProvider, uploadClientProvider, err := Switch(context.Background(), log.DefaultLogger, tt.backendKind, tt.config, tt.hubConfig)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !tt.wantNone {
+				return
+			}
+
+			downloadClient, err := downloadClientProvider(context.Background())
+			if err != nil || downloadClient != nil {
+				t.Errorf("expected no download client, got %v, %v", downloadClient, err)
+			}
+			uploadClient, err := uploadClientProvider(context.Background())
+			if err != nil || uploadClient != nil {
+				t.Errorf("expected no upload client, got %v, %v", uploadClient, err)
+			}
+		})
+	}
+}
+
+func TestBlobKey(t *testing.T) {
+	tests := []struct {
+		name            string
+		isolated        bool
+		wantKey         string
+		wantRestoreKeys []string
+	}{
+		{
+			name:            "not isolated",
+			wantKey:         "gocica-cache-Linux-refs/heads/main-abc",
+			wantRestoreKeys: []string{"gocica-cache-Linux-refs/heads/main-", "gocica-cache-Linux-"},
+		},
+		{
+			name:     "isolated",
+			isolated: true,
+			wantKey:  "gocica-cache-pr-Linux-refs/heads/main-abc",
+			wantRestoreKeys: []string{
+				"gocica-cache-pr-Linux-refs/heads/main-",
+				"gocica-cache-Linux-refs/heads/main-",
+				"gocica-cache-Linux-",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ghaCacheClient{runnerOS: "Linux", ref: "refs/heads/main", sha: "abc", isolated: tt.isolated}
+
+			key, restoreKeys := client.blobKey()
+			if key != tt.wantKey {
+				t.Errorf("key: got %q, want %q", key, tt.wantKey)
+			}
+			if !slices.Equal(restoreKeys, tt.wantRestoreKeys) {
+				t.Errorf("restore keys: got %q, want %q", restoreKeys, tt.wantRestoreKeys)
+			}
+
+			// Runs outside of pull requests must never restore from the pull request namespace.
+			if !tt.isolated {
+				for _, restoreKey := range restoreKeys {
+					if strings.HasPrefix("gocica-cache-pr-Linux-refs/heads/main-abc", restoreKey) {
+						t.Errorf("restore key %q matches the pull request namespace", restoreKey)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVariantRunnerOS(t *testing.T) {
+	keys := map[string][]string{}
+	for _, variant := range []string{"", "1a2b3c4d", "5e6f7a8b"} {
+		client := &ghaCacheClient{runnerOS: variantRunnerOS("Linux", variant), ref: "refs/heads/main", sha: "abc"}
+		key, restoreKeys := client.blobKey()
+		keys[key] = restoreKeys
+	}
+
+	// The restore keys of a variant must match its own key only.
+	for key, restoreKeys := range keys {
+		for otherKey := range keys {
+			for _, restoreKey := range restoreKeys {
+				if matched := strings.HasPrefix(otherKey, restoreKey); matched != (key == otherKey) {
+					t.Errorf("restore key %q of %q matches %q: %t", restoreKey, key, otherKey, matched)
+				}
+			}
+		}
+	}
+	if _, ok := keys["gocica-cache-Linux.1a2b3c4d-refs/heads/main-abc"]; !ok {
+		t.Errorf("keys: got %v", slices.Collect(maps.Keys(keys)))
+	}
+}
+
+func TestScopeRunnerOS(t *testing.T) {
+	keys := map[string][]string{}
+	for _, scope := range []string{"", "build", "test", "test_race"} {
+		for _, variant := range []string{"", "1a2b3c4d"} {
+			client := &ghaCacheClient{runnerOS: variantRunnerOS(scopeRunnerOS("Linux", scope), variant), ref: "refs/heads/main", sha: "abc"}
+			key, restoreKeys := client.blobKey()
+			keys[key] = restoreKeys
+		}
+	}
+
+	// The restore keys of a scope must match its own key only.
+	for key, restoreKeys := range keys {
+		for otherKey := range keys {
+			for _, restoreKey := range restoreKeys {
+				if matched := strings.HasPrefix(otherKey, restoreKey); matched != (key == otherKey) {
+					t.Errorf("restore key %q of %q matches %q: %t", restoreKey, key, otherKey, matched)
+				}
+			}
+		}
+	}
+	if _, ok := keys["gocica-cache-Linux@test.1a2b3c4d-refs/heads/main-abc"]; !ok {
+		t.Errorf("keys: got %v", slices.Collect(maps.Keys(keys)))
+	}
+}
+
+func TestValidateScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		wantErr bool
+	}{
+		{scope: "", wantErr: false},
+		{scope: "build", wantErr: false},
+		{scope: "Test_Race2", wantErr: false},
+		{scope: "lint-go", wantErr: true},
+		{scope: "a.b", wantErr: true},
+		{scope: "a@b", wantErr: true},
+		{scope: strings.Repeat("a", 33), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			err := ValidateScope(tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateScope(%q) error = %v, wantErr %t", tt.scope, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("ValidateScope(%q) error = %v, want ErrInvalidConfig", tt.scope, err)
+			}
+		})
+	}
+}
+
+func TestRunKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		runID      string
+		runAttempt string
+		wantKey    string
+		wantOK     bool
+	}{
+		{name: "no run", wantOK: false},
+		{name: "first attempt", runID: "42", wantKey: "gocica-cache-Linux-refs/heads/main-abc-run-42-1", wantOK: true},
+		{name: "retried", runID: "42", runAttempt: "3", wantKey: "gocica-cache-Linux-refs/heads/main-abc-run-42-3", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ghaCacheClient{runnerOS: "Linux", ref: "refs/heads/main", sha: "abc", runID: tt.runID, runAttempt: tt.runAttempt}
+
+			key, restoreKeys := client.blobKey()
+			runKey, ok := client.runKey(key)
+			if ok != tt.wantOK {
+				t.Fatalf("ok: got %v, want %v", ok, tt.wantOK)
+			}
+			if runKey != tt.wantKey {
+				t.Errorf("key: got %q, want %q", runKey, tt.wantKey)
+			}
+
+			// The next run must be able to restore the run-unique entry.
+			if ok && !strings.HasPrefix(runKey, restoreKeys[0]) {
+				t.Errorf("run key %q is not matched by the restore key %q", runKey, restoreKeys[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CacheServiceVersion is the version of the cache service of GitHub Actions.
+type CacheServiceVersion string
+
+const (
+	// CacheServiceV1 is the legacy REST cache service at ACTIONS_CACHE_URL, still used by GitHub Enterprise Server.
+	CacheServiceV1 CacheServiceVersion = "v1"
+	// CacheServiceV2 is the Twirp cache service at ACTIONS_RESULTS_URL, the one gocica speaks.
+	CacheServiceV2 CacheServiceVersion = "v2"
+)
+
+// The variables the runner sets for the cache service. Which ones are populated changes with the runner images and the rollout of the v2 service.
+const (
+	cacheServiceV2Env = "ACTIONS_CACHE_SERVICE_V2"
+	resultsURLEnv     = "ACTIONS_RESULTS_URL"
+	legacyCacheURLEnv = "ACTIONS_CACHE_URL"
+	serverURLEnv      = "GITHUB_SERVER_URL"
+)
+
+// DetectCacheService picks the cache service of the runner and its URL from the environment read by getenv,
+// the way @actions/cache does:
+//   - GitHub Enterprise Server, told by GITHUB_SERVER_URL, has the v1 service only.
+//   - ACTIONS_CACHE_SERVICE_V2 selects the v2 service when it is set to anything but a false boolean, and the v1 one otherwise.
+//   - Without it, ACTIONS_RESULTS_URL selects the v2 service, as the runners of github.com do not always set the flag
+//     since the v1 service was retired, and ACTIONS_CACHE_URL the v1 one.
+//
+// The URL is "" when the runner sets none for the service.
+func DetectCacheService(getenv func(string) string) (CacheServiceVersion, string) {
+	legacyURL := func() string {
+		if cacheURL := getenv(legacyCacheURLEnv); cacheURL != "" {
+			return cacheURL
+		}
+		return getenv(resultsURLEnv)
+	}
+
+	if isGHES(getenv(serverURLEnv)) {
+		return CacheServiceV1, legacyURL()
+	}
+
+	if flag := strings.TrimSpace(getenv(cacheServiceV2Env)); flag != "" {
+		if v2, err := strconv.ParseBool(flag); err == nil && !v2 {
+			return CacheServiceV1, legacyURL()
+		}
+		return CacheServiceV2, getenv(resultsURLEnv)
+	}
+
+	if resultsURL := getenv(resultsURLEnv); resultsURL != "" {
+		return CacheServiceV2, resultsURL
+	}
+	if cacheURL := getenv(legacyCacheURLEnv); cacheURL != "" {
+		return CacheServiceV1, cacheURL
+	}
+
+	return CacheServiceV2, ""
+}
+
+// isGHES reports whether the server URL of the workflow is the one of a GitHub Enterprise Server,
+// rather than github.com, a GHE.com data residency host or a local one.
+func isGHES(serverURL string) bool {
+	if serverURL == "" {
+		return false
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSpace(u.Hostname()))
+
+	return host != "github.com" && !strings.HasSuffix(host, ".ghe.com") && !strings.HasSuffix(host, ".localhost")
+}
+
+// checkCacheService returns an error for a cache service gocica does not speak.
+func checkCacheService(config *GHACacheConfig) error {
+	if config.Service == CacheServiceV1 {
+		return fmt.Errorf("%w: the runner only offers the legacy cache service (v1, %s), e.g. on GitHub Enterprise Server, and gocica needs the v2 one (%s). "+
+			"set GOCICA_GITHUB_CACHE_URL to a cache service v2, or use another backend", ErrInvalidConfig, legacyCacheURLEnv, resultsURLEnv)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectCacheService(t *testing.T) {
+	const (
+		resultsURL = "https://results-receiver.actions.githubusercontent.com/"
+		cacheURL   = "https://artifactcache.actions.githubusercontent.com/abc/"
+	)
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantService CacheServiceVersion
+		wantURL     string
+	}{
+		{
+			name:        "v2 flag",
+			env:         map[string]string{"ACTIONS_CACHE_SERVICE_V2": "True", "ACTIONS_RESULTS_URL": resultsURL, "ACTIONS_CACHE_URL": cacheURL},
+			wantService: CacheServiceV2,
+			wantURL:     resultsURL,
+		},
+		{
+			name:        "v2 flag not a boolean",
+			env:         map[string]string{"ACTIONS_CACHE_SERVICE_V2": "yes", "ACTIONS_RESULTS_URL": resultsURL},
+			wantService: CacheServiceV2,
+			wantURL:     resultsURL,
+		},
+		{
+			name:        "v2 flag off",
+			env:         map[string]string{"ACTIONS_CACHE_SERVICE_V2": "false", "ACTIONS_RESULTS_URL": resultsURL, "ACTIONS_CACHE_URL": cacheURL},
+			wantService: CacheServiceV1,
+			wantURL:     cacheURL,
+		},
+		{
+			name:        "results URL only",
+			env:         map[string]string{"ACTIONS_RESULTS_URL": resultsURL},
+			wantService: CacheServiceV2,
+			wantURL:     resultsURL,
+		},
+		{
+			name:        "results URL preferred without the flag",
+			env:         map[string]string{"ACTIONS_RESULTS_URL": resultsURL, "ACTIONS_CACHE_URL": cacheURL},
+			wantService: CacheServiceV2,
+			wantURL:     resultsURL,
+		},
+		{
+			name:        "legacy cache URL only",
+			env:         map[string]string{"ACTIONS_CACHE_URL": cacheURL},
+			wantService: CacheServiceV1,
+			wantURL:     cacheURL,
+		},
+		{
+			name:        "GHES",
+			env:         map[string]string{"GITHUB_SERVER_URL": "https://ghes.example.com", "ACTIONS_CACHE_SERVICE_V2": "true", "ACTIONS_RESULTS_URL": resultsURL, "ACTIONS_CACHE_URL": cacheURL},
+			wantService: CacheServiceV1,
+			wantURL:     cacheURL,
+		},
+		{
+			name:        "GHE.com",
+			env:         map[string]string{"GITHUB_SERVER_URL": "https://octo.ghe.com", "ACTIONS_RESULTS_URL": resultsURL},
+			wantService: CacheServiceV2,
+			wantURL:     resultsURL,
+		},
+		{
+			name:        "none",
+			env:         map[string]string{"GITHUB_SERVER_URL": "https://github.com"},
+			wantService: CacheServiceV2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, url := DetectCacheService(func(key string) string { return tt.env[key] })
+			if service != tt.wantService || url != tt.wantURL {
+				t.Errorf("DetectCacheService() = %s, %q, want %s, %q", service, url, tt.wantService, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestValidateGHACacheConfig_service(t *testing.T) {
+	config := &GHACacheConfig{Token: "token", CacheURL: "https://artifactcache.actions.githubusercontent.com/abc/", Service: CacheServiceV1}
+	if err := validateGHACacheConfig(config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("validateGHACacheConfig(v1) error = %v, want ErrInvalidConfig", err)
+	}
+
+	config.Service = CacheServiceV2
+	if err := validateGHACacheConfig(config); err != nil {
+		t.Errorf("validateGHACacheConfig(v2) error = %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	myhttp "github.com/mazrean/gocica/internal/pkg/http"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/storage"
+	"github.com/mazrean/gocica/log"
+)
+
+// SignedURLConfig is the configuration of the backend on pre-signed URLs,
+// brokered by a trusted service so that the job holds no credentials of the storage.
+type SignedURLConfig struct {
+	// DownloadURL is the pre-signed URL the blob is read from. Empty runs without the remote cache read.
+	DownloadURL string
+	// UploadURL is the pre-signed URL the blob is written to. Empty runs without uploads.
+	UploadURL string
+}
+
+var (
+	signedURLConfigLocker sync.Mutex
+	signedURLConfig       SignedURLConfig
+)
+
+// SetSignedURLConfig sets the pre-signed URLs of the signed backend.
+func SetSignedURLConfig(config SignedURLConfig) {
+	signedURLConfigLocker.Lock()
+	defer signedURLConfigLocker.Unlock()
+
+	signedURLConfig = config
+}
+
+func currentSignedURLConfig() SignedURLConfig {
+	signedURLConfigLocker.Lock()
+	defer signedURLConfigLocker.Unlock()
+
+	return signedURLConfig
+}
+
+// validateSignedURLConfig checks that the pre-signed URLs given are absolute https URLs.
+func validateSignedURLConfig(config SignedURLConfig) error {
+	if config.DownloadURL == "" && config.UploadURL == "" {
+		return fmt.Errorf("%w: missing settings: signed download URL (GOCICA_SIGNED_DOWNLOAD_URL) or signed upload URL (GOCICA_SIGNED_UPLOAD_URL)", ErrInvalidConfig)
+	}
+
+	for name, rawURL := range map[string]string{"signed download URL": config.DownloadURL, "signed upload URL": config.UploadURL} {
+		if rawURL == "" {
+			continue
+		}
+		if err := validateHubURL(name, rawURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isAzureBlobURL reports whether the URL is a SAS URL of Azure Blob Storage,
+// whose blocks are staged and copied in the storage like in the GitHub Actions cache.
+func isAzureBlobURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.HasSuffix(u.Hostname(), ".blob.core.windows.net")
+}
+
+// SignedURLProvider returns the providers of the clients of the pre-signed URLs.
+// The URLs of Azure Blob Storage use the clients of the GitHub Actions cache, which stage the blocks in the storage.
+// The URLs of the other stores, e.g. S3 or GCS, take the whole blob in a single PUT,
+// so the blocks are staged in spoolDir and the ones of the blob read are downloaded again to be sent.
+func SignedURLProvider(logger log.Logger, config SignedURLConfig, spoolDir string) (DownloadClientProvider, UploadClientProvider) {
+	httpClient := myhttp.NewClient()
+
+	downloadClientProvider := func(ctx context.Context) (core.DownloadClient, error) {
+		if config.DownloadURL == "" {
+			logger.Infof("no signed download URL is given. building without cache.")
+			return nil, nil
+		}
+
+		if isAzureBlobURL(config.DownloadURL) {
+			client, err := storage.NewAzureDownloadClient(config.DownloadURL)
+			if err != nil {
+				return nil, fmt.Errorf("create azure download client: %w", err)
+			}
+			return client, nil
+		}
+
+		err := storage.FindSignedBlob(ctx, httpClient, config.DownloadURL)
+		if errors.Is(err, storage.ErrSignedBlobNotFound) {
+			logger.Infof("cache not found at the signed download URL. building without cache.")
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("find blob at the signed download URL: %w", err)
+		}
+
+		return storage.NewSignedDownloadClient(httpClient, config.DownloadURL), nil
+	}
+	uploadClientProvider := func(context.Context) (core.UploadClient, error) {
+		if config.UploadURL == "" {
+			logger.Infof("no signed upload URL is given. uploads are disabled for this run.")
+			return nil, nil
+		}
+
+		if isAzureBlobURL(config.UploadURL) {
+			client, err := storage.NewAzureUploadClient(config.UploadURL)
+			if err != nil {
+				return nil, fmt.Errorf("create azure upload client: %w", err)
+			}
+			return client, nil
+		}
+
+		return storage.NewSignedUploadClient(httpClient, config.UploadURL, spoolDir), nil
+	}
+
+	return downloadClientProvider, uploadClientProvider
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuntimeToken is the subset of the ACTIONS_RUNTIME_TOKEN claims gocica relies on.
+type RuntimeToken struct {
+	// Expiry is zero when the token has no exp claim.
+	Expiry time.Time
+	// Scopes are the cache scopes the token can access. nil when the token has no ac claim.
+	Scopes []CacheScope
+}
+
+// CacheScope is a cache scope of the runtime token.
+type CacheScope struct {
+	Scope      string `json:"Scope"`
+	Permission int    `json:"Permission"`
+}
+
+// cacheWritePermission is the permission bit of a cache scope that allows writing.
+const cacheWritePermission = 2
+
+// CanWrite reports whether the scope allows writing.
+func (s CacheScope) CanWrite() bool {
+	return s.Permission&cacheWritePermission != 0
+}
+
+type runtimeTokenClaims struct {
+	Exp int64 `json:"exp"`
+	// Ac is a JSON encoded list of the cache scopes the token can access.
+	Ac string `json:"ac"`
+}
+
+// ErrNotJWT is returned by ParseRuntimeToken for tokens whose claims cannot be inspected.
+var ErrNotJWT = errors.New("token is not a JWT")
+
+// ParseRuntimeToken reads the claims of the runtime token without verifying its signature.
+func ParseRuntimeToken(token string) (*RuntimeToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrNotJWT
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decode token payload: %w", err)
+	}
+
+	var claims runtimeTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("parse token claims: %w", err)
+	}
+
+	runtimeToken := &RuntimeToken{}
+	if claims.Exp != 0 {
+		runtimeToken.Expiry = time.Unix(claims.Exp, 0)
+	}
+
+	if claims.Ac != "" {
+		if err := json.Unmarshal([]byte(claims.Ac), &runtimeToken.Scopes); err != nil {
+			return nil, fmt.Errorf("parse cache scopes: %w", err)
+		}
+	}
+
+	return runtimeToken, nil
+}
+
+// WritableScopes returns the scopes the token can write to.
+func (t *RuntimeToken) WritableScopes() []string {
+	writable := make([]string, 0, len(t.Scopes))
+	for _, scope := range t.Scopes {
+		if scope.CanWrite() {
+			writable = append(writable, scope.Scope)
+		}
+	}
+
+	return writable
+}
+
+// apiTokenPrefixes are the prefixes of GitHub REST API tokens, which the cache service does not accept.
+// ref: https://github.blog/engineering/platform-security/behind-githubs-new-authentication-token-formats/
+var apiTokenPrefixes = []string{"ghp_", "gho_", "ghu_", "ghs_", "ghr_", "github_pat_"}
+
+// validateRuntimeToken checks that the token is meant for the cache service before any request is made.
+// It reports whether the token can write caches.
+// Tokens that are not JWTs are accepted as is, as custom cache servers may use opaque tokens.
+func validateRuntimeToken(token string, now time.Time) (bool, error) {
+	for _, prefix := range apiTokenPrefixes {
+		if strings.HasPrefix(token, prefix) {
+			return false, errors.New("token is a GitHub API token such as GITHUB_TOKEN, but the cache service needs ACTIONS_RUNTIME_TOKEN. " +
+				"it is only exposed to actions, so export it to the job environment, e.g. with crazy-max/ghaction-github-runtime")
+		}
+	}
+
+	runtimeToken, err := ParseRuntimeToken(token)
+	if errors.Is(err, ErrNotJWT) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !runtimeToken.Expiry.IsZero() && runtimeToken.Expiry.Before(now) {
+		return false, fmt.Errorf("token expired at %s. the runtime token is only valid during the job that issued it", runtimeToken.Expiry.Format(time.RFC3339))
+	}
+
+	if runtimeToken.Scopes == nil {
+		return false, errors.New("token has no cache scopes (ac claim), so it is not an ACTIONS_RUNTIME_TOKEN")
+	}
+
+	return len(runtimeToken.WritableScopes()) > 0, nil
+}
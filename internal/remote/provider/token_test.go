@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+
+	return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+func TestValidateRuntimeToken(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	writeScopes := `[{"Scope":"refs/heads/main","Permission":3}]`
+	readScopes := `[{"Scope":"refs/heads/main","Permission":1}]`
+
+	tests := []struct {
+		name         string
+		token        string
+		wantCanWrite bool
+		wantErr      bool
+	}{
+		{
+			name:         "opaque token",
+			token:        "token",
+			wantCanWrite: true,
+		},
+		{
+			name:    "GITHUB_TOKEN",
+			token:   "ghs_0123456789",
+			wantErr: true,
+		},
+		{
+			name:    "personal access token",
+			token:   "github_pat_0123456789",
+			wantErr: true,
+		},
+		{
+			name:         "writable",
+			token:        newTestJWT(t, map[string]any{"exp": now.Add(time.Hour).Unix(), "ac": writeScopes}),
+			wantCanWrite: true,
+		},
+		{
+			name:  "read-only",
+			token: newTestJWT(t, map[string]any{"exp": now.Add(time.Hour).Unix(), "ac": readScopes}),
+		},
+		{
+			name:    "expired",
+			token:   newTestJWT(t, map[string]any{"exp": now.Add(-time.Hour).Unix(), "ac": writeScopes}),
+			wantErr: true,
+		},
+		{
+			name:    "no cache scopes",
+			token:   newTestJWT(t, map[string]any{"exp": now.Add(time.Hour).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "broken payload",
+			token:   "a.!!!.c",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			canWrite, err := validateRuntimeToken(tt.token, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateRuntimeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if canWrite != tt.wantCanWrite {
+				t.Errorf("canWrite: got %v, want %v", canWrite, tt.wantCanWrite)
+			}
+		})
+	}
+}
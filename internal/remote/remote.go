@@ -3,7 +3,9 @@ package remote
 import (
 	"context"
 	"io"
+	"time"
 
+	"github.com/mazrean/gocica/backend"
 	v1 "github.com/mazrean/gocica/internal/proto/gocica/v1"
 )
 
@@ -12,4 +14,47 @@ type Backend interface {
 	WriteMetaData(ctx context.Context, metaDataMap map[string]*v1.IndexEntry) error
 	Put(ctx context.Context, objectID string, size int64, r io.ReadSeeker) error
 	Close(ctx context.Context) error
+	// Capabilities returns the features of the storage, which the transfers pick their algorithms by.
+	Capabilities() Capabilities
+}
+
+// Capabilities are the features of the storage of a Backend, shared with the backends of the backend package.
+type Capabilities = backend.Capabilities
+
+// OnDemandBackend is a Backend that may stop restoring the whole blob when few of its outputs are used,
+// and download the outputs looked up afterwards on demand.
+type OnDemandBackend interface {
+	Backend
+	// Used records that the output was used by a hit.
+	Used(outputID string)
+	// Fetch downloads the output to the local cache and returns its path, or "" when it is not downloaded on demand.
+	Fetch(ctx context.Context, outputID string) (diskPath string, err error)
+}
+
+// RestoreWaiter is a Backend restoring the whole blob to the local cache in the background.
+type RestoreWaiter interface {
+	Backend
+	// WaitRestored waits for the restoration to end.
+	WaitRestored(ctx context.Context) error
+}
+
+// PendingBackend is a Backend restoring the whole blob to the local cache in the background,
+// telling the outputs missing from the local cache only because the restoration has not reached them yet.
+type PendingBackend interface {
+	Backend
+	// Pending reports whether the output is still to be written to the local cache by the restoration.
+	Pending(outputID string) bool
+	// WaitPending waits up to wait for the restoration to write the output, and returns its path in the local cache,
+	// or "" when it is not written by then.
+	WaitPending(ctx context.Context, outputID string, wait time.Duration) (diskPath string, err error)
+}
+
+// MaxInlineSize is the largest output kept inline in its IndexEntry instead of being uploaded as an output of the blob.
+// Go produces thousands of such tiny outputs, and a round trip each costs more than the bytes in the header.
+const MaxInlineSize = 1 << 10
+
+// IsInline reports whether the content of the output of entry is in the entry itself.
+// An empty output is always inline, as there is nothing to keep.
+func IsInline(entry *v1.IndexEntry) bool {
+	return entry.Size <= MaxInlineSize && int64(len(entry.InlineOutput)) == entry.Size
 }
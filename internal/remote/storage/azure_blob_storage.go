@@ -4,22 +4,71 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/mazrean/gocica/internal/pkg/http"
 	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
 	"github.com/mazrean/gocica/internal/remote/core"
 )
 
-var _ core.UploadClient = (*AzureUploadClient)(nil)
-var latencyGauge = metrics.NewGauge("azure_blob_storage_latency")
+var (
+	_ core.UploadClient      = (*AzureUploadClient)(nil)
+	_ core.StagedBlockClient = (*AzureUploadClient)(nil)
+)
+var latencyHistogram = metrics.NewHistogram("azure_blob_storage_latency")
+
+// AzureOptions are the transfer options of the Azure Blob Storage clients.
+type AzureOptions struct {
+	// DownloadConcurrency is the parallel range requests a chunk is downloaded with. 0 is the default of the SDK.
+	DownloadConcurrency uint16
+	// DownloadBlockSize is the size of the range requests a chunk is split into. 0 is the default of the SDK.
+	DownloadBlockSize int64
+	// MaxRetries is the retries of a failed request. 0 is the default of the SDK, and a negative value disables them.
+	MaxRetries int32
+	// TryTimeout bounds a single try of a request. 0 is the default of the SDK.
+	TryTimeout time.Duration
+}
+
+var (
+	azureOptions atomic.Pointer[AzureOptions]
+	azureConfig  = &blockblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: http.NewClient(),
+		},
+	}
+)
+
+// SetAzureOptions sets the transfer options of the Azure Blob Storage clients created after it.
+func SetAzureOptions(options *AzureOptions) {
+	azureOptions.Store(options)
+}
+
+// azureClientOptions returns the options of a client with the retries of the transfer options.
+func azureClientOptions() *blockblob.ClientOptions {
+	options := azureOptions.Load()
+	if options == nil {
+		return azureConfig
+	}
+
+	clientOptions := *azureConfig
+	clientOptions.Retry = policy.RetryOptions{
+		MaxRetries: options.MaxRetries,
+		TryTimeout: options.TryTimeout,
+	}
+
+	return &clientOptions
+}
 
-var azureConfig = &blockblob.ClientOptions{
-	ClientOptions: azcore.ClientOptions{
-		Transport: http.NewClient(),
-	},
+// stopwatch counts the Azure operation and records its latency.
+func stopwatch(f func(), operation string) {
+	report.AddAPICall("azure", operation)
+	latencyHistogram.Stopwatch(f, operation)
 }
 
 type AzureUploadClient struct {
@@ -27,7 +76,7 @@ type AzureUploadClient struct {
 }
 
 func NewAzureUploadClient(url string) (*AzureUploadClient, error) {
-	client, err := blockblob.NewClientWithNoCredential(url, azureConfig)
+	client, err := blockblob.NewClientWithNoCredential(url, azureClientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("create upload client: %w", err)
 	}
@@ -45,7 +94,7 @@ func (a *AzureUploadClient) UploadBlock(ctx context.Context, blockID string, r i
 		return 0, fmt.Errorf("seek start: %w", err)
 	}
 
-	latencyGauge.Stopwatch(func() {
+	stopwatch(func() {
 		_, err = a.client.StageBlock(ctx, blockID, r, nil)
 	}, "stage_block")
 	if err != nil {
@@ -57,7 +106,7 @@ func (a *AzureUploadClient) UploadBlock(ctx context.Context, blockID string, r i
 
 func (a *AzureUploadClient) UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error {
 	var err error
-	latencyGauge.Stopwatch(func() {
+	stopwatch(func() {
 		_, err = a.client.StageBlockFromURL(ctx, blockID, url, &blockblob.StageBlockFromURLOptions{
 			Range: blob.HTTPRange{Offset: offset, Count: size},
 		})
@@ -71,7 +120,7 @@ func (a *AzureUploadClient) UploadBlockFromURL(ctx context.Context, blockID stri
 
 func (a *AzureUploadClient) Commit(ctx context.Context, blockIDs []string, _ int64) error {
 	var err error
-	latencyGauge.Stopwatch(func() {
+	stopwatch(func() {
 		_, err = a.client.CommitBlockList(ctx, blockIDs, nil)
 	}, "commit_block_list")
 	if err != nil {
@@ -81,14 +130,41 @@ func (a *AzureUploadClient) Commit(ctx context.Context, blockIDs []string, _ int
 	return nil
 }
 
-var _ core.DownloadClient = (*AzureDownloadClient)(nil)
+// StagedBlocks returns the sizes of the uncommitted blocks of the blob by their IDs.
+func (a *AzureUploadClient) StagedBlocks(ctx context.Context) (map[string]int64, error) {
+	var (
+		res blockblob.GetBlockListResponse
+		err error
+	)
+	stopwatch(func() {
+		res, err = a.client.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	}, "get_block_list")
+	if err != nil {
+		return nil, fmt.Errorf("get block list: %w", err)
+	}
+
+	blocks := make(map[string]int64, len(res.UncommittedBlocks))
+	for _, block := range res.UncommittedBlocks {
+		if block.Name == nil || block.Size == nil {
+			continue
+		}
+		blocks[*block.Name] = *block.Size
+	}
+
+	return blocks, nil
+}
+
+var (
+	_ core.DownloadClient     = (*AzureDownloadClient)(nil)
+	_ core.ETagDownloadClient = (*AzureDownloadClient)(nil)
+)
 
 type AzureDownloadClient struct {
 	client *blockblob.Client
 }
 
 func NewAzureDownloadClient(url string) (*AzureDownloadClient, error) {
-	client, err := blockblob.NewClientWithNoCredential(url, azureConfig)
+	client, err := blockblob.NewClientWithNoCredential(url, azureClientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("create download client: %w", err)
 	}
@@ -100,12 +176,31 @@ func (a *AzureDownloadClient) GetURL(context.Context) string {
 	return a.client.URL()
 }
 
+// ETag returns the ETag of the blob.
+func (a *AzureDownloadClient) ETag(ctx context.Context) (string, error) {
+	var (
+		res blob.GetPropertiesResponse
+		err error
+	)
+	stopwatch(func() {
+		res, err = a.client.GetProperties(ctx, nil)
+	}, "get_properties")
+	if err != nil {
+		return "", fmt.Errorf("get properties: %w", err)
+	}
+	if res.ETag == nil {
+		return "", nil
+	}
+
+	return string(*res.ETag), nil
+}
+
 func (a *AzureDownloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
 	var (
 		res blob.DownloadStreamResponse
 		err error
 	)
-	latencyGauge.Stopwatch(func() {
+	stopwatch(func() {
 		res, err = a.client.DownloadStream(ctx, &blob.DownloadStreamOptions{
 			Range: blob.HTTPRange{Offset: offset, Count: size},
 		})
@@ -122,12 +217,23 @@ func (a *AzureDownloadClient) DownloadBlock(ctx context.Context, offset int64, s
 	return nil
 }
 
+// downloadBufferOptions returns the options of downloading the range into a buffer, with the transfer options.
+func (a *AzureDownloadClient) downloadBufferOptions(offset, size int64) *blob.DownloadBufferOptions {
+	options := &blob.DownloadBufferOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: size},
+	}
+	if transfer := azureOptions.Load(); transfer != nil {
+		options.Concurrency = transfer.DownloadConcurrency
+		options.BlockSize = transfer.DownloadBlockSize
+	}
+
+	return options
+}
+
 func (a *AzureDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
 	var err error
-	latencyGauge.Stopwatch(func() {
-		_, err = a.client.DownloadBuffer(ctx, buf, &blob.DownloadBufferOptions{
-			Range: blob.HTTPRange{Offset: offset, Count: size},
-		})
+	stopwatch(func() {
+		_, err = a.client.DownloadBuffer(ctx, buf, a.downloadBufferOptions(offset, size))
 	}, "download_buffer")
 	if err != nil {
 		return fmt.Errorf("download buffer: %w", err)
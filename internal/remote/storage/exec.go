@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/log"
+)
+
+var execLatencyHistogram = metrics.NewHistogram("exec_latency")
+
+// ExecPlugin is a plugin process speaking the exec protocol of the backend package.
+// The requests are sent one at a time, as the protocol answers them in order.
+type ExecPlugin struct {
+	logger log.Logger
+	cmd    *exec.Cmd
+
+	locker sync.Mutex
+	w      *bufio.Writer
+	r      *bufio.Reader
+	// err is set once the stream is out of sync, e.g. the process exited, and fails every later request.
+	err error
+
+	readOnly bool
+}
+
+// StartExecPlugin starts the plugin with the command, and initializes it with the options.
+// The plugin exits when its stdin is closed at the exit of gocica.
+func StartExecPlugin(logger log.Logger, command []string, options map[string]string) (*ExecPlugin, error) {
+	if len(command) == 0 {
+		return nil, errors.New("empty plugin command")
+	}
+
+	// The process outlives the context of the setup, so it is not bound to it.
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = logWriter{logger: logger}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+
+	p := &ExecPlugin{
+		logger: logger,
+		cmd:    cmd,
+		w:      bufio.NewWriter(stdin),
+		r:      bufio.NewReader(stdout),
+	}
+
+	res, err := p.call(&backend.ExecRequest{
+		Op:      backend.ExecOpInit,
+		Version: backend.ExecProtocolVersion,
+		Options: options,
+	}, nil, nil)
+	if err != nil {
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("initialize plugin: %w", err)
+	}
+	p.readOnly = res.ReadOnly
+
+	return p, nil
+}
+
+// ReadOnly reports whether the plugin only reads the remote cache in this run.
+func (p *ExecPlugin) ReadOnly() bool {
+	return p.readOnly
+}
+
+// call sends the request followed by the body, and reads the body of the response with readBody.
+func (p *ExecPlugin) call(req *backend.ExecRequest, body io.Reader, readBody func(r io.Reader, size int64) error) (res *backend.ExecResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	execLatencyHistogram.Stopwatch(func() {
+		report.AddAPICall("exec", req.Op)
+		res, err = p.roundTrip(req, body, readBody)
+	}, req.Op)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("plugin: %s", res.Error)
+	}
+
+	return res, nil
+}
+
+// roundTrip runs a request. An error leaving the stream out of sync breaks the plugin.
+func (p *ExecPlugin) roundTrip(req *backend.ExecRequest, body io.Reader, readBody func(r io.Reader, size int64) error) (*backend.ExecResponse, error) {
+	fail := func(err error) (*backend.ExecResponse, error) {
+		p.err = fmt.Errorf("plugin is broken: %w", err)
+		return nil, p.err
+	}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	if _, err := p.w.Write(append(line, '\n')); err != nil {
+		return fail(fmt.Errorf("write request: %w", err))
+	}
+	if body != nil {
+		if _, err := io.CopyN(p.w, body, req.Size); err != nil {
+			return fail(fmt.Errorf("write body: %w", err))
+		}
+	}
+	if err := p.w.Flush(); err != nil {
+		return fail(fmt.Errorf("flush: %w", err))
+	}
+
+	line, err = p.r.ReadBytes('\n')
+	if err != nil {
+		return fail(fmt.Errorf("read response: %w", err))
+	}
+	var res backend.ExecResponse
+	if err := json.Unmarshal(line, &res); err != nil {
+		return fail(fmt.Errorf("decode response: %w", err))
+	}
+
+	if res.Error == "" && readBody != nil {
+		if err := readBody(io.LimitReader(p.r, res.Size), res.Size); err != nil {
+			return fail(fmt.Errorf("read body: %w", err))
+		}
+	}
+
+	return &res, nil
+}
+
+// FindBlob returns the download client of the blob committed latest, or nil when nothing is committed yet.
+func (p *ExecPlugin) FindBlob(ctx context.Context) (*ExecDownloadClient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := p.call(&backend.ExecRequest{Op: backend.ExecOpFindBlob}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("find blob: %w", err)
+	}
+	if !res.Found {
+		return nil, nil
+	}
+
+	return &ExecDownloadClient{
+		plugin: p,
+		url:    res.URL,
+	}, nil
+}
+
+var _ core.DownloadClient = (*ExecDownloadClient)(nil)
+
+// ExecDownloadClient downloads ranges of the blob found by the plugin.
+type ExecDownloadClient struct {
+	plugin *ExecPlugin
+	url    string
+}
+
+func (e *ExecDownloadClient) GetURL(context.Context) string {
+	return e.url
+}
+
+func (e *ExecDownloadClient) download(ctx context.Context, offset, size int64, readBody func(r io.Reader, size int64) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := e.plugin.call(&backend.ExecRequest{
+		Op:     backend.ExecOpDownload,
+		URL:    e.url,
+		Offset: offset,
+		Size:   size,
+	}, nil, func(r io.Reader, got int64) error {
+		if got != size {
+			// The body is skipped to keep the stream in sync.
+			_, _ = io.Copy(io.Discard, r)
+			return fmt.Errorf("got %d bytes, want %d", got, size)
+		}
+		return readBody(r, got)
+	})
+
+	return err
+}
+
+func (e *ExecDownloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	err := e.download(ctx, offset, size, func(r io.Reader, size int64) error {
+		_, err := io.CopyN(w, r, size)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("download stream: %w", err)
+	}
+
+	return nil
+}
+
+func (e *ExecDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	err := e.download(ctx, offset, size, func(r io.Reader, size int64) error {
+		_, err := io.ReadFull(r, buf[:size])
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("download buffer: %w", err)
+	}
+
+	return nil
+}
+
+var (
+	_ core.UploadClient         = (*ExecUploadClient)(nil)
+	_ core.ExpiringUploadClient = (*ExecUploadClient)(nil)
+)
+
+// ExecUploadClient stages blocks with the plugin and commits them.
+type ExecUploadClient struct {
+	plugin *ExecPlugin
+	// expiresAt is sent with the commit, in Unix nanoseconds. 0 never expires.
+	expiresAt int64
+}
+
+// NewExecUploadClient returns the upload client of the plugin.
+func NewExecUploadClient(plugin *ExecPlugin) *ExecUploadClient {
+	return &ExecUploadClient{plugin: plugin}
+}
+
+func (e *ExecUploadClient) UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("get size: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek start: %w", err)
+	}
+
+	_, err = e.plugin.call(&backend.ExecRequest{
+		Op:      backend.ExecOpUploadBlock,
+		BlockID: blockID,
+		Size:    size,
+	}, r, nil)
+	if err != nil {
+		return 0, fmt.Errorf("stage block: %w", err)
+	}
+
+	return size, nil
+}
+
+func (e *ExecUploadClient) UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := e.plugin.call(&backend.ExecRequest{
+		Op:      backend.ExecOpUploadBlockFromURL,
+		BlockID: blockID,
+		URL:     url,
+		Offset:  offset,
+		Size:    size,
+	}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("stage block from url: %w", err)
+	}
+
+	return nil
+}
+
+// SetExpiry sends the time the blob expires with the commit.
+func (e *ExecUploadClient) SetExpiry(expiresAt time.Time) {
+	e.expiresAt = expiresAt.UnixNano()
+}
+
+func (e *ExecUploadClient) Commit(ctx context.Context, blockIDs []string, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := e.plugin.call(&backend.ExecRequest{
+		Op:        backend.ExecOpCommit,
+		BlockIDs:  blockIDs,
+		Size:      size,
+		ExpiresAt: e.expiresAt,
+	}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+// logWriter writes the stderr of the plugin to the log, a line at a time.
+type logWriter struct {
+	logger log.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for line := range bytes.Lines(p) {
+		if line := bytes.TrimSpace(line); len(line) > 0 {
+			w.logger.Infof("plugin: %s", line)
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mazrean/gocica/backend"
+	"github.com/mazrean/gocica/backend/example/dirstore"
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/log"
+)
+
+// execPluginEnv makes the test binary serve the example backend as a plugin.
+const execPluginEnv = "GOCICA_TEST_EXEC_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(execPluginEnv) != "" {
+		if err := backend.ServeExec(context.Background(), os.Stdin, os.Stdout, dirstore.New); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestExecPlugin(t *testing.T) {
+	t.Setenv(execPluginEnv, "1")
+
+	ctx := context.Background()
+	options := map[string]string{"path": t.TempDir()}
+
+	plugin, err := StartExecPlugin(log.DefaultLogger, []string{os.Args[0]}, options)
+	if err != nil {
+		t.Fatalf("failed to start plugin: %v", err)
+	}
+	if plugin.ReadOnly() {
+		t.Error("expected the plugin to write")
+	}
+
+	downloadClient, err := plugin.FindBlob(ctx)
+	if err != nil || downloadClient != nil {
+		t.Fatalf("expected no blob, got %v, %v", downloadClient, err)
+	}
+
+	uploadClient := NewExecUploadClient(plugin)
+	for _, block := range []struct{ id, content string }{{"a", "header"}, {"b", "outputs"}} {
+		if _, err := uploadClient.UploadBlock(ctx, block.id, myio.NopSeekCloser(strings.NewReader(block.content))); err != nil {
+			t.Fatalf("failed to upload block: %v", err)
+		}
+	}
+	if err := uploadClient.Commit(ctx, []string{"a", "b"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	downloadClient, err = plugin.FindBlob(ctx)
+	if err != nil || downloadClient == nil {
+		t.Fatalf("failed to find blob: %v, %v", downloadClient, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := downloadClient.DownloadBlock(ctx, 6, 7, buf); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if buf.String() != "outputs" {
+		t.Errorf("got %q, want %q", buf.String(), "outputs")
+	}
+
+	// An error of the plugin fails the request, and the later ones still run.
+	if err := downloadClient.DownloadBlock(ctx, 10, 10, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error downloading beyond the blob")
+	}
+	if err := uploadClient.UploadBlockFromURL(ctx, "b", downloadClient.GetURL(ctx), 6, 7); err != nil {
+		t.Fatalf("failed to copy block: %v", err)
+	}
+	if _, err := uploadClient.UploadBlock(ctx, "a", myio.NopSeekCloser(strings.NewReader("HEADER"))); err != nil {
+		t.Fatalf("failed to upload block: %v", err)
+	}
+	if err := uploadClient.Commit(ctx, []string{"a", "b"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	downloadClient, err = plugin.FindBlob(ctx)
+	if err != nil || downloadClient == nil {
+		t.Fatalf("failed to find blob: %v, %v", downloadClient, err)
+	}
+	got := make([]byte, 13)
+	if err := downloadClient.DownloadBlockBuffer(ctx, 0, 13, got); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if string(got) != "HEADERoutputs" {
+		t.Errorf("got %q, want %q", got, "HEADERoutputs")
+	}
+
+	// A plugin exited breaks the later requests.
+	if err := plugin.cmd.Process.Kill(); err != nil {
+		t.Fatalf("failed to kill plugin: %v", err)
+	}
+	_ = plugin.cmd.Wait()
+	if _, err := plugin.FindBlob(ctx); err == nil {
+		t.Error("expected an error after the plugin exited")
+	}
+	if _, err := plugin.FindBlob(ctx); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the plugin to be broken, got %v", err)
+	}
+}
+
+func TestStartExecPlugin_invalidOptions(t *testing.T) {
+	t.Setenv(execPluginEnv, "1")
+
+	// The example backend requires the path option.
+	if _, err := StartExecPlugin(log.DefaultLogger, []string{os.Args[0]}, nil); err == nil {
+		t.Error("expected an error initializing the plugin")
+	}
+}
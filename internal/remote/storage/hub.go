@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mazrean/gocica/internal/hub"
+	myhttp "github.com/mazrean/gocica/internal/pkg/http"
+	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/remote/core"
+)
+
+var hubLatencyHistogram = metrics.NewHistogram("hub_latency")
+
+// hubStopwatch counts the hub operation and records its latency.
+func hubStopwatch(f func(), operation string) {
+	report.AddAPICall("hub", operation)
+	hubLatencyHistogram.Stopwatch(f, operation)
+}
+
+// ErrHubBlobNotFound is returned by FindHubBlob when nothing is committed to the hub yet.
+var ErrHubBlobNotFound = errors.New("no blob is committed to the hub")
+
+// FindHubBlob returns the URL of the latest version of the blob of the hub at baseURL.
+func FindHubBlob(ctx context.Context, client *http.Client, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+hub.BlobPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	// Only the redirect to the version is needed, not its content.
+	req.Header.Set("Range", "bytes=0-0")
+
+	var res *http.Response
+	hubStopwatch(func() {
+		res, err = client.Do(req)
+	}, "find_blob")
+	if err != nil {
+		return "", fmt.Errorf("find blob: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return res.Request.URL.String(), nil
+	case http.StatusNotFound:
+		return "", ErrHubBlobNotFound
+	default:
+		return "", hubStatusError(res)
+	}
+}
+
+func hubStatusError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+var _ core.UploadClient = (*HubUploadClient)(nil)
+
+// HubUploadClient stages blocks to the hub served by gocica serve --http and commits them as the next version of its blob.
+type HubUploadClient struct {
+	client  *http.Client
+	baseURL string
+	// session is the upload session of the client, so that the blocks it stages are not committed or removed by other clients.
+	session string
+	// mirrorURLs are the base URLs of the replicas of the hub, whose versions are also versions of the hub.
+	mirrorURLs []string
+}
+
+// NewHubUploadClient creates a client committing to the hub at baseURL.
+// The versions of the blob read from the mirrors are copied from the same versions of the hub.
+func NewHubUploadClient(client *http.Client, baseURL string, mirrorURLs ...string) *HubUploadClient {
+	if client == nil {
+		client = myhttp.NewClient()
+	}
+
+	h := &HubUploadClient{
+		client:  client,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		session: rand.Text(),
+	}
+	for _, mirrorURL := range mirrorURLs {
+		if mirrorURL != "" {
+			h.mirrorURLs = append(h.mirrorURLs, strings.TrimSuffix(mirrorURL, "/"))
+		}
+	}
+
+	return h
+}
+
+// version returns the version of the blob at the URL, of the hub or one of its mirrors.
+func (h *HubUploadClient) version(blobURL string) (string, bool) {
+	for _, baseURL := range append([]string{h.baseURL}, h.mirrorURLs...) {
+		if version, ok := strings.CutPrefix(blobURL, baseURL+hub.VersionsPath); ok {
+			return version, true
+		}
+	}
+
+	return "", false
+}
+
+func (h *HubUploadClient) do(req *http.Request, operation string, wantStatus int) error {
+	var (
+		res *http.Response
+		err error
+	)
+	hubStopwatch(func() {
+		res, err = h.client.Do(req)
+	}, operation)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != wantStatus {
+		return hubStatusError(res)
+	}
+
+	return nil
+}
+
+func (h *HubUploadClient) UploadBlock(ctx context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("get size: %w", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("seek start: %w", err)
+	}
+
+	query := url.Values{"id": {blockID}, hub.SessionQuery: {h.session}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.baseURL+hub.BlocksPath+"?"+query.Encode(), io.NopCloser(r))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = size
+
+	if err := h.do(req, "stage_block", http.StatusCreated); err != nil {
+		return 0, fmt.Errorf("stage block: %w", err)
+	}
+
+	return size, nil
+}
+
+// UploadBlockFromURL stages a range of a version of the blob of the same hub, which it copies without sending the bytes.
+// A version read from a mirror is copied from the hub, which fails when the mirror is ahead of it.
+func (h *HubUploadClient) UploadBlockFromURL(ctx context.Context, blockID string, blobURL string, offset, size int64) error {
+	version, ok := h.version(blobURL)
+	if !ok {
+		return fmt.Errorf("%s is not a blob of the hub %s", blobURL, h.baseURL)
+	}
+
+	query := url.Values{
+		"id":             {blockID},
+		hub.SessionQuery: {h.session},
+		"version":        {version},
+		"offset":         {strconv.FormatInt(offset, 10)},
+		"size":           {strconv.FormatInt(size, 10)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.baseURL+hub.BlocksPath+"?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	if err := h.do(req, "stage_block_from_url", http.StatusCreated); err != nil {
+		return fmt.Errorf("stage block from url: %w", err)
+	}
+
+	return nil
+}
+
+func (h *HubUploadClient) Commit(ctx context.Context, blockIDs []string, _ int64) error {
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(&hub.CommitRequest{Blocks: blockIDs}); err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	query := url.Values{hub.SessionQuery: {h.session}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+hub.CommitPath+"?"+query.Encode(), buf)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := h.do(req, "commit", http.StatusNoContent); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+var (
+	_ core.DownloadClient     = (*HubDownloadClient)(nil)
+	_ core.ETagDownloadClient = (*HubDownloadClient)(nil)
+)
+
+// HubDownloadClient downloads ranges of a version of the blob of the hub.
+type HubDownloadClient struct {
+	client  *http.Client
+	blobURL string
+}
+
+// NewHubDownloadClient creates a client of the version of the blob at blobURL, which FindHubBlob returns.
+func NewHubDownloadClient(client *http.Client, blobURL string) *HubDownloadClient {
+	if client == nil {
+		client = myhttp.NewClient()
+	}
+
+	return &HubDownloadClient{
+		client:  client,
+		blobURL: blobURL,
+	}
+}
+
+func (h *HubDownloadClient) GetURL(context.Context) string {
+	return h.blobURL
+}
+
+// ETag returns the ETag of the version of the blob, or "" when the hub gives none.
+func (h *HubDownloadClient) ETag(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.blobURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+
+	var res *http.Response
+	hubStopwatch(func() {
+		res, err = h.client.Do(req)
+	}, "get_etag")
+	if err != nil {
+		return "", fmt.Errorf("get etag: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get etag: %w", hubStatusError(res))
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+// download requests the range and calls f with its body.
+func (h *HubDownloadClient) download(ctx context.Context, offset, size int64, operation string, f func(io.Reader) error) error {
+	if size == 0 {
+		return f(http.NoBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.blobURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	var res *http.Response
+	hubStopwatch(func() {
+		res, err = h.client.Do(req)
+	}, operation)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		return hubStatusError(res)
+	}
+
+	return f(res.Body)
+}
+
+func (h *HubDownloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	err := h.download(ctx, offset, size, "download_stream", func(r io.Reader) error {
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("copy: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("download stream: %w", err)
+	}
+
+	return nil
+}
+
+func (h *HubDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	err := h.download(ctx, offset, size, "download_buffer", func(r io.Reader) error {
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("download buffer: %w", err)
+	}
+
+	return nil
+}
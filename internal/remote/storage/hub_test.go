@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mazrean/gocica/internal/hub"
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/log"
+)
+
+func TestHubClients(t *testing.T) {
+	t.Parallel()
+
+	server, err := hub.NewServer(log.DefaultLogger, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	httpClient := ts.Client()
+
+	if _, err := FindHubBlob(ctx, httpClient, ts.URL); !errors.Is(err, ErrHubBlobNotFound) {
+		t.Fatalf("expected ErrHubBlobNotFound, got %v", err)
+	}
+
+	uploadClient := NewHubUploadClient(httpClient, ts.URL)
+	for blockID, content := range map[string]string{"a/b+c=": "header", "d": "outputs"} {
+		size, err := uploadClient.UploadBlock(ctx, blockID, myio.NopSeekCloser(strings.NewReader(content)))
+		if err != nil {
+			t.Fatalf("failed to upload block %s: %v", blockID, err)
+		}
+		if size != int64(len(content)) {
+			t.Errorf("size of block %s: got %d, want %d", blockID, size, len(content))
+		}
+	}
+	if err := uploadClient.Commit(ctx, []string{"a/b+c=", "d"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	firstURL, err := FindHubBlob(ctx, httpClient, ts.URL)
+	if err != nil {
+		t.Fatalf("failed to find blob: %v", err)
+	}
+	first := NewHubDownloadClient(httpClient, firstURL)
+
+	buf := make([]byte, 7)
+	if err := first.DownloadBlockBuffer(ctx, 6, 7, buf); err != nil {
+		t.Fatalf("failed to download buffer: %v", err)
+	}
+	if string(buf) != "outputs" {
+		t.Errorf("buffer: got %q, want %q", buf, "outputs")
+	}
+
+	// The next version keeps the outputs of the first one by copying them in the hub.
+	if err := uploadClient.UploadBlockFromURL(ctx, "copied", first.GetURL(ctx), 6, 7); err != nil {
+		t.Fatalf("failed to upload block from url: %v", err)
+	}
+	if _, err := uploadClient.UploadBlock(ctx, "new", myio.NopSeekCloser(strings.NewReader("HEADER"))); err != nil {
+		t.Fatalf("failed to upload block: %v", err)
+	}
+	if err := uploadClient.Commit(ctx, []string{"new", "copied"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	secondURL, err := FindHubBlob(ctx, httpClient, ts.URL)
+	if err != nil {
+		t.Fatalf("failed to find blob: %v", err)
+	}
+	if secondURL == firstURL {
+		t.Fatalf("expected a new version, got %s again", secondURL)
+	}
+
+	firstETag, err := first.ETag(ctx)
+	if err != nil {
+		t.Fatalf("failed to get etag: %v", err)
+	}
+	secondETag, err := NewHubDownloadClient(httpClient, secondURL).ETag(ctx)
+	if err != nil {
+		t.Fatalf("failed to get etag: %v", err)
+	}
+	if firstETag == "" || firstETag == secondETag {
+		t.Errorf("etags: got %q and %q, want distinct ones", firstETag, secondETag)
+	}
+
+	var out bytes.Buffer
+	if err := NewHubDownloadClient(httpClient, secondURL).DownloadBlock(ctx, 0, 13, &out); err != nil {
+		t.Fatalf("failed to download block: %v", err)
+	}
+	if out.String() != "HEADERoutputs" {
+		t.Errorf("second version: got %q, want %q", out.String(), "HEADERoutputs")
+	}
+
+	// A client of the first version is not affected by the commit of the second.
+	out.Reset()
+	if err := first.DownloadBlock(ctx, 0, 6, &out); err != nil {
+		t.Fatalf("failed to download block: %v", err)
+	}
+	if out.String() != "header" {
+		t.Errorf("first version: got %q, want %q", out.String(), "header")
+	}
+
+	// A version read from a replica is copied from the same version of the hub.
+	mirrorClient := NewHubUploadClient(httpClient, ts.URL, "http://replica.example.com/")
+	if err := mirrorClient.UploadBlockFromURL(ctx, "mirrored", "http://replica.example.com"+strings.TrimPrefix(firstURL, ts.URL), 6, 7); err != nil {
+		t.Errorf("failed to upload block from the url of the replica: %v", err)
+	}
+
+	if err := uploadClient.Commit(ctx, []string{"missing"}, 0); err == nil {
+		t.Error("expected error for a block not staged, got nil")
+	}
+	if err := uploadClient.UploadBlockFromURL(ctx, "other", "http://example.com/blobs/1", 0, 1); err == nil {
+		t.Error("expected error for a blob of another server, got nil")
+	}
+}
+
+func TestHubDownloadClient_notFound(t *testing.T) {
+	t.Parallel()
+
+	server, err := hub.NewServer(log.DefaultLogger, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	client := NewHubDownloadClient(ts.Client(), ts.URL+hub.VersionsPath+"1")
+	err = client.DownloadBlockBuffer(context.Background(), 0, 1, make([]byte, 1))
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
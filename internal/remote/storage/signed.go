@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	myhttp "github.com/mazrean/gocica/internal/pkg/http"
+	"github.com/mazrean/gocica/internal/pkg/metrics"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/remote"
+	"github.com/mazrean/gocica/internal/remote/core"
+)
+
+var signedLatencyHistogram = metrics.NewHistogram("signed_url_latency")
+
+// signedStopwatch counts the operation on a signed URL and records its latency.
+func signedStopwatch(f func(), operation string) {
+	report.AddAPICall("signed_url", operation)
+	signedLatencyHistogram.Stopwatch(f, operation)
+}
+
+// ErrSignedBlobNotFound is returned by FindSignedBlob when no object is at the signed URL yet.
+var ErrSignedBlobNotFound = errors.New("no object is at the signed URL")
+
+// FindSignedBlob checks that an object is at the pre-signed download URL.
+// Object stores answer a missing object with 404 only when the signer may list the bucket, and with 403 otherwise,
+// which is reported as an error.
+func FindSignedBlob(ctx context.Context, client *http.Client, downloadURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	// Only the existence is needed, not the content.
+	req.Header.Set("Range", "bytes=0-0")
+
+	var res *http.Response
+	signedStopwatch(func() {
+		res, err = client.Do(req)
+	}, "find_blob")
+	if err != nil {
+		return fmt.Errorf("find blob: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return nil
+	case http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+		return ErrSignedBlobNotFound
+	default:
+		return hubStatusError(res)
+	}
+}
+
+// signedCapabilities are the capabilities of an object store behind pre-signed URLs,
+// which reads ranges but takes the whole object in a single PUT.
+var signedCapabilities = remote.Capabilities{RangedReads: true}
+
+var (
+	_ core.DownloadClient     = (*SignedDownloadClient)(nil)
+	_ core.ETagDownloadClient = (*SignedDownloadClient)(nil)
+	_ core.CapableClient      = (*SignedDownloadClient)(nil)
+)
+
+// SignedDownloadClient downloads ranges of the object at a pre-signed download URL, e.g. of S3 or GCS.
+type SignedDownloadClient struct {
+	client      *http.Client
+	downloadURL string
+}
+
+// NewSignedDownloadClient creates a client of the object at the pre-signed download URL.
+func NewSignedDownloadClient(client *http.Client, downloadURL string) *SignedDownloadClient {
+	if client == nil {
+		client = myhttp.NewClient()
+	}
+
+	return &SignedDownloadClient{
+		client:      client,
+		downloadURL: downloadURL,
+	}
+}
+
+func (s *SignedDownloadClient) GetURL(context.Context) string {
+	return s.downloadURL
+}
+
+func (s *SignedDownloadClient) Capabilities() remote.Capabilities {
+	return signedCapabilities
+}
+
+// ETag returns the ETag of the object, which another job may replace while it is read.
+func (s *SignedDownloadClient) ETag(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.downloadURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	// A pre-signed URL is signed for GET, so the headers are read from a GET of a single byte instead of a HEAD.
+	req.Header.Set("Range", "bytes=0-0")
+
+	var res *http.Response
+	signedStopwatch(func() {
+		res, err = s.client.Do(req)
+	}, "get_etag")
+	if err != nil {
+		return "", fmt.Errorf("get etag: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		return "", fmt.Errorf("get etag: %w", hubStatusError(res))
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+func (s *SignedDownloadClient) DownloadBlock(ctx context.Context, offset int64, size int64, w io.Writer) error {
+	if err := downloadSignedRange(ctx, s.client, s.downloadURL, offset, size, "download_stream", w); err != nil {
+		return fmt.Errorf("download stream: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SignedDownloadClient) DownloadBlockBuffer(ctx context.Context, offset int64, size int64, buf []byte) error {
+	w := &sliceWriter{buf: buf[:size]}
+	if err := downloadSignedRange(ctx, s.client, s.downloadURL, offset, size, "download_buffer", w); err != nil {
+		return fmt.Errorf("download buffer: %w", err)
+	}
+
+	return nil
+}
+
+// sliceWriter writes into a slice, failing when it is full.
+type sliceWriter struct {
+	buf []byte
+	n   int64
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[w.n:], p)
+	w.n += int64(n)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+
+	return n, nil
+}
+
+// downloadSignedRange writes the range of the object at the URL to w.
+func downloadSignedRange(ctx context.Context, client *http.Client, url string, offset, size int64, operation string, w io.Writer) error {
+	if size == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	var res *http.Response
+	signedStopwatch(func() {
+		res, err = client.Do(req)
+	}, operation)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		return hubStatusError(res)
+	}
+
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("copy: %w", io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
+var (
+	_ core.UploadClient  = (*SignedUploadClient)(nil)
+	_ core.CapableClient = (*SignedUploadClient)(nil)
+)
+
+// SignedUploadClient writes the blob to a pre-signed upload URL, e.g. of S3 or GCS, taking the whole object in a single PUT.
+// The blocks are staged in a spool file on the local disk, and sent in the order committed by Commit.
+type SignedUploadClient struct {
+	client    *http.Client
+	uploadURL string
+	spoolDir  string
+
+	locker sync.Mutex
+	spool  *os.File
+	size   int64
+	blocks map[string]signedBlock
+}
+
+// signedBlock is a block staged in the spool file.
+type signedBlock struct {
+	offset int64
+	size   int64
+}
+
+// NewSignedUploadClient creates a client of the pre-signed upload URL, staging the blocks in spoolDir.
+func NewSignedUploadClient(client *http.Client, uploadURL, spoolDir string) *SignedUploadClient {
+	if client == nil {
+		client = myhttp.NewClient()
+	}
+
+	return &SignedUploadClient{
+		client:    client,
+		uploadURL: uploadURL,
+		spoolDir:  spoolDir,
+		blocks:    map[string]signedBlock{},
+	}
+}
+
+func (s *SignedUploadClient) Capabilities() remote.Capabilities {
+	return signedCapabilities
+}
+
+// reserve returns the spool file and the offset of a block of the size in it, creating the file on the first block.
+func (s *SignedUploadClient) reserve(size int64) (*os.File, int64, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if s.spool == nil {
+		spool, err := os.CreateTemp(s.spoolDir, "signed-upload-*")
+		if err != nil {
+			return nil, 0, fmt.Errorf("create spool file: %w", err)
+		}
+		s.spool = spool
+	}
+
+	offset := s.size
+	s.size += size
+
+	return s.spool, offset, nil
+}
+
+// stage writes size bytes of r as the block.
+func (s *SignedUploadClient) stage(blockID string, r io.Reader, size int64) error {
+	spool, offset, err := s.reserve(size)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(spool, offset), io.LimitReader(r, size))
+	if err != nil {
+		return fmt.Errorf("write spool file: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("write spool file: %w", io.ErrUnexpectedEOF)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.blocks[blockID] = signedBlock{offset: offset, size: size}
+
+	return nil
+}
+
+func (s *SignedUploadClient) UploadBlock(_ context.Context, blockID string, r io.ReadSeekCloser) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("get size: %w", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("seek start: %w", err)
+	}
+
+	signedStopwatch(func() {
+		err = s.stage(blockID, r, size)
+	}, "stage_block")
+	if err != nil {
+		return 0, fmt.Errorf("stage block: %w", err)
+	}
+
+	return size, nil
+}
+
+// UploadBlockFromURL downloads the range of the object at the signed download URL into the spool file,
+// as a signed URL cannot copy from another object.
+func (s *SignedUploadClient) UploadBlockFromURL(ctx context.Context, blockID string, url string, offset, size int64) error {
+	spool, spoolOffset, err := s.reserve(size)
+	if err != nil {
+		return fmt.Errorf("stage block from url: %w", err)
+	}
+
+	if err := downloadSignedRange(ctx, s.client, url, offset, size, "stage_block_from_url", io.NewOffsetWriter(spool, spoolOffset)); err != nil {
+		return fmt.Errorf("stage block from url: %w", err)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.blocks[blockID] = signedBlock{offset: spoolOffset, size: size}
+
+	return nil
+}
+
+// Commit sends the blocks in the order given as the object, and removes the spool file.
+func (s *SignedUploadClient) Commit(ctx context.Context, blockIDs []string, size int64) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	readers := make([]io.Reader, 0, len(blockIDs))
+	var total int64
+	for _, blockID := range blockIDs {
+		block, ok := s.blocks[blockID]
+		if !ok {
+			return fmt.Errorf("commit: block %s is not staged", blockID)
+		}
+		readers = append(readers, io.NewSectionReader(s.spool, block.offset, block.size))
+		total += block.size
+	}
+	if total != size {
+		return fmt.Errorf("commit: the blocks have %d bytes, want %d", total, size)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.uploadURL, io.NopCloser(io.MultiReader(readers...)))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = total
+	if total == 0 {
+		req.Body = http.NoBody
+	}
+
+	var res *http.Response
+	signedStopwatch(func() {
+		res, err = s.client.Do(req)
+	}, "commit")
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("commit: %w", hubStatusError(res))
+	}
+
+	s.closeSpool()
+
+	return nil
+}
+
+// closeSpool removes the spool file. The caller holds the locker.
+func (s *SignedUploadClient) closeSpool() {
+	if s.spool == nil {
+		return
+	}
+
+	_ = s.spool.Close()
+	_ = os.Remove(s.spool.Name())
+	s.spool = nil
+	s.size = 0
+	s.blocks = map[string]signedBlock{}
+}
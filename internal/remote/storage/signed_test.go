@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	myio "github.com/mazrean/gocica/internal/pkg/io"
+)
+
+// objectStore serves a single object like an object store behind pre-signed URLs: ranged GETs and whole PUTs.
+type objectStore struct {
+	locker sync.Mutex
+	object []byte
+}
+
+func (s *objectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.object == nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(s.object))
+	case http.MethodPut:
+		object, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.object = object
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSignedClients(t *testing.T) {
+	t.Parallel()
+
+	store := &objectStore{}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+
+	ctx := context.Background()
+	httpClient := ts.Client()
+	objectURL := ts.URL + "/bucket/blob?X-Amz-Signature=signature"
+
+	if err := FindSignedBlob(ctx, httpClient, objectURL); !errors.Is(err, ErrSignedBlobNotFound) {
+		t.Fatalf("expected ErrSignedBlobNotFound, got %v", err)
+	}
+
+	uploadClient := NewSignedUploadClient(httpClient, objectURL, t.TempDir())
+	for blockID, content := range map[string]string{"header": "header", "outputs": "outputs"} {
+		if _, err := uploadClient.UploadBlock(ctx, blockID, myio.NopSeekCloser(strings.NewReader(content))); err != nil {
+			t.Fatalf("failed to upload block %s: %v", blockID, err)
+		}
+	}
+	if err := uploadClient.Commit(ctx, []string{"header", "outputs"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if string(store.object) != "headeroutputs" {
+		t.Fatalf("object: got %q, want %q", store.object, "headeroutputs")
+	}
+
+	if err := FindSignedBlob(ctx, httpClient, objectURL); err != nil {
+		t.Fatalf("failed to find blob: %v", err)
+	}
+	downloadClient := NewSignedDownloadClient(httpClient, objectURL)
+
+	buf := make([]byte, 7)
+	if err := downloadClient.DownloadBlockBuffer(ctx, 6, 7, buf); err != nil {
+		t.Fatalf("failed to download buffer: %v", err)
+	}
+	if string(buf) != "outputs" {
+		t.Errorf("buffer: got %q, want %q", buf, "outputs")
+	}
+
+	// The outputs of the object read are downloaded again into the next one.
+	if err := uploadClient.UploadBlockFromURL(ctx, "copied", downloadClient.GetURL(ctx), 6, 7); err != nil {
+		t.Fatalf("failed to upload block from url: %v", err)
+	}
+	if _, err := uploadClient.UploadBlock(ctx, "new", myio.NopSeekCloser(strings.NewReader("HEADER"))); err != nil {
+		t.Fatalf("failed to upload block: %v", err)
+	}
+	if err := uploadClient.Commit(ctx, []string{"new", "copied"}, 13); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := downloadClient.DownloadBlock(ctx, 0, 13, &out); err != nil {
+		t.Fatalf("failed to download block: %v", err)
+	}
+	if out.String() != "HEADERoutputs" {
+		t.Errorf("second object: got %q, want %q", out.String(), "HEADERoutputs")
+	}
+
+	if err := uploadClient.Commit(ctx, []string{"missing"}, 0); err == nil {
+		t.Error("expected error for a block not staged, got nil")
+	}
+	if err := downloadClient.DownloadBlockBuffer(ctx, 10, 7, make([]byte, 7)); err == nil {
+		t.Error("expected error for a range beyond the object, got nil")
+	}
+}
@@ -0,0 +1,146 @@
+// Package scratch places the local cache on the best volume of a GitHub-hosted runner.
+// The runners have a temporary disk (/mnt on Linux, the disk of RUNNER_TEMP on Windows)
+// that is often faster and larger than the home directory where the user cache directory is,
+// and the local cache does not outlive the job on such a runner anyway.
+package scratch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mazrean/gocica/internal/pkg/diskspace"
+)
+
+const (
+	// probeSize is the size of the file written to measure the throughput of a volume.
+	probeSize = 8 << 20
+	// minFreeSpace is the free space a volume needs to be chosen.
+	minFreeSpace = 4 << 30
+	// choiceFile is the file in RUNNER_TEMP remembering the choice for the later go commands of the job.
+	choiceFile = "gocica-scratch"
+)
+
+// Enabled reports whether the process runs on a GitHub-hosted runner, where the local cache is placed on the best volume.
+// A self-hosted runner keeps the user cache directory across jobs, so it is left there.
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true" && os.Getenv("RUNNER_ENVIRONMENT") == "github-hosted"
+}
+
+// Candidates returns the directories the local cache may be placed in, the default first.
+func Candidates(defaultDir string) []string {
+	candidates := []string{defaultDir}
+	if runnerTemp := os.Getenv("RUNNER_TEMP"); runnerTemp != "" {
+		candidates = append(candidates, filepath.Join(runnerTemp, "gocica"))
+	}
+	if info, err := os.Stat("/mnt"); err == nil && info.IsDir() {
+		candidates = append(candidates, "/mnt/gocica")
+	}
+
+	return candidates
+}
+
+// Probe is the result of probing a candidate.
+type Probe struct {
+	Dir        string
+	Free       uint64
+	Throughput float64
+	Err        error
+}
+
+// probe measures the throughput of writing a file synced to the volume of dir.
+func probe(dir string) *Probe {
+	p := &Probe{Dir: dir}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		p.Err = fmt.Errorf("create directory: %w", err)
+		return p
+	}
+
+	free, err := diskspace.Free(dir)
+	if err != nil {
+		p.Err = fmt.Errorf("get free space: %w", err)
+		return p
+	}
+	p.Free = free
+	if free < minFreeSpace {
+		p.Err = fmt.Errorf("only %d bytes free", free)
+		return p
+	}
+
+	f, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		p.Err = fmt.Errorf("create probe file: %w", err)
+		return p
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	start := time.Now()
+	if _, err := f.Write(bytes.Repeat([]byte{0xa5}, probeSize)); err != nil {
+		p.Err = fmt.Errorf("write probe file: %w", err)
+		return p
+	}
+	if err := f.Sync(); err != nil {
+		p.Err = fmt.Errorf("sync probe file: %w", err)
+		return p
+	}
+	p.Throughput = probeSize / max(time.Since(start).Seconds(), 1e-6)
+
+	return p
+}
+
+// Choose probes the candidates and returns the fastest one with enough free space,
+// or the first one when none has. The probes are returned for the log.
+func Choose(candidates []string) (string, []*Probe) {
+	probes := make([]*Probe, 0, len(candidates))
+	var best *Probe
+	for _, candidate := range candidates {
+		p := probe(candidate)
+		probes = append(probes, p)
+		if p.Err == nil && (best == nil || p.Throughput > best.Throughput) {
+			best = p
+		}
+	}
+
+	if best == nil {
+		return candidates[0], probes
+	}
+
+	return best.Dir, probes
+}
+
+// Place returns the directory of the local cache on a GitHub-hosted runner, defaultDir when it cannot be placed.
+// The first go command of the job probes the candidates, and the later ones reuse its choice from RUNNER_TEMP,
+// so that only one of them pays for the probes.
+func Place(defaultDir string) (string, []*Probe, error) {
+	var choicePath string
+	if runnerTemp := os.Getenv("RUNNER_TEMP"); runnerTemp != "" {
+		choicePath = filepath.Join(runnerTemp, choiceFile)
+		choice, err := os.ReadFile(choicePath)
+		switch {
+		case err == nil && strings.TrimSpace(string(choice)) != "":
+			return strings.TrimSpace(string(choice)), nil, nil
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return defaultDir, nil, fmt.Errorf("read choice: %w", err)
+		}
+	}
+
+	dir, probes := Choose(Candidates(defaultDir))
+	if choicePath != "" {
+		// The choice is written atomically, as the go commands of the job may start at the same time.
+		tmp := fmt.Sprintf("%s.%d", choicePath, os.Getpid())
+		if err := os.WriteFile(tmp, []byte(dir), 0o644); err != nil {
+			return dir, probes, fmt.Errorf("write choice: %w", err)
+		}
+		if err := os.Rename(tmp, choicePath); err != nil {
+			return dir, probes, fmt.Errorf("write choice: %w", err)
+		}
+	}
+
+	return dir, probes, nil
+}
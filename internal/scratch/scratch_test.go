@@ -0,0 +1,70 @@
+package scratch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChoose(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// A file in the way of the directory makes the candidate fail the probe.
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		candidates []string
+		want       string
+	}{
+		{name: "fallback to the default", candidates: []string{filepath.Join(blocked, "default")}, want: filepath.Join(blocked, "default")},
+		{name: "skip failed candidates", candidates: []string{filepath.Join(blocked, "default"), filepath.Join(dir, "scratch")}, want: filepath.Join(dir, "scratch")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, probes := Choose(tt.candidates)
+			if len(probes) != len(tt.candidates) {
+				t.Errorf("expected %d probes, got %d", len(tt.candidates), len(probes))
+			}
+			if probes[0].Err == nil {
+				t.Error("expected the blocked candidate to fail")
+			}
+			want := tt.want
+			if probes[len(probes)-1].Err != nil {
+				// The temporary directory may be on a volume without enough free space.
+				want = tt.candidates[0]
+			}
+			if got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPlace_remembered(t *testing.T) {
+	runnerTemp := t.TempDir()
+	t.Setenv("RUNNER_TEMP", runnerTemp)
+
+	want := filepath.Join(runnerTemp, "chosen")
+	if err := os.WriteFile(filepath.Join(runnerTemp, choiceFile), []byte(want+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write choice: %v", err)
+	}
+
+	got, probes, err := Place(filepath.Join(runnerTemp, "default"))
+	if err != nil {
+		t.Fatalf("failed to place: %v", err)
+	}
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if probes != nil {
+		t.Error("expected no probes for a remembered choice")
+	}
+}
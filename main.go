@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/alecthomas/kong"
-	"github.com/mazrean/gocica/internal/kessoku"
-	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/config"
+	"github.com/mazrean/gocica/internal/pkg/crypt"
+	"github.com/mazrean/gocica/internal/pkg/lifecycle"
 	mylog "github.com/mazrean/gocica/internal/pkg/log"
+	"github.com/mazrean/gocica/internal/pkg/report"
+	"github.com/mazrean/gocica/internal/pkg/variant"
+	"github.com/mazrean/gocica/internal/policy"
+	"github.com/mazrean/gocica/internal/prune"
+	"github.com/mazrean/gocica/internal/remote/core"
 	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/internal/remote/storage"
+	"github.com/mazrean/gocica/internal/scratch"
 	"github.com/mazrean/gocica/log"
-	"github.com/mazrean/gocica/protocol"
 )
 
 //go:generate go tool buf generate
@@ -22,30 +32,405 @@ var (
 	revision = "none"
 )
 
-// CLI represents command line options and configuration file values
-var CLI struct {
-	Version  kong.VersionFlag `kong:"short='v',help='Show version and exit.'"`
-	Dir      string           `kong:"short='d',optional,help='Directory to store cache files',env='GOCICA_DIR'"`
-	LogLevel string           `kong:"short='l',default='info',enum='debug,info,warn,error,silent',help='Log level',env='GOCICA_LOG_LEVEL'"`
-	Github   struct {
-		CacheURL string `kong:"help='GitHub Actions Cache URL',env='GOCICA_GITHUB_CACHE_URL,ACTIONS_RESULTS_URL'"`
-		Token    string `kong:"help='GitHub token',env='GOCICA_GITHUB_TOKEN,ACTIONS_RUNTIME_TOKEN'"`
-		RunnerOS string `kong:"help='GitHub runner OS',env='GOCICA_GITHUB_RUNNER_OS,RUNNER_OS'"`
-		Ref      string `kong:"help='GitHub base ref of the workflow or the target branch of the pull request',env='GOCICA_GITHUB_REF,GITHUB_REF'"`
-		Sha      string `kong:"help='GitHub SHA of the commit',env='GOCICA_GITHUB_SHA,GITHUB_SHA'"`
-	} `kong:"optional,group='github',embed,prefix='github.'"`
-	Dev DevFlag `kong:"group='dev',embed,prefix='dev.'"`
+// GithubFlag is the GitHub Actions Cache configuration
+type GithubFlag struct {
+	CacheURL   string          `kong:"help='GitHub Actions Cache URL of the cache service v2. Detected from the runner (ACTIONS_CACHE_SERVICE_V2, ACTIONS_RESULTS_URL, ACTIONS_CACHE_URL) when not set.',env='GOCICA_GITHUB_CACHE_URL'"`
+	Token      string          `kong:"help='GitHub token',env='GOCICA_GITHUB_TOKEN,ACTIONS_RUNTIME_TOKEN',secret"`
+	RunnerOS   string          `kong:"help='GitHub runner OS',env='GOCICA_GITHUB_RUNNER_OS,RUNNER_OS'"`
+	Ref        string          `kong:"help='GitHub base ref of the workflow or the target branch of the pull request',env='GOCICA_GITHUB_REF,GITHUB_REF'"`
+	Sha        string          `kong:"help='GitHub SHA of the commit',env='GOCICA_GITHUB_SHA,GITHUB_SHA'"`
+	Event      string          `kong:"help='GitHub event that triggered the workflow',env='GOCICA_GITHUB_EVENT,GITHUB_EVENT_NAME'"`
+	RunID      string          `kong:"help='GitHub workflow run ID',env='GOCICA_GITHUB_RUN_ID,GITHUB_RUN_ID'"`
+	RunAttempt string          `kong:"help='GitHub workflow run attempt',env='GOCICA_GITHUB_RUN_ATTEMPT,GITHUB_RUN_ATTEMPT'"`
+	Retry      GithubRetryFlag `kong:"embed,prefix='retry-'"`
 }
 
-// loadConfig loads and parses configuration from command line arguments
-func loadConfig() (*kong.Context, error) {
-	// Parse command line arguments
-	parser := kong.Must(&CLI,
+// GithubRetryFlag is the retry policy of the calls to the GitHub Actions Cache API
+type GithubRetryFlag struct {
+	Attempts    int           `kong:"default='4',help='Attempts of a call to the GitHub Actions Cache API failing transiently or rate limited. 1 disables the retries.',env='GOCICA_GITHUB_RETRY_ATTEMPTS'"`
+	BaseBackoff time.Duration `kong:"default='1s',help='Wait before the first retry of a call, doubled for each next one and jittered.',env='GOCICA_GITHUB_RETRY_BASE_BACKOFF'"`
+	MaxBackoff  time.Duration `kong:"default='30s',help='Upper bound of the wait between the retries of a call.',env='GOCICA_GITHUB_RETRY_MAX_BACKOFF'"`
+	MaxWait     time.Duration `kong:"default='1m',help='Longest wait asked by the service with Retry-After or a rate limit reset that is waited for. A call asked to wait longer fails at once.',env='GOCICA_GITHUB_RETRY_MAX_WAIT'"`
+}
+
+func (g *GithubRetryFlag) policy() provider.GHARetryPolicy {
+	return provider.GHARetryPolicy{
+		Attempts:    g.Attempts,
+		BaseBackoff: g.BaseBackoff,
+		MaxBackoff:  g.MaxBackoff,
+		MaxWait:     g.MaxWait,
+	}
+}
+
+// cacheService returns the cache service and its URL, the one given or else the one the runner sets.
+func (g *GithubFlag) cacheService() (provider.CacheServiceVersion, string) {
+	if g.CacheURL != "" {
+		return provider.CacheServiceV2, g.CacheURL
+	}
+
+	return provider.DetectCacheService(os.Getenv)
+}
+
+func (g *GithubFlag) config() *provider.GHACacheConfig {
+	service, cacheURL := g.cacheService()
+
+	return &provider.GHACacheConfig{
+		Token:    g.Token,
+		CacheURL: cacheURL,
+		Service:  service,
+		RunnerOS: cacheOS(g.RunnerOS),
+		Ref:      g.Ref,
+		Sha:      g.Sha,
+		// Only runs triggered by pull requests are isolated. Other runs just stay away from the pull request namespace.
+		PRIsolation: CLI.PRIsolation && provider.IsPullRequestEvent(g.Event),
+		RunID:       g.RunID,
+		RunAttempt:  g.RunAttempt,
+		JobIndex:    CLI.Coordination.JobIndex,
+		JobTotal:    CLI.Coordination.JobTotal,
+		Retry:       g.Retry.policy(),
+		Variant:     variant.Key(variantInputs()),
+		Scope:       CLI.Scope,
+	}
+}
+
+// variantInputs returns what selects the build variant of the go command, or nil when --variant-namespace is disabled.
+// The cache shared across OSes names the target of a native build, like the one of a cross build for the same platform.
+func variantInputs() []string {
+	if !CLI.VariantNS {
+		return nil
+	}
+
+	getenv := os.Getenv
+	if crossOS() {
+		getenv = variant.WithTarget(getenv)
+	}
+
+	return variant.Inputs(getenv, report.BuildFlags(os.Getenv("GOFLAGS")))
+}
+
+// crossOS reports whether the remote cache is shared with the runners of other OSes: with --cross-os, for builds without cgo.
+func crossOS() bool {
+	return CLI.CrossOS && !variant.Cgo(os.Getenv)
+}
+
+// cacheOS returns the OS segment of the cache keys: the runner OS, or the target of the go command when the cache is shared across OSes.
+func cacheOS(runnerOS string) string {
+	if !crossOS() {
+		return runnerOS
+	}
+
+	return variant.Target(os.Getenv)
+}
+
+// GithubRESTFlag is the GitHub REST API configuration used by the commands managing cache entries
+type GithubRESTFlag struct {
+	APIURL     string `kong:"name='api-url',default='https://api.github.com',help='GitHub REST API URL',env='GITHUB_API_URL'"`
+	Repository string `kong:"help='GitHub repository (owner/name)',env='GITHUB_REPOSITORY'"`
+	Token      string `kong:"help='GitHub token with the actions:write permission',env='GOCICA_PRUNE_TOKEN,GITHUB_TOKEN',secret"`
+}
+
+func (g *GithubRESTFlag) remote() (*prune.GitHub, error) {
+	remote, err := prune.NewGitHub(g.APIURL, g.Repository, g.Token)
+	if err != nil {
+		return nil, fmt.Errorf("create github client: %w", err)
+	}
+
+	return remote, nil
+}
+
+// MetricsFlag is the export configuration of the end-of-run metrics
+type MetricsFlag struct {
+	OpenMetrics string `kong:"optional,help='File to write the end-of-run metrics to in the OpenMetrics format',type='path',env='GOCICA_METRICS_OPENMETRICS'"`
+	Pushgateway string `kong:"optional,help='Prometheus Pushgateway URL to push the end-of-run metrics to',env='GOCICA_METRICS_PUSHGATEWAY'"`
+	Job         string `kong:"default='gocica',help='Job name used when pushing to the Pushgateway',env='GOCICA_METRICS_JOB'"`
+}
+
+// AuditFlag is the configuration of the audit manifest of remote transfers
+type AuditFlag struct {
+	Manifest string `kong:"optional,help='File to write the manifest of outputs uploaded to and downloaded from the remote to',type='path',env='GOCICA_AUDIT_MANIFEST'"`
+	Key      string `kong:"optional,help='PEM encoded Ed25519 private key used to sign the manifest as a DSSE envelope',type='path',env='GOCICA_AUDIT_KEY'"`
+}
+
+// CoordinationFlag is the configuration of the jobs of a matrix sharing the remote cache entry
+type CoordinationFlag struct {
+	JobIndex int `kong:"help='Index of this job in the matrix (strategy.job-index).',env='GOCICA_COORDINATION_JOB_INDEX'"`
+	JobTotal int `kong:"help='Number of jobs in the matrix (strategy.job-total). With more than 1, each job uploads a shard and the last job to finish merges them into one cache entry.',env='GOCICA_COORDINATION_JOB_TOTAL'"`
+}
+
+// PolicyFlag is the configuration of the outputs kept in the remote cache
+type PolicyFlag struct {
+	MaxSize int64    `kong:"default='0',help='Size in MiB above which an output is only kept in the local cache. 0 is unlimited.',env='GOCICA_POLICY_MAX_SIZE'"`
+	Skip    []string `kong:"optional,help='Kinds of outputs only kept in the local cache: compile (package archives), link (executables such as test binaries), test (test results and logs) or other.',env='GOCICA_POLICY_SKIP'"`
+	Only    []string `kong:"optional,help='Kinds of outputs kept in the remote cache, e.g. compile. Empty keeps every kind not skipped.',env='GOCICA_POLICY_ONLY'"`
+	Pin     []string `kong:"optional,help='Action IDs or output IDs, in base64 or hex, kept in the remote cache even when unused for long or left out by the other rules.',env='GOCICA_POLICY_PIN'"`
+	PinFile string   `kong:"optional,help='File listing IDs to pin like --policy.pin, one a line.',type='path',env='GOCICA_POLICY_PIN_FILE'"`
+}
+
+// policy returns the policy of the outputs kept in the remote cache.
+func (p *PolicyFlag) policy() (*policy.Policy, error) {
+	pol, err := policy.New(p.MaxSize<<20, p.Skip, p.Only)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	return pol, nil
+}
+
+// pins returns the actions and the outputs pinned by --policy.pin and --policy.pin-file.
+func (p *PolicyFlag) pins() (*policy.Pins, error) {
+	ids := p.Pin
+	if p.PinFile != "" {
+		fileIDs, err := policy.ReadPinFile(p.PinFile)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(slices.Clone(ids), fileIDs...)
+	}
+
+	pins, err := policy.NewPins(ids)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin: %w", err)
+	}
+
+	return pins, nil
+}
+
+// ExpiryFlag is the configuration of the expiration of the entries, given as TTLs by the toolchain on put
+type ExpiryFlag struct {
+	Default   time.Duration `kong:"default='0',help='TTL of the outputs put without one. 0 keeps them until unused for a week.',env='GOCICA_EXPIRY_DEFAULT'"`
+	Max       time.Duration `kong:"default='0',help='Upper bound of the TTLs given by the toolchain. 0 is unbounded.',env='GOCICA_EXPIRY_MAX'"`
+	Lifecycle bool          `kong:"help='Pass the time every entry of the blob has expired to backends with object lifecycle, e.g. exec plugins, when every entry has a TTL.',env='GOCICA_EXPIRY_LIFECYCLE'"`
+}
+
+// expiry returns the expiry rules of the entries.
+func (e *ExpiryFlag) expiry() (*policy.Expiry, error) {
+	if e.Default < 0 || e.Max < 0 {
+		return nil, errors.New("invalid expiry: negative TTL")
+	}
+
+	return &policy.Expiry{
+		Default:   e.Default,
+		Max:       e.Max,
+		Lifecycle: e.Lifecycle,
+	}, nil
+}
+
+// DownloadFlag is the configuration of the outputs restored from the remote cache
+type DownloadFlag struct {
+	MaxObjectSize int64         `kong:"default='0',help='Size in MiB above which an output of the remote cache is not restored with the whole blob, only fetched when looked up on storages with ranged reads. 0 is unlimited.',env='GOCICA_DOWNLOAD_MAX_OBJECT_SIZE'"`
+	Include       []string      `kong:"optional,help='Globs of the outputs restored, matched against the kinds of outputs (compile, link, test or other) and the action and output IDs in hex. Empty restores every output not excluded.',env='GOCICA_DOWNLOAD_INCLUDE'"`
+	Exclude       []string      `kong:"optional,help='Globs of the outputs not restored, matched like --download.include, e.g. link to leave test binaries in the remote cache.',env='GOCICA_DOWNLOAD_EXCLUDE'"`
+	PendingWait   time.Duration `kong:"default='0',help='Time a get of an output of the remote cache the restoration in the background has not reached yet waits for it, before it is answered as a miss. 0 answers at once. These misses are reported as pending misses either way.',env='GOCICA_DOWNLOAD_PENDING_WAIT'"`
+}
+
+// filter returns the filter of the outputs restored from the remote cache.
+func (d *DownloadFlag) filter() (*policy.DownloadFilter, error) {
+	filter, err := policy.NewDownloadFilter(d.MaxObjectSize<<20, d.Include, d.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download filter: %w", err)
+	}
+
+	return filter, nil
+}
+
+// TransferFlag is the configuration of the transfers of blocks to and from the remote cache
+type TransferFlag struct {
+	BlockSize                int64         `kong:"default='0',help='Size in MiB of the blocks copied from the previous blob and of the batches of small outputs. 0 picks 4MiB, or larger blocks for multi-GB blobs to stay within the 50000 blocks of Azure Blob Storage.',env='GOCICA_TRANSFER_BLOCK_SIZE'"`
+	Concurrency              int           `kong:"default='0',help='Parallel transfers of blocks. 0 tunes them by the CPUs and the bandwidth.',env='GOCICA_TRANSFER_CONCURRENCY'"`
+	AzureDownloadConcurrency uint16        `kong:"default='0',help='Parallel range requests of a chunk downloaded from Azure Blob Storage. 0 is the default of the Azure SDK.',env='GOCICA_TRANSFER_AZURE_DOWNLOAD_CONCURRENCY'"`
+	AzureDownloadBlockSize   int64         `kong:"default='0',help='Size in MiB of the range requests of a chunk downloaded from Azure Blob Storage. 0 is the default of the Azure SDK.',env='GOCICA_TRANSFER_AZURE_DOWNLOAD_BLOCK_SIZE'"`
+	AzureMaxRetries          int32         `kong:"default='0',help='Retries of a failed request to Azure Blob Storage. 0 is the default of the Azure SDK, and -1 disables them.',env='GOCICA_TRANSFER_AZURE_MAX_RETRIES'"`
+	AzureTryTimeout          time.Duration `kong:"default='0',help='Time allowed for a single try of a request to Azure Blob Storage. 0 is the default of the Azure SDK.',env='GOCICA_TRANSFER_AZURE_TRY_TIMEOUT'"`
+	SparseAfter              time.Duration `kong:"default='0',help='Time after which the restoration of the whole remote cache stops when few of its outputs were used, downloading the outputs looked up afterwards in coalesced ranges. 0 always restores the whole cache.',env='GOCICA_TRANSFER_SPARSE_AFTER'"`
+}
+
+// apply sets the transfer options of the run.
+// The options are validated by configure.
+func (t *TransferFlag) apply() {
+	core.SetBlockSize(t.BlockSize << 20)
+	core.SetSparseAfter(t.SparseAfter)
+	if t.Concurrency > 0 {
+		core.SetTransfers(t.Concurrency)
+	}
+	if t.AzureDownloadConcurrency > 0 || t.AzureDownloadBlockSize > 0 || t.AzureMaxRetries != 0 || t.AzureTryTimeout > 0 {
+		storage.SetAzureOptions(&storage.AzureOptions{
+			DownloadConcurrency: t.AzureDownloadConcurrency,
+			DownloadBlockSize:   t.AzureDownloadBlockSize << 20,
+			MaxRetries:          t.AzureMaxRetries,
+			TryTimeout:          t.AzureTryTimeout,
+		})
+	}
+}
+
+// CompressFlag is the configuration of the compression of the outputs uploaded to the remote cache
+type CompressFlag struct {
+	Codec       string `kong:"default='auto',enum='auto,zstd,none',help='Compression of the outputs uploaded: auto, zstd or none. auto compresses a sample at start and picks none on a CPU too slow to compress faster than a runner uploads, zstd level 3 on a fast one and zstd level 1 otherwise. The compression of every output is recorded in the remote cache, so runners picking different ones share it.',env='GOCICA_COMPRESS_CODEC'"`
+	Level       int    `kong:"default='0',help='zstd level of the outputs, from 1 to 22. 0 is the level picked by --compress.codec=auto, or 1.',env='GOCICA_COMPRESS_LEVEL'"`
+	Exec        string `kong:"optional,help='Command compressing the large outputs instead of the built-in zstd, e.g. zstd -T0 -3 or a compressor offloading to hardware. It is run without a shell, reads an output on stdin and writes a zstd frame of it to stdout. It is checked on a sample at start, and the built-in zstd is used when it or a later run fails.',env='GOCICA_COMPRESS_EXEC'"`
+	ExecMinSize int64  `kong:"name='exec-min-size',default='1',help='Size in MiB from which an output is compressed by --compress.exec. The smaller ones are compressed by the built-in zstd faster than a process is started.',env='GOCICA_COMPRESS_EXEC_MIN_SIZE'"`
+}
+
+// SignedFlag is the configuration of the remote cache on pre-signed URLs
+type SignedFlag struct {
+	DownloadURL string `kong:"optional,help='Pre-signed URL to read the remote cache from, e.g. of S3, GCS or an Azure SAS, brokered by a trusted service so that the job holds no credentials.',env='GOCICA_SIGNED_DOWNLOAD_URL',secret"`
+	UploadURL   string `kong:"optional,help='Pre-signed URL to write the remote cache to with a PUT, or an Azure SAS URL. Without it the run only reads.',env='GOCICA_SIGNED_UPLOAD_URL',secret"`
+}
+
+// AdmissionFlag is the configuration of the admission of large outputs to the remote cache
+type AdmissionFlag struct {
+	MinSize int64 `kong:"default='0',help='Size in MiB from which an output is only uploaded once its action has been seen in an earlier run, leaving out the outputs written every run but never read again. The history is kept in the remote cache. 0 uploads every output.',env='GOCICA_ADMISSION_MIN_SIZE'"`
+}
+
+// SigningFlag is the configuration of the signature of the remote cache header
+type SigningFlag struct {
+	Key       string `kong:"optional,help='PEM encoded Ed25519 private key to sign the remote cache with. Give it to trusted pipelines only.',type='path',env='GOCICA_SIGNING_KEY'"`
+	PublicKey string `kong:"optional,help='PEM encoded Ed25519 public key. Remote caches not signed with its private key are treated as misses.',type='path',env='GOCICA_SIGNING_PUBLIC_KEY'"`
+}
+
+// signer returns the signer of the remote cache, or nil when signing is disabled.
+func (s *SigningFlag) signer() (*crypt.Signer, error) {
+	if s.Key == "" && s.PublicKey == "" {
+		return nil, nil
+	}
+
+	signer, err := crypt.LoadSigner(s.Key, s.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("load signing key: %w", err)
+	}
+
+	return signer, nil
+}
+
+// cli represents command line options and configuration file values
+type cli struct {
+	Version        VersionFlag       `kong:"short='v',help='Show version and exit.'"`
+	JSON           bool              `kong:"name='json',help='Show the version as JSON with --version, or the diff of the diff command.'"`
+	Config         kong.ConfigFlag   `kong:"optional,help='Configuration file to load in addition to gocica.yaml at the repository root and in the user config directory.',type='path',env='GOCICA_CONFIG'"`
+	Dir            string            `kong:"short='d',optional,help='Directory to store cache files',env='GOCICA_DIR'"`
+	Scratch        bool              `kong:"negatable,default='true',help='On GitHub-hosted runners, place the cache directory on the fastest volume with enough space, e.g. /mnt, when --dir is not given.',env='GOCICA_SCRATCH'"`
+	LogLevel       string            `kong:"short='l',default='info',enum='debug,info,warn,error,silent',help='Log level',env='GOCICA_LOG_LEVEL'"`
+	LogFile        string            `kong:"optional,help='File to write logs to instead of stderr',type='path',env='GOCICA_LOG_FILE'"`
+	LogSample      int               `kong:"default='50',help='Most debug lines a second logged from each place in the code, e.g. the lock acquisitions of the local cache. The others are dropped, and counted in the next line logged from the place. 0 logs every line.',env='GOCICA_LOG_SAMPLE'"`
+	LogMaxSize     int64             `kong:"default='100',help='Size in MiB at which the log file is rotated. 0 disables the rotation.',env='GOCICA_LOG_MAX_SIZE'"`
+	Strict         bool              `kong:"help='Exit with an error instead of running without the remote cache when the backend cannot be initialized.',env='GOCICA_STRICT'"`
+	MaxMemory      int64             `kong:"default='0',help='Memory in MiB for the buffers of put bodies and compression. Buffers beyond it are spilled to temporary files in the cache directory. 0 is unlimited.',env='GOCICA_MAX_MEMORY'"`
+	CommitTimeout  time.Duration     `kong:"default='5m',help='Time allowed for the uploads and the commit of the remote cache at the end of the build. Uploads still running are abandoned and the finished ones committed. 0 waits forever.',env='GOCICA_COMMIT_TIMEOUT'"`
+	CloseTimeout   time.Duration     `kong:"default='0',help='Hard bound of the whole close at the end of the build, e.g. 90s so that it never holds the job. --commit-timeout is lowered to it, so the finished uploads are committed in time, and the close returns at it even with the backends not closed yet. 0 leaves the close to --commit-timeout.',env='GOCICA_CLOSE_TIMEOUT'"`
+	InitWait       time.Duration     `kong:"default='30s',help='Time a get waits for the remote cache being set up in the background before it is answered as a miss. With --strict, the remote cache is set up before serving instead.',env='GOCICA_INIT_WAIT'"`
+	Laptop         bool              `kong:"help='Developer machine mode: serve gets from the local index kept across runs without waiting for the remote cache, sync with it in the background at a low priority, and pause the sync while sync.pause exists in the cache directory.',env='GOCICA_LAPTOP'"`
+	RestoreMtime   bool              `kong:"name='restore-mtime',help='Set the mtime of the outputs restored from the remote cache to the time they were put, instead of the time they were downloaded, for tools that compare the mtimes of the cache files.',env='GOCICA_RESTORE_MTIME'"`
+	ModCache       bool              `kong:"name='modcache',help='Keep the module download cache of GOMODCACHE in the remote blob: restore it when the remote cache is set up, and upload the modules added at the end of the build. It replaces caching GOMODCACHE separately, e.g. by setup-go.',env='GOCICA_MODCACHE'"`
+	Backend        string            `kong:"default='auto',help='Remote backend: auto, github, http, exec, signed, none, or one registered by a package linked in. auto uses exec or http when --remote is given, signed when signed URLs are given, github on GitHub Actions or when its settings are given, and none otherwise.',env='GOCICA_BACKEND'"`
+	LocalBackend   string            `kong:"default='disk',help='Local backend: disk, pack (the objects restored from the remote cache are kept in pack files until they are looked up), or one registered by a package linked in.',env='GOCICA_LOCAL_BACKEND'"`
+	BackendOptions map[string]string `kong:"name='backend-option',help='Option of a registered backend as key=value. Repeatable.',env='GOCICA_BACKEND_OPTIONS'"`
+	Remote         string            `kong:"optional,help='URL of the cache hub served by gocica serve --http, e.g. http://hub:8080, or exec:<command> of a plugin process speaking the exec protocol of the backend package.',env='GOCICA_REMOTE'"`
+	RemoteRead     string            `kong:"optional,name='remote-read',help='URL of a replica of the cache hub to read from instead of --remote, e.g. one in the region of the runner. Commits are written to --remote, which is also read when the replica has no cache or fails.',env='GOCICA_REMOTE_READ'"`
+	EncryptionKey  string            `kong:"optional,help='Base64 encoded 32 byte AES-256 key to encrypt the remote cache with, or file:<path> to read it from a file written by a KMS or secret manager.',env='GOCICA_ENCRYPTION_KEY',secret"`
+	FIPS           bool              `kong:"name='fips',help='Require the Go FIPS 140-3 mode, in which only approved algorithms (SHA-256, AES-GCM, Ed25519) are used. Build with -tags fips or run with GODEBUG=fips140=on.',env='GOCICA_FIPS'"`
+	PRIsolation    bool              `kong:"name='pr-isolation',help='Write the cache of runs triggered by pull requests to a separate namespace that other branches never restore from. Leave github.ref to the pull request ref (GITHUB_REF) to also isolate pull requests from each other.',env='GOCICA_PR_ISOLATION'"`
+	VariantNS      bool              `kong:"name='variant-namespace',negatable,default='true',help='Keep the remote cache of each build variant under its own key, selected by GOOS, GOARCH, CGO_ENABLED, GOEXPERIMENT and the flags changing every action ID (e.g. -race, -tags) of the go command and GOFLAGS, so that a wrapper mixing them up cannot serve the outputs of one variant to another. Keys are only used by the github backend.',env='GOCICA_VARIANT_NAMESPACE'"`
+	Scope          string            `kong:"optional,help='Name of the cache of this process, e.g. build, test or lint for the steps of a job invoking the go command with different GOCACHEPROG arguments. Each scope keeps its remote cache under its own key and restores only from it, while the scopes share the local store of the cache directory. Letters, digits and underscores only. Keys are only used by the github backend.',env='GOCICA_SCOPE'"`
+	CrossOS        bool              `kong:"name='cross-os',help='Share the remote cache with the runners of other OSes building for the same GOOS and GOARCH, e.g. linux runners and macOS runners cross-compiling for linux, by keying it on the target instead of the runner OS. Builds that may use cgo, whose outputs depend on the C toolchain of the runner, keep the key of the runner OS. The action IDs of the go command only match across OSes with -trimpath. Keys are only used by the github backend.',env='GOCICA_CROSS_OS'"`
+	Report         string            `kong:"optional,help='File to write the end-of-run summary report to as JSON',type='path',env='GOCICA_REPORT'"`
+	Record         string            `kong:"optional,help='Directory to record the protocol sessions with the go command to, put bodies included, for gocica replay.',type='path',env='GOCICA_RECORD'"`
+	Attribution    string            `kong:"optional,help='GODEBUG=gocachehash=1 output of the go command, used to attribute cache misses to packages in the summary',type='path',env='GOCICA_ATTRIBUTION'"`
+	Github         GithubFlag        `kong:"optional,group='github',embed,prefix='github.'"`
+	Metrics        MetricsFlag       `kong:"group='metrics',embed,prefix='metrics.'"`
+	Audit          AuditFlag         `kong:"group='audit',embed,prefix='audit.'"`
+	Signing        SigningFlag       `kong:"group='signing',embed,prefix='signing.'"`
+	Coordination   CoordinationFlag  `kong:"group='coordination',embed,prefix='coordination.'"`
+	Policy         PolicyFlag        `kong:"group='policy',embed,prefix='policy.'"`
+	Expiry         ExpiryFlag        `kong:"group='expiry',embed,prefix='expiry.'"`
+	Download       DownloadFlag      `kong:"group='download',embed,prefix='download.'"`
+	Admission      AdmissionFlag     `kong:"group='admission',embed,prefix='admission.'"`
+	Signed         SignedFlag        `kong:"group='signed',embed,prefix='signed.'"`
+	Transfer       TransferFlag      `kong:"group='transfer',embed,prefix='transfer.'"`
+	Compress       CompressFlag      `kong:"group='compress',embed,prefix='compress.'"`
+	Dev            DevFlag           `kong:"group='dev',embed,prefix='dev.'"`
+
+	Run     RunCmd     `kong:"cmd,default='1',help='Run as GOCACHEPROG. This is the default command.'"`
+	Doctor  DoctorCmd  `kong:"cmd,help='Diagnose the configuration, the remote cache and the local disk.'"`
+	Prune   PruneCmd   `kong:"cmd,help='Delete stale gocica entries in the remote cache.'"`
+	Warm    WarmCmd    `kong:"cmd,help='Run a build with gocica and commit the result to a dedicated key to keep the cache hot.'"`
+	Verify  VerifyCmd  `kong:"cmd,help='Download the current cache entry and check the checksums of its outputs.'"`
+	Diff    DiffCmd    `kong:"cmd,help='Compare the headers of two cache entries by their keys: the entries added, removed and changed, and the size deltas.'"`
+	Migrate MigrateCmd `kong:"cmd,help='Copy a cache entry to another key or backend, by the storage where it can, so that a change of the key scheme starts warm.'"`
+	Export  ExportCmd  `kong:"cmd,help='Write the current cache entry to a local archive.'"`
+	Import  ImportCmd  `kong:"cmd,help='Upload an archive written by export as the cache entry of the current key.'"`
+	Restore RestoreCmd `kong:"cmd,help='Restore the remote cache to the local disk in a step before the build. The go commands are then served from it without the remote cache until save.'"`
+	Save    SaveCmd    `kong:"cmd,help='Commit the outputs the go commands used and built since restore to the remote cache in a step after the build.'"`
+	Bench   BenchCmd   `kong:"cmd,help='Measure the throughput and latency of gocica with a synthetic workload against the configured backend.'"`
+	Daemon  DaemonCmd  `kong:"cmd,help='Serve the go commands of many invocations with one index and remote session. Set GOCACHEPROG to gocica client to connect to it.'"`
+	Client  ClientCmd  `kong:"cmd,help='Run as GOCACHEPROG connected to the daemon, or in-process like run when the daemon is not running.'"`
+	Replay  ReplayCmd  `kong:"cmd,help='Replay a session recorded with --record against the configured backend and compare the responses.'"`
+	Serve   ServeCmd   `kong:"cmd,help='Serve the cache hub over HTTP, which the jobs of a workflow share with --remote.'"`
+}
+
+// CLI is the configuration of the process.
+var CLI cli
+
+// hubConfig returns the configuration of the cache hub given by --remote and --remote-read.
+func hubConfig() *provider.HubConfig {
+	return &provider.HubConfig{URL: CLI.Remote, ReadURL: CLI.RemoteRead}
+}
+
+// loadCipher returns the cipher of the remote cache, or nil when encryption is disabled.
+func loadCipher() (*crypt.Cipher, error) {
+	if CLI.EncryptionKey == "" {
+		return nil, nil
+	}
+
+	key, err := crypt.LoadKey(CLI.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("load encryption key: %w", err)
+	}
+
+	return crypt.New(key)
+}
+
+// scratchProbes and scratchErr are the result of placing the cache directory, logged once the logger is set up.
+var (
+	scratchProbes []*scratch.Probe
+	scratchErr    error
+)
+
+// logScratch logs where the cache directory was placed on a GitHub-hosted runner.
+func logScratch(logger log.Logger) {
+	if scratchErr != nil {
+		logger.Warnf("failed to remember the cache directory: %v", scratchErr)
+	}
+	for _, p := range scratchProbes {
+		if p.Err != nil {
+			logger.Debugf("scratch %s: %v", p.Dir, p.Err)
+			continue
+		}
+		logger.Debugf("scratch %s: %.1f MiB/s, %d MiB free", p.Dir, p.Throughput/(1<<20), p.Free>>20)
+	}
+	if scratchProbes != nil {
+		logger.Infof("placed the cache directory on %s", CLI.Dir)
+	}
+}
+
+// parserOptions are the options of the parser of the configuration, also used to parse it again on a reload.
+func parserOptions() []kong.Option {
+	return []kong.Option{
 		kong.Name("gocica"),
 		kong.Description("A fast GOCACHEPROG implementation for CI"),
 		kong.Vars{"version": fmt.Sprintf("%s (%s)", version, revision)},
-		kong.UsageOnError(),
-	)
+		// Precedence: flags > environment variables > configuration files
+		kong.Configuration(config.Loader, config.Paths()...),
+	}
+}
+
+// loadConfig loads and parses configuration from command line arguments
+func loadConfig() (*kong.Context, error) {
+	// Parse command line arguments
+	parser := kong.Must(&CLI, append(parserOptions(), kong.UsageOnError())...)
+	// Secrets mounted as files, e.g. by Kubernetes, are given by the environment variables with _FILE.
+	if err := config.LoadSecretFiles(parser.Model); err != nil {
+		return nil, fmt.Errorf("failed to load secret files: %w", err)
+	}
+
 	ctx, err := parser.Parse(os.Args[1:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse arguments: %w", err)
@@ -57,11 +442,9 @@ func loadConfig() (*kong.Context, error) {
 		if err == nil {
 			CLI.Dir = filepath.Join(cacheDir, "gocica")
 		}
-	}
-
-	// Validate directory
-	if CLI.Dir == "" {
-		return nil, fmt.Errorf("cache directory is not specified. please specify using the -dir flag or config file")
+		if CLI.Scratch && scratch.Enabled() && CLI.Dir != "" {
+			CLI.Dir, scratchProbes, scratchErr = scratch.Place(CLI.Dir)
+		}
 	}
 
 	return ctx, nil
@@ -69,64 +452,154 @@ func loadConfig() (*kong.Context, error) {
 
 func main() {
 	// Load configuration
-	_, err := loadConfig()
+	ctx, err := loadConfig()
 	if err != nil {
 		panic(fmt.Errorf("invalid configuration: %w", err))
 	}
 
-	// Initialize default logger with info level
-	logger := log.DefaultLogger
-
-	// Start profiling. Enable profiling only in development mode.
-	if err := CLI.Dev.StartProfiling(); err != nil {
-		logger.Warnf("failed to start profiling: %v", err)
-	}
-	defer CLI.Dev.StopProfiling()
+	os.Exit(run(ctx))
+}
 
-	// Set log level
-	switch CLI.LogLevel {
+// parseLogLevel returns the level of the name given by --log-level, or the info level and false when it is unknown.
+func parseLogLevel(name string) (mylog.Level, bool) {
+	switch name {
 	case "silent":
-		logger = mylog.NewLogger(mylog.Silent)
+		return mylog.Silent, true
 	case "error":
-		logger = mylog.NewLogger(mylog.Error)
+		return mylog.Error, true
 	case "warn":
-		logger = mylog.NewLogger(mylog.Warn)
+		return mylog.Warn, true
 	case "info":
-		// default info level
+		return mylog.Info, true
 	case "debug":
-		logger = mylog.NewLogger(mylog.Debug)
+		return mylog.Debug, true
 	default:
+		return mylog.Info, false
+	}
+}
+
+// run executes the selected command and returns the exit code.
+// It is separated from main so that deferred cleanups run before os.Exit.
+func run(ctx *kong.Context) int {
+	// Initialize default logger with info level
+	logger := log.DefaultLogger
+
+	// Start profiling. Enable profiling only in development mode.
+	// The long-running modes serve the profiles on the admin endpoints instead of writing them to files at the exit.
+	if admin := selectedAdmin(ctx); admin == nil || admin.Addr == "" {
+		if err := CLI.Dev.StartProfiling(); err != nil {
+			logger.Warnf("failed to start profiling: %v", err)
+		}
+		defer CLI.Dev.StopProfiling()
+	}
+
+	// Set log level
+	level, ok := parseLogLevel(CLI.LogLevel)
+	if !ok {
 		logger.Warnf("invalid log level: %s. ignore and use default info level instead", CLI.LogLevel)
 	}
 
-	logger.Debugf("configuration: %+v", CLI)
+	// Set log destination. stderr is shared with the go command, so large logs are better written to a file.
+	if CLI.LogFile != "" {
+		logFile, err := mylog.OpenRotatingFile(CLI.LogFile, CLI.LogMaxSize<<20)
+		if err != nil {
+			logger.Warnf("failed to open log file: %v. logging to stderr instead", err)
+			logger = mylog.NewLogger(level)
+		} else {
+			defer logFile.Close()
+			logger = mylog.NewLoggerWithWriter(level, logFile)
+		}
+	} else if level != mylog.Info {
+		logger = mylog.NewLogger(level)
+	}
+
+	if l, ok := logger.(interface{ SetSample(int) }); ok {
+		l.SetSample(CLI.LogSample)
+	}
+
+	// The configuration is logged as the admin endpoint serves it, with the values of the secret flags redacted.
+	logger.Debugf("configuration: %v", config.NewEffective(ctx).Snapshot().Values)
+	logScratch(logger)
+
+	// Inject faults into the remote cache. Only the dev build has the flags of them.
+	if err := CLI.Dev.InjectFaults(); err != nil {
+		logger.Errorf("invalid fault injection: %v", err)
+		return 1
+	}
+
+	if err := configure(ctx); err != nil {
+		logger.Errorf("%v", err)
+		return 1
+	}
+
+	ctx.BindTo(logger, (*log.Logger)(nil))
+	defer shutdown(logger)
+	if err := ctx.Run(); err != nil {
+		logger.Errorf("%s: %v", ctx.Command(), err)
+
+		var exitCoder kong.ExitCoder
+		if errors.As(err, &exitCoder) {
+			return exitCoder.ExitCode()
+		}
+		return 1
+	}
 
-	// Initialize process via DI (FR-002: Context parameter, FR-007: Degraded mode handling)
-	// Use a cancellable context so we can clean up background goroutines on initialization failure.
-	// The second context parameter is for GitHubActionsCache initialization (kessoku DI limitation).
-	ctx, cancel := context.WithCancel(context.Background())
-	// Defer cancel to ensure cleanup even on panic (idempotent - safe to call multiple times)
+	return 0
+}
+
+// shutdownTimeout bounds the wait for the workers in the background at the exit.
+const shutdownTimeout = 5 * time.Second
+
+// shutdown stops the workers in the background that the command left running, e.g. after its initialization failed.
+func shutdown(logger log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	process, err := kessoku.InitializeProcess(
-		ctx,
-		logger,
-		local.DiskDir(CLI.Dir),
-		&provider.GHACacheConfig{
-			Token:    CLI.Github.Token,
-			CacheURL: CLI.Github.CacheURL,
-			RunnerOS: CLI.Github.RunnerOS,
-			Ref:      CLI.Github.Ref,
-			Sha:      CLI.Github.Sha,
-		},
-	)
-	if err != nil {
-		// Degraded mode: log warning and continue with no-cache Process
-		logger.Warnf("failed to initialize process: %v. no cache will be used.", err)
-		process = protocol.NewProcess(protocol.WithLogger(logger))
+	if err := lifecycle.Shutdown(ctx, nil); err != nil {
+		logger.Warnf("failed to stop the workers in the background: %v", err)
 	}
+}
 
-	if err := process.Run(); err != nil {
-		panic(fmt.Errorf("unexpected error: failed to run process: %w", err))
+// Exit codes when the remote backend cannot be used in strict mode, by the class of the failure.
+// They follow the semantic exit codes used by kong (https://github.com/square/exit) where one fits.
+const (
+	exitCodeBackendConfig      = 80
+	exitCodeBackendForbidden   = 83
+	exitCodeBackendInternal    = 100
+	exitCodeBackendUnavailable = 101
+	exitCodeBackendQuota       = 102
+)
+
+// initFailureExitCode returns the exit code of a backend failure of the class in strict mode.
+func initFailureExitCode(class provider.FailureClass) int {
+	switch class {
+	case provider.FailureConfig:
+		return exitCodeBackendConfig
+	case provider.FailureAuth:
+		return exitCodeBackendForbidden
+	case provider.FailureQuota:
+		return exitCodeBackendQuota
+	case provider.FailureNetwork:
+		return exitCodeBackendUnavailable
+	default:
+		return exitCodeBackendInternal
 	}
 }
+
+// exitCodeError attaches an exit code to an error returned by a command.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+func (e *exitCodeError) ExitCode() int {
+	return e.code
+}
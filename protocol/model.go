@@ -3,7 +3,9 @@
 package protocol
 
 import (
-	"github.com/mazrean/gocica/internal/pkg/io"
+	"time"
+
+	"github.com/mazrean/gocica/stream"
 )
 
 // Cmd is a command that can be issued to a process.
@@ -39,10 +41,15 @@ type Request struct {
 	// BodySize is the number of bytes of Body. If zero, the body isn't written.
 	BodySize int64 `json:",omitempty"`
 
+	// TTL is how long the output of a "put" should be kept, in nanoseconds.
+	// It is an expiration hint sent by toolchains that know the output is short-lived.
+	// Zero is no hint.
+	TTL time.Duration `json:",omitempty"`
+
 	// Body is the request payload for operations like "put".
 	// It's sent separately from the JSON object so large values
 	// can be streamed efficiently.
-	Body io.ClonableReadSeeker `json:"-"`
+	Body stream.ClonableReadSeeker `json:"-"`
 }
 
 // Response is the JSON response from the process.
@@ -79,4 +86,13 @@ type Response struct {
 
 	// DiskPath is the absolute path on disk where the data is stored
 	DiskPath string `json:",omitempty"`
+
+	// ExpiresNanos is the time the output expires in Unix nanoseconds,
+	// when it was put with a TTL. Zero never expires.
+	ExpiresNanos int64 `json:",omitempty"`
+
+	// Degraded is the reason the request succeeded only in part, e.g. a put written to the local cache
+	// but not to the remote one. The go command only tells a success from a failure, so it is not sent,
+	// but logged and counted by Process.
+	Degraded string `json:"-"`
 }
@@ -9,11 +9,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime/debug"
+	"slices"
 	"sync"
 
-	myio "github.com/mazrean/gocica/internal/pkg/io"
 	"github.com/mazrean/gocica/internal/pkg/json"
+	"github.com/mazrean/gocica/internal/pkg/membuf"
+	"github.com/mazrean/gocica/internal/pkg/report"
 	"github.com/mazrean/gocica/log"
+	"github.com/mazrean/gocica/stream"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -136,6 +140,12 @@ func (p *Process) Run() error {
 	return p.run(os.Stdout, r)
 }
 
+// Serve runs the processing loop like Run, but on r and w instead of stdin and stdout,
+// so that the process can be driven in-process, e.g. by a benchmark.
+func (p *Process) Serve(w io.Writer, r io.Reader) error {
+	return p.run(w, r)
+}
+
 func (p *Process) run(w io.Writer, r io.Reader) (err error) {
 	// Create root context and error groups for concurrent operations
 	ctx := context.Background()
@@ -183,11 +193,15 @@ func (p *Process) run(w io.Writer, r io.Reader) (err error) {
 	err = p.decodeWorker(ctx, r, func(ctx context.Context, req *Request) error {
 		// Create response with matching ID
 		res := Response{}
-		err := p.handle(ctx, req, &res)
+		err := p.handleRecover(ctx, req, &res)
 		if err != nil {
 			p.logger.Warnf("handle request(%+v): %v", req, err)
 			res.Err = err.Error()
 		}
+		if res.Degraded != "" {
+			p.logger.Debugf("remote degraded: command=%s action=%s reason=%s", req.Command, req.ActionID, res.Degraded)
+			report.AddDegraded(string(req.Command), res.Degraded, req.BodySize)
+		}
 		res.ID = req.ID
 
 		// Send response or handle context cancellation
@@ -265,7 +279,7 @@ func (p *Process) decodeWorker(ctx context.Context, r io.Reader, handler func(co
 		}
 	}()
 
-	dr := myio.NewDelimReader(bufio.NewReader(r), '\n')
+	dr := stream.NewDelimReader(bufio.NewReader(r), '\n')
 	decoder := json.NewDecoder(dr)
 
 	for {
@@ -307,18 +321,11 @@ func (p *Process) decodeWorker(ctx context.Context, r io.Reader, handler func(co
 				return fmt.Errorf("next request body: %w", err)
 			}
 
-			buf := bytes.NewBuffer(make([]byte, 0, req.BodySize))
-			_, err = io.Copy(buf, base64.NewDecoder(base64.StdEncoding, myio.NewSkipCharReader(dr, '"')))
-			if err != nil && !errors.Is(err, io.EOF) {
+			// The body is released by the handler when it is done with it, or when it is garbage collected.
+			req.Body, err = readBody(dr, req.BodySize)
+			if err != nil {
 				return fmt.Errorf("read request body: %w", err)
 			}
-
-			if buf.Len() != int(req.BodySize) {
-				return fmt.Errorf("read request body: expected %d bytes, got %d", req.BodySize, buf.Len())
-			}
-
-			// Wrap the request body reader with a limited reader to prevent reading more than expected
-			req.Body = myio.NewClonableReadSeeker(buf.Bytes())
 		}
 
 		eg.Go(func() error {
@@ -327,6 +334,102 @@ func (p *Process) decodeWorker(ctx context.Context, r io.Reader, handler func(co
 	}
 }
 
+// encodedPool holds the buffers of base64 encoded bodies.
+var encodedPool = sync.Pool{
+	New: func() any {
+		return &[]byte{}
+	},
+}
+
+// maxPooledEncodedSize is the largest encoded body buffer put back to encodedPool.
+const maxPooledEncodedSize = 16 << 20
+
+// readBody reads the base64 encoded JSON string of a put body from r, which ends at the end of the line.
+// A body kept in memory is decoded in one go from a pooled copy of the line straight into its buffer,
+// as the streaming decoder copies every byte through a few intermediate buffers on the hot path of put.
+// A spilled body is streamed to its file, so that it is never held in memory as a whole.
+func readBody(r io.Reader, size int64) (stream.ClonableReadSeeker, error) {
+	buf, err := membuf.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("allocate: %w", err)
+	}
+
+	if buf.InMemory() {
+		err = decodeBody(buf, r, size)
+	} else {
+		_, err = io.Copy(buf, base64.NewDecoder(base64.StdEncoding, stream.NewSkipCharReader(r, '"')))
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		buf.Release()
+		return nil, err
+	}
+
+	if buf.Len() != size {
+		buf.Release()
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, buf.Len())
+	}
+
+	return buf.Reader(), nil
+}
+
+func decodeBody(buf *membuf.Buffer, r io.Reader, size int64) error {
+	//nolint:forcetypeassert
+	encoded := encodedPool.Get().(*[]byte)
+	defer func() {
+		if cap(*encoded) <= maxPooledEncodedSize {
+			encodedPool.Put(encoded)
+		}
+	}()
+
+	// The line is the encoded body in quotes, and the extra byte saves a grow for the EOF read.
+	line := slices.Grow((*encoded)[:0], base64.StdEncoding.EncodedLen(int(size))+3)
+	for {
+		if len(line) == cap(line) {
+			line = slices.Grow(line, 512)
+		}
+
+		n, err := r.Read(line[len(line):cap(line)])
+		line = line[:len(line)+n]
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			*encoded = line
+			return fmt.Errorf("read: %w", err)
+		}
+	}
+	*encoded = line
+
+	line = bytes.TrimSpace(line)
+	if len(line) < 2 || line[0] != '"' || line[len(line)-1] != '"' {
+		return errors.New("body is not a JSON string")
+	}
+	line = line[1 : len(line)-1]
+
+	if len(line)%4 != 0 || base64.StdEncoding.DecodedLen(len(line)) < int(size) {
+		return fmt.Errorf("expected %d bytes, got %d encoded bytes", size, len(line))
+	}
+
+	// The last quantum may be padded, so only the quanta before it are decoded in place.
+	// It keeps the decoded bytes within the size the buffer is allocated for.
+	head, tail := line[:max(len(line)-4, 0)], line[max(len(line)-4, 0):]
+	if _, err := base64.StdEncoding.Decode(buf.Extend(base64.StdEncoding.DecodedLen(len(head))), head); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	var last [3]byte
+	n, err := base64.StdEncoding.Decode(last[:], tail)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	_, _ = buf.Write(last[:n])
+
+	return nil
+}
+
 // handle processes individual requests based on their command type
 // It routes requests to the appropriate handler (get, push, or close)
 func (p *Process) handle(ctx context.Context, req *Request, res *Response) error {
@@ -348,6 +451,20 @@ func (p *Process) handle(ctx context.Context, req *Request, res *Response) error
 	}
 }
 
+// handleRecover calls handle, converting a panic of the handler into an error of the request,
+// so that a bug in a single get or put does not kill the process and the go command with it.
+func (p *Process) handleRecover(ctx context.Context, req *Request, res *Response) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			report.Panics.Add(1)
+			p.logger.Errorf("panic in handling request(%s %s): %v\n%s", req.Command, req.ActionID, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return p.handle(ctx, req, res)
+}
+
 // close handles the cleanup when the Process is being shut down
 // It calls the closeHandler if one is set
 func (p *Process) close(ctx context.Context) error {
@@ -7,12 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
-	myio "github.com/mazrean/gocica/internal/pkg/io"
+	"github.com/mazrean/gocica/stream"
 )
 
 func TestProcess_knownCommands(t *testing.T) {
@@ -197,6 +198,29 @@ func TestProcess_handle(t *testing.T) {
 	}
 }
 
+func TestProcess_handleRecover(t *testing.T) {
+	t.Parallel()
+
+	p := NewProcess(
+		WithGetHandler(func(context.Context, *Request, *Response) error {
+			panic("boom")
+		}),
+		WithPutHandler(func(context.Context, *Request, *Response) error {
+			return nil
+		}),
+	)
+
+	err := p.handleRecover(t.Context(), &Request{ID: 1, Command: CmdGet}, &Response{})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the panic as an error, got %v", err)
+	}
+
+	// The process keeps serving after the panic.
+	if err := p.handleRecover(t.Context(), &Request{ID: 2, Command: CmdPut}, &Response{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestProcess_close(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -307,7 +331,7 @@ func TestProcess_decodeWorker(t *testing.T) {
 			ActionID: "000a7673899170f3adcac947cabf348c041d32330bb3f6ac6f551128c0c7efa2",
 			OutputID: "04464d0c070ce0c1954c4d7846890a40597b70c10f9e7c542c30e6a2659abce4",
 		}
-		putBody     = myio.NewClonableReadSeeker([]byte("gocica"))
+		putBody     = stream.NewClonableReadSeeker([]byte("gocica"))
 		putReqValue = &Request{
 			ID:       2,
 			Command:  CmdPut,
@@ -497,3 +521,50 @@ func TestProcess_encodeWorker(t *testing.T) {
 		})
 	}
 }
+
+func TestReadBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		line    string
+		size    int64
+		want    string
+		wantErr bool
+	}{
+		{name: "no padding", line: `"YWJj"`, size: 3, want: "abc"},
+		{name: "one padding", line: `"YWJjZA=="`, size: 4, want: "abcd"},
+		{name: "two padding", line: `"YWJjZGU="`, size: 5, want: "abcde"},
+		{name: "several quanta", line: `"aGVsbG8sIHdvcmxkIQ=="`, size: 13, want: "hello, world!"},
+		{name: "trailing space", line: "\"YWJj\" \r", size: 3, want: "abc"},
+		{name: "short body", line: `"YWJj"`, size: 4, wantErr: true},
+		{name: "long body", line: `"YWJjZA=="`, size: 3, wantErr: true},
+		{name: "not a string", line: `YWJj`, size: 3, wantErr: true},
+		{name: "invalid base64", line: `"YW!j"`, size: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			body, err := readBody(strings.NewReader(tt.line), tt.size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/admission"
+	"github.com/mazrean/gocica/internal/config"
+	mylog "github.com/mazrean/gocica/internal/pkg/log"
+	"github.com/mazrean/gocica/internal/policy"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/log"
+)
+
+// reloadInterval is the interval at which the configuration files of a long-running mode are checked for changes.
+const reloadInterval = 5 * time.Second
+
+// reloadableFlags apply the values of the flags of the name to the running process.
+// The other flags take effect when the process is restarted.
+var reloadableFlags = map[string]func(logger log.Logger, next *cli) error{
+	"log-level": func(logger log.Logger, next *cli) error {
+		level, ok := parseLogLevel(next.LogLevel)
+		if !ok {
+			return fmt.Errorf("invalid log level: %s", next.LogLevel)
+		}
+		l, ok := logger.(interface{ SetLevel(mylog.Level) })
+		if !ok {
+			return errors.New("the logger has a fixed level")
+		}
+		l.SetLevel(level)
+		return nil
+	},
+	"log-sample": func(logger log.Logger, next *cli) error {
+		if next.LogSample < 0 {
+			return errors.New("invalid log sample: negative rate")
+		}
+		l, ok := logger.(interface{ SetSample(int) })
+		if !ok {
+			return errors.New("the logger does not sample")
+		}
+		l.SetSample(next.LogSample)
+		return nil
+	},
+	"transfer.concurrency": func(_ log.Logger, next *cli) error {
+		if CLI.Laptop {
+			return errors.New("the transfers are kept low by --laptop")
+		}
+		// 0 tunes the transfers by the bandwidth, which is probed only once at the start.
+		if next.Transfer.Concurrency <= 0 {
+			return fmt.Errorf("invalid transfer concurrency: %d. only a fixed number is applied without a restart", next.Transfer.Concurrency)
+		}
+		core.SetTransfers(next.Transfer.Concurrency)
+		return nil
+	},
+	"policy.max-size": reloadPolicy,
+	"policy.skip":     reloadPolicy,
+	"policy.only":     reloadPolicy,
+	"admission.min-size": func(_ log.Logger, next *cli) error {
+		if next.Admission.MinSize < 0 {
+			return errors.New("invalid admission: negative size")
+		}
+		admission.Set(next.Admission.MinSize << 20)
+		return nil
+	},
+}
+
+func reloadPolicy(_ log.Logger, next *cli) error {
+	pol, err := next.Policy.policy()
+	if err != nil {
+		return err
+	}
+	policy.Set(pol)
+
+	return nil
+}
+
+// watchConfig applies the changes of the configuration files to the running process until ctx is done,
+// and returns the configuration in effect, for the admin endpoint.
+// The flags in reloadableFlags are applied, and the others are logged to take effect on restart.
+func watchConfig(ctx context.Context, logger log.Logger, kctx *kong.Context) *config.Effective {
+	effective := config.NewEffective(kctx)
+
+	paths := config.Paths()
+	if CLI.Config != "" {
+		paths = append(paths, string(CLI.Config))
+	}
+	watcher := config.NewWatcher(paths)
+
+	// The changes are found against the last parse, as the process sets some flags itself, e.g. the default --dir.
+	_, prev, err := parseCLI()
+	if err != nil {
+		logger.Warnf("failed to parse the configuration: %v. the configuration files are not reloaded", err)
+		return effective
+	}
+
+	go watcher.Run(ctx, reloadInterval, func() {
+		next, values, err := parseCLI()
+		if err != nil {
+			logger.Warnf("failed to reload the configuration: %v. keeping the current one", err)
+			return
+		}
+
+		reloadChanges(logger, effective, prev, next, values)
+	})
+
+	return effective
+}
+
+// reloadChanges applies the flags changed from prev in values, parsed into next, and records them on effective.
+// prev is advanced only for the flags applied or marked pending, so that a failed reload is tried again at the next change of the files.
+func reloadChanges(logger log.Logger, effective *config.Effective, prev map[string]string, next *cli, values map[string]string) {
+	for _, name := range config.Changed(prev, values) {
+		reload, ok := reloadableFlags[name]
+		if !ok {
+			logger.Warnf("--%s was changed in the configuration files. it takes effect on restart", name)
+			effective.SetPending(name)
+		} else if err := reload(logger, next); err != nil {
+			logger.Warnf("failed to reload --%s: %v. keeping the current value", name, err)
+			continue
+		} else {
+			logger.Infof("reloaded --%s: %s", name, values[name])
+			effective.Set(name, values[name])
+		}
+
+		if value, ok := values[name]; ok {
+			prev[name] = value
+		} else {
+			delete(prev, name)
+		}
+	}
+}
+
+// parseCLI parses the configuration again, with the files as they are now.
+func parseCLI() (*cli, map[string]string, error) {
+	var next cli
+	parser, err := kong.New(&next, parserOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create parser: %w", err)
+	}
+
+	ctx, err := parser.Parse(os.Args[1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse: %w", err)
+	}
+
+	return &next, config.Values(ctx), nil
+}
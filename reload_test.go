@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mazrean/gocica/internal/config"
+	mylog "github.com/mazrean/gocica/internal/pkg/log"
+	"github.com/mazrean/gocica/log"
+)
+
+// parseTestCLI parses the arguments into c without the configuration files of the user or of the repository.
+func parseTestCLI(t *testing.T, c *cli, args ...string) *kong.Context {
+	t.Helper()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	parser, err := kong.New(c, parserOptions()...)
+	if err != nil {
+		t.Fatalf("kong.New() error = %v", err)
+	}
+	ctx, err := parser.Parse(args)
+	if err != nil {
+		t.Fatalf("Parse(%v) error = %v", args, err)
+	}
+
+	return ctx
+}
+
+func TestReloadChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		next        cli
+		values      map[string]string
+		wantPrev    map[string]string
+		wantValue   string
+		wantPending []string
+	}{
+		{
+			name:      "changed reloadable flag",
+			args:      []string{"--log-level", "info"},
+			next:      cli{LogLevel: "debug"},
+			values:    map[string]string{"log-level": "debug"},
+			wantPrev:  map[string]string{"log-level": "debug"},
+			wantValue: "debug",
+		},
+		{
+			name:        "changed flag applied on restart",
+			args:        []string{"--log-level", "info", "--dir", "/a"},
+			next:        cli{LogLevel: "info", Dir: "/b"},
+			values:      map[string]string{"log-level": "info", "dir": "/b"},
+			wantPrev:    map[string]string{"log-level": "info", "dir": "/b"},
+			wantValue:   "info",
+			wantPending: []string{"dir"},
+		},
+		{
+			name:      "failed reload",
+			args:      []string{"--log-level", "info"},
+			next:      cli{LogLevel: "verbose"},
+			values:    map[string]string{"log-level": "verbose"},
+			wantPrev:  map[string]string{"log-level": "info"},
+			wantValue: "info",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c cli
+			effective := config.NewEffective(parseTestCLI(t, &c, tt.args...))
+			prev := map[string]string{"log-level": c.LogLevel}
+			if c.Dir != "" {
+				prev["dir"] = c.Dir
+			}
+			logger := mylog.NewLoggerWithWriter(mylog.Info, io.Discard)
+
+			// A failed reload is tried again, and its flag is not marked as reloaded.
+			for range 2 {
+				reloadChanges(logger, effective, prev, &tt.next, tt.values)
+			}
+
+			if diff := cmp.Diff(tt.wantPrev, prev); diff != "" {
+				t.Errorf("prev mismatch (-want +got):\n%s", diff)
+			}
+			snapshot := effective.Snapshot()
+			if got := snapshot.Values["log-level"]; got != tt.wantValue {
+				t.Errorf("log-level in effect = %q, want %q", got, tt.wantValue)
+			}
+			if !slices.Equal(snapshot.PendingRestart, tt.wantPending) {
+				t.Errorf("pending restart = %v, want %v", snapshot.PendingRestart, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestReloadableFlags(t *testing.T) {
+	logger := mylog.NewLoggerWithWriter(mylog.Info, io.Discard)
+	// fixedLogger does not change its level or sampling.
+	fixedLogger := struct{ log.Logger }{Logger: logger}
+
+	tests := []struct {
+		name    string
+		flag    string
+		logger  log.Logger
+		next    cli
+		wantErr bool
+	}{
+		{name: "log level", flag: "log-level", logger: logger, next: cli{LogLevel: "debug"}},
+		{name: "unknown log level", flag: "log-level", logger: logger, next: cli{LogLevel: "verbose"}, wantErr: true},
+		{name: "fixed log level", flag: "log-level", logger: fixedLogger, next: cli{LogLevel: "debug"}, wantErr: true},
+		{name: "log sample", flag: "log-sample", logger: logger, next: cli{LogSample: 10}},
+		{name: "negative log sample", flag: "log-sample", logger: logger, next: cli{LogSample: -1}, wantErr: true},
+		{name: "transfer concurrency", flag: "transfer.concurrency", logger: logger, next: cli{Transfer: TransferFlag{Concurrency: 4}}},
+		{name: "tuned transfer concurrency", flag: "transfer.concurrency", logger: logger, next: cli{}, wantErr: true},
+		{name: "policy", flag: "policy.skip", logger: logger, next: cli{Policy: PolicyFlag{Skip: []string{"link"}}}},
+		{name: "unknown policy kind", flag: "policy.skip", logger: logger, next: cli{Policy: PolicyFlag{Skip: []string{"unknown"}}}, wantErr: true},
+		{name: "admission", flag: "admission.min-size", logger: logger, next: cli{Admission: AdmissionFlag{MinSize: 1}}},
+		{name: "negative admission", flag: "admission.min-size", logger: logger, next: cli{Admission: AdmissionFlag{MinSize: -1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reload, ok := reloadableFlags[tt.flag]
+			if !ok {
+				t.Fatalf("--%s is not reloadable. reloadable: %v", tt.flag, slices.Sorted(maps.Keys(reloadableFlags)))
+			}
+			if err := reload(tt.logger, &tt.next); (err != nil) != tt.wantErr {
+				t.Errorf("reload --%s error = %v, wantErr %v", tt.flag, err, tt.wantErr)
+			}
+		})
+	}
+}
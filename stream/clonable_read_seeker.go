@@ -1,12 +1,15 @@
-package io
+package stream
 
 import (
 	"bytes"
 	"io"
 )
 
+// ClonableReadSeeker is an io.ReadSeeker whose content can be read again from a clone,
+// independently of the position of the original.
 type ClonableReadSeeker interface {
 	io.ReadSeeker
+	// Clone returns a reader of the same content positioned at its start.
 	Clone() ClonableReadSeeker
 }
 
@@ -15,6 +18,7 @@ type clonableReadSeeker struct {
 	buf []byte
 }
 
+// NewClonableReadSeeker returns a ClonableReadSeeker of buf. The clones share buf, which must not be modified afterwards.
 func NewClonableReadSeeker(buf []byte) ClonableReadSeeker {
 	return &clonableReadSeeker{
 		br:  bytes.NewReader(buf),
@@ -0,0 +1,32 @@
+package stream
+
+import (
+	"io"
+	"testing"
+)
+
+func TestClonableReadSeeker(t *testing.T) {
+	t.Parallel()
+
+	r := NewClonableReadSeeker([]byte("gocica"))
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	clone, err := io.ReadAll(r.Clone())
+	if err != nil {
+		t.Fatalf("read clone: %v", err)
+	}
+	if string(clone) != "gocica" {
+		t.Errorf("clone: got %q, want the whole content", clone)
+	}
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read rest: %v", err)
+	}
+	if string(rest) != "cica" {
+		t.Errorf("original: got %q, want %q from where it was", rest, "cica")
+	}
+}
@@ -1,4 +1,4 @@
-package io
+package stream
 
 import (
 	"bytes"
@@ -6,6 +6,9 @@ import (
 	"io"
 )
 
+// DelimReader reads the segments of a stream separated by a delimiter, one at a time.
+// Read returns io.EOF at the end of the current segment, without the delimiter, and Next moves to the next one.
+// The empty segments at the start of a segment are skipped, e.g. blank lines between JSON lines.
 type DelimReader struct {
 	r         io.Reader
 	delim     byte
@@ -15,6 +18,7 @@ type DelimReader struct {
 	eof       bool
 }
 
+// NewDelimReader returns a DelimReader of r split at delim.
 func NewDelimReader(r io.Reader, delim byte) *DelimReader {
 	return &DelimReader{r: r, delim: delim, buf: make([]byte, 0, 1024), firstRead: true}
 }
@@ -76,6 +80,8 @@ func (d *DelimReader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// Next moves to the next segment. It returns io.EOF when the stream ended with the current one.
+// A stream ending with the delimiter has an empty last segment.
 func (d *DelimReader) Next() error {
 	if d.eof && len(d.buf) == 0 {
 		return io.EOF
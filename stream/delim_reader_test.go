@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// readSegments reads every segment of r.
+func readSegments(t *testing.T, r *DelimReader, oneByte bool) [][]byte {
+	t.Helper()
+
+	var segments [][]byte
+	for {
+		var reader io.Reader = r
+		if oneByte {
+			reader = iotest.OneByteReader(r)
+		}
+		segment, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("read segment: %v", err)
+		}
+		segments = append(segments, segment)
+
+		if err := r.Next(); errors.Is(err, io.EOF) {
+			return segments
+		} else if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+	}
+}
+
+// nonEmpty returns the segments with content.
+func nonEmpty(segments [][]byte) [][]byte {
+	var filtered [][]byte
+	for _, segment := range segments {
+		if len(segment) > 0 {
+			filtered = append(filtered, segment)
+		}
+	}
+
+	return filtered
+}
+
+func TestDelimReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "lines", input: "a\nbc\n", want: []string{"a", "bc", ""}},
+		{name: "no trailing delimiter", input: "a\nbc", want: []string{"a", "bc"}},
+		{name: "blank lines", input: "\n\na\n\nb", want: []string{"a", "b"}},
+		{name: "empty", input: "", want: []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			segments := readSegments(t, NewDelimReader(bytes.NewReader([]byte(tt.input)), '\n'), false)
+
+			got := make([]string, 0, len(segments))
+			for _, segment := range segments {
+				got = append(got, string(segment))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("segment %d: got %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func FuzzDelimReader(f *testing.F) {
+	f.Add([]byte("{\"ID\":1}\n{\"ID\":2}\n"), byte('\n'), false)
+	f.Add([]byte("\n\nab\n\ncd"), byte('\n'), true)
+	f.Add([]byte("a,b,,c,"), byte(','), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, delim byte, oneByte bool) {
+		var source io.Reader = bytes.NewReader(data)
+		if oneByte {
+			source = iotest.OneByteReader(source)
+		}
+
+		got := nonEmpty(readSegments(t, NewDelimReader(source, delim), oneByte))
+		want := nonEmpty(bytes.Split(data, []byte{delim}))
+		if len(got) != len(want) {
+			t.Fatalf("got %d segments %q, want %d %q", len(got), got, len(want), want)
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("segment %d: got %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+}
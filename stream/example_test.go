@@ -0,0 +1,77 @@
+package stream_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mazrean/gocica/stream"
+)
+
+func ExampleDelimReader() {
+	r := stream.NewDelimReader(strings.NewReader("{\"ID\":1}\n{\"ID\":2}\n"), '\n')
+	for {
+		line, err := io.ReadAll(r)
+		if err != nil {
+			panic(err)
+		}
+		if len(line) > 0 {
+			fmt.Println(string(line))
+		}
+
+		if err := r.Next(); errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	// Output:
+	// {"ID":1}
+	// {"ID":2}
+}
+
+func ExampleSkipCharReader() {
+	body, err := io.ReadAll(stream.NewSkipCharReader(strings.NewReader(`"aGVsbG8="`), '"'))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(body))
+	// Output: aGVsbG8=
+}
+
+// nopCloser is a writer of the example, closed by the JoinedWriter once full.
+type nopCloser struct {
+	strings.Builder
+	name string
+}
+
+func (w *nopCloser) Close() error {
+	fmt.Printf("%s closed with %q\n", w.name, w.String())
+	return nil
+}
+
+func ExampleJoinedWriter() {
+	first, second := &nopCloser{name: "first"}, &nopCloser{name: "second"}
+	w := stream.NewJoinedWriter(
+		stream.WriterWithSize{Writer: first, Size: 5},
+		stream.WriterWithSize{Writer: second, Size: 7},
+	)
+
+	if _, err := io.WriteString(w, "hello, world"); err != nil {
+		panic(err)
+	}
+	fmt.Printf("second holds %q\n", second.String())
+	// Output:
+	// first closed with "hello"
+	// second holds ", world"
+}
+
+func ExampleClonableReadSeeker() {
+	r := stream.NewClonableReadSeeker([]byte("output"))
+
+	// The clone is read, e.g. by an upload, while the original is read by another consumer.
+	clone := r.Clone()
+	a, _ := io.ReadAll(r)
+	b, _ := io.ReadAll(clone)
+	fmt.Println(string(a), string(b))
+	// Output: output output
+}
@@ -1,17 +1,22 @@
-package io
+package stream
 
 import "io"
 
+// WriterWithSize is a writer of a JoinedWriter and the number of bytes written to it.
 type WriterWithSize struct {
-	Writer io.WriteCloser // Changed from io.Writer to io.WriteCloser
+	Writer io.WriteCloser
 	Size   int64
 }
 
+// JoinedWriter writes a stream into consecutive writers, each getting the number of bytes of its Size.
+// A writer is closed once it is full and the stream goes on past it. The writers still open at the end are left to the caller.
+// The bytes past the last writer are dropped: Write then returns fewer bytes than given, without an error.
 type JoinedWriter struct {
 	writers   []WriterWithSize
 	curWriter int // current writer index
 }
 
+// NewJoinedWriter returns a JoinedWriter writing into writers in order.
 func NewJoinedWriter(writers ...WriterWithSize) *JoinedWriter {
 	return &JoinedWriter{
 		writers:   writers,
@@ -1,4 +1,4 @@
-package io
+package stream
 
 import (
 	"bytes"
@@ -182,3 +182,47 @@ func TestJoinedWriter(t *testing.T) {
 		})
 	}
 }
+
+func FuzzJoinedWriter(f *testing.F) {
+	f.Add([]byte("hello, world"), []byte{3, 0, 5, 4}, uint8(2))
+	f.Add([]byte("hello"), []byte{2}, uint8(1))
+
+	f.Fuzz(func(t *testing.T, data, sizes []byte, piece uint8) {
+		if piece == 0 {
+			piece = 1
+		}
+
+		buffers := make([]*bufferCloser, len(sizes))
+		writers := make([]WriterWithSize, len(sizes))
+		var capacity int
+		for i, size := range sizes {
+			buffers[i] = newBufferCloser()
+			writers[i] = WriterWithSize{Writer: buffers[i], Size: int64(size)}
+			capacity += int(size)
+		}
+
+		jw := NewJoinedWriter(writers...)
+		var written int
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), int(piece))
+			w, err := jw.Write(rest[:n])
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			written += w
+			rest = rest[n:]
+		}
+
+		if want := min(len(data), capacity); written != want {
+			t.Errorf("written: got %d, want %d", written, want)
+		}
+		rest := data
+		for i, size := range sizes {
+			want := rest[:min(len(rest), int(size))]
+			rest = rest[len(want):]
+			if got := buffers[i].Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("writer %d: got %q, want %q", i, got, want)
+			}
+		}
+	})
+}
@@ -1,4 +1,4 @@
-package io
+package stream
 
 import "io"
 
@@ -0,0 +1,30 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func FuzzSkipCharReader(f *testing.F) {
+	f.Add([]byte(`"aGVsbG8="`), byte('"'))
+	f.Add([]byte(""), byte('"'))
+	f.Add(bytes.Repeat([]byte{'x', '"'}, 2048), byte('"'))
+
+	f.Fuzz(func(t *testing.T, data []byte, skip byte) {
+		want := bytes.ReplaceAll(data, []byte{skip}, nil)
+
+		got, err := io.ReadAll(NewSkipCharReader(bytes.NewReader(data), skip))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := iotest.TestReader(NewSkipCharReader(iotest.HalfReader(bytes.NewReader(data)), skip), want); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,10 @@
+// Package stream provides the readers and writers gocica moves the bodies of the GOCACHEPROG protocol
+// and the outputs of the remote cache through, for wrapper tools and backends reusing them:
+//
+//   - ClonableReadSeeker, a body read more than once, e.g. by the local disk and the upload at the same time;
+//   - DelimReader, the segments of a stream split at a delimiter, e.g. the JSON lines of the protocol;
+//   - SkipCharReader, a stream without a character, e.g. the quotes around a base64 body;
+//   - JoinedWriter, one stream written into consecutive writers of fixed sizes, e.g. a range of a blob into its outputs.
+//
+// The API of this package is kept compatible within a major version of gocica.
+package stream
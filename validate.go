@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/fips140"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/admin"
+	"github.com/mazrean/gocica/internal/admission"
+	"github.com/mazrean/gocica/internal/cacheprog"
+	"github.com/mazrean/gocica/internal/config"
+	"github.com/mazrean/gocica/internal/local"
+	"github.com/mazrean/gocica/internal/policy"
+	"github.com/mazrean/gocica/internal/remote/core"
+	"github.com/mazrean/gocica/internal/remote/provider"
+)
+
+// configure validates the configuration and sets up the packages configured globally with it.
+// Every problem found is reported at once, with the flag, environment variable or file giving the bad value,
+// instead of failing on the first one a run.
+func configure(ctx *kong.Context) error {
+	v := config.NewValidation(ctx)
+
+	// The FIPS mode is selected when the process starts, so it can only be checked here.
+	if CLI.FIPS && !fips140.Enabled() {
+		v.Add("the FIPS 140-3 mode is required but not enabled. build with -tags fips or run with GODEBUG=fips140=on", "fips")
+	}
+	if CLI.Dir == "" {
+		v.Add("cache directory is not specified. please specify using the --dir flag, GOCICA_DIR or the dir key of gocica.yaml")
+	}
+
+	validateBackend(v)
+
+	// Backends linked in by their packages are configured here, as every subcommand may set up one.
+	provider.SetBackendConfig(CLI.Dir, CLI.BackendOptions)
+	core.SetHeaderCacheDir(filepath.Join(CLI.Dir, "headers"))
+	provider.SetSignedURLConfig(provider.SignedURLConfig{DownloadURL: CLI.Signed.DownloadURL, UploadURL: CLI.Signed.UploadURL})
+	if err := local.SetBackend(CLI.LocalBackend, CLI.BackendOptions); err != nil {
+		v.Check(fmt.Errorf("invalid local backend: %w", err), "local-backend", "backend-option")
+	}
+	local.SetRestoreMtime(CLI.RestoreMtime)
+
+	pol, err := CLI.Policy.policy()
+	v.Check(err, "policy.max-size", "policy.skip", "policy.only")
+	pins, err := CLI.Policy.pins()
+	v.Check(err, "policy.pin", "policy.pin-file")
+	expiry, err := CLI.Expiry.expiry()
+	v.Check(err, "expiry.default", "expiry.max")
+	downloadFilter, err := CLI.Download.filter()
+	v.Check(err, "download.max-object-size", "download.include", "download.exclude")
+	if CLI.Download.PendingWait < 0 {
+		v.Add("invalid download: negative pending wait", "download.pending-wait")
+	}
+	if CLI.Admission.MinSize < 0 {
+		v.Add("invalid admission: negative size", "admission.min-size")
+	}
+	if CLI.CloseTimeout < 0 {
+		v.Add("invalid close timeout: negative duration", "close-timeout")
+	}
+	if CLI.LogSample < 0 {
+		v.Add("invalid log sample: negative rate", "log-sample")
+	}
+	validateTransfer(v)
+	v.Check(provider.ValidateScope(CLI.Scope), "scope")
+	if CLI.Compress.ExecMinSize < 0 {
+		v.Add("invalid compress: negative size", "compress.exec-min-size")
+	}
+	// 0 is the level picked with the codec.
+	if CLI.Compress.Level < 0 || CLI.Compress.Level > 22 {
+		v.Add(fmt.Sprintf("invalid compress: level %d out of 1 to 22, or 0 for the default", CLI.Compress.Level), "compress.level")
+	}
+
+	if CLI.Coordination.JobTotal < 0 {
+		v.Add("invalid coordination: negative job total", "coordination.job-total")
+	} else if CLI.Coordination.JobTotal > 1 && (CLI.Coordination.JobIndex < 0 || CLI.Coordination.JobIndex >= CLI.Coordination.JobTotal) {
+		v.Add(fmt.Sprintf("invalid coordination: job index %d out of the %d jobs", CLI.Coordination.JobIndex, CLI.Coordination.JobTotal),
+			"coordination.job-index", "coordination.job-total")
+	}
+
+	if a := selectedAdmin(ctx); a != nil && a.Addr != "" {
+		v.Check(admin.CheckAddr(a.Addr), "admin-addr")
+	}
+
+	retry := CLI.Github.Retry
+	if retry.Attempts < 0 || retry.BaseBackoff < 0 || retry.MaxBackoff < 0 || retry.MaxWait < 0 {
+		v.Add("invalid github retry: negative value", "github.retry-attempts", "github.retry-base-backoff", "github.retry-max-backoff", "github.retry-max-wait")
+	}
+
+	if err := v.Err(); err != nil {
+		return err
+	}
+
+	policy.Set(pol)
+	policy.SetPins(pins)
+	policy.SetExpiry(expiry)
+	policy.SetDownload(downloadFilter)
+	cacheprog.SetPendingWait(CLI.Download.PendingWait)
+	if CLI.CloseTimeout > 0 && (CLI.CommitTimeout == 0 || CLI.CommitTimeout > CLI.CloseTimeout) {
+		// The commit has to be over before the close is cut short, or nothing of the run is committed.
+		CLI.CommitTimeout = CLI.CloseTimeout
+	}
+	cacheprog.SetCloseTimeout(CLI.CloseTimeout)
+	admission.Set(CLI.Admission.MinSize << 20)
+	CLI.Transfer.apply()
+
+	return nil
+}
+
+// validateBackend checks the remote backend and the URLs it is given.
+// Settings missing for a backend detected automatically are left to its initialization,
+// which runs without the remote cache unless --strict is given.
+func validateBackend(v *config.Validation) {
+	known := CLI.Backend == string(provider.BackendAuto) || slices.Contains(provider.BackendKinds(), provider.BackendKind(CLI.Backend))
+	if !known {
+		v.Add(fmt.Sprintf("unknown backend: %s. available: auto, %v", CLI.Backend, provider.BackendKinds()), "backend")
+	}
+
+	if command, ok := strings.CutPrefix(CLI.Remote, provider.ExecScheme); ok {
+		if strings.TrimSpace(command) == "" {
+			v.Add("invalid remote: empty plugin command", "remote")
+		}
+		if CLI.RemoteRead != "" {
+			v.Add("invalid remote read: a read replica is only supported by the http backend", "remote-read", "remote")
+		}
+	} else {
+		v.Check(urlProblem("remote", CLI.Remote), "remote")
+	}
+	v.Check(urlProblem("remote read", CLI.RemoteRead), "remote-read")
+	if CLI.RemoteRead != "" && CLI.Remote == "" {
+		v.Add("invalid remote read: a read replica requires --remote, which the commits are written to", "remote-read")
+	}
+
+	signed := CLI.Signed.DownloadURL != "" || CLI.Signed.UploadURL != ""
+	if CLI.Remote != "" && signed {
+		v.Add("the cache hub and signed URLs are mutually exclusive. give either --remote or --signed.*", "remote", "signed.download-url", "signed.upload-url")
+	}
+	v.Check(urlProblem("signed download URL", CLI.Signed.DownloadURL), "signed.download-url")
+	v.Check(urlProblem("signed upload URL", CLI.Signed.UploadURL), "signed.upload-url")
+	_, cacheURL := CLI.Github.cacheService()
+	v.Check(urlProblem("github cache URL", cacheURL), "github.cache-url")
+	v.Check(urlProblem("metrics pushgateway", CLI.Metrics.Pushgateway), "metrics.pushgateway")
+
+	// The settings of a backend chosen explicitly are required, so that a typo does not go unnoticed as a run without the cache.
+	switch provider.BackendKind(CLI.Backend) {
+	case provider.BackendHTTP:
+		if CLI.Remote == "" || strings.HasPrefix(CLI.Remote, provider.ExecScheme) {
+			v.Add("the http backend requires --remote with the URL of the cache hub", "backend", "remote")
+		}
+	case provider.BackendExec:
+		if !strings.HasPrefix(CLI.Remote, provider.ExecScheme) {
+			v.Add("the exec backend requires --remote exec:<command>", "backend", "remote")
+		}
+	case provider.BackendSigned:
+		if !signed {
+			v.Add("the signed backend requires --signed.download-url or --signed.upload-url", "backend")
+		}
+	}
+}
+
+// urlProblem returns the problem of the http(s) URL of the setting, or nil when it is valid or not given.
+func urlProblem(name, rawURL string) error {
+	if err := config.CheckURL(rawURL, "http", "https"); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// validateTransfer checks that the transfer options are not negative.
+func validateTransfer(v *config.Validation) {
+	negatives := map[string]bool{
+		"transfer.block-size":                CLI.Transfer.BlockSize < 0,
+		"transfer.concurrency":               CLI.Transfer.Concurrency < 0,
+		"transfer.azure-download-block-size": CLI.Transfer.AzureDownloadBlockSize < 0,
+		"transfer.azure-try-timeout":         CLI.Transfer.AzureTryTimeout < 0,
+		"transfer.sparse-after":              CLI.Transfer.SparseAfter < 0,
+	}
+
+	var flags []string
+	for name, negative := range negatives {
+		if negative {
+			flags = append(flags, name)
+		}
+	}
+	if len(flags) == 0 {
+		return
+	}
+	slices.Sort(flags)
+
+	v.Add("invalid transfer options: negative value", flags...)
+}
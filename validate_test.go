@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mazrean/gocica/internal/config"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantProblems []string
+	}{
+		{
+			name: "valid",
+			args: []string{"--dir", "/tmp/gocica"},
+		},
+		{
+			name:         "no cache directory",
+			args:         []string{},
+			wantProblems: []string{"cache directory is not specified"},
+		},
+		{
+			name:         "negative log sample",
+			args:         []string{"--dir", "/tmp/gocica", "--log-sample=-1"},
+			wantProblems: []string{"invalid log sample: negative rate (--log-sample on the command line)"},
+		},
+		{
+			name:         "unknown backend",
+			args:         []string{"--dir", "/tmp/gocica", "--backend", "unknown"},
+			wantProblems: []string{"unknown backend: unknown"},
+		},
+		{
+			name: "default compress level",
+			args: []string{"--dir", "/tmp/gocica", "--compress.level", "0"},
+		},
+		{
+			name: "lowest compress level",
+			args: []string{"--dir", "/tmp/gocica", "--compress.level", "1"},
+		},
+		{
+			name: "highest compress level",
+			args: []string{"--dir", "/tmp/gocica", "--compress.level", "22"},
+		},
+		{
+			name:         "negative compress level",
+			args:         []string{"--dir", "/tmp/gocica", "--compress.level=-1"},
+			wantProblems: []string{"invalid compress: level -1 out of 1 to 22, or 0 for the default"},
+		},
+		{
+			name:         "too high compress level",
+			args:         []string{"--dir", "/tmp/gocica", "--compress.level", "23"},
+			wantProblems: []string{"invalid compress: level 23 out of 1 to 22, or 0 for the default"},
+		},
+		{
+			name: "every problem at once",
+			args: []string{"--log-sample=-1", "--admission.min-size=-1"},
+			wantProblems: []string{
+				"cache directory is not specified",
+				"invalid admission: negative size",
+				"invalid log sample: negative rate",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevCLI := CLI
+			t.Cleanup(func() {
+				CLI = prevCLI
+			})
+			CLI = cli{}
+			ctx := parseTestCLI(t, &CLI, tt.args...)
+
+			err := configure(ctx)
+			if len(tt.wantProblems) == 0 {
+				if err != nil {
+					t.Errorf("configure() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var validationErr *config.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("configure() error = %v, want a *config.ValidationError", err)
+			}
+			if len(validationErr.Problems) != len(tt.wantProblems) {
+				t.Fatalf("configure() problems = %q, want %d problems", validationErr.Problems, len(tt.wantProblems))
+			}
+			for i, want := range tt.wantProblems {
+				if got := validationErr.Problems[i]; !strings.HasPrefix(got, want) {
+					t.Errorf("problem %d = %q, want it to start with %q", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/fips140"
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/alecthomas/kong"
+	"github.com/mazrean/gocica/internal/remote/provider"
+	"github.com/mazrean/gocica/protocol"
+)
+
+// BuildInfo is the machine-readable version information printed by --version --json
+type BuildInfo struct {
+	Version          string                 `json:"version"`
+	Revision         string                 `json:"revision"`
+	GoVersion        string                 `json:"go_version"`
+	OS               string                 `json:"os"`
+	Arch             string                 `json:"arch"`
+	Backends         []provider.BackendKind `json:"backends"`
+	ProtocolCommands []protocol.Cmd         `json:"protocol_commands"`
+	FIPS             bool                   `json:"fips"`
+}
+
+func buildInfo() *BuildInfo {
+	return &BuildInfo{
+		Version:          version,
+		Revision:         revision,
+		GoVersion:        runtime.Version(),
+		OS:               runtime.GOOS,
+		Arch:             runtime.GOARCH,
+		Backends:         provider.BackendKinds(),
+		ProtocolCommands: []protocol.Cmd{protocol.CmdGet, protocol.CmdPut, protocol.CmdClose},
+		FIPS:             fips140.Enabled(),
+	}
+}
+
+// VersionFlag prints the version and exits, as JSON when --json is also given
+type VersionFlag bool
+
+// BeforeApply runs after the other flags are parsed, so that --json is known.
+func (v VersionFlag) BeforeApply(app *kong.Kong, ctx *kong.Context, vars kong.Vars) error {
+	asJSON := false
+	for _, flag := range ctx.Flags() {
+		if flag.Name == "json" {
+			asJSON, _ = ctx.FlagValue(flag).(bool)
+		}
+	}
+
+	if asJSON {
+		buf, err := json.Marshal(buildInfo())
+		if err != nil {
+			return fmt.Errorf("encode build info: %w", err)
+		}
+		fmt.Fprintln(app.Stdout, string(buf))
+	} else {
+		fmt.Fprintln(app.Stdout, vars["version"])
+	}
+	app.Exit(0)
+
+	return nil
+}